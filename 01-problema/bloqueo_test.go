@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"problema-reservas/models"
+)
+
+// conSistemaNuevo sustituye el sistema global por uno de total asientos
+// durante la prueba
+func conSistemaNuevo(t *testing.T, total int) {
+	t.Helper()
+	previo := sistema
+	sistema = models.NewSistemaReservas("servidor-1", total)
+	t.Cleanup(func() { sistema = previo })
+}
+
+func estadoActual(t *testing.T) models.EstadoSistema {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	estadoHandler(rec, httptest.NewRequest(http.MethodGet, "/estado", nil))
+	var estado models.EstadoSistema
+	if err := json.NewDecoder(rec.Body).Decode(&estado); err != nil {
+		t.Fatal(err)
+	}
+	return estado
+}
+
+func TestBloquearAsientoImpideReservarHastaDesbloquear(t *testing.T) {
+	conAdminToken(t, "secreto")
+	conSistemaNuevo(t, 5)
+
+	rec := post(bloquearHandler, "/admin/bloquear", `{"numero":3,"motivo":"butaca rota"}`, "secreto")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 blocking seat 3, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = post(reservarHandler, "/reservar", `{"numero":3,"cliente":"ana"}`, "")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 reserving a blocked seat, got %d", rec.Code)
+	}
+	var resp httperr.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error.Code != CodeSeatOutOfOrder || resp.Error.Message != "El asiento está fuera de servicio: butaca rota" {
+		t.Fatalf("expected %s with the reason, got %+v", CodeSeatOutOfOrder, resp.Error)
+	}
+
+	// Se cuenta aparte: ni disponible ni reservado
+	estado := estadoActual(t)
+	if estado.Disponibles != 4 || estado.Reservados != 0 || estado.FueraDeServicio != 1 {
+		t.Fatalf("unexpected counts with seat 3 blocked: %+v", estado)
+	}
+	if asiento, _ := sistema.ObtenerAsiento(3); !asiento.Bloqueado || asiento.MotivoBloqueo != "butaca rota" {
+		t.Fatalf("seat 3 is not reported as blocked: %+v", asiento)
+	}
+
+	if rec := post(desbloquearHandler, "/admin/desbloquear", `{"numero":3}`, "secreto"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 unblocking seat 3, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := post(reservarHandler, "/reservar", `{"numero":3,"cliente":"ana"}`, ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 reserving the unblocked seat, got %d: %s", rec.Code, rec.Body)
+	}
+	if estado := estadoActual(t); estado.Reservados != 1 || estado.FueraDeServicio != 0 {
+		t.Fatalf("unexpected counts after reserving seat 3: %+v", estado)
+	}
+}
+
+func TestBloquearAsientoErrores(t *testing.T) {
+	conAdminToken(t, "secreto")
+	conSistemaNuevo(t, 5)
+	if err := sistema.ReservarAsientoPorAsiento(2, "ana"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		token   string
+		status  int
+		code    string
+	}{
+		{"no token", bloquearHandler, `{"numero":1}`, "", http.StatusUnauthorized, httperr.CodeUnauthorized},
+		{"reserved seat", bloquearHandler, `{"numero":2}`, "secreto", http.StatusConflict, CodeSeatTaken},
+		{"unknown seat", bloquearHandler, `{"numero":99}`, "secreto", http.StatusNotFound, CodeSeatNotFound},
+		{"not blocked", desbloquearHandler, `{"numero":1}`, "secreto", http.StatusConflict, CodeSeatNotBlocked},
+		{"missing number", desbloquearHandler, `{}`, "secreto", http.StatusBadRequest, httperr.CodeInvalidRequest},
+	}
+	for _, tc := range cases {
+		rec := post(tc.handler, "/admin", tc.body, tc.token)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, rec.Code)
+			continue
+		}
+		if code := codigoError(t, rec); code != tc.code {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.code, code)
+		}
+	}
+
+	if rec := post(bloquearHandler, "/admin/bloquear", `{"numero":1}`, "secreto"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	rec := post(bloquearHandler, "/admin/bloquear", `{"numero":1}`, "secreto")
+	if rec.Code != http.StatusConflict || codigoError(t, rec) != CodeSeatBlocked {
+		t.Fatalf("expected 409 %s blocking twice, got %d", CodeSeatBlocked, rec.Code)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+
+	"problema-reservas/models"
+)
+
+// Códigos de error de las reservas; los comunes están en shared/httperr
+const (
+	CodeSeatNotFound    = "SEAT_NOT_FOUND"
+	CodeSeatTaken       = "SEAT_TAKEN"
+	CodeSeatAlreadyFree = "SEAT_ALREADY_FREE"
+	CodeSeatExists      = "SEAT_ALREADY_EXISTS"
+	CodeSeatOutOfOrder  = "SEAT_OUT_OF_SERVICE"
+	CodeSeatBlocked     = "SEAT_ALREADY_BLOCKED"
+	CodeSeatNotBlocked  = "SEAT_NOT_BLOCKED"
+)
+
+// writeReservaError traduce un error del sistema de reservas a su código y
+// estado HTTP
+func writeReservaError(w http.ResponseWriter, err error) {
+	var reservaErr *models.ReservaError
+	if !errors.As(err, &reservaErr) {
+		httperr.Write(w, http.StatusInternalServerError, httperr.CodeInternal, err.Error())
+		return
+	}
+
+	switch reservaErr.Codigo {
+	case "ASIENTO_NO_EXISTE":
+		httperr.Write(w, http.StatusNotFound, CodeSeatNotFound, reservaErr.Mensaje)
+	case "ASIENTO_NO_DISPONIBLE":
+		httperr.Write(w, http.StatusConflict, CodeSeatTaken, reservaErr.Mensaje)
+	case "ASIENTO_YA_LIBRE":
+		httperr.Write(w, http.StatusConflict, CodeSeatAlreadyFree, reservaErr.Mensaje)
+	case "ASIENTO_YA_EXISTE":
+		httperr.Write(w, http.StatusConflict, CodeSeatExists, reservaErr.Mensaje)
+	case "ASIENTO_FUERA_DE_SERVICIO":
+		httperr.Write(w, http.StatusConflict, CodeSeatOutOfOrder, reservaErr.Mensaje)
+	case "ASIENTO_YA_BLOQUEADO":
+		httperr.Write(w, http.StatusConflict, CodeSeatBlocked, reservaErr.Mensaje)
+	case "ASIENTO_NO_BLOQUEADO":
+		httperr.Write(w, http.StatusConflict, CodeSeatNotBlocked, reservaErr.Mensaje)
+	default:
+		httperr.Write(w, http.StatusConflict, reservaErr.Codigo, reservaErr.Mensaje)
+	}
+}
@@ -1,3 +1,7 @@
 module problema-reservas
 
 go 1.21
+
+require github.com/sincronizacion-distribuida/shared v0.0.0
+
+replace github.com/sincronizacion-distribuida/shared => ../shared
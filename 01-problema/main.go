@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/sincronizacion-distribuida/shared/httperr"
 	"problema-reservas/models"
 )
 
@@ -15,6 +16,7 @@ var (
 	sistema    *models.SistemaReservas
 	servidorID string
 	puerto     string
+	adminToken string
 )
 
 func init() {
@@ -30,9 +32,12 @@ func init() {
 		puerto = "8080"
 	}
 
+	// Token para endpoints de administración (vacío = deshabilitados)
+	adminToken = os.Getenv("ADMIN_TOKEN")
+
 	// Inicializar sistema con 50 asientos
 	sistema = models.NewSistemaReservas(servidorID, 50)
-	
+
 	log.Printf("🚀 Servidor %s iniciado en puerto %s", servidorID, puerto)
 	log.Printf("⚠️  ADVERTENCIA: Este servidor tiene race conditions intencionalmente")
 }
@@ -47,11 +52,14 @@ func main() {
 	http.HandleFunc("/liberar", liberarHandler)
 	http.HandleFunc("/estado", estadoHandler)
 	http.HandleFunc("/reset", resetHandler)
+	http.HandleFunc("/simulate", simulateHandler)
+	http.HandleFunc("/admin/bloquear", bloquearHandler)
+	http.HandleFunc("/admin/desbloquear", desbloquearHandler)
 
 	// Configurar CORS para permitir requests desde el frontend
 	http.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
 		enableCORS(w)
-		
+
 		// Rutear a los handlers apropiados
 		switch r.URL.Path {
 		case "/api/asientos":
@@ -63,7 +71,7 @@ func main() {
 		case "/api/liberar":
 			liberarHandler(w, r)
 		default:
-			http.NotFound(w, r)
+			httperr.Write(w, http.StatusNotFound, httperr.CodeNotFound, "Ruta no encontrada: "+r.URL.Path)
 		}
 	})
 
@@ -77,8 +85,11 @@ func main() {
 	log.Printf("   POST /liberar       - Liberar un asiento")
 	log.Printf("   GET  /estado        - Estado del sistema")
 	log.Printf("   POST /reset         - Reiniciar sistema")
-	
-	if err := http.ListenAndServe(":"+puerto, nil); err != nil {
+	log.Printf("   POST /simulate      - Simular contención (admin)")
+	log.Printf("   POST /admin/bloquear    - Poner un asiento fuera de servicio (admin)")
+	log.Printf("   POST /admin/desbloquear - Volver a ponerlo en servicio (admin)")
+
+	if err := http.ListenAndServe(":"+puerto, httperr.WithRequestID(http.DefaultServeMux)); err != nil {
 		log.Fatal("❌ Error al iniciar servidor:", err)
 	}
 }
@@ -87,13 +98,27 @@ func main() {
 func enableCORS(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Admin-Token")
+}
+
+// requireAdmin comprueba el token de administración de la cabecera X-Admin-Token.
+// Si no es válido escribe la respuesta de error y devuelve false.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		httperr.Write(w, http.StatusForbidden, httperr.CodeAdminDisabled, "Endpoints de administración deshabilitados (ADMIN_TOKEN no definido)")
+		return false
+	}
+	if r.Header.Get("X-Admin-Token") != adminToken {
+		httperr.Write(w, http.StatusUnauthorized, httperr.CodeUnauthorized, "Token de administración inválido")
+		return false
+	}
+	return true
 }
 
 // homeHandler maneja la ruta raíz
 func homeHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	response := map[string]interface{}{
 		"servidor":    servidorID,
 		"mensaje":     "Sistema de Reservas - Problema con Race Conditions",
@@ -108,7 +133,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		},
 		"timestamp": time.Now(),
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -116,14 +141,14 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 // healthHandler verifica el estado del servidor
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	response := map[string]interface{}{
 		"status":    "ok",
 		"servidor":  servidorID,
 		"timestamp": time.Now(),
 		"uptime":    "activo",
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -131,25 +156,25 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 // asientosHandler devuelve todos los asientos
 func asientosHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	if r.Method == "OPTIONS" {
 		return
 	}
-	
+
 	if r.Method != "GET" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httperr.Write(w, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "Método no permitido")
 		return
 	}
-	
+
 	asientos := sistema.ObtenerTodosLosAsientos()
-	
+
 	response := map[string]interface{}{
 		"servidor":  servidorID,
 		"asientos":  asientos,
 		"total":     len(asientos),
 		"timestamp": time.Now(),
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -157,32 +182,32 @@ func asientosHandler(w http.ResponseWriter, r *http.Request) {
 // asientoHandler devuelve información de un asiento específico
 func asientoHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	if r.Method != "GET" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httperr.Write(w, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "Método no permitido")
 		return
 	}
-	
+
 	// Extraer número de asiento de la URL
 	numeroStr := r.URL.Path[len("/asiento/"):]
 	numero, err := strconv.Atoi(numeroStr)
 	if err != nil {
-		http.Error(w, "Número de asiento inválido", http.StatusBadRequest)
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Número de asiento inválido")
 		return
 	}
-	
+
 	asiento, err := sistema.ObtenerAsiento(numero)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeReservaError(w, err)
 		return
 	}
-	
+
 	response := map[string]interface{}{
 		"servidor":  servidorID,
 		"asiento":   asiento,
 		"timestamp": time.Now(),
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -196,54 +221,44 @@ type ReservaRequest struct {
 // reservarHandler maneja las reservas de asientos
 func reservarHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	if r.Method == "OPTIONS" {
 		return
 	}
-	
+
 	if r.Method != "POST" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httperr.Write(w, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "Método no permitido")
 		return
 	}
-	
+
 	var req ReservaRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		httperr.WriteDecodeError(w, err)
 		return
 	}
-	
+
 	// Validar datos
 	if req.Numero <= 0 || req.Cliente == "" {
-		http.Error(w, "Número de asiento y cliente son requeridos", http.StatusBadRequest)
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Número de asiento y cliente son requeridos")
 		return
 	}
-	
+
 	// Log de la solicitud
 	log.Printf("🎫 [%s] Intentando reservar asiento %d para %s", servidorID, req.Numero, req.Cliente)
-	
+
 	// AQUÍ ESTÁ EL PROBLEMA: Race condition
 	err := sistema.ReservarAsiento(req.Numero, req.Cliente)
 	if err != nil {
 		log.Printf("❌ [%s] Error al reservar asiento %d: %s", servidorID, req.Numero, err.Error())
-		
-		response := map[string]interface{}{
-			"success":   false,
-			"error":     err.Error(),
-			"servidor":  servidorID,
-			"timestamp": time.Now(),
-		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(response)
+		writeReservaError(w, err)
 		return
 	}
-	
+
 	log.Printf("✅ [%s] Asiento %d reservado exitosamente para %s", servidorID, req.Numero, req.Cliente)
-	
+
 	// Obtener asiento actualizado
 	asiento, _ := sistema.ObtenerAsiento(req.Numero)
-	
+
 	response := map[string]interface{}{
 		"success":   true,
 		"message":   "Asiento reservado exitosamente",
@@ -251,7 +266,7 @@ func reservarHandler(w http.ResponseWriter, r *http.Request) {
 		"servidor":  servidorID,
 		"timestamp": time.Now(),
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -264,55 +279,45 @@ type LiberarRequest struct {
 // liberarHandler maneja la liberación de asientos
 func liberarHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	if r.Method == "OPTIONS" {
 		return
 	}
-	
+
 	if r.Method != "POST" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httperr.Write(w, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "Método no permitido")
 		return
 	}
-	
+
 	var req LiberarRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		httperr.WriteDecodeError(w, err)
 		return
 	}
-	
+
 	if req.Numero <= 0 {
-		http.Error(w, "Número de asiento requerido", http.StatusBadRequest)
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Número de asiento requerido")
 		return
 	}
-	
+
 	log.Printf("🔓 [%s] Liberando asiento %d", servidorID, req.Numero)
-	
+
 	err := sistema.LiberarAsiento(req.Numero)
 	if err != nil {
 		log.Printf("❌ [%s] Error al liberar asiento %d: %s", servidorID, req.Numero, err.Error())
-		
-		response := map[string]interface{}{
-			"success":   false,
-			"error":     err.Error(),
-			"servidor":  servidorID,
-			"timestamp": time.Now(),
-		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(response)
+		writeReservaError(w, err)
 		return
 	}
-	
+
 	log.Printf("✅ [%s] Asiento %d liberado exitosamente", servidorID, req.Numero)
-	
+
 	response := map[string]interface{}{
 		"success":   true,
 		"message":   "Asiento liberado exitosamente",
 		"servidor":  servidorID,
 		"timestamp": time.Now(),
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -320,18 +325,18 @@ func liberarHandler(w http.ResponseWriter, r *http.Request) {
 // estadoHandler devuelve el estado del sistema
 func estadoHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	if r.Method == "OPTIONS" {
 		return
 	}
-	
+
 	if r.Method != "GET" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httperr.Write(w, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "Método no permitido")
 		return
 	}
-	
+
 	estado := sistema.ObtenerEstado()
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(estado)
 }
@@ -339,30 +344,161 @@ func estadoHandler(w http.ResponseWriter, r *http.Request) {
 // resetHandler reinicia el sistema
 func resetHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	if r.Method == "OPTIONS" {
 		return
 	}
-	
+
 	if r.Method != "POST" {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		httperr.Write(w, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "Método no permitido")
 		return
 	}
-	
+
 	log.Printf("🔄 [%s] Reiniciando sistema...", servidorID)
-	
+
 	// Reinicializar sistema
 	sistema = models.NewSistemaReservas(servidorID, 50)
-	
+
 	log.Printf("✅ [%s] Sistema reiniciado", servidorID)
-	
+
 	response := map[string]interface{}{
 		"success":   true,
 		"message":   "Sistema reiniciado exitosamente",
 		"servidor":  servidorID,
 		"timestamp": time.Now(),
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+// simulateHandler ejecuta un escenario de contención sobre un sistema aislado
+func simulateHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		httperr.Write(w, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "Método no permitido")
+		return
+	}
+
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var cfg models.ConfigSimulacion
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	log.Printf("🧪 [%s] Simulando %d clientes sobre el asiento %d (modo %s)", servidorID, cfg.NumClientes, cfg.AsientoMeta, cfg.Modo)
+
+	resultado, err := models.Simular(servidorID, 50, cfg)
+	if err != nil {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	log.Printf("🧪 [%s] Simulación terminada: %d ganadores de %d clientes", servidorID, resultado.Exitos, resultado.Clientes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resultado)
+}
+
+// BloqueoRequest representa una solicitud de bloqueo o desbloqueo de un asiento
+type BloqueoRequest struct {
+	Numero int    `json:"numero"`
+	Motivo string `json:"motivo,omitempty"`
+}
+
+// decodeBloqueo valida el método, el token y el cuerpo de /admin/bloquear y
+// /admin/desbloquear. Si algo falla escribe el error y devuelve false.
+func decodeBloqueo(w http.ResponseWriter, r *http.Request, req *BloqueoRequest) bool {
+	if r.Method != "POST" {
+		httperr.Write(w, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "Método no permitido")
+		return false
+	}
+
+	if !requireAdmin(w, r) {
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return false
+	}
+
+	if req.Numero <= 0 {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Número de asiento requerido")
+		return false
+	}
+	return true
+}
+
+// bloquearHandler pone un asiento fuera de servicio (p. ej. una butaca rota)
+func bloquearHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	var req BloqueoRequest
+	if !decodeBloqueo(w, r, &req) {
+		return
+	}
+
+	if err := sistema.BloquearAsiento(req.Numero, req.Motivo); err != nil {
+		log.Printf("❌ [%s] Error al bloquear asiento %d: %s", servidorID, req.Numero, err.Error())
+		writeReservaError(w, err)
+		return
+	}
+
+	log.Printf("🚧 [%s] Asiento %d fuera de servicio: %s", servidorID, req.Numero, req.Motivo)
+
+	asiento, _ := sistema.ObtenerAsiento(req.Numero)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "Asiento fuera de servicio",
+		"asiento":   asiento,
+		"servidor":  servidorID,
+		"timestamp": time.Now(),
+	})
+}
+
+// desbloquearHandler vuelve a poner en servicio un asiento bloqueado
+func desbloquearHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	var req BloqueoRequest
+	if !decodeBloqueo(w, r, &req) {
+		return
+	}
+
+	if err := sistema.DesbloquearAsiento(req.Numero); err != nil {
+		log.Printf("❌ [%s] Error al desbloquear asiento %d: %s", servidorID, req.Numero, err.Error())
+		writeReservaError(w, err)
+		return
+	}
+
+	log.Printf("✅ [%s] Asiento %d de nuevo en servicio", servidorID, req.Numero)
+
+	asiento, _ := sistema.ObtenerAsiento(req.Numero)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "Asiento de nuevo en servicio",
+		"asiento":   asiento,
+		"servidor":  servidorID,
+		"timestamp": time.Now(),
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"problema-reservas/models"
+)
+
+// conAdminToken fija el token de administración durante la prueba
+func conAdminToken(t *testing.T, token string) {
+	t.Helper()
+	previo := adminToken
+	adminToken = token
+	t.Cleanup(func() { adminToken = previo })
+}
+
+// post envía un POST con cuerpo JSON y, si token no está vacío, X-Admin-Token
+func post(handler http.HandlerFunc, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("X-Admin-Token", token)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// codigoError devuelve el código del sobre de error de la respuesta
+func codigoError(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp httperr.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+	return resp.Error.Code
+}
+
+func TestSimulateHandler(t *testing.T) {
+	conAdminToken(t, "secreto")
+	body := `{"numClients":5,"targetSeat":3,"modo":"mutex"}`
+
+	if rec := post(simulateHandler, "/simulate", body, ""); rec.Code != http.StatusUnauthorized || codigoError(t, rec) != httperr.CodeUnauthorized {
+		t.Fatalf("expected 401 without the admin token, got %d", rec.Code)
+	}
+
+	rec := post(simulateHandler, "/simulate", body, "secreto")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resultado models.ResultadoSimulacion
+	if err := json.NewDecoder(rec.Body).Decode(&resultado); err != nil {
+		t.Fatal(err)
+	}
+	if resultado.Exitos != 1 || resultado.Clientes != 5 || resultado.Asiento != 3 {
+		t.Fatalf("unexpected simulation result: %+v", resultado)
+	}
+	// La simulación no toca los asientos del servidor
+	if asiento, err := sistema.ObtenerAsiento(3); err != nil || !asiento.Disponible {
+		t.Fatalf("simulation changed the server's seat 3: %+v, %v", asiento, err)
+	}
+
+	rec = post(simulateHandler, "/simulate", `{"numClients":0,"targetSeat":3}`, "secreto")
+	if rec.Code != http.StatusBadRequest || codigoError(t, rec) != httperr.CodeInvalidRequest {
+		t.Fatalf("expected 400 %s for an invalid scenario, got %d", httperr.CodeInvalidRequest, rec.Code)
+	}
+}
+
+func TestSimulateHandlerDisabledWithoutToken(t *testing.T) {
+	conAdminToken(t, "")
+	rec := post(simulateHandler, "/simulate", `{"numClients":1,"targetSeat":1}`, "x")
+	if rec.Code != http.StatusForbidden || codigoError(t, rec) != httperr.CodeAdminDisabled {
+		t.Fatalf("expected 403 %s, got %d", httperr.CodeAdminDisabled, rec.Code)
+	}
+}
+
+// Un cuerpo vacío, un JSON mal formado y un campo con otro tipo se
+// distinguen por su código, como en los otros servidores
+func TestReservarYLiberarDecodeErrors(t *testing.T) {
+	for _, handler := range []struct {
+		name string
+		fn   http.HandlerFunc
+	}{
+		{"reservar", reservarHandler},
+		{"liberar", liberarHandler},
+	} {
+		for _, tc := range []struct {
+			name, body, code string
+		}{
+			{"empty body", "", httperr.CodeEmptyBody},
+			{"truncated", `{"numero":1`, httperr.CodeInvalidJSON},
+			{"syntax error", `{"numero":}`, httperr.CodeInvalidJSON},
+			{"wrong type", `{"numero":"uno","cliente":"ana"}`, httperr.CodeInvalidFieldType},
+		} {
+			rec := post(handler.fn, "/"+handler.name, tc.body, "")
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("%s %s: expected 400, got %d", handler.name, tc.name, rec.Code)
+			}
+			if code := codigoError(t, rec); code != tc.code {
+				t.Fatalf("%s %s: expected %s, got %s", handler.name, tc.name, tc.code, code)
+			}
+		}
+	}
+}
@@ -1,16 +1,37 @@
 package models
 
 import (
+	"sync"
 	"time"
+
+	compartido "github.com/sincronizacion-distribuida/shared/models"
 )
 
-// Asiento representa un asiento en el sistema de reservas
+// Asiento representa un asiento en el sistema de reservas. Número,
+// disponibilidad y cliente son los del modelo compartido con los servidores
+// con lock; el resto solo existe en esta versión en memoria.
 type Asiento struct {
-	Numero      int       `json:"numero"`
-	Disponible  bool      `json:"disponible"`
-	Cliente     string    `json:"cliente,omitempty"`
+	compartido.AsientoEstado
 	FechaReserva *time.Time `json:"fecha_reserva,omitempty"`
-	ServidorID  string    `json:"servidor_id"`
+	ServidorID   string     `json:"servidor_id"`
+	// Bloqueado marca un asiento fuera de servicio (p. ej. una butaca rota):
+	// no está reservado, pero no se puede reservar
+	Bloqueado     bool   `json:"bloqueado"`
+	MotivoBloqueo string `json:"motivo_bloqueo,omitempty"`
+
+	// mu protege los campos del asiento en ReservarAsientoPorAsiento
+	mu sync.Mutex
+}
+
+// copia devuelve una copia de los datos del asiento, sin su mutex
+func (a *Asiento) copia() *Asiento {
+	return &Asiento{
+		AsientoEstado: a.AsientoEstado,
+		FechaReserva:  a.FechaReserva,
+		ServidorID:    a.ServidorID,
+		Bloqueado:     a.Bloqueado,
+		MotivoBloqueo: a.MotivoBloqueo,
+	}
 }
 
 // SistemaReservas maneja el estado de los asientos
@@ -18,23 +39,27 @@ type Asiento struct {
 type SistemaReservas struct {
 	Asientos   map[int]*Asiento `json:"asientos"`
 	ServidorID string           `json:"servidor_id"`
-	// NO usamos mutex aquí para demostrar el problema
-	// mutex      sync.RWMutex
+	// ReservarAsiento NO usa este mutex para demostrar el problema;
+	// solo lo usa ReservarAsientoSeguro como comparación
+	mutex sync.Mutex
+	// asientosMu protege el mapa (altas y bajas de asientos). Lo toman todas
+	// las operaciones salvo ReservarAsiento, que lo lee sin protección a
+	// propósito; el estado de cada asiento lo protege su propio mutex
+	asientosMu sync.RWMutex
 }
 
 // NewSistemaReservas crea un nuevo sistema de reservas
 func NewSistemaReservas(servidorID string, totalAsientos int) *SistemaReservas {
 	asientos := make(map[int]*Asiento)
-	
+
 	// Inicializar asientos disponibles
 	for i := 1; i <= totalAsientos; i++ {
 		asientos[i] = &Asiento{
-			Numero:     i,
-			Disponible: true,
-			ServidorID: servidorID,
+			AsientoEstado: compartido.AsientoEstado{Numero: i, Disponible: true},
+			ServidorID:    servidorID,
 		}
 	}
-	
+
 	return &SistemaReservas{
 		Asientos:   asientos,
 		ServidorID: servidorID,
@@ -52,30 +77,131 @@ func (s *SistemaReservas) ReservarAsiento(numero int, cliente string) error {
 			Mensaje: "El asiento no existe",
 		}
 	}
-	
+
+	if asiento.Bloqueado {
+		return errFueraDeServicio(asiento)
+	}
+
 	// RACE CONDITION: Check-then-act sin sincronización
 	if asiento.Disponible {
 		// Simular latencia de red/procesamiento
 		time.Sleep(100 * time.Millisecond)
-		
+
 		// Cambiar estado del asiento
 		now := time.Now()
 		asiento.Disponible = false
 		asiento.Cliente = cliente
 		asiento.FechaReserva = &now
 		asiento.ServidorID = s.ServidorID
-		
+
 		return nil
 	}
-	
+
 	return &ReservaError{
 		Codigo:  "ASIENTO_NO_DISPONIBLE",
 		Mensaje: "El asiento ya está reservado",
 	}
 }
 
+// ReservarAsientoSeguro hace lo mismo que ReservarAsiento pero protege el
+// check-then-act con un mutex global, de modo que solo un cliente gana. El
+// mapa se toma en modo lectura para que AgregarAsiento y EliminarAsiento no
+// lo modifiquen mientras ReservarAsiento lo lee.
+func (s *SistemaReservas) ReservarAsientoSeguro(numero int, cliente string) error {
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ReservarAsiento(numero, cliente)
+}
+
+// ReservarAsientoPorAsiento protege el check-then-act con el mutex del propio
+// asiento: dos clientes que compiten por el mismo asiento se excluyen, pero
+// las reservas de asientos distintos avanzan en paralelo.
+//
+// El mapa también necesita protección, porque AgregarAsiento y
+// EliminarAsiento lo modifican. Se toma en modo lectura durante toda la
+// reserva (no solo al buscar el asiento): así nadie puede eliminar el asiento
+// mientras se reserva, y las lecturas concurrentes no se bloquean entre sí.
+func (s *SistemaReservas) ReservarAsientoPorAsiento(numero int, cliente string) error {
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+
+	asiento, existe := s.Asientos[numero]
+	if !existe {
+		return &ReservaError{
+			Codigo:  "ASIENTO_NO_EXISTE",
+			Mensaje: "El asiento no existe",
+		}
+	}
+
+	asiento.mu.Lock()
+	defer asiento.mu.Unlock()
+
+	if asiento.Bloqueado {
+		return errFueraDeServicio(asiento)
+	}
+
+	if !asiento.Disponible {
+		return &ReservaError{
+			Codigo:  "ASIENTO_NO_DISPONIBLE",
+			Mensaje: "El asiento ya está reservado",
+		}
+	}
+
+	// Misma latencia simulada que ReservarAsiento, pero ahora dentro del lock
+	time.Sleep(100 * time.Millisecond)
+
+	now := time.Now()
+	asiento.Disponible = false
+	asiento.Cliente = cliente
+	asiento.FechaReserva = &now
+	asiento.ServidorID = s.ServidorID
+
+	return nil
+}
+
+// AgregarAsiento añade un asiento libre al sistema
+func (s *SistemaReservas) AgregarAsiento(numero int) error {
+	s.asientosMu.Lock()
+	defer s.asientosMu.Unlock()
+
+	if _, existe := s.Asientos[numero]; existe {
+		return &ReservaError{
+			Codigo:  "ASIENTO_YA_EXISTE",
+			Mensaje: "El asiento ya existe",
+		}
+	}
+
+	s.Asientos[numero] = &Asiento{
+		AsientoEstado: compartido.AsientoEstado{Numero: numero, Disponible: true},
+		ServidorID:    s.ServidorID,
+	}
+	return nil
+}
+
+// EliminarAsiento quita un asiento del sistema. Al tomar el mapa en modo
+// escritura espera a que terminen las reservas en curso.
+func (s *SistemaReservas) EliminarAsiento(numero int) error {
+	s.asientosMu.Lock()
+	defer s.asientosMu.Unlock()
+
+	if _, existe := s.Asientos[numero]; !existe {
+		return &ReservaError{
+			Codigo:  "ASIENTO_NO_EXISTE",
+			Mensaje: "El asiento no existe",
+		}
+	}
+
+	delete(s.Asientos, numero)
+	return nil
+}
+
 // LiberarAsiento libera un asiento reservado
 func (s *SistemaReservas) LiberarAsiento(numero int) error {
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+
 	asiento, existe := s.Asientos[numero]
 	if !existe {
 		return &ReservaError{
@@ -83,24 +209,31 @@ func (s *SistemaReservas) LiberarAsiento(numero int) error {
 			Mensaje: "El asiento no existe",
 		}
 	}
-	
+
+	if asiento.Bloqueado {
+		return errFueraDeServicio(asiento)
+	}
+
 	if asiento.Disponible {
 		return &ReservaError{
 			Codigo:  "ASIENTO_YA_LIBRE",
 			Mensaje: "El asiento ya está libre",
 		}
 	}
-	
+
 	// Liberar asiento
 	asiento.Disponible = true
 	asiento.Cliente = ""
 	asiento.FechaReserva = nil
-	
+
 	return nil
 }
 
 // ObtenerAsiento devuelve información de un asiento específico
 func (s *SistemaReservas) ObtenerAsiento(numero int) (*Asiento, error) {
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+
 	asiento, existe := s.Asientos[numero]
 	if !existe {
 		return nil, &ReservaError{
@@ -108,39 +241,49 @@ func (s *SistemaReservas) ObtenerAsiento(numero int) (*Asiento, error) {
 			Mensaje: "El asiento no existe",
 		}
 	}
-	
+
 	// Crear copia para evitar modificaciones externas
-	copia := *asiento
-	return &copia, nil
+	return asiento.copia(), nil
 }
 
 // ObtenerTodosLosAsientos devuelve todos los asientos
 func (s *SistemaReservas) ObtenerTodosLosAsientos() map[int]*Asiento {
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+
 	// Crear copia del mapa para evitar modificaciones externas
 	copia := make(map[int]*Asiento)
 	for numero, asiento := range s.Asientos {
-		asientoCopia := *asiento
-		copia[numero] = &asientoCopia
+		copia[numero] = asiento.copia()
 	}
 	return copia
 }
 
 // ContarDisponibles cuenta los asientos disponibles
 func (s *SistemaReservas) ContarDisponibles() int {
-	contador := 0
-	for _, asiento := range s.Asientos {
-		if asiento.Disponible {
-			contador++
-		}
-	}
-	return contador
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+	return s.contar(disponible)
 }
 
-// ContarReservados cuenta los asientos reservados
+// ContarReservados cuenta los asientos reservados por clientes, sin los que
+// están fuera de servicio
 func (s *SistemaReservas) ContarReservados() int {
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+	return s.contar(reservado)
+}
+
+func disponible(asiento *Asiento) bool { return asiento.Disponible }
+func reservado(asiento *Asiento) bool  { return !asiento.Disponible && !asiento.Bloqueado }
+func bloqueado(asiento *Asiento) bool  { return asiento.Bloqueado }
+
+// contar cuenta los asientos que cumplen cond. Debe llamarse con asientosMu
+// tomado.
+func (s *SistemaReservas) contar(cond func(*Asiento) bool) int {
 	contador := 0
 	for _, asiento := range s.Asientos {
-		if !asiento.Disponible {
+		if cond(asiento) {
 			contador++
 		}
 	}
@@ -159,20 +302,26 @@ func (e *ReservaError) Error() string {
 
 // EstadoSistema devuelve el estado actual del sistema
 type EstadoSistema struct {
-	ServidorID       string `json:"servidor_id"`
-	TotalAsientos    int    `json:"total_asientos"`
-	Disponibles      int    `json:"disponibles"`
-	Reservados       int    `json:"reservados"`
+	ServidorID          string    `json:"servidor_id"`
+	TotalAsientos       int       `json:"total_asientos"`
+	Disponibles         int       `json:"disponibles"`
+	Reservados          int       `json:"reservados"`
+	FueraDeServicio     int       `json:"fuera_de_servicio"`
 	UltimaActualizacion time.Time `json:"ultima_actualizacion"`
 }
 
 // ObtenerEstado devuelve el estado actual del sistema
 func (s *SistemaReservas) ObtenerEstado() *EstadoSistema {
+	// Un solo RLock para todos los recuentos: tomarlo otra vez dentro de
+	// ContarX podría bloquearse si entre medias espera un AgregarAsiento
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
 	return &EstadoSistema{
-		ServidorID:       s.ServidorID,
-		TotalAsientos:    len(s.Asientos),
-		Disponibles:      s.ContarDisponibles(),
-		Reservados:       s.ContarReservados(),
+		ServidorID:          s.ServidorID,
+		TotalAsientos:       len(s.Asientos),
+		Disponibles:         s.contar(disponible),
+		Reservados:          s.contar(reservado),
+		FueraDeServicio:     s.contar(bloqueado),
 		UltimaActualizacion: time.Now(),
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	compartido "github.com/sincronizacion-distribuida/shared/models"
+)
+
+// El Asiento de 01-problema toma número, disponibilidad y cliente del modelo
+// compartido, pero su JSON tiene que seguir siendo el de antes, con
+// "servidor_id" y "fecha_reserva"
+func TestAsientoWireFormat(t *testing.T) {
+	fecha := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name    string
+		asiento *Asiento
+		want    string
+	}{
+		{
+			name: "reservado",
+			asiento: &Asiento{
+				AsientoEstado: compartido.AsientoEstado{Numero: 7, Cliente: "ana"},
+				FechaReserva:  &fecha,
+				ServidorID:    "servidor-1",
+			},
+			want: `{"numero":7,"disponible":false,"cliente":"ana","fecha_reserva":"2024-01-02T03:04:05Z","servidor_id":"servidor-1","bloqueado":false}`,
+		},
+		{
+			name: "libre",
+			asiento: &Asiento{
+				AsientoEstado: compartido.AsientoEstado{Numero: 3, Disponible: true},
+				ServidorID:    "servidor-2",
+			},
+			want: `{"numero":3,"disponible":true,"servidor_id":"servidor-2","bloqueado":false}`,
+		},
+		{
+			name: "fuera de servicio",
+			asiento: &Asiento{
+				AsientoEstado: compartido.AsientoEstado{Numero: 4},
+				ServidorID:    "servidor-1",
+				Bloqueado:     true,
+				MotivoBloqueo: "butaca rota",
+			},
+			want: `{"numero":4,"disponible":false,"servidor_id":"servidor-1","bloqueado":true,"motivo_bloqueo":"butaca rota"}`,
+		},
+	} {
+		encoded, err := json.Marshal(tc.asiento)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != tc.want {
+			t.Errorf("%s: JSON changed:\n got  %s\n want %s", tc.name, encoded, tc.want)
+		}
+
+		var decoded Asiento
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.copia().AsientoEstado != tc.asiento.AsientoEstado || decoded.ServidorID != tc.asiento.ServidorID {
+			t.Errorf("%s: JSON round trip gave %+v", tc.name, decoded.copia())
+		}
+	}
+}
+
+// Las copias que devuelve el sistema llevan los campos compartidos
+func TestObtenerAsientoCopiesSharedFields(t *testing.T) {
+	s := NewSistemaReservas("servidor-1", 3)
+	if err := s.ReservarAsiento(2, "ana"); err != nil {
+		t.Fatal(err)
+	}
+	asiento, err := s.ObtenerAsiento(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asiento.Numero != 2 || asiento.Disponible || asiento.Cliente != "ana" || asiento.ServidorID != "servidor-1" {
+		t.Errorf("copy of seat 2 is %+v", asiento)
+	}
+}
@@ -0,0 +1,88 @@
+package models
+
+import "fmt"
+
+// errFueraDeServicio es el error de reservar o liberar un asiento bloqueado
+func errFueraDeServicio(asiento *Asiento) error {
+	mensaje := "El asiento está fuera de servicio"
+	if asiento.MotivoBloqueo != "" {
+		mensaje = fmt.Sprintf("El asiento está fuera de servicio: %s", asiento.MotivoBloqueo)
+	}
+	return &ReservaError{
+		Codigo:  "ASIENTO_FUERA_DE_SERVICIO",
+		Mensaje: mensaje,
+	}
+}
+
+// BloquearAsiento deja un asiento libre fuera de servicio. Un asiento
+// reservado no se puede bloquear: antes hay que liberarlo.
+func (s *SistemaReservas) BloquearAsiento(numero int, motivo string) error {
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+
+	asiento, existe := s.Asientos[numero]
+	if !existe {
+		return &ReservaError{
+			Codigo:  "ASIENTO_NO_EXISTE",
+			Mensaje: "El asiento no existe",
+		}
+	}
+
+	asiento.mu.Lock()
+	defer asiento.mu.Unlock()
+
+	if asiento.Bloqueado {
+		return &ReservaError{
+			Codigo:  "ASIENTO_YA_BLOQUEADO",
+			Mensaje: "El asiento ya está fuera de servicio",
+		}
+	}
+	if !asiento.Disponible {
+		return &ReservaError{
+			Codigo:  "ASIENTO_NO_DISPONIBLE",
+			Mensaje: "El asiento está reservado; libérelo antes de bloquearlo",
+		}
+	}
+
+	// Deja de estar disponible para que ningún listado lo ofrezca
+	asiento.Disponible = false
+	asiento.Bloqueado = true
+	asiento.MotivoBloqueo = motivo
+	return nil
+}
+
+// DesbloquearAsiento vuelve a poner en servicio un asiento bloqueado
+func (s *SistemaReservas) DesbloquearAsiento(numero int) error {
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+
+	asiento, existe := s.Asientos[numero]
+	if !existe {
+		return &ReservaError{
+			Codigo:  "ASIENTO_NO_EXISTE",
+			Mensaje: "El asiento no existe",
+		}
+	}
+
+	asiento.mu.Lock()
+	defer asiento.mu.Unlock()
+
+	if !asiento.Bloqueado {
+		return &ReservaError{
+			Codigo:  "ASIENTO_NO_BLOQUEADO",
+			Mensaje: "El asiento no está fuera de servicio",
+		}
+	}
+
+	asiento.Disponible = true
+	asiento.Bloqueado = false
+	asiento.MotivoBloqueo = ""
+	return nil
+}
+
+// ContarBloqueados cuenta los asientos fuera de servicio
+func (s *SistemaReservas) ContarBloqueados() int {
+	s.asientosMu.RLock()
+	defer s.asientosMu.RUnlock()
+	return s.contar(bloqueado)
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// reservarConcurrente lanza un cliente por asiento de numeros a la vez y
+// devuelve cuántas reservas tuvieron éxito
+func reservarConcurrente(reservar func(numero int, cliente string) error, numeros []int) int {
+	var (
+		wg      sync.WaitGroup
+		exitos  int32
+		cliente int32
+	)
+	for _, numero := range numeros {
+		wg.Add(1)
+		go func(numero int) {
+			defer wg.Done()
+			id := atomic.AddInt32(&cliente, 1)
+			if reservar(numero, fmt.Sprintf("cliente-%d", id)) == nil {
+				atomic.AddInt32(&exitos, 1)
+			}
+		}(numero)
+	}
+	wg.Wait()
+	return int(exitos)
+}
+
+func TestReservarAsientoPorAsientoSoloUnGanador(t *testing.T) {
+	s := NewSistemaReservas("servidor-1", 5)
+
+	if exitos := reservarConcurrente(s.ReservarAsientoPorAsiento, []int{3, 3, 3, 3, 3}); exitos != 1 {
+		t.Fatalf("expected exactly one reservation of seat 3, got %d", exitos)
+	}
+	if reservados := s.ContarReservados(); reservados != 1 {
+		t.Fatalf("expected 1 reserved seat, got %d", reservados)
+	}
+}
+
+func TestReservarAsientoPorAsientoEnParalelo(t *testing.T) {
+	s := NewSistemaReservas("servidor-1", 8)
+
+	inicio := time.Now()
+	if exitos := reservarConcurrente(s.ReservarAsientoPorAsiento, []int{1, 2, 3, 4, 5, 6, 7, 8}); exitos != 8 {
+		t.Fatalf("expected 8 reservations, got %d", exitos)
+	}
+	// Con el mutex global tardaría 8 × 100ms
+	if duracion := time.Since(inicio); duracion > 400*time.Millisecond {
+		t.Fatalf("reservations of different seats were serialized: took %s", duracion)
+	}
+}
+
+func TestReservarAsientoSeguroSoloUnGanador(t *testing.T) {
+	s := NewSistemaReservas("servidor-1", 5)
+
+	if exitos := reservarConcurrente(s.ReservarAsientoSeguro, []int{2, 2, 2}); exitos != 1 {
+		t.Fatalf("expected exactly one reservation of seat 2, got %d", exitos)
+	}
+}
+
+// Altas y bajas del mapa mientras se reserva; con -race detecta si el mapa
+// queda sin proteger
+func TestAgregarEliminarDuranteReservas(t *testing.T) {
+	s := NewSistemaReservas("servidor-1", 4)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		reservarConcurrente(s.ReservarAsientoPorAsiento, []int{1, 2, 3, 4, 10, 11})
+	}()
+	go func() {
+		defer wg.Done()
+		for numero := 10; numero < 20; numero++ {
+			if err := s.AgregarAsiento(numero); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := s.EliminarAsiento(4); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	if err := s.AgregarAsiento(10); err == nil {
+		t.Fatal("expected seat 10 to exist already")
+	}
+	if err := s.ReservarAsientoPorAsiento(4, "ana"); err == nil || err.(*ReservaError).Codigo != "ASIENTO_NO_EXISTE" {
+		t.Fatalf("expected seat 4 to be gone, got %v", err)
+	}
+}
+
+// altasYBajas añade los asientos 10-19 y quita el 4 mientras corren otras
+// operaciones sobre el sistema
+func altasYBajas(t *testing.T, s *SistemaReservas, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for numero := 10; numero < 20; numero++ {
+		if err := s.AgregarAsiento(numero); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := s.EliminarAsiento(4); err != nil {
+		t.Error(err)
+	}
+}
+
+// Los listados y recuentos leen el mapa con asientosMu, así que pueden
+// correr junto a altas y bajas; con -race detecta si alguno lo lee sin él
+func TestLecturasDuranteAltasYBajas(t *testing.T) {
+	s := NewSistemaReservas("servidor-1", 4)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go altasYBajas(t, s, &wg)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			s.ObtenerTodosLosAsientos()
+			s.ContarDisponibles()
+			s.ContarReservados()
+			s.ContarBloqueados()
+			s.ObtenerEstado()
+			s.ObtenerAsiento(1)
+		}
+	}()
+	wg.Wait()
+
+	if estado := s.ObtenerEstado(); estado.TotalAsientos != 13 || estado.Disponibles != 13 {
+		t.Fatalf("expected 13 free seats after the changes, got %+v", estado)
+	}
+	if n := len(s.ObtenerTodosLosAsientos()); n != 13 {
+		t.Fatalf("expected 13 seats listed, got %d", n)
+	}
+}
+
+// La reserva con mutex global y la liberación también toman el mapa
+func TestReservarAsientoSeguroDuranteAltasYBajas(t *testing.T) {
+	s := NewSistemaReservas("servidor-1", 4)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go altasYBajas(t, s, &wg)
+	go func() {
+		defer wg.Done()
+		if exitos := reservarConcurrente(s.ReservarAsientoSeguro, []int{1, 2, 2, 3}); exitos != 3 {
+			t.Errorf("expected seats 1-3 reserved once each, got %d reservations", exitos)
+		}
+		if err := s.LiberarAsiento(2); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	if reservados := s.ContarReservados(); reservados != 2 {
+		t.Fatalf("expected seats 1 and 3 reserved, got %d", reservados)
+	}
+}
+
+// benchmarkReservas reserva en paralelo asientos siempre distintos, que es el
+// caso en que el mutex por asiento gana al global. Ejecutar con:
+//
+//	go test -race -bench Reservar -benchtime 2s ./models
+func benchmarkReservas(b *testing.B, reservar func(s *SistemaReservas, numero int, cliente string) error) {
+	s := NewSistemaReservas("servidor-1", b.N)
+	var siguiente int64
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			numero := int(atomic.AddInt64(&siguiente, 1))
+			if err := reservar(s, numero, "cliente"); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	b.StopTimer()
+	if reservados := s.ContarReservados(); reservados != b.N {
+		b.Fatalf("expected %d reserved seats, got %d", b.N, reservados)
+	}
+}
+
+func BenchmarkReservarMutexGlobal(b *testing.B) {
+	benchmarkReservas(b, (*SistemaReservas).ReservarAsientoSeguro)
+}
+
+func BenchmarkReservarMutexPorAsiento(b *testing.B) {
+	benchmarkReservas(b, (*SistemaReservas).ReservarAsientoPorAsiento)
+}
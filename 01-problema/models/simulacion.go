@@ -0,0 +1,161 @@
+package models
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Modos de reserva disponibles para la simulación
+const (
+	ModoRace    = "race"    // ReservarAsiento, con race condition
+	ModoMutex   = "mutex"   // ReservarAsientoSeguro, con un mutex global
+	ModoAsiento = "asiento" // ReservarAsientoPorAsiento, con un mutex por asiento
+)
+
+// ConfigSimulacion describe un escenario de contención
+type ConfigSimulacion struct {
+	NumClientes  int    `json:"numClients"`
+	AsientoMeta  int    `json:"targetSeat"`
+	RetardoMaxMs int    `json:"delayMs"` // Retardo aleatorio máximo antes de cada intento
+	Modo         string `json:"modo"`
+	// Si es true cada cliente reserva un asiento distinto en lugar de
+	// targetSeat, para comparar el rendimiento sin contención
+	AsientosDistintos bool `json:"distinctSeats"`
+}
+
+// ResultadoSimulacion resume lo ocurrido en una simulación
+type ResultadoSimulacion struct {
+	Modo       string   `json:"modo"`
+	Clientes   int      `json:"clientes"`
+	Asiento    int      `json:"asiento"`
+	Exitos     int      `json:"exitos"`
+	Fallos     int      `json:"fallos"`
+	Ganadores  []string `json:"ganadores"`
+	TiemposMs  Tiempos  `json:"tiempos_ms"`
+	DuracionMs float64  `json:"duracion_ms"`
+	// Intentos de reserva completados por segundo
+	Throughput float64 `json:"throughput"`
+}
+
+// Tiempos es la distribución de latencias de los intentos de reserva
+type Tiempos struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+}
+
+// Simular lanza NumClientes goroutines que intentan reservar el mismo asiento
+// sobre un sistema recién creado, sin tocar el estado del servidor.
+func Simular(servidorID string, totalAsientos int, cfg ConfigSimulacion) (*ResultadoSimulacion, error) {
+	if cfg.NumClientes <= 0 {
+		return nil, fmt.Errorf("numClients debe ser mayor que 0")
+	}
+	if cfg.AsientosDistintos && cfg.NumClientes > totalAsientos {
+		return nil, fmt.Errorf("con distinctSeats numClients no puede superar %d", totalAsientos)
+	}
+	if !cfg.AsientosDistintos && (cfg.AsientoMeta <= 0 || cfg.AsientoMeta > totalAsientos) {
+		return nil, fmt.Errorf("targetSeat debe estar entre 1 y %d", totalAsientos)
+	}
+	if cfg.Modo == "" {
+		cfg.Modo = ModoRace
+	}
+
+	sistema := NewSistemaReservas(servidorID, totalAsientos)
+
+	var reservar func(numero int, cliente string) error
+	switch cfg.Modo {
+	case ModoRace:
+		reservar = sistema.ReservarAsiento
+	case ModoMutex:
+		reservar = sistema.ReservarAsientoSeguro
+	case ModoAsiento:
+		reservar = sistema.ReservarAsientoPorAsiento
+	default:
+		return nil, fmt.Errorf("modo desconocido: %s", cfg.Modo)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		ganadores []string
+		tiempos   []float64
+	)
+
+	// Todas las goroutines arrancan a la vez para maximizar la contención
+	inicio := make(chan struct{})
+	for i := 1; i <= cfg.NumClientes; i++ {
+		wg.Add(1)
+		asiento := cfg.AsientoMeta
+		if cfg.AsientosDistintos {
+			asiento = i
+		}
+		go func(cliente string, asiento int) {
+			defer wg.Done()
+			<-inicio
+
+			if cfg.RetardoMaxMs > 0 {
+				time.Sleep(time.Duration(rand.Intn(cfg.RetardoMaxMs+1)) * time.Millisecond)
+			}
+
+			t0 := time.Now()
+			err := reservar(asiento, cliente)
+			ms := float64(time.Since(t0).Microseconds()) / 1000
+
+			mu.Lock()
+			defer mu.Unlock()
+			tiempos = append(tiempos, ms)
+			if err == nil {
+				ganadores = append(ganadores, cliente)
+			}
+		}(fmt.Sprintf("cliente-%d", i), asiento)
+	}
+
+	t0 := time.Now()
+	close(inicio)
+	wg.Wait()
+	duracion := time.Since(t0)
+
+	sort.Strings(ganadores)
+	return &ResultadoSimulacion{
+		Modo:       cfg.Modo,
+		Clientes:   cfg.NumClientes,
+		Asiento:    cfg.AsientoMeta,
+		Exitos:     len(ganadores),
+		Fallos:     cfg.NumClientes - len(ganadores),
+		Ganadores:  ganadores,
+		TiemposMs:  calcularTiempos(tiempos),
+		DuracionMs: float64(duracion.Microseconds()) / 1000,
+		Throughput: float64(cfg.NumClientes) / duracion.Seconds(),
+	}, nil
+}
+
+// calcularTiempos obtiene la distribución de una lista de latencias
+func calcularTiempos(muestras []float64) Tiempos {
+	if len(muestras) == 0 {
+		return Tiempos{}
+	}
+	sort.Float64s(muestras)
+
+	suma := 0.0
+	for _, m := range muestras {
+		suma += m
+	}
+
+	percentil := func(p float64) float64 {
+		idx := int(p * float64(len(muestras)-1))
+		return muestras[idx]
+	}
+
+	return Tiempos{
+		Min: muestras[0],
+		Max: muestras[len(muestras)-1],
+		Avg: suma / float64(len(muestras)),
+		P50: percentil(0.50),
+		P95: percentil(0.95),
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !race
+
+package models
+
+import "testing"
+
+// El modo race tiene una carrera a propósito, así que esta prueba no puede
+// ejecutarse con el detector de carreras
+
+func TestSimularRaceTieneVariosGanadores(t *testing.T) {
+	// Sin retardo previo todos comprueban el asiento antes de que nadie lo
+	// marque, dentro de los 100ms de latencia simulada
+	resultado, err := Simular("servidor-1", 10, ConfigSimulacion{NumClientes: 5, AsientoMeta: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resultado.Modo != ModoRace {
+		t.Fatalf("expected the default mode to be %s, got %s", ModoRace, resultado.Modo)
+	}
+	if resultado.Exitos < 2 {
+		t.Fatalf("expected the race condition to let several clients win, got %d", resultado.Exitos)
+	}
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSimularMutexTieneUnSoloGanador(t *testing.T) {
+	for _, modo := range []string{ModoMutex, ModoAsiento} {
+		resultado, err := Simular("servidor-1", 10, ConfigSimulacion{NumClientes: 8, AsientoMeta: 4, Modo: modo})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resultado.Exitos != 1 || resultado.Fallos != 7 || len(resultado.Ganadores) != 1 {
+			t.Errorf("%s: expected 1 winner and 7 failures, got %+v", modo, resultado)
+		}
+		if resultado.Asiento != 4 || resultado.Clientes != 8 || resultado.Modo != modo {
+			t.Errorf("%s: result does not describe the scenario: %+v", modo, resultado)
+		}
+	}
+}
+
+func TestSimularAsientosDistintos(t *testing.T) {
+	resultado, err := Simular("servidor-1", 10, ConfigSimulacion{NumClientes: 6, Modo: ModoAsiento, AsientosDistintos: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resultado.Exitos != 6 {
+		t.Fatalf("expected every client to get its own seat, got %d", resultado.Exitos)
+	}
+	// En paralelo: del orden de una latencia simulada, no de seis
+	if resultado.DuracionMs > 400 {
+		t.Fatalf("per-seat reservations were serialized: %.1fms", resultado.DuracionMs)
+	}
+	if resultado.TiemposMs.Min <= 0 || resultado.TiemposMs.Max < resultado.TiemposMs.P95 || resultado.Throughput <= 0 {
+		t.Fatalf("unexpected timings: %+v, throughput %.1f", resultado.TiemposMs, resultado.Throughput)
+	}
+}
+
+func TestSimularValidaLaConfiguracion(t *testing.T) {
+	cases := []struct {
+		cfg     ConfigSimulacion
+		mensaje string
+	}{
+		{ConfigSimulacion{NumClientes: 0, AsientoMeta: 1}, "numClients"},
+		{ConfigSimulacion{NumClientes: 2, AsientoMeta: 11}, "targetSeat"},
+		{ConfigSimulacion{NumClientes: 2, AsientoMeta: 0}, "targetSeat"},
+		{ConfigSimulacion{NumClientes: 11, AsientosDistintos: true}, "distinctSeats"},
+		{ConfigSimulacion{NumClientes: 2, AsientoMeta: 1, Modo: "otro"}, "modo desconocido"},
+	}
+	for _, tc := range cases {
+		if _, err := Simular("servidor-1", 10, tc.cfg); err == nil || !strings.Contains(err.Error(), tc.mensaje) {
+			t.Errorf("Simular(%+v): expected an error about %s, got %v", tc.cfg, tc.mensaje, err)
+		}
+	}
+}
+
+func TestCalcularTiempos(t *testing.T) {
+	muestras := make([]float64, 0, 100)
+	for i := 100; i >= 1; i-- {
+		muestras = append(muestras, float64(i))
+	}
+	tiempos := calcularTiempos(muestras)
+	want := Tiempos{Min: 1, Max: 100, Avg: 50.5, P50: 50, P95: 95}
+	if tiempos != want {
+		t.Fatalf("got %+v, want %+v", tiempos, want)
+	}
+	if vacio := calcularTiempos(nil); vacio != (Tiempos{}) {
+		t.Fatalf("expected zero timings for no samples, got %+v", vacio)
+	}
+}
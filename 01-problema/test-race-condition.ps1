@@ -94,7 +94,7 @@ function Reserve-Seat {
             return $true
         }
         else {
-            Write-Host "❌ [$ClientName] Error en servidor puerto $ServerPort: $($response.error)" -ForegroundColor Red
+            Write-Host "❌ [$ClientName] Error en servidor puerto $ServerPort: $($response.error.message)" -ForegroundColor Red
             return $false
         }
     }
@@ -133,7 +133,7 @@ function Demonstrate-RaceCondition {
         
         try {
             $response = Invoke-RestMethod -Uri $url -Method Post -Body $body -ContentType "application/json" -TimeoutSec 5
-            return @{ Success = $response.success; Client = "Cliente-A"; Port = $ServerPort; Error = $response.error }
+            return @{ Success = $response.success; Client = "Cliente-A"; Port = $ServerPort; Error = $response.error.message }
         }
         catch {
             return @{ Success = $false; Client = "Cliente-A"; Port = $ServerPort; Error = $_.Exception.Message }
@@ -151,7 +151,7 @@ function Demonstrate-RaceCondition {
         
         try {
             $response = Invoke-RestMethod -Uri $url -Method Post -Body $body -ContentType "application/json" -TimeoutSec 5
-            return @{ Success = $response.success; Client = "Cliente-B"; Port = $ServerPort; Error = $response.error }
+            return @{ Success = $response.success; Client = "Cliente-B"; Port = $ServerPort; Error = $response.error.message }
         }
         catch {
             return @{ Success = $false; Client = "Cliente-B"; Port = $ServerPort; Error = $_.Exception.Message }
@@ -169,7 +169,7 @@ function Demonstrate-RaceCondition {
         
         try {
             $response = Invoke-RestMethod -Uri $url -Method Post -Body $body -ContentType "application/json" -TimeoutSec 5
-            return @{ Success = $response.success; Client = "Cliente-C"; Port = $ServerPort; Error = $response.error }
+            return @{ Success = $response.success; Client = "Cliente-C"; Port = $ServerPort; Error = $response.error.message }
         }
         catch {
             return @{ Success = $false; Client = "Cliente-C"; Port = $ServerPort; Error = $_.Exception.Message }
@@ -92,7 +92,7 @@ concurrent_reservation() {
     if [ "$success" = "true" ]; then
         echo -e "${GREEN}✅ [$client_name] Reserva exitosa en servidor puerto $server_port${NC}"
     else
-        error=$(echo "$response" | grep -o '"error":"[^"]*"' | cut -d'"' -f4)
+        error=$(echo "$response" | grep -o '"message":"[^"]*"' | cut -d'"' -f4)
         echo -e "${RED}❌ [$client_name] Error en servidor puerto $server_port: $error${NC}"
     fi
 }
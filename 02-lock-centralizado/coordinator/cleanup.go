@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Valores por defecto de la limpieza periódica de bloqueos expirados
+const (
+	defaultCleanupInterval = 30 * time.Second
+	defaultCleanupJitter   = 0.2
+)
+
+// parseCleanupConfig interpreta CLEANUP_INTERVAL_S (segundos entre barridos,
+// positivo) y CLEANUP_JITTER (fracción del intervalo que se desplaza al azar
+// cada barrido, entre 0 y 1). Vacíos toman el valor por defecto.
+func parseCleanupConfig(intervalRaw, jitterRaw string) (time.Duration, float64, error) {
+	interval := defaultCleanupInterval
+	if intervalRaw != "" {
+		seconds, err := strconv.Atoi(intervalRaw)
+		if err != nil || seconds <= 0 {
+			return 0, 0, fmt.Errorf("CLEANUP_INTERVAL_S must be a positive number of seconds, got %q", intervalRaw)
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	jitter := defaultCleanupJitter
+	if jitterRaw != "" {
+		j, err := strconv.ParseFloat(jitterRaw, 64)
+		if err != nil || j < 0 || j >= 1 {
+			return 0, 0, fmt.Errorf("CLEANUP_JITTER must be a fraction in [0, 1), got %q", jitterRaw)
+		}
+		jitter = j
+	}
+	return interval, jitter, nil
+}
+
+// jittered desplaza interval al azar hasta ±jitter·interval, para que varios
+// coordinadores no barran todos a la vez
+func jittered(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	offset := (rand.Float64()*2 - 1) * jitter * float64(interval)
+	return interval + time.Duration(offset)
+}
+
+// cleanupExpiredLocks limpia periódicamente los bloqueos expirados
+func (lc *LockCoordinator) cleanupExpiredLocks(interval time.Duration, jitter float64) {
+	for {
+		time.Sleep(jittered(interval, jitter))
+		lc.sweepExpiredLocks()
+	}
+}
+
+// sweepExpiredLocks elimina los bloqueos expirados, cede cada recurso al
+// primero de su cola de espera y devuelve cuántos eliminó
+func (lc *LockCoordinator) sweepExpiredLocks() int {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	purged := 0
+	for resource, lock := range lc.locks {
+		if lc.expired(lock) {
+			delete(lc.locks, resource)
+			lc.collection.DeleteOne(context.Background(), bson.M{"_id": lock.ID})
+			log.Printf("Cleaned up expired lock for resource: %s", resource)
+			lc.handOffLocked(resource)
+			purged++
+		}
+	}
+	return purged
+}
+
+// handleAdminCleanup lanza un barrido inmediato de bloqueos expirados
+func (lc *LockCoordinator) handleAdminCleanup(w http.ResponseWriter, r *http.Request) {
+	if !lc.requireAdmin(w, r) {
+		return
+	}
+
+	purged := lc.sweepExpiredLocks()
+	log.Printf("Manual cleanup purged %d expired locks", purged)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"purged":  purged,
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func adminCleanup(lc *LockCoordinator, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/admin/cleanup", nil)
+	if token != "" {
+		req.Header.Set("X-Admin-Token", token)
+	}
+	rec := httptest.NewRecorder()
+	lc.handleAdminCleanup(rec, req)
+	return rec
+}
+
+// purgedCount devuelve cuántos bloqueos dice haber eliminado /admin/cleanup
+func purgedCount(t *testing.T, rec *httptest.ResponseRecorder) int {
+	t.Helper()
+	var body struct {
+		Success bool `json:"success"`
+		Purged  int  `json:"purged"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || !body.Success {
+		t.Fatalf("unexpected cleanup response %+v, %v", body, err)
+	}
+	return body.Purged
+}
+
+func TestAdminCleanupPurgesExpiredLock(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+		lc := newTestCoordinator(clock)
+		lc.collection = mt.Coll
+		lc.adminToken = "secret"
+
+		for _, l := range []struct {
+			id  string
+			ttl time.Duration
+		}{{"lock-1", time.Second}, {"lock-2", time.Minute}} {
+			lock := &Lock{ID: l.id, Resource: "seat_" + l.id, ClientID: "server1"}
+			lc.startLease(lock, l.ttl)
+			lc.locks[lock.Resource] = lock
+		}
+		clock.advance(5 * time.Second)
+
+		if rec := adminCleanup(lc, ""); rec.Code != http.StatusUnauthorized || errorCode(t, rec) != httperr.CodeUnauthorized {
+			t.Fatalf("expected 401 without the admin token, got %d", rec.Code)
+		}
+		if len(lc.locks) != 2 {
+			t.Fatal("unauthorized cleanup purged locks")
+		}
+
+		mt.AddMockResponses(writeResponse(1))
+		rec := adminCleanup(lc, "secret")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		if purged := purgedCount(t, rec); purged != 1 {
+			t.Fatalf("expected 1 purged lock, got %d", purged)
+		}
+		if _, held := lc.locks["seat_lock-1"]; held {
+			t.Fatal("expired lock is still held")
+		}
+		if _, held := lc.locks["seat_lock-2"]; !held {
+			t.Fatal("live lock was purged")
+		}
+		ev := mt.GetStartedEvent()
+		if ev == nil || ev.CommandName != "delete" || ev.Command.Lookup("deletes", "0", "q", "_id").StringValue() != "lock-1" {
+			t.Fatalf("expected lock-1 to be deleted from MongoDB, got %+v", ev)
+		}
+
+		// Un segundo barrido no encuentra nada
+		if purged := purgedCount(t, adminCleanup(lc, "secret")); purged != 0 {
+			t.Fatalf("second cleanup purged %d locks", purged)
+		}
+	})
+}
+
+func TestParseCleanupConfig(t *testing.T) {
+	interval, jitter, err := parseCleanupConfig("", "")
+	if err != nil || interval != defaultCleanupInterval || jitter != defaultCleanupJitter {
+		t.Fatalf("unexpected defaults: %s, %v, %v", interval, jitter, err)
+	}
+	interval, jitter, err = parseCleanupConfig("5", "0")
+	if err != nil || interval != 5*time.Second || jitter != 0 {
+		t.Fatalf("expected 5s without jitter, got %s, %v, %v", interval, jitter, err)
+	}
+	for _, tc := range [][2]string{{"0", ""}, {"-3", ""}, {"1.5", ""}, {"", "1"}, {"", "-0.1"}, {"", "x"}} {
+		if _, _, err := parseCleanupConfig(tc[0], tc[1]); err == nil {
+			t.Errorf("parseCleanupConfig(%q, %q) accepted an invalid value", tc[0], tc[1])
+		}
+	}
+}
+
+func TestJitteredStaysWithinBounds(t *testing.T) {
+	const interval = 10 * time.Second
+	if got := jittered(interval, 0); got != interval {
+		t.Fatalf("expected no jitter, got %s", got)
+	}
+	distintos := map[time.Duration]bool{}
+	for i := 0; i < 200; i++ {
+		got := jittered(interval, 0.2)
+		if got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("jittered interval %s outside 10s ±20%%", got)
+		}
+		distintos[got] = true
+	}
+	if len(distintos) < 2 {
+		t.Fatal("jitter did not vary the interval")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ClientLock es un bloqueo activo de un cliente con el tiempo que le queda
+type ClientLock struct {
+	*Lock
+	TTLSeconds float64 `json:"ttl_seconds"`
+}
+
+// LocksByClient devuelve los bloqueos activos de un cliente, ordenados por
+// recurso. Los que ya vencieron se eliminan de paso, sin esperar a
+// cleanupExpiredLocks.
+func (lc *LockCoordinator) LocksByClient(clientID string) []ClientLock {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	locks := []ClientLock{}
+	for resource, lock := range lc.locks {
+		if lock.ClientID != clientID {
+			continue
+		}
+		if lc.expired(lock) {
+			delete(lc.locks, resource)
+			lc.collection.DeleteOne(context.Background(), bson.M{"_id": lock.ID})
+			log.Printf("Cleaned up expired lock for resource: %s", resource)
+			lc.handOffLocked(resource)
+			continue
+		}
+		locks = append(locks, ClientLock{Lock: lock, TTLSeconds: lc.remaining(lock).Seconds()})
+	}
+
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Resource < locks[j].Resource })
+	return locks
+}
+
+// handleGetClientLocks lista los bloqueos que tiene un cliente, p. ej. para
+// encontrar un servidor que acapara recursos
+func (lc *LockCoordinator) handleGetClientLocks(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["client_id"]
+	locks := lc.LocksByClient(clientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"client_id": clientID,
+		"count":     len(locks),
+		"locks":     locks,
+	})
+}
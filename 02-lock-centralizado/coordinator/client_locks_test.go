@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func getClientLocks(lc *LockCoordinator, clientID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/locks/client/"+clientID, nil)
+	req = mux.SetURLVars(req, map[string]string{"client_id": clientID})
+	rec := httptest.NewRecorder()
+	lc.handleGetClientLocks(rec, req)
+	return rec
+}
+
+func TestHandleGetClientLocks(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+		lc := newTestCoordinator(clock)
+		lc.collection = mt.Coll
+		lc.newLockID = sequentialLockIDs()
+
+		mt.AddMockResponses(writeResponse(1), writeResponse(1), writeResponse(1), writeResponse(1))
+		for _, acquire := range []struct {
+			resource, clientID string
+			ttl                int
+		}{
+			{"seat_9", "server1", 30},
+			{"seat_3", "server1", 10},
+			{"seat_5", "server2", 30},
+			{"seat_1", "server1", 1},
+		} {
+			if resp, err := lc.AcquireLock(acquire.resource, acquire.clientID, acquire.ttl); err != nil || !resp.Success {
+				t.Fatalf("acquire of %s failed: %+v, %v", acquire.resource, resp, err)
+			}
+		}
+
+		// El bloqueo de seat_1 vence; la consulta lo borra de paso
+		clock.advance(4 * time.Second)
+		mt.AddMockResponses(writeResponse(1))
+		rec := getClientLocks(lc, "server1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+
+		var body struct {
+			ClientID string       `json:"client_id"`
+			Count    int          `json:"count"`
+			Locks    []ClientLock `json:"locks"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.ClientID != "server1" || body.Count != 2 || len(body.Locks) != 2 {
+			t.Fatalf("expected the two live locks of server1, got %+v", body)
+		}
+		want := []struct {
+			resource string
+			ttl      float64
+		}{{"seat_3", 6}, {"seat_9", 26}}
+		for i, w := range want {
+			got := body.Locks[i]
+			if got.Resource != w.resource || got.ClientID != "server1" || got.TTLSeconds != w.ttl {
+				t.Errorf("lock %d: expected %s with %vs left, got %s with %vs", i, w.resource, w.ttl, got.Resource, got.TTLSeconds)
+			}
+		}
+
+		if _, held := lc.locks["seat_1"]; held {
+			t.Fatal("expired lock was not cleaned up")
+		}
+	})
+}
+
+func TestHandleGetClientLocksUnknownClient(t *testing.T) {
+	lc := newTestCoordinator(&fakeClock{})
+	rec := getClientLocks(lc, "nadie")
+
+	var body struct {
+		Count int           `json:"count"`
+		Locks []interface{} `json:"locks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	// Lista vacía, no null
+	if body.Count != 0 || body.Locks == nil || len(body.Locks) != 0 {
+		t.Fatalf("expected an empty list, got %+v", body)
+	}
+}
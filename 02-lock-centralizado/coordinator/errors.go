@@ -0,0 +1,15 @@
+package main
+
+// Códigos de error del coordinador; los comunes están en shared/httperr. Las
+// respuestas de /acquire, /release y /renew que no consiguen el bloqueo
+// siguen siendo un LockResponse con success=false, pero llevan también uno
+// de estos códigos en el campo code.
+const (
+	CodeLockHeld     = "LOCK_HELD"
+	CodeLockNotFound = "LOCK_NOT_FOUND"
+	CodeLockExpired  = "LOCK_EXPIRED"
+	CodeStaleLockID  = "STALE_LOCK_ID"
+	CodeNotLockOwner = "NOT_LOCK_OWNER"
+	CodeWaitTimeout  = "WAIT_TIMEOUT"
+	CodeWaitCanceled = "WAIT_CANCELLED"
+)
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+func TestLockHandlersReportDecodeErrors(t *testing.T) {
+	lc := &LockCoordinator{}
+	cases := []struct {
+		name, body, code string
+	}{
+		{"empty body", "", httperr.CodeEmptyBody},
+		{"truncated JSON", `{"resource":`, httperr.CodeInvalidJSON},
+		{"malformed JSON", `{"resource":x}`, httperr.CodeInvalidJSON},
+		{"wrong field type", `{"client_id":7}`, httperr.CodeInvalidFieldType},
+	}
+
+	for _, h := range []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/acquire", lc.handleAcquireLock},
+		{"/release", lc.handleReleaseLock},
+		{"/renew", lc.handleRenewLock},
+	} {
+		for _, tc := range cases {
+			rec := httptest.NewRecorder()
+			h.handler(rec, httptest.NewRequest(http.MethodPost, h.path, strings.NewReader(tc.body)))
+
+			var resp httperr.Response
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("%s %s: %v", h.path, tc.name, err)
+			}
+			if rec.Code != http.StatusBadRequest || resp.Error.Code != tc.code {
+				t.Errorf("%s %s: %d %s (%q), want 400 %s", h.path, tc.name,
+					rec.Code, resp.Error.Code, resp.Error.Message, tc.code)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Caducidad de los bloqueos. ExpiresAt es la hora de pared que se publica y
+// se guarda en MongoDB, pero si un bloqueo caducó se decide con el tiempo
+// transcurrido desde que se concedió o renovó, medido con el reloj
+// monotónico: un salto del reloj del sistema (un ajuste de NTP) no adelanta
+// ni retrasa la caducidad. expiryGrace añade un margen a favor del titular.
+
+// defaultExpiryGrace es el margen por defecto tras el TTL de un bloqueo
+const defaultExpiryGrace = 500 * time.Millisecond
+
+// lockClock da la hora de pared y un tiempo monotónico
+type lockClock interface {
+	// Now es la hora de pared, para ExpiresAt y CreatedAt
+	Now() time.Time
+	// Monotonic es el tiempo transcurrido desde un origen fijo; no salta
+	// cuando se ajusta el reloj del sistema
+	Monotonic() time.Duration
+}
+
+// systemClock es el reloj del sistema. time.Since usa la lectura monotónica
+// que time.Now guarda en origin.
+type systemClock struct {
+	origin time.Time
+}
+
+func newSystemClock() systemClock {
+	return systemClock{origin: time.Now()}
+}
+
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c systemClock) Monotonic() time.Duration {
+	return time.Since(c.origin)
+}
+
+// parseExpiryGrace interpreta LOCK_EXPIRY_GRACE_MS (milisegundos, no
+// negativo). Vacío toma el valor por defecto.
+func parseExpiryGrace(raw string) (time.Duration, error) {
+	if raw == "" {
+		return defaultExpiryGrace, nil
+	}
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("LOCK_EXPIRY_GRACE_MS must be a non-negative number of milliseconds, got %q", raw)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// startLease hace que el bloqueo dure ttl desde ahora
+func (lc *LockCoordinator) startLease(lock *Lock, ttl time.Duration) {
+	lock.leaseStart = lc.clock.Monotonic()
+	lock.lease = ttl
+	lock.ExpiresAt = lc.clock.Now().Add(ttl)
+}
+
+// remaining es lo que le queda al bloqueo antes de caducar, sin contar el
+// margen. Un bloqueo sin lectura monotónica (p. ej. leído de MongoDB) se
+// juzga por su ExpiresAt.
+func (lc *LockCoordinator) remaining(lock *Lock) time.Duration {
+	if lock.lease <= 0 {
+		return lock.ExpiresAt.Sub(lc.clock.Now())
+	}
+	return lock.lease - (lc.clock.Monotonic() - lock.leaseStart)
+}
+
+// expired indica si el bloqueo caducó, con el margen expiryGrace
+func (lc *LockCoordinator) expired(lock *Lock) bool {
+	return lc.remaining(lock) < -lc.expiryGrace
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock es un lockClock cuya hora de pared puede saltar sin mover el
+// tiempo monotónico
+type fakeClock struct {
+	wall time.Time
+	mono time.Duration
+}
+
+func (c *fakeClock) Now() time.Time           { return c.wall }
+func (c *fakeClock) Monotonic() time.Duration { return c.mono }
+
+// advance hace pasar d en los dos relojes
+func (c *fakeClock) advance(d time.Duration) {
+	c.wall = c.wall.Add(d)
+	c.mono += d
+}
+
+func newTestCoordinator(clock *fakeClock) *LockCoordinator {
+	return &LockCoordinator{
+		locks:       make(map[string]*Lock),
+		waiters:     make(map[string][]*lockWaiter),
+		waitStats:   NewWaitStats(),
+		clock:       clock,
+		expiryGrace: defaultExpiryGrace,
+	}
+}
+
+func TestLockSurvivesBackwardClockJump(t *testing.T) {
+	clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), mono: time.Hour}
+	lc := newTestCoordinator(clock)
+
+	lock := &Lock{ID: "lock-1", Resource: "asiento-7", ClientID: "server1", CreatedAt: clock.Now()}
+	lc.startLease(lock, 10*time.Second)
+	lc.locks[lock.Resource] = lock
+
+	// NTP retrasa el reloj una hora mientras pasan 4s reales
+	clock.wall = clock.wall.Add(-time.Hour)
+	clock.advance(4 * time.Second)
+
+	if lc.expired(lock) {
+		t.Fatal("lock expired after 4s of a 10s TTL")
+	}
+	if remaining := lc.remaining(lock); remaining != 6*time.Second {
+		t.Fatalf("expected 6s remaining, got %s", remaining)
+	}
+	if purged := lc.sweepExpiredLocks(); purged != 0 {
+		t.Fatalf("cleanup purged %d locks after a backward clock jump", purged)
+	}
+	resp, err := lc.AcquireLock(lock.Resource, "server2", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Success || resp.Code != CodeLockHeld {
+		t.Fatalf("another client took the lock after a backward clock jump: %+v", resp)
+	}
+
+	// Aunque la hora de pared siga antes de ExpiresAt, al agotar TTL y margen caduca
+	clock.advance(6*time.Second + defaultExpiryGrace + time.Millisecond)
+	if clock.Now().After(lock.ExpiresAt) {
+		t.Fatal("test setup: the wall clock should still be before ExpiresAt")
+	}
+	if !lc.expired(lock) {
+		t.Fatal("lock did not expire once its TTL and grace elapsed")
+	}
+}
+
+func TestLockSurvivesForwardClockJump(t *testing.T) {
+	clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	lc := newTestCoordinator(clock)
+
+	lock := &Lock{ID: "lock-1", Resource: "asiento-7", ClientID: "server1", CreatedAt: clock.Now()}
+	lc.startLease(lock, 10*time.Second)
+
+	clock.wall = clock.wall.Add(time.Hour)
+	clock.advance(time.Second)
+	if lc.expired(lock) {
+		t.Fatal("lock expired early after a forward clock jump")
+	}
+}
+
+func TestExpiryGraceFavoursTheHolder(t *testing.T) {
+	clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	lc := newTestCoordinator(clock)
+	lc.expiryGrace = 300 * time.Millisecond
+
+	lock := &Lock{ID: "lock-1", Resource: "asiento-7", ClientID: "server1"}
+	lc.startLease(lock, time.Second)
+
+	clock.advance(time.Second + 300*time.Millisecond)
+	if lc.expired(lock) {
+		t.Fatal("lock expired inside its grace period")
+	}
+	clock.advance(time.Millisecond)
+	if !lc.expired(lock) {
+		t.Fatal("lock did not expire after its grace period")
+	}
+}
+
+func TestLockWithoutLeaseUsesExpiresAt(t *testing.T) {
+	clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	lc := newTestCoordinator(clock)
+
+	// Un bloqueo leído de MongoDB no tiene lectura monotónica
+	lock := &Lock{ID: "lock-1", Resource: "asiento-7", ExpiresAt: clock.Now().Add(2 * time.Second)}
+	if lc.expired(lock) {
+		t.Fatal("lock expired before its ExpiresAt")
+	}
+	clock.wall = clock.wall.Add(3 * time.Second)
+	if !lc.expired(lock) {
+		t.Fatal("lock did not expire after ExpiresAt plus the grace")
+	}
+}
+
+func TestParseExpiryGrace(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want time.Duration
+		ok   bool
+	}{
+		{"", defaultExpiryGrace, true},
+		{"0", 0, true},
+		{"250", 250 * time.Millisecond, true},
+		{"-1", 0, false},
+		{"1s", 0, false},
+	}
+	for _, tc := range cases {
+		got, err := parseExpiryGrace(tc.raw)
+		if (err == nil) != tc.ok || (tc.ok && got != tc.want) {
+			t.Errorf("parseExpiryGrace(%q) = %s, %v", tc.raw, got, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// adminExtend llama a /admin/extend con el cuerpo y el token indicados
+func adminExtend(lc *LockCoordinator, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/admin/extend", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("X-Admin-Token", token)
+	}
+	rec := httptest.NewRecorder()
+	lc.handleAdminExtend(rec, req)
+	return rec
+}
+
+// errorCode devuelve el código del sobre de error de la respuesta
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp httperr.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+	return resp.Error.Code
+}
+
+func TestAdminExtendRequiresToken(t *testing.T) {
+	lc := newTestCoordinator(&fakeClock{})
+	body := `{"resource":"seat_7","additional_seconds":10}`
+
+	if rec := adminExtend(lc, body, "x"); rec.Code != http.StatusForbidden || errorCode(t, rec) != httperr.CodeAdminDisabled {
+		t.Fatalf("expected 403 %s without ADMIN_TOKEN, got %d", httperr.CodeAdminDisabled, rec.Code)
+	}
+	lc.adminToken = "secret"
+	if rec := adminExtend(lc, body, "wrong"); rec.Code != http.StatusUnauthorized || errorCode(t, rec) != httperr.CodeUnauthorized {
+		t.Fatalf("expected 401 %s with a wrong token, got %d", httperr.CodeUnauthorized, rec.Code)
+	}
+}
+
+func TestAdminExtendRejectsInvalidRequests(t *testing.T) {
+	lc := newTestCoordinator(&fakeClock{})
+	lc.adminToken = "secret"
+
+	for _, body := range []string{
+		`{"additional_seconds":10}`,
+		`{"resource":"seat_7"}`,
+		`{"resource":"seat_7","additional_seconds":-5}`,
+	} {
+		if rec := adminExtend(lc, body, "secret"); rec.Code != http.StatusBadRequest || errorCode(t, rec) != httperr.CodeInvalidRequest {
+			t.Errorf("%s: expected 400 %s, got %d", body, httperr.CodeInvalidRequest, rec.Code)
+		}
+	}
+	if rec := adminExtend(lc, `{"resource":"seat_7","additional_seconds":10}`, "secret"); rec.Code != http.StatusConflict || errorCode(t, rec) != CodeLockNotFound {
+		t.Fatalf("expected 409 %s for an unknown lock, got %d", CodeLockNotFound, rec.Code)
+	}
+}
+
+func TestAdminExtendRefusesExpiredLock(t *testing.T) {
+	clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	lc := newTestCoordinator(clock)
+	lc.adminToken = "secret"
+	lock := &Lock{ID: "lock-1", Resource: "seat_7", ClientID: "server1"}
+	lc.startLease(lock, 5*time.Second)
+	lc.locks[lock.Resource] = lock
+
+	clock.advance(10 * time.Second)
+	rec := adminExtend(lc, `{"resource":"seat_7","additional_seconds":10}`, "secret")
+	if rec.Code != http.StatusConflict || errorCode(t, rec) != CodeLockExpired {
+		t.Fatalf("expected 409 %s for an expired lock, got %d", CodeLockExpired, rec.Code)
+	}
+}
+
+func TestAdminExtendPushesExpiry(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+		lc := newTestCoordinator(clock)
+		lc.collection = mt.Coll
+		lc.adminToken = "secret"
+		lock := &Lock{ID: "lock-1", Resource: "seat_7", ClientID: "server1"}
+		lc.startLease(lock, 10*time.Second)
+		lc.locks[lock.Resource] = lock
+		oldExpiry := lock.ExpiresAt
+
+		mt.AddMockResponses(writeResponse(1))
+		rec := adminExtend(lc, `{"resource":"seat_7","additional_seconds":20}`, "secret")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var resp LockResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Success || resp.LockID != "lock-1" {
+			t.Fatalf("unexpected response %+v", resp)
+		}
+		if want := oldExpiry.Add(20 * time.Second); !lock.ExpiresAt.Equal(want) {
+			t.Fatalf("ExpiresAt is %s, want %s", lock.ExpiresAt, want)
+		}
+
+		// La nueva hora se guarda en MongoDB
+		ev := mt.GetStartedEvent()
+		saved, err := ev.Command.LookupErr("updates", "0", "u", "$set", "expires_at")
+		if err != nil || !saved.Time().Equal(lock.ExpiresAt) {
+			t.Fatalf("expires_at was not saved: %s", ev.Command)
+		}
+
+		// Pasado el TTL original el bloqueo sigue vivo; pasado el ampliado caduca
+		clock.advance(15 * time.Second)
+		if lc.expired(lock) {
+			t.Fatal("extended lock expired at its original TTL")
+		}
+		clock.advance(15*time.Second + lc.expiryGrace + time.Millisecond)
+		if !lc.expired(lock) {
+			t.Fatal("extended lock did not expire after its new TTL")
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// withMockMongo ejecuta fn con un cliente de MongoDB simulado: cada orden que
+// envía el driver consume, en orden, una de las respuestas añadidas con
+// mt.AddMockResponses
+func withMockMongo(t *testing.T, fn func(mt *mtest.T)) {
+	t.Helper()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	mt.Run("mock", fn)
+}
+
+// writeResponse es la respuesta a una escritura que afectó a n documentos
+func writeResponse(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
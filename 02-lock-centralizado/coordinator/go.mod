@@ -4,10 +4,12 @@ go 1.21
 
 require (
 	github.com/gorilla/mux v1.8.0
+	github.com/sincronizacion-distribuida/shared v0.0.0
 	go.mongodb.org/mongo-driver v1.12.1
 )
 
 require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/montanaflynn/stats v0.0.0-20171201202039-1bf9dbcd8cbe // indirect
@@ -19,3 +21,5 @@ require (
 	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4 // indirect
 	golang.org/x/text v0.7.0 // indirect
 )
+
+replace github.com/sincronizacion-distribuida/shared => ../../shared
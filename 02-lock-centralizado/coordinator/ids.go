@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// LockIDGenerator genera el identificador de un bloqueo nuevo
+type LockIDGenerator func(resource, clientID string) string
+
+// uuidLockID genera un UUID v4 aleatorio: opaco (no revela recurso ni
+// cliente) y único aunque lleguen dos peticiones en el mismo nanosegundo
+func uuidLockID(resource, clientID string) string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // versión 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variante RFC 4122
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+	return string(buf[:])
+}
+
+// debugLockID es el formato antiguo, legible al depurar. No garantiza
+// unicidad: dos peticiones en el mismo nanosegundo generan el mismo ID.
+func debugLockID(resource, clientID string) string {
+	return fmt.Sprintf("%s_%s_%d", resource, clientID, time.Now().UnixNano())
+}
+
+// parseLockIDGenerator interpreta LOCK_ID_FORMAT: "uuid" (por defecto) o
+// "debug"
+func parseLockIDGenerator(format string) (LockIDGenerator, error) {
+	switch format {
+	case "", "uuid":
+		return uuidLockID, nil
+	case "debug":
+		return debugLockID, nil
+	default:
+		return nil, fmt.Errorf("unknown LOCK_ID_FORMAT %q (expected uuid or debug)", format)
+	}
+}
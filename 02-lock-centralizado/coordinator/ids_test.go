@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestConcurrentAcquireLockIDsAreUnique(t *testing.T) {
+	const clients = 200
+
+	withMockMongo(t, func(mt *mtest.T) {
+		lc := NewLockCoordinator(mt.Coll)
+		responses := make([]bson.D, clients)
+		for i := range responses {
+			responses[i] = writeResponse(1)
+		}
+		mt.AddMockResponses(responses...)
+
+		var (
+			wg  sync.WaitGroup
+			mu  sync.Mutex
+			ids = make(map[string]string, clients)
+		)
+		for i := 0; i < clients; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				// Mismo cliente y recursos distintos: todos los bloqueos se conceden
+				resource := fmt.Sprintf("asiento-%d", i)
+				resp, err := lc.AcquireLock(resource, "server1", 30)
+				if err != nil || !resp.Success {
+					t.Errorf("acquire of %s failed: %+v, %v", resource, resp, err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if other, dup := ids[resp.LockID]; dup {
+					t.Errorf("lock id %s issued for %s and %s", resp.LockID, other, resource)
+				}
+				ids[resp.LockID] = resource
+			}(i)
+		}
+		wg.Wait()
+
+		if len(ids) != clients {
+			t.Fatalf("expected %d distinct lock ids, got %d", clients, len(ids))
+		}
+		for id, resource := range ids {
+			if !uuidV4.MatchString(id) {
+				t.Fatalf("lock id %q is not a UUID v4", id)
+			}
+			if strings.Contains(id, resource) || strings.Contains(id, "server1") {
+				t.Fatalf("lock id %q leaks the resource or client", id)
+			}
+		}
+	})
+}
+
+func TestParseLockIDGenerator(t *testing.T) {
+	for _, format := range []string{"", "uuid"} {
+		gen, err := parseLockIDGenerator(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := gen("asiento-7", "server1"); !uuidV4.MatchString(id) {
+			t.Fatalf("format %q generated %q, expected a UUID", format, id)
+		}
+	}
+
+	gen, err := parseLockIDGenerator("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := gen("asiento-7", "server1"); !strings.HasPrefix(id, "asiento-7_server1_") {
+		t.Fatalf("debug format generated %q", id)
+	}
+
+	if _, err := parseLockIDGenerator("sequential"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sincronizacion-distribuida/shared/httperr"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -20,6 +22,9 @@ type LockRequest struct {
 	Resource string `json:"resource"`
 	ClientID string `json:"client_id"`
 	TTL      int    `json:"ttl"` // Time to live en segundos
+	// WaitSeconds > 0 hace que la petición espere en cola a que el recurso
+	// quede libre, como máximo ese tiempo
+	WaitSeconds int `json:"wait_seconds,omitempty"`
 }
 
 // LockResponse representa la respuesta de un bloqueo
@@ -27,6 +32,7 @@ type LockResponse struct {
 	Success   bool   `json:"success"`
 	LockID    string `json:"lock_id,omitempty"`
 	Message   string `json:"message,omitempty"`
+	Code      string `json:"code,omitempty"` // solo en los fallos, ver errors.go
 	ExpiresAt int64  `json:"expires_at,omitempty"`
 }
 
@@ -37,25 +43,38 @@ type Lock struct {
 	ClientID  string    `bson:"client_id" json:"client_id"`
 	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+
+	// Inicio (en el reloj monotónico del coordinador) y duración del
+	// arriendo actual; ver expiry.go
+	leaseStart time.Duration
+	lease      time.Duration
 }
 
 // LockCoordinator maneja los bloqueos distribuidos
 type LockCoordinator struct {
-	locks      map[string]*Lock
-	mutex      sync.RWMutex
-	collection *mongo.Collection
+	locks       map[string]*Lock
+	mutex       sync.RWMutex
+	collection  *mongo.Collection
+	adminToken  string                   // vacío = endpoints /admin deshabilitados
+	waiters     map[string][]*lockWaiter // resource -> cola FIFO de espera
+	waitStats   *WaitStats
+	newLockID   LockIDGenerator // genera el ID de cada bloqueo concedido
+	clock       lockClock       // mide la caducidad de los bloqueos
+	expiryGrace time.Duration   // margen tras el TTL antes de dar un bloqueo por caducado
 }
 
 // NewLockCoordinator crea un nuevo coordinador de bloqueos
 func NewLockCoordinator(collection *mongo.Collection) *LockCoordinator {
 	lc := &LockCoordinator{
-		locks:      make(map[string]*Lock),
-		collection: collection,
+		locks:       make(map[string]*Lock),
+		collection:  collection,
+		waiters:     make(map[string][]*lockWaiter),
+		waitStats:   NewWaitStats(),
+		newLockID:   uuidLockID,
+		clock:       newSystemClock(),
+		expiryGrace: defaultExpiryGrace,
 	}
-	
-	// Iniciar limpieza periódica de bloqueos expirados
-	go lc.cleanupExpiredLocks()
-	
+
 	return lc
 }
 
@@ -66,10 +85,11 @@ func (lc *LockCoordinator) AcquireLock(resource, clientID string, ttl int) (*Loc
 
 	// Verificar si ya existe un bloqueo activo para este recurso
 	if existingLock, exists := lc.locks[resource]; exists {
-		if time.Now().Before(existingLock.ExpiresAt) {
+		if !lc.expired(existingLock) {
 			return &LockResponse{
 				Success: false,
 				Message: fmt.Sprintf("Resource %s is already locked by client %s", resource, existingLock.ClientID),
+				Code:    CodeLockHeld,
 			}, nil
 		}
 		// El bloqueo ha expirado, eliminarlo
@@ -77,17 +97,31 @@ func (lc *LockCoordinator) AcquireLock(resource, clientID string, ttl int) (*Loc
 		lc.collection.DeleteOne(context.Background(), bson.M{"_id": existingLock.ID})
 	}
 
+	// Los clientes en cola tienen preferencia sobre los que no esperan
+	if lc.handOffLocked(resource) {
+		return &LockResponse{
+			Success: false,
+			Message: fmt.Sprintf("Resource %s is already locked by client %s", resource, lc.locks[resource].ClientID),
+			Code:    CodeLockHeld,
+		}, nil
+	}
+
+	return lc.grantLocked(resource, clientID, ttl)
+}
+
+// grantLocked crea un bloqueo nuevo sobre un recurso libre.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (lc *LockCoordinator) grantLocked(resource, clientID string, ttl int) (*LockResponse, error) {
 	// Crear nuevo bloqueo
-	lockID := fmt.Sprintf("%s_%s_%d", resource, clientID, time.Now().UnixNano())
-	expiresAt := time.Now().Add(time.Duration(ttl) * time.Second)
-	
+	lockID := lc.newLockID(resource, clientID)
+
 	lock := &Lock{
 		ID:        lockID,
 		Resource:  resource,
 		ClientID:  clientID,
-		ExpiresAt: expiresAt,
-		CreatedAt: time.Now(),
+		CreatedAt: lc.clock.Now(),
 	}
+	lc.startLease(lock, time.Duration(ttl)*time.Second)
 
 	// Guardar en memoria y MongoDB
 	lc.locks[resource] = lock
@@ -101,12 +135,14 @@ func (lc *LockCoordinator) AcquireLock(resource, clientID string, ttl int) (*Loc
 		Success:   true,
 		LockID:    lockID,
 		Message:   "Lock acquired successfully",
-		ExpiresAt: expiresAt.Unix(),
+		ExpiresAt: lock.ExpiresAt.Unix(),
 	}, nil
 }
 
-// ReleaseLock libera un bloqueo
-func (lc *LockCoordinator) ReleaseLock(resource, clientID string) (*LockResponse, error) {
+// ReleaseLock libera un bloqueo. Solo lo borra si lockID coincide con el del
+// bloqueo actual: un cliente cuyo bloqueo expiró no puede liberar el que otro
+// obtuvo después, aunque reutilice el mismo clientID.
+func (lc *LockCoordinator) ReleaseLock(resource, clientID, lockID string) (*LockResponse, error) {
 	lc.mutex.Lock()
 	defer lc.mutex.Unlock()
 
@@ -115,6 +151,16 @@ func (lc *LockCoordinator) ReleaseLock(resource, clientID string) (*LockResponse
 		return &LockResponse{
 			Success: false,
 			Message: "No lock found for this resource",
+			Code:    CodeLockNotFound,
+		}, nil
+	}
+
+	if lock.ID != lockID {
+		log.Printf("Rejected release of resource %s by client %s: stale lock id %s", resource, clientID, lockID)
+		return &LockResponse{
+			Success: false,
+			Message: "Stale lock id: the lock expired and was acquired again",
+			Code:    CodeStaleLockID,
 		}, nil
 	}
 
@@ -122,6 +168,7 @@ func (lc *LockCoordinator) ReleaseLock(resource, clientID string) (*LockResponse
 		return &LockResponse{
 			Success: false,
 			Message: "Lock belongs to a different client",
+			Code:    CodeNotLockOwner,
 		}, nil
 	}
 
@@ -131,6 +178,7 @@ func (lc *LockCoordinator) ReleaseLock(resource, clientID string) (*LockResponse
 	if err != nil {
 		log.Printf("Failed to delete lock from database: %v", err)
 	}
+	lc.handOffLocked(resource)
 
 	return &LockResponse{
 		Success: true,
@@ -138,6 +186,106 @@ func (lc *LockCoordinator) ReleaseLock(resource, clientID string) (*LockResponse
 	}, nil
 }
 
+// ExtendLock amplía el TTL de un bloqueo activo sin comprobar quién es su
+// dueño. Es una operación de administración: falla si el bloqueo no existe o
+// ya ha expirado.
+func (lc *LockCoordinator) ExtendLock(resource string, additionalSeconds int) (*LockResponse, error) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	lock, exists := lc.locks[resource]
+	if !exists {
+		return &LockResponse{
+			Success: false,
+			Message: "No lock found for this resource",
+			Code:    CodeLockNotFound,
+		}, nil
+	}
+
+	if lc.expired(lock) {
+		return &LockResponse{
+			Success: false,
+			Message: "Lock has already expired",
+			Code:    CodeLockExpired,
+		}, nil
+	}
+
+	extension := time.Duration(additionalSeconds) * time.Second
+	newExpiresAt := lock.ExpiresAt.Add(extension)
+	_, err := lc.collection.UpdateOne(context.Background(),
+		bson.M{"_id": lock.ID},
+		bson.M{"$set": bson.M{"expires_at": newExpiresAt}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update lock in database: %v", err)
+	}
+	lock.ExpiresAt = newExpiresAt
+	if lock.lease > 0 {
+		lock.lease += extension
+	}
+
+	log.Printf("Admin extended lock for resource %s by %ds, now expires at %s",
+		resource, additionalSeconds, newExpiresAt.Format(time.RFC3339))
+
+	return &LockResponse{
+		Success:   true,
+		LockID:    lock.ID,
+		Message:   "Lock extended successfully",
+		ExpiresAt: newExpiresAt.Unix(),
+	}, nil
+}
+
+// RenewLock renueva el bloqueo de su dueño: pasa a expirar ttl segundos
+// después de ahora. Solo se renueva un bloqueo vigente cuyo lockID coincide,
+// para no resucitar uno que ya expiró y quizá se concedió a otro.
+func (lc *LockCoordinator) RenewLock(resource, clientID, lockID string, ttl int) (*LockResponse, error) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	lock, exists := lc.locks[resource]
+	if !exists || lock.ID != lockID {
+		return &LockResponse{
+			Success: false,
+			Message: "No lock with this id: it expired or was released",
+			Code:    CodeLockNotFound,
+		}, nil
+	}
+
+	if lock.ClientID != clientID {
+		return &LockResponse{
+			Success: false,
+			Message: "Lock belongs to a different client",
+			Code:    CodeNotLockOwner,
+		}, nil
+	}
+
+	if lc.expired(lock) {
+		return &LockResponse{
+			Success: false,
+			Message: "Lock has already expired",
+			Code:    CodeLockExpired,
+		}, nil
+	}
+
+	renewed := *lock
+	lc.startLease(&renewed, time.Duration(ttl)*time.Second)
+	_, err := lc.collection.UpdateOne(context.Background(),
+		bson.M{"_id": lock.ID},
+		bson.M{"$set": bson.M{"expires_at": renewed.ExpiresAt}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update lock in database: %v", err)
+	}
+	*lock = renewed
+
+	return &LockResponse{
+		Success:   true,
+		LockID:    lock.ID,
+		Message:   "Lock renewed successfully",
+		ExpiresAt: lock.ExpiresAt.Unix(),
+	}, nil
+}
+
 // GetLockStatus obtiene el estado de un bloqueo
 func (lc *LockCoordinator) GetLockStatus(resource string) (*Lock, bool) {
 	lc.mutex.RLock()
@@ -148,12 +296,15 @@ func (lc *LockCoordinator) GetLockStatus(resource string) (*Lock, bool) {
 		return nil, false
 	}
 
-	if time.Now().After(lock.ExpiresAt) {
+	if lc.expired(lock) {
 		// El bloqueo ha expirado
 		go func() {
 			lc.mutex.Lock()
-			delete(lc.locks, resource)
-			lc.collection.DeleteOne(context.Background(), bson.M{"_id": lock.ID})
+			if current, ok := lc.locks[resource]; ok && current.ID == lock.ID {
+				delete(lc.locks, resource)
+				lc.collection.DeleteOne(context.Background(), bson.M{"_id": lock.ID})
+				lc.handOffLocked(resource)
+			}
 			lc.mutex.Unlock()
 		}()
 		return nil, false
@@ -162,32 +313,12 @@ func (lc *LockCoordinator) GetLockStatus(resource string) (*Lock, bool) {
 	return lock, true
 }
 
-// cleanupExpiredLocks limpia periódicamente los bloqueos expirados
-func (lc *LockCoordinator) cleanupExpiredLocks() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		lc.mutex.Lock()
-		now := time.Now()
-		
-		for resource, lock := range lc.locks {
-			if now.After(lock.ExpiresAt) {
-				delete(lc.locks, resource)
-				lc.collection.DeleteOne(context.Background(), bson.M{"_id": lock.ID})
-				log.Printf("Cleaned up expired lock for resource: %s", resource)
-			}
-		}
-		lc.mutex.Unlock()
-	}
-}
-
 // HTTP Handlers
 
 func (lc *LockCoordinator) handleAcquireLock(w http.ResponseWriter, r *http.Request) {
 	var req LockRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		httperr.WriteDecodeError(w, err)
 		return
 	}
 
@@ -195,9 +326,16 @@ func (lc *LockCoordinator) handleAcquireLock(w http.ResponseWriter, r *http.Requ
 		req.TTL = 300 // Default 5 minutes
 	}
 
-	response, err := lc.AcquireLock(req.Resource, req.ClientID, req.TTL)
+	var response *LockResponse
+	var err error
+	if req.WaitSeconds > 0 {
+		response, err = lc.AcquireLockWait(r.Context(), req.Resource, req.ClientID, req.TTL,
+			time.Duration(req.WaitSeconds)*time.Second)
+	} else {
+		response, err = lc.AcquireLock(req.Resource, req.ClientID, req.TTL)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httperr.Write(w, http.StatusInternalServerError, httperr.CodeInternal, err.Error())
 		return
 	}
 
@@ -209,16 +347,50 @@ func (lc *LockCoordinator) handleReleaseLock(w http.ResponseWriter, r *http.Requ
 	var req struct {
 		Resource string `json:"resource"`
 		ClientID string `json:"client_id"`
+		LockID   string `json:"lock_id"`
 	}
-	
+
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		httperr.WriteDecodeError(w, err)
 		return
 	}
 
-	response, err := lc.ReleaseLock(req.Resource, req.ClientID)
+	if req.LockID == "" {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "lock_id is required")
+		return
+	}
+
+	response, err := lc.ReleaseLock(req.Resource, req.ClientID, req.LockID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httperr.Write(w, http.StatusInternalServerError, httperr.CodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (lc *LockCoordinator) handleRenewLock(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Resource string `json:"resource"`
+		ClientID string `json:"client_id"`
+		LockID   string `json:"lock_id"`
+		TTL      int    `json:"ttl"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	if req.LockID == "" || req.TTL <= 0 {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "lock_id and a positive ttl are required")
+		return
+	}
+
+	response, err := lc.RenewLock(req.Resource, req.ClientID, req.LockID, req.TTL)
+	if err != nil {
+		httperr.Write(w, http.StatusInternalServerError, httperr.CodeInternal, err.Error())
 		return
 	}
 
@@ -231,12 +403,12 @@ func (lc *LockCoordinator) handleGetLockStatus(w http.ResponseWriter, r *http.Re
 	resource := vars["resource"]
 
 	lock, exists := lc.GetLockStatus(resource)
-	
+
 	response := map[string]interface{}{
 		"resource": resource,
 		"locked":   exists,
 	}
-	
+
 	if exists {
 		response["lock"] = lock
 	}
@@ -245,6 +417,55 @@ func (lc *LockCoordinator) handleGetLockStatus(w http.ResponseWriter, r *http.Re
 	json.NewEncoder(w).Encode(response)
 }
 
+// requireAdmin comprueba el token de la cabecera X-Admin-Token. Si no es
+// válido escribe la respuesta de error y devuelve false.
+func (lc *LockCoordinator) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if lc.adminToken == "" {
+		httperr.Write(w, http.StatusForbidden, httperr.CodeAdminDisabled, "Admin endpoints disabled (ADMIN_TOKEN not set)")
+		return false
+	}
+	if r.Header.Get("X-Admin-Token") != lc.adminToken {
+		httperr.Write(w, http.StatusUnauthorized, httperr.CodeUnauthorized, "Invalid admin token")
+		return false
+	}
+	return true
+}
+
+func (lc *LockCoordinator) handleAdminExtend(w http.ResponseWriter, r *http.Request) {
+	if !lc.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Resource          string `json:"resource"`
+		AdditionalSeconds int    `json:"additional_seconds"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	if req.Resource == "" || req.AdditionalSeconds <= 0 {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "resource and a positive additional_seconds are required")
+		return
+	}
+
+	response, err := lc.ExtendLock(req.Resource, req.AdditionalSeconds)
+	if err != nil {
+		httperr.Write(w, http.StatusInternalServerError, httperr.CodeInternal, err.Error())
+		return
+	}
+
+	if !response.Success {
+		httperr.Write(w, http.StatusConflict, response.Code, response.Message)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 func (lc *LockCoordinator) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -268,22 +489,46 @@ func main() {
 	}
 
 	collection := client.Database("locks_db").Collection("locks")
-	
+
 	// Crear coordinador de bloqueos
 	coordinator := NewLockCoordinator(collection)
+	coordinator.adminToken = os.Getenv("ADMIN_TOKEN")
+	idGenerator, err := parseLockIDGenerator(os.Getenv("LOCK_ID_FORMAT"))
+	if err != nil {
+		log.Fatal("Failed to configure lock IDs:", err)
+	}
+	coordinator.newLockID = idGenerator
+	coordinator.expiryGrace, err = parseExpiryGrace(os.Getenv("LOCK_EXPIRY_GRACE_MS"))
+	if err != nil {
+		log.Fatal("Failed to configure lock expiry:", err)
+	}
+	log.Printf("Locks expire by monotonic elapsed time, with a %s grace after their TTL", coordinator.expiryGrace)
+
+	// Iniciar limpieza periódica de bloqueos expirados
+	cleanupInterval, cleanupJitter, err := parseCleanupConfig(os.Getenv("CLEANUP_INTERVAL_S"), os.Getenv("CLEANUP_JITTER"))
+	if err != nil {
+		log.Fatal("Failed to configure lock cleanup:", err)
+	}
+	go coordinator.cleanupExpiredLocks(cleanupInterval, cleanupJitter)
 
 	// Configurar rutas
 	r := mux.NewRouter()
 
-       // ...existing code...
+	// ...existing code...
 
 	r.HandleFunc("/acquire", coordinator.handleAcquireLock).Methods("POST", "OPTIONS")
 	r.HandleFunc("/release", coordinator.handleReleaseLock).Methods("POST", "OPTIONS")
+	r.HandleFunc("/renew", coordinator.handleRenewLock).Methods("POST", "OPTIONS")
 	r.HandleFunc("/status/{resource}", coordinator.handleGetLockStatus).Methods("GET", "OPTIONS")
+	r.HandleFunc("/locks/client/{client_id}", coordinator.handleGetClientLocks).Methods("GET")
 	r.HandleFunc("/health", coordinator.handleHealthCheck).Methods("GET", "OPTIONS")
-
+	r.HandleFunc("/stats", coordinator.handleStats).Methods("GET")
+	r.HandleFunc("/admin/extend", coordinator.handleAdminExtend).Methods("POST")
+	r.HandleFunc("/admin/cleanup", coordinator.handleAdminCleanup).Methods("POST")
+	r.NotFoundHandler = http.HandlerFunc(httperr.NotFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(httperr.MethodNotAllowedHandler)
 
 	port := ":8080"
 	log.Printf("Lock Coordinator starting on port %s", port)
-	log.Fatal(http.ListenAndServe(port, r))
-}
\ No newline at end of file
+	log.Fatal(http.ListenAndServe(port, httperr.WithRequestID(r)))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// sequentialLockIDs genera lock-1, lock-2... para poder nombrar los bloqueos
+func sequentialLockIDs() LockIDGenerator {
+	n := 0
+	return func(resource, clientID string) string {
+		n++
+		return fmt.Sprintf("lock-%d", n)
+	}
+}
+
+func TestReleaseWithStaleLockIDIsRejected(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+		lc := newTestCoordinator(clock)
+		lc.collection = mt.Coll
+		lc.newLockID = sequentialLockIDs()
+
+		mt.AddMockResponses(writeResponse(1))
+		first, err := lc.AcquireLock("asiento-7", "server1", 5)
+		if err != nil || !first.Success {
+			t.Fatalf("first acquire failed: %+v, %v", first, err)
+		}
+
+		// El bloqueo expira y otro proceso que reutiliza el mismo clientID lo
+		// vuelve a obtener: borrado del caducado y alta del nuevo
+		clock.advance(10 * time.Second)
+		mt.AddMockResponses(writeResponse(1), writeResponse(1))
+		second, err := lc.AcquireLock("asiento-7", "server1", 5)
+		if err != nil || !second.Success || second.LockID == first.LockID {
+			t.Fatalf("second acquire failed: %+v, %v", second, err)
+		}
+
+		mt.ClearEvents()
+		resp, err := lc.ReleaseLock("asiento-7", "server1", first.LockID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Success || resp.Code != CodeStaleLockID {
+			t.Fatalf("expected %s, got %+v", CodeStaleLockID, resp)
+		}
+		if lock := lc.locks["asiento-7"]; lock == nil || lock.ID != second.LockID {
+			t.Fatalf("stale release removed the current lock: %+v", lock)
+		}
+		if ev := mt.GetStartedEvent(); ev != nil {
+			t.Fatalf("stale release wrote to MongoDB: %s", ev.CommandName)
+		}
+
+		// Con el LockID vigente sí se libera
+		mt.AddMockResponses(writeResponse(1))
+		resp, err = lc.ReleaseLock("asiento-7", "server1", second.LockID)
+		if err != nil || !resp.Success {
+			t.Fatalf("release with the current lock id failed: %+v, %v", resp, err)
+		}
+		if _, held := lc.locks["asiento-7"]; held {
+			t.Fatal("lock still held after a valid release")
+		}
+	})
+}
+
+func TestReleaseByAnotherClientIsRejected(t *testing.T) {
+	lc := newTestCoordinator(&fakeClock{})
+	lc.locks["asiento-7"] = &Lock{ID: "lock-1", Resource: "asiento-7", ClientID: "server1"}
+
+	resp, err := lc.ReleaseLock("asiento-7", "server2", "lock-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Success || resp.Code != CodeNotLockOwner {
+		t.Fatalf("expected %s, got %+v", CodeNotLockOwner, resp)
+	}
+	if _, held := lc.locks["asiento-7"]; !held {
+		t.Fatal("lock released by a client that does not own it")
+	}
+}
+
+func TestReleaseRequiresLockID(t *testing.T) {
+	lc := newTestCoordinator(&fakeClock{})
+	lc.locks["asiento-7"] = &Lock{ID: "lock-1", Resource: "asiento-7", ClientID: "server1"}
+
+	rec := httptest.NewRecorder()
+	body := `{"resource":"asiento-7","client_id":"server1"}`
+	lc.handleReleaseLock(rec, httptest.NewRequest(http.MethodPost, "/release", strings.NewReader(body)))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	if code := errorCode(t, rec); code != httperr.CodeInvalidRequest {
+		t.Fatalf("expected %s, got %s", httperr.CodeInvalidRequest, code)
+	}
+	if _, held := lc.locks["asiento-7"]; !held {
+		t.Fatal("release without lock_id removed the lock")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestRenewLockExtendsTheLease(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+		lc := newTestCoordinator(clock)
+		lc.collection = mt.Coll
+		lock := &Lock{ID: "lock-1", Resource: "asiento-7", ClientID: "server1"}
+		lc.startLease(lock, 2*time.Second)
+		lc.locks[lock.Resource] = lock
+
+		// Renueva dos veces, cada una antes de expirar: en total pasan 3s
+		for i := 0; i < 2; i++ {
+			clock.advance(1500 * time.Millisecond)
+			mt.AddMockResponses(writeResponse(1))
+			resp, err := lc.RenewLock("asiento-7", "server1", "lock-1", 2)
+			if err != nil || !resp.Success {
+				t.Fatalf("renewal %d failed: %+v, %v", i+1, resp, err)
+			}
+		}
+		if lc.expired(lock) {
+			t.Fatal("renewed lock expired")
+		}
+		if want := clock.Now().Add(2 * time.Second); !lock.ExpiresAt.Equal(want) {
+			t.Fatalf("expected expiry %s, got %s", want, lock.ExpiresAt)
+		}
+	})
+}
+
+func TestRenewLockRejections(t *testing.T) {
+	clock := &fakeClock{wall: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	lc := newTestCoordinator(clock)
+	lock := &Lock{ID: "lock-1", Resource: "asiento-7", ClientID: "server1"}
+	lc.startLease(lock, time.Second)
+	lc.locks[lock.Resource] = lock
+
+	cases := []struct {
+		name, clientID, lockID, code string
+	}{
+		{"stale lock id", "server1", "lock-0", CodeLockNotFound},
+		{"other client", "server2", "lock-1", CodeNotLockOwner},
+	}
+	for _, tc := range cases {
+		resp, err := lc.RenewLock("asiento-7", tc.clientID, tc.lockID, 30)
+		if err != nil || resp.Success || resp.Code != tc.code {
+			t.Errorf("%s: expected %s, got %+v, %v", tc.name, tc.code, resp, err)
+		}
+	}
+
+	clock.advance(time.Second + defaultExpiryGrace + time.Millisecond)
+	resp, err := lc.RenewLock("asiento-7", "server1", "lock-1", 30)
+	if err != nil || resp.Success || resp.Code != CodeLockExpired {
+		t.Fatalf("expected %s, got %+v, %v", CodeLockExpired, resp, err)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// waitPollInterval es cada cuánto un cliente en cola comprueba si el bloqueo
+// que espera ha expirado (la limpieza periódica solo pasa cada 30s)
+const waitPollInterval = 250 * time.Millisecond
+
+// lockWaiter es un cliente esperando en la cola de un recurso
+type lockWaiter struct {
+	clientID   string
+	ttl        int
+	enqueuedAt time.Time
+	granted    chan grantResult // recibe el bloqueo cuando le toca el turno
+}
+
+type grantResult struct {
+	response *LockResponse
+	err      error
+}
+
+// AcquireLockWait intenta adquirir un bloqueo y, si el recurso está ocupado,
+// espera en una cola FIFO hasta que quede libre o pase maxWait. Los clientes
+// que se rinden (o cancelan la petición) cuentan como abandonados.
+func (lc *LockCoordinator) AcquireLockWait(ctx context.Context, resource, clientID string, ttl int, maxWait time.Duration) (*LockResponse, error) {
+	lc.mutex.Lock()
+	if lock, exists := lc.locks[resource]; exists && lc.expired(lock) {
+		delete(lc.locks, resource)
+		lc.collection.DeleteOne(context.Background(), bson.M{"_id": lock.ID})
+		lc.handOffLocked(resource)
+	}
+	if _, busy := lc.locks[resource]; !busy && len(lc.waiters[resource]) == 0 {
+		response, err := lc.grantLocked(resource, clientID, ttl)
+		lc.mutex.Unlock()
+		return response, err
+	}
+	w := &lockWaiter{
+		clientID:   clientID,
+		ttl:        ttl,
+		enqueuedAt: time.Now(),
+		granted:    make(chan grantResult, 1),
+	}
+	lc.waiters[resource] = append(lc.waiters[resource], w)
+	lc.mutex.Unlock()
+
+	timeout := time.NewTimer(maxWait)
+	defer timeout.Stop()
+	poll := time.NewTicker(waitPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case result := <-w.granted:
+			return lc.waitFinished(resource, w, result)
+		case <-poll.C:
+			lc.handOffExpired(resource)
+		case <-timeout.C:
+			return lc.abandonWait(resource, w, CodeWaitTimeout, "Timed out waiting for lock")
+		case <-ctx.Done():
+			response, err := lc.abandonWait(resource, w, CodeWaitCanceled, "Request cancelled while waiting for lock")
+			if err == nil && response.Success {
+				// Nadie va a recibir el bloqueo: devolverlo en lugar de
+				// dejarlo ocupado hasta que expire
+				lc.ReleaseLock(resource, clientID, response.LockID)
+			}
+			return response, err
+		}
+	}
+}
+
+// waitFinished registra la espera de un cliente que ha recibido su turno
+func (lc *LockCoordinator) waitFinished(resource string, w *lockWaiter, result grantResult) (*LockResponse, error) {
+	waited := time.Since(w.enqueuedAt)
+	if result.err == nil && result.response.Success {
+		lc.waitStats.Granted.Observe(waited)
+		log.Printf("Lock for resource %s granted to queued client %s after %s",
+			resource, w.clientID, waited.Round(time.Millisecond))
+	} else {
+		lc.waitStats.Abandoned.Observe(waited)
+	}
+	return result.response, result.err
+}
+
+// abandonWait saca a un cliente de la cola. Si el turno le llegó justo a la
+// vez, se queda con el bloqueo en lugar de abandonar.
+func (lc *LockCoordinator) abandonWait(resource string, w *lockWaiter, code, message string) (*LockResponse, error) {
+	lc.mutex.Lock()
+	queue := lc.waiters[resource]
+	removed := false
+	for i, queued := range queue {
+		if queued == w {
+			lc.setQueueLocked(resource, append(queue[:i], queue[i+1:]...))
+			removed = true
+			break
+		}
+	}
+	lc.mutex.Unlock()
+
+	if !removed {
+		return lc.waitFinished(resource, w, <-w.granted)
+	}
+
+	waited := time.Since(w.enqueuedAt)
+	lc.waitStats.Abandoned.Observe(waited)
+	log.Printf("Client %s abandoned the queue for resource %s after %s",
+		w.clientID, resource, waited.Round(time.Millisecond))
+	return &LockResponse{
+		Success: false,
+		Message: fmt.Sprintf("%s on resource %s", message, resource),
+		Code:    code,
+	}, nil
+}
+
+// handOffExpired elimina el bloqueo del recurso si ha expirado y pasa el
+// turno al siguiente cliente de la cola
+func (lc *LockCoordinator) handOffExpired(resource string) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if lock, exists := lc.locks[resource]; exists && lc.expired(lock) {
+		delete(lc.locks, resource)
+		lc.collection.DeleteOne(context.Background(), bson.M{"_id": lock.ID})
+		log.Printf("Lock for resource %s expired with clients waiting", resource)
+	}
+	lc.handOffLocked(resource)
+}
+
+// handOffLocked concede el recurso, si está libre, al primer cliente de su
+// cola. Devuelve true si el recurso ha quedado bloqueado por un cliente en
+// cola. ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (lc *LockCoordinator) handOffLocked(resource string) bool {
+	if _, busy := lc.locks[resource]; busy {
+		return false
+	}
+	for queue := lc.waiters[resource]; len(queue) > 0; queue = lc.waiters[resource] {
+		next := queue[0]
+		lc.setQueueLocked(resource, queue[1:])
+
+		response, err := lc.grantLocked(resource, next.clientID, next.ttl)
+		next.granted <- grantResult{response: response, err: err}
+		if err == nil && response.Success {
+			return true
+		}
+	}
+	return false
+}
+
+// setQueueLocked sustituye la cola de un recurso, borrándola si queda vacía.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (lc *LockCoordinator) setQueueLocked(resource string, queue []*lockWaiter) {
+	if len(queue) == 0 {
+		delete(lc.waiters, resource)
+		return
+	}
+	lc.waiters[resource] = queue
+}
+
+// waitBucketsMs son los límites superiores (en ms) de los buckets del
+// histograma de espera; el último bucket (+Inf) recoge el resto
+var waitBucketsMs = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// waitSampleWindow es cuántas esperas recientes se guardan para calcular los
+// percentiles
+const waitSampleWindow = 1024
+
+// WaitHistogram acumula duraciones de espera en buckets fijos y guarda las
+// últimas muestras para calcular percentiles exactos sobre ellas
+type WaitHistogram struct {
+	mu      sync.Mutex
+	buckets []uint64 // len(waitBucketsMs)+1, el último es +Inf
+	count   uint64
+	sum     time.Duration
+	max     time.Duration
+	samples []time.Duration // anillo de las últimas waitSampleWindow esperas
+	next    int
+}
+
+func newWaitHistogram() *WaitHistogram {
+	return &WaitHistogram{buckets: make([]uint64, len(waitBucketsMs)+1)}
+}
+
+// Observe registra una espera
+func (h *WaitHistogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ms := float64(d) / float64(time.Millisecond)
+	h.buckets[sort.SearchFloat64s(waitBucketsMs, ms)]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+	if len(h.samples) < waitSampleWindow {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % waitSampleWindow
+	}
+}
+
+// WaitBucket es un bucket del histograma; LeMs vacío significa +Inf
+type WaitBucket struct {
+	LeMs  *float64 `json:"le_ms"`
+	Count uint64   `json:"count"`
+}
+
+// WaitHistogramSnapshot es la vista del histograma que publica /stats. Los
+// percentiles se calculan sobre las últimas waitSampleWindow esperas.
+type WaitHistogramSnapshot struct {
+	Count   uint64       `json:"count"`
+	AvgMs   float64      `json:"avg_ms"`
+	MaxMs   float64      `json:"max_ms"`
+	P50Ms   float64      `json:"p50_ms"`
+	P95Ms   float64      `json:"p95_ms"`
+	P99Ms   float64      `json:"p99_ms"`
+	Buckets []WaitBucket `json:"buckets"`
+}
+
+// Snapshot devuelve una copia del histograma
+func (h *WaitHistogram) Snapshot() WaitHistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snap := WaitHistogramSnapshot{
+		Count:   h.count,
+		MaxMs:   toMs(h.max),
+		Buckets: make([]WaitBucket, len(h.buckets)),
+	}
+	for i, c := range h.buckets {
+		snap.Buckets[i].Count = c
+		if i < len(waitBucketsMs) {
+			le := waitBucketsMs[i]
+			snap.Buckets[i].LeMs = &le
+		}
+	}
+	if h.count > 0 {
+		snap.AvgMs = toMs(h.sum) / float64(h.count)
+	}
+
+	sorted := append([]time.Duration(nil), h.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	snap.P50Ms = percentile(sorted, 0.50)
+	snap.P95Ms = percentile(sorted, 0.95)
+	snap.P99Ms = percentile(sorted, 0.99)
+	return snap
+}
+
+// percentile devuelve, en ms, el percentil p (nearest-rank) de unas
+// muestras ordenadas
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return toMs(sorted[rank])
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// WaitStats separa las esperas que acabaron con el bloqueo concedido de las
+// abandonadas por timeout, que de otro modo sesgarían los percentiles
+type WaitStats struct {
+	Granted   *WaitHistogram
+	Abandoned *WaitHistogram
+}
+
+// NewWaitStats crea estadísticas de espera vacías
+func NewWaitStats() *WaitStats {
+	return &WaitStats{
+		Granted:   newWaitHistogram(),
+		Abandoned: newWaitHistogram(),
+	}
+}
+
+// handleStats publica los histogramas de espera y el estado de las colas
+func (lc *LockCoordinator) handleStats(w http.ResponseWriter, r *http.Request) {
+	lc.mutex.RLock()
+	queued := make(map[string]int, len(lc.waiters))
+	for resource, queue := range lc.waiters {
+		queued[resource] = len(queue)
+	}
+	activeLocks := len(lc.locks)
+	lc.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active_locks":   activeLocks,
+		"queued":         queued,
+		"wait_granted":   lc.waitStats.Granted.Snapshot(),
+		"wait_abandoned": lc.waitStats.Abandoned.Snapshot(),
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestWaitHistogramPercentiles(t *testing.T) {
+	h := newWaitHistogram()
+	for ms := 1; ms <= 100; ms++ {
+		h.Observe(time.Duration(ms) * time.Millisecond)
+	}
+
+	snap := h.Snapshot()
+	if snap.Count != 100 || snap.MaxMs != 100 || snap.AvgMs != 50.5 {
+		t.Fatalf("unexpected count/avg/max: %+v", snap)
+	}
+	if snap.P50Ms != 50 || snap.P95Ms != 95 || snap.P99Ms != 99 {
+		t.Fatalf("expected p50=50 p95=95 p99=99, got %v %v %v", snap.P50Ms, snap.P95Ms, snap.P99Ms)
+	}
+
+	// Buckets no acumulativos: (0,1] (1,5] (5,10] (10,25] (25,50] (50,100]
+	want := []uint64{1, 4, 5, 15, 25, 50}
+	for i, c := range want {
+		if snap.Buckets[i].Count != c {
+			t.Errorf("bucket le=%v: expected %d, got %d", *snap.Buckets[i].LeMs, c, snap.Buckets[i].Count)
+		}
+	}
+	last := snap.Buckets[len(snap.Buckets)-1]
+	if last.LeMs != nil || last.Count != 0 {
+		t.Fatalf("expected an empty +Inf bucket, got %+v", last)
+	}
+}
+
+func TestWaitHistogramOverflowBucket(t *testing.T) {
+	h := newWaitHistogram()
+	h.Observe(2 * time.Minute)
+
+	snap := h.Snapshot()
+	if last := snap.Buckets[len(snap.Buckets)-1]; last.Count != 1 {
+		t.Fatalf("expected the wait in the +Inf bucket, got %+v", last)
+	}
+	if snap.P99Ms != 120000 {
+		t.Fatalf("expected p99=120000ms, got %v", snap.P99Ms)
+	}
+}
+
+func TestWaitHistogramPercentilesUseRecentWindow(t *testing.T) {
+	h := newWaitHistogram()
+	// Esperas antiguas muy largas que el anillo acaba descartando
+	for i := 0; i < waitSampleWindow; i++ {
+		h.Observe(10 * time.Second)
+	}
+	for i := 0; i < waitSampleWindow; i++ {
+		h.Observe(time.Millisecond)
+	}
+
+	snap := h.Snapshot()
+	if snap.Count != 2*waitSampleWindow {
+		t.Fatalf("expected count %d, got %d", 2*waitSampleWindow, snap.Count)
+	}
+	if snap.P99Ms != 1 {
+		t.Fatalf("expected percentiles over the last %d waits, got p99=%v", waitSampleWindow, snap.P99Ms)
+	}
+	if snap.MaxMs != 10000 {
+		t.Fatalf("expected max to cover every wait, got %v", snap.MaxMs)
+	}
+}
+
+func TestEmptyWaitHistogram(t *testing.T) {
+	snap := newWaitHistogram().Snapshot()
+	if snap.Count != 0 || snap.AvgMs != 0 || snap.P99Ms != 0 {
+		t.Fatalf("expected an empty snapshot, got %+v", snap)
+	}
+}
+
+// waitForQueue espera a que haya n clientes en la cola de resource
+func waitForQueue(t *testing.T, lc *LockCoordinator, resource string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		lc.mutex.RLock()
+		queued := len(lc.waiters[resource])
+		lc.mutex.RUnlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d clients queued on %s", n, resource)
+}
+
+func TestQueuedClientWaitIsRecordedAsGranted(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		lc := NewLockCoordinator(mt.Coll)
+		mt.AddMockResponses(writeResponse(1))
+		first, err := lc.AcquireLockWait(context.Background(), "asiento-7", "server1", 30, time.Second)
+		if err != nil || !first.Success {
+			t.Fatalf("first acquire failed: %+v, %v", first, err)
+		}
+
+		done := make(chan *LockResponse, 1)
+		go func() {
+			response, _ := lc.AcquireLockWait(context.Background(), "asiento-7", "server2", 30, 5*time.Second)
+			done <- response
+		}()
+		waitForQueue(t, lc, "asiento-7", 1)
+
+		time.Sleep(20 * time.Millisecond)
+		// Borrado del bloqueo liberado y alta del concedido al de la cola
+		mt.AddMockResponses(writeResponse(1), writeResponse(1))
+		if resp, err := lc.ReleaseLock("asiento-7", "server1", first.LockID); err != nil || !resp.Success {
+			t.Fatalf("release failed: %+v, %v", resp, err)
+		}
+
+		second := <-done
+		if second == nil || !second.Success {
+			t.Fatalf("queued client did not get the lock: %+v", second)
+		}
+		granted := lc.waitStats.Granted.Snapshot()
+		if granted.Count != 1 || granted.MaxMs < 20 {
+			t.Fatalf("expected one granted wait of at least 20ms, got %+v", granted)
+		}
+		if abandoned := lc.waitStats.Abandoned.Snapshot(); abandoned.Count != 0 {
+			t.Fatalf("expected no abandoned waits, got %+v", abandoned)
+		}
+	})
+}
+
+func TestTimedOutWaitIsRecordedAsAbandoned(t *testing.T) {
+	lc := newTestCoordinator(&fakeClock{})
+	lc.clock = newSystemClock()
+	lock := &Lock{ID: "lock-1", Resource: "asiento-7", ClientID: "server1"}
+	lc.startLease(lock, time.Minute)
+	lc.locks[lock.Resource] = lock
+
+	resp, err := lc.AcquireLockWait(context.Background(), "asiento-7", "server2", 30, 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Success || resp.Code != CodeWaitTimeout {
+		t.Fatalf("expected %s, got %+v", CodeWaitTimeout, resp)
+	}
+	if len(lc.waiters) != 0 {
+		t.Fatalf("timed out client still queued: %v", lc.waiters)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// Cancela en cuanto el cliente está en la cola
+		for {
+			lc.mutex.RLock()
+			queued := len(lc.waiters["asiento-7"])
+			lc.mutex.RUnlock()
+			if queued == 1 {
+				cancel()
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	resp, err = lc.AcquireLockWait(ctx, "asiento-7", "server3", 30, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Success || resp.Code != CodeWaitCanceled {
+		t.Fatalf("expected %s, got %+v", CodeWaitCanceled, resp)
+	}
+
+	if granted := lc.waitStats.Granted.Snapshot(); granted.Count != 0 {
+		t.Fatalf("abandoned waits skewed the granted histogram: %+v", granted)
+	}
+	abandoned := lc.waitStats.Abandoned.Snapshot()
+	if abandoned.Count != 2 || abandoned.MaxMs < 30 {
+		t.Fatalf("expected two abandoned waits, the longest over 30ms, got %+v", abandoned)
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	lc := newTestCoordinator(&fakeClock{})
+	lc.locks["asiento-7"] = &Lock{ID: "lock-1", Resource: "asiento-7"}
+	lc.waiters["asiento-7"] = []*lockWaiter{{clientID: "server2"}, {clientID: "server3"}}
+	lc.waitStats.Granted.Observe(40 * time.Millisecond)
+	lc.waitStats.Abandoned.Observe(2 * time.Second)
+
+	rec := httptest.NewRecorder()
+	lc.handleStats(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+	var body struct {
+		ActiveLocks   int                   `json:"active_locks"`
+		Queued        map[string]int        `json:"queued"`
+		WaitGranted   WaitHistogramSnapshot `json:"wait_granted"`
+		WaitAbandoned WaitHistogramSnapshot `json:"wait_abandoned"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ActiveLocks != 1 || body.Queued["asiento-7"] != 2 {
+		t.Fatalf("unexpected lock state: %+v", body)
+	}
+	if body.WaitGranted.Count != 1 || body.WaitGranted.P99Ms != 40 {
+		t.Fatalf("unexpected granted histogram: %+v", body.WaitGranted)
+	}
+	if body.WaitAbandoned.Count != 1 || body.WaitAbandoned.P99Ms != 2000 {
+		t.Fatalf("unexpected abandoned histogram: %+v", body.WaitAbandoned)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reservaStore son las lecturas de asientos y las escrituras de una reserva:
+// su recibo y el asiento. En producción van a MongoDB (mongoReservaStore); las
+// pruebas lo sustituyen por un almacén en memoria que puede fallar o entrar
+// en pánico.
+type reservaStore interface {
+	// CargarAsientos lee todos los asientos guardados
+	CargarAsientos(ctx context.Context) ([]*Asiento, error)
+	// CargarAsiento lee un asiento; devuelve nil si no existe
+	CargarAsiento(ctx context.Context, numero int) (*Asiento, error)
+	GuardarRecibo(ctx context.Context, recibo *Recibo) error
+	BorrarRecibo(ctx context.Context, codigo string) error
+	GuardarAsiento(ctx context.Context, asiento *Asiento) error
+}
+
+// mongoReservaStore escribe en las colecciones del servidor. Guarda el
+// servidor, no las colecciones, porque main asigna rs.recibos después de
+// crearlo.
+type mongoReservaStore struct {
+	rs *ReservationServer
+}
+
+func (s mongoReservaStore) CargarAsientos(ctx context.Context) ([]*Asiento, error) {
+	cursor, err := s.rs.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var asientos []*Asiento
+	for cursor.Next(ctx) {
+		var asiento Asiento
+		if err := cursor.Decode(&asiento); err != nil {
+			continue
+		}
+		asientos = append(asientos, &asiento)
+	}
+	return asientos, cursor.Err()
+}
+
+func (s mongoReservaStore) CargarAsiento(ctx context.Context, numero int) (*Asiento, error) {
+	var asiento Asiento
+	err := s.rs.collection.FindOne(ctx, bson.M{"numero": numero}).Decode(&asiento)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &asiento, nil
+}
+
+func (s mongoReservaStore) GuardarRecibo(ctx context.Context, recibo *Recibo) error {
+	return s.rs.recibos.Guardar(ctx, recibo)
+}
+
+func (s mongoReservaStore) BorrarRecibo(ctx context.Context, codigo string) error {
+	return s.rs.recibos.Borrar(ctx, codigo)
+}
+
+func (s mongoReservaStore) GuardarAsiento(ctx context.Context, asiento *Asiento) error {
+	_, err := s.rs.collection.ReplaceOne(
+		ctx,
+		bson.M{"numero": asiento.Numero},
+		asiento,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
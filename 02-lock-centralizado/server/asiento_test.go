@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// El Asiento de 02 incrusta el modelo compartido; el JSON de la API y el
+// documento de MongoDB tienen que seguir siendo byte a byte los de antes,
+// cuando el servidor declaraba todos los campos, con "precio" siempre
+// presente y el código de confirmación solo en la BD
+func TestAsientoWireFormat(t *testing.T) {
+	updated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	expires := updated.Add(5 * time.Minute)
+
+	for _, tc := range []struct {
+		name    string
+		asiento Asiento
+		json    string
+		bson    string
+	}{
+		{
+			name: "held",
+			asiento: Asiento{
+				AsientoBase: models.AsientoBase{
+					AsientoEstado: models.AsientoEstado{Numero: 7, Cliente: "ana"},
+					ServerID:      "s1",
+					UpdatedAt:     updated,
+				},
+				ExpiresAt:     &expires,
+				RetenidoDesde: &updated,
+				Seccion:       "platea",
+				JuntoAPasillo: true,
+				GrupoCuota:    "prensa",
+				Codigo:        "ABC123",
+				Precio:        12.5,
+			},
+			json: `{"numero":7,"disponible":false,"cliente":"ana","server_id":"s1","updated_at":"2024-01-02T03:04:05Z","expires_at":"2024-01-02T03:09:05Z","retenido_desde":"2024-01-02T03:04:05Z","seccion":"platea","junto_a_pasillo":true,"grupo_cuota":"prensa","precio":12.5}`,
+			bson: "d2000000106e756d65726f000700000008646973706f6e69626c65000002636c69656e74650004000000616e6100027365727665725f6964000300000073310009757064617465645f61740088d820c88c01000009657870697265735f617400686c25c88c01000009726574656e69646f5f64657364650088d820c88c0100000273656363696f6e0007000000706c6174656100086a756e746f5f615f706173696c6c6f000102677275706f5f63756f746100070000007072656e73610002636f6469676f00070000004142433132330000",
+		},
+		{
+			name: "free",
+			asiento: Asiento{AsientoBase: models.AsientoBase{
+				AsientoEstado: models.AsientoEstado{Numero: 3, Disponible: true},
+				ServerID:      "s2",
+				UpdatedAt:     updated,
+			}},
+			json: `{"numero":3,"disponible":true,"server_id":"s2","updated_at":"2024-01-02T03:04:05Z","precio":0}`,
+			bson: "44000000106e756d65726f000300000008646973706f6e69626c650001027365727665725f6964000300000073320009757064617465645f61740088d820c88c01000000",
+		},
+	} {
+		encoded, err := json.Marshal(tc.asiento)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != tc.json {
+			t.Errorf("%s: JSON changed:\n got  %s\n want %s", tc.name, encoded, tc.json)
+		}
+		doc, err := bson.Marshal(tc.asiento)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(doc); got != tc.bson {
+			t.Errorf("%s: BSON changed:\n got  %s\n want %s", tc.name, got, tc.bson)
+		}
+
+		// Un documento guardado antes se sigue leyendo entero
+		var decoded Asiento
+		if err := bson.Unmarshal(doc, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Numero != tc.asiento.Numero || decoded.Cliente != tc.asiento.Cliente ||
+			decoded.Codigo != tc.asiento.Codigo || decoded.Seccion != tc.asiento.Seccion ||
+			!decoded.UpdatedAt.Equal(tc.asiento.UpdatedAt) {
+			t.Errorf("%s: BSON round trip lost fields: %+v", tc.name, decoded)
+		}
+	}
+}
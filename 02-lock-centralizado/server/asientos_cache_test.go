@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// seatDoc es el documento de MongoDB de un asiento
+func seatDoc(numero int, cliente string) bson.D {
+	return bson.D{
+		{Key: "numero", Value: numero},
+		{Key: "disponible", Value: cliente == ""},
+		{Key: "cliente", Value: cliente},
+	}
+}
+
+// nuevoAsiento es un asiento libre, o reservado por cliente si no está vacío
+func nuevoAsiento(numero int, cliente string) Asiento {
+	return Asiento{AsientoBase: models.AsientoBase{AsientoEstado: models.AsientoEstado{
+		Numero: numero, Disponible: cliente == "", Cliente: cliente,
+	}}}
+}
+
+// newCacheTestServer crea un servidor con los asientos 1..total libres, en la
+// caché y en el almacén
+func newCacheTestServer(t *testing.T, total int) (*ReservationServer, *fakeReservaStore) {
+	store := newFakeReservaStore()
+	rs, _ := newTestServer(t, store)
+	rs.asientos = make(map[int]*Asiento, total)
+	for i := 1; i <= total; i++ {
+		asiento := nuevoAsiento(i, "")
+		rs.asientos[i] = &asiento
+		store.asientos[i] = asiento
+	}
+	return rs, store
+}
+
+func TestGetAsientosUpdatesCacheInPlace(t *testing.T) {
+	rs, store := newCacheTestServer(t, 3)
+	before := rs.asientos[2]
+
+	// En la BD el asiento 2 lo reservó otro servidor, el 3 ya no existe y
+	// hay un asiento 4 nuevo
+	store.asientos[2] = nuevoAsiento(2, "ana")
+	delete(store.asientos, 3)
+	store.asientos[4] = nuevoAsiento(4, "")
+	snapshot, err := rs.GetAsientos()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.asientos[2] != before {
+		t.Fatal("GetAsientos replaced the cached seat instead of updating it")
+	}
+	if before.Disponible || before.Cliente != "ana" {
+		t.Fatalf("cached seat 2 was not refreshed: %+v", before)
+	}
+	if _, ok := rs.asientos[3]; ok {
+		t.Fatal("seat 3 is no longer in the database but is still cached")
+	}
+	if _, ok := rs.asientos[4]; !ok {
+		t.Fatal("new seat 4 was not cached")
+	}
+	// El resultado es una copia: modificarlo no toca la caché
+	snapshot[2].Cliente = "luis"
+	if before.Cliente != "ana" {
+		t.Fatal("GetAsientos returned the cached seat instead of a copy")
+	}
+}
+
+// Con -race: refrescos de la caché intercalados con reservas de asientos
+// distintos. Ninguna reserva puede quedarse modificando un asiento que ya
+// no está en el mapa.
+func TestGetAsientosInterleavedWithReservations(t *testing.T) {
+	const total, refreshes = 8, 20
+	rs, store := newCacheTestServer(t, total)
+	pointers := make(map[int]*Asiento, total)
+	for numero, asiento := range rs.asientos {
+		pointers[numero] = asiento
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < refreshes; i++ {
+			if _, err := rs.GetAsientos(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for numero := 1; numero <= total; numero++ {
+		wg.Add(1)
+		go func(numero int) {
+			defer wg.Done()
+			if _, apiErr := rs.reservarAsiento(numero, fmt.Sprintf("cliente-%d", numero), ""); apiErr != nil {
+				t.Errorf("seat %d: %+v", numero, apiErr)
+			}
+		}(numero)
+	}
+	wg.Wait()
+
+	for numero, asiento := range rs.asientos {
+		if pointers[numero] != asiento {
+			t.Fatalf("seat %d was swapped for a new object during the refreshes", numero)
+		}
+	}
+	for numero, asiento := range store.asientos {
+		if asiento.Disponible || asiento.Cliente != fmt.Sprintf("cliente-%d", numero) {
+			t.Fatalf("reservation of seat %d was not saved: %+v", numero, asiento)
+		}
+	}
+}
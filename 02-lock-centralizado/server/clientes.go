@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reputacion guarda el historial de no-shows de un cliente
+type Reputacion struct {
+	Cliente        string     `bson:"_id" json:"cliente"`
+	NoShows        int        `bson:"no_shows" json:"no_shows"`
+	TotalNoShows   int        `bson:"total_no_shows" json:"total_no_shows"`
+	BloqueadoHasta *time.Time `bson:"bloqueado_hasta,omitempty" json:"bloqueado_hasta,omitempty"`
+	UpdatedAt      time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// Bloqueado indica si el cliente no puede reservar en el instante now
+func (r *Reputacion) Bloqueado(now time.Time) bool {
+	return r.BloqueadoHasta != nil && now.Before(*r.BloqueadoHasta)
+}
+
+// ClientStore lleva el registro de no-shows por cliente en MongoDB.
+// Un cliente que acumula Threshold no-shows queda bloqueado durante Cooldown;
+// con Threshold <= 0 nunca se bloquea a nadie.
+type ClientStore struct {
+	collection *mongo.Collection
+	Threshold  int
+	Cooldown   time.Duration
+}
+
+// NewClientStore crea el almacén de reputación de clientes
+func NewClientStore(collection *mongo.Collection, threshold int, cooldown time.Duration) *ClientStore {
+	return &ClientStore{
+		collection: collection,
+		Threshold:  threshold,
+		Cooldown:   cooldown,
+	}
+}
+
+// Get devuelve la reputación del cliente (vacía si nunca tuvo no-shows). Sin
+// almacén ningún cliente tiene no-shows.
+func (cs *ClientStore) Get(ctx context.Context, cliente string) (*Reputacion, error) {
+	if cs == nil {
+		return &Reputacion{Cliente: cliente}, nil
+	}
+	var rep Reputacion
+	err := cs.collection.FindOne(ctx, bson.M{"_id": cliente}).Decode(&rep)
+	if err == mongo.ErrNoDocuments {
+		return &Reputacion{Cliente: cliente}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+// RegistrarNoShow incrementa el contador del cliente y lo bloquea si supera el
+// umbral. Al bloquearlo el contador vuelve a cero para que, pasado el
+// cooldown, empiece de nuevo.
+func (cs *ClientStore) RegistrarNoShow(ctx context.Context, cliente string) (*Reputacion, error) {
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var rep Reputacion
+	err := cs.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": cliente},
+		bson.M{
+			"$inc": bson.M{"no_shows": 1, "total_no_shows": 1},
+			"$set": bson.M{"updated_at": now},
+		},
+		opts,
+	).Decode(&rep)
+	if err != nil {
+		return nil, err
+	}
+
+	if cs.Threshold > 0 && rep.NoShows >= cs.Threshold {
+		hasta := now.Add(cs.Cooldown)
+		_, err := cs.collection.UpdateOne(ctx,
+			bson.M{"_id": cliente},
+			bson.M{"$set": bson.M{"no_shows": 0, "bloqueado_hasta": hasta}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		rep.NoShows = 0
+		rep.BloqueadoHasta = &hasta
+	}
+
+	return &rep, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestReputacionBloqueado(t *testing.T) {
+	now := time.Now()
+	hasta := now.Add(time.Minute)
+	rep := &Reputacion{Cliente: "ana"}
+	if rep.Bloqueado(now) {
+		t.Fatal("client without a block reported as blocked")
+	}
+	rep.BloqueadoHasta = &hasta
+	if !rep.Bloqueado(now) || rep.Bloqueado(hasta) {
+		t.Fatal("block must last until BloqueadoHasta, exclusive")
+	}
+}
+
+func TestClientStoreGetUnknownClient(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		mt.AddMockResponses(findResponse())
+		rep, err := NewClientStore(mt.Coll, 3, time.Minute).Get(context.Background(), "ana")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Cliente != "ana" || rep.NoShows != 0 || rep.Bloqueado(time.Now()) {
+			t.Fatalf("expected an empty reputation, got %+v", rep)
+		}
+	})
+}
+
+func TestRegistrarNoShowBelowThreshold(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		mt.AddMockResponses(findAndModifyResponse(bson.D{
+			{Key: "_id", Value: "ana"}, {Key: "no_shows", Value: 2}, {Key: "total_no_shows", Value: 5},
+		}))
+		rep, err := NewClientStore(mt.Coll, 3, time.Minute).RegistrarNoShow(context.Background(), "ana")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.NoShows != 2 || rep.TotalNoShows != 5 || rep.BloqueadoHasta != nil {
+			t.Fatalf("client below the threshold should not be blocked: %+v", rep)
+		}
+	})
+}
+
+func TestRegistrarNoShowBlocksAtThreshold(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		mt.AddMockResponses(
+			findAndModifyResponse(bson.D{{Key: "_id", Value: "ana"}, {Key: "no_shows", Value: 3}, {Key: "total_no_shows", Value: 3}}),
+			writeResponse(1),
+		)
+		antes := time.Now()
+		rep, err := NewClientStore(mt.Coll, 3, 10*time.Minute).RegistrarNoShow(context.Background(), "ana")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.NoShows != 0 || rep.TotalNoShows != 3 {
+			t.Fatalf("expected the counter to restart after blocking, got %+v", rep)
+		}
+		if !rep.Bloqueado(antes.Add(9*time.Minute)) || rep.Bloqueado(time.Now().Add(10*time.Minute+time.Second)) {
+			t.Fatalf("expected a 10 minute block, got until %v", rep.BloqueadoHasta)
+		}
+
+		mt.GetStartedEvent() // findAndModify
+		update := mt.GetStartedEvent()
+		if update == nil || update.CommandName != "update" {
+			t.Fatalf("expected the block to be saved with an update, got %+v", update)
+		}
+	})
+}
+
+func TestRegistrarNoShowWithoutThresholdNeverBlocks(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		mt.AddMockResponses(findAndModifyResponse(bson.D{{Key: "_id", Value: "ana"}, {Key: "no_shows", Value: 50}}))
+		rep, err := NewClientStore(mt.Coll, 0, time.Minute).RegistrarNoShow(context.Background(), "ana")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.BloqueadoHasta != nil {
+			t.Fatalf("threshold 0 must never block, got %+v", rep)
+		}
+	})
+}
+
+func TestBlockedClientCannotReserve(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		hasta := time.Now().Add(time.Hour)
+		mt.AddMockResponses(findResponse(bson.D{{Key: "_id", Value: "ana"}, {Key: "bloqueado_hasta", Value: hasta}}))
+		rs := &ReservationServer{serverID: "s1", clientes: NewClientStore(mt.Coll, 3, time.Hour)}
+
+		_, apiErr := rs.ReservarAsiento(7, "ana", "")
+		if apiErr == nil || apiErr.Status != http.StatusForbidden || apiErr.Code != CodeClientBlocked {
+			t.Fatalf("expected 403 %s, got %+v", CodeClientBlocked, apiErr)
+		}
+	})
+}
+
+func TestExpiredHoldRecordsNoShow(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		expired := time.Now().Add(-time.Second)
+		mt.AddMockResponses(
+			findAndModifyResponse(bson.D{{Key: "_id", Value: "ana"}, {Key: "no_shows", Value: 1}, {Key: "total_no_shows", Value: 1}}),
+		)
+		// reloadSeat lee del almacén el asiento retenido
+		store := newFakeReservaStore()
+		retenido := nuevoAsiento(7, "ana")
+		retenido.ExpiresAt = &expired
+		store.asientos[7] = retenido
+		rs, coordinator := newTestServer(t, store)
+		rs.clientes = NewClientStore(mt.Coll, 3, time.Hour)
+		rs.holdTimers = make(map[int]*time.Timer)
+
+		rs.expireHold(7, "ana")
+
+		asiento := rs.asientos[7]
+		if !asiento.Disponible || asiento.Cliente != "" || asiento.ExpiresAt != nil {
+			t.Fatalf("expired hold was not released: %+v", asiento)
+		}
+		if saved := store.asientos[7]; !saved.Disponible {
+			t.Fatalf("released seat was not saved: %+v", saved)
+		}
+		if n := coordinator.releasesOf("lock-1"); n != 1 {
+			t.Fatalf("expected the seat lock to be released once, got %d", n)
+		}
+
+		if ev := mt.GetStartedEvent(); ev == nil || ev.CommandName != "findAndModify" {
+			t.Fatalf("expected the no-show to be recorded, got %+v", ev)
+		}
+	})
+}
+
+func TestHandleGetReputacion(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		hasta := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+		mt.AddMockResponses(findResponse(bson.D{
+			{Key: "_id", Value: "ana"}, {Key: "no_shows", Value: 1},
+			{Key: "total_no_shows", Value: 4}, {Key: "bloqueado_hasta", Value: hasta},
+		}))
+		rs := &ReservationServer{serverID: "s1", clientes: NewClientStore(mt.Coll, 3, time.Hour)}
+
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/clientes/ana/reputacion", nil), map[string]string{"id": "ana"})
+		rec := httptest.NewRecorder()
+		rs.handleGetReputacion(rec, req)
+
+		var resp struct {
+			Cliente        string    `json:"cliente"`
+			NoShows        int       `json:"no_shows"`
+			TotalNoShows   int       `json:"total_no_shows"`
+			Bloqueado      bool      `json:"bloqueado"`
+			BloqueadoHasta time.Time `json:"bloqueado_hasta"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK || resp.Cliente != "ana" || resp.NoShows != 1 || resp.TotalNoShows != 4 ||
+			!resp.Bloqueado || !resp.BloqueadoHasta.Equal(hasta) {
+			t.Fatalf("unexpected reputation response %d: %+v", rec.Code, resp)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// candidatosLibres devuelve, de menor a mayor, los asientos libres, solo los
+// de la sección categoria si no está vacía
+func candidatosLibres(asientos map[int]*Asiento, categoria string) []int {
+	var numeros []int
+	for numero, asiento := range asientos {
+		if asiento.Disponible && (categoria == "" || asiento.Seccion == categoria) {
+			numeros = append(numeros, numero)
+		}
+	}
+	sort.Ints(numeros)
+	return numeros
+}
+
+// reservarSiLibre reserva el asiento si sigue libre, con su bloqueo del
+// coordinador y sin esperar a que otro cliente lo suelte. Lo relee de MongoDB
+// dentro del bloqueo, porque la caché no ve las reservas de los demás
+// servidores.
+func (rs *ReservationServer) reservarSiLibre(numero int, cliente string) (*Asiento, *APIError) {
+	var asignado Asiento
+	_, apiErr := rs.withSeatLock(numero, func() (string, *APIError) {
+		if err := rs.reloadSeat(numero); err != nil {
+			return "", newAPIError(http.StatusInternalServerError, CodeDatabaseError, fmt.Sprintf("Error reading seat: %v", err))
+		}
+
+		asiento, exists := rs.asientos[numero]
+		if !exists {
+			return "", newAPIError(http.StatusNotFound, CodeSeatNotFound, "Asiento no existe")
+		}
+		if !asiento.Disponible {
+			return "", newAPIError(http.StatusConflict, CodeSeatTaken, "Asiento ya está ocupado")
+		}
+
+		asiento.Disponible = false
+		asiento.Cliente = cliente
+		asiento.UpdatedAt = time.Now()
+		recibo, apiErr := rs.emitirRecibo(asiento)
+		if apiErr != nil {
+			asiento.Disponible = true
+			asiento.Cliente = ""
+			return "", apiErr
+		}
+		if err := rs.saveSeat(asiento); err != nil {
+			asiento.Disponible = true
+			asiento.Cliente = ""
+			rs.anularRecibo(asiento, recibo)
+			return "", errDatabase(err)
+		}
+		asignado = *asiento
+		return "", nil
+	})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return &asignado, nil
+}
+
+// ReservarCualquiera reserva el asiento libre de número más bajo, dentro de
+// la sección categoria si se indica, y devuelve cuál se asignó.
+//
+// Cada candidato se reserva con reservarSiLibre: si otro cliente tiene ya el
+// bloqueo de un asiento, se pasa al siguiente. Como el asiento se relee
+// dentro del bloqueo, dos llamadas concurrentes, aunque lleguen a servidores
+// distintos, nunca obtienen el mismo asiento.
+func (rs *ReservationServer) ReservarCualquiera(cliente, categoria string) (*Asiento, *APIError) {
+	if apiErr := rs.checkClienteBloqueado(cliente); apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Partir del estado de MongoDB para no saltarse asientos que otro
+	// servidor liberó
+	asientos, err := rs.GetAsientos()
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, CodeDatabaseError, fmt.Sprintf("Error reading seats: %v", err))
+	}
+	candidatos := candidatosLibres(asientos, categoria)
+
+	for _, numero := range candidatos {
+		asignado, apiErr := rs.reservarSiLibre(numero, cliente)
+		if apiErr == nil {
+			log.Printf("Server %s: Seat %d assigned to %s (any seat, category %q)", rs.serverID, numero, cliente, categoria)
+			return asignado, nil
+		}
+		// Otro cliente se adelantó con este asiento: probar el siguiente
+		if apiErr.Code == CodeSeatLocked || apiErr.Code == CodeSeatTaken || apiErr.Code == CodeSeatNotFound {
+			continue
+		}
+		return nil, apiErr
+	}
+
+	message := "No quedan asientos libres"
+	if categoria != "" {
+		message = fmt.Sprintf("No quedan asientos libres en la categoría %s", categoria)
+	}
+	return nil, newAPIError(http.StatusConflict, CodeNoSeatsAvailable, message)
+}
+
+func (rs *ReservationServer) handleReservarCualquiera(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cliente   string `json:"cliente"`
+		Categoria string `json:"categoria"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	if req.Cliente == "" {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Cliente is required")
+		return
+	}
+
+	asiento, apiErr := rs.ReservarCualquiera(req.Cliente, req.Categoria)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "Asiento reservado exitosamente",
+		"numero":    asiento.Numero,
+		"codigo":    asiento.Codigo,
+		"asiento":   asiento,
+		"server_id": rs.serverID,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// newAnySeatServers crea n servidores con el mismo coordinador y el mismo
+// almacén, que hace de base de datos común, y total asientos libres
+func newAnySeatServers(t *testing.T, n, total int) ([]*ReservationServer, *fakeReservaStore) {
+	rs, store := newCacheTestServer(t, total)
+	servers := []*ReservationServer{rs}
+	for i := 2; i <= n; i++ {
+		servers = append(servers, &ReservationServer{
+			serverID:       fmt.Sprintf("s%d", i),
+			coordinatorURL: rs.coordinatorURL,
+			asientos:       make(map[int]*Asiento),
+			activeLocks:    make(map[string]string),
+			claimedLocks:   make(map[string]bool),
+			reservas:       store,
+		})
+	}
+	return servers, store
+}
+
+func TestReservarCualquieraConcurrentCallersGetDistinctSeats(t *testing.T) {
+	const callers, total = 20, 24
+	servers, store := newAnySeatServers(t, 2, total)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		asignado = make(map[int]string) // numero -> cliente
+	)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cliente := fmt.Sprintf("cliente-%d", i)
+			asiento, apiErr := servers[i%len(servers)].ReservarCualquiera(cliente, "")
+			if apiErr != nil {
+				t.Errorf("%s got no seat: %+v", cliente, apiErr)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if otro, dup := asignado[asiento.Numero]; dup {
+				t.Errorf("seat %d assigned to both %s and %s", asiento.Numero, otro, cliente)
+			}
+			asignado[asiento.Numero] = cliente
+		}(i)
+	}
+	wg.Wait()
+
+	if len(asignado) != callers {
+		t.Fatalf("expected %d distinct seats, got %d", callers, len(asignado))
+	}
+	reservados := 0
+	for numero, asiento := range store.asientos {
+		if asiento.Disponible {
+			continue
+		}
+		reservados++
+		if asiento.Cliente != asignado[numero] {
+			t.Fatalf("seat %d saved for %q but assigned to %q", numero, asiento.Cliente, asignado[numero])
+		}
+	}
+	if reservados != callers {
+		t.Fatalf("expected %d seats reserved in the store, got %d", callers, reservados)
+	}
+}
+
+func TestReservarCualquieraPicksTheLowestFreeSeat(t *testing.T) {
+	rs, store := newCacheTestServer(t, 6)
+	// Otro servidor reservó el 1 y el 2; el 3 está en la sección B
+	store.asientos[1] = nuevoAsiento(1, "ana")
+	store.asientos[2] = nuevoAsiento(2, "luis")
+	for numero := 1; numero <= 6; numero++ {
+		asiento := store.asientos[numero]
+		asiento.Seccion = "A"
+		if numero == 3 || numero == 6 {
+			asiento.Seccion = "B"
+		}
+		store.asientos[numero] = asiento
+	}
+
+	asiento, apiErr := rs.ReservarCualquiera("eva", "")
+	if apiErr != nil || asiento.Numero != 3 {
+		t.Fatalf("expected seat 3, got %+v, %+v", asiento, apiErr)
+	}
+	asiento, apiErr = rs.ReservarCualquiera("eva", "A")
+	if apiErr != nil || asiento.Numero != 4 || asiento.Seccion != "A" {
+		t.Fatalf("expected seat 4 in section A, got %+v, %+v", asiento, apiErr)
+	}
+	if asiento, apiErr = rs.ReservarCualquiera("eva", "B"); apiErr != nil || asiento.Numero != 6 {
+		t.Fatalf("expected seat 6 in section B, got %+v, %+v", asiento, apiErr)
+	}
+
+	_, apiErr = rs.ReservarCualquiera("eva", "B")
+	if apiErr == nil || apiErr.Status != http.StatusConflict || apiErr.Code != CodeNoSeatsAvailable {
+		t.Fatalf("expected 409 %s once section B is full, got %+v", CodeNoSeatsAvailable, apiErr)
+	}
+}
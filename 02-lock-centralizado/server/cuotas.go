@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GroupQuota es el máximo de asientos que un grupo puede tener reservados: un
+// número fijo o un porcentaje del total de asientos
+type GroupQuota struct {
+	Value   int
+	Percent bool
+}
+
+// limit devuelve la cuota en asientos para una sala de total asientos
+func (q GroupQuota) limit(total int) int {
+	if q.Percent {
+		return total * q.Value / 100
+	}
+	return q.Value
+}
+
+func (q GroupQuota) String() string {
+	if q.Percent {
+		return fmt.Sprintf("%d%%", q.Value)
+	}
+	return strconv.Itoa(q.Value)
+}
+
+// parseGroupQuotas interpreta GROUP_QUOTAS, p. ej. "estudiantes=30%,prensa=2":
+// los estudiantes pueden reservar hasta el 30% de la sala y la prensa dos
+// asientos. Vacío significa que no hay cuotas.
+func parseGroupQuotas(spec string) (map[string]GroupQuota, error) {
+	quotas := make(map[string]GroupQuota)
+	if strings.TrimSpace(spec) == "" {
+		return quotas, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		grupo, valor, ok := strings.Cut(strings.TrimSpace(part), "=")
+		grupo = strings.TrimSpace(grupo)
+		if !ok || grupo == "" {
+			return nil, fmt.Errorf("invalid quota %q (expected GROUP=N or GROUP=N%%)", part)
+		}
+		if _, dup := quotas[grupo]; dup {
+			return nil, fmt.Errorf("group %q appears more than once", grupo)
+		}
+
+		valor = strings.TrimSpace(valor)
+		quota := GroupQuota{Percent: strings.HasSuffix(valor, "%")}
+		n, err := strconv.Atoi(strings.TrimSuffix(valor, "%"))
+		if err != nil || n < 0 || (quota.Percent && n > 100) {
+			return nil, fmt.Errorf("invalid quota value in %q", part)
+		}
+		quota.Value = n
+		quotas[grupo] = quota
+	}
+	return quotas, nil
+}
+
+// countGroupReserved cuenta en MongoDB los asientos reservados a cuenta del
+// grupo. MongoDB, y no la caché, ve también las reservas de los demás
+// servidores.
+func (rs *ReservationServer) countGroupReserved(grupo string) (int, error) {
+	n, err := rs.collection.CountDocuments(context.Background(),
+		bson.M{"grupo_cuota": grupo, "disponible": false})
+	return int(n), err
+}
+
+// groupLimit devuelve la cuota del grupo en asientos, o false si el grupo no
+// está configurado
+func (rs *ReservationServer) groupLimit(grupo string) (int, bool) {
+	quota, ok := rs.quotas[grupo]
+	if !ok {
+		return 0, false
+	}
+	rs.mutex.RLock()
+	total := len(rs.asientos)
+	rs.mutex.RUnlock()
+	return quota.limit(total), true
+}
+
+// reservarConCuota reserva un asiento a cuenta de un grupo sin pasarse de su
+// cuota. El recuento se hace con el bloqueo del grupo en el coordinador, que
+// se toma siempre antes que el del asiento: dos reservas del mismo grupo,
+// aunque lleguen a servidores distintos, no pueden ver el mismo recuento y
+// ocupar entre las dos el último hueco.
+func (rs *ReservationServer) reservarConCuota(numero int, cliente, grupo string) (*Recibo, *APIError) {
+	limite, ok := rs.groupLimit(grupo)
+	if !ok {
+		return nil, newAPIError(http.StatusBadRequest, CodeUnknownGroup,
+			fmt.Sprintf("Grupo de cuota desconocido: %s", grupo))
+	}
+
+	var recibo *Recibo
+	_, apiErr := rs.withCoordinatorLock("quota_"+grupo, func() (string, *APIError) {
+		reservados, err := rs.countGroupReserved(grupo)
+		if err != nil {
+			return "", newAPIError(http.StatusInternalServerError, CodeDatabaseError,
+				fmt.Sprintf("Error counting group seats: %v", err))
+		}
+		if reservados >= limite {
+			log.Printf("Server %s: Quota of group %s exhausted (%d/%d), rejecting seat %d for %s",
+				rs.serverID, grupo, reservados, limite, numero, cliente)
+			return "", newAPIError(http.StatusConflict, CodeGroupQuotaExceeded,
+				fmt.Sprintf("Cuota de grupo excedida: %s ya tiene %d de %d asientos", grupo, reservados, limite))
+		}
+		var apiErr *APIError
+		recibo, apiErr = rs.reservarAsiento(numero, cliente, grupo)
+		return "", apiErr
+	})
+	return recibo, apiErr
+}
+
+// CuotaGrupo es el uso de la cuota de un grupo que publica /cuotas
+type CuotaGrupo struct {
+	Grupo      string `json:"grupo"`
+	Cuota      string `json:"cuota"`
+	Limite     int    `json:"limite"`
+	Reservados int    `json:"reservados"`
+	Restantes  int    `json:"restantes"`
+}
+
+// handleGetCuotas muestra, por grupo, su cuota y cuántos asientos ocupa
+func (rs *ReservationServer) handleGetCuotas(w http.ResponseWriter, r *http.Request) {
+	grupos := make([]string, 0, len(rs.quotas))
+	for grupo := range rs.quotas {
+		grupos = append(grupos, grupo)
+	}
+	sort.Strings(grupos)
+
+	cuotas := make([]CuotaGrupo, 0, len(grupos))
+	for _, grupo := range grupos {
+		limite, _ := rs.groupLimit(grupo)
+		reservados, err := rs.countGroupReserved(grupo)
+		if err != nil {
+			httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to count group seats")
+			return
+		}
+		restantes := limite - reservados
+		if restantes < 0 {
+			// La cuota se redujo después de que el grupo reservara
+			restantes = 0
+		}
+		cuotas = append(cuotas, CuotaGrupo{
+			Grupo:      grupo,
+			Cuota:      rs.quotas[grupo].String(),
+			Limite:     limite,
+			Reservados: reservados,
+			Restantes:  restantes,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cuotas":    cuotas,
+		"server_id": rs.serverID,
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// countResponse es la respuesta a un CountDocuments que cuenta n documentos
+func countResponse(n int) bson.D {
+	return findResponse(bson.D{{Key: "n", Value: n}})
+}
+
+func TestGroupQuotaRejectsReservationOverQuota(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, store := newCacheTestServer(t, 5)
+		rs.collection = mt.Coll
+		rs.quotas = map[string]GroupQuota{"prensa": {Value: 2}}
+
+		for i, numero := range []int{1, 2} {
+			mt.AddMockResponses(countResponse(i))
+			if _, apiErr := rs.ReservarAsiento(numero, "periodista", "prensa"); apiErr != nil {
+				t.Fatalf("reservation %d within the quota failed: %+v", numero, apiErr)
+			}
+		}
+
+		mt.AddMockResponses(countResponse(2))
+		_, apiErr := rs.ReservarAsiento(3, "periodista", "prensa")
+		if apiErr == nil || apiErr.Status != http.StatusConflict || apiErr.Code != CodeGroupQuotaExceeded {
+			t.Fatalf("expected 409 %s, got %+v", CodeGroupQuotaExceeded, apiErr)
+		}
+		if asiento := store.asientos[3]; !asiento.Disponible {
+			t.Fatalf("seat 3 was reserved over the quota: %+v", asiento)
+		}
+		for _, numero := range []int{1, 2} {
+			if asiento := store.asientos[numero]; asiento.GrupoCuota != "prensa" {
+				t.Fatalf("seat %d not counted for the group: %+v", numero, asiento)
+			}
+		}
+
+		// El recuento filtra por grupo y solo cuenta asientos ocupados
+		for ev := mt.GetStartedEvent(); ev != nil; ev = mt.GetStartedEvent() {
+			match := ev.Command.Lookup("pipeline", "0", "$match")
+			if grupo := match.Document().Lookup("grupo_cuota").StringValue(); grupo != "prensa" {
+				t.Fatalf("count filtered by group %q", grupo)
+			}
+			if match.Document().Lookup("disponible").Boolean() {
+				t.Fatal("count included free seats")
+			}
+		}
+
+		// Fuera de la cuota el asiento sigue siendo reservable
+		if _, apiErr := rs.ReservarAsiento(3, "ana", ""); apiErr != nil {
+			t.Fatalf("reservation without a group failed: %+v", apiErr)
+		}
+	})
+}
+
+func TestGroupQuotaUnknownGroup(t *testing.T) {
+	rs, _ := newCacheTestServer(t, 5)
+	rs.quotas = map[string]GroupQuota{"prensa": {Value: 2}}
+
+	_, apiErr := rs.ReservarAsiento(1, "ana", "vip")
+	if apiErr == nil || apiErr.Status != http.StatusBadRequest || apiErr.Code != CodeUnknownGroup {
+		t.Fatalf("expected 400 %s, got %+v", CodeUnknownGroup, apiErr)
+	}
+}
+
+func TestHandleGetCuotas(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, _ := newCacheTestServer(t, 10)
+		rs.collection = mt.Coll
+		rs.quotas = map[string]GroupQuota{"prensa": {Value: 2}, "estudiantes": {Value: 30, Percent: true}}
+
+		// Por orden alfabético: estudiantes y prensa
+		mt.AddMockResponses(countResponse(1), countResponse(3))
+		rec := httptest.NewRecorder()
+		rs.handleGetCuotas(rec, httptest.NewRequest(http.MethodGet, "/cuotas", nil))
+
+		var body struct {
+			Cuotas []CuotaGrupo `json:"cuotas"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		want := []CuotaGrupo{
+			{Grupo: "estudiantes", Cuota: "30%", Limite: 3, Reservados: 1, Restantes: 2},
+			// La cuota se redujo por debajo de lo ya reservado
+			{Grupo: "prensa", Cuota: "2", Limite: 2, Reservados: 3, Restantes: 0},
+		}
+		if len(body.Cuotas) != len(want) {
+			t.Fatalf("expected %d groups, got %+v", len(want), body.Cuotas)
+		}
+		for i := range want {
+			if body.Cuotas[i] != want[i] {
+				t.Errorf("expected %+v, got %+v", want[i], body.Cuotas[i])
+			}
+		}
+	})
+}
+
+func TestParseGroupQuotas(t *testing.T) {
+	quotas, err := parseGroupQuotas(" estudiantes=30% , prensa=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := quotas["estudiantes"]; !q.Percent || q.limit(20) != 6 {
+		t.Fatalf("unexpected students quota: %+v", q)
+	}
+	if q := quotas["prensa"]; q.Percent || q.limit(20) != 2 {
+		t.Fatalf("unexpected press quota: %+v", q)
+	}
+
+	for _, spec := range []string{"prensa", "=2", "prensa=x", "prensa=-1", "prensa=101%", "a=1,a=2"} {
+		if _, err := parseGroupQuotas(spec); err == nil {
+			t.Errorf("parseGroupQuotas(%q) accepted an invalid spec", spec)
+		}
+	}
+}
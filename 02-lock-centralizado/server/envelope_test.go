@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// envelopeOf comprueba que la respuesta es exactamente
+// {"error":{"code","message","request_id"}} y devuelve su contenido
+func envelopeOf(t *testing.T, rec *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected a JSON error, got Content-Type %q", ct)
+	}
+	var raw map[string]map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&raw); err != nil {
+		t.Fatalf("decoding error envelope: %v", err)
+	}
+	if len(raw) != 1 || raw["error"] == nil {
+		t.Fatalf("expected only an error object, got %v", raw)
+	}
+	var keys []string
+	for key := range raw["error"] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if want := []string{"code", "message", "request_id"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expected error fields %v, got %v", want, keys)
+	}
+	return raw["error"]
+}
+
+func TestErrorEnvelopeForConflictAndNotFound(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, _ := newTestServer(t, newFakeReservaStore())
+		rs.clientes = NewClientStore(mt.Coll, 3, time.Hour)
+		rs.asientos[7].Disponible = false
+		rs.asientos[7].Cliente = "ana"
+		handler := httperr.WithRequestID(http.HandlerFunc(rs.handleReservarAsiento))
+
+		cases := []struct {
+			body, requestID string
+			status          int
+			code            string
+		}{
+			{`{"numero":7,"cliente":"luis"}`, "req-conflict", http.StatusConflict, CodeSeatTaken},
+			{`{"numero":99,"cliente":"luis"}`, "req-missing", http.StatusNotFound, CodeSeatNotFound},
+		}
+		for _, tc := range cases {
+			// El cliente no tiene reputación guardada
+			mt.AddMockResponses(findResponse())
+			req := httptest.NewRequest(http.MethodPost, "/reservar", strings.NewReader(tc.body))
+			req.Header.Set(httperr.RequestIDHeader, tc.requestID)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Fatalf("%s: expected %d, got %d", tc.code, tc.status, rec.Code)
+			}
+			env := envelopeOf(t, rec)
+			if env["code"] != tc.code || env["message"] == "" || env["request_id"] != tc.requestID {
+				t.Fatalf("expected %s with request id %s, got %v", tc.code, tc.requestID, env)
+			}
+		}
+	})
+}
+
+func TestUnknownRouteUsesTheEnvelope(t *testing.T) {
+	rec := httptest.NewRecorder()
+	httperr.WithRequestID(http.HandlerFunc(httperr.NotFoundHandler)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nada", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+	env := envelopeOf(t, rec)
+	// Sin X-Request-ID el servidor genera uno y lo devuelve también en la cabecera
+	if env["code"] != httperr.CodeNotFound || env["request_id"] == "" || env["request_id"] != rec.Header().Get(httperr.RequestIDHeader) {
+		t.Fatalf("unexpected envelope %v (header %q)", env, rec.Header().Get(httperr.RequestIDHeader))
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Códigos de error propios del servidor; los comunes están en shared/httperr
+const (
+	CodeMaintenance = "MAINTENANCE"
+
+	CodeSeatNotFound           = "SEAT_NOT_FOUND"
+	CodeSeatTaken              = "SEAT_TAKEN"
+	CodeSeatAlreadyFree        = "SEAT_ALREADY_FREE"
+	CodeSeatLocked             = "SEAT_LOCKED"
+	CodeNoContiguousSeats      = "NO_CONTIGUOUS_SEATS"
+	CodeNoSeatsAvailable       = "NO_SEATS_AVAILABLE"
+	CodeHoldNotFound           = "HOLD_NOT_FOUND"
+	CodeHoldExpired            = "HOLD_EXPIRED"
+	CodeNotHoldOwner           = "NOT_HOLD_OWNER"
+	CodeClientBlocked          = "CLIENT_BLOCKED"
+	CodeUnknownGroup           = "UNKNOWN_GROUP"
+	CodeGroupQuotaExceeded     = "GROUP_QUOTA_EXCEEDED"
+	CodeReceiptNotFound        = "RECEIPT_NOT_FOUND"
+	CodeCoordinatorUnavailable = "COORDINATOR_UNAVAILABLE"
+	CodeDatabaseError          = "DATABASE_ERROR"
+)
+
+// APIError es el fallo de una operación sobre asientos, con el estado HTTP y
+// el código con que se responde al cliente
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// errCoordinator es el fallo de comunicación con el coordinador de bloqueos
+func errCoordinator(err error) *APIError {
+	return newAPIError(http.StatusServiceUnavailable, CodeCoordinatorUnavailable,
+		fmt.Sprintf("Error acquiring lock: %v", err))
+}
+
+// errLockDenied es la respuesta cuando el coordinador no concede el bloqueo
+func errLockDenied(lockResp *LockResponse) *APIError {
+	return newAPIError(http.StatusConflict, CodeSeatLocked, lockResp.Message)
+}
+
+// errDatabase es un fallo al escribir en MongoDB
+func errDatabase(err error) *APIError {
+	return newAPIError(http.StatusInternalServerError, CodeDatabaseError,
+		fmt.Sprintf("Error updating database: %v", err))
+}
+
+// writeAPIError escribe un *APIError con httperr.Write
+func writeAPIError(w http.ResponseWriter, apiErr *APIError) {
+	httperr.Write(w, apiErr.Status, apiErr.Code, apiErr.Message)
+}
+
+// recoverPanics convierte el pánico de un handler en un 500 con el sobre de
+// error común, en lugar de cortar la conexión sin respuesta. Los bloqueos
+// del coordinador se liberan en sus propios defer.
+func (rs *ReservationServer) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			log.Printf("Server %s: PANIC in %s %s (request %s): %v\n%s",
+				rs.serverID, r.Method, r.URL.Path, w.Header().Get(httperr.RequestIDHeader), p, debug.Stack())
+			httperr.Write(w, http.StatusInternalServerError, httperr.CodeInternal, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
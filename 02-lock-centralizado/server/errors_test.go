@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// decodeErrorCase es un cuerpo que no se puede interpretar y el código y el
+// comienzo del mensaje con que se responde
+type decodeErrorCase struct {
+	name, body, code, message string
+}
+
+// decodeErrorCases devuelve los cuerpos inválidos de una ruta; wrongType
+// tiene un campo de la ruta con un tipo que no le corresponde
+func decodeErrorCases(wrongType string) []decodeErrorCase {
+	return []decodeErrorCase{
+		{"empty body", "", httperr.CodeEmptyBody, "Request body is empty"},
+		{"truncated JSON", `{"numero":`, httperr.CodeInvalidJSON, "Malformed JSON: unexpected end of body"},
+		{"malformed JSON", `{"numero":x}`, httperr.CodeInvalidJSON, "Malformed JSON at byte"},
+		{"wrong field type", wrongType, httperr.CodeInvalidFieldType, "Field "},
+	}
+}
+
+func TestHandlersReportDecodeErrors(t *testing.T) {
+	rs := &ReservationServer{serverID: "s1"}
+	handlers := []struct {
+		path      string
+		handler   http.HandlerFunc
+		wrongType string
+	}{
+		{"/reservar", rs.handleReservarAsiento, `{"cliente":7}`},
+		{"/liberar", rs.handleLiberarAsiento, `{"numero":"7"}`},
+		{"/retener", rs.handleRetenerAsiento, `{"cliente":7}`},
+		{"/confirmar", rs.handleConfirmarAsiento, `{"cliente":7}`},
+		{"/extender", rs.handleExtender, `{"cliente":7}`},
+		{"/reservar-cualquiera", rs.handleReservarCualquiera, `{"cliente":7}`},
+		{"/reservar-preferencia", rs.handleReservarPreferencia, `{"cliente":7}`},
+		{"/sesion/heartbeat", rs.handleHeartbeat, `{"cliente":7}`},
+	}
+
+	for _, h := range handlers {
+		for _, tc := range decodeErrorCases(h.wrongType) {
+			rec := httptest.NewRecorder()
+			h.handler(rec, httptest.NewRequest(http.MethodPost, h.path, strings.NewReader(tc.body)))
+
+			var resp httperr.Response
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("%s %s: %v", h.path, tc.name, err)
+			}
+			if rec.Code != http.StatusBadRequest || resp.Error.Code != tc.code || !strings.HasPrefix(resp.Error.Message, tc.message) {
+				t.Errorf("%s %s: %d %s %q, want 400 %s %q...", h.path, tc.name,
+					rec.Code, resp.Error.Code, resp.Error.Message, tc.code, tc.message)
+			}
+		}
+	}
+}
+
+// Las rutas de administración comprueban el token antes de leer el cuerpo
+func TestAdminHandlersReportDecodeErrors(t *testing.T) {
+	rs := &ReservationServer{serverID: "s1", adminToken: "token"}
+	for _, h := range []struct {
+		path      string
+		handler   http.HandlerFunc
+		wrongType string
+	}{
+		{"/liberar-rango", rs.handleLiberarRango, `{"desde":"1"}`},
+		{"/admin/precio", rs.handleAdminPrecio, `{"precio":"caro"}`},
+	} {
+		for _, tc := range decodeErrorCases(h.wrongType) {
+			req := httptest.NewRequest(http.MethodPost, h.path, strings.NewReader(tc.body))
+			req.Header.Set("X-Admin-Token", "token")
+			rec := httptest.NewRecorder()
+			h.handler(rec, req)
+
+			var resp httperr.Response
+			json.NewDecoder(rec.Body).Decode(&resp)
+			if rec.Code != http.StatusBadRequest || resp.Error.Code != tc.code {
+				t.Errorf("%s %s: %d %s, want 400 %s", h.path, tc.name, rec.Code, resp.Error.Code, tc.code)
+			}
+		}
+	}
+}
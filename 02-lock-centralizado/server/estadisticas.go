@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Histórico de estadísticas para paneles: cada STATS_ROLLUP_S el servidor
+// recuenta los asientos desde MongoDB y guarda un resumen en stats_history,
+// con las reservas hechas desde el resumen anterior. /stats/history devuelve
+// la serie. Los recuentos son de toda la sala y las reservas las de todos los
+// servidores; cada resumen lleva el server_id de quien lo calculó.
+
+// seccionSinNombre agrupa los asientos sin sección (sin SEAT_LAYOUT)
+const seccionSinNombre = "sin_seccion"
+
+// maxStatsHistory es el máximo de resúmenes que devuelve /stats/history
+const maxStatsHistory = 10000
+
+// StatsRollup es el resumen de la sala en un instante
+type StatsRollup struct {
+	Timestamp  time.Time             `bson:"timestamp" json:"timestamp"`
+	ServerID   string                `bson:"server_id" json:"server_id"`
+	Asientos   SeatCounts            `bson:"asientos" json:"asientos"`
+	PorSeccion map[string]SeatCounts `bson:"por_seccion" json:"por_seccion"`
+	// Reservas hechas entre Desde (el resumen anterior) y Timestamp, y su
+	// ritmo por minuto
+	Desde       time.Time `bson:"desde" json:"desde"`
+	Reservas    int64     `bson:"reservas" json:"reservas"`
+	ReservasMin float64   `bson:"reservas_por_minuto" json:"reservas_por_minuto"`
+}
+
+// buildRollup resume los asientos y las reservas hechas entre desde y now
+func buildRollup(serverID string, asientos []Asiento, reservas int64, desde, now time.Time) StatsRollup {
+	rollup := StatsRollup{
+		Timestamp:  now,
+		ServerID:   serverID,
+		PorSeccion: make(map[string]SeatCounts),
+		Desde:      desde,
+		Reservas:   reservas,
+	}
+	for _, asiento := range asientos {
+		seccion := asiento.Seccion
+		if seccion == "" {
+			seccion = seccionSinNombre
+		}
+		counts := rollup.PorSeccion[seccion]
+		counts.Total++
+		rollup.Asientos.Total++
+		if asiento.Disponible {
+			counts.Disponibles++
+			rollup.Asientos.Disponibles++
+		} else {
+			counts.Reservados++
+			rollup.Asientos.Reservados++
+		}
+		rollup.PorSeccion[seccion] = counts
+	}
+	if minutos := now.Sub(desde).Minutes(); minutos > 0 {
+		rollup.ReservasMin = float64(reservas) / minutos
+	}
+	return rollup
+}
+
+// StatsHistory guarda los resúmenes en MongoDB
+type StatsHistory struct {
+	collection *mongo.Collection
+}
+
+// NewStatsHistory crea el histórico sobre la colección indicada
+func NewStatsHistory(collection *mongo.Collection) *StatsHistory {
+	return &StatsHistory{collection: collection}
+}
+
+// Guardar añade un resumen al histórico
+func (h *StatsHistory) Guardar(ctx context.Context, rollup StatsRollup) error {
+	_, err := h.collection.InsertOne(ctx, rollup)
+	return err
+}
+
+// Ultimo devuelve el último resumen de serverID, o nil si no hay ninguno
+func (h *StatsHistory) Ultimo(ctx context.Context, serverID string) (*StatsRollup, error) {
+	var rollup StatsRollup
+	opts := options.FindOne().SetSort(bson.M{"timestamp": -1})
+	err := h.collection.FindOne(ctx, bson.M{"server_id": serverID}, opts).Decode(&rollup)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rollup, nil
+}
+
+// Serie devuelve los resúmenes entre desde y hasta, ambos incluidos y
+// opcionales (cero = sin límite), en orden cronológico. Con serverID solo
+// los de ese servidor.
+func (h *StatsHistory) Serie(ctx context.Context, desde, hasta time.Time, serverID string) ([]StatsRollup, error) {
+	filter := bson.M{}
+	rango := bson.M{}
+	if !desde.IsZero() {
+		rango["$gte"] = desde
+	}
+	if !hasta.IsZero() {
+		rango["$lte"] = hasta
+	}
+	if len(rango) > 0 {
+		filter["timestamp"] = rango
+	}
+	if serverID != "" {
+		filter["server_id"] = serverID
+	}
+
+	opts := options.Find().SetSort(bson.M{"timestamp": 1}).SetLimit(maxStatsHistory)
+	cursor, err := h.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	serie := []StatsRollup{}
+	if err := cursor.All(ctx, &serie); err != nil {
+		return nil, err
+	}
+	return serie, nil
+}
+
+// ContarDesde cuenta los recibos emitidos después de desde y hasta hasta,
+// incluido
+func (s *ReciboStore) ContarDesde(ctx context.Context, desde, hasta time.Time) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{"reservado_en": bson.M{"$gt": desde, "$lte": hasta}})
+}
+
+// RollupStats recuenta la sala desde la BD, guarda el resumen con las
+// reservas hechas desde desde y lo devuelve
+func (rs *ReservationServer) RollupStats(ctx context.Context, desde time.Time) (*StatsRollup, error) {
+	now := time.Now()
+	opts := options.Find().SetProjection(bson.M{"numero": 1, "disponible": 1, "seccion": 1})
+	cursor, err := rs.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var asientos []Asiento
+	if err := cursor.All(ctx, &asientos); err != nil {
+		return nil, err
+	}
+
+	reservas, err := rs.recibos.ContarDesde(ctx, desde, now)
+	if err != nil {
+		return nil, err
+	}
+
+	rollup := buildRollup(rs.serverID, asientos, reservas, desde, now)
+	if err := rs.estadisticas.Guardar(ctx, rollup); err != nil {
+		return nil, err
+	}
+	return &rollup, nil
+}
+
+// runStatsRollup guarda un resumen cada intervalo. El primero cuenta las
+// reservas desde el último resumen que este servidor guardó antes de
+// reiniciar, o desde el arranque si no hay ninguno.
+func (rs *ReservationServer) runStatsRollup(intervalo time.Duration) {
+	desde := time.Now()
+	if ultimo, err := rs.estadisticas.Ultimo(context.Background(), rs.serverID); err != nil {
+		log.Printf("Server %s: Could not read the last stats rollup: %v", rs.serverID, err)
+	} else if ultimo != nil {
+		desde = ultimo.Timestamp
+	}
+
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), intervalo)
+		rollup, err := rs.RollupStats(ctx, desde)
+		cancel()
+		if err != nil {
+			// El siguiente resumen cubrirá también este intervalo
+			log.Printf("Server %s: Stats rollup failed: %v", rs.serverID, err)
+			continue
+		}
+		desde = rollup.Timestamp
+	}
+}
+
+// parseStatsTime interpreta un límite de /stats/history en RFC 3339; vacío
+// es sin límite
+func parseStatsTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+// handleStatsHistory devuelve los resúmenes entre ?desde= y ?hasta= (RFC
+// 3339, opcionales), y solo los de ?server_id= si se indica
+func (rs *ReservationServer) handleStatsHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	desde, err := parseStatsTime(query.Get("desde"))
+	if err != nil {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "desde must be an RFC 3339 timestamp")
+		return
+	}
+	hasta, err := parseStatsTime(query.Get("hasta"))
+	if err != nil {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "hasta must be an RFC 3339 timestamp")
+		return
+	}
+	if !desde.IsZero() && !hasta.IsZero() && hasta.Before(desde) {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "hasta must not be before desde")
+		return
+	}
+
+	serie, err := rs.estadisticas.Serie(r.Context(), desde, hasta, query.Get("server_id"))
+	if err != nil {
+		writeAPIError(w, errDatabase(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"count":     len(serie),
+		"history":   serie,
+		"server_id": rs.serverID,
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// seccionDoc es el documento de MongoDB de un asiento de la sección indicada
+func seccionDoc(numero int, seccion string, disponible bool) bson.D {
+	return bson.D{{Key: "numero", Value: numero}, {Key: "disponible", Value: disponible}, {Key: "seccion", Value: seccion}}
+}
+
+func TestRollupStatsWritesHistoryDocument(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs := &ReservationServer{
+			serverID:     "s1",
+			collection:   mt.Coll,
+			recibos:      NewReciboStore(mt.Coll),
+			estadisticas: NewStatsHistory(mt.Coll),
+		}
+		desde := time.Now().Add(-2 * time.Minute)
+
+		mt.AddMockResponses(
+			findResponse(
+				seccionDoc(1, "A", false), seccionDoc(2, "A", true), seccionDoc(3, "A", false),
+				seccionDoc(4, "B", true), seccionDoc(5, "", false),
+			),
+			findResponse(bson.D{{Key: "n", Value: 6}}), // recibos desde el resumen anterior
+			writeResponse(1),
+		)
+		rollup, err := rs.RollupStats(context.Background(), desde)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mt.GetStartedEvent() // asientos
+		count := mt.GetStartedEvent()
+		rango := count.Command.Lookup("pipeline", "0", "$match", "reservado_en").Document()
+		if !rango.Lookup("$gt").Time().Equal(desde.Truncate(time.Millisecond)) {
+			t.Fatalf("reservations counted from %v, expected %v", rango.Lookup("$gt"), desde)
+		}
+
+		insert := mt.GetStartedEvent()
+		if insert == nil || insert.CommandName != "insert" {
+			t.Fatalf("expected the rollup to be inserted, got %+v", insert)
+		}
+		doc := insert.Command.Lookup("documents", "0").Document()
+		counts := func(path ...string) SeatCounts {
+			v := doc.Lookup(path...).Document()
+			return SeatCounts{
+				Total:       int(v.Lookup("total").AsInt64()),
+				Disponibles: int(v.Lookup("disponibles").AsInt64()),
+				Reservados:  int(v.Lookup("reservados").AsInt64()),
+			}
+		}
+		if got := counts("asientos"); got != (SeatCounts{Total: 5, Disponibles: 2, Reservados: 3}) {
+			t.Fatalf("unexpected seat counts %+v", got)
+		}
+		if got := counts("por_seccion", "A"); got != (SeatCounts{Total: 3, Disponibles: 1, Reservados: 2}) {
+			t.Fatalf("unexpected counts for section A %+v", got)
+		}
+		if got := counts("por_seccion", "B"); got != (SeatCounts{Total: 1, Disponibles: 1}) {
+			t.Fatalf("unexpected counts for section B %+v", got)
+		}
+		if got := counts("por_seccion", seccionSinNombre); got != (SeatCounts{Total: 1, Reservados: 1}) {
+			t.Fatalf("unexpected counts for seats without a section %+v", got)
+		}
+		if doc.Lookup("server_id").StringValue() != "s1" || doc.Lookup("reservas").AsInt64() != 6 {
+			t.Fatalf("unexpected rollup document %v", doc)
+		}
+		// 6 reservas en algo más de 2 minutos
+		if rollup.ReservasMin <= 2.9 || rollup.ReservasMin > 3 {
+			t.Fatalf("expected about 3 reservations per minute, got %v", rollup.ReservasMin)
+		}
+	})
+}
+
+func TestBuildRollupWithoutElapsedTime(t *testing.T) {
+	now := time.Now()
+	rollup := buildRollup("s1", nil, 4, now, now)
+	if rollup.ReservasMin != 0 || rollup.Asientos.Total != 0 || len(rollup.PorSeccion) != 0 {
+		t.Fatalf("unexpected rollup %+v", rollup)
+	}
+}
+
+func TestHandleStatsHistory(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs := &ReservationServer{serverID: "s1", estadisticas: NewStatsHistory(mt.Coll)}
+		ts := time.Date(2026, 3, 14, 20, 0, 0, 0, time.UTC)
+		mt.AddMockResponses(findResponse(bson.D{
+			{Key: "timestamp", Value: ts},
+			{Key: "server_id", Value: "s2"},
+			{Key: "asientos", Value: bson.D{{Key: "total", Value: 10}, {Key: "disponibles", Value: 4}, {Key: "reservados", Value: 6}}},
+			{Key: "reservas", Value: 2},
+		}))
+
+		rec := httptest.NewRecorder()
+		url := "/stats/history?desde=2026-03-14T19:00:00Z&hasta=2026-03-14T21:00:00Z&server_id=s2"
+		rs.handleStatsHistory(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var resp struct {
+			Count   int           `json:"count"`
+			History []StatsRollup `json:"history"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Count != 1 || !resp.History[0].Timestamp.Equal(ts) || resp.History[0].Asientos.Reservados != 6 {
+			t.Fatalf("unexpected history %+v", resp)
+		}
+
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		if filter.Lookup("server_id").StringValue() != "s2" {
+			t.Fatalf("history not filtered by server: %v", filter)
+		}
+		rango := filter.Lookup("timestamp").Document()
+		if !rango.Lookup("$gte").Time().Equal(ts.Add(-time.Hour)) || !rango.Lookup("$lte").Time().Equal(ts.Add(time.Hour)) {
+			t.Fatalf("history not filtered by time: %v", rango)
+		}
+	})
+}
+
+func TestHandleStatsHistoryRejectsInvalidRanges(t *testing.T) {
+	rs := &ReservationServer{serverID: "s1"}
+	for _, query := range []string{"desde=ayer", "hasta=2026-13-01", "desde=2026-03-14T21:00:00Z&hasta=2026-03-14T20:00:00Z"} {
+		rec := httptest.NewRecorder()
+		rs.handleStatsHistory(rec, httptest.NewRequest(http.MethodGet, "/stats/history?"+query, nil))
+		if rec.Code != http.StatusBadRequest || errorCode(t, rec) != httperr.CodeInvalidRequest {
+			t.Errorf("%s: expected 400 %s, got %d", query, httperr.CodeInvalidRequest, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Una retención se puede ampliar con /extender mientras el cliente termina el
+// pago, pero nunca más allá de HOLD_MAX_S desde que se retuvo el asiento. No
+// tiene que ver con /renew del coordinador: aquí se alarga la retención del
+// asiento, no el bloqueo con que se modifica.
+
+// Prorroga es el resultado de ampliar una retención
+type Prorroga struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	// Recortada indica que no se concedió todo lo pedido por el máximo
+	Recortada bool `json:"recortada"`
+}
+
+// prorrogarRetencion calcula el nuevo vencimiento de la retención de cliente
+// sobre el asiento, con adicional más de tiempo y como mucho maximo desde que
+// empezó. Nunca adelanta el vencimiento actual.
+func prorrogarRetencion(asiento *Asiento, cliente string, adicional, maximo time.Duration, now time.Time) (Prorroga, *APIError) {
+	if asiento.Disponible || asiento.ExpiresAt == nil {
+		return Prorroga{}, newAPIError(http.StatusConflict, CodeHoldNotFound, "No hay una retención sobre el asiento")
+	}
+	if asiento.Cliente != cliente {
+		return Prorroga{}, newAPIError(http.StatusForbidden, CodeNotHoldOwner, "La retención es de otro cliente")
+	}
+	if now.After(*asiento.ExpiresAt) {
+		return Prorroga{}, newAPIError(http.StatusConflict, CodeHoldExpired, "La retención ya expiró")
+	}
+
+	desde := asiento.UpdatedAt
+	if asiento.RetenidoDesde != nil {
+		desde = *asiento.RetenidoDesde
+	}
+	limite := desde.Add(maximo)
+
+	prorroga := Prorroga{ExpiresAt: asiento.ExpiresAt.Add(adicional)}
+	if prorroga.ExpiresAt.After(limite) {
+		prorroga.ExpiresAt = limite
+		prorroga.Recortada = true
+	}
+	if prorroga.ExpiresAt.Before(*asiento.ExpiresAt) {
+		prorroga.ExpiresAt = *asiento.ExpiresAt
+	}
+	return prorroga, nil
+}
+
+// ExtenderRetencion amplía la retención que cliente tiene sobre el asiento.
+// El estado se lee de MongoDB con el bloqueo tomado, porque la retención pudo
+// hacerse o confirmarse en otro servidor.
+func (rs *ReservationServer) ExtenderRetencion(numero int, cliente string, adicional time.Duration) (Prorroga, *APIError) {
+	var prorroga Prorroga
+	_, apiErr := rs.withSeatLock(numero, func() (string, *APIError) {
+		if err := rs.reloadSeat(numero); err != nil {
+			return "", newAPIError(http.StatusInternalServerError, CodeDatabaseError, fmt.Sprintf("Error reading seat: %v", err))
+		}
+		asiento, exists := rs.asientos[numero]
+		if !exists {
+			return "", newAPIError(http.StatusNotFound, CodeSeatNotFound, "Asiento no existe")
+		}
+
+		var apiErr *APIError
+		if prorroga, apiErr = prorrogarRetencion(asiento, cliente, adicional, rs.holdMax, time.Now()); apiErr != nil {
+			return "", apiErr
+		}
+		if prorroga.ExpiresAt.Equal(*asiento.ExpiresAt) {
+			return "", nil
+		}
+
+		expiresAt := asiento.ExpiresAt
+		updatedAt := asiento.UpdatedAt
+		asiento.ExpiresAt = &prorroga.ExpiresAt
+		asiento.UpdatedAt = time.Now()
+		if err := rs.saveSeat(asiento); err != nil {
+			asiento.ExpiresAt = expiresAt
+			asiento.UpdatedAt = updatedAt
+			return "", errDatabase(err)
+		}
+
+		// Si la retención se hizo en otro servidor, su temporizador verá el
+		// nuevo vencimiento al dispararse y se volverá a programar
+		rs.armHoldTimer(numero, cliente, prorroga.ExpiresAt)
+		log.Printf("Server %s: Hold on seat %d by %s extended until %s", rs.serverID, numero, cliente, prorroga.ExpiresAt.Format(time.RFC3339))
+		return "", nil
+	})
+	return prorroga, apiErr
+}
+
+func (rs *ReservationServer) handleExtender(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Numero              int    `json:"numero"`
+		Codigo              string `json:"codigo"`
+		Cliente             string `json:"cliente"`
+		SegundosAdicionales int    `json:"segundos_adicionales"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	if req.Cliente == "" {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Cliente is required")
+		return
+	}
+	if (req.Numero == 0) == (req.Codigo == "") {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Either numero or codigo is required")
+		return
+	}
+	if req.SegundosAdicionales <= 0 {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "segundos_adicionales must be positive")
+		return
+	}
+
+	numero := req.Numero
+	if req.Codigo != "" {
+		recibo, err := rs.recibos.Get(r.Context(), req.Codigo)
+		if err != nil {
+			httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to get receipt")
+			return
+		}
+		if recibo == nil {
+			httperr.Write(w, http.StatusNotFound, CodeReceiptNotFound, "No existe una reserva con ese código")
+			return
+		}
+		numero = recibo.Numero
+	}
+
+	prorroga, apiErr := rs.ExtenderRetencion(numero, req.Cliente, time.Duration(req.SegundosAdicionales)*time.Second)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"numero":     numero,
+		"expires_at": prorroga.ExpiresAt,
+		"recortada":  prorroga.Recortada,
+		"max_hold_s": int(rs.holdMax / time.Second),
+		"server_id":  rs.serverID,
+	})
+}
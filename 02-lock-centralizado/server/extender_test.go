@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// retener deja el asiento retenido por cliente desde desde hasta expira, en la
+// caché y en el almacén
+func retener(rs *ReservationServer, store *fakeReservaStore, numero int, cliente string, desde, expira time.Time) {
+	asiento := nuevoAsiento(numero, cliente)
+	asiento.RetenidoDesde = &desde
+	asiento.ExpiresAt = &expira
+	asiento.UpdatedAt = desde
+	rs.asientos[numero] = &asiento
+	store.asientos[numero] = asiento
+}
+
+// newExtenderTestServer crea un servidor con HOLD_MAX_S de holdMax y para sus
+// temporizadores de retención al acabar el test
+func newExtenderTestServer(t *testing.T, holdMax time.Duration) (*ReservationServer, *fakeReservaStore) {
+	rs, store := newCacheTestServer(t, 3)
+	rs.holdMax = holdMax
+	rs.holdTimers = make(map[int]*time.Timer)
+	t.Cleanup(func() {
+		rs.mutex.Lock()
+		defer rs.mutex.Unlock()
+		for numero := range rs.holdTimers {
+			rs.stopHoldTimer(numero)
+		}
+	})
+	return rs, store
+}
+
+func TestExtenderPushesExpiryForward(t *testing.T) {
+	rs, store := newExtenderTestServer(t, 10*time.Minute)
+	now := time.Now()
+	expira := now.Add(time.Minute)
+	retener(rs, store, 2, "ana", now.Add(-time.Minute), expira)
+
+	body := `{"numero":2,"cliente":"ana","segundos_adicionales":120}`
+	rec := httptest.NewRecorder()
+	rs.handleExtender(rec, httptest.NewRequest(http.MethodPost, "/extender", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		ExpiresAt time.Time `json:"expires_at"`
+		Recortada bool      `json:"recortada"`
+		MaxHoldS  int       `json:"max_hold_s"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := expira.Add(2 * time.Minute)
+	if !resp.ExpiresAt.Equal(want) || resp.Recortada || resp.MaxHoldS != 600 {
+		t.Fatalf("expected expiry %v unclamped, got %+v", want, resp)
+	}
+	if saved := store.asientos[2]; saved.ExpiresAt == nil || !saved.ExpiresAt.Equal(want) {
+		t.Fatalf("new expiry was not saved: %+v", saved)
+	}
+	if _, armed := rs.holdTimers[2]; !armed {
+		t.Fatal("hold timer was not rearmed for the new expiry")
+	}
+}
+
+func TestExtenderIsClampedToTheMaximumHold(t *testing.T) {
+	rs, store := newExtenderTestServer(t, 5*time.Minute)
+	now := time.Now()
+	desde := now.Add(-4 * time.Minute)
+	retener(rs, store, 2, "ana", desde, now.Add(30*time.Second))
+
+	prorroga, apiErr := rs.ExtenderRetencion(2, "ana", 10*time.Minute)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	limite := desde.Add(5 * time.Minute)
+	if !prorroga.Recortada || !prorroga.ExpiresAt.Equal(limite) {
+		t.Fatalf("expected the extension clamped to %v, got %+v", limite, prorroga)
+	}
+	if saved := store.asientos[2]; !saved.ExpiresAt.Equal(limite) {
+		t.Fatalf("clamped expiry was not saved: %+v", saved)
+	}
+
+	// Ya en el máximo, otra prórroga no cambia nada
+	prorroga, apiErr = rs.ExtenderRetencion(2, "ana", time.Minute)
+	if apiErr != nil || !prorroga.Recortada || !prorroga.ExpiresAt.Equal(limite) {
+		t.Fatalf("expected the expiry to stay at the maximum, got %+v, %+v", prorroga, apiErr)
+	}
+}
+
+func TestExtenderRejectsAnotherClient(t *testing.T) {
+	rs, store := newExtenderTestServer(t, 10*time.Minute)
+	now := time.Now()
+	expira := now.Add(time.Minute)
+	retener(rs, store, 2, "ana", now, expira)
+
+	_, apiErr := rs.ExtenderRetencion(2, "luis", time.Minute)
+	if apiErr == nil || apiErr.Status != http.StatusForbidden || apiErr.Code != CodeNotHoldOwner {
+		t.Fatalf("expected 403 %s, got %+v", CodeNotHoldOwner, apiErr)
+	}
+	if saved := store.asientos[2]; !saved.ExpiresAt.Equal(expira) {
+		t.Fatalf("another client's request moved the expiry: %+v", saved)
+	}
+}
+
+func TestProrrogarRetencionWithoutAValidHold(t *testing.T) {
+	now := time.Now()
+	libre := nuevoAsiento(1, "")
+	if _, apiErr := prorrogarRetencion(&libre, "ana", time.Minute, time.Hour, now); apiErr == nil || apiErr.Code != CodeHoldNotFound {
+		t.Fatalf("expected %s for a free seat, got %+v", CodeHoldNotFound, apiErr)
+	}
+
+	// Reservado en firme: ocupado pero sin vencimiento
+	confirmado := nuevoAsiento(1, "ana")
+	if _, apiErr := prorrogarRetencion(&confirmado, "ana", time.Minute, time.Hour, now); apiErr == nil || apiErr.Code != CodeHoldNotFound {
+		t.Fatalf("expected %s for a confirmed seat, got %+v", CodeHoldNotFound, apiErr)
+	}
+
+	vencida := nuevoAsiento(1, "ana")
+	expira := now.Add(-time.Second)
+	vencida.ExpiresAt = &expira
+	if _, apiErr := prorrogarRetencion(&vencida, "ana", time.Minute, time.Hour, now); apiErr == nil || apiErr.Code != CodeHoldExpired {
+		t.Fatalf("expected %s for an expired hold, got %+v", CodeHoldExpired, apiErr)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"github.com/sincronizacion-distribuida/shared/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// fakeCoordinator concede cada recurso a un único cliente a la vez, sin cola,
+// y cuenta las liberaciones de cada bloqueo
+type fakeCoordinator struct {
+	*httptest.Server
+	mu       sync.Mutex
+	granted  int
+	held     map[string]string // resource -> lockID
+	releases map[string]int    // lockID -> liberaciones recibidas
+}
+
+func newFakeCoordinator(t *testing.T) *fakeCoordinator {
+	c := &fakeCoordinator{held: make(map[string]string), releases: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/acquire", func(w http.ResponseWriter, r *http.Request) {
+		var req LockRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, busy := c.held[req.Resource]; busy {
+			json.NewEncoder(w).Encode(LockResponse{Success: false, Message: "Resource " + req.Resource + " is already locked"})
+			return
+		}
+		c.granted++
+		lockID := fmt.Sprintf("lock-%d", c.granted)
+		c.held[req.Resource] = lockID
+		json.NewEncoder(w).Encode(LockResponse{Success: true, LockID: lockID})
+	})
+	mux.HandleFunc("/release", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Resource string `json:"resource"`
+			LockID   string `json:"lock_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		c.mu.Lock()
+		c.releases[req.LockID]++
+		if c.held[req.Resource] == req.LockID {
+			delete(c.held, req.Resource)
+		}
+		c.mu.Unlock()
+		json.NewEncoder(w).Encode(LockResponse{Success: true})
+	})
+	c.Server = httptest.NewServer(mux)
+	t.Cleanup(c.Close)
+	return c
+}
+
+// releasesOf devuelve cuántas veces se liberó el bloqueo
+func (c *fakeCoordinator) releasesOf(lockID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.releases[lockID]
+}
+
+// fakeReservaStore guarda recibos y asientos en memoria y puede fallar o
+// entrar en pánico en las escrituras que se le indiquen. Hace de base de
+// datos compartida cuando varios servidores usan el mismo almacén.
+type fakeReservaStore struct {
+	mu       sync.Mutex
+	recibos  map[string]*Recibo
+	asientos map[int]Asiento // último estado guardado de cada asiento
+	// Escrituras de asiento que entran en pánico, empezando por la siguiente
+	panicAsiento int
+	panicRecibo  bool
+	// Error de las escrituras de asiento (nil = se guardan)
+	errAsiento error
+}
+
+func newFakeReservaStore() *fakeReservaStore {
+	return &fakeReservaStore{recibos: make(map[string]*Recibo), asientos: make(map[int]Asiento)}
+}
+
+func (s *fakeReservaStore) CargarAsientos(ctx context.Context) ([]*Asiento, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	asientos := make([]*Asiento, 0, len(s.asientos))
+	for _, asiento := range s.asientos {
+		copia := asiento
+		asientos = append(asientos, &copia)
+	}
+	return asientos, nil
+}
+
+func (s *fakeReservaStore) CargarAsiento(ctx context.Context, numero int) (*Asiento, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	asiento, ok := s.asientos[numero]
+	if !ok {
+		return nil, nil
+	}
+	return &asiento, nil
+}
+
+func (s *fakeReservaStore) GuardarRecibo(ctx context.Context, recibo *Recibo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.panicRecibo {
+		panic("receipt store exploded")
+	}
+	s.recibos[recibo.Codigo] = recibo
+	return nil
+}
+
+func (s *fakeReservaStore) BorrarRecibo(ctx context.Context, codigo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.recibos, codigo)
+	return nil
+}
+
+func (s *fakeReservaStore) GuardarAsiento(ctx context.Context, asiento *Asiento) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.panicAsiento > 0 {
+		s.panicAsiento--
+		panic("seat store exploded")
+	}
+	if s.errAsiento != nil {
+		return s.errAsiento
+	}
+	s.asientos[asiento.Numero] = *asiento
+	return nil
+}
+
+// newTestServer crea un servidor sin MongoDB con el asiento 7 libre, el coordinador
+// falso y el almacén indicado
+func newTestServer(t *testing.T, store *fakeReservaStore) (*ReservationServer, *fakeCoordinator) {
+	coordinator := newFakeCoordinator(t)
+	rs := &ReservationServer{
+		serverID:       "s1",
+		coordinatorURL: coordinator.URL,
+		asientos: map[int]*Asiento{
+			7: {AsientoBase: models.AsientoBase{AsientoEstado: models.AsientoEstado{Numero: 7, Disponible: true}}},
+		},
+		activeLocks:  make(map[string]string),
+		claimedLocks: make(map[string]bool),
+		reservas:     store,
+	}
+	return rs, coordinator
+}
+
+// withMockMongo ejecuta fn con un cliente de MongoDB simulado: cada orden que
+// envía el driver consume, en orden, una de las respuestas añadidas con
+// mt.AddMockResponses
+func withMockMongo(t *testing.T, fn func(mt *mtest.T)) {
+	t.Helper()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	mt.Run("mock", fn)
+}
+
+// findResponse es la respuesta a un Find o FindOne que devuelve docs
+func findResponse(docs ...bson.D) bson.D {
+	return mtest.CreateCursorResponse(0, "test.coll", mtest.FirstBatch, docs...)
+}
+
+// findAndModifyResponse es la respuesta a un FindOneAndUpdate que devuelve doc
+func findAndModifyResponse(doc bson.D) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: doc})
+}
+
+// writeResponse es la respuesta a una escritura que afectó a n documentos
+func writeResponse(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+// adminPost envía un POST con cuerpo JSON y, si token no está vacío,
+// X-Admin-Token
+func adminPost(handler http.HandlerFunc, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("X-Admin-Token", token)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// errorCode devuelve el código del sobre de error de la respuesta
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp httperr.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+	return resp.Error.Code
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// withSeatLock ejecuta fn con el bloqueo del coordinador para el asiento y el
+// mutex local tomados, liberando ambos al terminar
+func (rs *ReservationServer) withSeatLock(numero int, fn func() (string, *APIError)) (string, *APIError) {
+	return rs.withCoordinatorLock(fmt.Sprintf("seat_%d", numero), func() (string, *APIError) {
+		rs.mutex.Lock()
+		defer rs.mutex.Unlock()
+		return fn()
+	})
+}
+
+// withCoordinatorLock ejecuta fn con el bloqueo del coordinador sobre
+// resource tomado, liberándolo al terminar
+func (rs *ReservationServer) withCoordinatorLock(resource string, fn func() (string, *APIError)) (string, *APIError) {
+	lockResp, err := rs.acquireLock(resource, 30)
+	if err != nil {
+		return "", errCoordinator(err)
+	}
+
+	if !lockResp.Success {
+		return "", errLockDenied(lockResp)
+	}
+
+	rs.locksMutex.Lock()
+	rs.activeLocks[resource] = lockResp.LockID
+	rs.locksMutex.Unlock()
+
+	defer rs.lockReleaser(resource, lockResp.LockID, rs.startRenewal(resource, lockResp.LockID, 30))()
+
+	return fn()
+}
+
+// lockReleaser devuelve la función que deja de renovar el bloqueo y lo
+// libera en el coordinador. Solo actúa la primera vez que se llama, y libera
+// el bloqueo aunque parar la renovación entre en pánico.
+func (rs *ReservationServer) lockReleaser(resource, lockID string, renewal *leaseRenewer) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			defer func() {
+				rs.releaseLock(resource, lockID)
+				rs.locksMutex.Lock()
+				delete(rs.activeLocks, resource)
+				rs.locksMutex.Unlock()
+			}()
+			renewal.Stop()
+		})
+	}
+}
+
+// saveSeat persiste el asiento en MongoDB. Debe llamarse con rs.mutex tomado.
+func (rs *ReservationServer) saveSeat(asiento *Asiento) error {
+	return rs.reservas.GuardarAsiento(context.Background(), asiento)
+}
+
+// checkClienteBloqueado devuelve un error si el cliente está bloqueado por
+// acumular no-shows
+func (rs *ReservationServer) checkClienteBloqueado(cliente string) *APIError {
+	rep, err := rs.clientes.Get(context.Background(), cliente)
+	if err != nil {
+		// Si no podemos consultar la reputación no bloqueamos la reserva
+		log.Printf("Server %s: Error reading reputation for %s: %v", rs.serverID, cliente, err)
+		return nil
+	}
+	if rep.Bloqueado(time.Now()) {
+		return newAPIError(http.StatusForbidden, CodeClientBlocked,
+			fmt.Sprintf("Cliente bloqueado por no-shows hasta %s", rep.BloqueadoHasta.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// RetenerAsiento reserva un asiento de forma provisional. Si el cliente no la
+// confirma antes de que pase duracion, el asiento se libera y se le anota un
+// no-show.
+func (rs *ReservationServer) RetenerAsiento(numero int, cliente string, duracion time.Duration) (string, *APIError) {
+	if apiErr := rs.checkClienteBloqueado(cliente); apiErr != nil {
+		return "", apiErr
+	}
+
+	return rs.withSeatLock(numero, func() (string, *APIError) {
+		asiento, exists := rs.asientos[numero]
+		if !exists {
+			return "", newAPIError(http.StatusNotFound, CodeSeatNotFound, "Asiento no existe")
+		}
+
+		if !asiento.Disponible {
+			return "", newAPIError(http.StatusConflict, CodeSeatTaken, "Asiento ya está ocupado")
+		}
+
+		now := time.Now()
+		expiresAt := now.Add(duracion)
+		asiento.Disponible = false
+		asiento.Cliente = cliente
+		asiento.ExpiresAt = &expiresAt
+		asiento.RetenidoDesde = &now
+		asiento.UpdatedAt = now
+
+		if err := rs.saveSeat(asiento); err != nil {
+			// Revertir cambios en caso de error
+			asiento.Disponible = true
+			asiento.Cliente = ""
+			asiento.ExpiresAt = nil
+			asiento.RetenidoDesde = nil
+			return "", errDatabase(err)
+		}
+
+		rs.armHoldTimer(numero, cliente, expiresAt)
+		log.Printf("Server %s: Seat %d held by %s until %s", rs.serverID, numero, cliente, expiresAt.Format(time.RFC3339))
+		return "Asiento retenido, confirme antes de que expire", nil
+	})
+}
+
+// ConfirmarAsiento convierte una retención en una reserva definitiva y
+// devuelve su recibo
+func (rs *ReservationServer) ConfirmarAsiento(numero int, cliente string) (*Recibo, *APIError) {
+	var recibo *Recibo
+	_, apiErr := rs.withSeatLock(numero, func() (string, *APIError) {
+		asiento, exists := rs.asientos[numero]
+		if !exists {
+			return "", newAPIError(http.StatusNotFound, CodeSeatNotFound, "Asiento no existe")
+		}
+
+		if asiento.Disponible || asiento.Cliente != cliente || asiento.ExpiresAt == nil {
+			return "", newAPIError(http.StatusConflict, CodeHoldNotFound, "No hay una retención de este cliente sobre el asiento")
+		}
+
+		if time.Now().After(*asiento.ExpiresAt) {
+			return "", newAPIError(http.StatusConflict, CodeHoldExpired, "La retención ya expiró")
+		}
+
+		expiresAt := asiento.ExpiresAt
+		retenidoDesde := asiento.RetenidoDesde
+		updatedAt := asiento.UpdatedAt
+		asiento.ExpiresAt = nil
+		asiento.RetenidoDesde = nil
+		asiento.UpdatedAt = time.Now()
+
+		var apiErr *APIError
+		if recibo, apiErr = rs.emitirRecibo(asiento); apiErr != nil {
+			asiento.ExpiresAt = expiresAt
+			asiento.RetenidoDesde = retenidoDesde
+			asiento.UpdatedAt = updatedAt
+			return "", apiErr
+		}
+
+		if err := rs.saveSeat(asiento); err != nil {
+			asiento.ExpiresAt = expiresAt
+			asiento.RetenidoDesde = retenidoDesde
+			asiento.UpdatedAt = updatedAt
+			rs.anularRecibo(asiento, recibo)
+			return "", errDatabase(err)
+		}
+
+		rs.stopHoldTimer(numero)
+		log.Printf("Server %s: Seat %d confirmed by %s (code %s)", rs.serverID, numero, cliente, recibo.Codigo)
+		return "", nil
+	})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return recibo, nil
+}
+
+// armHoldTimer programa la expiración de una retención. Debe llamarse con
+// rs.mutex tomado.
+func (rs *ReservationServer) armHoldTimer(numero int, cliente string, expiresAt time.Time) {
+	if timer, exists := rs.holdTimers[numero]; exists {
+		timer.Stop()
+	}
+	rs.holdTimers[numero] = time.AfterFunc(time.Until(expiresAt), func() {
+		rs.expireHold(numero, cliente)
+	})
+}
+
+// stopHoldTimer cancela la expiración pendiente de un asiento. Debe llamarse
+// con rs.mutex tomado.
+func (rs *ReservationServer) stopHoldTimer(numero int) {
+	if timer, exists := rs.holdTimers[numero]; exists {
+		timer.Stop()
+		delete(rs.holdTimers, numero)
+	}
+}
+
+// rearmHolds programa de nuevo las retenciones guardadas en MongoDB, p. ej.
+// tras un reinicio. Las que vencieron mientras el servidor estaba caído se
+// liberan en el acto.
+func (rs *ReservationServer) rearmHolds() {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	now := time.Now()
+	pending, expired := 0, 0
+	for numero, asiento := range rs.asientos {
+		if asiento.Disponible || asiento.ExpiresAt == nil {
+			continue
+		}
+		if asiento.ExpiresAt.After(now) {
+			pending++
+		} else {
+			expired++
+		}
+		// Con un instante ya pasado el temporizador dispara enseguida
+		rs.armHoldTimer(numero, asiento.Cliente, *asiento.ExpiresAt)
+	}
+
+	if pending+expired > 0 {
+		log.Printf("Server %s: Re-armed %d pending holds, releasing %d expired holds", rs.serverID, pending, expired)
+	}
+}
+
+// reloadSeat actualiza la caché de un asiento desde MongoDB. Debe llamarse
+// con rs.mutex tomado.
+func (rs *ReservationServer) reloadSeat(numero int) error {
+	asiento, err := rs.reservas.CargarAsiento(context.Background(), numero)
+	if err != nil {
+		return err
+	}
+	if asiento == nil {
+		delete(rs.asientos, numero)
+		return nil
+	}
+	if existing, ok := rs.asientos[numero]; ok {
+		*existing = *asiento
+	} else {
+		rs.asientos[numero] = asiento
+	}
+	return nil
+}
+
+// expireHold libera una retención no confirmada y anota el no-show del cliente
+func (rs *ReservationServer) expireHold(numero int, cliente string) {
+	noShow := false
+	_, apiErr := rs.withSeatLock(numero, func() (string, *APIError) {
+		delete(rs.holdTimers, numero)
+
+		// Otro servidor pudo confirmar o liberar la retención: decidir con
+		// el estado de MongoDB y no con la caché
+		if err := rs.reloadSeat(numero); err != nil {
+			return "", newAPIError(http.StatusInternalServerError, CodeDatabaseError, fmt.Sprintf("Error reading seat: %v", err))
+		}
+
+		asiento, exists := rs.asientos[numero]
+		if !exists || asiento.Disponible || asiento.Cliente != cliente || asiento.ExpiresAt == nil {
+			// Ya se confirmó o se liberó por otra vía
+			return "", nil
+		}
+
+		if time.Now().Before(*asiento.ExpiresAt) {
+			// La retención se amplió mientras esperábamos
+			rs.armHoldTimer(numero, cliente, *asiento.ExpiresAt)
+			return "", nil
+		}
+
+		expiresAt := asiento.ExpiresAt
+		retenidoDesde := asiento.RetenidoDesde
+		asiento.Disponible = true
+		asiento.Cliente = ""
+		asiento.ExpiresAt = nil
+		asiento.RetenidoDesde = nil
+		asiento.UpdatedAt = time.Now()
+
+		if err := rs.saveSeat(asiento); err != nil {
+			asiento.Disponible = false
+			asiento.Cliente = cliente
+			asiento.ExpiresAt = expiresAt
+			asiento.RetenidoDesde = retenidoDesde
+			return "", errDatabase(err)
+		}
+
+		noShow = true
+		return "", nil
+	})
+
+	if apiErr != nil {
+		// Reintentar más tarde: el asiento sigue retenido
+		log.Printf("Server %s: Could not expire hold on seat %d, retrying: %s", rs.serverID, numero, apiErr.Message)
+		rs.mutex.Lock()
+		rs.armHoldTimer(numero, cliente, time.Now().Add(time.Second))
+		rs.mutex.Unlock()
+		return
+	}
+
+	if !noShow {
+		return
+	}
+
+	log.Printf("Server %s: Hold on seat %d by %s expired without confirmation (no-show)", rs.serverID, numero, cliente)
+	rep, err := rs.clientes.RegistrarNoShow(context.Background(), cliente)
+	if err != nil {
+		log.Printf("Server %s: Error recording no-show for %s: %v", rs.serverID, cliente, err)
+		return
+	}
+	if rep.Bloqueado(time.Now()) {
+		log.Printf("Server %s: Client %s blocked until %s", rs.serverID, cliente, rep.BloqueadoHasta.Format(time.RFC3339))
+	}
+}
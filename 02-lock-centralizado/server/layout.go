@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SeatSection es un bloque de asientos consecutivos entre dos pasillos
+type SeatSection struct {
+	Name  string
+	First int
+	Last  int
+}
+
+// SeatLayout reparte los asientos en secciones separadas por pasillos. Dos
+// asientos solo son contiguos si tienen números consecutivos y están en la
+// misma sección.
+type SeatLayout struct {
+	Sections []SeatSection
+}
+
+// parseSeatLayout interpreta SEAT_LAYOUT, p. ej. "A:1-10,B:11-20": dos
+// secciones con un pasillo entre el 10 y el 11. Vacío significa una única
+// sala sin pasillos.
+func parseSeatLayout(spec string) (*SeatLayout, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	layout := &SeatLayout{}
+	for _, part := range strings.Split(spec, ",") {
+		name, rango, ok := strings.Cut(strings.TrimSpace(part), ":")
+		desde, hasta, okRango := strings.Cut(rango, "-")
+		if !ok || !okRango || name == "" {
+			return nil, fmt.Errorf("invalid section %q (expected NAME:FIRST-LAST)", part)
+		}
+		first, err1 := strconv.Atoi(desde)
+		last, err2 := strconv.Atoi(hasta)
+		if err1 != nil || err2 != nil || first < 1 || last < first {
+			return nil, fmt.Errorf("invalid seat range in section %q", part)
+		}
+		layout.Sections = append(layout.Sections, SeatSection{Name: name, First: first, Last: last})
+	}
+
+	sort.Slice(layout.Sections, func(i, j int) bool {
+		return layout.Sections[i].First < layout.Sections[j].First
+	})
+	for i := 1; i < len(layout.Sections); i++ {
+		if layout.Sections[i].First <= layout.Sections[i-1].Last {
+			return nil, fmt.Errorf("sections %s and %s overlap",
+				layout.Sections[i-1].Name, layout.Sections[i].Name)
+		}
+	}
+	return layout, nil
+}
+
+// locate devuelve la sección de un asiento y si linda con un pasillo (está
+// en el borde de su sección y al otro lado hay otra sección)
+func (l *SeatLayout) locate(numero int) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	for i, section := range l.Sections {
+		if numero < section.First || numero > section.Last {
+			continue
+		}
+		aisle := (numero == section.First && i > 0) ||
+			(numero == section.Last && i < len(l.Sections)-1)
+		return section.Name, aisle
+	}
+	return "", false
+}
+
+// applyLayout asigna a cada asiento su sección según la configuración actual
+// y guarda en la BD los que han cambiado. Debe llamarse con rs.mutex tomado.
+func (rs *ReservationServer) applyLayout(layout *SeatLayout) {
+	for numero, asiento := range rs.asientos {
+		seccion, pasillo := layout.locate(numero)
+		if asiento.Seccion == seccion && asiento.JuntoAPasillo == pasillo {
+			continue
+		}
+		asiento.Seccion = seccion
+		asiento.JuntoAPasillo = pasillo
+
+		_, err := rs.collection.UpdateOne(context.Background(),
+			bson.M{"numero": numero},
+			bson.M{"$set": bson.M{"seccion": seccion, "junto_a_pasillo": pasillo}},
+		)
+		if err != nil {
+			log.Printf("Error saving layout of seat %d: %v", numero, err)
+		}
+	}
+}
+
+// recomendarContiguos busca el primer bloque de cantidad asientos libres,
+// consecutivos y de una misma sección: un bloque nunca cruza un pasillo.
+func recomendarContiguos(asientos map[int]*Asiento, cantidad int) []*Asiento {
+	numeros := make([]int, 0, len(asientos))
+	for numero := range asientos {
+		numeros = append(numeros, numero)
+	}
+	sort.Ints(numeros)
+
+	var bloque []*Asiento
+	for _, numero := range numeros {
+		asiento := asientos[numero]
+		if !asiento.Disponible {
+			bloque = nil
+			continue
+		}
+		if len(bloque) > 0 {
+			previo := bloque[len(bloque)-1]
+			if previo.Numero != numero-1 || previo.Seccion != asiento.Seccion {
+				bloque = nil
+			}
+		}
+		bloque = append(bloque, asiento)
+		if len(bloque) == cantidad {
+			return bloque
+		}
+	}
+	return nil
+}
+
+// handleRecomendar sugiere cantidad asientos contiguos libres sin reservarlos
+func (rs *ReservationServer) handleRecomendar(w http.ResponseWriter, r *http.Request) {
+	cantidad, err := strconv.Atoi(r.URL.Query().Get("cantidad"))
+	if err != nil || cantidad < 1 {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "cantidad must be a positive integer")
+		return
+	}
+
+	asientos, err := rs.GetAsientos()
+	if err != nil {
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to get seats")
+		return
+	}
+
+	bloque := recomendarContiguos(asientos, cantidad)
+	if bloque == nil {
+		httperr.Write(w, http.StatusNotFound, CodeNoContiguousSeats,
+			fmt.Sprintf("No hay %d asientos contiguos libres en una misma sección", cantidad))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"asientos":  bloque,
+		"seccion":   bloque[0].Seccion,
+		"server_id": rs.serverID,
+	})
+}
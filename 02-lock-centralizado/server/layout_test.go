@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"github.com/sincronizacion-distribuida/shared/models"
+)
+
+// asientosConLayout crea los asientos 1..total con su sección; los de
+// reservados empiezan ocupados
+func asientosConLayout(t *testing.T, spec string, total int, reservados ...int) map[int]*Asiento {
+	t.Helper()
+	layout, err := parseSeatLayout(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asientos := make(map[int]*Asiento, total)
+	for i := 1; i <= total; i++ {
+		asiento := &Asiento{AsientoBase: models.AsientoBase{AsientoEstado: models.AsientoEstado{Numero: i, Disponible: true}}}
+		asiento.Seccion, asiento.JuntoAPasillo = layout.locate(i)
+		asientos[i] = asiento
+	}
+	for _, numero := range reservados {
+		asientos[numero].Disponible = false
+		asientos[numero].Cliente = "ana"
+	}
+	return asientos
+}
+
+func numerosDe(bloque []*Asiento) []int {
+	numeros := make([]int, len(bloque))
+	for i, asiento := range bloque {
+		numeros[i] = asiento.Numero
+	}
+	return numeros
+}
+
+func TestRecomendarContiguosDoesNotCrossTheAisle(t *testing.T) {
+	// Libres 4-5 en A y 6-10 en B: un recorrido ingenuo propondría 4, 5, 6
+	asientos := asientosConLayout(t, "A:1-5,B:6-10", 10, 1, 2, 3)
+
+	bloque := recomendarContiguos(asientos, 3)
+	if got := numerosDe(bloque); len(got) != 3 || got[0] != 6 || got[2] != 8 {
+		t.Fatalf("expected seats 6-8, got %v", got)
+	}
+	for _, asiento := range bloque {
+		if asiento.Seccion != "B" {
+			t.Fatalf("block left section B: %+v", asiento)
+		}
+	}
+
+	// Sin pasillos el mismo hueco sí vale
+	sinPasillos := asientosConLayout(t, "", 10, 1, 2, 3)
+	if got := numerosDe(recomendarContiguos(sinPasillos, 3)); len(got) != 3 || got[0] != 4 {
+		t.Fatalf("expected seats 4-6 without aisles, got %v", got)
+	}
+}
+
+func TestRecomendarContiguosNoBlock(t *testing.T) {
+	// Cada sección tiene como mucho 2 libres seguidos
+	asientos := asientosConLayout(t, "A:1-4,B:5-8", 8, 1, 2, 7, 8)
+	if bloque := recomendarContiguos(asientos, 3); bloque != nil {
+		t.Fatalf("expected no block, got %v", numerosDe(bloque))
+	}
+	if got := numerosDe(recomendarContiguos(asientos, 2)); len(got) != 2 || got[0] != 3 {
+		t.Fatalf("expected seats 3-4, got %v", got)
+	}
+}
+
+func TestSeatLayoutLocate(t *testing.T) {
+	layout, err := parseSeatLayout("B:6-10, A:1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		numero  int
+		seccion string
+		pasillo bool
+	}{
+		{1, "A", false},
+		{5, "A", true},
+		{6, "B", true},
+		{10, "B", false},
+		{11, "", false},
+	}
+	for _, tc := range cases {
+		seccion, pasillo := layout.locate(tc.numero)
+		if seccion != tc.seccion || pasillo != tc.pasillo {
+			t.Errorf("seat %d: expected (%q, %v), got (%q, %v)", tc.numero, tc.seccion, tc.pasillo, seccion, pasillo)
+		}
+	}
+}
+
+func TestParseSeatLayoutRejectsInvalidSpecs(t *testing.T) {
+	for _, spec := range []string{"A", "A:1", ":1-5", "A:5-1", "A:0-3", "A:1-5,B:5-8", "A:x-3"} {
+		if _, err := parseSeatLayout(spec); err == nil {
+			t.Errorf("parseSeatLayout(%q) accepted an invalid layout", spec)
+		}
+	}
+	if layout, err := parseSeatLayout("  "); err != nil || layout != nil {
+		t.Fatalf("expected no layout for an empty spec, got %+v, %v", layout, err)
+	}
+}
+
+func TestHandleRecomendarRejectsInvalidCantidad(t *testing.T) {
+	rs, _ := newTestServer(t, newFakeReservaStore())
+	for _, query := range []string{"", "?cantidad=0", "?cantidad=dos"} {
+		rec := httptest.NewRecorder()
+		rs.handleRecomendar(rec, httptest.NewRequest(http.MethodGet, "/asientos/recomendar"+query, nil))
+		if rec.Code != http.StatusBadRequest || errorCode(t, rec) != httperr.CodeInvalidRequest {
+			t.Errorf("query %q: expected 400 %s, got %d", query, httperr.CodeInvalidRequest, rec.Code)
+		}
+	}
+}
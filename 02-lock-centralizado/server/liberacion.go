@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Liberación en bloque para el organizador: /liberar-rango libera los
+// asientos ocupados entre dos números y /liberar-todos todos los ocupados.
+// Los asientos se bloquean de uno en uno y en orden ascendente, así que
+// nunca se retiene más de un bloqueo del coordinador a la vez. Un asiento ya
+// libre no es un error: se informa y se sigue con el resto.
+
+// maxRangoLiberacion es el mayor número de asientos que acepta /liberar-rango
+const maxRangoLiberacion = 1000
+
+// Resultado de cada asiento en una liberación en bloque
+const (
+	ResultadoLiberado = "liberado"
+	ResultadoYaLibre  = "ya_libre"
+	ResultadoNoExiste = "no_existe"
+	ResultadoError    = "error"
+)
+
+// LiberacionAsiento es el resultado de liberar un asiento del bloque
+type LiberacionAsiento struct {
+	Numero    int           `json:"numero"`
+	Resultado string        `json:"resultado"`
+	Error     *httperr.Body `json:"error,omitempty"`
+}
+
+// LiberacionBloque es la respuesta de /liberar-rango y /liberar-todos
+type LiberacionBloque struct {
+	Success    bool                `json:"success"`
+	Liberados  int                 `json:"liberados"`
+	YaLibres   int                 `json:"ya_libres"`
+	Fallidos   int                 `json:"fallidos"`
+	Resultados []LiberacionAsiento `json:"resultados"`
+	ServerID   string              `json:"server_id"`
+}
+
+func (b *LiberacionBloque) anotar(resultado LiberacionAsiento) {
+	switch resultado.Resultado {
+	case ResultadoLiberado:
+		b.Liberados++
+	case ResultadoYaLibre:
+		b.YaLibres++
+	case ResultadoError:
+		b.Fallidos++
+	}
+	b.Resultados = append(b.Resultados, resultado)
+}
+
+// liberarSi libera el asiento si sigue ocupado y debe(asiento) lo permite. El
+// estado se decide con MongoDB bajo el bloqueo: entre la consulta y el
+// bloqueo pudo liberarlo otro servidor. Devuelve si lo liberó.
+func (rs *ReservationServer) liberarSi(numero int, debe func(*Asiento) bool) (bool, *APIError) {
+	liberado := false
+	_, apiErr := rs.withSeatLock(numero, func() (string, *APIError) {
+		if err := rs.reloadSeat(numero); err != nil {
+			return "", newAPIError(http.StatusInternalServerError, CodeDatabaseError, fmt.Sprintf("Error reading seat: %v", err))
+		}
+		asiento, exists := rs.asientos[numero]
+		if !exists || asiento.Disponible || !debe(asiento) {
+			return "", nil
+		}
+
+		previo := *asiento
+		asiento.Disponible = true
+		asiento.Cliente = ""
+		asiento.ExpiresAt = nil
+		asiento.RetenidoDesde = nil
+		asiento.GrupoCuota = ""
+		// El recibo se conserva, como al liberar con /liberar
+		asiento.Codigo = ""
+		asiento.UpdatedAt = time.Now()
+
+		if err := rs.saveSeat(asiento); err != nil {
+			*asiento = previo
+			return "", errDatabase(err)
+		}
+
+		rs.stopHoldTimer(numero)
+		liberado = true
+		return "", nil
+	})
+	return liberado, apiErr
+}
+
+// liberarOcupado libera un asiento que la consulta dio por ocupado
+func (rs *ReservationServer) liberarOcupado(numero int) LiberacionAsiento {
+	liberado, apiErr := rs.liberarSi(numero, func(*Asiento) bool { return true })
+	switch {
+	case apiErr != nil:
+		return LiberacionAsiento{Numero: numero, Resultado: ResultadoError,
+			Error: &httperr.Body{Code: apiErr.Code, Message: apiErr.Message}}
+	case liberado:
+		return LiberacionAsiento{Numero: numero, Resultado: ResultadoLiberado}
+	default:
+		return LiberacionAsiento{Numero: numero, Resultado: ResultadoYaLibre}
+	}
+}
+
+// estadoAsientos devuelve si está disponible cada asiento que cumple filter,
+// en orden ascendente de número
+func (rs *ReservationServer) estadoAsientos(ctx context.Context, filter bson.M) ([]Asiento, error) {
+	opts := options.Find().SetSort(bson.M{"numero": 1}).SetProjection(bson.M{"numero": 1, "disponible": 1})
+	cursor, err := rs.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var asientos []Asiento
+	if err := cursor.All(ctx, &asientos); err != nil {
+		return nil, err
+	}
+	return asientos, nil
+}
+
+// LiberarRango libera los asientos ocupados entre desde y hasta, ambos
+// incluidos. Los que la consulta da por libres no se bloquean.
+func (rs *ReservationServer) LiberarRango(ctx context.Context, desde, hasta int) (*LiberacionBloque, error) {
+	asientos, err := rs.estadoAsientos(ctx, bson.M{"numero": bson.M{"$gte": desde, "$lte": hasta}})
+	if err != nil {
+		return nil, err
+	}
+	disponible := make(map[int]bool, len(asientos))
+	for _, asiento := range asientos {
+		disponible[asiento.Numero] = asiento.Disponible
+	}
+
+	bloque := &LiberacionBloque{Resultados: []LiberacionAsiento{}, ServerID: rs.serverID}
+	for numero := desde; numero <= hasta; numero++ {
+		libre, exists := disponible[numero]
+		switch {
+		case !exists:
+			bloque.anotar(LiberacionAsiento{Numero: numero, Resultado: ResultadoNoExiste})
+		case libre:
+			bloque.anotar(LiberacionAsiento{Numero: numero, Resultado: ResultadoYaLibre})
+		default:
+			bloque.anotar(rs.liberarOcupado(numero))
+		}
+	}
+	bloque.Success = bloque.Fallidos == 0
+	return bloque, nil
+}
+
+// LiberarTodos libera todos los asientos ocupados, retenidos o reservados
+func (rs *ReservationServer) LiberarTodos(ctx context.Context) (*LiberacionBloque, error) {
+	asientos, err := rs.estadoAsientos(ctx, bson.M{"disponible": false})
+	if err != nil {
+		return nil, err
+	}
+
+	bloque := &LiberacionBloque{Resultados: []LiberacionAsiento{}, ServerID: rs.serverID}
+	for _, asiento := range asientos {
+		bloque.anotar(rs.liberarOcupado(asiento.Numero))
+	}
+	bloque.Success = bloque.Fallidos == 0
+	return bloque, nil
+}
+
+func (rs *ReservationServer) handleLiberarRango(w http.ResponseWriter, r *http.Request) {
+	if !rs.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Desde int `json:"desde"`
+		Hasta int `json:"hasta"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+	if req.Desde < 1 || req.Hasta < req.Desde {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "desde must be at least 1 and not greater than hasta")
+		return
+	}
+	if req.Hasta-req.Desde >= maxRangoLiberacion {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest,
+			fmt.Sprintf("A range may span at most %d seats", maxRangoLiberacion))
+		return
+	}
+
+	bloque, err := rs.LiberarRango(r.Context(), req.Desde, req.Hasta)
+	if err != nil {
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, fmt.Sprintf("Error reading seats: %v", err))
+		return
+	}
+	log.Printf("Server %s: Bulk release of seats %d-%d: %d freed, %d already free, %d failed",
+		rs.serverID, req.Desde, req.Hasta, bloque.Liberados, bloque.YaLibres, bloque.Fallidos)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bloque)
+}
+
+func (rs *ReservationServer) handleLiberarTodos(w http.ResponseWriter, r *http.Request) {
+	if !rs.requireAdmin(w, r) {
+		return
+	}
+
+	bloque, err := rs.LiberarTodos(r.Context())
+	if err != nil {
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, fmt.Sprintf("Error reading seats: %v", err))
+		return
+	}
+	log.Printf("Server %s: Released all seats: %d freed, %d failed",
+		rs.serverID, bloque.Liberados, bloque.Fallidos)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bloque)
+}
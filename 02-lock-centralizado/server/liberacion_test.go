@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// liberacionBloque decodifica la respuesta de /liberar-rango o /liberar-todos
+func liberacionBloque(t *testing.T, rec *httptest.ResponseRecorder) LiberacionBloque {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var bloque LiberacionBloque
+	if err := json.NewDecoder(rec.Body).Decode(&bloque); err != nil {
+		t.Fatal(err)
+	}
+	return bloque
+}
+
+func TestLiberarRangoFreesOnlyTheRange(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, store := newCacheTestServer(t, 6)
+		rs.collection = mt.Coll
+		rs.adminToken = "secret"
+		coordinator := newFakeCoordinator(t)
+		rs.coordinatorURL = coordinator.URL
+		for _, numero := range []int{3, 4, 6} {
+			ocupar(rs, store, numero, "cliente")
+		}
+
+		if rec := adminPost(rs.handleLiberarRango, "/liberar-rango", `{"desde":2,"hasta":7}`, ""); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 without the admin token, got %d", rec.Code)
+		}
+
+		// Estado de los asientos 2..7 según MongoDB; el 7 no existe
+		mt.AddMockResponses(findResponse(seatDoc(2, ""), seatDoc(3, "cliente"), seatDoc(4, "cliente"), seatDoc(5, ""), seatDoc(6, "cliente")))
+		bloque := liberacionBloque(t, adminPost(rs.handleLiberarRango, "/liberar-rango", `{"desde":2,"hasta":7}`, "secret"))
+
+		want := []LiberacionAsiento{
+			{Numero: 2, Resultado: ResultadoYaLibre},
+			{Numero: 3, Resultado: ResultadoLiberado},
+			{Numero: 4, Resultado: ResultadoLiberado},
+			{Numero: 5, Resultado: ResultadoYaLibre},
+			{Numero: 6, Resultado: ResultadoLiberado},
+			{Numero: 7, Resultado: ResultadoNoExiste},
+		}
+		if !bloque.Success || bloque.Liberados != 3 || bloque.YaLibres != 2 || bloque.Fallidos != 0 {
+			t.Fatalf("unexpected totals: %+v", bloque)
+		}
+		if len(bloque.Resultados) != len(want) {
+			t.Fatalf("expected %d results, got %+v", len(want), bloque.Resultados)
+		}
+		for i := range want {
+			if bloque.Resultados[i] != want[i] {
+				t.Errorf("expected %+v, got %+v", want[i], bloque.Resultados[i])
+			}
+		}
+
+		filtro := mt.GetStartedEvent().Command.Lookup("filter", "numero").Document()
+		if filtro.Lookup("$gte").AsInt64() != 2 || filtro.Lookup("$lte").AsInt64() != 7 {
+			t.Fatalf("seats queried outside the range: %v", filtro)
+		}
+		for numero, asiento := range store.asientos {
+			if !asiento.Disponible {
+				t.Errorf("seat %d in the range is still taken", numero)
+			}
+		}
+		// Solo se bloquearon los asientos ocupados, y todos se soltaron
+		coordinator.mu.Lock()
+		defer coordinator.mu.Unlock()
+		if coordinator.granted != 3 || len(coordinator.held) != 0 {
+			t.Fatalf("expected 3 locks, all released; got %d granted, %v held", coordinator.granted, coordinator.held)
+		}
+	})
+}
+
+func TestLiberarRangoLeavesSeatsOutsideTheRange(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, store := newCacheTestServer(t, 6)
+		rs.collection = mt.Coll
+		for _, numero := range []int{1, 3, 6} {
+			ocupar(rs, store, numero, "cliente")
+		}
+
+		mt.AddMockResponses(findResponse(seatDoc(2, ""), seatDoc(3, "cliente"), seatDoc(4, "")))
+		bloque, err := rs.LiberarRango(context.Background(), 2, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bloque.Liberados != 1 || bloque.YaLibres != 2 {
+			t.Fatalf("unexpected totals: %+v", bloque)
+		}
+		if !store.asientos[3].Disponible || store.asientos[1].Disponible || store.asientos[6].Disponible {
+			t.Fatalf("expected only seat 3 to be freed: %+v", store.asientos)
+		}
+	})
+}
+
+func TestLiberarTodosOnAHalfReservedVenue(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, store := newCacheTestServer(t, 6)
+		rs.collection = mt.Coll
+		rs.adminToken = "secret"
+		for _, numero := range []int{1, 3, 5} {
+			ocupar(rs, store, numero, "cliente")
+		}
+
+		mt.AddMockResponses(findResponse(seatDoc(1, "cliente"), seatDoc(3, "cliente"), seatDoc(5, "cliente")))
+		bloque := liberacionBloque(t, adminPost(rs.handleLiberarTodos, "/liberar-todos", "", "secret"))
+
+		if !bloque.Success || bloque.Liberados != 3 || bloque.Fallidos != 0 || len(bloque.Resultados) != 3 {
+			t.Fatalf("expected 3 seats freed, got %+v", bloque)
+		}
+		if filtro := mt.GetStartedEvent().Command.Lookup("filter"); filtro.Document().Lookup("disponible").Boolean() {
+			t.Fatalf("expected only taken seats to be queried, got %v", filtro)
+		}
+		for numero, asiento := range store.asientos {
+			if !asiento.Disponible || asiento.Cliente != "" {
+				t.Errorf("seat %d is still taken: %+v", numero, asiento)
+			}
+		}
+	})
+}
+
+func TestLiberarRangoRejectsInvalidRanges(t *testing.T) {
+	rs := &ReservationServer{serverID: "s1", adminToken: "secret"}
+	for _, body := range []string{`{"desde":0,"hasta":3}`, `{"desde":5,"hasta":4}`, `{"desde":1,"hasta":1001}`} {
+		rec := adminPost(rs.handleLiberarRango, "/liberar-rango", body, "secret")
+		if rec.Code != http.StatusBadRequest || errorCode(t, rec) != httperr.CodeInvalidRequest {
+			t.Errorf("%s: expected 400 %s, got %d", body, httperr.CodeInvalidRequest, rec.Code)
+		}
+	}
+}
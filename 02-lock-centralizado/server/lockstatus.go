@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Respuesta perdida de /acquire: si la petición llegó al coordinador pero la
+// respuesta no llegó al servidor (conexión cortada, cuerpo ilegible), el
+// bloqueo puede estar concedido a nuestro nombre sin que lo sepamos. Antes de
+// dar la operación por fallida se pregunta a /status/{resource}; si el
+// bloqueo es de este servidor y nadie aquí lo está usando, se adopta.
+
+// coordinatorLock es el bloqueo que devuelve GET /status/{resource}
+type coordinatorLock struct {
+	ID        string    `json:"id"`
+	Resource  string    `json:"resource"`
+	ClientID  string    `json:"client_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// lockStatus consulta el bloqueo actual de resource. Devuelve nil si el
+// recurso está libre.
+func (rs *ReservationServer) lockStatus(resource string) (*coordinatorLock, error) {
+	resp, err := http.Get(rs.coordinatorURL + "/status/" + url.PathEscape(resource))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var status struct {
+		Locked bool             `json:"locked"`
+		Lock   *coordinatorLock `json:"lock"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	if !status.Locked || status.Lock == nil {
+		return nil, nil
+	}
+	return status.Lock, nil
+}
+
+// recoverLostAcquire decide qué pasó con un /acquire cuya respuesta se
+// perdió (acquireErr). Si /status muestra el bloqueo a nombre de este
+// servidor, lo adopta y lo devuelve como concedido; si no, o si /status
+// tampoco responde, devuelve el error original.
+func (rs *ReservationServer) recoverLostAcquire(resource string, acquireErr error) (*LockResponse, error) {
+	lock, err := rs.lockStatus(resource)
+	if err != nil {
+		log.Printf("Server %s: Could not check %s after a failed acquire: %v", rs.serverID, resource, err)
+		return nil, acquireErr
+	}
+	if lock == nil || lock.ClientID != rs.serverID || !time.Now().Before(lock.ExpiresAt) {
+		return nil, acquireErr
+	}
+	// Otra petición de este mismo servidor puede tener ese bloqueo
+	if !rs.claimLock(lock.ID) {
+		return nil, acquireErr
+	}
+
+	log.Printf("Server %s: Acquire response for %s was lost (%v), but the coordinator shows lock %s as ours; proceeding",
+		rs.serverID, resource, acquireErr, lock.ID)
+	return &LockResponse{
+		Success:   true,
+		LockID:    lock.ID,
+		Message:   "Lock acquired (recovered from /status)",
+		ExpiresAt: lock.ExpiresAt.Unix(),
+	}, nil
+}
+
+// claimLock apunta que lockID lo usa una petición de este servidor. Devuelve
+// false si ya estaba apuntado, para que un mismo bloqueo no lo adopten dos
+// peticiones a la vez.
+func (rs *ReservationServer) claimLock(lockID string) bool {
+	rs.locksMutex.Lock()
+	defer rs.locksMutex.Unlock()
+	if rs.claimedLocks[lockID] {
+		return false
+	}
+	rs.claimedLocks[lockID] = true
+	return true
+}
+
+// unclaimLock olvida lockID al liberarlo
+func (rs *ReservationServer) unclaimLock(lockID string) {
+	rs.locksMutex.Lock()
+	delete(rs.claimedLocks, lockID)
+	rs.locksMutex.Unlock()
+}
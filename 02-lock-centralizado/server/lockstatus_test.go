@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lostResponseCoordinator concede cada /acquire pero corta la conexión antes
+// de responder, como si la respuesta se hubiera perdido, y publica el
+// bloqueo en /status a nombre de owner
+type lostResponseCoordinator struct {
+	*httptest.Server
+	mu       sync.Mutex
+	owner    string
+	acquires int
+	released []string
+}
+
+func newLostResponseCoordinator(t *testing.T, owner string) *lostResponseCoordinator {
+	c := &lostResponseCoordinator{owner: owner}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/acquire", func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		c.acquires++
+		c.mu.Unlock()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	})
+	mux.HandleFunc("/status/", func(w http.ResponseWriter, r *http.Request) {
+		resource := strings.TrimPrefix(r.URL.Path, "/status/")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"locked": true,
+			"lock": coordinatorLock{ID: "lock-1", Resource: resource, ClientID: c.owner,
+				ExpiresAt: time.Now().Add(30 * time.Second)},
+		})
+	})
+	mux.HandleFunc("/release", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			LockID string `json:"lock_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		c.mu.Lock()
+		c.released = append(c.released, req.LockID)
+		c.mu.Unlock()
+		json.NewEncoder(w).Encode(LockResponse{Success: true})
+	})
+	c.Server = httptest.NewServer(mux)
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestLostAcquireResponseRecoveredFromStatus(t *testing.T) {
+	store := newFakeReservaStore()
+	rs, _ := newTestServer(t, store)
+	coordinator := newLostResponseCoordinator(t, rs.serverID)
+	rs.coordinatorURL = coordinator.URL
+
+	recibo, apiErr := rs.reservarAsiento(7, "ana", "")
+	if apiErr != nil {
+		t.Fatalf("reservation failed although the lock was ours: %+v", apiErr)
+	}
+	if saved := store.asientos[7]; saved.Disponible || saved.Cliente != "ana" || saved.Codigo != recibo.Codigo {
+		t.Fatalf("reservation was not saved: %+v", saved)
+	}
+
+	coordinator.mu.Lock()
+	defer coordinator.mu.Unlock()
+	if coordinator.acquires != 1 {
+		t.Fatalf("expected a single acquire, got %d", coordinator.acquires)
+	}
+	// El bloqueo adoptado se libera al terminar, como uno concedido
+	if len(coordinator.released) != 1 || coordinator.released[0] != "lock-1" {
+		t.Fatalf("expected lock-1 to be released, got %v", coordinator.released)
+	}
+	if len(rs.claimedLocks) != 0 {
+		t.Fatalf("adopted lock still claimed after release: %v", rs.claimedLocks)
+	}
+}
+
+func TestLostAcquireResponseLockedByAnotherServer(t *testing.T) {
+	store := newFakeReservaStore()
+	rs, _ := newTestServer(t, store)
+	coordinator := newLostResponseCoordinator(t, "server2")
+	rs.coordinatorURL = coordinator.URL
+
+	_, apiErr := rs.reservarAsiento(7, "ana", "")
+	if apiErr == nil || apiErr.Code != CodeCoordinatorUnavailable {
+		t.Fatalf("expected %s, got %+v", CodeCoordinatorUnavailable, apiErr)
+	}
+	if !rs.asientos[7].Disponible || len(store.asientos) != 0 {
+		t.Fatal("seat was reserved without owning the lock")
+	}
+	if len(coordinator.released) != 0 {
+		t.Fatalf("released a lock owned by another server: %v", coordinator.released)
+	}
+}
+
+func TestLostAcquireResponseLockAlreadyInUse(t *testing.T) {
+	rs, _ := newTestServer(t, newFakeReservaStore())
+	coordinator := newLostResponseCoordinator(t, rs.serverID)
+	rs.coordinatorURL = coordinator.URL
+
+	// Otra petición de este servidor ya usa lock-1
+	rs.claimLock("lock-1")
+	if _, err := rs.acquireLock("seat_7", 30); err == nil {
+		t.Fatal("the same lock was adopted by two requests")
+	}
+}
@@ -8,10 +8,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"runtime/debug"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"github.com/sincronizacion-distribuida/shared/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -19,11 +24,21 @@ import (
 
 // Asiento representa un asiento en el sistema
 type Asiento struct {
-	Numero     int    `bson:"numero" json:"numero"`
-	Disponible bool   `bson:"disponible" json:"disponible"`
-	Cliente    string `bson:"cliente,omitempty" json:"cliente,omitempty"`
-	ServerID   string `bson:"server_id" json:"server_id"`
-	UpdatedAt  time.Time `bson:"updated_at" json:"updated_at"`
+	models.AsientoBase `bson:",inline"`
+	// ExpiresAt solo está presente en retenciones pendientes de confirmar;
+	// RetenidoDesde es cuándo empezó la retención, para limitar /extender
+	ExpiresAt     *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	RetenidoDesde *time.Time `bson:"retenido_desde,omitempty" json:"retenido_desde,omitempty"`
+	// Sección del asiento según SEAT_LAYOUT y si linda con un pasillo
+	Seccion       string `bson:"seccion,omitempty" json:"seccion,omitempty"`
+	JuntoAPasillo bool   `bson:"junto_a_pasillo,omitempty" json:"junto_a_pasillo,omitempty"`
+	// Grupo (GROUP_QUOTAS) a cuya cuota cuenta la reserva, si lo hay
+	GrupoCuota string `bson:"grupo_cuota,omitempty" json:"grupo_cuota,omitempty"`
+	// Código de confirmación de la reserva; da acceso al recibo, así que
+	// solo se entrega a quien reserva y no aparece en /asientos
+	Codigo string `bson:"codigo,omitempty" json:"-"`
+	// Precio vigente según PRICING_STRATEGY; se calcula al leer, no se guarda
+	Precio float64 `bson:"-" json:"precio"`
 }
 
 // LockRequest para comunicarse con el coordinador
@@ -49,27 +64,63 @@ type ReservationServer struct {
 	asientos         map[int]*Asiento
 	mutex            sync.RWMutex
 	activeLocks      map[string]string // resource -> lockID
+	claimedLocks     map[string]bool   // lockIDs en uso por alguna petición
 	locksMutex       sync.RWMutex
+	clientes         *ClientStore
+	holdTimers       map[int]*time.Timer // numero -> expiración de la retención
+	holdDefault      time.Duration       // duración de una retención si no se indica
+	holdMax          time.Duration       // duración máxima de una retención con sus prórrogas
+	mongoSettings    MongoSettings
+	adminToken       string      // vacío = endpoints /admin deshabilitados
+	maintenance      atomic.Bool // reservas y liberaciones devuelven 503
+	maintenanceRetry int         // segundos anunciados en Retry-After
+	autoRenew        bool        // renueva los bloqueos a TTL/2 mientras dura la operación
+	// Cuota de cada grupo (GROUP_QUOTAS): máximo de asientos reservados
+	quotas map[string]GroupQuota
+	// Recibos de las reservas y precio de un asiento (SEAT_PRICE)
+	recibos    *ReciboStore
+	precioBase float64
+	// Escrituras de una reserva (recibo y asiento); por defecto en MongoDB
+	reservas reservaStore
+	// Precios fijados con /admin/precio y estrategia que los ajusta
+	// (PRICING_STRATEGY); con demand el recargo máximo es recargoDemanda
+	precios        *PrecioStore
+	pricing        string
+	recargoDemanda float64
+	// Sesiones de quiosco: sin latidos durante sessionTimeout se liberan los
+	// asientos del cliente (nil = desactivadas)
+	sesiones       *SesionStore
+	sessionTimeout time.Duration
+	// Histórico de resúmenes de la sala (stats_history)
+	estadisticas *StatsHistory
 }
 
 // NewReservationServer crea un nuevo servidor de reservas
-func NewReservationServer(serverID, coordinatorURL string, collection *mongo.Collection) *ReservationServer {
+func NewReservationServer(serverID, coordinatorURL string, collection *mongo.Collection, clientes *ClientStore, seatInit SeatInit) *ReservationServer {
 	rs := &ReservationServer{
 		serverID:       serverID,
 		coordinatorURL: coordinatorURL,
 		collection:     collection,
 		asientos:       make(map[int]*Asiento),
 		activeLocks:    make(map[string]string),
+		claimedLocks:   make(map[string]bool),
+		clientes:       clientes,
+		holdTimers:     make(map[int]*time.Timer),
+		holdDefault:    2 * time.Minute,
 	}
-	
+	rs.reservas = mongoReservaStore{rs}
+
 	// Inicializar asientos
-	rs.initializeSeats()
-	
+	rs.initializeSeats(seatInit)
+	// Retomar las retenciones que quedaron pendientes antes de un reinicio
+	rs.rearmHolds()
+
 	return rs
 }
 
-// initializeSeats inicializa los asientos en la base de datos
-func (rs *ReservationServer) initializeSeats() {
+// initializeSeats inicializa los asientos en la base de datos. Si se crean de
+// cero, el porcentaje seatInit.Occupancy empieza reservado.
+func (rs *ReservationServer) initializeSeats(seatInit SeatInit) {
 	rs.mutex.Lock()
 	defer rs.mutex.Unlock()
 
@@ -89,15 +140,24 @@ func (rs *ReservationServer) initializeSeats() {
 
 	// Si no hay asientos, crear 20 asientos por defecto
 	if len(rs.asientos) == 0 {
+		reservados := make(map[int]bool)
+		for _, numero := range preReservedSeats(20, seatInit) {
+			reservados[numero] = true
+		}
+
 		for i := 1; i <= 20; i++ {
-			asiento := &Asiento{
-				Numero:     i,
-				Disponible: true,
-				ServerID:   rs.serverID,
-				UpdatedAt:  time.Now(),
+			asiento := &Asiento{AsientoBase: models.AsientoBase{
+				AsientoEstado: models.AsientoEstado{Numero: i, Disponible: true},
+				ServerID:      rs.serverID,
+				UpdatedAt:     time.Now(),
+			}}
+			if reservados[i] {
+				asiento.Disponible = false
+				asiento.Cliente = occupancyClient
 			}
+			asiento.Seccion, asiento.JuntoAPasillo = seatInit.Layout.locate(i)
 			rs.asientos[i] = asiento
-			
+
 			// Guardar en base de datos
 			_, err := rs.collection.ReplaceOne(
 				context.Background(),
@@ -109,11 +169,17 @@ func (rs *ReservationServer) initializeSeats() {
 				log.Printf("Error saving seat %d: %v", i, err)
 			}
 		}
-		log.Printf("Initialized %d seats for server %s", len(rs.asientos), rs.serverID)
+		log.Printf("Initialized %d seats for server %s (%d pre-reserved, occupancy %d%%, seed %d)",
+			len(rs.asientos), rs.serverID, len(reservados), seatInit.Occupancy, seatInit.Seed)
 	}
+
+	// La distribución de la sala es configuración: aplicarla también a los
+	// asientos que ya existían
+	rs.applyLayout(seatInit.Layout)
 }
 
-// acquireLock solicita un bloqueo al coordinador
+// acquireLock solicita un bloqueo al coordinador. Si la respuesta se pierde
+// comprueba con /status si el bloqueo se concedió de todos modos.
 func (rs *ReservationServer) acquireLock(resource string, ttl int) (*LockResponse, error) {
 	lockReq := LockRequest{
 		Resource: resource,
@@ -128,23 +194,31 @@ func (rs *ReservationServer) acquireLock(resource string, ttl int) (*LockRespons
 
 	resp, err := http.Post(rs.coordinatorURL+"/acquire", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, err
+		return rs.recoverLostAcquire(resource, err)
 	}
 	defer resp.Body.Close()
 
 	var lockResp LockResponse
 	if err := json.NewDecoder(resp.Body).Decode(&lockResp); err != nil {
-		return nil, err
+		return rs.recoverLostAcquire(resource, err)
 	}
 
+	if lockResp.Success && !rs.claimLock(lockResp.LockID) {
+		// Otra petición perdió su respuesta y ya adoptó este bloqueo
+		return &LockResponse{Success: false,
+			Message: fmt.Sprintf("Resource %s is already locked by client %s", resource, rs.serverID)}, nil
+	}
 	return &lockResp, nil
 }
 
-// releaseLock libera un bloqueo en el coordinador
-func (rs *ReservationServer) releaseLock(resource string) error {
+// releaseLock libera en el coordinador el bloqueo lockID
+func (rs *ReservationServer) releaseLock(resource, lockID string) error {
+	defer rs.unclaimLock(lockID)
+
 	releaseReq := map[string]string{
 		"resource":  resource,
 		"client_id": rs.serverID,
+		"lock_id":   lockID,
 	}
 
 	jsonData, err := json.Marshal(releaseReq)
@@ -158,21 +232,46 @@ func (rs *ReservationServer) releaseLock(resource string) error {
 	}
 	defer resp.Body.Close()
 
+	var releaseResp LockResponse
+	if err := json.NewDecoder(resp.Body).Decode(&releaseResp); err != nil {
+		return err
+	}
+	if !releaseResp.Success {
+		// Normalmente el bloqueo expiró antes de terminar la operación
+		log.Printf("Server %s: Release of %s not applied: %s", rs.serverID, resource, releaseResp.Message)
+	}
+
 	return nil
 }
 
-// ReservarAsiento reserva un asiento específico
-func (rs *ReservationServer) ReservarAsiento(numero int, cliente string) (bool, string) {
+// ReservarAsiento reserva un asiento específico y devuelve el recibo de la
+// reserva. Con grupo, la reserva cuenta para la cuota de ese grupo y se
+// rechaza si ya la ha agotado.
+func (rs *ReservationServer) ReservarAsiento(numero int, cliente, grupo string) (*Recibo, *APIError) {
+	if apiErr := rs.checkClienteBloqueado(cliente); apiErr != nil {
+		return nil, apiErr
+	}
+
+	if grupo != "" {
+		return rs.reservarConCuota(numero, cliente, grupo)
+	}
+	return rs.reservarAsiento(numero, cliente, "")
+}
+
+// reservarAsiento hace la reserva con el bloqueo del asiento. Un pánico
+// durante la reserva la deshace (ver deshacerReservaEnPanico) y se devuelve
+// como un error 500; el bloqueo se libera igualmente, una sola vez.
+func (rs *ReservationServer) reservarAsiento(numero int, cliente, grupo string) (recibo *Recibo, apiErr *APIError) {
 	resource := fmt.Sprintf("seat_%d", numero)
-	
+
 	// Intentar adquirir bloqueo
 	lockResp, err := rs.acquireLock(resource, 30) // 30 segundos TTL
 	if err != nil {
-		return false, fmt.Sprintf("Error acquiring lock: %v", err)
+		return nil, errCoordinator(err)
 	}
-	
+
 	if !lockResp.Success {
-		return false, lockResp.Message
+		return nil, errLockDenied(lockResp)
 	}
 
 	// Guardar el lockID para liberarlo después
@@ -180,13 +279,8 @@ func (rs *ReservationServer) ReservarAsiento(numero int, cliente string) (bool,
 	rs.activeLocks[resource] = lockResp.LockID
 	rs.locksMutex.Unlock()
 
-	defer func() {
-		// Liberar el bloqueo al finalizar
-		rs.releaseLock(resource)
-		rs.locksMutex.Lock()
-		delete(rs.activeLocks, resource)
-		rs.locksMutex.Unlock()
-	}()
+	// Liberar el bloqueo al finalizar
+	defer rs.lockReleaser(resource, lockResp.LockID, rs.startRenewal(resource, lockResp.LockID, 30))()
 
 	rs.mutex.Lock()
 	defer rs.mutex.Unlock()
@@ -194,52 +288,98 @@ func (rs *ReservationServer) ReservarAsiento(numero int, cliente string) (bool,
 	// Verificar si el asiento existe y está disponible
 	asiento, exists := rs.asientos[numero]
 	if !exists {
-		return false, "Asiento no existe"
+		return nil, newAPIError(http.StatusNotFound, CodeSeatNotFound, "Asiento no existe")
 	}
 
 	if !asiento.Disponible {
-		return false, "Asiento ya está ocupado"
+		return nil, newAPIError(http.StatusConflict, CodeSeatTaken, "Asiento ya está ocupado")
 	}
 
 	// Reservar el asiento
+	defer rs.deshacerReservaEnPanico(asiento, *asiento, &recibo, &apiErr)
 	asiento.Disponible = false
 	asiento.Cliente = cliente
+	asiento.GrupoCuota = grupo
 	asiento.UpdatedAt = time.Now()
 
+	recibo, apiErr = rs.emitirRecibo(asiento)
+	if apiErr != nil {
+		asiento.Disponible = true
+		asiento.Cliente = ""
+		asiento.GrupoCuota = ""
+		return nil, apiErr
+	}
+
 	// Actualizar en base de datos
-	_, err = rs.collection.ReplaceOne(
-		context.Background(),
-		bson.M{"numero": numero},
-		asiento,
-		options.Replace().SetUpsert(true),
-	)
-	if err != nil {
+	if err := rs.saveSeat(asiento); err != nil {
 		// Revertir cambios en caso de error
 		asiento.Disponible = true
 		asiento.Cliente = ""
-		return false, fmt.Sprintf("Error updating database: %v", err)
+		asiento.GrupoCuota = ""
+		rs.anularRecibo(asiento, recibo)
+		return nil, errDatabase(err)
 	}
 
-	log.Printf("Server %s: Seat %d reserved by %s", rs.serverID, numero, cliente)
-	return true, "Asiento reservado exitosamente"
+	if grupo != "" {
+		log.Printf("Server %s: Seat %d reserved by %s (group %s, code %s)", rs.serverID, numero, cliente, grupo, recibo.Codigo)
+		return recibo, nil
+	}
+	log.Printf("Server %s: Seat %d reserved by %s (code %s)", rs.serverID, numero, cliente, recibo.Codigo)
+	return recibo, nil
+}
+
+// deshacerReservaEnPanico, diferida en reservarAsiento, recupera un pánico
+// de la reserva: anula el recibo si llegó a emitirse, devuelve el asiento de
+// la caché y de la BD al estado previo y convierte el pánico en un error 500.
+// Debe diferirse con rs.mutex tomado.
+func (rs *ReservationServer) deshacerReservaEnPanico(asiento *Asiento, previo Asiento, recibo **Recibo, apiErr **APIError) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	log.Printf("Server %s: PANIC reserving seat %d, rolling it back: %v\n%s", rs.serverID, asiento.Numero, p, debug.Stack())
+
+	if *recibo != nil {
+		rs.anularRecibo(asiento, *recibo)
+	}
+	*asiento = previo
+	// El pánico pudo llegar antes o después de escribir el asiento
+	if err := rs.restaurarAsiento(asiento); err != nil {
+		log.Printf("Server %s: CRITICAL: could not restore seat %d after a panic, run /admin/reconcile: %v", rs.serverID, asiento.Numero, err)
+	}
+	*recibo = nil
+	*apiErr = newAPIError(http.StatusInternalServerError, httperr.CodeInternal, "Error interno al reservar; la reserva se ha deshecho")
+}
+
+// restaurarAsiento guarda el asiento como saveSeat, pero un pánico del
+// almacén se devuelve como error en lugar de propagarse
+func (rs *ReservationServer) restaurarAsiento(asiento *Asiento) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic saving seat: %v", p)
+		}
+	}()
+	return rs.saveSeat(asiento)
 }
 
 // LiberarAsiento libera un asiento específico
-func (rs *ReservationServer) LiberarAsiento(numero int) (bool, string) {
+func (rs *ReservationServer) LiberarAsiento(numero int) (string, *APIError) {
 	resource := fmt.Sprintf("seat_%d", numero)
-	
+
 	// Intentar adquirir bloqueo
 	lockResp, err := rs.acquireLock(resource, 30)
 	if err != nil {
-		return false, fmt.Sprintf("Error acquiring lock: %v", err)
+		return "", errCoordinator(err)
 	}
-	
+
 	if !lockResp.Success {
-		return false, lockResp.Message
+		return "", errLockDenied(lockResp)
 	}
 
+	renewal := rs.startRenewal(resource, lockResp.LockID, 30)
 	defer func() {
-		rs.releaseLock(resource)
+		renewal.Stop()
+		rs.releaseLock(resource, lockResp.LockID)
 		rs.locksMutex.Lock()
 		delete(rs.activeLocks, resource)
 		rs.locksMutex.Unlock()
@@ -250,62 +390,94 @@ func (rs *ReservationServer) LiberarAsiento(numero int) (bool, string) {
 
 	asiento, exists := rs.asientos[numero]
 	if !exists {
-		return false, "Asiento no existe"
+		return "", newAPIError(http.StatusNotFound, CodeSeatNotFound, "Asiento no existe")
 	}
 
 	if asiento.Disponible {
-		return false, "Asiento ya está disponible"
+		return "", newAPIError(http.StatusConflict, CodeSeatAlreadyFree, "Asiento ya está disponible")
 	}
 
 	// Liberar el asiento
+	cliente := asiento.Cliente
+	expiresAt := asiento.ExpiresAt
+	retenidoDesde := asiento.RetenidoDesde
+	grupo := asiento.GrupoCuota
+	codigo := asiento.Codigo
 	asiento.Disponible = true
 	asiento.Cliente = ""
+	asiento.ExpiresAt = nil
+	asiento.RetenidoDesde = nil
+	asiento.GrupoCuota = ""
+	// El recibo se conserva: el código deja de estar asociado al asiento
+	asiento.Codigo = ""
 	asiento.UpdatedAt = time.Now()
 
 	// Actualizar en base de datos
-	_, err = rs.collection.ReplaceOne(
-		context.Background(),
-		bson.M{"numero": numero},
-		asiento,
-		options.Replace().SetUpsert(true),
-	)
-	if err != nil {
+	if err := rs.saveSeat(asiento); err != nil {
 		// Revertir cambios en caso de error
 		asiento.Disponible = false
-		return false, fmt.Sprintf("Error updating database: %v", err)
+		asiento.Cliente = cliente
+		asiento.ExpiresAt = expiresAt
+		asiento.RetenidoDesde = retenidoDesde
+		asiento.GrupoCuota = grupo
+		asiento.Codigo = codigo
+		return "", errDatabase(err)
 	}
 
+	rs.stopHoldTimer(numero)
 	log.Printf("Server %s: Seat %d freed", rs.serverID, numero)
-	return true, "Asiento liberado exitosamente"
+	return "Asiento liberado exitosamente", nil
 }
 
-// GetAsientos obtiene todos los asientos, actualizando la caché desde la base de datos
+// GetAsientos obtiene todos los asientos, actualizando la caché desde la base de datos.
+// La caché se refresca en el sitio, sin sustituir el mapa ni los punteros, para
+// que las reservas en curso nunca modifiquen un asiento ya descartado. Devuelve
+// una copia que el llamador puede serializar sin tomar rs.mutex.
 func (rs *ReservationServer) GetAsientos() (map[int]*Asiento, error) {
 	rs.mutex.Lock()
 	defer rs.mutex.Unlock()
 
 	// Consultar todos los asientos de la base de datos
-	cursor, err := rs.collection.Find(context.Background(), bson.M{})
+	asientos, err := rs.reservas.CargarAsientos(context.Background())
 	if err != nil {
 		log.Printf("Error fetching seats from database: %v", err)
 		return nil, err
 	}
-	defer cursor.Close(context.Background())
 
-	// Crear un nuevo mapa para la caché actualizada
-	newAsientos := make(map[int]*Asiento)
-	for cursor.Next(context.Background()) {
-		var asiento Asiento
-		if err := cursor.Decode(&asiento); err == nil {
-			newAsientos[asiento.Numero] = &asiento
+	// Actualizar cada entrada existente sobre el mismo puntero
+	seen := make(map[int]bool)
+	for _, asiento := range asientos {
+		seen[asiento.Numero] = true
+		if existing, ok := rs.asientos[asiento.Numero]; ok {
+			*existing = *asiento
+		} else {
+			rs.asientos[asiento.Numero] = asiento
 		}
 	}
 
-	// Reemplazar la caché antigua con la nueva
-	rs.asientos = newAsientos
+	// Quitar los asientos que ya no están en la base de datos
+	for numero := range rs.asientos {
+		if !seen[numero] {
+			delete(rs.asientos, numero)
+		}
+	}
 	log.Printf("Server %s: Cache updated with %d seats from database", rs.serverID, len(rs.asientos))
 
-	return rs.asientos, nil
+	if err := rs.asignarPrecios(); err != nil {
+		log.Printf("Error reading seat prices: %v", err)
+		return nil, err
+	}
+	return rs.snapshotAsientos(), nil
+}
+
+// snapshotAsientos copia la caché de asientos. Debe llamarse con rs.mutex tomado.
+func (rs *ReservationServer) snapshotAsientos() map[int]*Asiento {
+	copia := make(map[int]*Asiento, len(rs.asientos))
+	for numero, asiento := range rs.asientos {
+		asientoCopia := *asiento
+		copia[numero] = &asientoCopia
+	}
+	return copia
 }
 
 // HTTP Handlers
@@ -313,13 +485,13 @@ func (rs *ReservationServer) GetAsientos() (map[int]*Asiento, error) {
 func (rs *ReservationServer) handleGetAsientos(w http.ResponseWriter, r *http.Request) {
 	asientos, err := rs.GetAsientos()
 	if err != nil {
-		http.Error(w, "Failed to get seats", http.StatusInternalServerError)
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to get seats")
 		return
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"asientos": asientos,
+		"asientos":  asientos,
 		"server_id": rs.serverID,
 	})
 }
@@ -328,33 +500,33 @@ func (rs *ReservationServer) handleReservarAsiento(w http.ResponseWriter, r *htt
 	var req struct {
 		Numero  int    `json:"numero"`
 		Cliente string `json:"cliente"`
+		// Grupo de GROUP_QUOTAS a cuya cuota cuenta la reserva (opcional)
+		Grupo string `json:"grupo"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		httperr.WriteDecodeError(w, err)
 		return
 	}
 
 	if req.Cliente == "" {
-		http.Error(w, "Cliente is required", http.StatusBadRequest)
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Cliente is required")
 		return
 	}
 
-	success, message := rs.ReservarAsiento(req.Numero, req.Cliente)
-	
-	response := map[string]interface{}{
-		"success": success,
-		"message": message,
-		"server_id": rs.serverID,
+	recibo, apiErr := rs.ReservarAsiento(req.Numero, req.Cliente, req.Grupo)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if success {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusConflict)
-	}
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "Asiento reservado exitosamente",
+		"codigo":    recibo.Codigo,
+		"server_id": rs.serverID,
+	})
 }
 
 func (rs *ReservationServer) handleLiberarAsiento(w http.ResponseWriter, r *http.Request) {
@@ -363,34 +535,127 @@ func (rs *ReservationServer) handleLiberarAsiento(w http.ResponseWriter, r *http
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	message, apiErr := rs.LiberarAsiento(req.Numero)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   message,
+		"server_id": rs.serverID,
+	})
+}
+
+func (rs *ReservationServer) handleRetenerAsiento(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Numero   int    `json:"numero"`
+		Cliente  string `json:"cliente"`
+		Segundos int    `json:"segundos"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	if req.Cliente == "" {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Cliente is required")
+		return
+	}
+
+	duracion := time.Duration(req.Segundos) * time.Second
+	if duracion <= 0 {
+		duracion = rs.holdDefault
+	}
+
+	message, apiErr := rs.RetenerAsiento(req.Numero, req.Cliente, duracion)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
 		return
 	}
 
-	success, message := rs.LiberarAsiento(req.Numero)
-	
-	response := map[string]interface{}{
-		"success": success,
-		"message": message,
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   message,
 		"server_id": rs.serverID,
+	})
+}
+
+func (rs *ReservationServer) handleConfirmarAsiento(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Numero  int    `json:"numero"`
+		Cliente string `json:"cliente"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	recibo, apiErr := rs.ConfirmarAsiento(req.Numero, req.Cliente)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if success {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "Reserva confirmada",
+		"codigo":    recibo.Codigo,
+		"server_id": rs.serverID,
+	})
+}
+
+func (rs *ReservationServer) handleGetReputacion(w http.ResponseWriter, r *http.Request) {
+	cliente := mux.Vars(r)["id"]
+
+	rep, err := rs.clientes.Get(r.Context(), cliente)
+	if err != nil {
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to get reputation")
+		return
 	}
-	json.NewEncoder(w).Encode(response)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cliente":         rep.Cliente,
+		"no_shows":        rep.NoShows,
+		"total_no_shows":  rep.TotalNoShows,
+		"bloqueado":       rep.Bloqueado(time.Now()),
+		"bloqueado_hasta": rep.BloqueadoHasta,
+		"server_id":       rs.serverID,
+	})
+}
+
+func (rs *ReservationServer) handleInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"server_id":       rs.serverID,
+		"coordinator_url": rs.coordinatorURL,
+		"mongo":           rs.mongoSettings,
+	})
 }
 
 func (rs *ReservationServer) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
+	rs.mutex.RLock()
+	seatsCount := len(rs.asientos)
+	rs.mutex.RUnlock()
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status": "healthy",
-		"server_id": rs.serverID,
-		"time": time.Now().Format(time.RFC3339),
-		"seats_count": len(rs.asientos),
+		"status":      "healthy",
+		"server_id":   rs.serverID,
+		"time":        time.Now().Format(time.RFC3339),
+		"seats_count": seatsCount,
+		"maintenance": rs.maintenance.Load(),
 	})
 }
 
@@ -428,24 +693,127 @@ func main() {
 		log.Fatal("Failed to ping MongoDB:", err)
 	}
 
-	collection := client.Database("reservations_db").Collection("seats")
+	// Durabilidad y enrutado de lecturas configurables sin recompilar
+	dbOpts, mongoSettings, err := mongoDatabaseOptions(os.Getenv("MONGO_WRITE_CONCERN"), os.Getenv("MONGO_READ_PREFERENCE"))
+	if err != nil {
+		log.Fatal("Invalid MongoDB settings:", err)
+	}
+	log.Printf("MongoDB write concern: %s, read preference: %s", mongoSettings.WriteConcern, mongoSettings.ReadPreference)
+
+	db := client.Database("reservations_db", dbOpts)
+	collection := db.Collection("seats")
+
+	// Reputación de clientes: bloqueo temporal tras varios no-shows
+	clientes := NewClientStore(
+		db.Collection("clientes"),
+		getEnvInt("NOSHOW_THRESHOLD", 3),
+		time.Duration(getEnvInt("NOSHOW_COOLDOWN_S", 600))*time.Second,
+	)
 
 	// Crear servidor de reservas
-	server := NewReservationServer(serverID, coordinatorURL, collection)
+	server := NewReservationServer(serverID, coordinatorURL, collection, clientes, seatInitFromEnv())
+	server.recibos = NewReciboStore(db.Collection("recibos"))
+	// Precio de un asiento, que queda anotado en el recibo de cada reserva
+	seatPrice := os.Getenv("SEAT_PRICE")
+	if seatPrice == "" {
+		seatPrice = "10"
+	}
+	server.precioBase, err = strconv.ParseFloat(seatPrice, 64)
+	if err != nil || server.precioBase < 0 {
+		log.Fatalf("SEAT_PRICE must be a non-negative number, got %q", seatPrice)
+	}
+	// Precios fijados con /admin/precio y, con PRICING_STRATEGY=demand,
+	// recargo según la ocupación (PRICING_DEMAND_SURCHARGE=1 dobla el precio
+	// con la sala llena)
+	server.precios = NewPrecioStore(db.Collection("precios"))
+	server.pricing, err = parsePricingStrategy(os.Getenv("PRICING_STRATEGY"))
+	if err != nil {
+		log.Fatal("Invalid PRICING_STRATEGY:", err)
+	}
+	surcharge := os.Getenv("PRICING_DEMAND_SURCHARGE")
+	if surcharge == "" {
+		surcharge = "1"
+	}
+	server.recargoDemanda, err = strconv.ParseFloat(surcharge, 64)
+	if err != nil || server.recargoDemanda < 0 {
+		log.Fatalf("PRICING_DEMAND_SURCHARGE must be a non-negative number, got %q", surcharge)
+	}
+	log.Printf("Server %s: Pricing strategy %s (base price %.2f)", serverID, server.pricing, server.precioBase)
+	server.holdDefault = time.Duration(getEnvInt("HOLD_DEFAULT_S", 120)) * time.Second
+	server.holdMax = time.Duration(getEnvInt("HOLD_MAX_S", 600)) * time.Second
+	// Un cliente con sesión que pasa SESSION_TIMEOUT_S sin enviar latidos
+	// pierde sus asientos (0 = sin sesiones); se comprueba cada SESSION_SWEEP_S
+	server.sessionTimeout = time.Duration(getEnvInt("SESSION_TIMEOUT_S", 60)) * time.Second
+	if server.sessionTimeout > 0 {
+		server.sesiones = NewSesionStore(db.Collection("sesiones"))
+		sweep := time.Duration(getEnvInt("SESSION_SWEEP_S", 5)) * time.Second
+		if sweep <= 0 {
+			log.Fatal("SESSION_SWEEP_S must be positive")
+		}
+		go server.runSessionReaper(sweep)
+		log.Printf("Server %s: Sessions expire after %s without heartbeats", serverID, server.sessionTimeout)
+	}
+	// Un resumen de la sala cada STATS_ROLLUP_S para /stats/history (0 = no
+	// se calculan, pero se sirven los de otros servidores)
+	server.estadisticas = NewStatsHistory(db.Collection("stats_history"))
+	if rollup := time.Duration(getEnvInt("STATS_ROLLUP_S", 60)) * time.Second; rollup > 0 {
+		go server.runStatsRollup(rollup)
+		log.Printf("Server %s: Stats rollup every %s", serverID, rollup)
+	}
+	server.mongoSettings = mongoSettings
+	server.adminToken = os.Getenv("ADMIN_TOKEN")
+	server.maintenanceRetry = getEnvInt("MAINTENANCE_RETRY_AFTER_S", 300)
+	server.autoRenew = os.Getenv("LOCK_AUTO_RENEW") == "true"
+	// Cuotas de asientos por grupo, p. ej. "estudiantes=30%,prensa=2"
+	server.quotas, err = parseGroupQuotas(os.Getenv("GROUP_QUOTAS"))
+	if err != nil {
+		log.Fatal("Invalid GROUP_QUOTAS:", err)
+	}
 
 	// Configurar rutas
 	r := mux.NewRouter()
+	r.Use(server.recoverPanics)
 
-       // ...existing code...
+	// ...existing code...
 
 	r.HandleFunc("/asientos", server.handleGetAsientos).Methods("GET")
-	r.HandleFunc("/reservar", server.handleReservarAsiento).Methods("POST")
-	r.HandleFunc("/liberar", server.handleLiberarAsiento).Methods("POST")
+	r.HandleFunc("/asientos/recomendar", server.handleRecomendar).Methods("GET")
+	r.HandleFunc("/cuotas", server.handleGetCuotas).Methods("GET")
+	r.HandleFunc("/stats/history", server.handleStatsHistory).Methods("GET")
+	r.HandleFunc("/reservar", server.unlessMaintenance(server.handleReservarAsiento)).Methods("POST")
+	r.HandleFunc("/reservar-cualquiera", server.unlessMaintenance(server.handleReservarCualquiera)).Methods("POST")
+	r.HandleFunc("/reservar-preferencia", server.unlessMaintenance(server.handleReservarPreferencia)).Methods("POST")
+	r.HandleFunc("/liberar", server.unlessMaintenance(server.handleLiberarAsiento)).Methods("POST")
+	r.HandleFunc("/liberar-rango", server.unlessMaintenance(server.handleLiberarRango)).Methods("POST")
+	r.HandleFunc("/liberar-todos", server.unlessMaintenance(server.handleLiberarTodos)).Methods("POST")
+	r.HandleFunc("/retener", server.unlessMaintenance(server.handleRetenerAsiento)).Methods("POST")
+	r.HandleFunc("/confirmar", server.unlessMaintenance(server.handleConfirmarAsiento)).Methods("POST")
+	r.HandleFunc("/extender", server.unlessMaintenance(server.handleExtender)).Methods("POST")
+	r.HandleFunc("/sesion/heartbeat", server.handleHeartbeat).Methods("POST")
+	r.HandleFunc("/reserva/{codigo}/recibo", server.handleGetRecibo).Methods("GET")
+	r.HandleFunc("/clientes/{id}/reputacion", server.handleGetReputacion).Methods("GET")
 	r.HandleFunc("/health", server.handleHealthCheck).Methods("GET")
-
-
+	r.HandleFunc("/info", server.handleInfo).Methods("GET")
+	r.HandleFunc("/admin/maintenance", server.handleMaintenance).Methods("POST")
+	r.HandleFunc("/admin/reconcile", server.handleReconcile).Methods("POST")
+	r.HandleFunc("/admin/precio", server.handleAdminPrecio).Methods("POST")
+	r.NotFoundHandler = http.HandlerFunc(httperr.NotFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(httperr.MethodNotAllowedHandler)
 
 	log.Printf("Reservation Server %s starting on port %s", serverID, port)
 	log.Printf("Coordinator URL: %s", coordinatorURL)
-	log.Fatal(http.ListenAndServe(":"+port, r))
-}
\ No newline at end of file
+	log.Fatal(http.ListenAndServe(":"+port, httperr.WithRequestID(r)))
+}
+
+// getEnvInt lee una variable de entorno entera, usando def si no está definida
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer, got %q", key, value)
+	}
+	return n
+}
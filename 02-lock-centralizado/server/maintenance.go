@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// requireAdmin comprueba el token de la cabecera X-Admin-Token. Si no es
+// válido escribe la respuesta de error y devuelve false.
+func (rs *ReservationServer) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if rs.adminToken == "" {
+		httperr.Write(w, http.StatusForbidden, httperr.CodeAdminDisabled, "Admin endpoints disabled (ADMIN_TOKEN not set)")
+		return false
+	}
+	if r.Header.Get("X-Admin-Token") != rs.adminToken {
+		httperr.Write(w, http.StatusUnauthorized, httperr.CodeUnauthorized, "Invalid admin token")
+		return false
+	}
+	return true
+}
+
+// unlessMaintenance envuelve un handler que modifica asientos para que
+// responda 503 mientras el modo mantenimiento esté activo
+func (rs *ReservationServer) unlessMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !rs.maintenance.Load() {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(rs.maintenanceRetry))
+		httperr.Write(w, http.StatusServiceUnavailable, CodeMaintenance, "Sistema en mantenimiento, inténtelo de nuevo más tarde")
+	}
+}
+
+// handleMaintenance activa o desactiva el modo mantenimiento. El modo sigue
+// activo hasta que un operador lo desactive.
+func (rs *ReservationServer) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !rs.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "enabled (true/false) is required")
+		return
+	}
+
+	if previous := rs.maintenance.Swap(*req.Enabled); previous != *req.Enabled {
+		log.Printf("Server %s: Maintenance mode %s", rs.serverID, map[bool]string{true: "enabled", false: "disabled"}[*req.Enabled])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"maintenance": *req.Enabled,
+		"server_id":   rs.serverID,
+	})
+}
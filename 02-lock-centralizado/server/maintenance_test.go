@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+func TestMaintenanceRequiresAdmin(t *testing.T) {
+	rs := &ReservationServer{serverID: "s1"}
+	if rec := adminPost(rs.handleMaintenance, "/admin/maintenance", `{"enabled":true}`, "x"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without ADMIN_TOKEN, got %d", rec.Code)
+	}
+	rs.adminToken = "secret"
+	if rec := adminPost(rs.handleMaintenance, "/admin/maintenance", `{"enabled":true}`, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong token, got %d", rec.Code)
+	}
+	if rec := adminPost(rs.handleMaintenance, "/admin/maintenance", `{}`, "secret"); rec.Code != http.StatusBadRequest || errorCode(t, rec) != httperr.CodeInvalidRequest {
+		t.Fatalf("expected 400 when enabled is missing, got %d", rec.Code)
+	}
+	if rs.maintenance.Load() {
+		t.Fatal("rejected requests must not change the maintenance mode")
+	}
+}
+
+func TestMaintenanceBlocksWritesUntilDisabled(t *testing.T) {
+	rs := &ReservationServer{serverID: "s1", adminToken: "secret", maintenanceRetry: 120}
+	called := 0
+	reservar := rs.unlessMaintenance(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if rec := adminPost(reservar, "/reservar", `{}`, ""); rec.Code != http.StatusOK || called != 1 {
+		t.Fatalf("expected the handler to run outside maintenance, got %d", rec.Code)
+	}
+
+	rec := adminPost(rs.handleMaintenance, "/admin/maintenance", `{"enabled":true}`, "secret")
+	var resp struct {
+		Maintenance bool `json:"maintenance"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || !resp.Maintenance {
+		t.Fatalf("expected maintenance to be reported as enabled: %v %+v", err, resp)
+	}
+
+	rec = adminPost(reservar, "/reservar", `{}`, "")
+	if rec.Code != http.StatusServiceUnavailable || called != 1 {
+		t.Fatalf("expected 503 in maintenance without running the handler, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "120" {
+		t.Fatalf("expected Retry-After 120, got %q", got)
+	}
+	if code := errorCode(t, rec); code != CodeMaintenance {
+		t.Fatalf("expected %s, got %s", CodeMaintenance, code)
+	}
+
+	health := httptest.NewRecorder()
+	rs.handleHealthCheck(health, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var status struct {
+		Maintenance bool `json:"maintenance"`
+	}
+	if err := json.NewDecoder(health.Body).Decode(&status); err != nil || !status.Maintenance {
+		t.Fatalf("/health does not report maintenance: %v %+v", err, status)
+	}
+
+	adminPost(rs.handleMaintenance, "/admin/maintenance", `{"enabled":false}`, "secret")
+	if rec := adminPost(reservar, "/reservar", `{}`, ""); rec.Code != http.StatusOK || called != 2 {
+		t.Fatalf("expected writes to resume after maintenance, got %d", rec.Code)
+	}
+}
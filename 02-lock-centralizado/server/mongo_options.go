@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// MongoSettings describe el write concern y la read preference efectivos
+type MongoSettings struct {
+	WriteConcern   string `json:"write_concern"`
+	ReadPreference string `json:"read_preference"`
+}
+
+// mongoDatabaseOptions construye las opciones de base de datos a partir de los
+// valores de MONGO_WRITE_CONCERN y MONGO_READ_PREFERENCE. Un valor vacío deja
+// el valor por defecto del driver.
+//
+// El write concern acepta "majority", un número de nodos ("0", "1", "2"...) o
+// el nombre de un tag set. La read preference acepta los modos del driver:
+// primary, primaryPreferred, secondary, secondaryPreferred y nearest.
+func mongoDatabaseOptions(writeConcern, readPreference string) (*options.DatabaseOptions, MongoSettings, error) {
+	opts := options.Database()
+	settings := MongoSettings{WriteConcern: "default", ReadPreference: "default"}
+
+	switch {
+	case writeConcern == "":
+	case writeConcern == "majority":
+		opts.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+		settings.WriteConcern = writeConcern
+	default:
+		if w, err := strconv.Atoi(writeConcern); err == nil {
+			if w < 0 {
+				return nil, settings, fmt.Errorf("invalid write concern %q", writeConcern)
+			}
+			opts.SetWriteConcern(writeconcern.New(writeconcern.W(w)))
+		} else {
+			opts.SetWriteConcern(writeconcern.New(writeconcern.WTagSet(writeConcern)))
+		}
+		settings.WriteConcern = writeConcern
+	}
+
+	if readPreference != "" {
+		mode, err := readpref.ModeFromString(readPreference)
+		if err != nil {
+			return nil, settings, fmt.Errorf("invalid read preference %q: %v", readPreference, err)
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, settings, err
+		}
+		opts.SetReadPreference(rp)
+		settings.ReadPreference = mode.String()
+	}
+
+	return opts, settings, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestMongoDatabaseOptions(t *testing.T) {
+	cases := []struct {
+		writeConcern, readPreference string
+		want                         MongoSettings
+		w                            interface{} // w del write concern (nil = por defecto)
+		mode                         readpref.Mode
+	}{
+		{"", "", MongoSettings{"default", "default"}, nil, 0},
+		{"majority", "", MongoSettings{"majority", "default"}, "majority", 0},
+		{"2", "secondaryPreferred", MongoSettings{"2", "secondaryPreferred"}, 2, readpref.SecondaryPreferredMode},
+		{"0", "nearest", MongoSettings{"0", "nearest"}, 0, readpref.NearestMode},
+		{"dc-east", "primary", MongoSettings{"dc-east", "primary"}, "dc-east", readpref.PrimaryMode},
+	}
+	for _, tc := range cases {
+		opts, settings, err := mongoDatabaseOptions(tc.writeConcern, tc.readPreference)
+		if err != nil {
+			t.Fatalf("(%q, %q): %v", tc.writeConcern, tc.readPreference, err)
+		}
+		if settings != tc.want {
+			t.Errorf("(%q, %q): settings %+v, want %+v", tc.writeConcern, tc.readPreference, settings, tc.want)
+		}
+		switch {
+		case tc.w == nil && opts.WriteConcern != nil:
+			t.Errorf("(%q): expected the driver's default write concern, got %+v", tc.writeConcern, opts.WriteConcern)
+		case tc.w != nil && (opts.WriteConcern == nil || opts.WriteConcern.GetW() != tc.w):
+			t.Errorf("(%q): expected w=%v, got %+v", tc.writeConcern, tc.w, opts.WriteConcern)
+		}
+		switch {
+		case tc.mode == 0 && opts.ReadPreference != nil:
+			t.Errorf("(%q): expected the driver's default read preference, got %v", tc.readPreference, opts.ReadPreference)
+		case tc.mode != 0 && (opts.ReadPreference == nil || opts.ReadPreference.Mode() != tc.mode):
+			t.Errorf("(%q): expected mode %v, got %v", tc.readPreference, tc.mode, opts.ReadPreference)
+		}
+	}
+}
+
+func TestMongoDatabaseOptionsRejectsInvalidValues(t *testing.T) {
+	for _, tc := range []struct{ writeConcern, readPreference string }{
+		{"-1", ""},
+		{"", "fastest"},
+	} {
+		if _, _, err := mongoDatabaseOptions(tc.writeConcern, tc.readPreference); err == nil {
+			t.Errorf("(%q, %q): expected an error", tc.writeConcern, tc.readPreference)
+		}
+	}
+}
+
+// Las escrituras de una colección obtenida con esas opciones llevan el write
+// concern configurado
+func TestMongoWriteConcernIsSentWithWrites(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		opts, _, err := mongoDatabaseOptions("majority", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		coll := mt.Client.Database("reservas", opts).Collection("asientos")
+
+		mt.AddMockResponses(writeResponse(1))
+		if _, err := coll.InsertOne(context.Background(), bson.M{"numero": 1}); err != nil {
+			t.Fatal(err)
+		}
+		ev := mt.GetStartedEvent()
+		wc, err := ev.Command.LookupErr("writeConcern", "w")
+		if err != nil || wc.StringValue() != "majority" {
+			t.Fatalf("insert was sent without w=majority: %s", ev.Command)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// occupancyClient es el cliente sintético al que se asignan los asientos que
+// empiezan ocupados
+const occupancyClient = "ocupacion-inicial"
+
+// SeatInit configura cómo se crean los asientos la primera vez
+type SeatInit struct {
+	Occupancy int   // porcentaje de asientos (0-100) que empiezan reservados
+	Seed      int64 // semilla para elegir esos asientos
+	// Secciones y pasillos de la sala (SEAT_LAYOUT); nil = sin pasillos
+	Layout *SeatLayout
+}
+
+// seatInitFromEnv lee INITIAL_OCCUPANCY (por defecto 0), SEED (por defecto
+// 1) y SEAT_LAYOUT. Con la misma semilla todos los servidores eligen los
+// mismos asientos.
+func seatInitFromEnv() SeatInit {
+	cfg := SeatInit{Occupancy: getEnvInt("INITIAL_OCCUPANCY", 0), Seed: 1}
+	if cfg.Occupancy < 0 || cfg.Occupancy > 100 {
+		log.Fatalf("INITIAL_OCCUPANCY must be between 0 and 100, got %d", cfg.Occupancy)
+	}
+	if value := os.Getenv("SEED"); value != "" {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatalf("SEED must be an integer, got %q", value)
+		}
+		cfg.Seed = seed
+	}
+	layout, err := parseSeatLayout(os.Getenv("SEAT_LAYOUT"))
+	if err != nil {
+		log.Fatalf("Invalid SEAT_LAYOUT: %v", err)
+	}
+	cfg.Layout = layout
+	return cfg
+}
+
+// preReservedSeats elige qué asientos de 1..total empiezan reservados. El
+// resultado solo depende de sus argumentos, para que una demo sea repetible.
+func preReservedSeats(total int, cfg SeatInit) []int {
+	count := (total*cfg.Occupancy + 50) / 100
+	perm := rand.New(rand.NewSource(cfg.Seed)).Perm(total)
+
+	seats := make([]int, count)
+	for i := range seats {
+		seats[i] = perm[i] + 1
+	}
+	sort.Ints(seats)
+	return seats
+}
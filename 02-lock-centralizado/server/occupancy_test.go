@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestPreReservedSeatsFixedSeed(t *testing.T) {
+	got := preReservedSeats(20, SeatInit{Occupancy: 50, Seed: 42})
+	want := []int{5, 6, 7, 10, 11, 13, 15, 17, 18, 20}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected seats %v for seed 42, got %v", want, got)
+	}
+
+	// Misma semilla, mismo resultado; otra semilla elige otros asientos
+	if again := preReservedSeats(20, SeatInit{Occupancy: 50, Seed: 42}); !reflect.DeepEqual(again, want) {
+		t.Fatalf("seed 42 is not reproducible: %v", again)
+	}
+	if other := preReservedSeats(20, SeatInit{Occupancy: 50, Seed: 7}); reflect.DeepEqual(other, want) {
+		t.Fatal("seeds 7 and 42 picked the same seats")
+	}
+	// Con menos ocupación se eligen los primeros de la misma permutación
+	if got := preReservedSeats(20, SeatInit{Occupancy: 25, Seed: 7}); !reflect.DeepEqual(got, []int{2, 4, 11, 15, 17}) {
+		t.Fatalf("unexpected seats for seed 7 at 25%%: %v", got)
+	}
+}
+
+func TestPreReservedSeatsCount(t *testing.T) {
+	cases := []struct {
+		occupancy, want int
+	}{
+		{0, 0},
+		{7, 1},  // 1.4 asientos
+		{13, 3}, // 2.6 asientos
+		{100, 20},
+	}
+	for _, tc := range cases {
+		if got := preReservedSeats(20, SeatInit{Occupancy: tc.occupancy, Seed: 1}); len(got) != tc.want {
+			t.Errorf("occupancy %d%%: expected %d seats, got %v", tc.occupancy, tc.want, got)
+		}
+	}
+}
+
+func TestSeatInitFromEnv(t *testing.T) {
+	t.Setenv("INITIAL_OCCUPANCY", "")
+	t.Setenv("SEED", "")
+	t.Setenv("SEAT_LAYOUT", "")
+	if cfg := seatInitFromEnv(); cfg.Occupancy != 0 || cfg.Seed != 1 || cfg.Layout != nil {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+
+	t.Setenv("INITIAL_OCCUPANCY", "40")
+	t.Setenv("SEED", "-3")
+	if cfg := seatInitFromEnv(); cfg.Occupancy != 40 || cfg.Seed != -3 {
+		t.Fatalf("expected occupancy 40 and seed -3, got %+v", cfg)
+	}
+}
+
+func TestInitializeSeatsPreReservesSeededSeats(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, _ := newTestServer(t, newFakeReservaStore())
+		rs.collection = mt.Coll
+		rs.asientos = make(map[int]*Asiento)
+
+		// Colección vacía y luego una escritura por asiento creado
+		responses := []bson.D{findResponse()}
+		for i := 0; i < 20; i++ {
+			responses = append(responses, writeResponse(1))
+		}
+		mt.AddMockResponses(responses...)
+		mt.ClearEvents()
+		rs.initializeSeats(SeatInit{Occupancy: 50, Seed: 42})
+
+		if len(rs.asientos) != 20 {
+			t.Fatalf("expected 20 seats, got %d", len(rs.asientos))
+		}
+		var reservados []int
+		for i := 1; i <= 20; i++ {
+			asiento := rs.asientos[i]
+			if asiento.Disponible {
+				continue
+			}
+			if asiento.Cliente != occupancyClient {
+				t.Fatalf("seat %d reserved to %q, expected %q", i, asiento.Cliente, occupancyClient)
+			}
+			reservados = append(reservados, i)
+		}
+		if want := []int{5, 6, 7, 10, 11, 13, 15, 17, 18, 20}; !reflect.DeepEqual(reservados, want) {
+			t.Fatalf("expected pre-reserved seats %v, got %v", want, reservados)
+		}
+
+		// Lo guardado en MongoDB coincide con la caché
+		mt.GetStartedEvent() // find
+		for i := 1; i <= 20; i++ {
+			ev := mt.GetStartedEvent()
+			if ev == nil || ev.CommandName != "update" {
+				t.Fatalf("expected an upsert of seat %d, got %+v", i, ev)
+			}
+			numero := ev.Command.Lookup("updates", "0", "q", "numero").AsInt64()
+			disponible := ev.Command.Lookup("updates", "0", "u", "disponible").Boolean()
+			// cliente se omite en los asientos libres
+			cliente, _ := ev.Command.Lookup("updates", "0", "u", "cliente").StringValueOK()
+			asiento := rs.asientos[int(numero)]
+			if disponible != asiento.Disponible || cliente != asiento.Cliente {
+				t.Fatalf("seat %d saved as disponible=%v cliente=%q, cached as %+v", numero, disponible, cliente, asiento)
+			}
+		}
+	})
+}
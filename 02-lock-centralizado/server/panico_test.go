@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// checkRolledBack comprueba que el pánico se devolvió como un 500, que el
+// asiento quedó libre y que el bloqueo se liberó una sola vez
+func checkRolledBack(t *testing.T, rs *ReservationServer, coordinator *fakeCoordinator, recibo *Recibo, apiErr *APIError) {
+	t.Helper()
+	if recibo != nil {
+		t.Fatalf("expected no receipt, got %+v", recibo)
+	}
+	if apiErr == nil || apiErr.Status != http.StatusInternalServerError || apiErr.Code != httperr.CodeInternal {
+		t.Fatalf("expected a 500 %s, got %+v", httperr.CodeInternal, apiErr)
+	}
+	rec := httptest.NewRecorder()
+	writeAPIError(rec, apiErr)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the handler to answer 500, got %d", rec.Code)
+	}
+
+	asiento := rs.asientos[7]
+	if !asiento.Disponible || asiento.Cliente != "" || asiento.Codigo != "" {
+		t.Fatalf("seat 7 was not rolled back: %+v", asiento)
+	}
+	if n := coordinator.releasesOf("lock-1"); n != 1 {
+		t.Fatalf("expected the lock to be released once, got %d releases", n)
+	}
+	if len(rs.activeLocks) != 0 || len(rs.claimedLocks) != 0 {
+		t.Fatalf("lock still tracked: active %v, claimed %v", rs.activeLocks, rs.claimedLocks)
+	}
+}
+
+func TestReservationPanicInSeatWriteRollsBack(t *testing.T) {
+	store := newFakeReservaStore()
+	store.panicAsiento = 1
+	rs, coordinator := newTestServer(t, store)
+
+	recibo, apiErr := rs.reservarAsiento(7, "ana", "")
+	checkRolledBack(t, rs, coordinator, recibo, apiErr)
+
+	if len(store.recibos) != 0 {
+		t.Fatalf("receipt of the failed reservation was kept: %v", store.recibos)
+	}
+	if saved, ok := store.asientos[7]; !ok || !saved.Disponible || saved.Cliente != "" {
+		t.Fatalf("seat 7 was not restored in the store: %+v", saved)
+	}
+
+	// El servidor sigue atendiendo: ni el mutex ni el bloqueo quedaron tomados
+	recibo, apiErr = rs.reservarAsiento(7, "luis", "")
+	if apiErr != nil {
+		t.Fatalf("reservation after the panic failed: %+v", apiErr)
+	}
+	if rs.asientos[7].Cliente != "luis" || store.recibos[recibo.Codigo] == nil {
+		t.Fatalf("reservation after the panic was not saved: %+v", rs.asientos[7])
+	}
+	if n := coordinator.releasesOf("lock-2"); n != 1 {
+		t.Fatalf("expected the second lock to be released once, got %d", n)
+	}
+}
+
+func TestReservationPanicInReceiptWriteRollsBack(t *testing.T) {
+	store := newFakeReservaStore()
+	store.panicRecibo = true
+	rs, coordinator := newTestServer(t, store)
+
+	recibo, apiErr := rs.reservarAsiento(7, "ana", "")
+	checkRolledBack(t, rs, coordinator, recibo, apiErr)
+	if saved := store.asientos[7]; !saved.Disponible {
+		t.Fatalf("seat 7 was saved as reserved: %+v", saved)
+	}
+}
+
+func TestReservationPanicDuringRestoreStillReleasesOnce(t *testing.T) {
+	store := newFakeReservaStore()
+	// Falla la escritura y también la que intenta restaurar el asiento
+	store.panicAsiento = 2
+	rs, coordinator := newTestServer(t, store)
+
+	recibo, apiErr := rs.reservarAsiento(7, "ana", "")
+	checkRolledBack(t, rs, coordinator, recibo, apiErr)
+	if _, saved := store.asientos[7]; saved {
+		t.Fatalf("store should not have recorded seat 7: %+v", store.asientos[7])
+	}
+}
+
+// La liberación escribe por el mismo almacén que la reserva: si la escritura
+// falla el asiento sigue reservado, y si no, queda libre también en la BD
+func TestReleaseWritesThroughTheStore(t *testing.T) {
+	store := newFakeReservaStore()
+	rs, coordinator := newTestServer(t, store)
+	recibo, apiErr := rs.reservarAsiento(7, "ana", "")
+	if apiErr != nil {
+		t.Fatalf("reservation failed: %+v", apiErr)
+	}
+
+	store.errAsiento = errors.New("disk full")
+	if _, apiErr := rs.LiberarAsiento(7); apiErr == nil || apiErr.Code != CodeDatabaseError {
+		t.Fatalf("expected %s when the seat write fails, got %+v", CodeDatabaseError, apiErr)
+	}
+	if asiento := rs.asientos[7]; asiento.Disponible || asiento.Cliente != "ana" || asiento.Codigo != recibo.Codigo {
+		t.Fatalf("failed release changed the cached seat: %+v", asiento)
+	}
+	if saved := store.asientos[7]; saved.Disponible || saved.Cliente != "ana" {
+		t.Fatalf("failed release changed the stored seat: %+v", saved)
+	}
+
+	store.errAsiento = nil
+	if _, apiErr := rs.LiberarAsiento(7); apiErr != nil {
+		t.Fatalf("release failed: %+v", apiErr)
+	}
+	if saved := store.asientos[7]; !saved.Disponible || saved.Cliente != "" || saved.Codigo != "" {
+		t.Fatalf("release was not saved in the store: %+v", saved)
+	}
+	if n := coordinator.releasesOf("lock-3"); n != 1 {
+		t.Fatalf("expected the release lock to be released once, got %d", n)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// maxPreferencias limita la lista de preferencias de una petición
+const maxPreferencias = 20
+
+// Preferencia es una opción de la lista de /reservar-preferencia: un asiento
+// concreto o cualquier asiento libre de una sección de SEAT_LAYOUT
+type Preferencia struct {
+	Numero  int    `json:"numero,omitempty"`
+	Seccion string `json:"seccion,omitempty"`
+}
+
+func (p Preferencia) String() string {
+	if p.Seccion != "" {
+		return "sección " + p.Seccion
+	}
+	return fmt.Sprintf("asiento %d", p.Numero)
+}
+
+// IntentoPreferencia es lo que pasó con una preferencia que no se pudo
+// cumplir
+type IntentoPreferencia struct {
+	Preferencia Preferencia `json:"preferencia"`
+	Code        string      `json:"code"`
+	Message     string      `json:"message"`
+}
+
+// validarPreferencias comprueba que cada preferencia indique un asiento o una
+// sección, pero no ambos
+func validarPreferencias(preferencias []Preferencia) *APIError {
+	if len(preferencias) == 0 {
+		return newAPIError(http.StatusBadRequest, httperr.CodeInvalidRequest, "Preferencias is required")
+	}
+	if len(preferencias) > maxPreferencias {
+		return newAPIError(http.StatusBadRequest, httperr.CodeInvalidRequest,
+			fmt.Sprintf("Too many preferencias (max %d)", maxPreferencias))
+	}
+	for i, p := range preferencias {
+		if (p.Numero == 0) == (p.Seccion == "") {
+			return newAPIError(http.StatusBadRequest, httperr.CodeInvalidRequest,
+				fmt.Sprintf("Preferencia %d must have either numero or seccion", i))
+		}
+		if p.Numero < 0 {
+			return newAPIError(http.StatusBadRequest, httperr.CodeInvalidRequest,
+				fmt.Sprintf("Preferencia %d has an invalid numero", i))
+		}
+	}
+	return nil
+}
+
+// recorrerPreferencias prueba las preferencias en orden y se queda con el
+// primer asiento que reservar consigue. Una sección se expande con libres en
+// sus asientos libres de menor a mayor; un asiento ya probado no se repite.
+// Un asiento ocupado, bloqueado por otro cliente o inexistente hace pasar a
+// la siguiente opción; cualquier otro error corta la búsqueda. Devuelve el
+// asiento y la posición de la preferencia que lo dio, y los intentos fallidos.
+func recorrerPreferencias(preferencias []Preferencia,
+	libres func(seccion string) ([]int, *APIError),
+	reservar func(numero int) (*Asiento, *APIError)) (*Asiento, int, []IntentoPreferencia, *APIError) {
+
+	intentos := []IntentoPreferencia{}
+	probados := make(map[int]bool)
+	for i, p := range preferencias {
+		candidatos := []int{p.Numero}
+		if p.Seccion != "" {
+			var apiErr *APIError
+			if candidatos, apiErr = libres(p.Seccion); apiErr != nil {
+				return nil, -1, intentos, apiErr
+			}
+		}
+
+		var ultimo *APIError
+		for _, numero := range candidatos {
+			if probados[numero] {
+				continue
+			}
+			probados[numero] = true
+
+			asiento, apiErr := reservar(numero)
+			if apiErr == nil {
+				return asiento, i, intentos, nil
+			}
+			if apiErr.Code != CodeSeatLocked && apiErr.Code != CodeSeatTaken && apiErr.Code != CodeSeatNotFound {
+				return nil, -1, intentos, apiErr
+			}
+			ultimo = apiErr
+		}
+
+		intento := IntentoPreferencia{Preferencia: p, Code: CodeNoSeatsAvailable,
+			Message: fmt.Sprintf("No quedan asientos libres en la sección %s", p.Seccion)}
+		if p.Seccion == "" {
+			intento.Code, intento.Message = httperr.CodeInvalidRequest, "Asiento repetido en la lista"
+			if ultimo != nil {
+				intento.Code, intento.Message = ultimo.Code, ultimo.Message
+			}
+		}
+		intentos = append(intentos, intento)
+	}
+	return nil, -1, intentos, nil
+}
+
+// ReservarPreferencia reserva el primer asiento disponible de una lista de
+// preferencias y devuelve cuál se asignó, qué preferencia lo dio y por qué
+// fallaron las anteriores.
+//
+// Cada asiento se reserva con reservarSiLibre, así que solo se tiene un
+// bloqueo del coordinador a la vez y se para en el primer éxito. Los asientos
+// de una sección se leen de MongoDB solo al llegar a esa preferencia.
+func (rs *ReservationServer) ReservarPreferencia(cliente string, preferencias []Preferencia) (*Asiento, int, []IntentoPreferencia, *APIError) {
+	if apiErr := validarPreferencias(preferencias); apiErr != nil {
+		return nil, -1, nil, apiErr
+	}
+	if apiErr := rs.checkClienteBloqueado(cliente); apiErr != nil {
+		return nil, -1, nil, apiErr
+	}
+
+	libres := func(seccion string) ([]int, *APIError) {
+		asientos, err := rs.GetAsientos()
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, CodeDatabaseError, fmt.Sprintf("Error reading seats: %v", err))
+		}
+		return candidatosLibres(asientos, seccion), nil
+	}
+	reservar := func(numero int) (*Asiento, *APIError) {
+		return rs.reservarSiLibre(numero, cliente)
+	}
+
+	asiento, elegida, intentos, apiErr := recorrerPreferencias(preferencias, libres, reservar)
+	if apiErr != nil {
+		return nil, -1, intentos, apiErr
+	}
+	if asiento == nil {
+		motivos := make([]string, len(intentos))
+		for i, intento := range intentos {
+			motivos[i] = fmt.Sprintf("%s: %s", intento.Preferencia, intento.Message)
+		}
+		return nil, -1, intentos, newAPIError(http.StatusConflict, CodeNoSeatsAvailable,
+			"Ninguna de las preferencias está disponible ("+strings.Join(motivos, "; ")+")")
+	}
+	log.Printf("Server %s: Seat %d assigned to %s (preference %d: %s)", rs.serverID, asiento.Numero, cliente, elegida+1, preferencias[elegida])
+	return asiento, elegida, intentos, nil
+}
+
+func (rs *ReservationServer) handleReservarPreferencia(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cliente      string        `json:"cliente"`
+		Preferencias []Preferencia `json:"preferencias"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	if req.Cliente == "" {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Cliente is required")
+		return
+	}
+	for i := range req.Preferencias {
+		req.Preferencias[i].Seccion = strings.TrimSpace(req.Preferencias[i].Seccion)
+	}
+
+	asiento, elegida, intentos, apiErr := rs.ReservarPreferencia(req.Cliente, req.Preferencias)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"message":     "Asiento reservado exitosamente",
+		"numero":      asiento.Numero,
+		"codigo":      asiento.Codigo,
+		"preferencia": elegida,
+		"intentos":    intentos,
+		"asiento":     asiento,
+		"server_id":   rs.serverID,
+	})
+}
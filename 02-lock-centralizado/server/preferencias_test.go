@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// ocupar reserva numero para cliente en la caché y en el almacén
+func ocupar(rs *ReservationServer, store *fakeReservaStore, numero int, cliente string) {
+	asiento := nuevoAsiento(numero, cliente)
+	rs.asientos[numero] = &asiento
+	store.asientos[numero] = asiento
+}
+
+func TestReservarPreferenciaFallsBackToTheSecondChoice(t *testing.T) {
+	rs, store := newCacheTestServer(t, 12)
+	coordinator := newFakeCoordinator(t)
+	rs.coordinatorURL = coordinator.URL
+	ocupar(rs, store, 10, "luis")
+
+	body := `{"cliente":"ana","preferencias":[{"numero":10},{"numero":11},{"numero":12}]}`
+	rec := httptest.NewRecorder()
+	rs.handleReservarPreferencia(rec, httptest.NewRequest(http.MethodPost, "/reservar-preferencia", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Numero      int                  `json:"numero"`
+		Codigo      string               `json:"codigo"`
+		Preferencia int                  `json:"preferencia"`
+		Intentos    []IntentoPreferencia `json:"intentos"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Numero != 11 || resp.Preferencia != 1 || resp.Codigo == "" {
+		t.Fatalf("expected seat 11 from the second preference, got %+v", resp)
+	}
+	if len(resp.Intentos) != 1 || resp.Intentos[0].Preferencia.Numero != 10 || resp.Intentos[0].Code != CodeSeatTaken {
+		t.Fatalf("expected seat 10 reported as taken, got %+v", resp.Intentos)
+	}
+
+	if asiento := store.asientos[11]; asiento.Disponible || asiento.Cliente != "ana" {
+		t.Fatalf("seat 11 was not saved for ana: %+v", asiento)
+	}
+	if asiento := store.asientos[12]; !asiento.Disponible {
+		t.Fatalf("search went on after the first success: %+v", asiento)
+	}
+	if asiento := store.asientos[10]; asiento.Cliente != "luis" {
+		t.Fatalf("taken seat 10 was modified: %+v", asiento)
+	}
+	// Solo se bloquearon los asientos probados, y de uno en uno
+	coordinator.mu.Lock()
+	defer coordinator.mu.Unlock()
+	if coordinator.granted != 2 || len(coordinator.held) != 0 {
+		t.Fatalf("expected 2 locks, all released; got %d granted, %v held", coordinator.granted, coordinator.held)
+	}
+}
+
+func TestReservarPreferenciaAllTaken(t *testing.T) {
+	rs, store := newCacheTestServer(t, 3)
+	ocupar(rs, store, 1, "luis")
+	ocupar(rs, store, 2, "marta")
+
+	asiento, _, intentos, apiErr := rs.ReservarPreferencia("ana", []Preferencia{{Numero: 1}, {Numero: 2}, {Numero: 9}})
+	if asiento != nil {
+		t.Fatalf("expected no seat, got %+v", asiento)
+	}
+	if apiErr == nil || apiErr.Status != http.StatusConflict || apiErr.Code != CodeNoSeatsAvailable {
+		t.Fatalf("expected 409 %s, got %+v", CodeNoSeatsAvailable, apiErr)
+	}
+	wantCodes := []string{CodeSeatTaken, CodeSeatTaken, CodeSeatNotFound}
+	if len(intentos) != len(wantCodes) {
+		t.Fatalf("expected %d attempts, got %+v", len(wantCodes), intentos)
+	}
+	for i, code := range wantCodes {
+		if intentos[i].Code != code {
+			t.Errorf("attempt %d: expected %s, got %s", i, code, intentos[i].Code)
+		}
+	}
+	if store.asientos[1].Cliente != "luis" || store.asientos[2].Cliente != "marta" || !store.asientos[3].Disponible {
+		t.Fatalf("a failed search modified the seats: %+v", store.asientos)
+	}
+	if len(store.recibos) != 0 {
+		t.Fatalf("a failed search issued receipts: %v", store.recibos)
+	}
+}
+
+func TestValidarPreferencias(t *testing.T) {
+	invalid := [][]Preferencia{
+		nil,
+		{{}},
+		{{Numero: 3, Seccion: "A"}},
+		{{Numero: -1}},
+		make([]Preferencia, maxPreferencias+1),
+	}
+	for _, preferencias := range invalid {
+		if apiErr := validarPreferencias(preferencias); apiErr == nil || apiErr.Code != httperr.CodeInvalidRequest {
+			t.Errorf("validarPreferencias(%v) accepted an invalid list", preferencias)
+		}
+	}
+	if apiErr := validarPreferencias([]Preferencia{{Numero: 3}, {Seccion: "A"}}); apiErr != nil {
+		t.Fatalf("valid list rejected: %+v", apiErr)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Estrategias de precio (PRICING_STRATEGY)
+const (
+	// PricingStatic cobra el precio fijado, sin más
+	PricingStatic = "static"
+	// PricingDemand encarece el precio fijado a medida que se llena la sala
+	PricingDemand = "demand"
+)
+
+// parsePricingStrategy valida PRICING_STRATEGY; vacío es static
+func parsePricingStrategy(s string) (string, error) {
+	switch strings.TrimSpace(s) {
+	case "", PricingStatic:
+		return PricingStatic, nil
+	case PricingDemand:
+		return PricingDemand, nil
+	}
+	return "", fmt.Errorf("unknown pricing strategy %q (expected %s or %s)", s, PricingStatic, PricingDemand)
+}
+
+// PrecioFijado es un precio que un operador asignó con /admin/precio a un
+// asiento o a todos los de una categoría (sección de SEAT_LAYOUT)
+type PrecioFijado struct {
+	ID        string    `bson:"_id" json:"-"`
+	Numero    int       `bson:"numero,omitempty" json:"numero,omitempty"`
+	Categoria string    `bson:"categoria,omitempty" json:"categoria,omitempty"`
+	Precio    float64   `bson:"precio" json:"precio"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// TablaPrecios son los precios fijados vigentes. El de un asiento manda sobre
+// el de su categoría, y este sobre SEAT_PRICE.
+type TablaPrecios struct {
+	porAsiento   map[int]float64
+	porCategoria map[string]float64
+}
+
+// base devuelve el precio fijado del asiento, antes de aplicar la estrategia
+func (t TablaPrecios) base(asiento *Asiento, defecto float64) float64 {
+	if precio, ok := t.porAsiento[asiento.Numero]; ok {
+		return precio
+	}
+	if precio, ok := t.porCategoria[asiento.Seccion]; ok && asiento.Seccion != "" {
+		return precio
+	}
+	return defecto
+}
+
+// PrecioStore guarda los precios fijados en su propia colección de MongoDB.
+// No van en el documento del asiento para que las reservas, que reescriben
+// el asiento entero desde la caché, no pisen un precio que otro servidor
+// acaba de cambiar.
+type PrecioStore struct {
+	collection *mongo.Collection
+}
+
+// NewPrecioStore crea un almacén de precios sobre la colección indicada
+func NewPrecioStore(collection *mongo.Collection) *PrecioStore {
+	return &PrecioStore{collection: collection}
+}
+
+// Fijar guarda (o sustituye) el precio de un asiento o de una categoría
+func (s *PrecioStore) Fijar(ctx context.Context, precio PrecioFijado) error {
+	precio.ID = fmt.Sprintf("asiento:%d", precio.Numero)
+	if precio.Categoria != "" {
+		precio.ID = "categoria:" + precio.Categoria
+	}
+	precio.UpdatedAt = time.Now()
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"_id": precio.ID}, precio, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Tabla lee todos los precios fijados. Sin almacén la tabla está vacía.
+func (s *PrecioStore) Tabla(ctx context.Context) (TablaPrecios, error) {
+	tabla := TablaPrecios{porAsiento: map[int]float64{}, porCategoria: map[string]float64{}}
+	if s == nil {
+		return tabla, nil
+	}
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return tabla, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var precio PrecioFijado
+		if err := cursor.Decode(&precio); err != nil {
+			return tabla, err
+		}
+		if precio.Categoria != "" {
+			tabla.porCategoria[precio.Categoria] = precio.Precio
+		} else {
+			tabla.porAsiento[precio.Numero] = precio.Precio
+		}
+	}
+	return tabla, cursor.Err()
+}
+
+// precioPorDemanda sube el precio con la ocupación de la sala: con todos los
+// asientos libres cuesta base y con la sala llena base*(1+recargo)
+func precioPorDemanda(base float64, libres, total int, recargo float64) float64 {
+	if total <= 0 || libres >= total {
+		return base
+	}
+	ocupacion := float64(total-libres) / float64(total)
+	return math.Round(base*(1+recargo*ocupacion)*100) / 100
+}
+
+// precio aplica la estrategia de precios al precio fijado del asiento, con
+// libres de total asientos disponibles
+func (rs *ReservationServer) precio(asiento *Asiento, tabla TablaPrecios, libres, total int) float64 {
+	base := tabla.base(asiento, rs.precioBase)
+	if rs.pricing != PricingDemand {
+		return base
+	}
+	return precioPorDemanda(base, libres, total, rs.recargoDemanda)
+}
+
+// contarLibres cuenta los asientos disponibles de la caché.
+// ASUME QUE rs.mutex YA ESTÁ ADQUIRIDO.
+func (rs *ReservationServer) contarLibres() int {
+	libres := 0
+	for _, asiento := range rs.asientos {
+		if asiento.Disponible {
+			libres++
+		}
+	}
+	return libres
+}
+
+// precioAsiento es el precio que se cobra por el asiento al reservarlo. Es el
+// mismo que /asientos mostraba justo antes, así que el asiento cuenta como
+// libre aunque el llamador ya lo haya marcado como ocupado.
+// ASUME QUE rs.mutex YA ESTÁ ADQUIRIDO.
+func (rs *ReservationServer) precioAsiento(asiento *Asiento) (float64, error) {
+	tabla, err := rs.precios.Tabla(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	libres := rs.contarLibres()
+	if !asiento.Disponible {
+		libres++
+	}
+	return rs.precio(asiento, tabla, libres, len(rs.asientos)), nil
+}
+
+// asignarPrecios calcula el precio vigente de cada asiento de la caché con
+// una sola lectura de la tabla de precios y de la ocupación, para que todos
+// los precios de una misma respuesta sean coherentes entre sí.
+// ASUME QUE rs.mutex YA ESTÁ ADQUIRIDO.
+func (rs *ReservationServer) asignarPrecios() error {
+	tabla, err := rs.precios.Tabla(context.Background())
+	if err != nil {
+		return err
+	}
+	libres := rs.contarLibres()
+	for _, asiento := range rs.asientos {
+		asiento.Precio = rs.precio(asiento, tabla, libres, len(rs.asientos))
+	}
+	return nil
+}
+
+// handleAdminPrecio fija el precio de un asiento ({numero, precio}) o de
+// todos los de una categoría ({categoria, precio}). Las reservas ya hechas
+// conservan el precio de su recibo.
+func (rs *ReservationServer) handleAdminPrecio(w http.ResponseWriter, r *http.Request) {
+	if !rs.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Numero    int      `json:"numero"`
+		Categoria string   `json:"categoria"`
+		Precio    *float64 `json:"precio"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+	req.Categoria = strings.TrimSpace(req.Categoria)
+	if (req.Numero == 0) == (req.Categoria == "") {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Either numero or categoria is required")
+		return
+	}
+	if req.Precio == nil || *req.Precio < 0 || math.IsInf(*req.Precio, 0) || math.IsNaN(*req.Precio) {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "precio must be a non-negative number")
+		return
+	}
+
+	asientos, err := rs.GetAsientos()
+	if err != nil {
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to get seats")
+		return
+	}
+	if req.Numero != 0 {
+		if _, ok := asientos[req.Numero]; !ok {
+			httperr.Write(w, http.StatusNotFound, CodeSeatNotFound, "Asiento no existe")
+			return
+		}
+	} else if len(candidatosCategoria(asientos, req.Categoria)) == 0 {
+		httperr.Write(w, http.StatusNotFound, httperr.CodeNotFound, fmt.Sprintf("No hay asientos en la categoría %s", req.Categoria))
+		return
+	}
+
+	precio := PrecioFijado{Numero: req.Numero, Categoria: req.Categoria, Precio: *req.Precio}
+	if err := rs.precios.Fijar(r.Context(), precio); err != nil {
+		writeAPIError(w, errDatabase(err))
+		return
+	}
+	if req.Categoria != "" {
+		log.Printf("Server %s: Price of category %s set to %.2f", rs.serverID, req.Categoria, *req.Precio)
+	} else {
+		log.Printf("Server %s: Price of seat %d set to %.2f", rs.serverID, req.Numero, *req.Precio)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"numero":    req.Numero,
+		"categoria": req.Categoria,
+		"precio":    *req.Precio,
+		"strategy":  rs.pricing,
+		"server_id": rs.serverID,
+	})
+}
+
+// candidatosCategoria devuelve los números de los asientos de una categoría
+func candidatosCategoria(asientos map[int]*Asiento, categoria string) []int {
+	var numeros []int
+	for numero, asiento := range asientos {
+		if asiento.Seccion == categoria {
+			numeros = append(numeros, numero)
+		}
+	}
+	return numeros
+}
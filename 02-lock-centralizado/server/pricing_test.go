@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// precioDoc es el documento de MongoDB del precio fijado para un asiento
+func precioDoc(numero int, precio float64) bson.D {
+	return bson.D{
+		{Key: "_id", Value: fmt.Sprintf("asiento:%d", numero)},
+		{Key: "numero", Value: numero},
+		{Key: "precio", Value: precio},
+	}
+}
+
+func TestAdminPrecioUpdatesSeatPrice(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, _ := newCacheTestServer(t, 3)
+		rs.adminToken = "secret"
+		rs.precioBase = 10
+		rs.pricing = PricingStatic
+		rs.precios = NewPrecioStore(mt.Coll)
+
+		if rec := adminPost(rs.handleAdminPrecio, "/admin/precio", `{"numero":3,"precio":80}`, ""); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 without the admin token, got %d", rec.Code)
+		}
+
+		// Lectura de la tabla al validar el asiento y guardado del precio
+		mt.AddMockResponses(findResponse(), writeResponse(1))
+		rec := adminPost(rs.handleAdminPrecio, "/admin/precio", `{"numero":3,"precio":80}`, "secret")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		mt.GetStartedEvent() // find
+		replace := mt.GetStartedEvent()
+		if replace == nil || replace.CommandName != "update" {
+			t.Fatalf("expected the price to be saved with an upsert, got %+v", replace)
+		}
+		saved := replace.Command.Lookup("updates", "0", "u").Document()
+		if id := saved.Lookup("_id").StringValue(); id != "asiento:3" {
+			t.Fatalf("price saved under %q", id)
+		}
+		if precio := saved.Lookup("precio").Double(); precio != 80 {
+			t.Fatalf("saved price %v, expected 80", precio)
+		}
+
+		// /asientos y el recibo de la siguiente reserva usan el precio nuevo
+		mt.AddMockResponses(findResponse(precioDoc(3, 80)))
+		asientos, err := rs.GetAsientos()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if asientos[3].Precio != 80 || asientos[1].Precio != 10 {
+			t.Fatalf("expected seat 3 at 80 and seat 1 at the base price, got %v and %v", asientos[3].Precio, asientos[1].Precio)
+		}
+		mt.AddMockResponses(findResponse(precioDoc(3, 80)))
+		recibo, apiErr := rs.reservarAsiento(3, "ana", "")
+		if apiErr != nil {
+			t.Fatal(apiErr)
+		}
+		if recibo.Precio != 80 {
+			t.Fatalf("receipt charged %v, expected 80", recibo.Precio)
+		}
+	})
+}
+
+func TestAdminPrecioRejectsInvalidRequests(t *testing.T) {
+	rs, _ := newCacheTestServer(t, 3)
+	rs.adminToken = "secret"
+	for _, body := range []string{
+		`{"precio":5}`,
+		`{"numero":1,"categoria":"VIP","precio":5}`,
+		`{"numero":1}`,
+		`{"numero":1,"precio":-5}`,
+	} {
+		rec := adminPost(rs.handleAdminPrecio, "/admin/precio", body, "secret")
+		if rec.Code != http.StatusBadRequest || errorCode(t, rec) != httperr.CodeInvalidRequest {
+			t.Errorf("%s: expected 400 %s, got %d", body, httperr.CodeInvalidRequest, rec.Code)
+		}
+	}
+}
+
+func TestDemandPricingRaisesPriceWhenFewSeatsRemain(t *testing.T) {
+	rs, store := newCacheTestServer(t, 10)
+	rs.precioBase = 10
+	rs.pricing = PricingDemand
+	rs.recargoDemanda = 1
+
+	asientos, err := rs.GetAsientos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asientos[10].Precio != 10 {
+		t.Fatalf("expected the base price with the room empty, got %v", asientos[10].Precio)
+	}
+
+	for numero := 1; numero <= 8; numero++ {
+		ocupar(rs, store, numero, "cliente")
+	}
+	asientos, err = rs.GetAsientos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ocupación del 80%: 10 * (1 + 1*0.8)
+	if asientos[10].Precio != 18 {
+		t.Fatalf("expected 18 with two seats left, got %v", asientos[10].Precio)
+	}
+	// Todos los asientos de una misma respuesta tienen el mismo precio
+	if asientos[9].Precio != asientos[10].Precio {
+		t.Fatalf("inconsistent prices in one read: %v and %v", asientos[9].Precio, asientos[10].Precio)
+	}
+
+	// El recibo cobra lo que /asientos mostraba antes de reservar
+	recibo, apiErr := rs.reservarAsiento(10, "ana", "")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if recibo.Precio != 18 {
+		t.Fatalf("receipt charged %v, expected 18", recibo.Precio)
+	}
+}
+
+func TestStaticPricingIgnoresDemand(t *testing.T) {
+	rs, store := newCacheTestServer(t, 4)
+	rs.precioBase = 10
+	rs.pricing = PricingStatic
+	rs.recargoDemanda = 1
+	for numero := 1; numero <= 3; numero++ {
+		ocupar(rs, store, numero, "cliente")
+	}
+
+	asientos, err := rs.GetAsientos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asientos[4].Precio != 10 {
+		t.Fatalf("static pricing changed with demand: %v", asientos[4].Precio)
+	}
+}
+
+func TestTablaPreciosSeatOverridesCategory(t *testing.T) {
+	tabla := TablaPrecios{porAsiento: map[int]float64{1: 50}, porCategoria: map[string]float64{"VIP": 30}}
+	vip := nuevoAsiento(1, "")
+	vip.Seccion = "VIP"
+	otro := nuevoAsiento(2, "")
+	otro.Seccion = "VIP"
+	general := nuevoAsiento(3, "")
+
+	if got := tabla.base(&vip, 10); got != 50 {
+		t.Errorf("seat price: expected 50, got %v", got)
+	}
+	if got := tabla.base(&otro, 10); got != 30 {
+		t.Errorf("category price: expected 30, got %v", got)
+	}
+	if got := tabla.base(&general, 10); got != 10 {
+		t.Errorf("default price: expected 10, got %v", got)
+	}
+}
+
+func TestParsePricingStrategy(t *testing.T) {
+	for in, want := range map[string]string{"": PricingStatic, "static": PricingStatic, " demand ": PricingDemand} {
+		if got, err := parsePricingStrategy(in); err != nil || got != want {
+			t.Errorf("parsePricingStrategy(%q) = %q, %v; expected %q", in, got, err, want)
+		}
+	}
+	if _, err := parsePricingStrategy("surge"); err == nil {
+		t.Fatal("expected an error for an unknown strategy")
+	}
+}
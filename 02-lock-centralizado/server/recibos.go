@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Recibo es el comprobante de una reserva. Se guarda como una foto tomada al
+// reservar, así que liberar el asiento o cambiar su precio o su sección
+// después no lo altera.
+type Recibo struct {
+	Codigo      string    `bson:"_id" json:"codigo"`
+	Numero      int       `bson:"numero" json:"numero"`
+	Cliente     string    `bson:"cliente" json:"cliente"`
+	Categoria   string    `bson:"categoria,omitempty" json:"categoria,omitempty"`
+	Precio      float64   `bson:"precio" json:"precio"`
+	ReservadoEn time.Time `bson:"reservado_en" json:"reservado_en"`
+	ServerID    string    `bson:"server_id" json:"server_id"`
+}
+
+// reciboCSVHeader son las columnas del recibo en CSV
+var reciboCSVHeader = []string{"codigo", "numero", "cliente", "categoria", "precio", "reservado_en", "server_id"}
+
+// ReciboStore guarda los recibos en MongoDB, uno por código de confirmación
+type ReciboStore struct {
+	collection *mongo.Collection
+}
+
+// NewReciboStore crea el almacén de recibos
+func NewReciboStore(collection *mongo.Collection) *ReciboStore {
+	return &ReciboStore{collection: collection}
+}
+
+// Guardar inserta un recibo; el código es la clave, así que no se repite
+func (s *ReciboStore) Guardar(ctx context.Context, recibo *Recibo) error {
+	_, err := s.collection.InsertOne(ctx, recibo)
+	return err
+}
+
+// Borrar elimina un recibo cuya reserva no llegó a guardarse
+func (s *ReciboStore) Borrar(ctx context.Context, codigo string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": codigo})
+	return err
+}
+
+// Get devuelve el recibo con ese código, o nil si no existe
+func (s *ReciboStore) Get(ctx context.Context, codigo string) (*Recibo, error) {
+	var recibo Recibo
+	err := s.collection.FindOne(ctx, bson.M{"_id": codigo}).Decode(&recibo)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &recibo, nil
+}
+
+// nuevoCodigoConfirmacion genera un código de confirmación como "R-9F3A61C2"
+func nuevoCodigoConfirmacion() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		// Sin aleatoriedad del sistema, el instante sigue siendo único en la práctica
+		return fmt.Sprintf("R-%X", time.Now().UnixNano())
+	}
+	return "R-" + strings.ToUpper(hex.EncodeToString(b))
+}
+
+// emitirRecibo asigna un código de confirmación al asiento que se acaba de
+// reservar y guarda su recibo. Debe llamarse con el bloqueo del asiento y
+// rs.mutex tomados, antes de guardar el asiento: si ese guardado falla, el
+// llamador deshace el recibo con anularRecibo.
+func (rs *ReservationServer) emitirRecibo(asiento *Asiento) (*Recibo, *APIError) {
+	precio, err := rs.precioAsiento(asiento)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, CodeDatabaseError,
+			fmt.Sprintf("Error reading seat price: %v", err))
+	}
+	recibo := &Recibo{
+		Codigo:      nuevoCodigoConfirmacion(),
+		Numero:      asiento.Numero,
+		Cliente:     asiento.Cliente,
+		Categoria:   asiento.Seccion,
+		Precio:      precio,
+		ReservadoEn: asiento.UpdatedAt,
+		ServerID:    rs.serverID,
+	}
+	if err := rs.reservas.GuardarRecibo(context.Background(), recibo); err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, CodeDatabaseError,
+			fmt.Sprintf("Error saving receipt: %v", err))
+	}
+	asiento.Codigo = recibo.Codigo
+	asiento.Precio = precio
+	return recibo, nil
+}
+
+// anularRecibo deshace emitirRecibo cuando la reserva no llegó a guardarse
+func (rs *ReservationServer) anularRecibo(asiento *Asiento, recibo *Recibo) {
+	asiento.Codigo = ""
+	if err := rs.reservas.BorrarRecibo(context.Background(), recibo.Codigo); err != nil {
+		log.Printf("Server %s: Failed to remove receipt %s of a failed reservation: %v", rs.serverID, recibo.Codigo, err)
+	}
+}
+
+// writeReciboJSON escribe el recibo en JSON
+func writeReciboJSON(w io.Writer, recibo *Recibo) error {
+	return json.NewEncoder(w).Encode(recibo)
+}
+
+// writeReciboCSV escribe el recibo en CSV: una cabecera y una fila
+func writeReciboCSV(w io.Writer, recibo *Recibo) error {
+	cw := csv.NewWriter(w)
+	cw.Write(reciboCSVHeader)
+	cw.Write([]string{
+		recibo.Codigo,
+		strconv.Itoa(recibo.Numero),
+		recibo.Cliente,
+		recibo.Categoria,
+		strconv.FormatFloat(recibo.Precio, 'f', 2, 64),
+		recibo.ReservadoEn.UTC().Format(time.RFC3339),
+		recibo.ServerID,
+	})
+	cw.Flush()
+	return cw.Error()
+}
+
+// handleGetRecibo devuelve el recibo de una reserva: en JSON por defecto y en
+// CSV si el cliente envía Accept: text/csv
+func (rs *ReservationServer) handleGetRecibo(w http.ResponseWriter, r *http.Request) {
+	codigo := mux.Vars(r)["codigo"]
+
+	recibo, err := rs.recibos.Get(r.Context(), codigo)
+	if err != nil {
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to get receipt")
+		return
+	}
+	if recibo == nil {
+		httperr.Write(w, http.StatusNotFound, CodeReceiptNotFound, "No existe una reserva con ese código")
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="recibo-%s.csv"`, recibo.Codigo))
+		writeReciboCSV(w, recibo)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="recibo-%s.json"`, recibo.Codigo))
+	writeReciboJSON(w, recibo)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var reservadoEn = time.Date(2026, 3, 14, 20, 30, 0, 0, time.UTC)
+
+// reciboDoc es el documento de MongoDB del recibo R-9F3A61C2
+func reciboDoc() bson.D {
+	return bson.D{
+		{Key: "_id", Value: "R-9F3A61C2"},
+		{Key: "numero", Value: 7},
+		{Key: "cliente", Value: "ana"},
+		{Key: "categoria", Value: "VIP"},
+		{Key: "precio", Value: 42.5},
+		{Key: "reservado_en", Value: reservadoEn},
+		{Key: "server_id", Value: "server2"},
+	}
+}
+
+// getRecibo pide el recibo de codigo con la cabecera Accept indicada
+func getRecibo(rs *ReservationServer, codigo, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/reserva/"+codigo+"/recibo", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	req = mux.SetURLVars(req, map[string]string{"codigo": codigo})
+	rec := httptest.NewRecorder()
+	rs.handleGetRecibo(rec, req)
+	return rec
+}
+
+func TestGetReciboJSON(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		mt.AddMockResponses(findResponse(reciboDoc()))
+		rs := &ReservationServer{serverID: "s1", recibos: NewReciboStore(mt.Coll)}
+
+		rec := getRecibo(rs, "R-9F3A61C2", "")
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("expected a JSON receipt, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var recibo Recibo
+		if err := json.NewDecoder(rec.Body).Decode(&recibo); err != nil {
+			t.Fatal(err)
+		}
+		want := Recibo{Codigo: "R-9F3A61C2", Numero: 7, Cliente: "ana", Categoria: "VIP",
+			Precio: 42.5, ReservadoEn: reservadoEn, ServerID: "server2"}
+		if recibo != want {
+			t.Fatalf("expected %+v, got %+v", want, recibo)
+		}
+	})
+}
+
+func TestGetReciboCSV(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		mt.AddMockResponses(findResponse(reciboDoc()))
+		rs := &ReservationServer{serverID: "s1", recibos: NewReciboStore(mt.Coll)}
+
+		rec := getRecibo(rs, "R-9F3A61C2", "text/csv")
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+			t.Fatalf("expected a CSV receipt, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="recibo-R-9F3A61C2.csv"` {
+			t.Fatalf("unexpected Content-Disposition %q", got)
+		}
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := [][]string{
+			reciboCSVHeader,
+			{"R-9F3A61C2", "7", "ana", "VIP", "42.50", "2026-03-14T20:30:00Z", "server2"},
+		}
+		if len(rows) != len(want) {
+			t.Fatalf("expected %d rows, got %q", len(want), rows)
+		}
+		for i := range want {
+			for j := range want[i] {
+				if rows[i][j] != want[i][j] {
+					t.Fatalf("row %d: expected %q, got %q", i, want[i], rows[i])
+				}
+			}
+		}
+	})
+}
+
+func TestGetReciboNotFound(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		mt.AddMockResponses(findResponse())
+		rs := &ReservationServer{serverID: "s1", recibos: NewReciboStore(mt.Coll)}
+
+		rec := getRecibo(rs, "R-00000000", "")
+		if rec.Code != http.StatusNotFound || errorCode(t, rec) != CodeReceiptNotFound {
+			t.Fatalf("expected 404 %s, got %d", CodeReceiptNotFound, rec.Code)
+		}
+	})
+}
+
+func TestReciboIsASnapshotOfTheReservation(t *testing.T) {
+	store := newFakeReservaStore()
+	rs, _ := newTestServer(t, store)
+	rs.asientos[7].Seccion = "VIP"
+
+	recibo, apiErr := rs.reservarAsiento(7, "ana", "")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if rs.asientos[7].Codigo != recibo.Codigo || store.recibos[recibo.Codigo] == nil {
+		t.Fatalf("receipt %s was not saved or linked to the seat", recibo.Codigo)
+	}
+
+	// Cambios posteriores del asiento no alteran el recibo guardado
+	rs.asientos[7].Seccion = "General"
+	rs.asientos[7].Cliente = "luis"
+	guardado := store.recibos[recibo.Codigo]
+	if guardado.Numero != 7 || guardado.Cliente != "ana" || guardado.Categoria != "VIP" || guardado.ServerID != "s1" {
+		t.Fatalf("receipt does not reflect the reservation: %+v", guardado)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SeatCounts resume cuántos asientos están libres y cuántos ocupados
+type SeatCounts struct {
+	Total       int `json:"total"`
+	Disponibles int `json:"disponibles"`
+	Reservados  int `json:"reservados"`
+}
+
+func countAsientos(asientos map[int]*Asiento) SeatCounts {
+	counts := SeatCounts{Total: len(asientos)}
+	for _, asiento := range asientos {
+		if asiento.Disponible {
+			counts.Disponibles++
+		} else {
+			counts.Reservados++
+		}
+	}
+	return counts
+}
+
+// SeatDiscrepancy es un asiento cuya copia en caché no coincidía con la BD
+type SeatDiscrepancy struct {
+	Numero   int      `json:"numero"`
+	Problema string   `json:"problema"` // solo_en_cache, solo_en_bd o estado_distinto
+	Cache    *Asiento `json:"cache,omitempty"`
+	BD       *Asiento `json:"bd,omitempty"`
+}
+
+// ReconcileReport es el resultado de /admin/reconcile
+type ReconcileReport struct {
+	Antes         SeatCounts        `json:"antes"`   // según la caché
+	Despues       SeatCounts        `json:"despues"` // recontado desde la BD
+	Discrepancias []SeatDiscrepancy `json:"discrepancias"`
+	Corregidas    int               `json:"corregidas"`
+	ServerID      string            `json:"server_id"`
+}
+
+// Reconcile recuenta los asientos directamente desde MongoDB y corrige la
+// caché del servidor allí donde no coincida (p. ej. tras editar la BD a
+// mano). Las retenciones que aparecen o desaparecen rearman o cancelan su
+// temporizador.
+func (rs *ReservationServer) Reconcile() (*ReconcileReport, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	cursor, err := rs.collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	bd := make(map[int]*Asiento)
+	for cursor.Next(context.Background()) {
+		var asiento Asiento
+		if err := cursor.Decode(&asiento); err != nil {
+			return nil, err
+		}
+		bd[asiento.Numero] = &asiento
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{
+		Antes:         countAsientos(rs.asientos),
+		Despues:       countAsientos(bd),
+		Discrepancias: []SeatDiscrepancy{},
+		ServerID:      rs.serverID,
+	}
+
+	for numero, cached := range rs.asientos {
+		if _, ok := bd[numero]; !ok {
+			copia := *cached
+			report.Discrepancias = append(report.Discrepancias, SeatDiscrepancy{
+				Numero: numero, Problema: "solo_en_cache", Cache: &copia,
+			})
+			rs.stopHoldTimer(numero)
+			delete(rs.asientos, numero)
+		}
+	}
+	for numero, actual := range bd {
+		cached, ok := rs.asientos[numero]
+		switch {
+		case !ok:
+			report.Discrepancias = append(report.Discrepancias, SeatDiscrepancy{
+				Numero: numero, Problema: "solo_en_bd", BD: actual,
+			})
+			rs.asientos[numero] = actual
+		case !sameSeatState(cached, actual):
+			copia := *cached
+			report.Discrepancias = append(report.Discrepancias, SeatDiscrepancy{
+				Numero: numero, Problema: "estado_distinto", Cache: &copia, BD: actual,
+			})
+			*cached = *actual
+		default:
+			continue
+		}
+
+		// Alinear el temporizador de la retención con el estado de la BD
+		if !actual.Disponible && actual.ExpiresAt != nil {
+			rs.armHoldTimer(numero, actual.Cliente, *actual.ExpiresAt)
+		} else {
+			rs.stopHoldTimer(numero)
+		}
+	}
+
+	sort.Slice(report.Discrepancias, func(i, j int) bool {
+		return report.Discrepancias[i].Numero < report.Discrepancias[j].Numero
+	})
+	report.Corregidas = len(report.Discrepancias)
+	return report, nil
+}
+
+// sameSeatState compara el estado de reserva de dos copias de un asiento
+func sameSeatState(a, b *Asiento) bool {
+	if a.Disponible != b.Disponible || a.Cliente != b.Cliente {
+		return false
+	}
+	if (a.ExpiresAt == nil) != (b.ExpiresAt == nil) {
+		return false
+	}
+	return a.ExpiresAt == nil || a.ExpiresAt.Equal(*b.ExpiresAt)
+}
+
+// handleReconcile recuenta los asientos desde la BD y corrige la caché.
+// Requiere la cabecera X-Admin-Token.
+func (rs *ReservationServer) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if !rs.requireAdmin(w, r) {
+		return
+	}
+
+	report, err := rs.Reconcile()
+	if err != nil {
+		log.Printf("Server %s: Reconcile failed: %v", rs.serverID, err)
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to reconcile seats")
+		return
+	}
+	if report.Corregidas > 0 {
+		log.Printf("Server %s: Reconcile fixed %d seats (cache %d/%d free/reserved, db %d/%d)",
+			rs.serverID, report.Corregidas,
+			report.Antes.Disponibles, report.Antes.Reservados,
+			report.Despues.Disponibles, report.Despues.Reservados)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
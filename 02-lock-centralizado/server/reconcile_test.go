@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestReconcileRequiresAdmin(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, _ := newCacheTestServer(t, 3)
+		rs.collection = mt.Coll
+		rs.adminToken = "secret"
+
+		rec := adminPost(rs.handleReconcile, "/admin/reconcile", "", "wrong")
+		if rec.Code != http.StatusUnauthorized || errorCode(t, rec) != httperr.CodeUnauthorized {
+			t.Fatalf("expected 401 %s, got %d", httperr.CodeUnauthorized, rec.Code)
+		}
+		if ev := mt.GetStartedEvent(); ev != nil {
+			t.Fatalf("unauthorized reconcile queried MongoDB: %s", ev.CommandName)
+		}
+	})
+}
+
+func TestReconcileRestoresCountsFromTheDatabase(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, _ := newCacheTestServer(t, 3)
+		rs.collection = mt.Coll
+		rs.adminToken = "secret"
+		// La caché cree que el asiento 1 está reservado; en la BD está libre
+		rs.asientos[1].Disponible = false
+		rs.asientos[1].Cliente = "fantasma"
+		seat2 := rs.asientos[2]
+
+		// En la BD el 2 lo reservó ana a mano, el 3 se borró y hay un 4 reservado
+		mt.AddMockResponses(findResponse(seatDoc(1, ""), seatDoc(2, "ana"), seatDoc(4, "luis")))
+		rec := adminPost(rs.handleReconcile, "/admin/reconcile", "", "secret")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+
+		var report ReconcileReport
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if want := (SeatCounts{Total: 3, Disponibles: 2, Reservados: 1}); report.Antes != want {
+			t.Fatalf("expected cache counts %+v, got %+v", want, report.Antes)
+		}
+		if want := (SeatCounts{Total: 3, Disponibles: 1, Reservados: 2}); report.Despues != want {
+			t.Fatalf("expected db counts %+v, got %+v", want, report.Despues)
+		}
+		problemas := map[int]string{}
+		for _, d := range report.Discrepancias {
+			problemas[d.Numero] = d.Problema
+		}
+		want := map[int]string{1: "estado_distinto", 2: "estado_distinto", 3: "solo_en_cache", 4: "solo_en_bd"}
+		if len(problemas) != len(want) || report.Corregidas != len(want) {
+			t.Fatalf("expected discrepancies %v, got %v (%d fixed)", want, problemas, report.Corregidas)
+		}
+		for numero, problema := range want {
+			if problemas[numero] != problema {
+				t.Errorf("seat %d: expected %s, got %q", numero, problema, problemas[numero])
+			}
+		}
+
+		// La caché queda igual que la BD, corrigiendo los asientos en su sitio
+		if got := countAsientos(rs.asientos); got != report.Despues {
+			t.Fatalf("cache counts %+v after reconcile, expected %+v", got, report.Despues)
+		}
+		if !rs.asientos[1].Disponible || rs.asientos[1].Cliente != "" {
+			t.Fatalf("seat 1 not restored: %+v", rs.asientos[1])
+		}
+		if rs.asientos[2] != seat2 || seat2.Cliente != "ana" {
+			t.Fatalf("seat 2 not updated in place: %+v", rs.asientos[2])
+		}
+		if _, ok := rs.asientos[3]; ok {
+			t.Fatal("seat 3 is still cached")
+		}
+
+		// Una segunda pasada ya no encuentra nada que corregir
+		mt.AddMockResponses(findResponse(seatDoc(1, ""), seatDoc(2, "ana"), seatDoc(4, "luis")))
+		again, err := rs.Reconcile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Corregidas != 0 {
+			t.Fatalf("second reconcile fixed %d seats: %+v", again.Corregidas, again.Discrepancias)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// leaseRenewer renueva en segundo plano un bloqueo del coordinador cada
+// TTL/2 mientras dura la operación que lo usa
+type leaseRenewer struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startRenewal empieza a renovar el bloqueo lockID si LOCK_AUTO_RENEW está
+// activado; devuelve nil si no lo está. Hay que llamar a Stop antes de
+// liberar el bloqueo.
+func (rs *ReservationServer) startRenewal(resource, lockID string, ttl int) *leaseRenewer {
+	if !rs.autoRenew || ttl <= 0 {
+		return nil
+	}
+
+	l := &leaseRenewer{stop: make(chan struct{}), done: make(chan struct{})}
+	go rs.renewLoop(l, resource, lockID, ttl)
+	return l
+}
+
+// Stop deja de renovar y espera a que la renovación en curso, si la hay, se
+// cancele. Es seguro llamarlo sobre un renovador nil.
+func (l *leaseRenewer) Stop() {
+	if l == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+}
+
+func (rs *ReservationServer) renewLoop(l *leaseRenewer, resource, lockID string, ttl int) {
+	defer close(l.done)
+
+	interval := time.Duration(ttl) * time.Second / 2
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Una renovación en vuelo se cancela en cuanto se pide parar, para no
+	// alargar el bloqueo después de liberarlo
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-l.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		renewCtx, renewCancel := context.WithTimeout(ctx, interval)
+		resp, err := rs.renewLock(renewCtx, resource, lockID, ttl)
+		renewCancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// Error transitorio: se reintenta en el siguiente tick, que aún
+			// llega antes de que el bloqueo expire
+			log.Printf("Server %s: Failed to renew lock on %s: %v", rs.serverID, resource, err)
+			continue
+		}
+		if !resp.Success {
+			log.Printf("Server %s: WARNING: lost lock on %s, stopping renewal: %s", rs.serverID, resource, resp.Message)
+			return
+		}
+	}
+}
+
+// renewLock pide al coordinador que el bloqueo lockID expire ttl segundos
+// después de ahora
+func (rs *ReservationServer) renewLock(ctx context.Context, resource, lockID string, ttl int) (*LockResponse, error) {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"resource":  resource,
+		"client_id": rs.serverID,
+		"lock_id":   lockID,
+		"ttl":       ttl,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rs.coordinatorURL+"/renew", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("coordinator returned status %d", resp.StatusCode)
+	}
+	var lockResp LockResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lockResp); err != nil {
+		return nil, err
+	}
+	return &lockResp, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leaseCoordinator simula /renew con caducidad real: anota si alguna
+// renovación llegó con el bloqueo ya caducado
+type leaseCoordinator struct {
+	*httptest.Server
+	mu        sync.Mutex
+	expiresAt time.Time
+	renewals  int
+	expired   bool
+	lost      bool // responde que el bloqueo ya no existe
+}
+
+func newLeaseCoordinator(t *testing.T, ttl time.Duration) *leaseCoordinator {
+	c := &leaseCoordinator{expiresAt: time.Now().Add(ttl)}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			LockID string `json:"lock_id"`
+			TTL    int    `json:"ttl"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.renewals++
+		if c.lost {
+			json.NewEncoder(w).Encode(LockResponse{Success: false, Message: "No lock with this id"})
+			return
+		}
+		now := time.Now()
+		if now.After(c.expiresAt) {
+			c.expired = true
+		}
+		c.expiresAt = now.Add(time.Duration(req.TTL) * time.Second)
+		json.NewEncoder(w).Encode(LockResponse{Success: true, LockID: req.LockID})
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *leaseCoordinator) state() (renewals int, expired bool, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.renewals, c.expired, c.expiresAt
+}
+
+func TestRenewalKeepsLockPastItsTTL(t *testing.T) {
+	coordinator := newLeaseCoordinator(t, time.Second)
+	rs, _ := newTestServer(t, newFakeReservaStore())
+	rs.coordinatorURL = coordinator.URL
+	rs.autoRenew = true
+
+	renewal := rs.startRenewal("asiento-7", "lock-1", 1)
+	// La operación dura más del doble del TTL; termina entre dos ticks para
+	// que no quede ninguna renovación en vuelo al parar
+	time.Sleep(2300 * time.Millisecond)
+	renewal.Stop()
+
+	renewals, expired, expiresAt := coordinator.state()
+	if expired {
+		t.Fatal("the lock expired between two renewals")
+	}
+	if renewals < 4 {
+		t.Fatalf("expected a renewal every 500ms, got %d in 2.5s", renewals)
+	}
+	if time.Now().After(expiresAt) {
+		t.Fatal("the lock was already expired when the operation finished")
+	}
+
+	// Tras Stop no se renueva más
+	time.Sleep(1200 * time.Millisecond)
+	if after, _, _ := coordinator.state(); after != renewals {
+		t.Fatalf("renewal continued after Stop: %d renewals, then %d", renewals, after)
+	}
+}
+
+func TestRenewalStopsWhenTheLockIsLost(t *testing.T) {
+	coordinator := newLeaseCoordinator(t, time.Second)
+	coordinator.lost = true
+	rs, _ := newTestServer(t, newFakeReservaStore())
+	rs.coordinatorURL = coordinator.URL
+	rs.autoRenew = true
+
+	renewal := rs.startRenewal("asiento-7", "lock-1", 1)
+	select {
+	case <-renewal.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("renewal kept running after the coordinator reported the lock lost")
+	}
+	if renewals, _, _ := coordinator.state(); renewals != 1 {
+		t.Fatalf("expected a single renewal attempt, got %d", renewals)
+	}
+	renewal.Stop()
+}
+
+func TestRenewalIsOptIn(t *testing.T) {
+	rs, _ := newTestServer(t, newFakeReservaStore())
+	if renewal := rs.startRenewal("asiento-7", "lock-1", 30); renewal != nil {
+		t.Fatal("renewal started without LOCK_AUTO_RENEW")
+	}
+	// Parar un renovador nil no hace nada
+	var renewal *leaseRenewer
+	renewal.Stop()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Sesiones de quiosco: un cliente que envía latidos a /sesion/heartbeat tiene
+// una sesión abierta, y si deja de enviarlos durante SESSION_TIMEOUT_S se le
+// liberan todos sus asientos, retenidos o reservados. A diferencia del
+// vencimiento de una retención, no depende de cuánto tiempo lleve el asiento
+// ocupado sino de que el cliente siga ahí. Los clientes que nunca envían
+// latidos no tienen sesión y no se ven afectados.
+
+// Sesion es el último latido de un cliente
+type Sesion struct {
+	Cliente      string    `bson:"_id" json:"cliente"`
+	UltimoLatido time.Time `bson:"ultimo_latido" json:"ultimo_latido"`
+}
+
+// SesionStore guarda las sesiones en MongoDB, para que cualquier servidor
+// reciba los latidos y cualquiera pueda cerrar la sesión
+type SesionStore struct {
+	collection *mongo.Collection
+}
+
+// NewSesionStore crea el almacén de sesiones sobre la colección indicada
+func NewSesionStore(collection *mongo.Collection) *SesionStore {
+	return &SesionStore{collection: collection}
+}
+
+// Latido anota un latido del cliente, abriendo su sesión si no la tenía
+func (s *SesionStore) Latido(ctx context.Context, cliente string, now time.Time) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": cliente},
+		bson.M{"$set": bson.M{"ultimo_latido": now}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Caducadas devuelve las sesiones cuyo último latido es anterior a limite
+func (s *SesionStore) Caducadas(ctx context.Context, limite time.Time) ([]Sesion, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{"ultimo_latido": bson.M{"$lt": limite}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sesiones []Sesion
+	if err := cursor.All(ctx, &sesiones); err != nil {
+		return nil, err
+	}
+	return sesiones, nil
+}
+
+// Cerrar borra la sesión si no ha recibido latidos desde ultimo. Devuelve
+// false si el cliente volvió a dar señales de vida o si otro servidor ya la
+// cerró.
+func (s *SesionStore) Cerrar(ctx context.Context, sesion Sesion) (bool, error) {
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": sesion.Cliente, "ultimo_latido": sesion.UltimoLatido})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// asientosDeCliente devuelve los números de los asientos ocupados por el
+// cliente según MongoDB, que ve también los ocupados desde otros servidores
+func (rs *ReservationServer) asientosDeCliente(ctx context.Context, cliente string) ([]int, error) {
+	cursor, err := rs.collection.Find(ctx, bson.M{"cliente": cliente, "disponible": false})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var numeros []int
+	for cursor.Next(ctx) {
+		var asiento Asiento
+		if err := cursor.Decode(&asiento); err != nil {
+			return nil, err
+		}
+		numeros = append(numeros, asiento.Numero)
+	}
+	return numeros, cursor.Err()
+}
+
+// liberarDeCliente libera el asiento solo si sigue ocupado por cliente: entre
+// la consulta y el bloqueo pudo liberarlo otro servidor y ocuparlo otro
+// cliente. Devuelve si lo liberó.
+func (rs *ReservationServer) liberarDeCliente(numero int, cliente string) (bool, *APIError) {
+	return rs.liberarSi(numero, func(asiento *Asiento) bool {
+		return asiento.Cliente == cliente
+	})
+}
+
+// cerrarSesion libera los asientos de una sesión caducada y, si lo consigue
+// con todos, la cierra. Si alguno falla la sesión queda abierta y el
+// siguiente barrido lo vuelve a intentar.
+func (rs *ReservationServer) cerrarSesion(ctx context.Context, sesion Sesion) error {
+	numeros, err := rs.asientosDeCliente(ctx, sesion.Cliente)
+	if err != nil {
+		return err
+	}
+
+	liberados := 0
+	for _, numero := range numeros {
+		liberado, apiErr := rs.liberarDeCliente(numero, sesion.Cliente)
+		if apiErr != nil {
+			return fmt.Errorf("seat %d: %s", numero, apiErr.Message)
+		}
+		if liberado {
+			liberados++
+		}
+	}
+
+	cerrada, err := rs.sesiones.Cerrar(ctx, sesion)
+	if err != nil {
+		return err
+	}
+	if cerrada || liberados > 0 {
+		log.Printf("Server %s: Session of %s expired (last heartbeat %s), released %d seats",
+			rs.serverID, sesion.Cliente, sesion.UltimoLatido.Format(time.RFC3339), liberados)
+	}
+	return nil
+}
+
+// barrerSesiones cierra las sesiones sin latidos desde hace más de
+// rs.sessionTimeout
+func (rs *ReservationServer) barrerSesiones() {
+	// En mantenimiento no se modifican asientos; los latidos siguen
+	// llegando, así que nadie caduca por culpa del mantenimiento
+	if rs.maintenance.Load() {
+		return
+	}
+
+	ctx := context.Background()
+	sesiones, err := rs.sesiones.Caducadas(ctx, time.Now().Add(-rs.sessionTimeout))
+	if err != nil {
+		log.Printf("Server %s: Error looking for expired sessions: %v", rs.serverID, err)
+		return
+	}
+	for _, sesion := range sesiones {
+		if err := rs.cerrarSesion(ctx, sesion); err != nil {
+			log.Printf("Server %s: Could not release the seats of %s, retrying: %v", rs.serverID, sesion.Cliente, err)
+		}
+	}
+}
+
+// runSessionReaper barre las sesiones caducadas cada intervalo
+func (rs *ReservationServer) runSessionReaper(intervalo time.Duration) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for range ticker.C {
+		rs.barrerSesiones()
+	}
+}
+
+// handleHeartbeat anota un latido de {cliente} y devuelve cuándo caducará su
+// sesión si no envía otro
+func (rs *ReservationServer) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cliente string `json:"cliente"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+	req.Cliente = strings.TrimSpace(req.Cliente)
+	if req.Cliente == "" {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Cliente is required")
+		return
+	}
+	if rs.sesiones == nil {
+		httperr.Write(w, http.StatusNotFound, httperr.CodeNotFound, "Las sesiones están desactivadas (SESSION_TIMEOUT_S=0)")
+		return
+	}
+
+	now := time.Now()
+	if err := rs.sesiones.Latido(r.Context(), req.Cliente, now); err != nil {
+		writeAPIError(w, errDatabase(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"cliente":   req.Cliente,
+		"expira":    now.Add(rs.sessionTimeout),
+		"timeout_s": int(rs.sessionTimeout / time.Second),
+		"server_id": rs.serverID,
+	})
+}
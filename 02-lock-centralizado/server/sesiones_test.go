@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// sesionDoc es el documento de MongoDB de la sesión de cliente
+func sesionDoc(cliente string, ultimoLatido time.Time) bson.D {
+	return bson.D{{Key: "_id", Value: cliente}, {Key: "ultimo_latido", Value: ultimoLatido}}
+}
+
+func TestSeatsReleasedWhenHeartbeatsStop(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, store := newCacheTestServer(t, 3)
+		rs.collection = mt.Coll
+		rs.sesiones = NewSesionStore(mt.Coll)
+		rs.sessionTimeout = 50 * time.Millisecond
+
+		if _, apiErr := rs.reservarAsiento(2, "ana", ""); apiErr != nil {
+			t.Fatal(apiErr)
+		}
+		mt.AddMockResponses(writeResponse(1))
+		rec := httptest.NewRecorder()
+		rs.handleHeartbeat(rec, httptest.NewRequest(http.MethodPost, "/sesion/heartbeat", strings.NewReader(`{"cliente":"ana"}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("heartbeat failed: %d %s", rec.Code, rec.Body)
+		}
+		latido := mt.GetStartedEvent().Command.Lookup("updates", "0", "u", "$set", "ultimo_latido").Time()
+
+		// Mientras no pase el timeout la sesión sigue abierta
+		mt.AddMockResponses(findResponse())
+		rs.barrerSesiones()
+		limite := mt.GetStartedEvent().Command.Lookup("filter", "ultimo_latido", "$lt").Time()
+		if limite.After(latido) {
+			t.Fatalf("session looked for as expired right after its heartbeat (limit %v, heartbeat %v)", limite, latido)
+		}
+		if store.asientos[2].Disponible {
+			t.Fatal("seat released while the client was still heartbeating")
+		}
+
+		// Sin más latidos, el siguiente barrido tras el timeout libera el asiento
+		time.Sleep(2 * rs.sessionTimeout)
+		mt.AddMockResponses(
+			findResponse(sesionDoc("ana", latido)), // sesiones caducadas
+			findResponse(seatDoc(2, "ana")),        // asientos de ana
+			writeResponse(1),                       // cierre de la sesión
+		)
+		rs.barrerSesiones()
+
+		limite = mt.GetStartedEvent().Command.Lookup("filter", "ultimo_latido", "$lt").Time()
+		if !limite.After(latido) {
+			t.Fatalf("expected the last heartbeat %v to be older than the limit %v", latido, limite)
+		}
+		if saved := store.asientos[2]; !saved.Disponible || saved.Cliente != "" {
+			t.Fatalf("seat was not released after the session timed out: %+v", saved)
+		}
+		mt.GetStartedEvent() // asientos de ana
+		cerrar := mt.GetStartedEvent()
+		if cerrar == nil || cerrar.CommandName != "delete" {
+			t.Fatalf("expected the session to be closed, got %+v", cerrar)
+		}
+		// Solo se cierra si no llegó otro latido mientras tanto
+		filtro := cerrar.Command.Lookup("deletes", "0", "q")
+		if !filtro.Document().Lookup("ultimo_latido").Time().Equal(latido) {
+			t.Fatalf("session closed without checking the last heartbeat: %v", filtro)
+		}
+	})
+}
+
+func TestSessionSweepKeepsSeatsTakenByAnotherClient(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		rs, store := newCacheTestServer(t, 3)
+		rs.collection = mt.Coll
+		rs.sesiones = NewSesionStore(mt.Coll)
+		rs.sessionTimeout = time.Minute
+		// La consulta dio el asiento 2 como de ana, pero otro servidor ya lo
+		// liberó y lo reservó luis
+		ocupar(rs, store, 2, "luis")
+
+		mt.AddMockResponses(
+			findResponse(sesionDoc("ana", time.Now().Add(-time.Hour))),
+			findResponse(seatDoc(2, "ana")),
+			writeResponse(1),
+		)
+		rs.barrerSesiones()
+
+		if saved := store.asientos[2]; saved.Disponible || saved.Cliente != "luis" {
+			t.Fatalf("another client's seat was released: %+v", saved)
+		}
+	})
+}
+
+func TestHeartbeatWithSessionsDisabled(t *testing.T) {
+	rs, _ := newCacheTestServer(t, 1)
+	rec := httptest.NewRecorder()
+	rs.handleHeartbeat(rec, httptest.NewRequest(http.MethodPost, "/sesion/heartbeat", strings.NewReader(`{"cliente":"ana"}`)))
+	if rec.Code != http.StatusNotFound || errorCode(t, rec) != httperr.CodeNotFound {
+		t.Fatalf("expected 404 %s, got %d", httperr.CodeNotFound, rec.Code)
+	}
+}
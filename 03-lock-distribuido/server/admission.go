@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errCSCancelled indica que la petición a la CS se retiró con CancelCSRequest
+// mientras se esperaba la concesión
+var errCSCancelled = errors.New("critical section request cancelled")
+
+// El nodo solo puede tener una petición en curso en el protocolo (un único
+// RequestTime, RepliesNeeded y csGranted), así que las peticiones locales
+// concurrentes pasan antes por una cola FIFO de admisión: la primera ejecuta
+// el protocolo y las demás esperan a que libere la CS o abandone.
+
+// localTurn es una petición local que espera su turno
+type localTurn struct {
+	ready chan struct{}
+	since time.Time
+}
+
+type csResourceKey struct{}
+
+// WithCSResource anota en ctx para qué se pide la CS (p. ej. "asiento 5"),
+// para que /health lo muestre
+func WithCSResource(ctx context.Context, resource string) context.Context {
+	return context.WithValue(ctx, csResourceKey{}, resource)
+}
+
+// csResource devuelve el recurso anotado con WithCSResource, o "" si no hay
+func csResource(ctx context.Context) string {
+	resource, _ := ctx.Value(csResourceKey{}).(string)
+	return resource
+}
+
+// RequestCSContext espera su turno entre las peticiones locales y después
+// pide la sección crítica. Devuelve nil si el nodo quedó dentro de la CS, y
+// entonces el llamador debe liberarla con ReleaseCS; si ctx se cancela antes,
+// devuelve su error y la petición queda retirada.
+func (n *Node) RequestCSContext(ctx context.Context) error {
+	if err := n.admitLocal(ctx); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	n.csResource = csResource(ctx)
+	n.mu.Unlock()
+
+	var err error
+	if n.raftMode() {
+		err = n.requestRaftCS(ctx)
+	} else {
+		err = n.requestCS(ctx)
+	}
+	if err != nil {
+		n.leaveLocal()
+	}
+	return err
+}
+
+// admitLocal bloquea hasta que sea el turno de esta petición local
+func (n *Node) admitLocal(ctx context.Context) error {
+	n.localMu.Lock()
+	if !n.localBusy {
+		n.localBusy = true
+		n.localMu.Unlock()
+		return nil
+	}
+	turn := localTurn{ready: make(chan struct{}), since: time.Now()}
+	n.localQueue = append(n.localQueue, turn)
+	n.localMu.Unlock()
+
+	select {
+	case <-turn.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	n.localMu.Lock()
+	for i, queued := range n.localQueue {
+		if queued.ready == turn.ready {
+			n.localQueue = append(n.localQueue[:i], n.localQueue[i+1:]...)
+			n.localMu.Unlock()
+			return ctx.Err()
+		}
+	}
+	n.localMu.Unlock()
+
+	// El turno llegó a la vez que la cancelación: cederlo al siguiente
+	n.leaveLocal()
+	return ctx.Err()
+}
+
+// leaveLocal cede el turno a la siguiente petición local en la cola
+func (n *Node) leaveLocal() {
+	n.localMu.Lock()
+	defer n.localMu.Unlock()
+
+	if len(n.localQueue) == 0 {
+		n.localBusy = false
+		return
+	}
+	next := n.localQueue[0]
+	n.localQueue = n.localQueue[1:]
+	close(next.ready)
+}
+
+// LocalQueueLength devuelve cuántas peticiones locales esperan turno
+func (n *Node) LocalQueueLength() int {
+	n.localMu.Lock()
+	defer n.localMu.Unlock()
+	return len(n.localQueue)
+}
+
+// CSQueueStatus es la cola local de peticiones a la CS tal como la publica
+// /health, para que el balanceador envíe las reservas a nodos menos cargados
+type CSQueueStatus struct {
+	// Operaciones locales que esperan la CS: la que ejecuta el protocolo si
+	// está en Wanted más las que esperan turno
+	Depth int `json:"depth"`
+	// Las que esperan turno sin haber empezado el protocolo
+	Queued int `json:"queued"`
+	// Lo que lleva esperando la más antigua (0 si no espera ninguna)
+	OldestWaitMs int64  `json:"oldest_wait_ms"`
+	State        string `json:"state"`
+	// Recurso de la petición en Wanted o Held
+	Resource string `json:"resource,omitempty"`
+}
+
+// CSQueueStatus resume la cola local. Toma n.mu y localMu por separado y
+// solo para copiar unos campos, así que no retrasa el procesamiento de
+// mensajes.
+func (n *Node) CSQueueStatus() CSQueueStatus {
+	n.mu.Lock()
+	status := CSQueueStatus{State: n.State.String()}
+	var oldest time.Time
+	if n.State != Released {
+		status.Resource = n.csResource
+	}
+	if n.State == Wanted {
+		status.Depth = 1
+		oldest = n.requestedAt
+	}
+	n.mu.Unlock()
+
+	n.localMu.Lock()
+	status.Queued = len(n.localQueue)
+	// La cola es FIFO: la primera es la que más lleva esperando
+	if status.Queued > 0 && (oldest.IsZero() || n.localQueue[0].since.Before(oldest)) {
+		oldest = n.localQueue[0].since
+	}
+	n.localMu.Unlock()
+
+	status.Depth += status.Queued
+	if !oldest.IsZero() {
+		status.OldestWaitMs = time.Since(oldest).Milliseconds()
+	}
+	return status
+}
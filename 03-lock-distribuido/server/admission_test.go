@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitLocalQueue espera a que haya n peticiones locales esperando turno
+func waitLocalQueue(t *testing.T, node *Node, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for node.LocalQueueLength() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued local requests, got %d", n, node.LocalQueueLength())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 50 peticiones locales a la vez en cada nodo entran de una en una y dejan
+// el nodo limpio al terminar
+func TestConcurrentLocalRequestsStress(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	const perNode = 50
+
+	var inside, overlaps int64
+	var wg sync.WaitGroup
+	for _, id := range []string{"node1", "node2"} {
+		for i := 0; i < perNode; i++ {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				if err := c.Enter(id, 10*time.Second); err != nil {
+					t.Error(err)
+					return
+				}
+				if atomic.AddInt64(&inside, 1) > 1 {
+					atomic.AddInt64(&overlaps, 1)
+				}
+				time.Sleep(100 * time.Microsecond)
+				atomic.AddInt64(&inside, -1)
+				c.Exit(id)
+			}(id)
+		}
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt64(&overlaps); n != 0 || c.Violations() != 0 {
+		t.Fatalf("expected one request inside the CS at a time, got %d overlaps and %d violations", n, c.Violations())
+	}
+	for _, id := range []string{"node1", "node2"} {
+		node := c.Node(id)
+		if entries := node.MessageStats().CSEntries; entries != perNode {
+			t.Errorf("expected %s to enter %d times, got %d", id, perNode, entries)
+		}
+		state := node.DebugState()
+		if state.State != Released.String() || len(state.RepliesNeeded) != 0 || len(state.DeferredReplies) != 0 || state.LocalWaiting != 0 {
+			t.Errorf("expected %s to end clean, got %+v", id, state)
+		}
+	}
+}
+
+// Las peticiones locales entran en el orden en que llegaron
+func TestLocalRequestsAreAdmittedInOrder(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node := c.Node("node1")
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 1; i <= 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := c.Enter("node1", 5*time.Second); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			c.Exit("node1")
+		}(i)
+		waitLocalQueue(t, node, i)
+	}
+	if status := node.CSQueueStatus(); status.Queued != 4 || status.Depth != 4 || status.State != Held.String() {
+		t.Fatalf("expected 4 queued requests behind the held CS, got %+v", status)
+	}
+
+	c.Exit("node1")
+	wg.Wait()
+	if !reflect.DeepEqual(order, []int{1, 2, 3, 4}) {
+		t.Fatalf("expected FIFO admission, got %v", order)
+	}
+}
+
+// Una petición que se cancela mientras espera turno sale de la cola sin
+// bloquear a las que van detrás
+func TestCancelledLocalRequestLeavesTheQueue(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node := c.Node("node1")
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() { cancelled <- node.RequestCSContext(ctx) }()
+	waitLocalQueue(t, node, 1)
+	entered := make(chan error, 1)
+	go func() { entered <- c.Enter("node1", 5*time.Second) }()
+	waitLocalQueue(t, node, 2)
+
+	cancel()
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	waitLocalQueue(t, node, 1)
+
+	c.Exit("node1")
+	if err := <-entered; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+	// La petición cancelada no deja a node1 con la CS ni esperándola
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node2")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Asiento incrusta models.AsientoBase del módulo compartido, y el JSON de la
+// API y el documento BSON de MongoDB tienen que seguir siendo byte a byte los
+// de antes, con logical_ts siempre presente
+func TestSeatWireFormat(t *testing.T) {
+	updated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		asiento Asiento
+		json    string
+		bson    string
+	}{
+		{
+			name: "reserved",
+			asiento: Asiento{
+				AsientoBase: models.AsientoBase{AsientoEstado: models.AsientoEstado{Numero: 7, Cliente: "ana"}, ServerID: "s1", UpdatedAt: updated},
+				LogicalTS:   42,
+			},
+			json: `{"numero":7,"disponible":false,"cliente":"ana","server_id":"s1","updated_at":"2024-01-02T03:04:05Z","logical_ts":42}`,
+			bson: "69000000106e756d65726f000700000008646973706f6e69626c65000002636c69656e74650004000000616e6100027365727665725f6964000300000073310009757064617465645f61740088d820c88c010000126c6f676963616c5f7473002a0000000000000000",
+		},
+		{
+			name: "free",
+			asiento: Asiento{
+				AsientoBase: models.AsientoBase{AsientoEstado: models.AsientoEstado{Numero: 3, Disponible: true}, ServerID: "s2", UpdatedAt: updated},
+			},
+			json: `{"numero":3,"disponible":true,"server_id":"s2","updated_at":"2024-01-02T03:04:05Z","logical_ts":0}`,
+			bson: "58000000106e756d65726f000300000008646973706f6e69626c650001027365727665725f6964000300000073320009757064617465645f61740088d820c88c010000126c6f676963616c5f747300000000000000000000",
+		},
+	} {
+		encoded, err := json.Marshal(tc.asiento)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != tc.json {
+			t.Fatalf("%s: JSON changed:\n got  %s\n want %s", tc.name, encoded, tc.json)
+		}
+		doc, err := bson.Marshal(tc.asiento)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(doc); got != tc.bson {
+			t.Fatalf("%s: BSON changed:\n got  %s\n want %s", tc.name, got, tc.bson)
+		}
+
+		// El driver devuelve las fechas en hora local, así que se comparan
+		// los asientos leídos volviendo a codificarlos
+		var fromJSON, fromBSON Asiento
+		if err := json.Unmarshal(encoded, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		if err := bson.Unmarshal(doc, &fromBSON); err != nil {
+			t.Fatal(err)
+		}
+		fromBSON.UpdatedAt = fromBSON.UpdatedAt.UTC()
+		for _, read := range []Asiento{fromJSON, fromBSON} {
+			if again, _ := json.Marshal(read); string(again) != tc.json {
+				t.Fatalf("%s: round trip changed the seat: %s", tc.name, again)
+			}
+		}
+	}
+}
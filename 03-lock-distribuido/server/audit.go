@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEntry registra una operación confirmada dentro de la sección crítica.
+// El par (Timestamp, NodeID) define un orden total entre nodos.
+type AuditEntry struct {
+	Timestamp  int64     `bson:"timestamp" json:"timestamp"` // Reloj de Lamport con el que se obtuvo la CS
+	NodeID     string    `bson:"node_id" json:"node_id"`
+	Operacion  string    `bson:"operacion" json:"operacion"` // "reservar" o "liberar"
+	Numero     int       `bson:"numero" json:"numero"`
+	Cliente    string    `bson:"cliente,omitempty" json:"cliente,omitempty"`
+	OnBehalfOf string    `bson:"on_behalf_of,omitempty" json:"on_behalf_of,omitempty"` // Servidor que delegó la operación en NodeID
+	OpID       string    `bson:"op_id,omitempty" json:"op_id,omitempty"`               // SeatOp del asiento; la entrada marca que terminó
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// AuditLog persiste las entradas de auditoría de todos los nodos en MongoDB
+type AuditLog struct {
+	collection *mongo.Collection
+}
+
+// NewAuditLog crea un registro de auditoría sobre la colección indicada
+func NewAuditLog(collection *mongo.Collection) *AuditLog {
+	return &AuditLog{collection: collection}
+}
+
+// Record guarda una entrada de auditoría
+func (a *AuditLog) Record(ctx context.Context, entry AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	_, err := a.collection.InsertOne(ctx, entry)
+	return err
+}
+
+// List devuelve todas las entradas en orden total (timestamp, node_id)
+func (a *AuditLog) List(ctx context.Context) ([]AuditEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "node_id", Value: 1}})
+	cursor, err := a.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	// Reordenar en memoria para no depender de la colación de MongoDB
+	sortAuditEntries(entries)
+	return entries, nil
+}
+
+// sortAuditEntries ordena las entradas por (timestamp, node_id).
+// Es el mismo criterio de desempate que usa Ricart-Agrawala, por lo que el
+// orden resultante coincide con el orden en que se concedió la CS.
+func sortAuditEntries(entries []AuditEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Timestamp != entries[j].Timestamp {
+			return entries[i].Timestamp < entries[j].Timestamp
+		}
+		return entries[i].NodeID < entries[j].NodeID
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// withMockMongo ejecuta fn con un cliente de MongoDB simulado: cada orden que
+// envía el driver consume, en orden, una de las respuestas añadidas con
+// mt.AddMockResponses
+func withMockMongo(t *testing.T, fn func(mt *mtest.T)) {
+	t.Helper()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	mt.Run("mock", fn)
+}
+
+func TestAuditMergedOrderIsDeterministic(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	c.Network.Delay = func(from, to string, msg Message) time.Duration {
+		return time.Millisecond
+	}
+
+	// Cada nodo guarda sus entradas; csOrder es el orden real de la CS
+	var (
+		mu      sync.Mutex
+		csOrder []AuditEntry
+		byNode  = make(map[string][]AuditEntry)
+		wg      sync.WaitGroup
+	)
+	for _, id := range []string{"node1", "node2"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				if err := c.Enter(id, 5*time.Second); err != nil {
+					t.Error(err)
+					return
+				}
+				ts, held := c.Node(id).HeldTimestamp()
+				if !held {
+					t.Errorf("%s is not holding the CS it just entered", id)
+				}
+				entry := AuditEntry{Timestamp: ts, NodeID: id, Operacion: OpReservar}
+				mu.Lock()
+				csOrder = append(csOrder, entry)
+				byNode[id] = append(byNode[id], entry)
+				mu.Unlock()
+				c.Exit(id)
+			}
+		}(id)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// Da igual cómo lleguen mezcladas las entradas de los dos nodos: el
+	// orden (timestamp, node_id) es siempre el mismo y es el de la CS
+	rng := rand.New(rand.NewSource(1))
+	for attempt := 0; attempt < 20; attempt++ {
+		merged := append(append([]AuditEntry{}, byNode["node1"]...), byNode["node2"]...)
+		rng.Shuffle(len(merged), func(i, j int) { merged[i], merged[j] = merged[j], merged[i] })
+		sortAuditEntries(merged)
+		for i := range csOrder {
+			if merged[i].Timestamp != csOrder[i].Timestamp || merged[i].NodeID != csOrder[i].NodeID {
+				t.Fatalf("attempt %d: entry %d is %s@%d, but the CS was held by %s@%d",
+					attempt, i, merged[i].NodeID, merged[i].Timestamp, csOrder[i].NodeID, csOrder[i].Timestamp)
+			}
+		}
+	}
+}
+
+func TestSortAuditEntriesBreaksTiesByNodeID(t *testing.T) {
+	entries := []AuditEntry{
+		{Timestamp: 4, NodeID: "server2"},
+		{Timestamp: 3, NodeID: "server3"},
+		{Timestamp: 4, NodeID: "server1"},
+		{Timestamp: 3, NodeID: "server1"},
+	}
+	sortAuditEntries(entries)
+	want := []AuditEntry{
+		{Timestamp: 3, NodeID: "server1"},
+		{Timestamp: 3, NodeID: "server3"},
+		{Timestamp: 4, NodeID: "server1"},
+		{Timestamp: 4, NodeID: "server2"},
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Fatalf("expected %+v at %d, got %+v", want[i], i, entries[i])
+		}
+	}
+}
+
+func TestRecordAuditUsesTheHeldTimestamp(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		c := NewSimCluster("node1", "node2")
+		if err := c.Enter("node1", 2*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Exit("node1")
+		node := c.Node("node1")
+		held, _ := node.HeldTimestamp()
+		// Eventos posteriores adelantan el reloj mientras se está en la CS
+		node.Clock.Increment()
+		node.Clock.Increment()
+
+		s := NewServer(node, nil, NewAuditLog(mt.Coll), "node1")
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if err := s.recordAudit(context.Background(), OpReservar, 7, "ana", "", "op-1"); err != nil {
+			t.Fatal(err)
+		}
+
+		doc := mt.GetStartedEvent().Command.Lookup("documents", "0").Document()
+		if ts := doc.Lookup("timestamp").AsInt64(); ts != held {
+			t.Fatalf("expected the CS timestamp %d, got %d (clock is at %d)", held, ts, node.Clock.GetTime())
+		}
+		if doc.Lookup("node_id").StringValue() != "node1" || doc.Lookup("numero").AsInt64() != 7 {
+			t.Fatalf("unexpected audit entry %v", doc)
+		}
+	})
+}
+
+func TestAuditLogListReturnsTheTotalOrder(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		entry := func(ts int64, node string) bson.D {
+			return bson.D{{Key: "timestamp", Value: ts}, {Key: "node_id", Value: node}, {Key: "numero", Value: 1}}
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.audit", mtest.FirstBatch,
+			entry(5, "server2"), entry(5, "server1"), entry(2, "server3")))
+
+		entries, err := NewAuditLog(mt.Coll).List(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(entries))
+		for i, e := range entries {
+			got[i] = e.NodeID
+		}
+		if len(got) != 3 || got[0] != "server3" || got[1] != "server1" || got[2] != "server2" {
+			t.Fatalf("expected server3, server1, server2; got %v", got)
+		}
+
+		sort := mt.GetStartedEvent().Command.Lookup("sort").Document()
+		keys, _ := sort.Elements()
+		if len(keys) != 2 || keys[0].Key() != "timestamp" || keys[1].Key() != "node_id" {
+			t.Fatalf("expected the query sorted by timestamp then node_id, got %v", sort)
+		}
+	})
+}
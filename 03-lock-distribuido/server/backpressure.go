@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// ReservationLimiter limita las peticiones de reserva en curso en el nodo.
+// Sin límite, una avalancha de clientes crea una goroutine y una conexión por
+// petición, todas esperando la CS; pasado el límite es mejor responder 503
+// enseguida y que el cliente reintente.
+type ReservationLimiter struct {
+	slots chan struct{}
+}
+
+// NewReservationLimiter crea un limitador de max peticiones simultáneas;
+// con max <= 0 no limita nada
+func NewReservationLimiter(max int) *ReservationLimiter {
+	if max <= 0 {
+		return &ReservationLimiter{}
+	}
+	return &ReservationLimiter{slots: make(chan struct{}, max)}
+}
+
+// TryAcquire ocupa un hueco sin esperar. Devuelve false si no queda ninguno.
+func (l *ReservationLimiter) TryAcquire() bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release libera el hueco ocupado con TryAcquire
+func (l *ReservationLimiter) Release() {
+	if l.slots == nil {
+		return
+	}
+	<-l.slots
+}
+
+// InFlight devuelve cuántas peticiones ocupan un hueco y el límite (0 = sin
+// límite)
+func (l *ReservationLimiter) InFlight() (int, int) {
+	return len(l.slots), cap(l.slots)
+}
+
+// limitReservations rechaza con 503 las peticiones que superan el límite de
+// reservas simultáneas en lugar de dejarlas esperando la CS
+func (s *Server) limitReservations(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || s.limiter == nil {
+			next(w, r)
+			return
+		}
+		if !s.limiter.TryAcquire() {
+			_, max := s.limiter.InFlight()
+			log.Printf("[%s] Rejecting %s %s: %d reservations already in flight", s.serverID, r.Method, r.URL.Path, max)
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Retry-After", "1")
+			httperr.Write(w, http.StatusServiceUnavailable, CodeOverloaded, "Demasiadas reservas en curso, reintente en unos segundos")
+			return
+		}
+		defer s.limiter.Release()
+		next(w, r)
+	}
+}
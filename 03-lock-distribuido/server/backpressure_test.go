@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Con el semáforo lleno de reservas esperando la CS, las peticiones que
+// sobran reciben 503 con Retry-After enseguida en lugar de ponerse a la cola
+func TestSaturatedLimiterRejectsExcessReservations(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node1 := c.Node("node1")
+	s := &Server{node: node1, serverID: node1.ID, limiter: NewReservationLimiter(3)}
+	handler := s.limitReservations(func(w http.ResponseWriter, r *http.Request) {
+		release, err := s.acquireCS(r.Context(), 5*time.Second)
+		if err != nil {
+			httperr.Write(w, http.StatusGatewayTimeout, CodeCSTimeout, err.Error())
+			return
+		}
+		release()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// node2 tiene la CS: las reservas de node1 ocupan el semáforo esperando
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	codes := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, "/reservar", nil))
+			codes <- rec.Code
+		}()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if inFlight, _ := s.limiter.InFlight(); inFlight == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the reservations never filled the limiter")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/reservar", nil))
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("expected a fast rejection, took %s", elapsed)
+		}
+		var body httperr.Response
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusServiceUnavailable || body.Error.Code != CodeOverloaded {
+			t.Fatalf("expected 503 %s, got %d %+v", CodeOverloaded, rec.Code, body.Error)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Fatal("expected a Retry-After header")
+		}
+	}
+	// Los preflight no ocupan hueco
+	rec := httptest.NewRecorder()
+	s.limitReservations(func(w http.ResponseWriter, r *http.Request) {})(rec, httptest.NewRequest(http.MethodOptions, "/reservar", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the preflight to go through, got %d", rec.Code)
+	}
+
+	c.Exit("node2")
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("expected the admitted reservations to succeed, got %d", code)
+		}
+	}
+	if inFlight, max := s.limiter.InFlight(); inFlight != 0 || max != 3 {
+		t.Fatalf("expected the limiter empty with room for 3, got %d/%d", inFlight, max)
+	}
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/reservar", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected a reservation after the stampede to succeed, got %d", rec.Code)
+	}
+}
+
+// Con un límite <= 0 no se rechaza nada
+func TestUnlimitedReservationLimiter(t *testing.T) {
+	l := NewReservationLimiter(0)
+	for i := 0; i < 100; i++ {
+		if !l.TryAcquire() {
+			t.Fatalf("expected no limit, rejected after %d", i)
+		}
+	}
+	l.Release()
+	if inFlight, max := l.InFlight(); inFlight != 0 || max != 0 {
+		t.Fatalf("expected an unlimited limiter to report 0/0, got %d/%d", inFlight, max)
+	}
+}
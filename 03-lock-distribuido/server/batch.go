@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Lotes de mensajes: con muchas reservas a la vez, un mismo par de nodos
+// intercambia decenas de POST pequeños. BatchingTransport retiene los envíos
+// a cada peer durante MESSAGE_BATCH_WINDOW_MS (o hasta reunir
+// MESSAGE_BATCH_MAX) y los entrega en
+// un solo POST /internal/messages. El receptor los procesa en el orden del
+// lote, cada uno como si hubiera llegado por /internal/message, y devuelve el
+// resultado de cada uno para que sendMessage lo trate igual que antes.
+
+// Valores por defecto de MESSAGE_BATCH_WINDOW_MS y MESSAGE_BATCH_MAX
+const (
+	defaultBatchWindow = 2 * time.Millisecond
+	defaultBatchMax    = 32
+)
+
+// maxBatchMessages limita los mensajes de un lote recibido
+const maxBatchMessages = 256
+
+// BatchResult es el resultado de un mensaje del lote: el estado HTTP que
+// habría devuelto /internal/message y su respuesta
+type BatchResult struct {
+	Status int `json:"status"`
+	MessageResponse
+}
+
+// BatchResponse es el cuerpo de la respuesta a POST /internal/messages
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// batchSender es un transporte que además sabe entregar varios mensajes al
+// mismo peer de una vez. SendBatch devuelve el estado del lote y, si es 200,
+// un resultado por mensaje en el mismo orden.
+type batchSender interface {
+	Transport
+	SendBatch(peerID string, payloads []json.RawMessage) (int, []BatchResult, error)
+}
+
+// receiveBatch procesa en orden los mensajes de un lote
+func (n *Node) receiveBatch(payloads []json.RawMessage) []BatchResult {
+	results := make([]BatchResult, len(payloads))
+	for i, payload := range payloads {
+		var msg Message
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			n.logf("Rejected message %d of a batch: %v", i, err)
+			results[i].Status = http.StatusBadRequest
+			continue
+		}
+		results[i].Status, results[i].MessageResponse = n.receiveMessage(msg)
+	}
+	return results
+}
+
+// handleInternalMessages es el endpoint de los lotes de mensajes entre nodos.
+// La firma cubre el lote entero.
+func (s *Server) handleInternalMessages(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageBytes))
+	if err != nil {
+		httperr.Write(w, http.StatusBadRequest, CodeInvalidMessage, "Invalid message batch")
+		return
+	}
+
+	if !s.node.signer.Verify(body, r.Header.Get(signatureHeader)) {
+		s.node.stats.recordRejectedSignature()
+		log.Printf("[%s] Rejected message batch from %s with a missing or invalid signature", s.serverID, r.RemoteAddr)
+		httperr.Write(w, http.StatusUnauthorized, CodeInvalidSignature, "Invalid message signature")
+		return
+	}
+
+	var payloads []json.RawMessage
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&payloads); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+	if len(payloads) == 0 || len(payloads) > maxBatchMessages {
+		httperr.Write(w, http.StatusBadRequest, CodeInvalidMessage,
+			fmt.Sprintf("A batch must carry between 1 and %d messages", maxBatchMessages))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchResponse{Results: s.node.receiveBatch(payloads)})
+}
+
+// findPeerBatchURL encuentra la URL del endpoint de lotes de un peer
+func (n *Node) findPeerBatchURL(nodeID string) (string, error) {
+	base, err := n.internalBaseURL(nodeID)
+	if err != nil {
+		return "", err
+	}
+	return base + "/internal/messages", nil
+}
+
+// SendBatch envía los mensajes con un solo POST /internal/messages
+func (t *HTTPTransport) SendBatch(peerID string, payloads []json.RawMessage) (int, []BatchResult, error) {
+	url, err := t.node.findPeerBatchURL(peerID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", errNoRoute, err)
+	}
+	body, err := json.Marshal(payloads)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := t.node.post(url, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil, nil
+	}
+	var batch BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return 0, nil, fmt.Errorf("unreadable batch response: %v", err)
+	}
+	return resp.StatusCode, batch.Results, nil
+}
+
+// SendBatch entrega el lote en memoria; Drop y Delay se aplican al lote
+// entero, como a un POST
+func (t *memoryTransport) SendBatch(peerID string, payloads []json.RawMessage) (int, []BatchResult, error) {
+	t.network.mu.RLock()
+	peer, ok := t.network.nodes[peerID]
+	t.network.mu.RUnlock()
+	if !ok {
+		return 0, nil, fmt.Errorf("peer %q is not attached to the in-memory network", peerID)
+	}
+
+	var first Message
+	json.Unmarshal(payloads[0], &first)
+	if drop := t.network.Drop; drop != nil && drop(t.from, peerID, first) {
+		return 0, nil, errMemoryDropped
+	}
+	if delay := t.network.Delay; delay != nil {
+		time.Sleep(delay(t.from, peerID, first))
+	}
+	return http.StatusOK, peer.receiveBatch(payloads), nil
+}
+
+// batchOutcome es lo que recibe cada envío cuando se entrega su lote
+type batchOutcome struct {
+	status int
+	resp   MessageResponse
+	err    error
+}
+
+// batchItem es un mensaje que espera a que salga su lote
+type batchItem struct {
+	seq     uint64
+	payload []byte
+	done    chan batchOutcome
+}
+
+// BatchingTransport agrupa los envíos a cada peer sobre un transporte con
+// lotes. Send sigue siendo síncrono: devuelve cuando llega la respuesta del
+// lote, con el resultado de su mensaje.
+type BatchingTransport struct {
+	inner batchSender
+	stats *MessageStats
+
+	// Cuánto se retiene el primer mensaje de un lote y cuántos mensajes lo
+	// llenan y lo hacen salir antes
+	Window time.Duration
+	Max    int
+
+	mu      sync.Mutex
+	pending map[string][]*batchItem
+}
+
+// NewBatchingTransport agrupa los envíos de inner
+func NewBatchingTransport(inner batchSender, stats *MessageStats, window time.Duration, max int) *BatchingTransport {
+	return &BatchingTransport{
+		inner:   inner,
+		stats:   stats,
+		Window:  window,
+		Max:     max,
+		pending: make(map[string][]*batchItem),
+	}
+}
+
+func (t *BatchingTransport) Name() string { return t.inner.Name() + "+batch" }
+
+func (t *BatchingTransport) Send(peerID string, seq uint64, payload []byte) (int, MessageResponse, error) {
+	item := &batchItem{seq: seq, payload: payload, done: make(chan batchOutcome, 1)}
+
+	t.mu.Lock()
+	t.pending[peerID] = append(t.pending[peerID], item)
+	switch size := len(t.pending[peerID]); {
+	case size >= t.Max:
+		// Lote lleno: sale ya, desde este mismo envío
+		items := t.take(peerID)
+		t.mu.Unlock()
+		t.flush(peerID, items)
+	case size == 1:
+		// Primer mensaje del lote: sale al acabar la ventana
+		t.mu.Unlock()
+		time.AfterFunc(t.Window, func() {
+			t.mu.Lock()
+			items := t.take(peerID)
+			t.mu.Unlock()
+			t.flush(peerID, items)
+		})
+	default:
+		t.mu.Unlock()
+	}
+
+	outcome := <-item.done
+	return outcome.status, outcome.resp, outcome.err
+}
+
+// take saca los mensajes pendientes para un peer.
+// ASUME QUE t.mu YA ESTÁ ADQUIRIDO.
+func (t *BatchingTransport) take(peerID string) []*batchItem {
+	items := t.pending[peerID]
+	delete(t.pending, peerID)
+	return items
+}
+
+// flush entrega un lote y reparte los resultados. Un lote de un solo mensaje
+// va por el endpoint de siempre, y un peer que no conoce /internal/messages
+// (404) recibe los mensajes uno a uno, en el mismo orden.
+func (t *BatchingTransport) flush(peerID string, items []*batchItem) {
+	if len(items) == 0 {
+		// El lote ya salió al llenarse
+		return
+	}
+	t.stats.recordBatch(len(items))
+
+	if len(items) == 1 {
+		t.sendOne(peerID, items[0])
+		return
+	}
+
+	payloads := make([]json.RawMessage, len(items))
+	for i, item := range items {
+		payloads[i] = item.payload
+	}
+	status, results, err := t.inner.SendBatch(peerID, payloads)
+	if err == nil && status == http.StatusNotFound {
+		for _, item := range items {
+			t.sendOne(peerID, item)
+		}
+		return
+	}
+	if err == nil && status == http.StatusOK && len(results) != len(items) {
+		err = fmt.Errorf("batch of %d messages answered with %d results", len(items), len(results))
+	}
+	for i, item := range items {
+		switch {
+		case err != nil:
+			item.done <- batchOutcome{err: err}
+		case status != http.StatusOK:
+			item.done <- batchOutcome{status: status}
+		default:
+			item.done <- batchOutcome{status: results[i].Status, resp: results[i].MessageResponse}
+		}
+	}
+}
+
+// sendOne entrega un mensaje suelto por el transporte de debajo
+func (t *BatchingTransport) sendOne(peerID string, item *batchItem) {
+	status, resp, err := t.inner.Send(peerID, item.seq, item.payload)
+	item.done <- batchOutcome{status: status, resp: resp, err: err}
+}
+
+// UseBatching agrupa los envíos del nodo en lotes de hasta max mensajes
+// retenidos como mucho window. Solo los transportes con lotes (HTTP y la red
+// en memoria) lo admiten.
+func (n *Node) UseBatching(window time.Duration, max int) error {
+	inner, ok := n.transport.(batchSender)
+	if !ok {
+		return fmt.Errorf("transport %s does not support batching", n.transport.Name())
+	}
+	if window <= 0 || max < 2 {
+		return errors.New("batching needs a positive window and room for at least 2 messages")
+	}
+	if max > maxBatchMessages {
+		return fmt.Errorf("batches are limited to %d messages", maxBatchMessages)
+	}
+	n.transport = NewBatchingTransport(inner, n.stats, window, max)
+	return nil
+}
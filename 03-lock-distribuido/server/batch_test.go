@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Cuatro mensajes de node1 a node2 salen en un solo lote, que node2 procesa
+// en el orden en que se encolaron y avanzando el reloj con cada uno. Después
+// el clúster entero, con lotes en todos los nodos, compite por la CS sin
+// violar la exclusión mutua.
+func TestMessageBatching(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1, node2 := c.Node("node1"), c.Node("node2")
+	node2.trace = NewTraceRecorder(100)
+	if err := node1.UseBatching(time.Second, 4); err != nil {
+		t.Fatal(err)
+	}
+	batching := node1.transport.(*BatchingTransport)
+
+	// Encolar de uno en uno para fijar el orden del lote; el cuarto lo llena
+	// y lo hace salir sin esperar a la ventana
+	timestamps := []int64{10, 40, 20, 30}
+	results := make(chan error, len(timestamps))
+	for i, ts := range timestamps {
+		payload, err := json.Marshal(Message{
+			Type:              "REPLY",
+			Timestamp:         ts,
+			NodeID:            "node1",
+			MembershipVersion: node1.MembershipVersion(),
+			Seq:               node1.nextSeq(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(seq uint64, payload []byte) {
+			status, _, err := batching.Send("node2", seq, payload)
+			if err == nil && status != http.StatusOK {
+				err = fmt.Errorf("message %d answered with status %d", seq, status)
+			}
+			results <- err
+		}(uint64(i+1), payload)
+
+		if i == len(timestamps)-1 {
+			break
+		}
+		deadline := time.Now().Add(time.Second)
+		for {
+			batching.mu.Lock()
+			queued := len(batching.pending["node2"])
+			batching.mu.Unlock()
+			if queued == i+1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("message %d was never queued", i+1)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for range timestamps {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := node1.MessageStats(); stats.BatchesSent != 1 || stats.AvgBatchSize != 4 {
+		t.Fatalf("expected 1 batch of 4 messages, got %d batches of %.1f",
+			stats.BatchesSent, stats.AvgBatchSize)
+	}
+	var received []TraceEvent
+	for _, ev := range node2.trace.Since(0, 100) {
+		if ev.Direction == traceReceived && ev.Peer == "node1" {
+			received = append(received, ev)
+		}
+	}
+	if len(received) != len(timestamps) {
+		t.Fatalf("node2 traced %d messages from node1, expected %d", len(received), len(timestamps))
+	}
+	clock := received[0].Clock - 1
+	if clock < 0 {
+		clock = 0
+	}
+	for i, ev := range received {
+		if ev.Timestamp != timestamps[i] {
+			t.Fatalf("message %d of the batch carried ts %d, expected %d: order not preserved",
+				i+1, ev.Timestamp, timestamps[i])
+		}
+		if clock < ev.Timestamp {
+			clock = ev.Timestamp
+		}
+		clock++
+		if ev.Clock != clock {
+			t.Fatalf("after message %d node2's clock is %d, expected %d", i+1, ev.Clock, clock)
+		}
+	}
+
+	// Con lotes en todos los nodos el algoritmo sigue funcionando
+	for _, id := range []string{"node2", "node3"} {
+		if err := c.Node(id).UseBatching(5*time.Millisecond, 8); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batching.Window = 5 * time.Millisecond
+	for round := 0; round < 3; round++ {
+		done := make(chan error, 3)
+		for _, id := range []string{"node1", "node2", "node3"} {
+			id := id
+			go func() {
+				err := c.Enter(id, 3*time.Second)
+				if err == nil {
+					time.Sleep(2 * time.Millisecond)
+					c.Exit(id)
+				}
+				done <- err
+			}()
+		}
+		for i := 0; i < 3; i++ {
+			if err := <-done; err != nil {
+				t.Fatalf("round %d: %v", round, err)
+			}
+		}
+	}
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("%d mutual exclusion violations with batching", v)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BenchResult es una fila del informe de `server bench`
+type BenchResult struct {
+	Nodes         int
+	Entries       int
+	Duration      time.Duration
+	AvgWait       time.Duration
+	P99Wait       time.Duration
+	Messages      uint64
+	Violations    int64
+	CyclesPerNode int
+}
+
+// runBench mide la sección crítica con clústeres de distintos tamaños dentro
+// del mismo proceso, conectados por una MemoryNetwork, y escribe una fila CSV
+// por tamaño:
+//
+//	server bench -sizes 2,3,5,8 -cycles 50 -workers 2 -delay 1ms
+//
+// Cada nodo hace cycles entradas con workers peticiones simultáneas. La
+// latencia de cada mensaje es delay más un jitter aleatorio con semilla fija,
+// así que dos ejecuciones con los mismos parámetros son comparables.
+func runBench(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	sizesFlag := fs.String("sizes", "2,3,5,8", "comma-separated cluster sizes")
+	cycles := fs.Int("cycles", 50, "CS entries per node")
+	workers := fs.Int("workers", 1, "concurrent requesters per node")
+	delay := fs.Duration("delay", time.Millisecond, "one-way latency of each message")
+	jitter := fs.Duration("jitter", 500*time.Microsecond, "maximum random extra latency per message")
+	hold := fs.Duration("hold", 0, "time spent inside the CS on each entry")
+	algorithmFlag := fs.String("algorithm", AlgorithmRicartAgrawala, "ricart-agrawala or lamport-queue")
+	seed := fs.Int64("seed", 1, "seed for the latency jitter")
+	verbose := fs.Bool("v", false, "keep the algorithm logs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var sizes []int
+	for _, s := range strings.Split(*sizesFlag, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid cluster size %q", s)
+		}
+		sizes = append(sizes, n)
+	}
+	if *cycles < 1 || *workers < 1 {
+		return fmt.Errorf("cycles and workers must be positive")
+	}
+	algorithm, err := parseAlgorithm(*algorithmFlag)
+	if err != nil {
+		return err
+	}
+	if algorithm == AlgorithmRaft {
+		// Raft replica por su propio cliente HTTP, no por el Transport
+		return fmt.Errorf("the benchmark does not support %q", AlgorithmRaft)
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{
+		"nodes", "cycles_per_node", "entries", "duration_s", "entries_per_s",
+		"avg_wait_ms", "p99_wait_ms", "messages", "messages_per_entry", "violations",
+	})
+	for _, size := range sizes {
+		res := benchCluster(size, *cycles, *workers, *delay, *jitter, *hold, algorithm, *seed)
+		w.Write([]string{
+			strconv.Itoa(res.Nodes),
+			strconv.Itoa(res.CyclesPerNode),
+			strconv.Itoa(res.Entries),
+			strconv.FormatFloat(res.Duration.Seconds(), 'f', 3, 64),
+			strconv.FormatFloat(float64(res.Entries)/res.Duration.Seconds(), 'f', 1, 64),
+			strconv.FormatFloat(durationMs(res.AvgWait), 'f', 3, 64),
+			strconv.FormatFloat(durationMs(res.P99Wait), 'f', 3, 64),
+			strconv.FormatUint(res.Messages, 10),
+			strconv.FormatFloat(float64(res.Messages)/float64(res.Entries), 'f', 2, 64),
+			strconv.FormatInt(res.Violations, 10),
+		})
+		w.Flush()
+		if res.Violations > 0 {
+			return fmt.Errorf("mutual exclusion violated %d times with %d nodes", res.Violations, size)
+		}
+	}
+	return w.Error()
+}
+
+// benchCluster monta un clúster de size nodos en memoria y mide cycles
+// entradas por nodo
+func benchCluster(size, cycles, workers int, delay, jitter, hold time.Duration, algorithm string, seed int64) BenchResult {
+	ids := make([]string, size)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("node%d", i+1)
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	var rngMu sync.Mutex
+	network := NewMemoryNetwork()
+	network.Delay = func(from, to string, msg Message) time.Duration {
+		if jitter <= 0 {
+			return delay
+		}
+		rngMu.Lock()
+		defer rngMu.Unlock()
+		return delay + time.Duration(rng.Int63n(int64(jitter)))
+	}
+
+	nodes := make([]*Node, size)
+	for i, id := range ids {
+		var peers []string
+		for _, p := range ids {
+			if p != id {
+				peers = append(peers, p)
+			}
+		}
+		node := NewNode(id, peers, nil)
+		node.Algorithm = algorithm
+		node.trace = nil
+		network.Attach(node)
+		nodes[i] = node
+	}
+
+	var (
+		inside     int32
+		violations int64
+		mu         sync.Mutex
+		waits      []time.Duration
+		wg         sync.WaitGroup
+	)
+	start := time.Now()
+	for _, node := range nodes {
+		// Repartir los ciclos del nodo entre sus workers
+		for w := 0; w < workers; w++ {
+			share := cycles / workers
+			if w < cycles%workers {
+				share++
+			}
+			wg.Add(1)
+			go func(node *Node, share int) {
+				defer wg.Done()
+				for i := 0; i < share; i++ {
+					requested := time.Now()
+					if err := node.RequestCSContext(context.Background()); err != nil {
+						continue
+					}
+					wait := time.Since(requested)
+
+					if atomic.AddInt32(&inside, 1) > 1 {
+						atomic.AddInt64(&violations, 1)
+					}
+					if hold > 0 {
+						time.Sleep(hold)
+					}
+					atomic.AddInt32(&inside, -1)
+					node.ReleaseCS()
+
+					mu.Lock()
+					waits = append(waits, wait)
+					mu.Unlock()
+				}
+			}(node, share)
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	res := BenchResult{
+		Nodes:         size,
+		Entries:       len(waits),
+		Duration:      elapsed,
+		Violations:    violations,
+		CyclesPerNode: cycles,
+	}
+	for _, node := range nodes {
+		res.Messages += node.MessageStats().TotalSent
+	}
+	if len(waits) > 0 {
+		sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+		var total time.Duration
+		for _, wait := range waits {
+			total += wait
+		}
+		res.AvgWait = total / time.Duration(len(waits))
+		res.P99Wait = waits[(len(waits)*99+99)/100-1]
+	}
+	return res
+}
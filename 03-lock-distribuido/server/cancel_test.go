@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// waitWanted espera a que el nodo esté esperando la CS
+func waitWanted(t *testing.T, node *Node) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for node.CSStatus().State != Wanted.String() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never started waiting for the CS", node.ID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Un cliente que se va mientras la petición espera la CS la aborta: el
+// handler responde sin tocar la base de datos y el nodo no se queda
+// esperando ni entra después
+func TestReservarAbortsWhenClientDisconnects(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// Sin colección: si el handler llegara a la BD entraría en pánico
+	s := &Server{node: c.Node("node1"), serverID: "node1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/reservar", strings.NewReader(`{"numero":4,"cliente":"ana"}`)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.handleReservarAsiento(rec, req)
+		close(done)
+	}()
+
+	waitWanted(t, c.Node("node1"))
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handler kept waiting after the client disconnected")
+	}
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rec.Code)
+	}
+	var body httperr.Response
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body.Error.Code != CodeCSTimeout {
+		t.Fatalf("expected code %s, got %q", CodeCSTimeout, body.Error.Code)
+	}
+	if state := c.Node("node1").CSStatus().State; state != Released.String() {
+		t.Fatalf("expected node1 to drop its request, got %s", state)
+	}
+
+	// Al liberar node2, node1 no entra: su petición ya no existe
+	c.Exit("node2")
+	time.Sleep(50 * time.Millisecond)
+	if holders := c.Holders(); len(holders) != 0 {
+		t.Fatalf("expected nobody to hold the CS, held by %v", holders)
+	}
+	if err := c.Enter("node3", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node3")
+}
+
+// Al abortar, los REPLY que el nodo había pospuesto mientras esperaba se
+// envían: quien llegó detrás no puede seguir esperando a un nodo que ya no
+// quiere la CS
+func TestCancelledRequestSendsDeferredReplies(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{node: c.Node("node1"), serverID: "node1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acquired := make(chan error, 1)
+	go func() {
+		release, err := s.acquireCS(ctx, csWaitTimeout)
+		if release != nil {
+			release()
+		}
+		acquired <- err
+	}()
+	waitWanted(t, c.Node("node1"))
+
+	// node3 pide después que node1, que tiene prioridad y le pospone el REPLY
+	entered := make(chan error, 1)
+	go func() { entered <- c.Enter("node3", 3*time.Second) }()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.Node("node1").DebugState().DeferredReplies) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("node1 never deferred its reply to node3")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-acquired; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the wait to end with context.Canceled, got %v", err)
+	}
+	if deferred := c.Node("node1").DebugState().DeferredReplies; len(deferred) != 0 {
+		t.Fatalf("expected node1 to send its deferred replies, still owes %v", deferred)
+	}
+
+	c.Exit("node2")
+	if err := <-entered; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node3")
+}
+
+func TestAcquireCSTimesOut(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Exit("node2")
+	s := &Server{node: c.Node("node1"), serverID: "node1"}
+
+	release, err := s.acquireCS(context.Background(), 50*time.Millisecond)
+	if !errors.Is(err, errCSTimeout) || release != nil {
+		t.Fatalf("expected errCSTimeout and no release func, got %v", err)
+	}
+	if state := c.Node("node1").CSStatus().State; state != Released.String() {
+		t.Fatalf("expected node1 to drop its request after the timeout, got %s", state)
+	}
+}
+
+// Una petición cancelada cuya concesión llega a la vez deja una señal antigua
+// en csGranted. La siguiente petición no debe tomarla por suya ni quedarse
+// bloqueada por ella.
+func TestRerequestIgnoresAStaleGrant(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// node2 pospone la respuesta, así que la petición de node1 expira
+	if err := c.Enter("node1", 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected node1's request to time out, got %v", err)
+	}
+
+	// Simular la concesión que se cruzó con la cancelación
+	node1 := c.Node("node1")
+	node1.mu.Lock()
+	node1.csGranted <- node1.csToken
+	node1.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Enter("node1", 2*time.Second) }()
+	select {
+	case err := <-done:
+		t.Fatalf("node1 took the stale grant while node2 held the CS (err: %v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Exit("node2")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("node1 wedged after re-requesting the CS")
+	}
+	c.Exit("node1")
+
+	if pending := len(node1.csGranted); pending != 0 {
+		t.Fatalf("%d grant(s) left in node1's channel after releasing", pending)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Inspección de los relojes lógicos. /internal/clock publica el reloj de
+// Lamport del nodo y el mayor timestamp que ha recibido de cada peer, y
+// /internal/clock/skew consulta a todos los nodos para ver cuánto se han
+// separado sus relojes. Solo observa: el frontend lo usa para dibujar el
+// diagrama de sincronización de relojes.
+
+// ClockReport es el reloj de un nodo tal como lo publica /internal/clock
+type ClockReport struct {
+	NodeID string `json:"node_id"`
+	Time   int64  `json:"time"`
+	// Mayor timestamp recibido de cada peer
+	Witnessed map[string]int64 `json:"witnessed"`
+	// Reloj vectorial, solo con CLOCK_MODE=vector
+	Vector map[string]int64 `json:"vector,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// ClockReport devuelve el estado actual del reloj del nodo
+func (n *Node) ClockReport() ClockReport {
+	report := ClockReport{
+		NodeID:    n.ID,
+		Time:      n.Clock.GetTime(),
+		Witnessed: n.Clock.Witnessed(),
+	}
+	if n.VClock != nil {
+		report.Vector = n.VClock.Snapshot()
+	}
+	return report
+}
+
+// ClockSkewNode es el reloj de un nodo comparado con el resto
+type ClockSkewNode struct {
+	ClockReport
+	Reachable bool `json:"reachable"`
+	// Ticks que le faltan para alcanzar el reloj más adelantado
+	Behind int64 `json:"behind"`
+	// Ticks que lleva por delante de lo último que otro nodo recibió de él
+	// (el peor caso entre los que respondieron)
+	PeerLag int64 `json:"peer_lag"`
+}
+
+// ClockSkew es la vista de los relojes de todo el clúster. Los nodos se
+// consultan en paralelo pero no en el mismo instante, así que los valores son
+// aproximados en los ticks de los mensajes que se cruzan con la consulta.
+type ClockSkew struct {
+	QueriedBy string `json:"queried_by"`
+	Min       int64  `json:"min"`
+	Max       int64  `json:"max"`
+	// Max - Min entre los nodos que respondieron
+	Skew  int64           `json:"skew"`
+	Nodes []ClockSkewNode `json:"nodes"`
+}
+
+// QueryClockSkew consulta /internal/clock en todos los peers en paralelo y
+// compara sus relojes con el local
+func (n *Node) QueryClockSkew(client *http.Client) ClockSkew {
+	peers := n.PeerList()
+	reports := make([]ClockReport, len(peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			reports[i] = n.fetchClock(client, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	return aggregateClockSkew(n.ID, append(reports, n.ClockReport()))
+}
+
+// fetchClock obtiene el reloj de un peer; si no responde, el informe lleva
+// el error
+func (n *Node) fetchClock(client *http.Client, peerID string) ClockReport {
+	failed := func(err error) ClockReport {
+		return ClockReport{NodeID: peerID, Error: err.Error()}
+	}
+
+	base, err := n.internalBaseURL(peerID)
+	if err != nil {
+		return failed(err)
+	}
+	resp, err := client.Get(base + "/internal/clock")
+	if err != nil {
+		return failed(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return failed(fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+
+	var report ClockReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return failed(err)
+	}
+	report.NodeID = peerID
+	return report
+}
+
+// aggregateClockSkew ordena los informes por nodo y calcula la separación
+// entre los relojes de los que respondieron
+func aggregateClockSkew(queriedBy string, reports []ClockReport) ClockSkew {
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].NodeID < reports[j].NodeID
+	})
+
+	view := ClockSkew{QueriedBy: queriedBy, Nodes: make([]ClockSkewNode, len(reports))}
+	first := true
+	for i, report := range reports {
+		view.Nodes[i] = ClockSkewNode{ClockReport: report, Reachable: report.Error == ""}
+		if report.Error != "" {
+			continue
+		}
+		if first || report.Time < view.Min {
+			view.Min = report.Time
+		}
+		if first || report.Time > view.Max {
+			view.Max = report.Time
+		}
+		first = false
+	}
+	view.Skew = view.Max - view.Min
+
+	for i := range view.Nodes {
+		node := &view.Nodes[i]
+		if !node.Reachable {
+			continue
+		}
+		node.Behind = view.Max - node.Time
+		for _, other := range reports {
+			if other.Error != "" || other.NodeID == node.NodeID {
+				continue
+			}
+			if lag := node.Time - other.Witnessed[node.NodeID]; lag > node.PeerLag {
+				node.PeerLag = lag
+			}
+		}
+	}
+	return view
+}
+
+// handleClock publica el reloj del nodo
+func (s *Server) handleClock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.node.ClockReport())
+}
+
+// handleClockSkew consulta el reloj de todos los nodos y publica cuánto se
+// han separado
+func (s *Server) handleClockSkew(w http.ResponseWriter, r *http.Request) {
+	client := &http.Client{Timeout: 2 * time.Second, Transport: s.node.client.Transport}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.node.QueryClockSkew(client))
+}
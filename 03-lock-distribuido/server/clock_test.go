@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Cada nodo anota el mayor timestamp recibido de cada peer, que nunca supera
+// el reloj del peer, y el informe de separación se calcula solo con los nodos
+// que responden
+func TestClockSkewReport(t *testing.T) {
+	clock := NewLamportClock()
+	clock.WitnessFrom("node2", 9)
+	clock.WitnessFrom("node2", 4)
+	if got := clock.Witnessed()["node2"]; got != 9 {
+		t.Fatalf("a late, older timestamp moved the witnessed value back to %d", got)
+	}
+
+	ids := []string{"node1", "node2", "node3"}
+	c := NewSimCluster(ids...)
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ids))
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for i := 0; i < 3; i++ {
+				if err := c.Enter(id, 2*time.Second); err != nil {
+					errs <- err
+					return
+				}
+				c.Exit(id)
+			}
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	// Los REPLY pospuestos salen en goroutines al liberar
+	time.Sleep(50 * time.Millisecond)
+
+	var reports []ClockReport
+	for _, id := range ids {
+		reports = append(reports, c.Node(id).ClockReport())
+	}
+	times := map[string]int64{}
+	for _, report := range reports {
+		times[report.NodeID] = report.Time
+	}
+	for _, report := range reports {
+		for _, peer := range ids {
+			if peer == report.NodeID {
+				continue
+			}
+			witnessed, ok := report.Witnessed[peer]
+			if !ok || witnessed <= 0 {
+				t.Fatalf("%s has witnessed nothing from %s: %v", report.NodeID, peer, report.Witnessed)
+			}
+			if witnessed > times[peer] {
+				t.Fatalf("%s witnessed %d from %s, ahead of its clock %d", report.NodeID, witnessed, peer, times[peer])
+			}
+		}
+	}
+
+	reports = append(reports, ClockReport{NodeID: "node4", Error: "connection refused"})
+	view := aggregateClockSkew("node1", reports)
+	var min, max int64 = -1, 0
+	for _, t := range times {
+		if min < 0 || t < min {
+			min = t
+		}
+		if t > max {
+			max = t
+		}
+	}
+	if view.Min != min || view.Max != max || view.Skew != max-min {
+		t.Fatalf("skew %d..%d (%d), want %d..%d", view.Min, view.Max, view.Skew, min, max)
+	}
+	if len(view.Nodes) != 4 || view.Nodes[3].NodeID != "node4" || view.Nodes[3].Reachable {
+		t.Fatalf("the unreachable node should be listed last and marked unreachable: %+v", view.Nodes)
+	}
+	for _, node := range view.Nodes[:3] {
+		if node.Behind != max-node.Time {
+			t.Fatalf("%s is %d behind, want %d", node.NodeID, node.Behind, max-node.Time)
+		}
+		if node.PeerLag < 0 || node.PeerLag > node.Time {
+			t.Fatalf("%s has an impossible peer lag of %d", node.NodeID, node.PeerLag)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CSStatus es el estado de un nodo respecto a la sección crítica, tal como lo
+// publica /cs-status
+type CSStatus struct {
+	NodeID           string     `json:"node_id"`
+	State            string     `json:"state"` // Released, Wanted, Held o Unknown si no responde
+	RequestTimestamp int64      `json:"request_timestamp,omitempty"`
+	HeldSince        *time.Time `json:"held_since,omitempty"`
+	HeldForMs        int64      `json:"held_for_ms,omitempty"`
+	Error            string     `json:"error,omitempty"`
+}
+
+// stateUnknown marca a los peers que no respondieron a la consulta
+const stateUnknown = "Unknown"
+
+// CSStatus devuelve el estado actual del nodo respecto a la CS
+func (n *Node) CSStatus() CSStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	status := CSStatus{
+		NodeID: n.ID,
+		State:  n.State.String(),
+	}
+	if n.State != Released {
+		status.RequestTimestamp = n.RequestTime
+	}
+	if n.State == Held {
+		since := n.heldSince
+		status.HeldSince = &since
+		status.HeldForMs = time.Since(since).Milliseconds()
+	}
+	return status
+}
+
+// ClusterCSStatus es la vista agregada de la CS en todo el clúster
+type ClusterCSStatus struct {
+	QueriedBy string     `json:"queried_by"`
+	Holders   []CSStatus `json:"holders"` // Más de uno indica una violación de la exclusión mutua
+	Nodes     []CSStatus `json:"nodes"`
+}
+
+// QueryClusterCSStatus consulta /cs-status en todos los peers en paralelo y
+// agrega las respuestas junto con el estado local
+func (n *Node) QueryClusterCSStatus(client *http.Client) ClusterCSStatus {
+	peers := n.PeerList()
+	statuses := make([]CSStatus, len(peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			statuses[i] = n.fetchCSStatus(client, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	return aggregateCSStatus(n.ID, append(statuses, n.CSStatus()))
+}
+
+// fetchCSStatus obtiene el estado de la CS de un peer; si no responde, su
+// estado es Unknown
+func (n *Node) fetchCSStatus(client *http.Client, peerID string) CSStatus {
+	unknown := func(err error) CSStatus {
+		return CSStatus{NodeID: peerID, State: stateUnknown, Error: err.Error()}
+	}
+
+	base, err := n.peerBaseURL(peerID)
+	if err != nil {
+		return unknown(err)
+	}
+
+	resp, err := client.Get(base + "/cs-status")
+	if err != nil {
+		return unknown(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return unknown(fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+
+	var status CSStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return unknown(err)
+	}
+	status.NodeID = peerID
+	return status
+}
+
+// aggregateCSStatus ordena los estados por nodo y extrae los que tienen la CS
+func aggregateCSStatus(queriedBy string, statuses []CSStatus) ClusterCSStatus {
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].NodeID < statuses[j].NodeID
+	})
+
+	holders := []CSStatus{}
+	for _, status := range statuses {
+		if status.State == Held.String() {
+			holders = append(holders, status)
+		}
+	}
+
+	return ClusterCSStatus{
+		QueriedBy: queriedBy,
+		Holders:   holders,
+		Nodes:     statuses,
+	}
+}
+
+// Estado agregado que devuelve /cluster/health
+const (
+	clusterHealthy   = "healthy"   // todos los nodos responden
+	clusterDegraded  = "degraded"  // alguno caído, pero queda mayoría
+	clusterUnhealthy = "unhealthy" // sin mayoría de nodos vivos
+)
+
+// PeerHealth es el resultado de consultar /health en un nodo
+type PeerHealth struct {
+	Status    string `json:"status"` // el que publica el nodo, o "down" si no responde
+	Time      int64  `json:"time,omitempty"`
+	Reachable bool   `json:"reachable"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ClusterHealth es la vista agregada de /health en todo el clúster
+type ClusterHealth struct {
+	QueriedBy string                `json:"queried_by"`
+	Status    string                `json:"status"`
+	Up        int                   `json:"up"`
+	Down      int                   `json:"down"`
+	Nodes     map[string]PeerHealth `json:"nodes"`
+}
+
+// QueryClusterHealth consulta /health en todos los peers en paralelo. Un peer
+// que no responde cuenta como "down" en lugar de hacer fallar la consulta.
+func (n *Node) QueryClusterHealth(client *http.Client) ClusterHealth {
+	peers := n.PeerList()
+	results := make([]PeerHealth, len(peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			results[i] = n.fetchHealth(client, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	nodes := make(map[string]PeerHealth, len(peers)+1)
+	for i, peer := range peers {
+		nodes[peer] = results[i]
+	}
+	nodes[n.ID] = PeerHealth{Status: "healthy", Time: n.Clock.GetTime(), Reachable: true}
+	return aggregateHealth(n.ID, nodes)
+}
+
+// fetchHealth consulta /health en un peer
+func (n *Node) fetchHealth(client *http.Client, peerID string) PeerHealth {
+	down := func(err error) PeerHealth {
+		return PeerHealth{Status: "down", Error: err.Error()}
+	}
+
+	base, err := n.peerBaseURL(peerID)
+	if err != nil {
+		return down(err)
+	}
+
+	start := time.Now()
+	resp, err := client.Get(base + "/health")
+	if err != nil {
+		return down(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return down(fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+
+	var health PeerHealth
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return down(err)
+	}
+	health.Reachable = true
+	health.LatencyMs = time.Since(start).Milliseconds()
+	return health
+}
+
+// aggregateHealth cuenta los nodos vivos y calcula el estado del clúster
+func aggregateHealth(queriedBy string, nodes map[string]PeerHealth) ClusterHealth {
+	health := ClusterHealth{QueriedBy: queriedBy, Nodes: nodes}
+	for _, node := range nodes {
+		if node.Reachable {
+			health.Up++
+		} else {
+			health.Down++
+		}
+	}
+
+	switch {
+	case health.Down == 0:
+		health.Status = clusterHealthy
+	case health.Up > len(nodes)/2:
+		health.Status = clusterDegraded
+	default:
+		health.Status = clusterUnhealthy
+	}
+	return health
+}
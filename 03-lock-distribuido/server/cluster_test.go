@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// csStatusPeer simula el /cs-status de un peer con la respuesta indicada
+func csStatusPeer(t *testing.T, status int, body interface{}) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cs-status" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestClusterCSHolderReportsTheHolder(t *testing.T) {
+	since := time.Now().Add(-3 * time.Second)
+	// node5 no escucha: su servidor se cierra antes de la consulta
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	urls := map[string]string{
+		"node2": csStatusPeer(t, http.StatusOK, CSStatus{NodeID: "node2", State: "Held", RequestTimestamp: 12, HeldSince: &since, HeldForMs: 3000}),
+		"node3": csStatusPeer(t, http.StatusOK, CSStatus{NodeID: "node3", State: "Wanted", RequestTimestamp: 14}),
+		"node4": csStatusPeer(t, http.StatusInternalServerError, map[string]string{"error": "boom"}),
+		"node5": down.URL,
+	}
+	node := NewNode("node1", []string{"node2", "node3", "node4", "node5"}, urls)
+	s := &Server{node: node, serverID: "node1"}
+
+	rec := httptest.NewRecorder()
+	s.handleClusterCSHolder(rec, httptest.NewRequest(http.MethodGet, "/cluster/cs-holder", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var status ClusterCSStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.QueriedBy != "node1" {
+		t.Fatalf("expected queried_by node1, got %q", status.QueriedBy)
+	}
+	if len(status.Holders) != 1 || status.Holders[0].NodeID != "node2" {
+		t.Fatalf("expected node2 to be the only holder, got %+v", status.Holders)
+	}
+	if held := status.Holders[0]; held.HeldForMs != 3000 || held.HeldSince == nil || !held.HeldSince.Equal(since) {
+		t.Fatalf("expected node2's hold time to be reported, got %+v", held)
+	}
+
+	want := map[string]string{"node1": "Released", "node2": "Held", "node3": "Wanted", "node4": stateUnknown, "node5": stateUnknown}
+	if len(status.Nodes) != len(want) {
+		t.Fatalf("expected %d nodes, got %+v", len(want), status.Nodes)
+	}
+	for i, node := range status.Nodes {
+		if i > 0 && status.Nodes[i-1].NodeID >= node.NodeID {
+			t.Fatalf("expected nodes sorted by id, got %+v", status.Nodes)
+		}
+		if node.State != want[node.NodeID] {
+			t.Errorf("expected %s to be %s, got %s", node.NodeID, want[node.NodeID], node.State)
+		}
+		if node.State == stateUnknown && node.Error == "" {
+			t.Errorf("expected %s to explain why it is unknown", node.NodeID)
+		}
+	}
+}
+
+// El ID del nodo es el de la URL consultada, no el que diga la respuesta
+func TestFetchCSStatusUsesThePeerID(t *testing.T) {
+	urls := map[string]string{
+		"node2": csStatusPeer(t, http.StatusOK, CSStatus{NodeID: "impostor", State: "Released"}),
+		"node3": csStatusPeer(t, http.StatusOK, "not a status"),
+	}
+	node := NewNode("node1", []string{"node2", "node3", "node4"}, urls)
+	client := &http.Client{Timeout: time.Second}
+
+	if got := node.fetchCSStatus(client, "node2"); got.NodeID != "node2" || got.State != "Released" {
+		t.Fatalf("expected node2 Released, got %+v", got)
+	}
+	if got := node.fetchCSStatus(client, "node3"); got.State != stateUnknown {
+		t.Fatalf("expected an unreadable body to mark node3 Unknown, got %+v", got)
+	}
+	if got := node.fetchCSStatus(client, "node4"); got.State != stateUnknown || got.Error == "" {
+		t.Fatalf("expected a peer without URL to be Unknown, got %+v", got)
+	}
+}
+
+// Dos nodos en Held a la vez aparecen los dos como titulares
+func TestAggregateCSStatusReportsEveryHolder(t *testing.T) {
+	status := aggregateCSStatus("node1", []CSStatus{
+		{NodeID: "node3", State: "Held"},
+		{NodeID: "node1", State: "Released"},
+		{NodeID: "node2", State: "Held"},
+	})
+	if len(status.Holders) != 2 || status.Holders[0].NodeID != "node2" || status.Holders[1].NodeID != "node3" {
+		t.Fatalf("expected node2 and node3 as holders, got %+v", status.Holders)
+	}
+
+	status = aggregateCSStatus("node1", []CSStatus{{NodeID: "node1", State: "Released"}})
+	if status.Holders == nil || len(status.Holders) != 0 {
+		t.Fatalf("expected an empty, non-nil holder list, got %#v", status.Holders)
+	}
+}
+
+// Un nodo local que tiene la CS se cuenta entre los titulares
+func TestClusterCSStatusIncludesTheLocalNode(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Exit("node1")
+
+	c.Node("node1").SetPeerURL("node2", csStatusPeer(t, http.StatusOK, CSStatus{State: "Released"}))
+
+	status := c.Node("node1").QueryClusterCSStatus(&http.Client{Timeout: time.Second})
+	if len(status.Holders) != 1 || status.Holders[0].NodeID != "node1" || status.Holders[0].HeldSince == nil {
+		t.Fatalf("expected node1 to be reported as holder, got %+v", status.Holders)
+	}
+}
+
+// healthPeer simula el /health de un peer que tarda delay en responder
+func healthPeer(t *testing.T, delay time.Duration, clock int64) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "healthy", "time": clock})
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// Un peer caído aparece como "down" sin hacer fallar la consulta
+func TestClusterHealthReportsOneDownNode(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	urls := map[string]string{
+		"node2": healthPeer(t, 0, 7),
+		"node3": healthPeer(t, 0, 9),
+		"node4": down.URL,
+	}
+	node := NewNode("node1", []string{"node2", "node3", "node4"}, urls)
+	s := &Server{node: node, serverID: "node1"}
+
+	rec := httptest.NewRecorder()
+	s.handleClusterHealth(rec, httptest.NewRequest(http.MethodGet, "/cluster/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var health ClusterHealth
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+
+	if health.QueriedBy != "node1" || health.Up != 3 || health.Down != 1 || health.Status != clusterDegraded {
+		t.Fatalf("expected 3 up, 1 down and degraded, got %+v", health)
+	}
+	if n4 := health.Nodes["node4"]; n4.Status != "down" || n4.Reachable || n4.Error == "" {
+		t.Fatalf("expected node4 down with an error, got %+v", n4)
+	}
+	if n2 := health.Nodes["node2"]; !n2.Reachable || n2.Status != "healthy" || n2.Time != 7 {
+		t.Fatalf("expected node2 healthy at time 7, got %+v", n2)
+	}
+	if n1 := health.Nodes["node1"]; !n1.Reachable {
+		t.Fatalf("expected the local node to be reported up, got %+v", n1)
+	}
+}
+
+// Los peers se consultan a la vez: un peer colgado cuesta el timeout una
+// sola vez y los lentos no se suman
+func TestClusterHealthQueriesPeersConcurrently(t *testing.T) {
+	urls := map[string]string{
+		"node2": healthPeer(t, 80*time.Millisecond, 1),
+		"node3": healthPeer(t, 80*time.Millisecond, 2),
+		"node4": healthPeer(t, 80*time.Millisecond, 3),
+		"node5": healthPeer(t, 5*time.Second, 4),
+	}
+	node := NewNode("node1", []string{"node2", "node3", "node4", "node5"}, urls)
+
+	start := time.Now()
+	health := node.QueryClusterHealth(&http.Client{Timeout: 200 * time.Millisecond})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the peers to be queried in parallel, took %s", elapsed)
+	}
+	if health.Up != 4 || health.Down != 1 || health.Nodes["node5"].Status != "down" {
+		t.Fatalf("expected only the hung node5 to be down, got %+v", health)
+	}
+}
+
+func TestAggregateHealthStatus(t *testing.T) {
+	up := PeerHealth{Status: "healthy", Reachable: true}
+	down := PeerHealth{Status: "down"}
+	for _, tc := range []struct {
+		nodes map[string]PeerHealth
+		want  string
+	}{
+		{map[string]PeerHealth{"node1": up, "node2": up, "node3": up}, clusterHealthy},
+		{map[string]PeerHealth{"node1": up, "node2": up, "node3": down}, clusterDegraded},
+		{map[string]PeerHealth{"node1": up, "node2": down, "node3": down}, clusterUnhealthy},
+		{map[string]PeerHealth{"node1": up, "node2": down}, clusterUnhealthy},
+	} {
+		if got := aggregateHealth("node1", tc.nodes).Status; got != tc.want {
+			t.Errorf("expected %s for %v, got %s", tc.want, tc.nodes, got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// reservar llama a /reservar en s con el cuerpo indicado
+func reservar(s *Server, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.handleReservarAsiento(rec, httptest.NewRequest(http.MethodPost, "/reservar", strings.NewReader(body)))
+	return rec
+}
+
+// Una reserva delegada deja en la auditoría el servidor que la ejecuta y el
+// que la originó
+func TestDelegatedReservationAuditsBothServers(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		c := NewSimCluster("server2", "server1")
+		s := NewServer(c.Node("server2"), mt.Coll, NewAuditLog(mt.Coll), "server2")
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.asientos", mtest.FirstBatch,
+				bson.D{{Key: "numero", Value: 4}, {Key: "disponible", Value: true}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		rec := reservar(s, `{"numero":4,"cliente":"ana","on_behalf_of":"server1"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if resp["server_id"] != "server2" || resp["on_behalf_of"] != "server1" {
+			t.Fatalf("expected the response to name both servers, got %v", resp)
+		}
+
+		var audit bson.Raw
+		for _, ev := range mt.GetAllStartedEvents() {
+			if ev.CommandName == "insert" {
+				audit = ev.Command.Lookup("documents", "0").Document()
+			}
+		}
+		if audit == nil {
+			t.Fatal("no audit entry was written")
+		}
+		if audit.Lookup("node_id").StringValue() != "server2" || audit.Lookup("on_behalf_of").StringValue() != "server1" {
+			t.Fatalf("expected executor server2 on behalf of server1, got %v", audit)
+		}
+		if audit.Lookup("operacion").StringValue() != OpReservar || audit.Lookup("cliente").StringValue() != "ana" {
+			t.Fatalf("unexpected audit entry %v", audit)
+		}
+	})
+}
+
+// Solo un peer conocido puede delegar: el resto se rechaza antes de pedir
+// la CS o tocar la base de datos
+func TestDelegationOnlyFromKnownPeers(t *testing.T) {
+	c := NewSimCluster("server2", "server1")
+	// Sin colección: si el handler llegara a la BD entraría en pánico
+	s := &Server{node: c.Node("server2"), serverID: "server2"}
+
+	for _, origin := range []string{"server9", "server2"} {
+		rec := reservar(s, `{"numero":4,"cliente":"ana","on_behalf_of":"`+origin+`"}`)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 for a reservation on behalf of %s, got %d", origin, rec.Code)
+		}
+		var body httperr.Response
+		json.NewDecoder(rec.Body).Decode(&body)
+		if body.Error.Code != CodeForbidden {
+			t.Fatalf("expected code %s, got %q", CodeForbidden, body.Error.Code)
+		}
+	}
+	if sent := c.Node("server2").MessageStats().TotalSent; sent != 0 {
+		t.Fatalf("expected no CS request, sent %d messages", sent)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// initLeaseID es el documento de lease que decide quién inicializa los asientos
+const initLeaseID = "seat-initializer"
+
+// Lease es un documento de exclusión con caducidad almacenado en MongoDB
+type Lease struct {
+	ID        string    `bson:"_id" json:"id"`
+	Holder    string    `bson:"holder" json:"holder"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// leaseCollection son las operaciones de MongoDB con que se toma un lease;
+// *mongo.Collection la cumple
+type leaseCollection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+}
+
+// acquireLease intenta obtener el lease leaseID para nodeID durante ttl.
+// Gana el primer nodo que inserta el documento; si el lease existente ha
+// caducado, el primero que lo reescribe se convierte en el nuevo titular.
+// MongoDB garantiza la atomicidad de ambas operaciones sobre _id, por lo que
+// dos nodos que arrancan a la vez nunca ganan los dos.
+func acquireLease(ctx context.Context, leases leaseCollection, leaseID, nodeID string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	lease := Lease{
+		ID:        leaseID,
+		Holder:    nodeID,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	_, err := leases.InsertOne(ctx, lease)
+	if err == nil {
+		return true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	// El lease ya existe: solo se puede tomar si ha caducado
+	filter := bson.M{"_id": leaseID, "expires_at": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"holder": nodeID, "expires_at": lease.ExpiresAt}}
+	res, err := leases.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// runInitializerElection compite por el lease de inicialización y, si lo gana,
+// crea los asientos con initialize. Los nodos que pierden no hacen nada:
+// ensureSeats lo vuelve a intentar mientras no existan los asientos.
+func runInitializerElection(leases leaseCollection, nodeID string, ttl time.Duration, initialize func()) {
+	won, err := acquireLease(context.Background(), leases, initLeaseID, nodeID, ttl)
+	if err != nil {
+		log.Printf("[%s] Initializer election failed: %v", nodeID, err)
+		return
+	}
+	if !won {
+		log.Printf("[%s] Another node holds the initializer lease, skipping seat initialization", nodeID)
+		return
+	}
+
+	log.Printf("[%s] Won initializer lease, initializing seats", nodeID)
+	initialize()
+}
+
+// ensureSeatIndex crea un índice único sobre el número de asiento para que una
+// inicialización duplicada falle en lugar de crear asientos repetidos
+func ensureSeatIndex(collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "numero", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeLeases es la colección de leases en memoria, con las mismas garantías
+// que MongoDB sobre _id: una inserción repetida falla con clave duplicada y
+// cada actualización es atómica
+type fakeLeases struct {
+	mu     sync.Mutex
+	leases map[string]Lease
+}
+
+func newFakeLeases() *fakeLeases {
+	return &fakeLeases{leases: make(map[string]Lease)}
+}
+
+func (f *fakeLeases) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	lease := document.(Lease)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.leases[lease.ID]; exists {
+		return nil, mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "duplicate key"}}}
+	}
+	f.leases[lease.ID] = lease
+	return &mongo.InsertOneResult{InsertedID: lease.ID}, nil
+}
+
+func (f *fakeLeases) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	id := filter.(bson.M)["_id"].(string)
+	before := filter.(bson.M)["expires_at"].(bson.M)["$lt"].(time.Time)
+	set := update.(bson.M)["$set"].(bson.M)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lease, exists := f.leases[id]
+	if !exists || !lease.ExpiresAt.Before(before) {
+		return &mongo.UpdateResult{}, nil
+	}
+	lease.Holder = set["holder"].(string)
+	lease.ExpiresAt = set["expires_at"].(time.Time)
+	f.leases[id] = lease
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func TestConcurrentBootInitializesSeatsOnce(t *testing.T) {
+	for round := 0; round < 50; round++ {
+		leases := newFakeLeases()
+		var initialized int32
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for _, id := range []string{"server1", "server2"} {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				<-start
+				runInitializerElection(leases, id, time.Minute, func() {
+					atomic.AddInt32(&initialized, 1)
+				})
+			}(id)
+		}
+		close(start)
+		wg.Wait()
+
+		if n := atomic.LoadInt32(&initialized); n != 1 {
+			t.Fatalf("round %d: seats initialized %d times", round, n)
+		}
+		if holder := leases.leases[initLeaseID].Holder; holder != "server1" && holder != "server2" {
+			t.Fatalf("round %d: unexpected lease holder %q", round, holder)
+		}
+	}
+}
+
+func TestExpiredLeaseIsTakenOver(t *testing.T) {
+	leases := newFakeLeases()
+	ctx := context.Background()
+
+	if won, err := acquireLease(ctx, leases, initLeaseID, "server1", time.Minute); err != nil || !won {
+		t.Fatalf("first node did not win the free lease: %v, %v", won, err)
+	}
+	if won, _ := acquireLease(ctx, leases, initLeaseID, "server2", time.Minute); won {
+		t.Fatal("second node won a lease that is still held")
+	}
+
+	// server1 cayó sin inicializar y su lease caducó
+	leases.leases[initLeaseID] = Lease{ID: initLeaseID, Holder: "server1", ExpiresAt: time.Now().Add(-time.Second)}
+	if won, err := acquireLease(ctx, leases, initLeaseID, "server2", time.Minute); err != nil || !won {
+		t.Fatalf("expired lease was not taken over: %v, %v", won, err)
+	}
+	if holder := leases.leases[initLeaseID].Holder; holder != "server2" {
+		t.Fatalf("expected server2 to hold the lease, got %q", holder)
+	}
+}
+
+func TestAcquireLeaseOnMongoDuplicateKey(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		// Otro nodo insertó el lease y aún no ha caducado
+		mt.AddMockResponses(
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+		)
+		won, err := acquireLease(context.Background(), mt.Coll, initLeaseID, "server2", time.Minute)
+		if err != nil || won {
+			t.Fatalf("expected to lose the election without error, got %v, %v", won, err)
+		}
+
+		mt.GetStartedEvent() // insert
+		update := mt.GetStartedEvent()
+		if update == nil || update.CommandName != "update" {
+			t.Fatalf("expected an update of the expired lease, got %+v", update)
+		}
+		filter := update.Command.Lookup("updates", "0", "q").Document()
+		if filter.Lookup("_id").StringValue() != initLeaseID {
+			t.Fatalf("unexpected lease filter %v", filter)
+		}
+		if _, err := filter.LookupErr("expires_at", "$lt"); err != nil {
+			t.Fatalf("lease taken over without checking it expired: %v", filter)
+		}
+	})
+}
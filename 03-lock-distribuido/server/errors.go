@@ -0,0 +1,28 @@
+package main
+
+// Códigos de error propios del servidor; los comunes están en shared/httperr
+const (
+	CodeForbidden      = "FORBIDDEN"
+	CodeNotReady       = "NOT_READY"
+	CodeOverloaded     = "OVERLOADED"
+	CodeNodePaused     = "NODE_PAUSED"
+	CodeRequestTimeout = "REQUEST_TIMEOUT"
+
+	CodeSeatNotFound    = "SEAT_NOT_FOUND"
+	CodeSeatTaken       = "SEAT_TAKEN"
+	CodeSeatAlreadyFree = "SEAT_ALREADY_FREE"
+	CodeCSTimeout       = "CS_TIMEOUT"
+	CodeDatabaseError   = "DATABASE_ERROR"
+	CodeBatchAborted    = "BATCH_ABORTED"
+	CodeLoadTestRunning = "LOADTEST_RUNNING"
+
+	// Membresía
+	CodeReconfigUnsupported = "RECONFIGURATION_UNSUPPORTED"
+
+	// Tráfico entre nodos
+	CodeInvalidMessage   = "INVALID_MESSAGE"
+	CodeInvalidSignature = "INVALID_SIGNATURE"
+	CodeFaultInjected    = "FAULT_INJECTED"
+	CodeUnknownPeer      = "UNKNOWN_PEER"
+	CodeFaultsDisabled   = "FAULT_INJECTION_DISABLED"
+)
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tipos de evento del protocolo que publica /internal/events
+const (
+	eventMessage       = "message"        // mensaje enviado o recibido
+	eventState         = "state"          // cambio Released/Wanted/Held
+	eventDeferredAdd   = "deferred_add"   // REPLY pospuesto
+	eventDeferredFlush = "deferred_flush" // REPLY pospuestos enviados al salir
+	eventCSEnter       = "cs_enter"
+	eventCSExit        = "cs_exit"
+	eventSplitBrain    = "split_brain" // otro nodo anuncia que tiene la CS a la vez
+)
+
+// ProtocolEvent es un evento del algoritmo para animar el protocolo en el
+// frontend: flechas REQUEST/REPLY entre nodos y estados de cada nodo
+type ProtocolEvent struct {
+	Kind   string    `json:"kind"`
+	NodeID string    `json:"node_id"`
+	Time   time.Time `json:"time"`
+	// Reloj de Lamport del nodo al producirse el evento
+	Clock int64 `json:"clock"`
+
+	// Solo en eventos message
+	Direction string `json:"direction,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"` // el que lleva el mensaje
+	Outcome   string `json:"outcome,omitempty"`
+
+	// Peer del mensaje o del REPLY pospuesto; peers de un deferred_flush
+	Peer  string   `json:"peer,omitempty"`
+	Peers []string `json:"peers,omitempty"`
+
+	// Solo en eventos state
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// eventBuffer es la cola de cada conexión; si el cliente no la vacía a
+// tiempo sus eventos se descartan en lugar de frenar al algoritmo
+const eventBuffer = 256
+
+// EventHub reparte los eventos del protocolo a las conexiones WebSocket.
+// Publish nunca bloquea: toma un mutex propio y entrega sin esperar.
+type EventHub struct {
+	mu   sync.Mutex
+	subs map[*eventSub]struct{}
+}
+
+// eventSub es una conexión suscrita al hub
+type eventSub struct {
+	events  chan ProtocolEvent
+	dropped uint64
+}
+
+func newEventHub() *EventHub {
+	return &EventHub{subs: make(map[*eventSub]struct{})}
+}
+
+// Publish entrega el evento a cada suscriptor con hueco en su cola
+func (h *EventHub) Publish(ev ProtocolEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// HasSubscribers indica si alguien escucha, para no construir eventos en vano
+func (h *EventHub) HasSubscribers() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs) > 0
+}
+
+func (h *EventHub) subscribe() *eventSub {
+	sub := &eventSub{events: make(chan ProtocolEvent, eventBuffer)}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// unsubscribe retira la conexión y devuelve cuántos eventos se le descartaron
+func (h *EventHub) unsubscribe(sub *eventSub) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+	return sub.dropped
+}
+
+// publishEvent completa el evento con el nodo, la hora y el reloj de Lamport
+// y lo publica
+func (n *Node) publishEvent(ev ProtocolEvent) {
+	if !n.events.HasSubscribers() {
+		return
+	}
+	ev.NodeID = n.ID
+	ev.Time = time.Now()
+	ev.Clock = n.Clock.GetTime()
+	n.events.Publish(ev)
+}
+
+// setState cambia el estado del nodo y publica la transición.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) setState(state NodeState) {
+	if n.State == state {
+		return
+	}
+	from := n.State
+	n.State = state
+	n.markStateChanged()
+	n.publishEvent(ProtocolEvent{Kind: eventState, From: from.String(), To: state.String()})
+}
+
+// Intervalos del WebSocket de /internal/events
+const (
+	eventsWriteTimeout = 5 * time.Second
+	eventsPingInterval = 30 * time.Second
+)
+
+var eventsUpgrader = websocket.Upgrader{
+	// El frontend se sirve desde otro origen
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleEvents abre un WebSocket que emite en JSON cada evento del protocolo
+// de este nodo
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := eventsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade ya respondió con el error
+		log.Printf("[%s] Failed to open events WebSocket: %v", s.serverID, err)
+		return
+	}
+	defer conn.Close()
+
+	sub := s.node.events.subscribe()
+	log.Printf("[%s] Events WebSocket opened from %s", s.serverID, r.RemoteAddr)
+	defer func() {
+		dropped := s.node.events.unsubscribe(sub)
+		log.Printf("[%s] Events WebSocket from %s closed (%d events dropped)", s.serverID, r.RemoteAddr, dropped)
+	}()
+
+	// El cliente no envía nada, pero hay que leer para enterarse del cierre
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(eventsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case ev := <-sub.events:
+			conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// describe resume un evento del protocolo en una línea para compararlo
+func describe(ev ProtocolEvent) string {
+	switch ev.Kind {
+	case eventState:
+		return fmt.Sprintf("state %s->%s", ev.From, ev.To)
+	case eventMessage:
+		return fmt.Sprintf("%s %s %s %s", ev.Direction, ev.Type, ev.Peer, ev.Outcome)
+	case eventDeferredAdd:
+		return "deferred_add " + ev.Peer
+	case eventDeferredFlush:
+		return "deferred_flush " + strings.Join(ev.Peers, ",")
+	}
+	return ev.Kind
+}
+
+// Por el WebSocket de node1 llega todo lo que el frontend necesita para
+// dibujar una reserva con contienda: estados, entrada y salida de la CS y
+// el REQUEST de node2 pospuesto hasta la salida
+func TestEventsWebSocketStreamsAContendedEntry(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node1, node2 := c.Node("node1"), c.Node("node2")
+	node1.HeldAnnounceInterval = 0
+	node2.HeldAnnounceInterval = 0
+	s := &Server{node: node1, serverID: "node1"}
+	ts := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for !node1.events.HasSubscribers() {
+		if time.Now().After(deadline) {
+			t.Fatal("the WebSocket never subscribed to node1's events")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	entered := make(chan error, 1)
+	go func() { entered <- c.Enter("node2", 2*time.Second) }()
+	waitWanted(t, node2)
+	deadline = time.Now().Add(time.Second)
+	for len(node1.DebugState().DeferredReplies) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("node1 never deferred node2's request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Exit("node1")
+	if err := <-entered; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node2")
+
+	want := []string{
+		"state Released->Wanted",
+		"state Wanted->Held",
+		eventCSEnter,
+		"received REQUEST node2 deferred",
+		"deferred_add node2",
+		eventCSExit,
+		"state Held->Released",
+		"deferred_flush node2",
+		"sent REPLY node2 delivered",
+	}
+	var got []string
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < len(want); {
+		var ev ProtocolEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			t.Fatalf("expected %v in order, got %v before %v", want, got, err)
+		}
+		if ev.NodeID != "node1" || ev.Time.IsZero() {
+			t.Fatalf("event without node or time: %+v", ev)
+		}
+		// Al recibir un mensaje el reloj del nodo ya supera su timestamp
+		if ev.Kind == eventMessage && ev.Direction == traceReceived && ev.Clock <= ev.Timestamp {
+			t.Fatalf("received %s at clock %d, not after its timestamp %d", ev.Type, ev.Clock, ev.Timestamp)
+		}
+		got = append(got, describe(ev))
+		if describe(ev) == want[i] {
+			i++
+		}
+	}
+}
+
+// Un cliente que no lee no frena al algoritmo: Publish descarta lo que no
+// cabe en su cola y lo cuenta
+func TestEventHubNeverBlocksOnASlowSubscriber(t *testing.T) {
+	hub := newEventHub()
+	if hub.HasSubscribers() {
+		t.Fatal("expected a new hub to have no subscribers")
+	}
+	sub := hub.subscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < eventBuffer+10; i++ {
+			hub.Publish(ProtocolEvent{Kind: eventMessage})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a subscriber that does not read")
+	}
+	if queued := len(sub.events); queued != eventBuffer {
+		t.Fatalf("expected %d queued events, got %d", eventBuffer, queued)
+	}
+	if dropped := hub.unsubscribe(sub); dropped != 10 {
+		t.Fatalf("expected 10 dropped events, got %d", dropped)
+	}
+	if hub.HasSubscribers() {
+		t.Fatal("expected no subscribers after unsubscribing")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FailureDetector vigila la vitalidad de los peers mediante pings periódicos
+// a su endpoint /health. Un peer se declara sospechoso tras Threshold fallos
+// consecutivos sin saber nada de él durante al menos DeadAfter, y vuelve a
+// considerarse vivo en cuanto responde de nuevo.
+//
+// Un peer sospechoso deja de contar para la CS: las peticiones nuevas no le
+// piden REPLY y la que está esperando deja de esperar el suyo. Es lo que
+// evita que un nodo se quede colgado de un peer caído, pero si el peer solo
+// iba lento puede romper la exclusión mutua: el nodo entra sin su permiso y
+// el peer, que no se ha enterado, también entra cuando reúne los suyos. Por
+// eso no basta con Threshold fallos (un envío que vence su SendTimeout cuenta
+// como uno): además el peer tiene que llevar DeadAfter en silencio, sin
+// responder a ningún ping ni mandar ningún mensaje. Cuanto mayor es DeadAfter
+// menor es el riesgo y más tarda la CS en desbloquearse tras una caída real.
+// Si aun así ocurre, los anuncios HELD lo detectan como split-brain.
+type FailureDetector struct {
+	node      *Node
+	Interval  time.Duration
+	Threshold int
+	// Silencio mínimo del peer antes de declararlo sospechoso
+	DeadAfter time.Duration
+
+	missed   map[string]int
+	suspects map[string]bool
+	// Último ping correcto a cada peer y su tiempo de ida y vuelta
+	lastSeen map[string]time.Time
+	rtt      map[string]time.Duration
+	// Último ping o mensaje correcto de cada peer; started cuenta como tal
+	// para los que aún no han respondido nunca
+	lastContact map[string]time.Time
+	started     time.Time
+	mu          sync.Mutex
+
+	client *http.Client
+}
+
+// NewFailureDetector crea un detector de fallos para los peers del nodo
+func NewFailureDetector(node *Node, interval time.Duration, threshold int) *FailureDetector {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &FailureDetector{
+		node:      node,
+		Interval:  interval,
+		Threshold: threshold,
+		missed:    make(map[string]int),
+		suspects:  make(map[string]bool),
+		lastSeen:  make(map[string]time.Time),
+		rtt:       make(map[string]time.Duration),
+		client:    &http.Client{Timeout: interval},
+
+		lastContact: make(map[string]time.Time),
+		started:     time.Now(),
+	}
+}
+
+// Run hace ping a todos los peers en cada intervalo o, con gossip, consulta
+// en la tabla de miembros si han dado noticias. Bloquea para siempre.
+func (fd *FailureDetector) Run() {
+	ticker := time.NewTicker(fd.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, peer := range fd.node.PeerList() {
+			if fd.node.gossip != nil {
+				fd.checkGossip(peer)
+				continue
+			}
+			go fd.ping(peer)
+		}
+	}
+}
+
+// checkGossip cuenta como un ping correcto que el Heartbeat del peer haya
+// avanzado en la tabla de gossip hace menos de SuspectAfter, y como un fallo
+// lo contrario. El peer puede haber llegado por otro nodo: con gossip un peer
+// vivo no necesita contestarnos directamente.
+func (fd *FailureDetector) checkGossip(peerID string) {
+	if fd.node.faults.partitioned(peerID) {
+		fd.RecordFailure(peerID)
+		return
+	}
+	last, fresh := fd.node.gossip.Heard(peerID)
+	if !fresh {
+		fd.RecordFailure(peerID)
+		return
+	}
+	fd.mu.Lock()
+	fd.lastSeen[peerID] = last
+	fd.mu.Unlock()
+	fd.RecordSuccess(peerID)
+}
+
+// ping comprueba si un peer responde a /health
+func (fd *FailureDetector) ping(peerID string) {
+	// Una partición inyectada también corta los pings
+	if fd.node.faults.partitioned(peerID) {
+		fd.RecordFailure(peerID)
+		return
+	}
+
+	base, err := fd.node.peerBaseURL(peerID)
+	if err != nil {
+		fd.RecordFailure(peerID)
+		return
+	}
+
+	start := time.Now()
+	resp, err := fd.client.Get(base + "/health")
+	if err != nil {
+		fd.RecordFailure(peerID)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		fd.recordHeartbeat(peerID, time.Since(start))
+		fd.RecordSuccess(peerID)
+	} else {
+		fd.RecordFailure(peerID)
+	}
+}
+
+// recordHeartbeat anota un ping correcto y su tiempo de ida y vuelta
+func (fd *FailureDetector) recordHeartbeat(peerID string, rtt time.Duration) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.lastSeen[peerID] = time.Now()
+	fd.rtt[peerID] = rtt
+}
+
+// RecordSuccess anota que el peer respondió. Si estaba marcado como
+// sospechoso, vuelve a incluirse en el protocolo.
+func (fd *FailureDetector) RecordSuccess(peerID string) {
+	fd.mu.Lock()
+	fd.missed[peerID] = 0
+	fd.lastContact[peerID] = time.Now()
+	wasSuspect := fd.suspects[peerID]
+	delete(fd.suspects, peerID)
+	fd.mu.Unlock()
+
+	if wasSuspect {
+		log.Printf("[%s] Peer %s is alive again, re-including it", fd.node.ID, peerID)
+		fd.node.peerRecovered(peerID)
+	}
+}
+
+// RecordFailure anota un fallo de comunicación con el peer. Al alcanzar el
+// umbral, si además lleva DeadAfter en silencio, el peer pasa a ser
+// sospechoso y deja de bloquear la CS.
+func (fd *FailureDetector) RecordFailure(peerID string) {
+	fd.mu.Lock()
+	fd.missed[peerID]++
+	last, ok := fd.lastContact[peerID]
+	if !ok {
+		last = fd.started
+	}
+	silence := time.Since(last)
+	missed := fd.missed[peerID]
+	becameSuspect := !fd.suspects[peerID] && missed >= fd.Threshold && silence >= fd.DeadAfter
+	if becameSuspect {
+		fd.suspects[peerID] = true
+	}
+	fd.mu.Unlock()
+
+	if !becameSuspect && missed == fd.Threshold && silence < fd.DeadAfter {
+		log.Printf("[%s] Peer %s missed %d responses but was heard from %s ago; waiting for %s of silence before declaring it SUSPECT",
+			fd.node.ID, peerID, missed, silence.Round(time.Millisecond), fd.DeadAfter)
+	}
+	if becameSuspect {
+		log.Printf("[%s] Peer %s declared SUSPECT after %d missed responses", fd.node.ID, peerID, missed)
+		fd.node.peerSuspected(peerID)
+	}
+}
+
+// IsSuspect indica si el peer está actualmente marcado como sospechoso
+func (fd *FailureDetector) IsSuspect(peerID string) bool {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return fd.suspects[peerID]
+}
+
+// PeerHeartbeat es el estado de los pings a un peer: "down" si el detector
+// lo considera sospechoso y "up" en otro caso
+type PeerHeartbeat struct {
+	Status   string     `json:"status"`
+	LastSeen *time.Time `json:"last_seen"` // nil si nunca ha respondido
+	AgoMs    int64      `json:"ago_ms,omitempty"`
+	RTTMs    float64    `json:"rtt_ms"`
+	Missed   int        `json:"missed"`
+}
+
+// Heartbeats devuelve el estado de los pings a cada peer actual
+func (fd *FailureDetector) Heartbeats() map[string]PeerHeartbeat {
+	peers := fd.node.PeerList()
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	now := time.Now()
+	heartbeats := make(map[string]PeerHeartbeat, len(peers))
+	for _, peer := range peers {
+		hb := PeerHeartbeat{
+			Status: "up",
+			RTTMs:  float64(fd.rtt[peer].Microseconds()) / 1000,
+			Missed: fd.missed[peer],
+		}
+		if fd.suspects[peer] {
+			hb.Status = "down"
+		}
+		if last, ok := fd.lastSeen[peer]; ok {
+			hb.LastSeen = &last
+			hb.AgoMs = now.Sub(last).Milliseconds()
+		}
+		heartbeats[peer] = hb
+	}
+	return heartbeats
+}
+
+// Suspects devuelve la lista ordenada de peers sospechosos
+func (fd *FailureDetector) Suspects() []string {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	suspects := []string{}
+	for peer := range fd.suspects {
+		suspects = append(suspects, peer)
+	}
+	sort.Strings(suspects)
+	return suspects
+}
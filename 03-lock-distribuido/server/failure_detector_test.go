@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForState espera a que el nodo llegue al estado indicado
+func waitForState(t *testing.T, n *Node, state NodeState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if n.CSStatus().State == state.String() {
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	t.Fatalf("%s never reached %s", n.ID, state)
+}
+
+// declareDead hace que el detector dé por caído al peer
+func declareDead(t *testing.T, fd *FailureDetector, peerID string) {
+	t.Helper()
+	for i := 0; i < fd.Threshold && !fd.IsSuspect(peerID); i++ {
+		fd.RecordFailure(peerID)
+	}
+	if !fd.IsSuspect(peerID) {
+		t.Fatalf("%s was not declared suspect after %d failures", peerID, fd.Threshold)
+	}
+}
+
+// needsReplyFrom indica si la petición en curso espera el REPLY del peer
+func needsReplyFrom(n *Node, peerID string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.RepliesNeeded[peerID]
+}
+
+func TestFailureDetectorThreshold(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	fd := NewFailureDetector(c.Node("node1"), time.Second, 3)
+
+	fd.RecordFailure("node3")
+	fd.RecordFailure("node3")
+	if fd.IsSuspect("node3") {
+		t.Fatal("node3 declared suspect before reaching the threshold")
+	}
+	fd.RecordFailure("node3")
+	if !fd.IsSuspect("node3") {
+		t.Fatal("node3 not declared suspect at the threshold")
+	}
+	if hb := fd.Heartbeats()["node3"]; hb.Status != "down" || hb.Missed != 3 {
+		t.Fatalf("unexpected heartbeat for node3: %+v", hb)
+	}
+
+	fd.RecordSuccess("node3")
+	if fd.IsSuspect("node3") || len(fd.Suspects()) != 0 {
+		t.Fatalf("node3 still suspect after answering: %v", fd.Suspects())
+	}
+	if hb := fd.Heartbeats()["node3"]; hb.Status != "up" || hb.Missed != 0 {
+		t.Fatalf("unexpected heartbeat for node3 after recovering: %+v", hb)
+	}
+}
+
+func TestPeerDyingMidRequestStopsBlockingTheCS(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1 := c.Node("node1")
+	fd := NewFailureDetector(node1, time.Second, 3)
+	node1.detector = fd
+
+	c.Network.Detach("node3")
+	done := make(chan error, 1)
+	go func() { done <- c.Enter("node1", 3*time.Second) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("node1 entered without node3's reply (err: %v)", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	declareDead(t, fd, "node3")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+
+	// /health publica la lista de sospechosos
+	rec := httptest.NewRecorder()
+	(&Server{node: node1, serverID: "node1"}).handleHealthCheck(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var health struct {
+		Suspects []string `json:"suspects"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if len(health.Suspects) != 1 || health.Suspects[0] != "node3" {
+		t.Fatalf("expected node3 in the suspect list, got %v", health.Suspects)
+	}
+
+	// Las peticiones nuevas ya no esperan al peer caído
+	start := time.Now()
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Fatalf("node1 took %s to enter with node3 already dead", waited)
+	}
+}
+
+func TestPeerFlappingBackAsItIsExcluded(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1 := c.Node("node1")
+	fd := NewFailureDetector(node1, time.Second, 3)
+	node1.detector = fd
+
+	// node3 volverá lento: su REPLY tardará slow en llegar
+	const slow = 150 * time.Millisecond
+	var node3Slow int32
+	c.Network.Delay = func(from, to string, msg Message) time.Duration {
+		if to == "node3" && atomic.LoadInt32(&node3Slow) == 1 {
+			return slow
+		}
+		return 0
+	}
+
+	// node2 tiene la CS, así que node1 sigue esperando tras excluir a node3
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Network.Detach("node3")
+	done := make(chan error, 1)
+	go func() { done <- c.Enter("node1", 3*time.Second) }()
+	waitForState(t, node1, Wanted)
+	// Dejar que se agoten los reintentos del REQUEST a node3
+	time.Sleep(50 * time.Millisecond)
+
+	declareDead(t, fd, "node3")
+	if needsReplyFrom(node1, "node3") {
+		t.Fatal("node1 still waits for the suspect node3")
+	}
+
+	// node3 vuelve justo después de ser excluido
+	atomic.StoreInt32(&node3Slow, 1)
+	c.Network.Attach(c.Node("node3"))
+	recovered := time.Now()
+	fd.RecordSuccess("node3")
+	if !needsReplyFrom(node1, "node3") {
+		t.Fatal("recovered node3 was not re-included in the request in flight")
+	}
+
+	c.Exit("node2")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	defer c.Exit("node1")
+	if waited := time.Since(recovered); waited < slow*3/4 {
+		t.Fatalf("node1 entered %s after node3 recovered, without waiting for its reply", waited)
+	}
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("%d mutual exclusion violations", v)
+	}
+}
+
+// flappingPeer es un /health que responde 200 o 503 según up
+func flappingPeer(t *testing.T, up *int32) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(up) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// Los heartbeats siguen a un peer que cae y vuelve: se marca "down" al
+// alcanzar el umbral, conserva su última respuesta y vuelve a "up" con la
+// siguiente
+func TestHeartbeatsFollowAFlappingPeer(t *testing.T) {
+	up := int32(1)
+	node := NewNode("node1", []string{"node2"}, map[string]string{"node2": flappingPeer(t, &up)})
+	fd := NewFailureDetector(node, time.Second, 2)
+	node.detector = fd
+
+	fd.ping("node2")
+	first := fd.Heartbeats()["node2"]
+	if first.Status != "up" || first.LastSeen == nil || first.RTTMs <= 0 {
+		t.Fatalf("expected node2 up with its rtt, got %+v", first)
+	}
+
+	atomic.StoreInt32(&up, 0)
+	fd.ping("node2")
+	if hb := fd.Heartbeats()["node2"]; hb.Status != "up" || hb.Missed != 1 {
+		t.Fatalf("expected node2 still up below the threshold, got %+v", hb)
+	}
+	fd.ping("node2")
+	down := fd.Heartbeats()["node2"]
+	if down.Status != "down" || down.Missed != 2 || !down.LastSeen.Equal(*first.LastSeen) {
+		t.Fatalf("expected node2 down keeping its last success, got %+v", down)
+	}
+
+	atomic.StoreInt32(&up, 1)
+	fd.ping("node2")
+	back := fd.Heartbeats()["node2"]
+	if back.Status != "up" || back.Missed != 0 || !back.LastSeen.After(*first.LastSeen) {
+		t.Fatalf("expected node2 back up with a newer last success, got %+v", back)
+	}
+	if len(fd.Suspects()) != 0 {
+		t.Fatalf("expected no suspects once node2 answers again, got %v", fd.Suspects())
+	}
+}
+
+// /health publica los heartbeats de cada peer junto al estado de la CS
+func TestHealthReportsHeartbeatsAndCSState(t *testing.T) {
+	up := int32(1)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	node := NewNode("node1", []string{"node2", "node3"}, map[string]string{
+		"node2": flappingPeer(t, &up),
+		"node3": down.URL,
+	})
+	fd := NewFailureDetector(node, time.Second, 1)
+	node.detector = fd
+	fd.ping("node2")
+	fd.ping("node3")
+
+	rec := httptest.NewRecorder()
+	(&Server{node: node, serverID: "node1"}).handleHealthCheck(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var health struct {
+		Heartbeats map[string]PeerHeartbeat `json:"heartbeats"`
+		CS         CSStatus                 `json:"cs"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if hb := health.Heartbeats["node2"]; hb.Status != "up" || hb.LastSeen == nil {
+		t.Fatalf("expected node2 up in /health, got %+v", hb)
+	}
+	if hb := health.Heartbeats["node3"]; hb.Status != "down" || hb.LastSeen != nil || hb.Missed != 1 {
+		t.Fatalf("expected node3 down and never seen in /health, got %+v", hb)
+	}
+	if health.CS.State != Released.String() {
+		t.Fatalf("expected the CS state in /health, got %+v", health.CS)
+	}
+}
+
+// El detector hace ping por sí solo a cada intervalo
+func TestFailureDetectorPingsOnItsInterval(t *testing.T) {
+	up := int32(0)
+	node := NewNode("node1", []string{"node2"}, map[string]string{"node2": flappingPeer(t, &up)})
+	fd := NewFailureDetector(node, 10*time.Millisecond, 3)
+	node.detector = fd
+	go fd.Run()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !fd.IsSuspect("node2") {
+		if time.Now().After(deadline) {
+			t.Fatalf("node2 was never declared down: %+v", fd.Heartbeats()["node2"])
+		}
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreInt32(&up, 1)
+	for fd.IsSuspect("node2") {
+		if time.Now().After(deadline) {
+			t.Fatalf("node2 never came back: %+v", fd.Heartbeats()["node2"])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Un peer cae mientras node1 espera su REPLY. El detector no lo da por caído
+// hasta que, además de los fallos del umbral, lleva DeadAfter en silencio;
+// entonces node1 deja de esperarlo y entra. Las peticiones siguientes ya no
+// le piden REPLY, hasta que vuelve a responder.
+func TestPeerDeathMidWait(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1 := c.Node("node1")
+	fd := NewFailureDetector(node1, 10*time.Millisecond, 3)
+	fd.DeadAfter = 300 * time.Millisecond
+	node1.detector = fd
+
+	// Un peer lento que sigue dando señales de vida no se da por caído
+	// aunque acumule fallos
+	fd.RecordSuccess("node2")
+	for i := 0; i < 10; i++ {
+		fd.RecordFailure("node2")
+	}
+	if fd.IsSuspect("node2") {
+		t.Fatalf("node2 was declared dead %s after answering", fd.DeadAfter)
+	}
+	fd.RecordSuccess("node2")
+	fd.RecordSuccess("node3")
+
+	// Lo que haría Run con node3 caído: un ping fallido por intervalo
+	var node3Down int32 = 1
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(fd.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if atomic.LoadInt32(&node3Down) == 1 {
+					fd.RecordFailure("node3")
+				}
+			}
+		}
+	}()
+	c.Network.Detach("node3")
+
+	start := time.Now()
+	if err := c.Enter("node1", 3*time.Second); err != nil {
+		t.Fatalf("node1 hung on the dead peer: %v", err)
+	}
+	waited := time.Since(start)
+	c.Exit("node1")
+	if !fd.IsSuspect("node3") {
+		t.Fatal("node1 entered without node3 being declared dead")
+	}
+	if waited < fd.DeadAfter*3/4 {
+		t.Fatalf("node1 stopped waiting for node3 after %s, before %s of silence", waited, fd.DeadAfter)
+	}
+
+	// Con node3 caído, la petición siguiente ni siquiera le pide REPLY
+	start = time.Now()
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waited = time.Since(start)
+	c.Exit("node1")
+	if waited > 100*time.Millisecond {
+		t.Fatalf("node1 took %s to enter with node3 already dead", waited)
+	}
+
+	// node3 vuelve: su REPLY vuelve a ser necesario, así que node1 no entra
+	// mientras node3 tenga la CS
+	c.Network.Attach(c.Node("node3"))
+	atomic.StoreInt32(&node3Down, 0)
+	fd.RecordSuccess("node3")
+	if err := c.Enter("node3", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Enter("node1", 2*time.Second) }()
+	select {
+	case err := <-done:
+		c.Exit("node3")
+		t.Fatalf("node1 entered while the recovered node3 held the CS (err: %v)", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	c.Exit("node3")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("%d mutual exclusion violations", v)
+	}
+}
+
+// Con node2 en la CS, las peticiones locales de node1 se acumulan y /health
+// lo refleja: profundidad, espera de la más antigua, estado y recurso. Al
+// despejarse la cola los números vuelven a 0.
+func TestHealthReportsQueueDepth(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1 := c.Node("node1")
+	s := &Server{node: node1, serverID: "node1"}
+	health := func() (CSQueueStatus, error) {
+		rec := httptest.NewRecorder()
+		s.handleHealthCheck(rec, httptest.NewRequest("GET", "/health", nil))
+		var body struct {
+			CSQueue CSQueueStatus `json:"cs_queue"`
+		}
+		err := json.NewDecoder(rec.Body).Decode(&body)
+		return body.CSQueue, err
+	}
+	waitFor := func(what string, ok func(CSQueueStatus) bool) (CSQueueStatus, error) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			status, err := health()
+			if err != nil {
+				return status, err
+			}
+			if ok(status) {
+				return status, nil
+			}
+			if time.Now().After(deadline) {
+				return status, fmt.Errorf("%s: /health reports %+v", what, status)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if status, err := health(); err != nil || status.Depth != 0 || status.State != Released.String() {
+		t.Fatalf("idle node reports %+v (err %v)", status, err)
+	}
+
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	const load = 4
+	granted := make(chan int, load)
+	release := make(chan struct{})
+	errs := make(chan error, load)
+	for i := 1; i <= load; i++ {
+		go func(i int) {
+			ctx, cancel := context.WithTimeout(WithCSResource(context.Background(), fmt.Sprintf("asiento %d", i)), 5*time.Second)
+			defer cancel()
+			if err := node1.RequestCSContext(ctx); err != nil {
+				errs <- err
+				return
+			}
+			granted <- i
+			<-release
+			node1.ReleaseCS()
+			errs <- nil
+		}(i)
+		// Entrar en la cola en orden, para saber qué recurso va primero
+		if _, err := waitFor("request queued", func(q CSQueueStatus) bool { return q.Depth == i }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := waitFor("full queue", func(q CSQueueStatus) bool {
+		return q.Depth == load && q.Queued == load-1 && q.State == Wanted.String()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Resource != "asiento 1" {
+		t.Fatalf("the request running the protocol should be for asiento 1, /health says %q", status.Resource)
+	}
+	before := status.OldestWaitMs
+	time.Sleep(50 * time.Millisecond)
+	if status, _ = health(); status.OldestWaitMs < before+40 {
+		t.Fatalf("the oldest wait did not grow: %d ms, then %d ms", before, status.OldestWaitMs)
+	}
+
+	c.Exit("node2")
+	if first := <-granted; first != 1 {
+		t.Fatalf("asiento %d entered first instead of asiento 1", first)
+	}
+	if _, err := waitFor("node1 holding the CS", func(q CSQueueStatus) bool {
+		return q.State == Held.String() && q.Resource == "asiento 1" && q.Depth == load-1 && q.Queued == load-1
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	for i := 0; i < load; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := waitFor("drained queue", func(q CSQueueStatus) bool {
+		return q.Depth == 0 && q.Queued == 0 && q.OldestWaitMs == 0 && q.State == Released.String() && q.Resource == ""
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
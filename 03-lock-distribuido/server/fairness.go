@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Métricas de equidad: Ricart-Agrawala promete que las peticiones se atienden
+// en orden de timestamp, así que en saturación todos los nodos deberían
+// entrar un número parecido de veces y nadie debería esperar mucho más que
+// la media. El clúster protege una única sección crítica, por lo que las
+// métricas son por nodo.
+//
+// Mientras un nodo espera cuenta los nodos que entran antes que él: el peer
+// que pospone nuestro REQUEST (está dentro o tiene prioridad), el peer a
+// cuyo REQUEST respondemos estando en Wanted (tiene prioridad) y, con la
+// cola de Lamport, el que envía RELEASE. En ambos algoritmos un peer no
+// puede adelantarnos dos veces en la misma espera, así que cada peer cuenta
+// una vez. La racha más larga de una sola espera delata la inanición.
+
+// defaultStarvationFactor es cuántas veces la espera media puede superar la
+// espera máxima de un nodo antes de marcarlo como posible inanición
+const defaultStarvationFactor = 5.0
+
+// FairnessTracker acumula las esperas de las entradas de un nodo desde la
+// última puesta a cero
+type FairnessTracker struct {
+	mu          sync.Mutex
+	since       time.Time
+	entries     uint64
+	waitSum     time.Duration
+	waitMax     time.Duration
+	bypassedSum uint64
+	bypassedMax int
+}
+
+func newFairnessTracker() *FairnessTracker {
+	return &FairnessTracker{since: time.Now()}
+}
+
+// record anota una entrada tras esperar wait, con bypassed peers que
+// entraron antes durante la espera
+func (f *FairnessTracker) record(wait time.Duration, bypassed int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries++
+	f.waitSum += wait
+	if wait > f.waitMax {
+		f.waitMax = wait
+	}
+	f.bypassedSum += uint64(bypassed)
+	if bypassed > f.bypassedMax {
+		f.bypassedMax = bypassed
+	}
+}
+
+// Reset pone los contadores a cero
+func (f *FairnessTracker) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f = FairnessTracker{since: time.Now()}
+}
+
+// FairnessReport son las métricas de equidad de un nodo
+type FairnessReport struct {
+	NodeID    string    `json:"node_id"`
+	Since     time.Time `json:"since,omitempty"`
+	Entries   uint64    `json:"entries"`
+	AvgWaitMs float64   `json:"avg_wait_ms"`
+	MaxWaitMs float64   `json:"max_wait_ms"`
+	// Peers que entraron antes mientras este esperaba: media y la racha más
+	// larga en una sola espera
+	AvgBypassed float64 `json:"avg_bypassed"`
+	MaxBypassed int     `json:"max_bypassed"`
+
+	// Solo en la vista del clúster
+	EntryShare float64 `json:"entry_share"`
+	Starving   bool    `json:"starving"`
+	Error      string  `json:"error,omitempty"` // el nodo no respondió
+}
+
+// Report devuelve las métricas del nodo
+func (f *FairnessTracker) Report(nodeID string) FairnessReport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	report := FairnessReport{
+		NodeID:      nodeID,
+		Since:       f.since,
+		Entries:     f.entries,
+		MaxWaitMs:   durationMs(f.waitMax),
+		MaxBypassed: f.bypassedMax,
+	}
+	if f.entries > 0 {
+		report.AvgWaitMs = durationMs(f.waitSum / time.Duration(f.entries))
+		report.AvgBypassed = float64(f.bypassedSum) / float64(f.entries)
+	}
+	return report
+}
+
+// noteBypass anota que el peer entra en la CS antes que nosotros mientras
+// esperamos. ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) noteBypass(peerID string) {
+	if n.State == Wanted {
+		n.bypassedBy[peerID] = true
+	}
+}
+
+// noteDeferredRequest anota que el peer pospuso el REQUEST de la ronda
+// indicada
+func (n *Node) noteDeferredRequest(peerID string, round int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if round == n.round {
+		n.noteBypass(peerID)
+	}
+}
+
+// ClusterFairness es la vista de equidad de todo el clúster que publica
+// /internal/fairness
+type ClusterFairness struct {
+	QueriedBy string           `json:"queried_by"`
+	Nodes     []FairnessReport `json:"nodes"`
+	Entries   uint64           `json:"entries"`
+	// Espera media de todas las entradas del clúster y el múltiplo de ella
+	// a partir del cual la espera máxima de un nodo se marca como inanición
+	MeanWaitMs       float64 `json:"mean_wait_ms"`
+	StarvationFactor float64 `json:"starvation_factor"`
+	// Índice de Jain sobre las entradas por nodo: 1 = perfectamente
+	// equitativo, 1/N = un solo nodo entra
+	JainIndex float64  `json:"jain_index"`
+	Starving  []string `json:"starving"`
+}
+
+// aggregateFairness calcula la vista del clúster a partir de los informes
+// de cada nodo
+func aggregateFairness(queriedBy string, reports []FairnessReport, factor float64) ClusterFairness {
+	sort.Slice(reports, func(i, j int) bool { return reports[i].NodeID < reports[j].NodeID })
+	view := ClusterFairness{
+		QueriedBy:        queriedBy,
+		Nodes:            reports,
+		StarvationFactor: factor,
+		Starving:         []string{},
+	}
+
+	var waitSum, sumSq float64
+	answered := 0
+	for _, r := range reports {
+		if r.Error != "" {
+			continue
+		}
+		answered++
+		view.Entries += r.Entries
+		waitSum += r.AvgWaitMs * float64(r.Entries)
+		sumSq += float64(r.Entries) * float64(r.Entries)
+	}
+	if view.Entries == 0 {
+		return view
+	}
+	view.MeanWaitMs = waitSum / float64(view.Entries)
+	view.JainIndex = float64(view.Entries) * float64(view.Entries) / (float64(answered) * sumSq)
+
+	for i := range view.Nodes {
+		r := &view.Nodes[i]
+		if r.Error != "" {
+			continue
+		}
+		r.EntryShare = float64(r.Entries) / float64(view.Entries)
+		if r.Entries > 0 && r.MaxWaitMs > factor*view.MeanWaitMs {
+			r.Starving = true
+			view.Starving = append(view.Starving, r.NodeID)
+		}
+	}
+	return view
+}
+
+// fetchFairness obtiene el informe de equidad de un peer
+func (n *Node) fetchFairness(client *http.Client, peerID string) FairnessReport {
+	failed := func(err error) FairnessReport {
+		return FairnessReport{NodeID: peerID, Error: err.Error()}
+	}
+
+	base, err := n.internalBaseURL(peerID)
+	if err != nil {
+		return failed(err)
+	}
+	resp, err := client.Get(base + "/internal/fairness?scope=local")
+	if err != nil {
+		return failed(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return failed(fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+
+	var report FairnessReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return failed(err)
+	}
+	report.NodeID = peerID
+	return report
+}
+
+// resetPeerFairness pide a un peer que ponga a cero sus métricas
+func (n *Node) resetPeerFairness(client *http.Client, peerID string) error {
+	base, err := n.internalBaseURL(peerID)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(base+"/internal/fairness?scope=local", "application/json", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// QueryClusterFairness reúne en paralelo los informes de todos los nodos
+func (n *Node) QueryClusterFairness(client *http.Client, factor float64) ClusterFairness {
+	peers := n.PeerList()
+	reports := make([]FairnessReport, len(peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			reports[i] = n.fetchFairness(client, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	return aggregateFairness(n.ID, append(reports, n.fairness.Report(n.ID)), factor)
+}
+
+// handleFairness publica las métricas de equidad (GET) o las pone a cero
+// (POST). Por defecto abarca todo el clúster; con ?scope=local solo este
+// nodo, que es como se consultan los nodos entre sí.
+func (s *Server) handleFairness(w http.ResponseWriter, r *http.Request) {
+	local := r.URL.Query().Get("scope") == "local"
+	client := &http.Client{Timeout: 2 * time.Second, Transport: s.node.client.Transport}
+
+	if r.Method == http.MethodPost {
+		s.node.fairness.Reset()
+		reset := []string{s.serverID}
+		failed := map[string]string{}
+		if !local {
+			for _, peer := range s.node.PeerList() {
+				if err := s.node.resetPeerFairness(client, peer); err != nil {
+					failed[peer] = err.Error()
+					continue
+				}
+				reset = append(reset, peer)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"reset":  reset,
+			"failed": failed,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if local {
+		json.NewEncoder(w).Encode(s.node.fairness.Report(s.serverID))
+		return
+	}
+	view := s.node.QueryClusterFairness(client, s.starvationFactor)
+	if len(view.Starving) > 0 {
+		s.node.logf("WARNING: possible starvation, max wait above %.1fx the mean (%.1f ms) on %v",
+			view.StarvationFactor, view.MeanWaitMs, view.Starving)
+	}
+	json.NewEncoder(w).Encode(view)
+}
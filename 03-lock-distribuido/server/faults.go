@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// ErrFaultInjected indica que una regla de /internal/faults ha descartado el
+// mensaje recibido; el emisor lo ve como un fallo transitorio y reintenta
+var ErrFaultInjected = errors.New("message dropped by fault injection")
+
+// Acciones de una regla de fallos
+const (
+	FaultDelay     = "delay"     // retrasa el mensaje delay_ms
+	FaultDrop      = "drop"      // descarta el mensaje con probabilidad probability
+	FaultPartition = "partition" // descarta todo el tráfico con el peer, en ambos sentidos
+)
+
+// Sentido del mensaje al que se aplica una regla
+const (
+	faultOutbound = "out"
+	faultInbound  = "in"
+	faultBoth     = "both"
+)
+
+// FaultRule es una regla de inyección de fallos. Los filtros vacíos
+// coinciden con todo: sin peer afecta a todos los peers y sin type a todos
+// los tipos de mensaje.
+type FaultRule struct {
+	ID          string     `json:"id"`
+	Action      string     `json:"action"`
+	Direction   string     `json:"direction"`
+	Peer        string     `json:"peer,omitempty"`
+	Type        string     `json:"type,omitempty"`
+	Probability float64    `json:"probability"`
+	DelayMs     int        `json:"delay_ms,omitempty"`
+	TTLSeconds  int        `json:"ttl_seconds,omitempty"` // 0: hasta que se borre
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// normalize completa los valores por defecto y valida la regla
+func (r *FaultRule) normalize() error {
+	r.Type = strings.ToUpper(r.Type)
+	if r.Direction == "" {
+		r.Direction = faultBoth
+	}
+	if r.Probability == 0 {
+		r.Probability = 1
+	}
+
+	switch r.Action {
+	case FaultDelay:
+		if r.DelayMs <= 0 {
+			return errors.New("delay_ms must be positive for a delay rule")
+		}
+	case FaultDrop:
+	case FaultPartition:
+		if r.Peer == "" {
+			return errors.New("peer is required for a partition rule")
+		}
+		// Una partición corta todo: sin filtros de tipo ni de sentido
+		r.Direction, r.Type, r.Probability = faultBoth, "", 1
+	default:
+		return fmt.Errorf("unknown action %q (expected delay, drop or partition)", r.Action)
+	}
+
+	if r.Direction != faultOutbound && r.Direction != faultInbound && r.Direction != faultBoth {
+		return fmt.Errorf("unknown direction %q (expected out, in or both)", r.Direction)
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return errors.New("probability must be between 0 and 1")
+	}
+	if r.TTLSeconds < 0 {
+		return errors.New("ttl_seconds cannot be negative")
+	}
+	return nil
+}
+
+func (r *FaultRule) matches(direction, peerID, msgType string) bool {
+	return (r.Direction == faultBoth || r.Direction == direction) &&
+		(r.Peer == "" || r.Peer == peerID) &&
+		(r.Type == "" || r.Type == msgType)
+}
+
+func (r *FaultRule) expired(now time.Time) bool {
+	return r.ExpiresAt != nil && now.After(*r.ExpiresAt)
+}
+
+// FaultInjector guarda las reglas activas. Tiene su propio mutex: evaluar
+// una regla nunca toca el mutex del nodo, y los retrasos se duermen fuera de
+// ambos.
+type FaultInjector struct {
+	mu     sync.Mutex
+	rules  []*FaultRule
+	nextID int
+	rng    *rand.Rand
+}
+
+func newFaultInjector() *FaultInjector {
+	return &FaultInjector{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Add activa una regla y la devuelve con su ID y caducidad
+func (f *FaultInjector) Add(rule FaultRule) (FaultRule, error) {
+	if err := rule.normalize(); err != nil {
+		return FaultRule{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	rule.ID = fmt.Sprintf("fault-%d", f.nextID)
+	if rule.TTLSeconds > 0 {
+		expires := time.Now().Add(time.Duration(rule.TTLSeconds) * time.Second)
+		rule.ExpiresAt = &expires
+	}
+	f.rules = append(f.rules, &rule)
+	return rule, nil
+}
+
+// Remove borra una regla; devuelve false si no existía
+func (f *FaultInjector) Remove(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, rule := range f.rules {
+		if rule.ID == id {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Clear borra todas las reglas y devuelve cuántas había
+func (f *FaultInjector) Clear() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.rules)
+	f.rules = nil
+	return n
+}
+
+// Rules devuelve una copia de las reglas que siguen vigentes
+func (f *FaultInjector) Rules() []FaultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pruneLocked(time.Now())
+
+	rules := make([]FaultRule, 0, len(f.rules))
+	for _, rule := range f.rules {
+		rules = append(rules, *rule)
+	}
+	return rules
+}
+
+// pruneLocked descarta las reglas caducadas. ASUME QUE f.mu ESTÁ ADQUIRIDO.
+func (f *FaultInjector) pruneLocked(now time.Time) {
+	kept := f.rules[:0]
+	for _, rule := range f.rules {
+		if !rule.expired(now) {
+			kept = append(kept, rule)
+		}
+	}
+	f.rules = kept
+}
+
+// evaluate decide qué hacer con un mensaje: la acción de la primera regla
+// de descarte o partición que se dispare, o si no el mayor retraso de las
+// reglas de retraso que coincidan
+func (f *FaultInjector) evaluate(direction, peerID, msgType string) (action string, delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.rules) == 0 {
+		return "", 0
+	}
+	f.pruneLocked(time.Now())
+
+	for _, rule := range f.rules {
+		if !rule.matches(direction, peerID, msgType) || f.rng.Float64() >= rule.Probability {
+			continue
+		}
+		switch rule.Action {
+		case FaultDrop, FaultPartition:
+			return rule.Action, 0
+		case FaultDelay:
+			if d := time.Duration(rule.DelayMs) * time.Millisecond; d > delay {
+				action, delay = FaultDelay, d
+			}
+		}
+	}
+	return action, delay
+}
+
+// partitioned indica si hay una partición activa con el peer
+func (f *FaultInjector) partitioned(peerID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for _, rule := range f.rules {
+		if rule.Action == FaultPartition && rule.Peer == peerID && !rule.expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// injectFault aplica las reglas activas a un mensaje y devuelve true si hay
+// que descartarlo. Los retrasos duermen aquí mismo, así que NO debe llamarse
+// con el mutex del nodo tomado.
+func (n *Node) injectFault(direction, peerID, msgType string) bool {
+	action, delay := n.faults.evaluate(direction, peerID, msgType)
+	switch action {
+	case FaultDrop, FaultPartition:
+		n.stats.recordInjected(action, direction, msgType)
+		log.Printf("[%s] FAULT: %s %s %s %s", n.ID, action, msgType, faultVerb(direction), peerID)
+		return true
+	case FaultDelay:
+		n.stats.recordInjected(action, direction, msgType)
+		log.Printf("[%s] FAULT: delaying %s %s %s by %s", n.ID, msgType, faultVerb(direction), peerID, delay)
+		time.Sleep(delay)
+	}
+	return false
+}
+
+func faultVerb(direction string) string {
+	if direction == faultInbound {
+		return "from"
+	}
+	return "to"
+}
+
+// faultInjectionEnabled indica si FAULT_INJECTION permite usar /internal/faults
+func faultInjectionEnabled() bool {
+	return os.Getenv("FAULT_INJECTION") == "true"
+}
+
+// handleFaults gestiona las reglas de /internal/faults: GET las lista, POST
+// añade una y DELETE las borra todas (o solo una con /internal/faults/{id})
+func (s *Server) handleFaults(w http.ResponseWriter, r *http.Request) {
+	if !faultInjectionEnabled() {
+		httperr.Write(w, http.StatusForbidden, CodeFaultsDisabled, "Fault injection disabled (FAULT_INJECTION not set)")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	faults := s.node.faults
+	switch r.Method {
+	case http.MethodPost:
+		var rule FaultRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidJSON, "Invalid fault rule")
+			return
+		}
+		added, err := faults.Add(rule)
+		if err != nil {
+			httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, err.Error())
+			return
+		}
+		log.Printf("[%s] Fault rule %s added: %s peer=%q type=%q direction=%s",
+			s.serverID, added.ID, added.Action, added.Peer, added.Type, added.Direction)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(added)
+
+	case http.MethodDelete:
+		if id, ok := mux.Vars(r)["id"]; ok {
+			if !faults.Remove(id) {
+				httperr.Write(w, http.StatusNotFound, httperr.CodeNotFound, "Fault rule not found")
+				return
+			}
+			log.Printf("[%s] Fault rule %s removed", s.serverID, id)
+			json.NewEncoder(w).Encode(map[string]interface{}{"removed": 1})
+			return
+		}
+		removed := faults.Clear()
+		log.Printf("[%s] Cleared %d fault rules", s.serverID, removed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"removed": removed})
+
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"node_id": s.serverID,
+			"rules":   faults.Rules(),
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestFaultRuleValidation(t *testing.T) {
+	for _, rule := range []FaultRule{
+		{Action: "explode"},
+		{Action: FaultDelay},
+		{Action: FaultPartition},
+		{Action: FaultDrop, Direction: "sideways"},
+		{Action: FaultDrop, Probability: 1.5},
+		{Action: FaultDrop, TTLSeconds: -1},
+	} {
+		if err := rule.normalize(); err == nil {
+			t.Errorf("expected %+v to be rejected", rule)
+		}
+	}
+
+	rule := FaultRule{Action: FaultDrop, Type: "request"}
+	if err := rule.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if rule.Direction != faultBoth || rule.Probability != 1 || rule.Type != "REQUEST" {
+		t.Fatalf("expected both directions, probability 1 and type REQUEST, got %+v", rule)
+	}
+
+	// Una partición corta todo el tráfico con el peer
+	rule = FaultRule{Action: FaultPartition, Peer: "node3", Direction: faultInbound, Type: "REPLY", Probability: 0.1}
+	if err := rule.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if rule.Direction != faultBoth || rule.Type != "" || rule.Probability != 1 {
+		t.Fatalf("expected the partition to drop every message, got %+v", rule)
+	}
+}
+
+func TestFaultInjectorEvaluate(t *testing.T) {
+	f := newFaultInjector()
+	f.Add(FaultRule{Action: FaultDelay, Peer: "node2", Type: "REPLY", Direction: faultOutbound, DelayMs: 100})
+	f.Add(FaultRule{Action: FaultDelay, Type: "REPLY", DelayMs: 300})
+	drop, _ := f.Add(FaultRule{Action: FaultDrop, Type: "REQUEST", Direction: faultInbound})
+
+	for _, tc := range []struct {
+		direction, peer, msgType string
+		action                   string
+		delay                    time.Duration
+	}{
+		// De las reglas de retraso que coinciden gana la mayor
+		{faultOutbound, "node2", "REPLY", FaultDelay, 300 * time.Millisecond},
+		{faultInbound, "node3", "REPLY", FaultDelay, 300 * time.Millisecond},
+		{faultInbound, "node2", "REQUEST", FaultDrop, 0},
+		{faultOutbound, "node2", "REQUEST", "", 0},
+		{faultOutbound, "node2", "RELEASE", "", 0},
+	} {
+		action, delay := f.evaluate(tc.direction, tc.peer, tc.msgType)
+		if action != tc.action || delay != tc.delay {
+			t.Errorf("%s %s %s: expected %q %s, got %q %s", tc.direction, tc.peer, tc.msgType, tc.action, tc.delay, action, delay)
+		}
+	}
+
+	if !f.Remove(drop.ID) || f.Remove(drop.ID) {
+		t.Fatal("expected the drop rule to be removed exactly once")
+	}
+	if action, _ := f.evaluate(faultInbound, "node2", "REQUEST"); action != "" {
+		t.Fatalf("expected no action after removing the rule, got %q", action)
+	}
+	if n := f.Clear(); n != 2 || len(f.Rules()) != 0 {
+		t.Fatalf("expected Clear to remove the 2 remaining rules, removed %d", n)
+	}
+}
+
+func TestFaultRulesExpire(t *testing.T) {
+	f := newFaultInjector()
+	rule, _ := f.Add(FaultRule{Action: FaultPartition, Peer: "node2", TTLSeconds: 30})
+	if rule.ExpiresAt == nil || !f.partitioned("node2") {
+		t.Fatalf("expected an active partition with an expiry, got %+v", rule)
+	}
+
+	// Adelantar la caducidad en lugar de esperar al TTL
+	f.mu.Lock()
+	past := time.Now().Add(-time.Second)
+	f.rules[0].ExpiresAt = &past
+	f.mu.Unlock()
+
+	if f.partitioned("node2") {
+		t.Fatal("expected the expired partition to stop applying")
+	}
+	if action, _ := f.evaluate(faultOutbound, "node2", "REQUEST"); action != "" {
+		t.Fatalf("expected no action from an expired rule, got %q", action)
+	}
+	if rules := f.Rules(); len(rules) != 0 {
+		t.Fatalf("expected expired rules not to be listed, got %+v", rules)
+	}
+}
+
+// Un REQUEST retrasado retrasa la entrada de quien lo envía, pero mientras
+// tanto los dos nodos siguen atendiendo: el retraso no se duerme con el mutex
+func TestFaultDelayDoesNotHoldTheNodeMutex(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	const delay = 300 * time.Millisecond
+	if _, err := c.Node("node2").faults.Add(FaultRule{Action: FaultDelay, Type: "REQUEST", Direction: faultInbound, DelayMs: int(delay.Milliseconds())}); err != nil {
+		t.Fatal(err)
+	}
+
+	entered := make(chan error, 1)
+	go func() { entered <- c.Enter("node1", 2*time.Second) }()
+	waitWanted(t, c.Node("node1"))
+	time.Sleep(20 * time.Millisecond)
+
+	for _, id := range []string{"node1", "node2"} {
+		start := time.Now()
+		c.Node(id).DebugState()
+		if elapsed := time.Since(start); elapsed > delay/3 {
+			t.Fatalf("%s's mutex was held during the injected delay (%s)", id, elapsed)
+		}
+	}
+
+	if err := <-entered; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+
+	if wait := c.Node("node1").MessageStats().CSWait; wait.MaxMs < float64(delay.Milliseconds()) {
+		t.Fatalf("expected the CS wait to include the %s delay, got %+v", delay, wait)
+	}
+	injected := c.Node("node2").MessageStats().InjectedFaults
+	want := InjectedFaults{Action: FaultDelay, Direction: faultInbound, Type: "REQUEST", Count: 1}
+	if len(injected) != 1 || injected[0] != want {
+		t.Fatalf("expected %+v to be counted, got %+v", want, injected)
+	}
+}
+
+func TestFaultDropAndPartitionAreCounted(t *testing.T) {
+	node := newSimNode("node2", []string{"node1", "node3"})
+	node.faults.Add(FaultRule{Action: FaultDrop, Type: "REQUEST", Direction: faultInbound})
+	node.faults.Add(FaultRule{Action: FaultPartition, Peer: "node3"})
+
+	_, err := node.handleMessage(Message{Type: "REQUEST", NodeID: "node1", Timestamp: 1, Seq: 1})
+	if !errors.Is(err, ErrFaultInjected) {
+		t.Fatalf("expected ErrFaultInjected, got %v", err)
+	}
+	_, err = node.handleMessage(Message{Type: "REPLY", NodeID: "node3", Timestamp: 2, Seq: 2})
+	if !errors.Is(err, ErrFaultInjected) {
+		t.Fatalf("expected the partition to drop node3's REPLY, got %v", err)
+	}
+	if sent := node.MessageStats().TotalSent; sent != 0 {
+		t.Fatalf("expected nothing to be answered, sent %d", sent)
+	}
+
+	var buf bytes.Buffer
+	node.MessageStats().WritePrometheus(&buf, "node2")
+	for _, line := range []string{
+		`dme_faults_injected_total{node="node2",algorithm="ricart-agrawala",action="drop",direction="in",type="REQUEST"} 1`,
+		`dme_faults_injected_total{node="node2",algorithm="ricart-agrawala",action="partition",direction="in",type="REPLY"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("expected %q in the metrics, got:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestFaultsEndpoint(t *testing.T) {
+	s := &Server{node: newSimNode("node1", []string{"node2"}), serverID: "node1"}
+	r := mux.NewRouter()
+	r.HandleFunc("/internal/faults", s.handleFaults).Methods("GET", "POST", "DELETE")
+	r.HandleFunc("/internal/faults/{id}", s.handleFaults).Methods("DELETE")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	t.Setenv("FAULT_INJECTION", "")
+	if rec := do(http.MethodGet, "/internal/faults", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 with fault injection disabled, got %d", rec.Code)
+	}
+	t.Setenv("FAULT_INJECTION", "true")
+
+	rec := do(http.MethodPost, "/internal/faults", `{"action":"delay","peer":"node2","type":"reply","delay_ms":3000,"ttl_seconds":60}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var added FaultRule
+	json.NewDecoder(rec.Body).Decode(&added)
+	if added.ID == "" || added.ExpiresAt == nil || added.Type != "REPLY" {
+		t.Fatalf("expected the rule with an id and expiry, got %+v", added)
+	}
+	if rec := do(http.MethodPost, "/internal/faults", `{"action":"partition"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid rule, got %d", rec.Code)
+	}
+	do(http.MethodPost, "/internal/faults", `{"action":"drop","type":"REQUEST","probability":0.3}`)
+
+	var list struct {
+		Rules []FaultRule `json:"rules"`
+	}
+	json.NewDecoder(do(http.MethodGet, "/internal/faults", "").Body).Decode(&list)
+	if len(list.Rules) != 2 || list.Rules[1].Probability != 0.3 {
+		t.Fatalf("expected both rules listed, got %+v", list.Rules)
+	}
+
+	if rec := do(http.MethodDelete, "/internal/faults/"+added.ID, ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected the rule to be removed, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/internal/faults/"+added.ID, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 removing it twice, got %d", rec.Code)
+	}
+	var cleared map[string]int
+	json.NewDecoder(do(http.MethodDelete, "/internal/faults", "").Body).Decode(&cleared)
+	if cleared["removed"] != 1 || len(s.node.faults.Rules()) != 0 {
+		t.Fatalf("expected the last rule to be cleared, got %v", cleared)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Gossip de la membresía. Con GOSSIP_INTERVAL_MS, en lugar de que cada nodo
+// haga ping a todos los demás (N² pings por intervalo), en cada intervalo el
+// nodo elige un peer al azar y los dos intercambian su tabla de miembros
+// (POST /internal/membership). Cada nodo es el único que escribe su propia
+// entrada: aumenta su Heartbeat en cada ronda y su encarnación en cada
+// arranque. Al fusionar dos tablas gana, para cada miembro, la entrada con
+// mayor (Incarnation, Heartbeat), con su URL; así un cambio de dirección
+// llega a todo el clúster en unas pocas rondas.
+//
+// LastSeen y Suspect son locales: cuándo avanzó aquí por última vez el
+// Heartbeat del miembro y si lleva más de SuspectAfter sin avanzar. El
+// detector de fallos y la búsqueda de la URL de un peer leen de esta tabla.
+
+// defaultGossipSuspectRounds es cuántos intervalos sin noticias de un
+// miembro lo marcan como sospechoso por defecto. Una novedad tarda varias
+// rondas en dar la vuelta al clúster, así que debe ser holgado.
+const defaultGossipSuspectRounds = 5
+
+// MemberEntry es lo que un nodo sabe de un miembro del clúster
+type MemberEntry struct {
+	NodeID      string    `json:"node_id"`
+	URL         string    `json:"url"`
+	InternalURL string    `json:"internal_url,omitempty"`
+	Incarnation int64     `json:"incarnation"`
+	Heartbeat   uint64    `json:"heartbeat"`
+	LastSeen    time.Time `json:"last_seen"`
+	Suspect     bool      `json:"suspect"`
+}
+
+// newerThan indica si la entrada es más reciente que other
+func (e MemberEntry) newerThan(other MemberEntry) bool {
+	if e.Incarnation != other.Incarnation {
+		return e.Incarnation > other.Incarnation
+	}
+	return e.Heartbeat > other.Heartbeat
+}
+
+// heard indica si la entrada viene del propio miembro y no solo de la
+// configuración inicial
+func (e MemberEntry) heard() bool {
+	return e.Incarnation != 0 || e.Heartbeat != 0
+}
+
+// Gossip mantiene la tabla de miembros del nodo y la intercambia con los
+// peers
+type Gossip struct {
+	node         *Node
+	Interval     time.Duration
+	SuspectAfter time.Duration
+
+	mu      sync.Mutex
+	members map[string]MemberEntry
+	rng     *rand.Rand
+
+	// exchange envía nuestra tabla a un peer y devuelve la suya; por
+	// defecto con POST /internal/membership
+	exchange func(peerID string, view []MemberEntry) ([]MemberEntry, error)
+}
+
+// NewGossip crea la tabla con la entrada del propio nodo y las URLs de
+// PEER_URLS, que valen hasta que cada peer dé noticias suyas
+func NewGossip(node *Node, selfURL, selfInternalURL string, interval time.Duration) *Gossip {
+	now := time.Now()
+	g := &Gossip{
+		node:         node,
+		Interval:     interval,
+		SuspectAfter: defaultGossipSuspectRounds * interval,
+		members:      make(map[string]MemberEntry),
+		rng:          rand.New(rand.NewSource(now.UnixNano())),
+	}
+	g.exchange = g.httpExchange
+
+	peers := node.PeerList()
+	node.urlsMu.RLock()
+	for _, peer := range peers {
+		g.members[peer] = MemberEntry{NodeID: peer, URL: node.peerURLs[peer], LastSeen: now}
+	}
+	node.urlsMu.RUnlock()
+	g.members[node.ID] = MemberEntry{
+		NodeID:      node.ID,
+		URL:         selfURL,
+		InternalURL: selfInternalURL,
+		Incarnation: node.Incarnation(),
+		LastSeen:    now,
+	}
+	return g
+}
+
+// SetSelfURL cambia la dirección con la que el nodo se anuncia. La entrada
+// nueva gana a la anterior en cuanto llega a cada peer.
+func (g *Gossip) SetSelfURL(url, internalURL string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	self := g.members[g.node.ID]
+	self.URL, self.InternalURL = url, internalURL
+	self.Heartbeat++
+	g.members[g.node.ID] = self
+	g.node.logf("Announcing address %s via gossip", url)
+}
+
+// Run hace una ronda de gossip en cada intervalo. Bloquea para siempre.
+func (g *Gossip) Run() {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		g.Round()
+	}
+}
+
+// Round aumenta el Heartbeat propio e intercambia la tabla con un peer al
+// azar. Un peer que no responde no se anota aquí: deja de dar noticias y
+// acaba marcado como sospechoso.
+func (g *Gossip) Round() error {
+	peers := g.node.PeerList()
+	incarnation := g.node.Incarnation()
+
+	g.mu.Lock()
+	self := g.members[g.node.ID]
+	self.Incarnation = incarnation
+	self.Heartbeat++
+	self.LastSeen = time.Now()
+	g.members[g.node.ID] = self
+	view := g.viewLocked()
+	peer := ""
+	if len(peers) > 0 {
+		peer = peers[g.rng.Intn(len(peers))]
+	}
+	g.mu.Unlock()
+
+	if peer == "" {
+		return nil
+	}
+	theirs, err := g.exchange(peer, view)
+	if err != nil {
+		return err
+	}
+	g.Merge(theirs)
+	return nil
+}
+
+// Exchange fusiona la tabla recibida de un peer y devuelve la nuestra
+func (g *Gossip) Exchange(theirs []MemberEntry) []MemberEntry {
+	g.Merge(theirs)
+	return g.View()
+}
+
+// Merge se queda con las entradas recibidas que sean más recientes que las
+// nuestras. La entrada propia solo la escribe este nodo. Un nodo que no es
+// peer solo se incorpora a la membresía con AutoRegisterPeers, como quien
+// manda un mensaje sin estar en PEERS.
+func (g *Gossip) Merge(entries []MemberEntry) {
+	now := time.Now()
+	var learned []MemberEntry
+
+	g.mu.Lock()
+	for _, entry := range entries {
+		if entry.NodeID == "" || entry.NodeID == g.node.ID {
+			continue
+		}
+		current, known := g.members[entry.NodeID]
+		if known && !entry.newerThan(current) {
+			continue
+		}
+		if known && current.heard() && current.URL != entry.URL {
+			g.node.logf("Peer %s moved from %s to %s (learned via gossip)", entry.NodeID, current.URL, entry.URL)
+		}
+		entry.LastSeen, entry.Suspect = now, false
+		g.members[entry.NodeID] = entry
+		if !known {
+			learned = append(learned, entry)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, entry := range learned {
+		if g.node.IsPeer(entry.NodeID) {
+			continue
+		}
+		if !g.node.AutoRegisterPeers {
+			g.node.logf("Learned about %s via gossip, but it is not in PEERS (AUTO_REGISTER_PEERS=true admits it)", entry.NodeID)
+			continue
+		}
+		g.node.logf("WARNING: auto-registering %s learned via gossip", entry.NodeID)
+		g.node.AddPeer(entry.NodeID, entry.URL)
+	}
+}
+
+// View devuelve la tabla ordenada por ID, con Suspect calculado ahora
+func (g *Gossip) View() []MemberEntry {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.viewLocked()
+}
+
+// viewLocked es View con g.mu ya adquirido
+func (g *Gossip) viewLocked() []MemberEntry {
+	now := time.Now()
+	view := make([]MemberEntry, 0, len(g.members))
+	for id, entry := range g.members {
+		entry.Suspect = id != g.node.ID && now.Sub(entry.LastSeen) > g.SuspectAfter
+		view = append(view, entry)
+	}
+	sort.Slice(view, func(i, j int) bool { return view[i].NodeID < view[j].NodeID })
+	return view
+}
+
+// URL devuelve la URL base y la del listener interno de un peer, si ya ha
+// dado noticias suyas; si no, manda la configuración
+func (g *Gossip) URL(peerID string) (url, internalURL string, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	entry, known := g.members[peerID]
+	if !known || !entry.heard() || entry.URL == "" {
+		return "", "", false
+	}
+	return entry.URL, entry.InternalURL, true
+}
+
+// Heard devuelve cuándo avanzó por última vez el Heartbeat del peer y si
+// fue hace menos de SuspectAfter
+func (g *Gossip) Heard(peerID string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	entry, known := g.members[peerID]
+	if !known || !entry.heard() {
+		return time.Time{}, false
+	}
+	return entry.LastSeen, time.Since(entry.LastSeen) <= g.SuspectAfter
+}
+
+// httpExchange intercambia las tablas con POST /internal/membership. Una
+// partición inyectada también corta el gossip.
+func (g *Gossip) httpExchange(peerID string, view []MemberEntry) ([]MemberEntry, error) {
+	if g.node.faults.partitioned(peerID) {
+		return nil, fmt.Errorf("partitioned from %s", peerID)
+	}
+	base, err := g.node.internalBaseURL(peerID)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(MembershipView{NodeID: g.node.ID, Members: view})
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: g.Interval, Transport: g.node.client.Transport}
+	resp, err := g.node.postSigned(&client, base+"/internal/membership", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %d", peerID, resp.StatusCode)
+	}
+
+	var theirs MembershipView
+	if err := json.NewDecoder(resp.Body).Decode(&theirs); err != nil {
+		return nil, err
+	}
+	return theirs.Members, nil
+}
+
+// MembershipView es el cuerpo de /internal/membership
+type MembershipView struct {
+	NodeID         string        `json:"node_id"`
+	IntervalMs     int64         `json:"interval_ms,omitempty"`
+	SuspectAfterMs int64         `json:"suspect_after_ms,omitempty"`
+	Members        []MemberEntry `json:"members"`
+}
+
+// handleMembership devuelve la tabla de miembros (GET) o la intercambia con
+// la del peer que la envía (POST)
+func (s *Server) handleMembership(w http.ResponseWriter, r *http.Request) {
+	g := s.node.gossip
+	if g == nil {
+		httperr.Write(w, http.StatusNotFound, httperr.CodeNotFound, "Gossip is disabled (GOSSIP_INTERVAL_MS=0)")
+		return
+	}
+
+	var members []MemberEntry
+	if r.Method == http.MethodPost {
+		var theirs MembershipView
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageBytes)).Decode(&theirs); err != nil {
+			httperr.WriteDecodeError(w, err)
+			return
+		}
+		members = g.Exchange(theirs.Members)
+	} else {
+		members = g.View()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MembershipView{
+		NodeID:         s.serverID,
+		IntervalMs:     g.Interval.Milliseconds(),
+		SuspectAfterMs: g.SuspectAfter.Milliseconds(),
+		Members:        members,
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// Seis nodos intercambian su tabla de miembros por gossip, con rondas
+// deterministas, hasta que todos conocen la URL que anuncia cada uno. node4
+// cambia de dirección y en pocas rondas todos le envían los mensajes a la
+// nueva. node6 deja de hacer rondas y los demás lo marcan como sospechoso,
+// también en el detector de fallos.
+func TestGossipAddressChange(t *testing.T) {
+	ids := []string{"node1", "node2", "node3", "node4", "node5", "node6"}
+	c := NewSimCluster(ids...)
+	announced := func(id string) string { return "http://" + id + ":8080" }
+
+	gossips := make(map[string]*Gossip, len(ids))
+	for i, id := range ids {
+		node := c.Node(id)
+		for _, peer := range node.PeerList() {
+			// PEER_URLS con una dirección que nadie anuncia: hasta que el
+			// peer dé noticias suyas se usa esta
+			node.SetPeerURL(peer, "http://"+peer+".stale:8080")
+		}
+		g := NewGossip(node, announced(id), "", 10*time.Millisecond)
+		g.SuspectAfter = time.Hour
+		g.rng = rand.New(rand.NewSource(int64(i + 1)))
+		g.exchange = func(peer string, view []MemberEntry) ([]MemberEntry, error) {
+			return gossips[peer].Exchange(view), nil
+		}
+		gossips[id] = g
+		node.gossip = g
+	}
+
+	converged := func(target, url string) bool {
+		for _, id := range ids {
+			if id == target {
+				continue
+			}
+			node := c.Node(id)
+			base, err := node.peerBaseURL(target)
+			if err != nil || base != url {
+				return false
+			}
+			if message, err := node.findPeerURL(target); err != nil || message != url+"/internal/message" {
+				return false
+			}
+		}
+		return true
+	}
+	allConverged := func() bool {
+		for _, id := range ids {
+			if !converged(id, announced(id)) {
+				return false
+			}
+		}
+		return true
+	}
+	runRounds := func(live []string, limit int, done func() bool) (int, error) {
+		for round := 1; round <= limit; round++ {
+			for _, id := range live {
+				if err := gossips[id].Round(); err != nil {
+					return round, err
+				}
+			}
+			if done() {
+				return round, nil
+			}
+		}
+		return limit, fmt.Errorf("no convergence after %d rounds", limit)
+	}
+
+	const maxRounds = 12
+	if _, err := runRounds(ids, maxRounds, allConverged); err != nil {
+		t.Fatalf("initial membership: %v", err)
+	}
+
+	moved := "http://node4-moved:9090"
+	gossips["node4"].SetSelfURL(moved, "")
+	if _, err := runRounds(ids, maxRounds, func() bool { return converged("node4", moved) }); err != nil {
+		t.Fatalf("node4 address change: %v", err)
+	}
+	if urls := c.Node("node1").PeerURLs(); urls["node4"] != moved {
+		t.Fatalf("node1 PeerURLs reports %s for node4, want %s", urls["node4"], moved)
+	}
+
+	// node6 deja de hacer rondas: su Heartbeat no avanza y, cuando su
+	// última entrada ha llegado a todos, los demás dejan de tener noticias
+	// suyas
+	live := ids[:5]
+	var final MemberEntry
+	for _, entry := range gossips["node6"].View() {
+		if entry.NodeID == "node6" {
+			final = entry
+		}
+	}
+	if _, err := runRounds(live, maxRounds, func() bool {
+		for _, id := range live {
+			for _, entry := range gossips[id].View() {
+				if entry.NodeID == "node6" && (entry.Incarnation != final.Incarnation || entry.Heartbeat != final.Heartbeat) {
+					return false
+				}
+			}
+		}
+		return true
+	}); err != nil {
+		t.Fatalf("node6's last heartbeat: %v", err)
+	}
+	for _, id := range ids {
+		gossips[id].SuspectAfter = 50 * time.Millisecond
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := runRounds(live, maxRounds, func() bool {
+		_, fresh := gossips["node1"].Heard("node2")
+		return fresh
+	}); err != nil {
+		t.Fatalf("node1 hearing from node2 again: %v", err)
+	}
+	for _, id := range live {
+		if _, fresh := gossips[id].Heard("node6"); fresh {
+			t.Fatalf("%s still hears from node6 after it stopped gossiping", id)
+		}
+		for _, entry := range gossips[id].View() {
+			if entry.NodeID == "node6" && !entry.Suspect {
+				t.Fatalf("%s does not mark node6 as suspect", id)
+			}
+		}
+	}
+
+	fd := NewFailureDetector(c.Node("node1"), 10*time.Millisecond, 1)
+	fd.DeadAfter = 0
+	fd.checkGossip("node6")
+	fd.checkGossip("node2")
+	if suspects := fd.Suspects(); len(suspects) != 1 || suspects[0] != "node6" {
+		t.Fatalf("failure detector suspects %v, want [node6]", suspects)
+	}
+}
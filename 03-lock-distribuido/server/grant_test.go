@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// replyAll entrega el REPLY de cada peer a la ronda en curso
+func replyAll(t *testing.T, node *Node, round int64, seq *uint64, peers ...string) {
+	t.Helper()
+	for _, peer := range peers {
+		*seq++
+		inject(t, node, Message{Type: "REPLY", NodeID: peer, Timestamp: node.Clock.GetTime() + 1, Seq: *seq, Round: round})
+	}
+}
+
+// expectStillWaiting comprueba que la petición no ha terminado
+func expectStillWaiting(t *testing.T, done chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("the request returned without collecting the replies (err %v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Una concesión de una petición anterior que quedó en csGranted no basta
+// para entrar: la siguiente petición sigue esperando sus REPLY
+func TestStaleGrantDoesNotSatisfyTheNextRequest(t *testing.T) {
+	node, capture := newSequenceNode("node2", "node3")
+	var seq uint64
+	round, done := startRequest(t, node, capture)
+	replyAll(t, node, round, &seq, "node2", "node3")
+	expectEntered(t, done)
+	node.ReleaseCS()
+
+	// Lo que dejaba el fallo: la señal de la petición ya liberada sin recoger
+	node.mu.Lock()
+	node.csGranted <- node.csToken
+	node.mu.Unlock()
+
+	round, done = startRequest(t, node, capture)
+	expectStillWaiting(t, done)
+	expectWaiting(t, node, "node2", "node3")
+
+	replyAll(t, node, round, &seq, "node2", "node3")
+	expectEntered(t, done)
+	if n := len(node.csGranted); n != 0 {
+		t.Fatalf("expected no grant left over, %d buffered", n)
+	}
+	node.ReleaseCS()
+}
+
+// El último REPLY llega a la vez que se cancela la petición: el llamador se
+// queda la CS que ya se le concedió y no queda ninguna señal para la
+// siguiente petición
+func TestReplyRacingCancelLeavesNoGrantBehind(t *testing.T) {
+	node, capture := newSequenceNode("node2")
+	var seq uint64
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- node.RequestCSContext(ctx) }()
+	if msg := capture.next(t); msg.Type != "REQUEST" {
+		t.Fatalf("expected a REQUEST, got %+v", msg)
+	}
+
+	// Con el mutex tomado se concede la CS y se cancela el contexto: al
+	// soltarlo, el llamador ve las dos cosas a la vez
+	node.mu.Lock()
+	delete(node.RepliesNeeded, "node2")
+	node._enterCS()
+	cancel()
+	node.mu.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("expected the granted CS to be kept, got %v", err)
+	}
+	if state := node.CSStatus().State; state != Held.String() {
+		t.Fatalf("expected state Held, got %s", state)
+	}
+	node.ReleaseCS()
+	if n := len(node.csGranted); n != 0 {
+		t.Fatalf("expected no grant left over, %d buffered", n)
+	}
+
+	round, next := startRequest(t, node, capture)
+	expectStillWaiting(t, next)
+	replyAll(t, node, round, &seq, "node2")
+	expectEntered(t, next)
+	node.ReleaseCS()
+}
+
+// Una petición en espera descarta la señal de otra anterior y, si después se
+// cancela, no deja nada en csGranted
+func TestWaitingRequestDiscardsStaleGrant(t *testing.T) {
+	node, capture := newSequenceNode("node2")
+	var seq uint64
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- node.RequestCSContext(ctx) }()
+	capture.next(t)
+
+	node.mu.Lock()
+	node.csGranted <- node.csToken - 1
+	node.mu.Unlock()
+	// La señal antigua se descarta y la petición sigue esperando
+	expectStillWaiting(t, done)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if n := len(node.csGranted); n != 0 {
+		t.Fatalf("expected no grant left over, %d buffered", n)
+	}
+
+	round, next := startRequest(t, node, capture)
+	expectStillWaiting(t, next)
+	replyAll(t, node, round, &seq, "node2")
+	expectEntered(t, next)
+	node.ReleaseCS()
+}
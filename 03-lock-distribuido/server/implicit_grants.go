@@ -0,0 +1,29 @@
+package main
+
+// Optimización de Roucairol y Carvalho para Ricart-Agrawala. Un REPLY de un
+// peer es un permiso implícito: mientras no le enviemos nosotros un REPLY, el
+// peer no puede entrar en la CS sin pedirnos permiso antes, así que podemos
+// volver a entrar sin preguntarle. Un nodo que reserva muchos asientos
+// seguidos sin que nadie más pida la CS entra sin enviar ningún mensaje.
+//
+// Entre cada par de nodos como mucho uno de los dos tiene el permiso del otro:
+// se gana al aceptar un REPLY para la petición en curso y se pierde al
+// construir cualquier REPLY para ese peer (newReply). Si lo perdemos mientras
+// esperamos la CS, le pedimos de nuevo el REPLY. No tener el permiso es
+// siempre seguro, así que no se guarda en el snapshot: tras reiniciar un nodo
+// pregunta a todos. Con IMPLICIT_GRANTS=true; solo con ALGORITHM=ricart-agrawala.
+
+// regainGrant vuelve a pedir su REPLY a un peer cuyo permiso implícito
+// teníamos y al que vamos a responder mientras esperamos la CS: no le
+// enviamos el REQUEST de la petición en curso. Como en yieldTo, un REPLY suyo
+// anterior a su REQUEST ya no vale.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) regainGrant(msg Message) {
+	n.yieldedTo[msg.NodeID] = msg.Timestamp
+	if n.excluded[msg.NodeID] {
+		return
+	}
+	n.logf("Giving up implicit grant from %s, asking it again for its reply", msg.NodeID)
+	n.RepliesNeeded[msg.NodeID] = true
+	n.dispatch(msg.NodeID, n.currentRequest())
+}
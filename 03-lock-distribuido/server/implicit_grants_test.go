@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newGrantsCluster crea un clúster con IMPLICIT_GRANTS y sin anuncios HELD,
+// que también cuentan como mensajes
+func newGrantsCluster(ids ...string) *SimCluster {
+	c := NewSimCluster(ids...)
+	for _, id := range ids {
+		c.Node(id).ImplicitGrants = true
+		c.Node(id).HeldAnnounceInterval = 0
+	}
+	return c
+}
+
+// entryCost entra y sale de la CS y devuelve los mensajes que costó
+func entryCost(t *testing.T, c *SimCluster, id string) uint64 {
+	t.Helper()
+	before := c.MessagesSent()
+	if err := c.Enter(id, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit(id)
+	return c.MessagesSent() - before
+}
+
+func expectGrants(t *testing.T, c *SimCluster, id string, want ...string) {
+	t.Helper()
+	if got := c.Node(id).DebugState().ImplicitGrants; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("%s holds implicit grants from %v, want %v", id, got, want)
+	}
+}
+
+// Solo la primera de varias entradas seguidas sin contienda cuesta mensajes
+func TestImplicitGrantsRepeatedEntryIsFree(t *testing.T) {
+	c := newGrantsCluster("node1", "node2", "node3")
+
+	if sent := entryCost(t, c, "node1"); sent != 4 {
+		t.Fatalf("first entry cost %d messages, want 4", sent)
+	}
+	expectGrants(t, c, "node1", "node2", "node3")
+	for i := 0; i < 5; i++ {
+		if sent := entryCost(t, c, "node1"); sent != 0 {
+			t.Fatalf("repeated entry %d cost %d messages, want 0", i+1, sent)
+		}
+	}
+}
+
+// Sin IMPLICIT_GRANTS cada entrada pregunta a todos los peers
+func TestImplicitGrantsDisabled(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	c.Node("node1").HeldAnnounceInterval = 0
+
+	for i := 0; i < 3; i++ {
+		if sent := entryCost(t, c, "node1"); sent != 4 {
+			t.Fatalf("entry %d cost %d messages, want 4", i+1, sent)
+		}
+	}
+	expectGrants(t, c, "node1")
+}
+
+// Responder a un peer le cede su permiso: la siguiente entrada solo le
+// pregunta a él
+func TestImplicitGrantsLostByReplying(t *testing.T) {
+	c := newGrantsCluster("node1", "node2", "node3")
+	entryCost(t, c, "node1")
+
+	if sent := entryCost(t, c, "node2"); sent != 4 {
+		t.Fatalf("first entry of node2 cost %d messages, want 4", sent)
+	}
+	expectGrants(t, c, "node1", "node3")
+	expectGrants(t, c, "node2", "node1", "node3")
+	if sent := entryCost(t, c, "node1"); sent != 2 {
+		t.Fatalf("node1 after node2 took its grant back cost %d messages, want 2", sent)
+	}
+}
+
+// Un peer que sale de la membresía deja de contar como permiso
+func TestImplicitGrantsForgetRemovedPeer(t *testing.T) {
+	c := newGrantsCluster("node1", "node2", "node3")
+	entryCost(t, c, "node1")
+
+	c.Node("node1").RemovePeer("node3")
+	expectGrants(t, c, "node1", "node2")
+}
+
+// Los tres nodos compiten con latencia variable, que desordena los mensajes,
+// sin violar la exclusión mutua
+func TestImplicitGrantsMutualExclusion(t *testing.T) {
+	ids := []string{"node1", "node2", "node3"}
+	c := newGrantsCluster(ids...)
+	c.Network.Delay = func(from, to string, msg Message) time.Duration {
+		return time.Duration(rand.Intn(5)) * time.Millisecond
+	}
+
+	const entries = 15
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ids))
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for i := 0; i < entries; i++ {
+				if err := c.Enter(id, 3*time.Second); err != nil {
+					errs <- fmt.Errorf("%s entry %d: %w", id, i+1, err)
+					return
+				}
+				time.Sleep(time.Millisecond)
+				c.Exit(id)
+			}
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("%d mutual exclusion violations", v)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"time"
+)
+
+// Encarnaciones. Cada arranque de un nodo es una encarnación nueva, con un
+// número mayor que el de la anterior, y todos sus mensajes lo llevan. Al
+// arrancar, el nodo lo anuncia con un RECOVER. Cuando un peer ve por primera
+// vez la encarnación nueva (en el RECOVER o en cualquier mensaje que llegue
+// antes) olvida lo que quedaba de la anterior: los REPLY que le debía a una
+// petición que ya no existe, y la espera de un REPLY que la encarnación
+// nueva no sabe que debe, que se vuelve a pedir. Los mensajes de la
+// encarnación anterior que lleguen después se descartan.
+
+// initialIncarnation elige el número de encarnación a partir del reloj
+// físico, como initialSeq, para que sea mayor que el de la anterior aunque
+// no se haya podido leer el snapshot
+func initialIncarnation() int64 {
+	return time.Now().UnixNano()
+}
+
+// Incarnation devuelve la encarnación de este nodo
+func (n *Node) Incarnation() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.incarnation
+}
+
+// restoreIncarnation garantiza que la encarnación supera a la del snapshot,
+// aunque el reloj físico haya retrocedido
+func (n *Node) restoreIncarnation(persisted int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if persisted >= n.incarnation {
+		n.incarnation = persisted + 1
+	}
+}
+
+// AnnounceRecovery envía un RECOVER con la encarnación actual a todos los
+// peers. Pasa por los reintentos de sendMessage como cualquier mensaje.
+func (n *Node) AnnounceRecovery() {
+	n.mu.Lock()
+	msg := Message{
+		Type:        "RECOVER",
+		Timestamp:   n.Clock.Increment(),
+		NodeID:      n.ID,
+		Incarnation: n.incarnation,
+	}
+	peers := append([]string(nil), n.Peers...)
+	n.mu.Unlock()
+
+	n.logf("Announcing incarnation %d to %d peers", msg.Incarnation, len(peers))
+	n.broadcast(peers, msg)
+}
+
+// observeIncarnation anota la encarnación del emisor de un mensaje. Devuelve
+// true si el mensaje es de una encarnación anterior a la conocida y debe
+// descartarse. Los mensajes sin encarnación (nodos de una versión anterior)
+// se aceptan siempre.
+func (n *Node) observeIncarnation(msg Message) bool {
+	if msg.Incarnation == 0 {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	known := n.peerIncarnations[msg.NodeID]
+	if msg.Incarnation < known {
+		return true
+	}
+	if msg.Incarnation == known {
+		return false
+	}
+	n.peerIncarnations[msg.NodeID] = msg.Incarnation
+	if known != 0 {
+		n.forgetIncarnation(msg.NodeID, known, msg.Incarnation)
+	}
+	return false
+}
+
+// forgetIncarnation borra el estado que quedaba de la encarnación anterior
+// de un peer que se ha reiniciado. ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) forgetIncarnation(peerID string, old, current int64) {
+	// Su petición anterior ya no existe: no hay REPLY que deberle
+	dropped := 0
+	kept := make([]string, 0, len(n.DeferredReplies))
+	for _, id := range n.DeferredReplies {
+		if id == peerID {
+			dropped++
+			continue
+		}
+		kept = append(kept, id)
+	}
+	n.DeferredReplies = kept
+	n.replies.Drop(peerID, "peer restarted")
+
+	// Rondas, secuencias y prioridades empiezan de nuevo con la encarnación
+	delete(n.peerRounds, peerID)
+	delete(n.peerRequestTimes, peerID)
+	delete(n.peerPriorities, peerID)
+	delete(n.piggybacked, peerID)
+	delete(n.yieldedTo, peerID)
+	delete(n.lastSeq, peerID)
+	if n.lamportQueue() {
+		n.dequeueRequest(peerID)
+	}
+
+	// Si esperábamos su REPLY, la encarnación nueva no sabe que lo debe:
+	// volver a pedírselo
+	rerequested := false
+	if n.State == Wanted && !n.raftMode() && (n.RepliesNeeded[peerID] || n.excluded[peerID]) {
+		delete(n.excluded, peerID)
+		n.RepliesNeeded[peerID] = true
+		n.dispatch(peerID, n.currentRequest())
+		rerequested = true
+	}
+
+	n.markStateChanged()
+	n.logf("Peer %s restarted (incarnation %d -> %d): dropped %d deferred replies, re-requested: %t",
+		peerID, old, current, dropped, rerequested)
+}
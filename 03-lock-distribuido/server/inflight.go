@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InFlight cuenta las peticiones HTTP que un handler está atendiendo, por
+// ruta, para que el apagado sepa qué queda pendiente y espere a que acabe.
+type InFlight struct {
+	mu      sync.Mutex
+	active  int
+	byRoute map[string]int
+	// idle se cierra cuando active llega a cero
+	idle chan struct{}
+}
+
+// NewInFlight crea un contador sin peticiones en curso
+func NewInFlight() *InFlight {
+	idle := make(chan struct{})
+	close(idle)
+	return &InFlight{byRoute: make(map[string]int), idle: idle}
+}
+
+func (f *InFlight) begin(route string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.active++
+	f.byRoute[route]++
+}
+
+func (f *InFlight) end(route string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.active--
+	if f.byRoute[route]--; f.byRoute[route] == 0 {
+		delete(f.byRoute, route)
+	}
+	if f.active == 0 {
+		close(f.idle)
+	}
+}
+
+// Active devuelve cuántas peticiones están en curso
+func (f *InFlight) Active() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.active
+}
+
+// Routes resume las peticiones en curso por ruta, p. ej.
+// "POST /reservar=2, GET /asientos=1"
+func (f *InFlight) Routes() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	routes := make([]string, 0, len(f.byRoute))
+	for route, count := range f.byRoute {
+		routes = append(routes, fmt.Sprintf("%s=%d", route, count))
+	}
+	sort.Strings(routes)
+	return strings.Join(routes, ", ")
+}
+
+// Idle devuelve un canal que se cierra cuando no queda ninguna petición en
+// curso; si ya no queda ninguna, está cerrado
+func (f *InFlight) Idle() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.idle
+}
+
+// Middleware cuenta cada petición mientras su handler se ejecuta. Los
+// WebSocket de /internal/events quedan fuera: duran lo que el cliente quiera
+// y el apagado los corta al cerrar el servidor.
+func (f *InFlight) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := r.Method + " " + r.URL.Path
+		f.begin(route)
+		defer f.end(route)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// drainProgressInterval es cada cuánto se informa de lo que queda en curso
+// mientras se espera
+const drainProgressInterval = time.Second
+
+// drainHTTP deja de aceptar peticiones en los servidores y espera hasta grace
+// a que terminen las que están en curso. Si no terminan a tiempo, cierra las
+// conexiones a la fuerza. Devuelve cuántas seguían en curso al forzar el
+// cierre (0 si terminaron todas).
+func drainHTTP(serverID string, servers []*http.Server, inflight *InFlight, grace time.Duration) int {
+	start := time.Now()
+	log.Printf("[%s] Shutdown: stopped accepting requests, %d in flight [%s], waiting up to %s",
+		serverID, inflight.Active(), inflight.Routes(), grace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	// Shutdown cierra los listeners y espera a que las conexiones queden
+	// ociosas; el contador dice qué peticiones son las que faltan
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil && err != context.DeadlineExceeded {
+				log.Printf("[%s] Error during shutdown of %s: %v", serverID, srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	ticker := time.NewTicker(drainProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-inflight.Idle():
+			wg.Wait()
+			log.Printf("[%s] Shutdown: all in-flight requests finished after %s",
+				serverID, time.Since(start).Round(time.Millisecond))
+			return 0
+		case <-ticker.C:
+			log.Printf("[%s] Shutdown: waiting for %d in-flight requests [%s]",
+				serverID, inflight.Active(), inflight.Routes())
+		case <-ctx.Done():
+			remaining := inflight.Active()
+			if remaining == 0 {
+				wg.Wait()
+				return 0
+			}
+			log.Printf("[%s] WARNING: Shutdown grace of %s expired with %d requests in flight [%s], forcing exit",
+				serverID, grace, remaining, inflight.Routes())
+			for _, srv := range servers {
+				srv.Close()
+			}
+			wg.Wait()
+			return remaining
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Con una petición lenta en curso, el apagado espera a que termine si le da
+// tiempo la gracia, y si no cierra a la fuerza e informa de las que quedaban.
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		slow      time.Duration
+		grace     time.Duration
+		remaining int
+	}{
+		{name: "within-grace", slow: 200 * time.Millisecond, grace: 2 * time.Second, remaining: 0},
+		{name: "grace-expired", slow: 2 * time.Second, grace: 100 * time.Millisecond, remaining: 1},
+	} {
+		inflight := NewInFlight()
+		release := make(chan struct{})
+		mux := http.NewServeMux()
+		mux.HandleFunc("/reservar", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(tc.slow):
+			case <-release:
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+		ts := httptest.NewServer(inflight.Middleware(mux))
+
+		result := make(chan error, 1)
+		go func() {
+			resp, err := http.Post(ts.URL+"/reservar", "application/json", nil)
+			if err == nil {
+				resp.Body.Close()
+			}
+			result <- err
+		}()
+
+		deadline := time.Now().Add(time.Second)
+		for inflight.Active() != 1 {
+			if time.Now().After(deadline) {
+				close(release)
+				ts.Close()
+				t.Fatalf("%s: the slow request was never counted", tc.name)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if routes := inflight.Routes(); routes != "POST /reservar=1" {
+			close(release)
+			ts.Close()
+			t.Fatalf("%s: expected POST /reservar=1 in flight, got %q", tc.name, routes)
+		}
+
+		start := time.Now()
+		remaining := drainHTTP("node1", []*http.Server{ts.Config}, inflight, tc.grace)
+		elapsed := time.Since(start)
+		close(release)
+		reqErr := <-result
+		ts.Close()
+
+		if remaining != tc.remaining {
+			t.Fatalf("%s: expected %d requests left at shutdown, got %d", tc.name, tc.remaining, remaining)
+		}
+		if tc.remaining == 0 {
+			if elapsed < tc.slow/2 {
+				t.Fatalf("%s: shutdown returned after %s without waiting for the request", tc.name, elapsed)
+			}
+			if reqErr != nil || inflight.Active() != 0 {
+				t.Fatalf("%s: the in-flight request did not complete (err %v, %d active)", tc.name, reqErr, inflight.Active())
+			}
+		} else if elapsed >= tc.slow {
+			t.Fatalf("%s: shutdown waited %s instead of forcing after %s", tc.name, elapsed, tc.grace)
+		}
+	}
+}
@@ -7,11 +7,13 @@ import "sync"
 type LamportClock struct {
 	time int64
 	mu   sync.Mutex
+	// Mayor timestamp recibido de cada proceso, para /internal/clock
+	witnessed map[string]int64
 }
 
 // NewLamportClock crea una nueva instancia de LamportClock.
 func NewLamportClock() *LamportClock {
-	return &LamportClock{time: 0}
+	return &LamportClock{time: 0, witnessed: make(map[string]int64)}
 }
 
 // Increment incrementa el reloj y devuelve el nuevo valor.
@@ -30,6 +32,15 @@ func (c *LamportClock) GetTime() int64 {
 	return c.time
 }
 
+// AdvanceTo adelanta el reloj hasta t si está por detrás. Nunca lo retrasa.
+func (c *LamportClock) AdvanceTo(t int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t > c.time {
+		c.time = t
+	}
+}
+
 // Witness actualiza el reloj del proceso al recibir un timestamp de otro proceso.
 // Esta es la segunda regla del algoritmo de Lamport.
 func (c *LamportClock) Witness(receivedTime int64) int64 {
@@ -40,4 +51,27 @@ func (c *LamportClock) Witness(receivedTime int64) int64 {
 	}
 	c.time++
 	return c.time
-}
\ No newline at end of file
+}
+
+// WitnessFrom es Witness para un timestamp recibido de from, del que además
+// anota el mayor visto. Los envíos van en goroutines independientes, así que
+// un timestamp anterior puede llegar después y no debe hacerlo retroceder.
+func (c *LamportClock) WitnessFrom(from string, receivedTime int64) int64 {
+	c.mu.Lock()
+	if receivedTime > c.witnessed[from] {
+		c.witnessed[from] = receivedTime
+	}
+	c.mu.Unlock()
+	return c.Witness(receivedTime)
+}
+
+// Witnessed devuelve una copia del mayor timestamp recibido de cada proceso
+func (c *LamportClock) Witnessed() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	witnessed := make(map[string]int64, len(c.witnessed))
+	for from, t := range c.witnessed {
+		witnessed[from] = t
+	}
+	return witnessed
+}
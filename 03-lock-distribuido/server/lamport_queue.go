@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Algoritmos de exclusión mutua soportados (ALGORITHM)
+const (
+	AlgorithmRicartAgrawala = "ricart-agrawala"
+	AlgorithmLamportQueue   = "lamport-queue"
+)
+
+// parseAlgorithm valida el valor de ALGORITHM; vacío equivale a ricart-agrawala
+func parseAlgorithm(name string) (string, error) {
+	switch name {
+	case "", AlgorithmRicartAgrawala:
+		return AlgorithmRicartAgrawala, nil
+	case AlgorithmLamportQueue:
+		return AlgorithmLamportQueue, nil
+	case AlgorithmRaft:
+		return AlgorithmRaft, nil
+	default:
+		return "", fmt.Errorf("unknown algorithm %q (expected %q, %q or %q)",
+			name, AlgorithmRicartAgrawala, AlgorithmLamportQueue, AlgorithmRaft)
+	}
+}
+
+// queuedRequest es una petición en la cola de Lamport
+type queuedRequest struct {
+	Timestamp int64  `json:"timestamp"`
+	NodeID    string `json:"node_id"`
+}
+
+// before ordena las peticiones por (timestamp, nodeID)
+func (a queuedRequest) before(b queuedRequest) bool {
+	return a.Timestamp < b.Timestamp || (a.Timestamp == b.Timestamp && a.NodeID < b.NodeID)
+}
+
+// UseLamportQueue cambia el nodo al algoritmo original de Lamport: cada nodo
+// mantiene una cola de peticiones ordenada por (timestamp, nodeID), responde
+// siempre a los REQUEST y anuncia con RELEASE cuando sale de la CS, lo que
+// cuesta 3(N-1) mensajes por entrada frente a los 2(N-1) de Ricart-Agrawala.
+// Debe llamarse antes de arrancar el nodo.
+func (n *Node) UseLamportQueue() {
+	n.Algorithm = AlgorithmLamportQueue
+}
+
+// lamportQueue indica si el nodo usa el algoritmo de cola de Lamport
+func (n *Node) lamportQueue() bool {
+	return n.Algorithm == AlgorithmLamportQueue
+}
+
+// enqueueRequest inserta una petición en la cola en su posición. Un nodo solo
+// tiene una petición pendiente, así que sustituye a la anterior si la hay.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) enqueueRequest(req queuedRequest) {
+	n.dequeueRequest(req.NodeID)
+	i := sort.Search(len(n.queue), func(i int) bool {
+		return req.before(n.queue[i])
+	})
+	n.queue = append(n.queue, queuedRequest{})
+	copy(n.queue[i+1:], n.queue[i:])
+	n.queue[i] = req
+}
+
+// dequeueRequest quita de la cola la petición del nodo indicado.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) dequeueRequest(nodeID string) {
+	for i, req := range n.queue {
+		if req.NodeID == nodeID {
+			n.queue = append(n.queue[:i], n.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// handleLamportMessage procesa un mensaje del algoritmo de cola de Lamport.
+// A diferencia de Ricart-Agrawala, el REPLY nunca viaja en la respuesta HTTP:
+// debe salir por la cola ordenada del peer detrás de los mensajes anteriores.
+func (n *Node) handleLamportMessage(msg Message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch msg.Type {
+	case "REQUEST":
+		n.enqueueRequest(queuedRequest{Timestamp: msg.Timestamp, NodeID: msg.NodeID})
+		n.sendReply(msg.NodeID)
+	case "RELEASE":
+		n.dequeueRequest(msg.NodeID)
+		n.noteBypass(msg.NodeID)
+	}
+
+	// Cualquier mensaje posterior a nuestra petición cuenta como respuesta:
+	// por FIFO, ese peer ya no puede tener una petición anterior en camino
+	if n.State == Wanted && n.RepliesNeeded[msg.NodeID] &&
+		(queuedRequest{Timestamp: n.RequestTime, NodeID: n.ID}).before(queuedRequest{Timestamp: msg.Timestamp, NodeID: msg.NodeID}) {
+		delete(n.RepliesNeeded, msg.NodeID)
+	}
+
+	n.tryEnterLamport()
+}
+
+// tryEnterLamport entra en la CS si nuestra petición encabeza la cola y ya
+// hemos recibido de cada peer un mensaje posterior a ella.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) tryEnterLamport() {
+	if n.State != Wanted || len(n.RepliesNeeded) > 0 {
+		return
+	}
+	if len(n.queue) == 0 || n.queue[0].NodeID != n.ID {
+		n.logf("All peers answered but %d request(s) are ahead in the queue", n.queuePosition())
+		return
+	}
+	n._enterCS()
+}
+
+// queuePosition devuelve cuántas peticiones hay por delante de la nuestra.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) queuePosition() int {
+	for i, req := range n.queue {
+		if req.NodeID == n.ID {
+			return i
+		}
+	}
+	return len(n.queue)
+}
+
+// releaseLamport quita nuestra petición de la cola y avisa a todos los peers
+// con un RELEASE. ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) releaseLamport() {
+	n.dequeueRequest(n.ID)
+	msg := Message{
+		Type:      "RELEASE",
+		Timestamp: n.Clock.Increment(),
+		NodeID:    n.ID,
+		Round:     n.round,
+		Vector:    n.tickVector(),
+	}
+	n.broadcast(n.Peers, msg)
+}
+
+// lamportPeerSuspected olvida la petición encolada de un peer caído (nunca
+// llegará su RELEASE) y deja de esperar su respuesta.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) lamportPeerSuspected(peerID string) {
+	n.dequeueRequest(peerID)
+	if n.State == Wanted && n.RepliesNeeded[peerID] {
+		delete(n.RepliesNeeded, peerID)
+		n.excluded[peerID] = true
+		n.logf("No longer waiting for suspect peer %s. Needed: %d", peerID, len(n.RepliesNeeded))
+	}
+	n.tryEnterLamport()
+}
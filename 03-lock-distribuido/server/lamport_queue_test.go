@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAlgorithmCluster crea un clúster simulado con el algoritmo indicado y
+// sin anuncios HELD, que también cuentan como mensajes
+func newAlgorithmCluster(algorithm string, ids ...string) *SimCluster {
+	c := NewSimCluster(ids...)
+	for _, id := range ids {
+		c.Node(id).Algorithm = algorithm
+		c.Node(id).HeldAnnounceInterval = 0
+	}
+	return c
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                AlgorithmRicartAgrawala,
+		"ricart-agrawala": AlgorithmRicartAgrawala,
+		"lamport-queue":   AlgorithmLamportQueue,
+		"raft":            AlgorithmRaft,
+	} {
+		got, err := parseAlgorithm(in)
+		if err != nil || got != want {
+			t.Errorf("parseAlgorithm(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseAlgorithm("maekawa"); err == nil {
+		t.Error("expected an unknown algorithm to be rejected")
+	}
+}
+
+func TestLamportQueueOrdersByTimestampThenNode(t *testing.T) {
+	node := newSimNode("node1", []string{"node2", "node3", "node4"})
+	node.UseLamportQueue()
+
+	node.mu.Lock()
+	node.enqueueRequest(queuedRequest{Timestamp: 7, NodeID: "node3"})
+	node.enqueueRequest(queuedRequest{Timestamp: 5, NodeID: "node4"})
+	node.enqueueRequest(queuedRequest{Timestamp: 7, NodeID: "node2"})
+	node.enqueueRequest(queuedRequest{Timestamp: 9, NodeID: "node1"})
+	// Una petición nueva del mismo nodo sustituye a la anterior
+	node.enqueueRequest(queuedRequest{Timestamp: 3, NodeID: "node3"})
+	got := append([]queuedRequest(nil), node.queue...)
+	node.mu.Unlock()
+
+	want := []queuedRequest{{3, "node3"}, {5, "node4"}, {7, "node2"}, {9, "node1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected queue %v, got %v", want, got)
+	}
+}
+
+// Con todas las respuestas no basta: el nodo entra cuando su petición
+// encabeza la cola, es decir, cuando llega el RELEASE de la anterior
+func TestLamportQueueWaitsForTheHeadOfTheQueue(t *testing.T) {
+	node, capture := newSequenceNode("node2", "node3")
+	node.UseLamportQueue()
+	node.Clock.AdvanceTo(10)
+	_, done := startRequest(t, node, capture)
+	node.mu.Lock()
+	ts := node.RequestTime
+	node.mu.Unlock()
+
+	// node2 pidió antes; su REQUEST se responde siempre, nunca se pospone
+	inject(t, node, Message{Type: "REQUEST", NodeID: "node2", Timestamp: ts - 1, Seq: 1})
+	if reply := capture.next(t); reply.Type != "REPLY" {
+		t.Fatalf("expected an immediate REPLY to node2, got %+v", reply)
+	}
+	// Un mensaje anterior a nuestra petición no cuenta como respuesta
+	inject(t, node, Message{Type: "REPLY", NodeID: "node3", Timestamp: ts - 1, Seq: 2})
+	expectWaiting(t, node, "node2", "node3")
+
+	inject(t, node, Message{Type: "REPLY", NodeID: "node2", Timestamp: ts + 1, Seq: 3})
+	inject(t, node, Message{Type: "REPLY", NodeID: "node3", Timestamp: ts + 2, Seq: 4})
+	expectWaiting(t, node)
+	if pos := node.DebugState().Queue; len(pos) != 2 || pos[0].NodeID != "node2" {
+		t.Fatalf("expected node2 at the head of the queue, got %v", pos)
+	}
+
+	inject(t, node, Message{Type: "RELEASE", NodeID: "node2", Timestamp: ts + 3, Seq: 5})
+	expectEntered(t, done)
+
+	node.ReleaseCS()
+	for _, peer := range []string{"node2", "node3"} {
+		msg := capture.next(t)
+		if msg.Type != "RELEASE" {
+			t.Fatalf("expected a RELEASE broadcast to %s, got %+v", peer, msg)
+		}
+	}
+	if queue := node.DebugState().Queue; len(queue) != 0 {
+		t.Fatalf("expected an empty queue after releasing, got %v", queue)
+	}
+}
+
+func TestLamportQueueMutualExclusion(t *testing.T) {
+	c := newAlgorithmCluster(AlgorithmLamportQueue, "node1", "node2", "node3")
+	c.Network.Delay = func(from, to string, msg Message) time.Duration {
+		return time.Millisecond
+	}
+
+	if err := runContention(c, 10); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("mutual exclusion violated %d times", v)
+	}
+	if holders := c.Holders(); len(holders) != 0 {
+		t.Fatalf("expected the CS to be free at the end, held by %v", holders)
+	}
+}
+
+// Sin contienda una entrada cuesta 3(N-1) mensajes con la cola de Lamport
+// y 2(N-1) con Ricart-Agrawala
+func TestMessagesPerEntryByAlgorithm(t *testing.T) {
+	for _, tc := range []struct {
+		algorithm string
+		perPeer   uint64
+		types     map[string]uint64
+	}{
+		{AlgorithmRicartAgrawala, 2, map[string]uint64{"REQUEST": 4, "REPLY": 4}},
+		{AlgorithmLamportQueue, 3, map[string]uint64{"REQUEST": 4, "REPLY": 4, "RELEASE": 4}},
+	} {
+		c := newAlgorithmCluster(tc.algorithm, "node1", "node2", "node3", "node4", "node5")
+		for i := 0; i < 2; i++ {
+			if err := c.Enter("node1", time.Second); err != nil {
+				t.Fatal(err)
+			}
+			c.Exit("node1")
+		}
+		// El RELEASE sale en segundo plano: esperar a que se entreguen
+		want := 2 * tc.perPeer * 4
+		deadline := time.Now().Add(time.Second)
+		for c.MessagesSent() < want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if sent := c.MessagesSent(); sent != want {
+			t.Fatalf("%s: 2 entries cost %d messages, want %d", tc.algorithm, sent, want)
+		}
+
+		sent := make(map[string]uint64)
+		for _, id := range []string{"node1", "node2", "node3", "node4", "node5"} {
+			for typ, n := range c.Node(id).MessageStats().Sent {
+				sent[typ] += n / 2
+			}
+		}
+		if !reflect.DeepEqual(sent, tc.types) {
+			t.Fatalf("%s: expected %v messages per entry, got %v", tc.algorithm, tc.types, sent)
+		}
+	}
+}
+
+// /metrics etiqueta los contadores con el algoritmo para poder compararlos
+func TestMetricsLabelTheAlgorithm(t *testing.T) {
+	c := newAlgorithmCluster(AlgorithmLamportQueue, "node1", "node2")
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+	deadline := time.Now().Add(time.Second)
+	for c.Node("node1").MessageStats().Sent["RELEASE"] == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	var buf bytes.Buffer
+	c.Node("node1").MessageStats().WritePrometheus(&buf, "node1")
+	out := buf.String()
+	for _, typ := range []string{"REQUEST", "RELEASE"} {
+		line := fmt.Sprintf(`dme_messages_sent_total{node="node1",algorithm="lamport-queue",peer="node2",type=%q} 1`, typ)
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in the metrics, got:\n%s", line, out)
+		}
+	}
+	if !strings.Contains(out, `dme_cs_entries_total{node="node1",algorithm="lamport-queue"} 1`) {
+		t.Errorf("expected one CS entry labelled lamport-queue, got:\n%s", out)
+	}
+}
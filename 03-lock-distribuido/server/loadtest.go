@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Generador de carga para el laboratorio: POST /admin/loadtest lanza workers
+// que reservan un asiento al azar y, si lo consiguen, lo vuelven a liberar,
+// durante el tiempo indicado, y devuelve un informe. Por defecto llama
+// directamente a los handlers de este nodo; con ?target=peer lo hace por HTTP
+// contra un peer (?peer=ID, o el primero), para comparar el coste local con
+// el remoto. Solo libera los asientos que él mismo reservó. POST
+// /admin/loadtest/cancel detiene la prueba en curso: cada worker termina la
+// operación que tiene en marcha, y libera el asiento si acaba de reservarlo,
+// y la prueba responde con lo medido hasta entonces.
+
+// Límites de una prueba de carga
+const (
+	defaultLoadWorkers  = 4
+	maxLoadWorkers      = 64
+	defaultLoadDuration = 10 * time.Second
+	maxLoadDuration     = 5 * time.Minute
+	// Pausa de un worker tras un error, para no girar en vacío si el destino
+	// rechaza todo (en pausa, sin asientos, caído)
+	loadErrorBackoff = 50 * time.Millisecond
+)
+
+// Destinos de la carga
+const (
+	LoadTargetLocal = "local"
+	LoadTargetPeer  = "peer"
+)
+
+// LoadTestReport es el resultado de /admin/loadtest. Las latencias son las
+// de cada operación (reservar o liberar) y los mensajes, los que este nodo
+// envió durante la prueba.
+type LoadTestReport struct {
+	Target       string  `json:"target"`
+	Peer         string  `json:"peer,omitempty"`
+	Workers      int     `json:"workers"`
+	Asientos     int     `json:"asientos"`
+	DurationMs   int64   `json:"duration_ms"`
+	Cancelled    bool    `json:"cancelled"`
+	Attempted    int     `json:"attempted"`
+	Succeeded    int     `json:"succeeded"`
+	Conflicts    int     `json:"conflicts"`
+	Errors       int     `json:"errors"`
+	MeanMs       float64 `json:"mean_latency_ms"`
+	P95Ms        float64 `json:"p95_latency_ms"`
+	MessagesSent uint64  `json:"messages_sent"`
+	ServerID     string  `json:"server_id"`
+}
+
+// loadOp ejecuta una operación contra el destino y devuelve su código HTTP
+type loadOp func(ctx context.Context, path string, body interface{}) (int, error)
+
+// loadTally acumula los resultados de los workers
+type loadTally struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	succeeded int
+	conflicts int
+	errors    int
+}
+
+func (t *loadTally) record(status int, err error, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.latencies = append(t.latencies, latency)
+	switch {
+	case err != nil:
+		t.errors++
+	case status == http.StatusOK:
+		t.succeeded++
+	case status == http.StatusConflict:
+		t.conflicts++
+	default:
+		t.errors++
+	}
+}
+
+// fill vuelca el recuento y las latencias en el informe
+func (t *loadTally) fill(report *LoadTestReport) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report.Attempted = len(t.latencies)
+	report.Succeeded, report.Conflicts, report.Errors = t.succeeded, t.conflicts, t.errors
+	if len(t.latencies) == 0 {
+		return
+	}
+	sort.Slice(t.latencies, func(i, j int) bool { return t.latencies[i] < t.latencies[j] })
+	var total time.Duration
+	for _, latency := range t.latencies {
+		total += latency
+	}
+	report.MeanMs = durationMs(total / time.Duration(len(t.latencies)))
+	report.P95Ms = durationMs(t.latencies[(len(t.latencies)*95+99)/100-1])
+}
+
+// runLoad lanza workers contra op hasta que se cancele ctx. Cada worker
+// reserva un asiento al azar entre 1 y asientos y, si lo consigue, lo libera.
+// Las operaciones no se cortan a medias: una reserva interrumpida podría
+// quedar hecha sin que el worker lo sepa, y el asiento sin liberar.
+func runLoad(ctx context.Context, op loadOp, workers, asientos int, cliente string, seed int64) *loadTally {
+	tally := &loadTally{}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed + int64(worker)))
+			owner := fmt.Sprintf("%s-w%d", cliente, worker)
+			for ctx.Err() == nil {
+				numero := rng.Intn(asientos) + 1
+				switch runLoadOp(tally, op, "/reservar", map[string]interface{}{"numero": numero, "cliente": owner}) {
+				case http.StatusOK:
+					// Liberar aunque se haya cancelado la prueba: el asiento es nuestro
+					runLoadOp(tally, op, "/liberar", map[string]interface{}{"numero": numero})
+				case http.StatusConflict:
+				default:
+					select {
+					case <-ctx.Done():
+					case <-time.After(loadErrorBackoff):
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	return tally
+}
+
+// runLoadOp mide y anota una operación y devuelve su código HTTP (0 si
+// falló antes de tener respuesta)
+func runLoadOp(tally *loadTally, op loadOp, path string, body interface{}) int {
+	start := time.Now()
+	status, err := op(context.Background(), path, body)
+	tally.record(status, err, time.Since(start))
+	return status
+}
+
+// localLoadOp llama a los handlers de este nodo, con el plazo de la ruta y
+// las mismas comprobaciones de pausa y de límite de reservas
+func (s *Server) localLoadOp() loadOp {
+	handlers := map[string]http.HandlerFunc{
+		"/reservar": s.rejectWhilePaused(s.limitReservations(s.handleReservarAsiento)),
+		"/liberar":  s.rejectWhilePaused(s.limitReservations(s.handleLiberarAsiento)),
+	}
+	return func(ctx context.Context, path string, body interface{}) (int, error) {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		opCtx, cancel := context.WithTimeout(ctx, reservationTimeout)
+		defer cancel()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload)).WithContext(opCtx)
+		rec := httptest.NewRecorder()
+		handlers[path](rec, req)
+		// El handler vuelve sin responder si venció el plazo
+		if err := opCtx.Err(); err != nil {
+			return 0, err
+		}
+		return rec.Code, nil
+	}
+}
+
+// peerLoadOp llama por HTTP a los endpoints públicos de baseURL
+func (s *Server) peerLoadOp(baseURL string) loadOp {
+	client := &http.Client{Timeout: reservationTimeout + time.Second, Transport: s.node.client.Transport}
+	return func(ctx context.Context, path string, body interface{}) (int, error) {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+path, bytes.NewReader(payload))
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+}
+
+// startLoadTest registra la prueba en curso. Devuelve false si ya hay una.
+func (s *Server) startLoadTest(cancel context.CancelFunc) bool {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	if s.loadCancel != nil {
+		return false
+	}
+	s.loadCancel = cancel
+	return true
+}
+
+func (s *Server) finishLoadTest() {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	s.loadCancel = nil
+}
+
+// CancelLoadTest detiene la prueba en curso. Devuelve false si no hay ninguna.
+func (s *Server) CancelLoadTest() bool {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	if s.loadCancel == nil {
+		return false
+	}
+	s.loadCancel()
+	return true
+}
+
+// handleLoadTest ejecuta una prueba de carga ({workers, duration_ms,
+// asientos}, todos opcionales) y responde con su informe al terminar
+func (s *Server) handleLoadTest(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Workers    int   `json:"workers"`
+		DurationMs int64 `json:"duration_ms"`
+		// Los asientos van del 1 a Asientos; 0 = todos los de la BD
+		Asientos int `json:"asientos"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httperr.WriteDecodeError(w, err)
+			return
+		}
+	}
+	if req.Workers == 0 {
+		req.Workers = defaultLoadWorkers
+	}
+	duration := time.Duration(req.DurationMs) * time.Millisecond
+	if duration == 0 {
+		duration = defaultLoadDuration
+	}
+	if req.Workers < 0 || req.Workers > maxLoadWorkers || duration < 0 || duration > maxLoadDuration || req.Asientos < 0 {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest,
+			fmt.Sprintf("workers must be 1-%d, duration_ms 1-%d and asientos non-negative",
+				maxLoadWorkers, maxLoadDuration.Milliseconds()))
+		return
+	}
+	if req.Asientos == 0 {
+		req.Asientos = int(s.countSeats(s.collection))
+		if req.Asientos == 0 {
+			httperr.Write(w, http.StatusServiceUnavailable, CodeNotReady, "No seats to load")
+			return
+		}
+	}
+
+	report := LoadTestReport{Workers: req.Workers, Asientos: req.Asientos, ServerID: s.serverID}
+	var op loadOp
+	switch target := r.URL.Query().Get("target"); target {
+	case "", LoadTargetLocal:
+		report.Target = LoadTargetLocal
+		op = s.localLoadOp()
+	case LoadTargetPeer:
+		report.Target = LoadTargetPeer
+		urls := s.node.PeerURLs()
+		report.Peer = r.URL.Query().Get("peer")
+		if report.Peer == "" {
+			if peers := s.node.PeerList(); len(peers) > 0 {
+				report.Peer = peers[0]
+			}
+		}
+		baseURL, ok := urls[report.Peer]
+		if !ok {
+			httperr.Write(w, http.StatusNotFound, CodeUnknownPeer, fmt.Sprintf("Unknown peer %q", report.Peer))
+			return
+		}
+		op = s.peerLoadOp(baseURL)
+	default:
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "target must be local or peer")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), duration)
+	defer cancel()
+	if !s.startLoadTest(cancel) {
+		httperr.Write(w, http.StatusConflict, CodeLoadTestRunning, "A load test is already running")
+		return
+	}
+	defer s.finishLoadTest()
+
+	s.node.logf("Load test started: %d workers against %s %s for %s over %d seats",
+		req.Workers, report.Target, report.Peer, duration, req.Asientos)
+	sentBefore := s.node.MessageStats().TotalSent
+	start := time.Now()
+	tally := runLoad(ctx, op, req.Workers, req.Asientos, "loadtest-"+s.serverID, start.UnixNano())
+	report.DurationMs = time.Since(start).Milliseconds()
+	report.MessagesSent = s.node.MessageStats().TotalSent - sentBefore
+	report.Cancelled = ctx.Err() == context.Canceled
+	tally.fill(&report)
+	s.node.logf("Load test finished: %d operations, %d ok, %d conflicts, %d errors, p95 %.1f ms, %d messages sent",
+		report.Attempted, report.Succeeded, report.Conflicts, report.Errors, report.P95Ms, report.MessagesSent)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleLoadTestCancel detiene la prueba de carga en curso
+func (s *Server) handleLoadTestCancel(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if !s.CancelLoadTest() {
+		httperr.Write(w, http.StatusNotFound, httperr.CodeNotFound, "No load test is running")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"server_id": s.serverID,
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySeats es una sala en memoria que responde como /reservar y /liberar
+type memorySeats struct {
+	mu       sync.Mutex
+	owners   map[int]string
+	reserved int
+	freed    int
+}
+
+func newMemorySeats() *memorySeats {
+	return &memorySeats{owners: make(map[int]string)}
+}
+
+func (m *memorySeats) op(ctx context.Context, path string, body interface{}) (int, error) {
+	payload, _ := json.Marshal(body)
+	var req struct {
+		Numero  int    `json:"numero"`
+		Cliente string `json:"cliente"`
+	}
+	json.Unmarshal(payload, &req)
+	// Una operación lleva algo de tiempo, como la CS y la BD
+	time.Sleep(time.Millisecond)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch path {
+	case "/reservar":
+		if _, taken := m.owners[req.Numero]; taken {
+			return http.StatusConflict, nil
+		}
+		m.owners[req.Numero] = req.Cliente
+		m.reserved++
+	case "/liberar":
+		if _, taken := m.owners[req.Numero]; !taken {
+			return http.StatusConflict, nil
+		}
+		delete(m.owners, req.Numero)
+		m.freed++
+	default:
+		return http.StatusNotFound, nil
+	}
+	return http.StatusOK, nil
+}
+
+func (m *memorySeats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	status, _ := m.op(r.Context(), r.URL.Path, body)
+	w.WriteHeader(status)
+}
+
+func (m *memorySeats) counts() (reserved, freed, held int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reserved, m.freed, len(m.owners)
+}
+
+// Cada worker libera lo que reserva, también al cancelarse la prueba, y las
+// reservas que se pisan cuentan como conflictos
+func TestRunLoadFreesEverySeatItReserves(t *testing.T) {
+	seats := newMemorySeats()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	tally := runLoad(ctx, seats.op, 4, 3, "loadtest-node1", 1)
+
+	var report LoadTestReport
+	tally.fill(&report)
+	reserved, freed, held := seats.counts()
+	if held != 0 || reserved != freed {
+		t.Fatalf("expected every reservation freed, reserved %d freed %d, %d still held", reserved, freed, held)
+	}
+	if report.Succeeded != reserved+freed || report.Errors != 0 {
+		t.Fatalf("expected %d successful operations and no errors, got %+v", reserved+freed, report)
+	}
+	if report.Conflicts == 0 {
+		t.Fatalf("expected 4 workers on 3 seats to conflict, got %+v", report)
+	}
+	if report.Attempted != report.Succeeded+report.Conflicts {
+		t.Fatalf("expected attempted = succeeded + conflicts, got %+v", report)
+	}
+}
+
+func TestLoadTallyLatencyAndOutcomes(t *testing.T) {
+	tally := &loadTally{}
+	// Se anotan desordenadas: fill ordena antes del percentil
+	for i := 100; i >= 1; i-- {
+		status := http.StatusOK
+		switch i % 10 {
+		case 0:
+			status = http.StatusConflict
+		case 5:
+			status = http.StatusServiceUnavailable
+		}
+		tally.record(status, nil, time.Duration(i)*time.Millisecond)
+	}
+	tally.record(0, context.DeadlineExceeded, 101*time.Millisecond)
+
+	var report LoadTestReport
+	tally.fill(&report)
+	if report.Attempted != 101 || report.Succeeded != 80 || report.Conflicts != 10 || report.Errors != 11 {
+		t.Fatalf("unexpected outcomes: %+v", report)
+	}
+	if report.MeanMs != 51 || report.P95Ms != 96 {
+		t.Fatalf("expected mean 51ms and p95 96ms, got %.2f and %.2f", report.MeanMs, report.P95Ms)
+	}
+
+	// Sin operaciones no hay latencias que calcular
+	report = LoadTestReport{}
+	(&loadTally{}).fill(&report)
+	if report.Attempted != 0 || report.MeanMs != 0 || report.P95Ms != 0 {
+		t.Fatalf("expected an empty report, got %+v", report)
+	}
+}
+
+// loadTestRequest llama a /admin/loadtest con el token del servidor
+func loadTestRequest(s *Server, query, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/admin/loadtest"+query, strings.NewReader(body))
+	req.Header.Set("X-Admin-Token", s.adminToken)
+	rec := httptest.NewRecorder()
+	s.handleLoadTest(rec, req)
+	return rec
+}
+
+// Una prueba contra un peer va por HTTP, no admite otra a la vez y se
+// detiene con /admin/loadtest/cancel devolviendo lo medido hasta entonces
+func TestLoadTestAgainstAPeerStopsOnCancel(t *testing.T) {
+	seats := newMemorySeats()
+	peer := httptest.NewServer(seats)
+	defer peer.Close()
+
+	node := newSimNode("node1", nil)
+	node.ReplacePeers(map[string]string{"node2": peer.URL})
+	s := &Server{node: node, serverID: "node1", adminToken: "secreto"}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- loadTestRequest(s, "?target=peer", `{"workers":3,"duration_ms":30000,"asientos":5}`)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for reserved, _, _ := seats.counts(); reserved == 0; reserved, _, _ = seats.counts() {
+		if time.Now().After(deadline) {
+			t.Fatal("the load never reached the peer")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if rec := loadTestRequest(s, "", `{"asientos":5}`); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), CodeLoadTestRunning) {
+		t.Fatalf("expected a second load test to be rejected with %s, got %d: %s", CodeLoadTestRunning, rec.Code, rec.Body)
+	}
+
+	rec := httptest.NewRecorder()
+	cancelReq := httptest.NewRequest(http.MethodPost, "/admin/loadtest/cancel", nil)
+	cancelReq.Header.Set("X-Admin-Token", "secreto")
+	s.handleLoadTestCancel(rec, cancelReq)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the cancel to succeed, got %d: %s", rec.Code, rec.Body)
+	}
+
+	var res *httptest.ResponseRecorder
+	select {
+	case res = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the load test did not stop after the cancel")
+	}
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the report after the cancel, got %d: %s", res.Code, res.Body)
+	}
+	var report LoadTestReport
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Cancelled || report.Target != LoadTargetPeer || report.Peer != "node2" || report.Workers != 3 {
+		t.Fatalf("expected a cancelled peer test against node2, got %+v", report)
+	}
+	if report.DurationMs >= 30000 || report.Attempted == 0 || report.Errors != 0 {
+		t.Fatalf("expected a short run with operations and no errors, got %+v", report)
+	}
+	// La carga pasó por el peer: este nodo no envió mensajes del algoritmo
+	if report.MessagesSent != 0 {
+		t.Fatalf("expected no messages sent by node1, got %d", report.MessagesSent)
+	}
+	if _, _, held := seats.counts(); held != 0 {
+		t.Fatalf("expected the cancelled workers to free their seats, %d still held", held)
+	}
+
+	// Ya no hay prueba que cancelar
+	rec = httptest.NewRecorder()
+	s.handleLoadTestCancel(rec, cancelReq)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with no load test running, got %d", rec.Code)
+	}
+}
+
+func TestLoadTestValidation(t *testing.T) {
+	node := newSimNode("node1", nil)
+	node.ReplacePeers(map[string]string{"node2": "http://127.0.0.1:1"})
+	s := &Server{node: node, serverID: "node1"}
+
+	if rec := loadTestRequest(s, "", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without ADMIN_TOKEN, got %d", rec.Code)
+	}
+	s.adminToken = "secreto"
+	for _, tc := range []struct {
+		query, body string
+		want        int
+	}{
+		{"", `{"workers":1000}`, http.StatusBadRequest},
+		{"", `{"duration_ms":-1}`, http.StatusBadRequest},
+		{"", `{"asientos":-3}`, http.StatusBadRequest},
+		{"", `{"workers":`, http.StatusBadRequest},
+		{"?target=moon", `{"asientos":5}`, http.StatusBadRequest},
+		{"?target=peer&peer=node9", `{"asientos":5}`, http.StatusNotFound},
+	} {
+		if rec := loadTestRequest(s, tc.query, tc.body); rec.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d: %s", tc.query, tc.body, tc.want, rec.Code, rec.Body)
+		}
+	}
+	if stats := node.MessageStats(); stats.TotalSent != 0 {
+		t.Fatalf("expected rejected load tests to send nothing, sent %d", stats.TotalSent)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// committedTS devuelve el logical_ts de la respuesta y comprueba que es el
+// que se escribió en el asiento
+func committedTS(t *testing.T, mt *mtest.T, rec *httptest.ResponseRecorder) int64 {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		LogicalTS int64 `json:"logical_ts"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	var stored int64 = -1
+	for _, ev := range mt.GetAllStartedEvents() {
+		if ev.CommandName == "update" {
+			stored = ev.Command.Lookup("updates", "0", "u", "$set", "logical_ts").Int64()
+		}
+	}
+	mt.ClearEvents()
+	if resp.LogicalTS == 0 || stored != resp.LogicalTS {
+		t.Fatalf("expected the response logical_ts to be the stored one, got %d and %d", resp.LogicalTS, stored)
+	}
+	return resp.LogicalTS
+}
+
+// Reservas y liberaciones en servidores distintos, una tras otra, llevan
+// relojes de Lamport crecientes: la CS las ordena por happened-before
+func TestCommittedOperationsCarryIncreasingLogicalTimestamps(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		c := NewSimCluster("node1", "node2")
+		servers := map[string]*Server{}
+		for _, id := range []string{"node1", "node2"} {
+			c.Node(id).HeldAnnounceInterval = 0
+			servers[id] = NewServer(c.Node(id), mt.Coll, NewAuditLog(mt.Coll), id)
+		}
+		seat := func(numero int, disponible bool, cliente string) bson.D {
+			return bson.D{{Key: "numero", Value: numero}, {Key: "disponible", Value: disponible}, {Key: "cliente", Value: cliente}}
+		}
+		written := func(doc bson.D) {
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "test.asientos", mtest.FirstBatch, doc),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			)
+		}
+
+		var last int64
+		for _, op := range []struct {
+			server, path, body string
+			seat               bson.D
+		}{
+			{"node1", "/reservar", `{"numero":1,"cliente":"ana"}`, seat(1, true, "")},
+			{"node2", "/reservar", `{"numero":2,"cliente":"luis"}`, seat(2, true, "")},
+			{"node1", "/liberar", `{"numero":1}`, seat(1, false, "ana")},
+			{"node2", "/liberar", `{"numero":2}`, seat(2, false, "luis")},
+		} {
+			written(op.seat)
+			s := servers[op.server]
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, op.path, strings.NewReader(op.body))
+			if op.path == "/reservar" {
+				s.handleReservarAsiento(rec, req)
+			} else {
+				s.handleLiberarAsiento(rec, req)
+			}
+			ts := committedTS(t, mt, rec)
+			if ts <= last {
+				t.Fatalf("%s %s committed at ts=%d, not after the previous operation at ts=%d", op.server, op.path, ts, last)
+			}
+			last = ts
+		}
+	})
+}
+
+// GET /asientos devuelve el logical_ts guardado en cada asiento
+func TestGetAsientosReturnsLogicalTimestamps(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		c := NewSimCluster("node1", "node2")
+		s := NewServer(c.Node("node1"), mt.Coll, NewAuditLog(mt.Coll), "node1")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.asientos", mtest.FirstBatch,
+			bson.D{{Key: "numero", Value: 1}, {Key: "disponible", Value: false}, {Key: "cliente", Value: "ana"}, {Key: "logical_ts", Value: int64(7)}},
+			bson.D{{Key: "numero", Value: 2}, {Key: "disponible", Value: true}, {Key: "logical_ts", Value: int64(12)}},
+		))
+
+		rec := httptest.NewRecorder()
+		s.handleGetAsientos(rec, httptest.NewRequest(http.MethodGet, "/asientos", nil))
+		var resp struct {
+			Asientos []Asiento `json:"asientos"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Asientos) != 2 || resp.Asientos[0].LogicalTS != 7 || resp.Asientos[1].LogicalTS != 12 {
+			t.Fatalf("expected logical_ts 7 and 12, got %+v", resp.Asientos)
+		}
+	})
+}
+
+// Las líneas del algoritmo llevan el reloj del nodo como [ts=NN]; el de quien
+// recibe un mensaje ya es posterior al timestamp del mensaje
+func TestAlgorithmLogsCarryTheLamportClock(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(out)
+
+	c := NewSimCluster("node1", "node2")
+	c.Node("node1").HeldAnnounceInterval = 0
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+	// Restaurar la salida antes de leer: el log deja de escribir en buf
+	log.SetOutput(out)
+	logs := buf.String()
+
+	entering := regexp.MustCompile(`\[node1\] \[ts=(\d+)\] Entering critical section`).FindStringSubmatch(logs)
+	if entering == nil {
+		t.Fatalf("expected a [ts=NN] prefix on the CS entry, got:\n%s", logs)
+	}
+	received := regexp.MustCompile(`\[node2\] \[ts=(\d+)\] Received REQUEST message from node1 \(timestamp: (\d+)\)`).FindStringSubmatch(logs)
+	if received == nil {
+		t.Fatalf("expected a [ts=NN] prefix on the received REQUEST, got:\n%s", logs)
+	}
+	clock, _ := strconv.Atoi(received[1])
+	sent, _ := strconv.Atoi(received[2])
+	if clock <= sent {
+		t.Fatalf("node2 logged ts=%d for a REQUEST sent at %d", clock, sent)
+	}
+}
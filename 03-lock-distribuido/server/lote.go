@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"github.com/sincronizacion-distribuida/shared/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxLote limita los asientos de una reserva por lotes: todos se reservan
+// dentro de una sola entrada en la CS, que bloquea al resto del clúster
+const maxLote = 50
+
+// ResultadoLote es el resultado de un asiento dentro de /reservar-lote
+type ResultadoLote struct {
+	Numero  int    `json:"numero"`
+	Success bool   `json:"success"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+	// Reloj de Lamport con el que se confirmó la reserva
+	LogicalTS int64 `json:"logical_ts,omitempty"`
+}
+
+// RespuestaLote es la respuesta de /reservar-lote. Error solo aparece cuando
+// un lote atómico se anula.
+type RespuestaLote struct {
+	Success    bool            `json:"success"`
+	Atomico    bool            `json:"atomico"`
+	Reservados int             `json:"reservados"`
+	Resultados []ResultadoLote `json:"resultados"`
+	ServerID   string          `json:"server_id"`
+	Error      *httperr.Body   `json:"error,omitempty"`
+	// Asientos que el lote anulado no pudo liberar y quedaron reservados
+	PendientesReconciliacion []int `json:"pendientes_reconciliacion,omitempty"`
+}
+
+// loteStore es lo que la reserva por lotes necesita de la BD
+type loteStore interface {
+	// Comprobar indica si un asiento del lote se puede reservar
+	Comprobar(ctx context.Context, numero int) ResultadoLote
+	// Reservar deja ocupado por cliente un asiento que Comprobar dio por
+	// libre y devuelve el reloj de Lamport con el que se confirmó
+	Reservar(ctx context.Context, numero int, cliente string) (int64, error)
+	// Liberar deshace la reserva de un asiento hecha por el mismo lote
+	Liberar(ctx context.Context, numero int, cliente string) error
+}
+
+// handleReservarLote reserva varios asientos para un cliente con una sola
+// entrada en la sección crítica. Entrar cuesta 2(N-1) mensajes, así que
+// reservar el lote de una vez es mucho más barato que un /reservar por
+// asiento. La CS es única para todo el clúster, de modo que no hace falta
+// ordenar los asientos para evitar interbloqueos.
+//
+// Sin atomico, un asiento ocupado no impide reservar los demás; con
+// atomico: true el lote se reserva entero o no se reserva ninguno.
+func (s *Server) handleReservarLote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	var req struct {
+		Numeros []int  `json:"numeros"`
+		Cliente string `json:"cliente"`
+		Atomico bool   `json:"atomico"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+	if req.Cliente == "" {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Cliente is required")
+		return
+	}
+	if len(req.Numeros) == 0 || len(req.Numeros) > maxLote {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, fmt.Sprintf("numeros must contain between 1 and %d seats", maxLote))
+		return
+	}
+	vistos := make(map[int]bool, len(req.Numeros))
+	for _, numero := range req.Numeros {
+		if vistos[numero] {
+			httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, fmt.Sprintf("Seat %d appears more than once", numero))
+			return
+		}
+		vistos[numero] = true
+	}
+
+	log.Printf("[%s] Requesting CS to reserve %d seats for %s (atomic=%t)", s.serverID, len(req.Numeros), req.Cliente, req.Atomico)
+	release, err := s.acquireCS(WithCSResource(r.Context(), fmt.Sprintf("lote de %d asientos", len(req.Numeros))), csWaitTimeout)
+	if err != nil {
+		log.Printf("[%s] Gave up waiting for CS to reserve a batch of %d seats: %v", s.serverID, len(req.Numeros), err)
+		httperr.Write(w, http.StatusGatewayTimeout, CodeCSTimeout, "Timeout acquiring distributed lock")
+		return
+	}
+	defer release()
+
+	if err := r.Context().Err(); err != nil {
+		log.Printf("[%s] Client abandoned or timed out batch reservation, not reserving: %v", s.serverID, err)
+		return
+	}
+
+	resp := s.reservarLote(r.Context(), mongoLoteStore{s: s}, req.Numeros, req.Cliente, req.Atomico)
+	log.Printf("[%s] Batch reservation for %s: %d/%d seats reserved", s.serverID, req.Cliente, resp.Reservados, len(req.Numeros))
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Error != nil {
+		resp.Error.RequestID = w.Header().Get(httperr.RequestIDHeader)
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// reservarLote hace las reservas del lote. Debe llamarse dentro de la CS.
+func (s *Server) reservarLote(ctx context.Context, store loteStore, numeros []int, cliente string, atomico bool) RespuestaLote {
+	resp := RespuestaLote{Atomico: atomico, ServerID: s.serverID, Resultados: make([]ResultadoLote, len(numeros))}
+
+	// Dentro de la CS nadie más modifica los asientos, así que lo leído
+	// aquí sigue valiendo al actualizar
+	libres := 0
+	for i, numero := range numeros {
+		resp.Resultados[i] = store.Comprobar(ctx, numero)
+		if resp.Resultados[i].Success {
+			libres++
+		}
+	}
+
+	if atomico && libres < len(numeros) {
+		for i := range resp.Resultados {
+			if resp.Resultados[i].Success {
+				resp.Resultados[i] = ResultadoLote{Numero: numeros[i], Code: CodeBatchAborted, Message: "Lote anulado"}
+			}
+		}
+		resp.Error = &httperr.Body{Code: CodeBatchAborted, Message: "Algún asiento del lote no está disponible; no se reservó ninguno"}
+		return resp
+	}
+
+	var hechos []int
+	for i, numero := range numeros {
+		if !resp.Resultados[i].Success {
+			continue
+		}
+		logicalTS, err := store.Reservar(ctx, numero, cliente)
+		if err != nil {
+			log.Printf("[%s] Failed to update seat %d in batch: %v", s.serverID, numero, err)
+			resp.Resultados[i] = ResultadoLote{Numero: numero, Code: CodeDatabaseError, Message: "Failed to update seat"}
+			if atomico {
+				s.abortarLote(ctx, store, &resp, hechos, cliente, numero, numeros)
+				return resp
+			}
+			continue
+		}
+		hechos = append(hechos, numero)
+		resp.Resultados[i].Message = "Asiento reservado exitosamente"
+		resp.Resultados[i].LogicalTS = logicalTS
+	}
+
+	resp.Reservados = len(hechos)
+	resp.Success = resp.Reservados == len(numeros)
+	return resp
+}
+
+// mongoLoteStore implementa loteStore sobre la colección de asientos
+type mongoLoteStore struct {
+	s *Server
+}
+
+func (m mongoLoteStore) Comprobar(ctx context.Context, numero int) ResultadoLote {
+	var asiento Asiento
+	err := m.s.collection.FindOne(ctx, bson.M{"numero": numero}).Decode(&asiento)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return ResultadoLote{Numero: numero, Code: CodeSeatNotFound, Message: "Asiento no encontrado"}
+	case err != nil:
+		return ResultadoLote{Numero: numero, Code: CodeDatabaseError, Message: "Failed to fetch seat"}
+	case !asiento.Disponible:
+		return ResultadoLote{Numero: numero, Code: CodeSeatTaken, Message: "Asiento ya está ocupado"}
+	}
+	return ResultadoLote{Numero: numero, Success: true}
+}
+
+func (m mongoLoteStore) Reservar(ctx context.Context, numero int, cliente string) (int64, error) {
+	return m.s.aplicarOperacion(ctx, asientoLibre(numero), false, cliente, "")
+}
+
+func (m mongoLoteStore) Liberar(ctx context.Context, numero int, cliente string) error {
+	reservado := Asiento{AsientoBase: models.AsientoBase{AsientoEstado: models.AsientoEstado{Numero: numero, Cliente: cliente}}}
+	_, err := m.s.aplicarOperacion(ctx, reservado, true, "", "")
+	return err
+}
+
+// asientoLibre es el estado de un asiento que comprobarAsiento dio por libre
+func asientoLibre(numero int) Asiento {
+	return Asiento{AsientoBase: models.AsientoBase{AsientoEstado: models.AsientoEstado{Numero: numero, Disponible: true}}}
+}
+
+// marcarAsiento deja un asiento ocupado por cliente o libre y devuelve el
+// reloj de Lamport con el que se confirmó y el ID de su SeatOp. Con previo el
+// asiento guarda la SeatOp hasta la siguiente escritura; sin él (al deshacer
+// una operación sin marca) la borra.
+func (s *Server) marcarAsiento(ctx context.Context, numero int, disponible bool, cliente string, previo *Asiento) (int64, string, error) {
+	logicalTS := s.node.Clock.Increment()
+	set := bson.M{
+		"disponible": disponible,
+		"cliente":    cliente,
+		"server_id":  s.serverID,
+		"updated_at": time.Now(),
+		"logical_ts": logicalTS,
+	}
+	update := bson.M{"$set": set}
+	opID := ""
+	if previo != nil {
+		op := newSeatOp(s.serverID, logicalTS, disponible, *previo)
+		set["op"] = op
+		opID = op.ID
+	} else {
+		update["$unset"] = bson.M{"op": ""}
+	}
+	_, err := s.collection.UpdateOne(ctx, bson.M{"numero": numero}, update)
+	return logicalTS, opID, err
+}
+
+// aplicarOperacion reserva (disponible false) o libera el asiento previo y
+// escribe su entrada de auditoría, que marca la operación como terminada. Si
+// la entrada no se puede escribir la operación no cuenta: se deshace aquí, o
+// la deshará la reconciliación si el nodo cae antes. Debe llamarse dentro de
+// la CS, con el contexto de la petición que la tiene.
+func (s *Server) aplicarOperacion(ctx context.Context, previo Asiento, disponible bool, cliente, onBehalfOf string) (int64, error) {
+	numero := previo.Numero
+	logicalTS, opID, err := s.marcarAsiento(ctx, numero, disponible, cliente, &previo)
+	if err != nil {
+		return logicalTS, err
+	}
+
+	operacion, auditCliente := OpReservar, cliente
+	if disponible {
+		operacion, auditCliente = OpLiberar, previo.Cliente
+	}
+	if err := s.recordAudit(ctx, operacion, numero, auditCliente, onBehalfOf, opID); err != nil {
+		undoCtx, cancel := undoContext(ctx)
+		defer cancel()
+		if _, _, undoErr := s.marcarAsiento(undoCtx, numero, previo.Disponible, previo.Cliente, nil); undoErr != nil {
+			log.Printf("[%s] CRITICAL: could not undo unmarked %s of seat %d, recovery will roll it back on restart: %v",
+				s.serverID, operacion, numero, undoErr)
+		}
+		return logicalTS, err
+	}
+	return logicalTS, nil
+}
+
+// abortarLote anula un lote atómico cuya escritura del asiento fallido
+// falló después de reservar los de hechos: los deshace y deja en resp el
+// error con el asiento que falló y los que no se pudieron liberar.
+func (s *Server) abortarLote(ctx context.Context, store loteStore, resp *RespuestaLote, hechos []int, cliente string, fallido int, numeros []int) {
+	// Lo reservado se deshace aunque haya vencido el plazo de la petición
+	undoCtx, cancel := undoContext(ctx)
+	defer cancel()
+	pendientes := s.deshacerLote(undoCtx, store, hechos, cliente)
+	for i := range resp.Resultados {
+		if resp.Resultados[i].Success {
+			resp.Resultados[i] = ResultadoLote{Numero: numeros[i], Code: CodeBatchAborted, Message: "Lote anulado"}
+		}
+	}
+
+	message := fmt.Sprintf("Error al reservar el asiento %d; no se reservó ninguno", fallido)
+	if len(pendientes) > 0 {
+		message = fmt.Sprintf("Error al reservar el asiento %d; no se pudieron liberar los asientos %v, que quedan pendientes de reconciliación",
+			fallido, pendientes)
+		resp.PendientesReconciliacion = pendientes
+		resp.Reservados = len(pendientes)
+	}
+	resp.Error = &httperr.Body{Code: CodeBatchAborted, Message: message}
+}
+
+// deshacerLote libera los asientos ya reservados de un lote atómico que
+// falló a medias, antes de soltar la CS: nadie los ha visto ocupados salvo
+// los lectores de /asientos. Devuelve los que no se pudieron liberar, que
+// quedan marcados como pendientes de reconciliación.
+func (s *Server) deshacerLote(ctx context.Context, store loteStore, hechos []int, cliente string) []int {
+	var pendientes []int
+	for _, numero := range hechos {
+		if err := store.Liberar(ctx, numero, cliente); err != nil {
+			log.Printf("[%s] CRITICAL: could not undo batch reservation of seat %d for %s, it needs reconciliation: %v",
+				s.serverID, numero, cliente, err)
+			s.markPendingReconciliation(numero, cliente)
+			pendientes = append(pendientes, numero)
+		}
+	}
+	return pendientes
+}
+
+// markPendingReconciliation anota un asiento que quedó reservado por un lote
+// anulado. Su reserva tiene marca de auditoría, así que la reconciliación
+// tras un reinicio la daría por buena: hay que revisarlo a mano.
+func (s *Server) markPendingReconciliation(numero int, cliente string) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.pendingReconciliation == nil {
+		s.pendingReconciliation = make(map[int]string)
+	}
+	s.pendingReconciliation[numero] = cliente
+}
+
+// PendingReconciliation devuelve, ordenados, los asientos pendientes de
+// reconciliación
+func (s *Server) PendingReconciliation() []int {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	numeros := make([]int, 0, len(s.pendingReconciliation))
+	for numero := range s.pendingReconciliation {
+		numeros = append(numeros, numero)
+	}
+	sort.Ints(numeros)
+	return numeros
+}
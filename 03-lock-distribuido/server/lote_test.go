@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// fakeLoteStore guarda en memoria qué cliente ocupa cada asiento y falla las
+// escrituras de los asientos indicados
+type fakeLoteStore struct {
+	clientes     map[int]string
+	fallaReserva map[int]bool
+	fallaLiberar map[int]bool
+	// alReservar, si se define, se llama antes de cada reserva
+	alReservar func(numero int)
+}
+
+func newFakeLoteStore(total int) *fakeLoteStore {
+	f := &fakeLoteStore{clientes: make(map[int]string), fallaReserva: map[int]bool{}, fallaLiberar: map[int]bool{}}
+	for numero := 1; numero <= total; numero++ {
+		f.clientes[numero] = ""
+	}
+	return f
+}
+
+var errEscritura = errors.New("write failed")
+
+func (f *fakeLoteStore) Comprobar(ctx context.Context, numero int) ResultadoLote {
+	cliente, ok := f.clientes[numero]
+	switch {
+	case !ok:
+		return ResultadoLote{Numero: numero, Code: CodeSeatNotFound}
+	case cliente != "":
+		return ResultadoLote{Numero: numero, Code: CodeSeatTaken}
+	}
+	return ResultadoLote{Numero: numero, Success: true}
+}
+
+func (f *fakeLoteStore) Reservar(ctx context.Context, numero int, cliente string) (int64, error) {
+	if f.alReservar != nil {
+		f.alReservar(numero)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if f.fallaReserva[numero] {
+		return 0, errEscritura
+	}
+	f.clientes[numero] = cliente
+	return int64(numero), nil
+}
+
+func (f *fakeLoteStore) Liberar(ctx context.Context, numero int, cliente string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.fallaLiberar[numero] {
+		return errEscritura
+	}
+	f.clientes[numero] = ""
+	return nil
+}
+
+// ocupados devuelve los asientos con cliente
+func (f *fakeLoteStore) ocupados() map[int]string {
+	ocupados := map[int]string{}
+	for numero, cliente := range f.clientes {
+		if cliente != "" {
+			ocupados[numero] = cliente
+		}
+	}
+	return ocupados
+}
+
+func TestReservarLoteRollsBackWhenAWriteFailsMidBatch(t *testing.T) {
+	s := &Server{serverID: "node1"}
+	store := newFakeLoteStore(5)
+	store.fallaReserva[3] = true
+
+	resp := s.reservarLote(context.Background(), store, []int{1, 2, 3, 4}, "ana", true)
+
+	if ocupados := store.ocupados(); len(ocupados) != 0 {
+		t.Fatalf("seats still reserved after rollback: %v", ocupados)
+	}
+	if resp.Success || resp.Reservados != 0 {
+		t.Errorf("aborted batch reported success=%t reservados=%d", resp.Success, resp.Reservados)
+	}
+	if resp.Error == nil || resp.Error.Code != CodeBatchAborted || !strings.Contains(resp.Error.Message, "asiento 3") {
+		t.Errorf("error %+v does not name the failed seat", resp.Error)
+	}
+	codes := []string{}
+	for _, r := range resp.Resultados {
+		codes = append(codes, r.Code)
+	}
+	want := []string{CodeBatchAborted, CodeBatchAborted, CodeDatabaseError, CodeBatchAborted}
+	if !reflect.DeepEqual(codes, want) {
+		t.Errorf("result codes %v, want %v", codes, want)
+	}
+	if pending := s.PendingReconciliation(); len(pending) != 0 {
+		t.Errorf("clean rollback left seats pending reconciliation: %v", pending)
+	}
+}
+
+func TestReservarLoteMarksFailedRevertForReconciliation(t *testing.T) {
+	s := &Server{serverID: "node1"}
+	store := newFakeLoteStore(5)
+	store.fallaReserva[3] = true
+	store.fallaLiberar[2] = true
+
+	resp := s.reservarLote(context.Background(), store, []int{1, 2, 3}, "ana", true)
+
+	if ocupados := store.ocupados(); !reflect.DeepEqual(ocupados, map[int]string{2: "ana"}) {
+		t.Fatalf("reserved after rollback: %v, want only seat 2", ocupados)
+	}
+	if !reflect.DeepEqual(resp.PendientesReconciliacion, []int{2}) || resp.Reservados != 1 {
+		t.Errorf("response pending=%v reservados=%d, want [2] and 1", resp.PendientesReconciliacion, resp.Reservados)
+	}
+	if pending := s.PendingReconciliation(); !reflect.DeepEqual(pending, []int{2}) {
+		t.Errorf("server pending reconciliation %v, want [2]", pending)
+	}
+}
+
+func TestReservarLoteNonAtomicKeepsTheOtherSeats(t *testing.T) {
+	s := &Server{serverID: "node1"}
+	store := newFakeLoteStore(5)
+	store.fallaReserva[2] = true
+
+	resp := s.reservarLote(context.Background(), store, []int{1, 2, 3}, "ana", false)
+
+	if ocupados := store.ocupados(); !reflect.DeepEqual(ocupados, map[int]string{1: "ana", 3: "ana"}) {
+		t.Fatalf("reserved %v, want seats 1 and 3", ocupados)
+	}
+	if resp.Error != nil || resp.Reservados != 2 || resp.Success {
+		t.Errorf("non-atomic batch: error=%+v reservados=%d success=%t", resp.Error, resp.Reservados, resp.Success)
+	}
+}
+
+// Si vence el plazo de la petición a mitad del lote, la escritura siguiente
+// falla con el contexto, pero lo ya reservado se deshace igual
+func TestReservarLoteRollsBackAfterTheRequestDeadline(t *testing.T) {
+	s := &Server{serverID: "node1"}
+	store := newFakeLoteStore(5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.alReservar = func(numero int) {
+		if numero == 3 {
+			cancel()
+		}
+	}
+
+	resp := s.reservarLote(ctx, store, []int{1, 2, 3, 4}, "ana", true)
+
+	if ocupados := store.ocupados(); len(ocupados) != 0 {
+		t.Fatalf("seats still reserved after the deadline: %v", ocupados)
+	}
+	if resp.Error == nil || len(resp.PendientesReconciliacion) != 0 {
+		t.Errorf("error=%+v pending=%v, want a clean abort", resp.Error, resp.PendientesReconciliacion)
+	}
+}
+
+// reservarLoteHTTP llama a /reservar-lote en s con el cuerpo indicado
+func reservarLoteHTTP(s *Server, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.handleReservarLote(rec, httptest.NewRequest(http.MethodPost, "/reservar-lote", strings.NewReader(body)))
+	return rec
+}
+
+// El lote entra una sola vez en la CS, con un REQUEST por peer para todos
+// sus asientos, y un asiento ocupado no impide reservar los demás
+func TestReservarLoteEntersTheCSOnce(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		c := NewSimCluster("node1", "node2", "node3")
+		node1 := c.Node("node1")
+		node1.HeldAnnounceInterval = 0
+		s := NewServer(node1, mt.Coll, NewAuditLog(mt.Coll), "node1")
+
+		asiento := func(numero int, disponible bool) bson.D {
+			return bson.D{{Key: "numero", Value: numero}, {Key: "disponible", Value: disponible}}
+		}
+		updated := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+		inserted := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.asientos", mtest.FirstBatch, asiento(1, true)),
+			mtest.CreateCursorResponse(0, "test.asientos", mtest.FirstBatch, asiento(2, false)),
+			mtest.CreateCursorResponse(0, "test.asientos", mtest.FirstBatch, asiento(3, true)),
+			updated, inserted,
+			updated, inserted,
+		)
+		rec := reservarLoteHTTP(s, `{"numeros":[1,2,3],"cliente":"ana"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var resp RespuestaLote
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Success || resp.Reservados != 2 || resp.Error != nil {
+			t.Fatalf("expected 2 of 3 seats reserved without error, got %+v", resp)
+		}
+		codes := []string{}
+		for _, r := range resp.Resultados {
+			codes = append(codes, r.Code)
+		}
+		if want := []string{"", CodeSeatTaken, ""}; !reflect.DeepEqual(codes, want) {
+			t.Fatalf("result codes %v, want %v", codes, want)
+		}
+
+		snap := node1.MessageStats()
+		if snap.CSEntries != 1 || snap.Sent["REQUEST"] != 2 {
+			t.Fatalf("expected one CS entry with 2 REQUESTs, got %d entries and %d REQUESTs", snap.CSEntries, snap.Sent["REQUEST"])
+		}
+		if state := node1.CSStatus().State; state != Released.String() {
+			t.Fatalf("expected the CS released after the batch, got %s", state)
+		}
+	})
+}
+
+// Con atomico, un asiento ocupado anula el lote sin escribir nada
+func TestReservarLoteAtomicAbortsOnATakenSeat(t *testing.T) {
+	s := &Server{serverID: "node1"}
+	store := newFakeLoteStore(5)
+	store.clientes[2] = "luis"
+	store.alReservar = func(numero int) {
+		t.Fatalf("seat %d written in an aborted batch", numero)
+	}
+
+	resp := s.reservarLote(context.Background(), store, []int{1, 2, 3}, "ana", true)
+
+	if ocupados := store.ocupados(); !reflect.DeepEqual(ocupados, map[int]string{2: "luis"}) {
+		t.Fatalf("reserved %v, want only luis's seat", ocupados)
+	}
+	if resp.Success || resp.Reservados != 0 || resp.Error == nil || resp.Error.Code != CodeBatchAborted {
+		t.Fatalf("expected the batch aborted, got %+v", resp)
+	}
+	codes := []string{}
+	for _, r := range resp.Resultados {
+		codes = append(codes, r.Code)
+	}
+	if want := []string{CodeBatchAborted, CodeSeatTaken, CodeBatchAborted}; !reflect.DeepEqual(codes, want) {
+		t.Fatalf("result codes %v, want %v", codes, want)
+	}
+}
+
+// Un lote mal formado se rechaza antes de pedir la CS
+func TestReservarLoteValidation(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	// Sin colección: si el handler llegara a la BD entraría en pánico
+	s := &Server{node: c.Node("node1"), serverID: "node1"}
+	demasiados := make([]string, maxLote+1)
+	for i := range demasiados {
+		demasiados[i] = strconv.Itoa(i + 1)
+	}
+
+	for name, body := range map[string]string{
+		"sin cliente": `{"numeros":[1,2]}`,
+		"vacío":       `{"numeros":[],"cliente":"ana"}`,
+		"demasiados":  `{"numeros":[` + strings.Join(demasiados, ",") + `],"cliente":"ana"}`,
+		"repetido":    `{"numeros":[1,2,1],"cliente":"ana"}`,
+	} {
+		rec := reservarLoteHTTP(s, body)
+		var resp httperr.Response
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusBadRequest || resp.Error.Code != httperr.CodeInvalidRequest {
+			t.Errorf("%s: expected 400 %s, got %d %+v", name, httperr.CodeInvalidRequest, rec.Code, resp.Error)
+		}
+	}
+	if sent := c.Node("node1").MessageStats().TotalSent; sent != 0 {
+		t.Fatalf("expected no CS request for invalid batches, sent %d messages", sent)
+	}
+}
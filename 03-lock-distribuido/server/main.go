@@ -1,15 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"github.com/sincronizacion-distribuida/shared/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -17,29 +27,76 @@ import (
 
 // Asiento representa un asiento en la base de datos
 type Asiento struct {
-	Numero     int       `bson:"numero" json:"numero"`
-	Disponible bool      `bson:"disponible" json:"disponible"`
-	Cliente    string    `bson:"cliente,omitempty" json:"cliente,omitempty"`
-	ServerID   string    `bson:"server_id" json:"server_id"`
-	UpdatedAt  time.Time `bson:"updated_at" json:"updated_at"`
+	models.AsientoBase `bson:",inline"`
+	// Reloj de Lamport del nodo al confirmar la última reserva o liberación
+	LogicalTS int64 `bson:"logical_ts" json:"logical_ts"`
+	// Última escritura de la CS, para la reconciliación tras un reinicio
+	Op *SeatOp `bson:"op,omitempty" json:"-"`
 }
 
 // Server es la estructura principal de nuestro servidor de reservas
 type Server struct {
 	node       *Node
 	collection *mongo.Collection
+	audit      *AuditLog
 	serverID   string
+
+	mongoSettings MongoSettings
+	// 1 cuando los asientos existen en la BD (acceso atómico)
+	seatsReady int32
+	// 1 cuando terminó la espera de arranque a los peers (acceso atómico)
+	peersReady int32
+	// Límite de reservas simultáneas (nil = sin límite)
+	limiter *ReservationLimiter
+	// Token de X-Admin-Token para /admin/* (vacío = deshabilitados)
+	adminToken string
+	// Múltiplo de la espera media a partir del cual /internal/fairness marca
+	// la espera máxima de un nodo como inanición
+	starvationFactor float64
+	// Cancela la prueba de /admin/loadtest en curso (nil = ninguna)
+	loadMu     sync.Mutex
+	loadCancel context.CancelFunc
+	// Asientos de lotes anulados que no se pudieron liberar, con su cliente
+	pendingMu             sync.Mutex
+	pendingReconciliation map[int]string
 }
 
 // NewServer crea una nueva instancia del servidor
-func NewServer(node *Node, collection *mongo.Collection, serverID string) *Server {
+func NewServer(node *Node, collection *mongo.Collection, audit *AuditLog, serverID string) *Server {
 	return &Server{
 		node:       node,
 		collection: collection,
+		audit:      audit,
 		serverID:   serverID,
+
+		starvationFactor: defaultStarvationFactor,
 	}
 }
 
+// recordAudit registra una operación confirmada con el timestamp de Lamport
+// bajo el cual se mantiene la sección crítica. Debe llamarse dentro de la CS.
+func (s *Server) recordAudit(ctx context.Context, operacion string, numero int, cliente, onBehalfOf, opID string) error {
+	ts, held := s.node.HeldTimestamp()
+	if !held {
+		log.Printf("[%s] WARNING: recording audit for seat %d outside the critical section", s.serverID, numero)
+	}
+
+	entry := AuditEntry{
+		Timestamp:  ts,
+		NodeID:     s.serverID,
+		Operacion:  operacion,
+		Numero:     numero,
+		Cliente:    cliente,
+		OnBehalfOf: onBehalfOf,
+		OpID:       opID,
+	}
+	if err := s.audit.Record(ctx, entry); err != nil {
+		log.Printf("[%s] Failed to record audit entry for seat %d: %v", s.serverID, numero, err)
+		return err
+	}
+	return nil
+}
+
 // --- HTTP Handlers ---
 
 // handleGetAsientos devuelve el estado de todos los asientos desde la BD
@@ -48,17 +105,13 @@ func (s *Server) handleGetAsientos(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	
-	cursor, err := s.collection.Find(context.Background(), bson.M{})
-	if err != nil {
-		http.Error(w, "Failed to fetch seats", http.StatusInternalServerError)
-		return
-	}
-	defer cursor.Close(context.Background())
 
-	var asientos []Asiento
-	if err = cursor.All(context.Background(), &asientos); err != nil {
-		http.Error(w, "Failed to decode seats", http.StatusInternalServerError)
+	asientos, err := s.loadAsientos(r.Context())
+	if err != nil {
+		if requestTimedOut(r) {
+			return
+		}
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to fetch seats")
 		return
 	}
 
@@ -69,93 +122,136 @@ func (s *Server) handleGetAsientos(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// errCSTimeout indica que no se obtuvo la sección crítica a tiempo
+var errCSTimeout = errors.New("timeout waiting for critical section")
+
+// csWaitTimeout es lo que una petición espera la sección crítica
+const csWaitTimeout = 10 * time.Second
+
+// acquireCS pide la sección crítica y espera a obtenerla, a que venza timeout
+// o a que se cancele ctx (el cliente cerró la conexión). Si el nodo quedó
+// dentro de la CS devuelve la función que la libera, que el llamador debe
+// llamar; se puede llamar más de una vez y no libera una CS que el watchdog
+// ya reclamó y que puede ser de otra petición.
+func (s *Server) acquireCS(ctx context.Context, timeout time.Duration) (func(), error) {
+	start := time.Now()
+	defer func() { recordCSWait(ctx, time.Since(start)) }()
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Si la CS se concede a la vez que vence la espera, RequestCSContext
+	// devuelve nil: ya estamos dentro y el llamador decide qué hacer
+	if err := s.node.RequestCSContext(waitCtx); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errCSTimeout
+	}
+
+	// Con recoverPanics, anotar la CS para liberarla si la petición entra en
+	// pánico antes de terminar de liberarla
+	token := s.node.HoldToken()
+	hold, _ := ctx.Value(csHoldKey{}).(*csHold)
+	if hold != nil {
+		hold.acquired(token)
+	}
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			s.node.ReleaseCSToken(token)
+			if hold != nil {
+				hold.done()
+			}
+		})
+	}
+	return release, nil
+}
+
 // handleReservarAsiento gestiona la reserva de un asiento usando Ricart-Agrawala
 func (s *Server) handleReservarAsiento(w http.ResponseWriter, r *http.Request) {
 	// Configurar headers CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	
+
 	log.Printf("[%s] Received POST /reservar from %s", s.serverID, r.RemoteAddr)
 	var req struct {
 		Numero  int    `json:"numero"`
 		Cliente string `json:"cliente"`
+		// Servidor del clúster en cuyo nombre se reserva (reserva delegada)
+		OnBehalfOf string `json:"on_behalf_of,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("[%s] Error decoding /reservar body: %v", s.serverID, err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		httperr.WriteDecodeError(w, err)
 		return
 	}
 	log.Printf("[%s] /reservar payload: %+v", s.serverID, req)
 
+	if req.OnBehalfOf != "" && !s.node.IsPeer(req.OnBehalfOf) {
+		log.Printf("[%s] Rejecting reservation on behalf of unknown server %q", s.serverID, req.OnBehalfOf)
+		httperr.Write(w, http.StatusForbidden, CodeForbidden, "on_behalf_of must be a known peer")
+		return
+	}
+
 	// 1. Solicitar acceso a la sección crítica
 	log.Printf("[%s] Requesting CS to reserve seat %d", s.serverID, req.Numero)
 
-	// Llamar RequestCS pero con timeout para evitar bloqueo indefinido
-	csDone := make(chan struct{})
-	go func() {
-		s.node.RequestCS()
-		close(csDone)
-	}()
-
-	select {
-	case <-csDone:
-		log.Printf("[%s] Granted CS to reserve seat %d", s.serverID, req.Numero)
-	case <-time.After(10 * time.Second):
-		log.Printf("[%s] Timeout waiting for CS to reserve seat %d", s.serverID, req.Numero)
-
-		// Limpiar el estado del nodo para evitar deadlocks futuros.
-		s.node.CancelCSRequest()
-		http.Error(w, "Timeout acquiring distributed lock", http.StatusGatewayTimeout)
+	release, err := s.acquireCS(WithCSResource(r.Context(), fmt.Sprintf("asiento %d", req.Numero)), csWaitTimeout)
+	if err != nil {
+		log.Printf("[%s] Gave up waiting for CS to reserve seat %d: %v", s.serverID, req.Numero, err)
+		httperr.Write(w, http.StatusGatewayTimeout, CodeCSTimeout, "Timeout acquiring distributed lock")
 		return
 	}
+	log.Printf("[%s] Granted CS to reserve seat %d", s.serverID, req.Numero)
 
 	// Defer la liberación de la sección crítica
-	defer s.node.ReleaseCS()
+	defer release()
+
+	// Si el cliente se fue o venció el plazo de la ruta mientras esperábamos,
+	// no reservar un asiento que nadie va a recibir
+	if err := r.Context().Err(); err != nil {
+		log.Printf("[%s] Client abandoned or timed out reservation of seat %d, not reserving: %v", s.serverID, req.Numero, err)
+		return
+	}
 
 	// 2. Una vez dentro de la sección crítica, realizar la operación
 	var asiento Asiento
-	err := s.collection.FindOne(context.Background(), bson.M{"numero": req.Numero}).Decode(&asiento)
+	err = s.collection.FindOne(r.Context(), bson.M{"numero": req.Numero}).Decode(&asiento)
+	if err == mongo.ErrNoDocuments {
+		httperr.Write(w, http.StatusNotFound, CodeSeatNotFound, "Asiento no encontrado")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Asiento no encontrado", http.StatusNotFound)
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to fetch seat")
 		return
 	}
 
 	if !asiento.Disponible {
-		response := map[string]interface{}{
-			"success": false,
-			"message": "Asiento ya está ocupado",
-			"server_id": s.serverID,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(response)
+		httperr.Write(w, http.StatusConflict, CodeSeatTaken, "Asiento ya está ocupado")
 		return
 	}
 
-	// Actualizar el asiento
-	update := bson.M{
-		"$set": bson.M{
-			"disponible": false,
-			"cliente":    req.Cliente,
-			"server_id":  s.serverID,
-			"updated_at": time.Now(),
-		},
-	}
-
-	res, err := s.collection.UpdateOne(context.Background(), bson.M{"numero": req.Numero}, update)
+	// Actualizar el asiento. Confirmar la reserva es un evento local:
+	// avanza el reloj de Lamport y su valor queda en el asiento
+	logicalTS, err := s.aplicarOperacion(r.Context(), asiento, false, req.Cliente, req.OnBehalfOf)
 	if err != nil {
-		log.Printf("[%s] Failed to update seat %d: %v", s.serverID, req.Numero, err)
-		http.Error(w, "Failed to update seat", http.StatusInternalServerError)
+		log.Printf("[%s] Failed to reserve seat %d: %v", s.serverID, req.Numero, err)
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to update seat")
 		return
 	}
-	log.Printf("[%s] UpdateOne modified count: %d for seat %d", s.serverID, res.ModifiedCount, req.Numero)
+	log.Printf("[%s] [ts=%d] Reserved seat %d", s.serverID, logicalTS, req.Numero)
 
 	response := map[string]interface{}{
-		"success": true,
-		"message": "Asiento reservado exitosamente",
-		"server_id": s.serverID,
+		"success":    true,
+		"message":    "Asiento reservado exitosamente",
+		"server_id":  s.serverID,
+		"logical_ts": logicalTS,
+	}
+	if req.OnBehalfOf != "" {
+		response["on_behalf_of"] = req.OnBehalfOf
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -167,7 +263,7 @@ func (s *Server) handleLiberarAsiento(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	
+
 	log.Printf("[%s] Received POST /liberar from %s", s.serverID, r.RemoteAddr)
 	var req struct {
 		Numero int `json:"numero"`
@@ -175,127 +271,351 @@ func (s *Server) handleLiberarAsiento(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("[%s] Error decoding /liberar body: %v", s.serverID, err)
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		httperr.WriteDecodeError(w, err)
 		return
 	}
 	log.Printf("[%s] /liberar payload: %+v", s.serverID, req)
 
 	// Solicitar acceso a la sección crítica con timeout
-	csDone2 := make(chan struct{})
-	go func() {
-		s.node.RequestCS()
-		close(csDone2)
-	}()
-
-	select {
-	case <-csDone2:
-		// proceed
-	case <-time.After(10 * time.Second):
-		log.Printf("[%s] Timeout waiting for CS to free seat %d", s.serverID, req.Numero)
+	release, err := s.acquireCS(WithCSResource(r.Context(), fmt.Sprintf("asiento %d", req.Numero)), csWaitTimeout)
+	if err != nil {
+		log.Printf("[%s] Gave up waiting for CS to free seat %d: %v", s.serverID, req.Numero, err)
+		httperr.Write(w, http.StatusGatewayTimeout, CodeCSTimeout, "Timeout acquiring distributed lock")
+		return
+	}
+	defer release()
 
-		// Limpiar el estado del nodo para evitar deadlocks futuros.
-		s.node.CancelCSRequest()
-		http.Error(w, "Timeout acquiring distributed lock", http.StatusGatewayTimeout)
+	if err := r.Context().Err(); err != nil {
+		log.Printf("[%s] Client abandoned or timed out release of seat %d, not releasing: %v", s.serverID, req.Numero, err)
 		return
 	}
-	defer s.node.ReleaseCS()
 
 	// Verificar que el asiento existe y está ocupado
 	var asiento Asiento
-	err := s.collection.FindOne(context.Background(), bson.M{"numero": req.Numero}).Decode(&asiento)
+	err = s.collection.FindOne(r.Context(), bson.M{"numero": req.Numero}).Decode(&asiento)
+	if err == mongo.ErrNoDocuments {
+		httperr.Write(w, http.StatusNotFound, CodeSeatNotFound, "Seat not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Seat not found", http.StatusNotFound)
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to fetch seat")
 		return
 	}
 
 	if asiento.Disponible {
-		http.Error(w, "Seat is already available", http.StatusBadRequest)
+		httperr.Write(w, http.StatusConflict, CodeSeatAlreadyFree, "Seat is already available")
 		return
 	}
 
 	// Liberar el asiento
-	update := bson.M{
-		"$set": bson.M{
-			"disponible": true,
-			"cliente":    "",
-			"server_id":  s.serverID,
-			"updated_at": time.Now(),
-		},
-	}
-
-	_, err = s.collection.UpdateOne(context.Background(), bson.M{"numero": req.Numero}, update)
+	logicalTS, err := s.aplicarOperacion(r.Context(), asiento, true, "", "")
 	if err != nil {
-		http.Error(w, "Failed to update seat", http.StatusInternalServerError)
+		log.Printf("[%s] Failed to free seat %d: %v", s.serverID, req.Numero, err)
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to update seat")
 		return
 	}
 
 	response := map[string]interface{}{
-		"success": true,
-		"message": "Asiento liberado exitosamente",
-		"server_id": s.serverID,
+		"success":    true,
+		"message":    "Asiento liberado exitosamente",
+		"server_id":  s.serverID,
+		"logical_ts": logicalTS,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleGetAudit devuelve el registro de auditoría en orden total (timestamp, node_id)
+func (s *Server) handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.audit.List(r.Context())
+	if err != nil {
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to fetch audit log")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":   entries,
+		"server_id": s.serverID,
+	})
+}
+
+// maxMessageBytes limita el cuerpo de un mensaje interno
+const maxMessageBytes = 1 << 20
+
 // handleInternalMessage es el endpoint para la comunicación entre nodos
 func (s *Server) handleInternalMessage(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageBytes))
+	if err != nil {
+		httperr.Write(w, http.StatusBadRequest, CodeInvalidMessage, "Invalid message")
+		return
+	}
+
+	// La firma cubre los bytes exactos del cuerpo, antes de interpretarlo
+	if !s.node.signer.Verify(body, r.Header.Get(signatureHeader)) {
+		s.node.stats.recordRejectedSignature()
+		log.Printf("[%s] Rejected internal message from %s with a missing or invalid signature", s.serverID, r.RemoteAddr)
+		httperr.Write(w, http.StatusUnauthorized, CodeInvalidSignature, "Invalid message signature")
+		return
+	}
+
 	var msg Message
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		http.Error(w, "Invalid message", http.StatusBadRequest)
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&msg); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+
+	// Un fallo interno al procesar debe llegar al emisor como 500 para que
+	// reintente, en lugar de cortar la conexión
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[%s] Panic processing %s from %s: %v", s.serverID, msg.Type, msg.NodeID, rec)
+			httperr.Write(w, http.StatusInternalServerError, httperr.CodeInternal, "Failed to process message")
+		}
+	}()
+
+	// Procesar el mensaje en línea: solo toca el mutex del nodo, y así el
+	// emisor solo da el envío por bueno cuando realmente se ha procesado.
+	// Como el emisor no envía el siguiente mensaje a este nodo hasta tener
+	// la respuesta (dispatch), los de un mismo emisor no se adelantan.
+	reply, err := s.node.handleMessage(msg)
+	if errors.Is(err, ErrInvalidMessage) {
+		log.Printf("[%s] Rejected internal message: %v", s.serverID, err)
+		httperr.Write(w, http.StatusBadRequest, CodeInvalidMessage, err.Error())
+		return
+	}
+	if errors.Is(err, ErrFaultInjected) {
+		httperr.Write(w, http.StatusServiceUnavailable, CodeFaultInjected, err.Error())
+		return
+	}
+	if errors.Is(err, ErrUnknownSender) {
+		httperr.Write(w, http.StatusForbidden, CodeUnknownPeer, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNodePaused) {
+		httperr.Write(w, http.StatusServiceUnavailable, CodeNodePaused, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to process internal message: %v", s.serverID, err)
+		httperr.Write(w, http.StatusInternalServerError, httperr.CodeInternal, "Failed to process message")
+		return
+	}
+
+	// Un REQUEST concedido de inmediato lleva el REPLY en la respuesta
+	w.Header().Set("Content-Type", "application/json")
+	if msg.Type == "REQUEST" {
+		json.NewEncoder(w).Encode(MessageResponse{
+			Reply:    reply,
+			Deferred: reply == nil,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(MessageResponse{})
+}
+
+// handleInfo devuelve la configuración efectiva del servidor
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"server_id":    s.serverID,
+		"mongo":        s.mongoSettings,
+		"algorithm":    s.node.Algorithm,
+		"transport":    s.node.Transport(),
+		"clock_mode":   s.node.ClockMode(),
+		"lamport_time": s.node.Clock.GetTime(),
+		"vector_clock": s.node.VectorSnapshot(),
+	})
+}
+
+// Topology describe el lugar del nodo en el clúster según su propia
+// configuración
+type Topology struct {
+	NodeID            string            `json:"node_id"`
+	Peers             map[string]string `json:"peers"` // ID del peer -> URL base
+	Algorithm         string            `json:"algorithm"`
+	Transport         string            `json:"transport"`
+	LamportTime       int64             `json:"lamport_time"`
+	MembershipVersion int64             `json:"membership_version"`
+}
+
+// handleTopology devuelve el ID del nodo, las URLs resueltas de sus peers y
+// el algoritmo en uso. No consulta a los peers: para eso están /cluster/*.
+func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Topology{
+		NodeID:            s.serverID,
+		Peers:             s.node.PeerURLs(),
+		Algorithm:         s.node.Algorithm,
+		Transport:         s.node.Transport(),
+		LamportTime:       s.node.Clock.GetTime(),
+		MembershipVersion: s.node.MembershipVersion(),
+	})
+}
+
+// handleCSStatus devuelve el estado de este nodo respecto a la CS
+func (s *Server) handleCSStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.node.CSStatus())
+}
+
+// handleClusterCSHolder consulta a todos los peers quién tiene la CS
+func (s *Server) handleClusterCSHolder(w http.ResponseWriter, r *http.Request) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	status := s.node.QueryClusterCSStatus(client)
+	if len(status.Holders) > 1 {
+		log.Printf("[%s] WARNING: %d nodes report holding the CS at once", s.serverID, len(status.Holders))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleClusterHealth consulta /health en todos los peers a la vez
+func (s *Server) handleClusterHealth(w http.ResponseWriter, r *http.Request) {
+	client := &http.Client{Timeout: time.Second}
+	health := s.node.QueryClusterHealth(client)
+	if health.Down > 0 {
+		log.Printf("[%s] Cluster health: %d of %d nodes down", s.serverID, health.Down, len(health.Nodes))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health)
+}
+
+// handleJoin incorpora a la membresía un nodo que se anuncia
+func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
+	var change MembershipChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil || change.NodeID == "" {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Invalid membership change")
 		return
 	}
 
-	// Procesar el mensaje en una goroutine para no bloquear
-	go s.node.handleMessage(msg)
+	if change.InternalURL != "" {
+		s.node.SetPeerInternalURL(change.NodeID, change.InternalURL)
+	}
+	s.node.AddPeer(change.NodeID, change.URL)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MembershipChange{
+		NodeID:            s.serverID,
+		MembershipVersion: s.node.MembershipVersion(),
+	})
+}
 
+// handleLeave elimina de la membresía un nodo que abandona el clúster
+func (s *Server) handleLeave(w http.ResponseWriter, r *http.Request) {
+	var change MembershipChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil || change.NodeID == "" {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Invalid membership change")
+		return
+	}
+
+	s.node.RemovePeer(change.NodeID)
 	w.WriteHeader(http.StatusOK)
 }
 
 // handleHealthCheck comprueba la salud del servidor
 func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
+	suspects := []string{}
+	heartbeats := map[string]PeerHeartbeat{}
+	if s.node.detector != nil {
+		suspects = s.node.detector.Suspects()
+		heartbeats = s.node.detector.Heartbeats()
+	}
+
+	health := map[string]interface{}{
+		"status":             "healthy",
+		"server_id":          s.serverID,
+		"time":               s.node.Clock.GetTime(),
+		"suspects":           suspects,
+		"heartbeats":         heartbeats,
+		"cs":                 s.node.CSStatus(),
+		"cs_queue":           s.node.CSQueueStatus(),
+		"peers":              s.node.PeerList(),
+		"membership_version": s.node.MembershipVersion(),
+		"algorithm":          s.node.Algorithm,
+		"clock_mode":         s.node.ClockMode(),
+		"vector_clock":       s.node.VectorSnapshot(),
+		"paused":             s.node.pause.Paused(),
+	}
+	if s.node.raft != nil {
+		health["raft"] = s.node.raft.Status()
+	}
+	if pending := s.PendingReconciliation(); len(pending) > 0 {
+		health["status"] = "degraded"
+		health["needs_reconciliation"] = pending
+	}
+	if s.limiter != nil {
+		inFlight, max := s.limiter.InFlight()
+		health["reservations"] = map[string]int{"in_flight": inFlight, "max": max}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "healthy",
-		"server_id": s.serverID,
-		"time":      s.node.Clock.GetTime(),
-	})
+	json.NewEncoder(w).Encode(health)
+}
+
+// handleMetrics publica las métricas del algoritmo en formato Prometheus
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.node.MessageStats().WritePrometheus(w, s.serverID)
+}
+
+// handleStats devuelve las mismas métricas en JSON legible
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.node.MessageStats())
 }
 
 // --- Main y Setup ---
 
 func main() {
+	// "server bench ..." mide la CS en memoria y sale al terminar
+	if len(os.Args) > 1 {
+		tools := map[string]func([]string, io.Writer) error{
+			"bench": runBench,
+		}
+		if tool, ok := tools[os.Args[1]]; ok {
+			if err := tool(os.Args[2:], os.Stdout); err != nil {
+				// Las herramientas silencian el log del algoritmo
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// 1. Leer configuración del entorno
 	serverID := os.Getenv("SERVER_ID")
 	if serverID == "" {
 		log.Fatal("SERVER_ID must be set")
 	}
 
-	peersStr := os.Getenv("PEERS") // e.g., "server1:8081,server2:8082"
+	peersStr := os.Getenv("PEERS") // e.g., "server1,server2,server3"
 	if peersStr == "" {
 		log.Fatal("PEERS must be set")
 	}
-	
-	// Parse peers - they come as "server1,server2,server3" but we need full URLs
+
+	// Parse peers - they come as "server1,server2,server3"
 	rawPeers := strings.Split(peersStr, ",")
 	var peers []string
-	
-	// Convert peer names to proper URLs for Docker networking
 	for _, peer := range rawPeers {
+		peer = strings.TrimSpace(peer)
+		if peer == "" {
+			log.Fatalf("PEERS contains an empty node id: %q", peersStr)
+		}
 		if peer != serverID { // Don't include self
-			switch peer {
-			case "server1":
-				peers = append(peers, "server1")
-			case "server2":
-				peers = append(peers, "server2")
-			case "server3":
-				peers = append(peers, "server3")
-			default:
-				peers = append(peers, peer)
-			}
+			peers = append(peers, peer)
 		}
 	}
 
+	// PEER_URLS asocia cada ID con su URL base, e.g. "server1=http://server1:8081,..."
+	// Sin PEER_URLS se usan las URLs del docker-compose
+	peerURLs, err := ResolvePeerURLs(os.Getenv("PEER_URLS"), peers)
+	if err != nil {
+		log.Fatalf("Invalid PEER_URLS: %v", err)
+	}
+
 	mongoURI := os.Getenv("MONGO_URI")
 	if mongoURI == "" {
 		mongoURI = "mongodb://mongo:27017"
@@ -306,6 +626,12 @@ func main() {
 		port = "8081"
 	}
 
+	// URL con la que este nodo se anuncia a los demás al unirse
+	selfURL := os.Getenv("SELF_URL")
+	if selfURL == "" {
+		selfURL = fmt.Sprintf("http://%s:%s", serverID, port)
+	}
+
 	log.Printf("[%s] Starting with peers: %v", serverID, peers)
 
 	// 2. Conectar a MongoDB
@@ -315,22 +641,299 @@ func main() {
 	}
 	defer client.Disconnect(context.Background())
 
-	collection := client.Database("reservations_db_distributed").Collection("seats")
+	// Durabilidad y enrutado de lecturas configurables sin recompilar
+	dbOpts, mongoSettings, err := mongoDatabaseOptions(os.Getenv("MONGO_WRITE_CONCERN"), os.Getenv("MONGO_READ_PREFERENCE"))
+	if err != nil {
+		log.Fatalf("Invalid MongoDB settings: %v", err)
+	}
+	log.Printf("[%s] MongoDB write concern: %s, read preference: %s", serverID, mongoSettings.WriteConcern, mongoSettings.ReadPreference)
+
+	db := client.Database("reservations_db_distributed", dbOpts)
+	collection := db.Collection("seats")
+	audit := NewAuditLog(db.Collection("audit"))
 
 	// 3. Inicializar el nodo de Ricart-Agrawala
-	node := NewNode(serverID, peers)
+	node := NewNode(serverID, peers, peerURLs)
+	node.SendTimeout = time.Duration(getEnvInt("SEND_TIMEOUT_MS", 2000)) * time.Millisecond
+	// Política de reintentos de los envíos: intentos, espera inicial y su
+	// multiplicador, tope de la espera, jitter y tiempo total para reintentar
+	// un mensaje (0 = sin límite), alineado por defecto con la espera de la CS
+	node.Retry.MaxAttempts = getEnvInt("SEND_MAX_RETRIES", 3)
+	node.Retry.InitialDelay = time.Duration(getEnvInt("SEND_RETRY_DELAY_MS", 100)) * time.Millisecond
+	node.Retry.MaxDelay = time.Duration(getEnvInt("SEND_RETRY_MAX_DELAY_MS", 1000)) * time.Millisecond
+	node.Retry.Deadline = time.Duration(getEnvInt("SEND_RETRY_BUDGET_MS", int(csWaitTimeout/time.Millisecond))) * time.Millisecond
+	if spec := os.Getenv("SEND_RETRY_POLICY"); spec != "" {
+		if node.Retry, err = ParseRetryPolicy(spec, node.Retry); err != nil {
+			log.Fatalf("Invalid SEND_RETRY_POLICY: %v", err)
+		}
+	}
+	// RETRY_POLICY_<TIPO> ajusta la de un tipo de mensaje (REQUEST, REPLY...)
+	// y RETRY_POLICY_OUTBOX la del outbox de REPLY pospuestos
+	node.RetryOverrides, node.replies.Policy, err = retryOverridesFromEnv(node.Retry, node.replies.Policy)
+	if err != nil {
+		log.Fatalf("Invalid retry policy: %v", err)
+	}
+	log.Printf("[%s] Send retry policy: %s", serverID, node.Retry)
+	for msgType, policy := range node.RetryOverrides {
+		log.Printf("[%s] Send retry policy for %s: %s", serverID, msgType, policy)
+	}
+	// Tiempo máximo dentro de la CS antes de liberarla a la fuerza (0 = sin límite)
+	node.MaxHold = time.Duration(getEnvInt("CS_MAX_HOLD_MS", 30000)) * time.Millisecond
+	// Anuncios HELD para detectar dos titulares a la vez (0 = desactivado)
+	node.HeldAnnounceInterval = time.Duration(getEnvInt("HELD_ANNOUNCE_MS", 1000)) * time.Millisecond
+	// Mensajes de nodos que no están en PEERS: rechazarlos (por defecto) o
+	// incorporarlos a la membresía, con una URL como la de SELF_URL por defecto
+	node.AutoRegisterPeers = os.Getenv("AUTO_REGISTER_PEERS") == "true"
+	node.autoRegisterURL = func(peerID string) string {
+		return fmt.Sprintf("http://%s:%s", peerID, port)
+	}
+	// Mensajes que se encolan como máximo con el nodo en pausa (/admin/pause)
+	if size := getEnvInt("PAUSE_QUEUE_SIZE", defaultPauseQueueSize); size > 0 {
+		node.pause = newPauseGate(size)
+	}
+	// Traza de los últimos mensajes del algoritmo (/internal/trace); con
+	// TRACE_MONGO=true se copia además en la colección trace
+	node.trace = NewTraceRecorder(getEnvInt("TRACE_BUFFER_SIZE", defaultTraceSize))
+	if os.Getenv("TRACE_MONGO") == "true" {
+		node.trace.MirrorTo(db.Collection("trace"))
+	}
+
+	// CLOCK_MODE=vector ordena las peticiones con relojes vectoriales
+	clockMode, err := parseClockMode(os.Getenv("CLOCK_MODE"))
+	if err != nil {
+		log.Fatalf("Invalid CLOCK_MODE: %v", err)
+	}
+	if clockMode == ClockModeVector {
+		node.UseVectorClock()
+	}
+	log.Printf("[%s] Using %s clock to order CS requests", serverID, clockMode)
+
+	// ALGORITHM=lamport-queue usa el algoritmo original de Lamport
+	algorithm, err := parseAlgorithm(os.Getenv("ALGORITHM"))
+	if err != nil {
+		log.Fatalf("Invalid ALGORITHM: %v", err)
+	}
+	if algorithm == AlgorithmLamportQueue {
+		node.UseLamportQueue()
+		if clockMode == ClockModeVector {
+			log.Printf("[%s] WARNING: %s orders its queue by Lamport timestamps; the vector clock is only reported", serverID, algorithm)
+		}
+	}
+	// ALGORITHM=raft replica una tabla de bloqueo con Raft
+	if algorithm == AlgorithmRaft {
+		raft := NewRaft(node, NewRaftStore(db.Collection("raft_state")),
+			time.Duration(getEnvInt("RAFT_HEARTBEAT_MS", 200))*time.Millisecond,
+			time.Duration(getEnvInt("RAFT_ELECTION_MS", 1000))*time.Millisecond)
+		if err := raft.Restore(context.Background()); err != nil {
+			log.Fatalf("[%s] Failed to load persisted raft state: %v", serverID, err)
+		}
+		node.UseRaft(raft)
+	}
+	log.Printf("[%s] Using %s mutual exclusion", serverID, algorithm)
+	// IMPLICIT_GRANTS=true: un nodo que ya tiene el REPLY de un peer vuelve
+	// a entrar sin pedírselo (Roucairol-Carvalho)
+	if os.Getenv("IMPLICIT_GRANTS") == "true" {
+		if algorithm != AlgorithmRicartAgrawala {
+			log.Fatalf("IMPLICIT_GRANTS requires ALGORITHM=%s, got %s", AlgorithmRicartAgrawala, algorithm)
+		}
+		node.ImplicitGrants = true
+		log.Printf("[%s] Implicit grants: repeated CS entries skip peers that already replied", serverID)
+	}
+
+	// Cuántos ticks de Lamport vale un nivel de prioridad de una petición a
+	// la CS; debe ser igual en todos los nodos
+	node.PriorityAging = int64(getEnvInt("PRIORITY_AGING_TICKS", int(defaultPriorityAging)))
+	if node.PriorityAging <= 0 {
+		log.Fatalf("PRIORITY_AGING_TICKS must be positive, got %d", node.PriorityAging)
+	}
+
+	// Reanudar el reloj de Lamport donde lo dejó la ejecución anterior
+	stateStore := NewNodeStateStore(db.Collection("node_state"))
+	snap, err := stateStore.Load(context.Background(), serverID)
+	if err != nil {
+		log.Printf("[%s] Failed to load persisted node state: %v", serverID, err)
+	}
+	node.RestoreState(snap, int64(getEnvInt("CLOCK_SAFETY_JUMP", 1000)))
+	// Operaciones que el nodo pudo dejar a medias si cayó dentro de la CS
+	recoveryFrom := recoverySince(snap, node.MaxHold, time.Now())
+	persistState(stateStore, node) // Deja constancia de que arrancamos en Released
+
+	stopPersist := make(chan struct{})
+	persistDone := make(chan struct{})
+	go func() {
+		persistInterval := time.Duration(getEnvInt("STATE_PERSIST_INTERVAL_MS", 2000)) * time.Millisecond
+		RunStatePersistence(stateStore, node, persistInterval, stopPersist)
+		close(persistDone)
+	}()
+
+	// Detector de fallos: los peers caídos no bloquean la sección crítica
+	fdInterval := time.Duration(getEnvInt("FD_INTERVAL_MS", 1000)) * time.Millisecond
+	detector := NewFailureDetector(node, fdInterval, getEnvInt("FD_THRESHOLD", 3))
+	// Silencio mínimo antes de dejar de esperar a un peer: conservador,
+	// porque dar por caído a un peer lento rompe la exclusión mutua
+	detector.DeadAfter = time.Duration(getEnvInt("FD_DEAD_AFTER_MS", 5000)) * time.Millisecond
+	if detector.DeadAfter < 0 {
+		log.Fatalf("FD_DEAD_AFTER_MS must not be negative, got %s", detector.DeadAfter)
+	}
+	log.Printf("[%s] Failure detector: %d missed heartbeats every %s and %s of silence declare a peer dead",
+		serverID, detector.Threshold, fdInterval, detector.DeadAfter)
+	node.detector = detector
+
+	// Gossip de la membresía: con GOSSIP_INTERVAL_MS las URLs de los peers y
+	// su salud salen de la tabla que se intercambian los nodos, en lugar de
+	// un ping de cada nodo a todos los demás
+	if gossipInterval := time.Duration(getEnvInt("GOSSIP_INTERVAL_MS", 0)) * time.Millisecond; gossipInterval > 0 {
+		node.gossip = NewGossip(node, selfURL, "", gossipInterval)
+		node.gossip.SuspectAfter = time.Duration(getEnvInt("GOSSIP_SUSPECT_MS",
+			int(defaultGossipSuspectRounds*gossipInterval/time.Millisecond))) * time.Millisecond
+		log.Printf("[%s] Gossip membership every %s; peers silent for %s are suspected",
+			serverID, gossipInterval, node.gossip.SuspectAfter)
+	}
+	go detector.Run()
+
+	// Firma de los mensajes entre nodos con el secreto compartido
+	signer, err := messageSignerFromEnv()
+	if err != nil {
+		log.Fatalf("[%s] Invalid message signing configuration: %v", serverID, err)
+	}
+	if signer.Enabled() {
+		node.signer = signer
+		log.Printf("[%s] Internal messages are signed with HMAC-SHA256", serverID)
+	} else {
+		log.Printf("[%s] WARNING: CLUSTER_SECRET not set, internal messages are not authenticated", serverID)
+	}
+
+	// TLS mutuo entre nodos: con TLS_CERT_FILE, TLS_KEY_FILE y TLS_CA_FILE el
+	// tráfico /internal/* va por INTERNAL_TLS_PORT y exige certificado
+	internalTLS, err := internalTLSFromEnv()
+	if err != nil {
+		log.Fatalf("[%s] Invalid TLS configuration: %v", serverID, err)
+	}
+	internalPort := os.Getenv("INTERNAL_TLS_PORT")
+	selfInternalURL := ""
+	if internalTLS != nil {
+		if internalPort == "" {
+			log.Fatalf("[%s] INTERNAL_TLS_PORT must be set when TLS is configured", serverID)
+		}
+		internalURLs, err := ParsePeerURLs(os.Getenv("PEER_INTERNAL_URLS"))
+		if err != nil {
+			log.Fatalf("[%s] Invalid PEER_INTERNAL_URLS: %v", serverID, err)
+		}
+		for _, peer := range peers {
+			url, ok := internalURLs[peer]
+			if !ok {
+				log.Fatalf("[%s] PEER_INTERNAL_URLS has no URL for peer %s", serverID, peer)
+			}
+			node.SetPeerInternalURL(peer, url)
+		}
+		selfInternalURL = os.Getenv("SELF_INTERNAL_URL")
+		if selfInternalURL == "" {
+			selfInternalURL = fmt.Sprintf("https://%s:%s", serverID, internalPort)
+		}
+		node.UseInternalTLS(internalTLS)
+		go internalTLS.WatchReload(serverID)
+		log.Printf("[%s] Internal traffic uses mutual TLS on port %s", serverID, internalPort)
+		if node.gossip != nil {
+			node.gossip.SetSelfURL(selfURL, selfInternalURL)
+		}
+	}
+
+	// Todos los nodos reciben mensajes por UDP en su puerto HTTP + 1000, para
+	// poder mezclar nodos con TRANSPORT=udp y TRANSPORT=http; solo los
+	// primeros envían por UDP. UDP_LOSS descarta esa fracción de datagramas.
+	transport := os.Getenv("TRANSPORT")
+	if transport != "" && transport != "http" && transport != "udp" {
+		log.Fatalf("Invalid TRANSPORT %q (expected http or udp)", transport)
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		log.Fatalf("PORT must be an integer, got %q", port)
+	}
+	udp, err := NewUDPTransport(node, ":"+strconv.Itoa(portNum+udpPortOffset))
+	if err != nil {
+		if transport == "udp" {
+			log.Fatalf("Failed to open UDP transport: %v", err)
+		}
+		log.Printf("[%s] UDP listener unavailable, messages only over HTTP: %v", serverID, err)
+	} else {
+		if loss := os.Getenv("UDP_LOSS"); loss != "" {
+			udp.LossRate, err = strconv.ParseFloat(loss, 64)
+			if err != nil || udp.LossRate < 0 || udp.LossRate >= 1 {
+				log.Fatalf("UDP_LOSS must be a fraction in [0, 1), got %q", loss)
+			}
+		}
+		go udp.Serve()
+		defer udp.Close()
+		if transport == "udp" {
+			node.transport = udp
+			log.Printf("[%s] Sending algorithm messages over UDP (port %d, loss %.0f%%)",
+				serverID, portNum+udpPortOffset, udp.LossRate*100)
+		}
+	}
+
+	// Agrupar los envíos HTTP a cada peer en lotes (MESSAGE_BATCH_WINDOW_MS=0
+	// los desactiva)
+	batchWindow := time.Duration(getEnvInt("MESSAGE_BATCH_WINDOW_MS", int(defaultBatchWindow/time.Millisecond))) * time.Millisecond
+	if batchWindow > 0 && transport != "udp" {
+		if err := node.UseBatching(batchWindow, getEnvInt("MESSAGE_BATCH_MAX", defaultBatchMax)); err != nil {
+			log.Fatalf("Invalid message batching settings: %v", err)
+		}
+		log.Printf("[%s] Batching messages to each peer for up to %s", serverID, batchWindow)
+	}
 
 	// 4. Crear el servidor
-	server := NewServer(node, collection, serverID)
+	server := NewServer(node, collection, audit, serverID)
+	server.mongoSettings = mongoSettings
+	server.limiter = NewReservationLimiter(getEnvInt("MAX_CONCURRENT_RESERVATIONS", 64))
+	server.adminToken = os.Getenv("ADMIN_TOKEN")
+	node.adminToken = server.adminToken
+	if factor := os.Getenv("FAIRNESS_STARVATION_FACTOR"); factor != "" {
+		server.starvationFactor, err = strconv.ParseFloat(factor, 64)
+		if err != nil || server.starvationFactor <= 1 {
+			log.Fatalf("FAIRNESS_STARVATION_FACTOR must be a number greater than 1, got %q", factor)
+		}
+	}
 
 	// 5. Inicializar asientos si es necesario (solo lo hace un nodo)
-	if serverID == rawPeers[0] { // El primer peer es el encargado
-		initializeSeats(collection)
+	if err := ensureSeatIndex(collection); err != nil {
+		log.Printf("[%s] Failed to create seat index: %v", serverID, err)
+	}
+	// Todos los nodos intentan inicializar hasta que los asientos existan;
+	// el arranque en cualquier orden no deja la colección vacía
+	seatInit := seatInitFromEnv()
+	var initialize func() error
+	switch os.Getenv("INIT_ELECTION") {
+	case "lease":
+		// El nodo que obtiene el lease en MongoDB inicializa; si cae antes
+		// de hacerlo, otro lo toma cuando caduca
+		leaseTTL := time.Duration(getEnvInt("INIT_LEASE_TTL_S", 30)) * time.Second
+		initialize = func() error {
+			runInitializerElection(db.Collection("leases"), serverID, leaseTTL, func() {
+				initializeSeats(collection, seatInit)
+			})
+			return nil
+		}
+	default:
+		// Dentro de la sección crítica distribuida: el primero en entrar
+		// crea los asientos y los demás los encuentran ya creados
+		if mode := os.Getenv("INIT_ELECTION"); mode != "" && mode != "cs" {
+			log.Printf("[%s] Unknown INIT_ELECTION %q, initializing seats through the critical section", serverID, mode)
+		}
+		initialize = func() error {
+			return server.initSeatsInCS(collection, seatInit)
+		}
 	}
 
 	// 6. Configurar rutas
 	r := mux.NewRouter()
-	
+
+	// Un pánico en un handler responde 500 y libera la CS que tuviera
+	r.Use(server.recoverPanics)
+
+	// Peticiones que superan SLOW_REQUEST_MS (0 = desactivado) se registran como WARN
+	slowThreshold := time.Duration(getEnvInt("SLOW_REQUEST_MS", 1000)) * time.Millisecond
+	r.Use(slowRequestMiddleware(serverID, slowThreshold))
+
 	// Middleware CORS para manejar preflight requests
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -339,33 +942,162 @@ func main() {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			
+
 			if r.Method == "OPTIONS" {
 				log.Printf("[CORS MW] Handling preflight (OPTIONS) for %s", r.URL.Path)
 				w.WriteHeader(http.StatusOK)
 				return
 			}
-			
+
 			next.ServeHTTP(w, r)
 		})
 	})
-	
+
+	// Plazo de cada ruta; ROUTE_TIMEOUTS cambia los de DefaultRouteTimeouts
+	routeTimeouts, err := ParseRouteTimeouts(os.Getenv("ROUTE_TIMEOUTS"), DefaultRouteTimeouts())
+	if err != nil {
+		log.Fatalf("Invalid ROUTE_TIMEOUTS: %v", err)
+	}
+	log.Printf("[%s] Route timeouts: %s", serverID, routeTimeouts)
+	r.Use(routeTimeoutMiddleware(serverID, routeTimeouts))
+
 	// Endpoints públicos
-	r.HandleFunc("/asientos", server.handleGetAsientos).Methods("GET")
-	r.HandleFunc("/reservar", server.handleReservarAsiento).Methods("POST", "OPTIONS")
-	r.HandleFunc("/liberar", server.handleLiberarAsiento).Methods("POST", "OPTIONS")
+	r.HandleFunc("/asientos", server.requireReady(server.handleGetAsientos)).Methods("GET")
+	r.HandleFunc("/asientos/verificado", server.requireReady(server.handleAsientosVerificado)).Methods("GET")
+	r.HandleFunc("/asientos/snapshot", server.requireReady(server.handleAsientosSnapshot)).Methods("GET")
+	r.HandleFunc("/reservar", server.requireReady(server.rejectWhilePaused(server.limitReservations(server.handleReservarAsiento)))).Methods("POST", "OPTIONS")
+	r.HandleFunc("/liberar", server.requireReady(server.rejectWhilePaused(server.limitReservations(server.handleLiberarAsiento)))).Methods("POST", "OPTIONS")
+	r.HandleFunc("/reservar-lote", server.requireReady(server.rejectWhilePaused(server.limitReservations(server.handleReservarLote)))).Methods("POST", "OPTIONS")
 	r.HandleFunc("/health", server.handleHealthCheck).Methods("GET")
+	r.HandleFunc("/ready", server.handleReady).Methods("GET")
+	r.HandleFunc("/audit", server.handleGetAudit).Methods("GET")
+	r.HandleFunc("/info", server.handleInfo).Methods("GET")
+	r.HandleFunc("/topology", server.handleTopology).Methods("GET")
+	r.HandleFunc("/whoami", server.handleTopology).Methods("GET")
+	r.HandleFunc("/cs-status", server.handleCSStatus).Methods("GET")
+	r.HandleFunc("/metrics", server.handleMetrics).Methods("GET")
+	r.HandleFunc("/cluster/cs-holder", server.handleClusterCSHolder).Methods("GET")
+	r.HandleFunc("/violations", server.handleViolations).Methods("GET")
+	r.HandleFunc("/debug/queue", server.handleDebugQueue).Methods("GET")
+	r.HandleFunc("/cluster/health", server.handleClusterHealth).Methods("GET")
+	r.HandleFunc("/admin/peers", server.handleGetPeers).Methods("GET")
+	r.HandleFunc("/admin/peers", server.handleReplacePeers).Methods("POST")
+	r.HandleFunc("/admin/pause", server.handlePause).Methods("POST")
+	r.HandleFunc("/admin/resume", server.handleResume).Methods("POST")
+	r.HandleFunc("/admin/loadtest", server.handleLoadTest).Methods("POST")
+	r.HandleFunc("/admin/loadtest/cancel", server.handleLoadTestCancel).Methods("POST")
 
-	// Endpoint interno para el algoritmo
-	r.HandleFunc("/internal/message", server.handleInternalMessage).Methods("POST")
+	// Endpoint interno para el algoritmo. Con TLS mutuo se sirve en un
+	// listener aparte y la API pública no lo expone.
+	internal := r
+	if internalTLS != nil {
+		internal = mux.NewRouter()
+	}
+	for _, router := range []*mux.Router{r, internal} {
+		router.NotFoundHandler = http.HandlerFunc(httperr.NotFoundHandler)
+		router.MethodNotAllowedHandler = http.HandlerFunc(httperr.MethodNotAllowedHandler)
+	}
+	if internal != r {
+		internal.Use(server.recoverPanics)
+	}
+	internal.HandleFunc("/internal/message", server.handleInternalMessage).Methods("POST")
+	internal.HandleFunc("/internal/messages", server.handleInternalMessages).Methods("POST")
+	internal.HandleFunc("/internal/stats", server.handleStats).Methods("GET")
+	internal.HandleFunc("/internal/fairness", server.handleFairness).Methods("GET", "POST")
+	internal.HandleFunc("/internal/clock", server.handleClock).Methods("GET")
+	internal.HandleFunc("/internal/clock/skew", server.handleClockSkew).Methods("GET")
+	internal.HandleFunc("/internal/trace", server.handleTrace).Methods("GET")
+	internal.HandleFunc("/internal/trace/clear", server.handleTraceClear).Methods("POST")
+	internal.HandleFunc("/internal/events", server.handleEvents).Methods("GET")
+	internal.HandleFunc("/internal/state", server.handleInternalState).Methods("GET")
+	internal.HandleFunc("/internal/faults", server.handleFaults).Methods("GET", "POST", "DELETE")
+	internal.HandleFunc("/internal/faults/{id}", server.handleFaults).Methods("DELETE")
+	internal.HandleFunc("/internal/join", server.requireSignature(server.handleJoin)).Methods("POST")
+	internal.HandleFunc("/internal/leave", server.requireSignatureOrAdmin(server.handleLeave)).Methods("POST")
+	internal.HandleFunc("/internal/membership", server.handleMembership).Methods("GET")
+	internal.HandleFunc("/internal/membership", server.requireSignature(server.handleMembership)).Methods("POST")
+	stopRaft := make(chan struct{})
+	if node.raft != nil {
+		internal.HandleFunc("/internal/raft/vote", server.requireSignature(node.raft.handleVote)).Methods("POST")
+		internal.HandleFunc("/internal/raft/append", server.requireSignature(node.raft.handleAppend)).Methods("POST")
+		internal.HandleFunc("/internal/raft/propose", server.requireSignature(node.raft.handlePropose)).Methods("POST")
+		go node.raft.Run(stopRaft)
+	}
+
+	// Pagar los REPLY que se debían al caer antes de atender peticiones
+	if owed := node.PayOwedReplies(); owed > 0 {
+		log.Printf("[%s] Queued %d replies owed since before the restart", serverID, owed)
+	}
 
 	// 7. Iniciar servidor
 	log.Printf("Distributed Reservation Server %s starting on port %s", serverID, port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	// Peticiones en curso, para esperarlas al apagar
+	inflight := NewInFlight()
+	httpServer := &http.Server{Addr: ":" + port, Handler: httperr.WithRequestID(inflight.Middleware(r))}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	var internalServer *http.Server
+	if internalTLS != nil {
+		internalServer = &http.Server{
+			Addr:      ":" + internalPort,
+			Handler:   httperr.WithRequestID(inflight.Middleware(internal)),
+			TLSConfig: internalTLS.ServerConfig(),
+		}
+		go func() {
+			if err := internalServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// 8. Anunciarse a los peers por si alguno no nos tenía en su PEERS
+	go node.Join(selfURL, selfInternalURL)
+	if node.gossip != nil {
+		go node.gossip.Run()
+	}
+
+	// Con el servidor ya escuchando, esperar a que los peers también
+	// escuchen antes de pedir la CS para crear los asientos
+	startupTimeout := time.Duration(getEnvInt("STARTUP_PEER_TIMEOUT_S", 60)) * time.Second
+	go func() {
+		server.waitForPeers(startupTimeout)
+		// Que los peers olviden lo que quedaba de la encarnación anterior
+		node.AnnounceRecovery()
+		server.reconcileAfterRestart(recoveryFrom)
+		server.ensureSeats(collection, initialize)
+	}()
+
+	// 9. Al recibir una señal, vaciar la CS, abandonar el clúster y apagar
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	log.Printf("[%s] Shutting down with %d requests in flight", serverID, inflight.Active())
+	node.Leave(10 * time.Second)
+	close(stopRaft)
+
+	// Dejar de aceptar peticiones y esperar hasta SHUTDOWN_GRACE_MS a las que
+	// están en curso antes de cerrar a la fuerza
+	shutdownGrace := time.Duration(getEnvInt("SHUTDOWN_GRACE_MS", 10000)) * time.Millisecond
+	servers := []*http.Server{httpServer}
+	if internalServer != nil {
+		servers = append(servers, internalServer)
+	}
+	drainHTTP(serverID, servers, inflight, shutdownGrace)
+
+	// Último guardado del reloj antes de salir, ya sin peticiones que lo muevan
+	close(stopPersist)
+	<-persistDone
+	log.Printf("[%s] Shutdown complete", serverID)
 }
 
-// initializeSeats crea los asientos en la BD si no existen
-func initializeSeats(collection *mongo.Collection) {
+// initializeSeats crea los asientos en la BD si no existen; el porcentaje
+// seatInit.Occupancy de ellos empieza reservado
+func initializeSeats(collection seatCollection, seatInit SeatInit) {
 	count, err := collection.CountDocuments(context.Background(), bson.M{})
 	if err != nil {
 		log.Printf("Failed to count seats: %v", err)
@@ -373,18 +1105,42 @@ func initializeSeats(collection *mongo.Collection) {
 	}
 
 	if count == 0 {
-		log.Println("Initializing 20 seats in the database...")
+		reservados := preReservedSeats(20, seatInit)
+		log.Printf("Initializing 20 seats in the database (%d pre-reserved, occupancy %d%%, seed %d)...",
+			len(reservados), seatInit.Occupancy, seatInit.Seed)
+		ocupado := make(map[int]bool)
+		for _, numero := range reservados {
+			ocupado[numero] = true
+		}
+
 		var asientos []interface{}
 		for i := 1; i <= 20; i++ {
-			asientos = append(asientos, Asiento{
-				Numero:     i,
-				Disponible: true,
-				UpdatedAt:  time.Now(),
-			})
+			asiento := Asiento{AsientoBase: models.AsientoBase{
+				AsientoEstado: models.AsientoEstado{Numero: i, Disponible: true},
+				UpdatedAt:     time.Now(),
+			}}
+			if ocupado[i] {
+				asiento.Disponible = false
+				asiento.Cliente = occupancyClient
+			}
+			asientos = append(asientos, asiento)
 		}
 		_, err := collection.InsertMany(context.Background(), asientos)
 		if err != nil {
 			log.Printf("Failed to initialize seats: %v", err)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// getEnvInt lee una variable de entorno entera, usando def si no está definida
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer, got %q", key, value)
+	}
+	return n
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// MembershipChange es el cuerpo de /internal/join y /internal/leave
+type MembershipChange struct {
+	NodeID            string `json:"node_id"`
+	URL               string `json:"url,omitempty"`
+	InternalURL       string `json:"internal_url,omitempty"` // listener mTLS, si lo hay
+	MembershipVersion int64  `json:"membership_version"`
+}
+
+// PeerList devuelve una copia de la lista de peers actual
+func (n *Node) PeerList() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	peers := make([]string, len(n.Peers))
+	copy(peers, n.Peers)
+	return peers
+}
+
+// ErrUnknownSender indica que el mensaje viene de un nodo que no es miembro
+// del clúster
+var ErrUnknownSender = errors.New("sender is not a member of the cluster")
+
+// admitSender comprueba que el emisor de un mensaje sea un peer. Un nodo que
+// no está en Peers no aparece en nuestros RepliesNeeded: si se le respondiera
+// entraría en la CS sin que nosotros le pidiéramos paso, y podría dejar sin
+// turno a los demás. Por defecto el mensaje se rechaza; con
+// AutoRegisterPeers el emisor se incorpora a la membresía como si hubiera
+// llamado a /internal/join.
+func (n *Node) admitSender(msg Message) error {
+	if n.IsPeer(msg.NodeID) {
+		return nil
+	}
+	n.stats.recordUnknownSender()
+	if !n.AutoRegisterPeers || msg.NodeID == n.ID {
+		n.logf("WARNING: rejecting %s from unknown node %s (not in PEERS; AUTO_REGISTER_PEERS=true admits it)",
+			msg.Type, msg.NodeID)
+		return fmt.Errorf("%w: %s", ErrUnknownSender, msg.NodeID)
+	}
+
+	url := ""
+	if _, err := n.peerBaseURL(msg.NodeID); err != nil && n.autoRegisterURL != nil {
+		url = n.autoRegisterURL(msg.NodeID)
+	}
+	n.logf("WARNING: auto-registering unknown node %s after its %s", msg.NodeID, msg.Type)
+	n.AddPeer(msg.NodeID, url)
+	return nil
+}
+
+// IsPeer indica si id es uno de los peers actuales del nodo (no el propio)
+func (n *Node) IsPeer(id string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.isPeerLocked(id)
+}
+
+// isPeerLocked es IsPeer con el mutex ya adquirido
+func (n *Node) isPeerLocked(id string) bool {
+	for _, peer := range n.Peers {
+		if peer == id {
+			return true
+		}
+	}
+	return false
+}
+
+// MembershipVersion devuelve la versión de la membresía conocida por el nodo
+func (n *Node) MembershipVersion() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.membershipVersion
+}
+
+// SetPeerURL registra la URL base de un peer
+func (n *Node) SetPeerURL(peerID, url string) {
+	n.urlsMu.Lock()
+	defer n.urlsMu.Unlock()
+	n.peerURLs[peerID] = url
+}
+
+// AddPeer incorpora un nuevo nodo al clúster. Las peticiones a la CS que ya
+// están en curso no lo esperan: solo necesitan las respuestas de la membresía
+// que existía cuando se emitieron.
+func (n *Node) AddPeer(peerID, url string) {
+	if url != "" {
+		n.SetPeerURL(peerID, url)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if peerID == n.ID {
+		return
+	}
+	for _, p := range n.Peers {
+		if p == peerID {
+			return
+		}
+	}
+
+	n.Peers = append(n.Peers, peerID)
+	n.membershipVersion++
+	log.Printf("[%s] Peer %s joined (url: %s). Membership version: %d", n.ID, peerID, url, n.membershipVersion)
+}
+
+// RemovePeer elimina un nodo que abandona el clúster. Si la petición en curso
+// aún esperaba su respuesta, deja de esperarla.
+func (n *Node) RemovePeer(peerID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	found := false
+	peers := n.Peers[:0:0]
+	for _, p := range n.Peers {
+		if p == peerID {
+			found = true
+			continue
+		}
+		peers = append(peers, p)
+	}
+	if !found {
+		return
+	}
+	n.Peers = peers
+	n.membershipVersion++
+
+	waited := n.forgetPeer(peerID, "peer left the cluster")
+	log.Printf("[%s] Peer %s left. Membership version: %d", n.ID, peerID, n.membershipVersion)
+	if waited {
+		n.enterIfNoRepliesNeeded()
+	}
+}
+
+// forgetPeer borra el estado del algoritmo que se refiere a un peer que ya no
+// es miembro. Devuelve true si la petición en curso esperaba algo de él.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) forgetPeer(peerID, reason string) bool {
+	// Un nodo que se va no tiene peticiones pendientes, no le debemos nada
+	deferred := n.DeferredReplies[:0:0]
+	for _, id := range n.DeferredReplies {
+		if id != peerID {
+			deferred = append(deferred, id)
+		}
+	}
+	n.DeferredReplies = deferred
+	n.markStateChanged()
+	delete(n.excluded, peerID)
+	delete(n.hasGrant, peerID)
+	n.replies.Drop(peerID, reason)
+
+	// Su petición encolada no se liberará nunca: puede que fuera la que nos
+	// impedía entrar
+	unblocked := false
+	if n.lamportQueue() {
+		queued := len(n.queue)
+		n.dequeueRequest(peerID)
+		unblocked = len(n.queue) < queued
+	}
+
+	// Si la petición en curso aún esperaba su respuesta, deja de esperarla
+	if n.State == Wanted && n.RepliesNeeded[peerID] {
+		delete(n.RepliesNeeded, peerID)
+		n.logf("No longer waiting for removed peer %s. Needed: %d", peerID, len(n.RepliesNeeded))
+		return true
+	}
+	return unblocked
+}
+
+// enterIfNoRepliesNeeded entra en la CS si la petición en curso ya no espera
+// ninguna respuesta después de que forgetPeer dejara de esperar a alguien.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) enterIfNoRepliesNeeded() {
+	if n.lamportQueue() {
+		n.tryEnterLamport()
+		return
+	}
+	if n.State == Wanted && len(n.RepliesNeeded) == 0 {
+		n._enterCS()
+	}
+}
+
+// ReplacePeers sustituye de una vez toda la membresía por la de urls (ID del
+// peer -> URL base). La petición a la CS que esté en curso sigue esperando
+// solo a los peers a los que envió su REQUEST: los nuevos no se le añaden, y
+// los que salen dejan de esperarse en lugar de bloquearla para siempre.
+func (n *Node) ReplacePeers(urls map[string]string) (added, removed []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	current := make(map[string]bool, len(n.Peers))
+	for _, p := range n.Peers {
+		current[p] = true
+		if _, keep := urls[p]; !keep {
+			removed = append(removed, p)
+		}
+	}
+
+	peers := make([]string, 0, len(urls))
+	for id := range urls {
+		if id == n.ID {
+			continue
+		}
+		peers = append(peers, id)
+		if !current[id] {
+			added = append(added, id)
+		}
+	}
+	sort.Strings(peers)
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	n.urlsMu.Lock()
+	for id, url := range urls {
+		if id != n.ID {
+			n.peerURLs[id] = url
+		}
+	}
+	for _, id := range removed {
+		delete(n.peerURLs, id)
+		delete(n.internalURLs, id)
+	}
+	n.urlsMu.Unlock()
+
+	n.Peers = peers
+	if len(added)+len(removed) > 0 {
+		n.membershipVersion++
+	}
+	waited := false
+	for _, id := range removed {
+		if n.forgetPeer(id, "peer removed from the cluster") {
+			waited = true
+		}
+	}
+	n.logf("Peers replaced: %v (added: %v, removed: %v). Membership version: %d",
+		peers, added, removed, n.membershipVersion)
+	if waited {
+		n.enterIfNoRepliesNeeded()
+	}
+
+	return added, removed
+}
+
+// PeerURLs devuelve la URL base de cada peer actual
+func (n *Node) PeerURLs() map[string]string {
+	peers := n.PeerList()
+
+	urls := make(map[string]string, len(peers))
+	for _, p := range peers {
+		urls[p], _ = n.peerBaseURL(p)
+	}
+	return urls
+}
+
+// checkMembershipVersion compara la versión recibida en un mensaje con la
+// local y avisa si los nodos tienen vistas distintas del clúster
+func (n *Node) checkMembershipVersion(msg Message) {
+	local := n.MembershipVersion()
+	if msg.MembershipVersion != local {
+		log.Printf("[%s] WARNING: membership diverged from %s (theirs: %d, ours: %d)",
+			n.ID, msg.NodeID, msg.MembershipVersion, local)
+	}
+}
+
+// Join anuncia este nodo a todos sus peers. Adopta la mayor versión de
+// membresía que le devuelvan para quedar alineado con el clúster.
+func (n *Node) Join(selfURL, internalURL string) {
+	change := MembershipChange{NodeID: n.ID, URL: selfURL, InternalURL: internalURL}
+	for _, peer := range n.PeerList() {
+		var resp MembershipChange
+		if err := n.postMembership(peer, "/internal/join", change, &resp); err != nil {
+			log.Printf("[%s] Failed to announce join to %s: %v", n.ID, peer, err)
+			continue
+		}
+
+		n.mu.Lock()
+		if resp.MembershipVersion > n.membershipVersion {
+			n.membershipVersion = resp.MembershipVersion
+		}
+		n.mu.Unlock()
+	}
+}
+
+// Leave espera a que el nodo salga de la sección crítica (o deje de
+// esperarla) y después anuncia su salida a todos los peers.
+func (n *Node) Leave(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		n.mu.Lock()
+		state := n.State
+		n.mu.Unlock()
+
+		if state == Released {
+			break
+		}
+		if time.Now().After(deadline) {
+			log.Printf("[%s] WARNING: leaving while still %s", n.ID, state)
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	change := MembershipChange{NodeID: n.ID, MembershipVersion: n.MembershipVersion()}
+	for _, peer := range n.PeerList() {
+		if err := n.postMembership(peer, "/internal/leave", change, nil); err != nil {
+			log.Printf("[%s] Failed to announce leave to %s: %v", n.ID, peer, err)
+		}
+	}
+	log.Printf("[%s] Left the cluster", n.ID)
+}
+
+// postMembership envía un cambio de membresía a un peer
+func (n *Node) postMembership(peerID, path string, change MembershipChange, out interface{}) error {
+	jsonData, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	base, err := n.internalBaseURL(peerID)
+	if err != nil {
+		return err
+	}
+
+	req, err := n.newSignedPost(base+path, jsonData)
+	if err != nil {
+		return err
+	}
+	// Sin firma, la salida solo se acepta con el token de administración
+	if path == "/internal/leave" && !n.signer.Enabled() && n.adminToken != "" {
+		req.Header.Set("X-Admin-Token", n.adminToken)
+	}
+
+	client := http.Client{Timeout: 2 * time.Second, Transport: n.client.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newLeaveServer expone /internal/leave de node1, con peers node2 y node3,
+// protegido como en main
+func newLeaveServer(t *testing.T, secret, adminToken string) (*Server, *httptest.Server) {
+	t.Helper()
+	node := newSimNode("node1", []string{"node2", "node3"})
+	node.signer = NewMessageSigner(secret, "", time.Time{})
+	s := NewServer(node, nil, nil, "node1")
+	s.adminToken = adminToken
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/internal/leave", s.requireSignatureOrAdmin(s.handleLeave))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func postLeave(t *testing.T, url string, header http.Header) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url+"/internal/leave", bytes.NewReader([]byte(`{"node_id":"node3"}`)))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestLeaveRequiresSignatureOrAdminToken(t *testing.T) {
+	body := []byte(`{"node_id":"node3"}`)
+	signed := http.Header{signatureHeader: {NewMessageSigner("secreto", "", time.Time{}).SignRequest("POST", "/internal/leave", body)}}
+	admin := http.Header{"X-Admin-Token": {"token"}}
+
+	cases := []struct {
+		name, secret, token string
+		header              http.Header
+		want                int
+	}{
+		{"unsigned, nothing configured", "", "", nil, http.StatusForbidden},
+		{"unsigned with admin token configured", "", "token", nil, http.StatusUnauthorized},
+		{"wrong admin token", "", "token", http.Header{"X-Admin-Token": {"otro"}}, http.StatusUnauthorized},
+		{"admin token", "", "token", admin, http.StatusOK},
+		{"unsigned with cluster secret", "secreto", "", nil, http.StatusUnauthorized},
+		{"signed", "secreto", "", signed, http.StatusOK},
+		{"admin token on a signed cluster", "secreto", "token", admin, http.StatusOK},
+	}
+	for _, tc := range cases {
+		s, ts := newLeaveServer(t, tc.secret, tc.token)
+		if status := postLeave(t, ts.URL, tc.header); status != tc.want {
+			t.Errorf("%s: answered %d, want %d", tc.name, status, tc.want)
+		}
+		peers := s.node.PeerList()
+		removed := reflect.DeepEqual(peers, []string{"node2"})
+		if removed != (tc.want == http.StatusOK) {
+			t.Errorf("%s: peers after leave %v", tc.name, peers)
+		}
+	}
+}
+
+func TestLeaveAnnouncementIsAccepted(t *testing.T) {
+	for _, tc := range []struct{ name, secret, token string }{
+		{"signed cluster", "secreto", ""},
+		{"admin token only", "", "token"},
+	} {
+		s, ts := newLeaveServer(t, tc.secret, tc.token)
+
+		leaving := newSimNode("node3", []string{"node1"})
+		leaving.signer = NewMessageSigner(tc.secret, "", time.Time{})
+		leaving.adminToken = tc.token
+		leaving.SetPeerInternalURL("node1", ts.URL)
+		leaving.Leave(time.Second)
+
+		if peers := s.node.PeerList(); !reflect.DeepEqual(peers, []string{"node2"}) {
+			t.Errorf("%s: node1 still lists %v after node3 left", tc.name, peers)
+		}
+	}
+}
+
+// Node9 no está en la lista de peers de node1 y le envía un REQUEST. Por
+// defecto node1 lo rechaza sin tocar su estado; con AutoRegisterPeers lo
+// incorpora a la membresía y, a partir de ahí, también espera su respuesta
+// para entrar en la CS.
+func TestUnknownSender(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node1 := c.Node("node1")
+	stranger := newSimNode("node9", []string{"node1"})
+	c.Network.Attach(stranger)
+
+	request := func() (int, error) {
+		stranger.mu.Lock()
+		msg := Message{Type: "REQUEST", NodeID: "node9", Timestamp: stranger.Clock.Increment(), Round: 1}
+		stranger.mu.Unlock()
+		status, _ := node1.receiveMessage(msg)
+		_, err := node1.handleMessage(msg)
+		return status, err
+	}
+
+	status, err := request()
+	if status != http.StatusForbidden || !errors.Is(err, ErrUnknownSender) {
+		t.Fatalf("expected node1 to reject node9 with 403, got %d (%v)", status, err)
+	}
+	node1.mu.Lock()
+	_, tracked := node1.peerRounds["node9"]
+	node1.mu.Unlock()
+	if tracked || node1.IsPeer("node9") {
+		t.Fatal("node1 kept state for the rejected node9")
+	}
+	if n := node1.MessageStats().UnknownSenders; n != 2 {
+		t.Fatalf("expected unknown_senders=2, got %d", n)
+	}
+
+	node1.AutoRegisterPeers = true
+	version := node1.MembershipVersion()
+	if status, err := request(); status != http.StatusOK || err != nil {
+		t.Fatalf("expected node1 to admit node9, got %d (%v)", status, err)
+	}
+	if !node1.IsPeer("node9") || node1.MembershipVersion() != version+1 {
+		t.Fatal("node1 did not add node9 to its membership")
+	}
+	if n := node1.MessageStats().UnknownSenders; n != 3 {
+		t.Fatalf("expected unknown_senders=3, got %d", n)
+	}
+
+	// El clúster vuelve a ser simétrico: node1 necesita también a node9
+	before := stranger.MessageStats().Received["REQUEST"]
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+	if got := stranger.MessageStats().Received["REQUEST"]; got != before+1 {
+		t.Fatal("node1 entered the CS without asking node9")
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MessageStats cuenta los mensajes del algoritmo por peer y tipo, las
+// entradas en la CS y cuánto se espera y se permanece en ella, para comparar
+// el coste de cada algoritmo. Todos los contadores van protegidos por mu, así
+// que pueden actualizarse desde varios handlers a la vez.
+type MessageStats struct {
+	mu        sync.Mutex
+	sent      map[string]map[string]uint64 // peer -> tipo -> mensajes
+	received  map[string]map[string]uint64
+	csEntries uint64
+	// Latencia de los envíos correctos (ida y vuelta HTTP)
+	latencyTotal time.Duration
+	latencyCount uint64
+	// Desde RequestCS hasta entrar en la CS, y desde entrar hasta salir
+	wait durationStat
+	held durationStat
+	// Mayor número de respuestas pospuestas acumuladas a la vez
+	maxDeferred int
+	// Fallos inyectados desde /internal/faults
+	injected map[injectedKey]uint64
+	// Mensajes rechazados por llevar una firma ausente o incorrecta
+	rejectedSignatures uint64
+	// Estancias en la CS que el watchdog liberó a la fuerza
+	forcedReleases uint64
+	// Violaciones de la exclusión mutua detectadas por los anuncios HELD
+	splitBrain uint64
+	// Mensajes de nodos que no están en la lista de peers
+	unknownSenders uint64
+	// Lotes enviados por BatchingTransport y mensajes que llevaron
+	batches         uint64
+	batchedMessages uint64
+}
+
+// injectedKey identifica un contador de fallos inyectados
+type injectedKey struct {
+	action    string
+	direction string
+	msgType   string
+}
+
+// durationStat acumula duraciones para publicar su suma, número y máximo
+type durationStat struct {
+	count uint64
+	sum   time.Duration
+	max   time.Duration
+}
+
+func (d *durationStat) observe(v time.Duration) {
+	d.count++
+	d.sum += v
+	if v > d.max {
+		d.max = v
+	}
+}
+
+// DurationSnapshot es la vista de un durationStat en milisegundos
+type DurationSnapshot struct {
+	Count uint64  `json:"count"`
+	AvgMs float64 `json:"avg_ms"`
+	MaxMs float64 `json:"max_ms"`
+	SumMs float64 `json:"sum_ms"`
+}
+
+func (d durationStat) snapshot() DurationSnapshot {
+	snap := DurationSnapshot{
+		Count: d.count,
+		MaxMs: durationMs(d.max),
+		SumMs: durationMs(d.sum),
+	}
+	if d.count > 0 {
+		snap.AvgMs = snap.SumMs / float64(d.count)
+	}
+	return snap
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// newMessageStats crea contadores vacíos
+func newMessageStats() *MessageStats {
+	return &MessageStats{
+		sent:     make(map[string]map[string]uint64),
+		received: make(map[string]map[string]uint64),
+		injected: make(map[injectedKey]uint64),
+	}
+}
+
+// countMessage suma un mensaje en la tabla peer -> tipo
+func countMessage(table map[string]map[string]uint64, peerID, msgType string) {
+	byType, ok := table[peerID]
+	if !ok {
+		byType = make(map[string]uint64)
+		table[peerID] = byType
+	}
+	byType[msgType]++
+}
+
+func (s *MessageStats) recordSent(peerID, msgType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	countMessage(s.sent, peerID, msgType)
+}
+
+func (s *MessageStats) recordReceived(peerID, msgType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	countMessage(s.received, peerID, msgType)
+}
+
+func (s *MessageStats) recordLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencyTotal += d
+	s.latencyCount++
+}
+
+// recordEntry cuenta una entrada en la CS tras esperar wait
+func (s *MessageStats) recordEntry(wait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.csEntries++
+	s.wait.observe(wait)
+}
+
+// recordHeld anota cuánto tiempo se ocupó la CS
+func (s *MessageStats) recordHeld(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.held.observe(d)
+}
+
+// recordDeferred anota el número actual de respuestas pospuestas
+func (s *MessageStats) recordDeferred(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size > s.maxDeferred {
+		s.maxDeferred = size
+	}
+}
+
+// recordInjected cuenta un mensaje descartado o retrasado a propósito
+func (s *MessageStats) recordInjected(action, direction, msgType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.injected[injectedKey{action, direction, msgType}]++
+}
+
+// recordRejectedSignature cuenta un mensaje rechazado por su firma
+func (s *MessageStats) recordRejectedSignature() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejectedSignatures++
+}
+
+// recordForcedRelease cuenta una CS liberada por el watchdog
+func (s *MessageStats) recordForcedRelease() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forcedReleases++
+}
+
+// recordUnknownSender cuenta un mensaje de un nodo que no es peer
+func (s *MessageStats) recordUnknownSender() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unknownSenders++
+}
+
+// recordBatch cuenta un lote enviado con size mensajes
+func (s *MessageStats) recordBatch(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	s.batchedMessages += uint64(size)
+}
+
+// recordSplitBrain cuenta una violación de la exclusión mutua detectada
+func (s *MessageStats) recordSplitBrain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.splitBrain++
+}
+
+// InjectedFaults cuenta los mensajes afectados por una acción, sentido y tipo
+type InjectedFaults struct {
+	Action    string `json:"action"`
+	Direction string `json:"direction"`
+	Type      string `json:"type"`
+	Count     uint64 `json:"count"`
+}
+
+// MessageStatsSnapshot es la vista de los contadores que publica
+// /internal/stats
+type MessageStatsSnapshot struct {
+	Algorithm string            `json:"algorithm"`
+	Sent      map[string]uint64 `json:"messages_sent"`
+	Received  map[string]uint64 `json:"messages_received"`
+	// Los mismos mensajes desglosados por peer: peer -> tipo -> mensajes
+	SentByPeer     map[string]map[string]uint64 `json:"messages_sent_by_peer"`
+	ReceivedByPeer map[string]map[string]uint64 `json:"messages_received_by_peer"`
+	TotalSent      uint64                       `json:"total_sent"`
+	CSEntries      uint64                       `json:"cs_entries"`
+	// Mensajes enviados por este nodo por cada entrada propia en la CS:
+	// ~2(N-1) con Ricart-Agrawala y ~3(N-1) con la cola de Lamport si solo
+	// este nodo pide la CS
+	SentPerEntry float64 `json:"sent_per_entry"`
+	// Latencia media de ida y vuelta de un mensaje; con conexiones
+	// reutilizadas no incluye el establecimiento de TCP
+	AvgSendLatencyMs float64          `json:"avg_send_latency_ms"`
+	CSWait           DurationSnapshot `json:"cs_wait"`
+	CSHeld           DurationSnapshot `json:"cs_held"`
+	DeferredReplies  int              `json:"deferred_replies"`
+	MaxDeferred      int              `json:"max_deferred_replies"`
+	InjectedFaults   []InjectedFaults `json:"injected_faults"`
+
+	// Mensajes de /internal/message rechazados por su firma
+	RejectedSignatures uint64 `json:"rejected_signatures"`
+	// REPLY pospuestos que aún no han llegado a su destino
+	ReplyOutbox ReplyOutboxStats `json:"reply_outbox"`
+	// Estancias en la CS que superaron MaxHold y el watchdog liberó
+	ForcedReleases uint64 `json:"forced_releases"`
+	// Veces que otro nodo anunció tener la CS mientras este la tenía
+	SplitBrainDetected uint64 `json:"split_brain_detected"`
+	// Mensajes recibidos de nodos que no están en la lista de peers
+	UnknownSenders uint64 `json:"unknown_senders"`
+	// Lotes enviados con MESSAGE_BATCH_WINDOW_MS y su tamaño medio: cuanto
+	// más se acerque a 1, menos se están agrupando los mensajes
+	BatchesSent  uint64  `json:"batches_sent"`
+	AvgBatchSize float64 `json:"avg_batch_size"`
+}
+
+// MessageStats devuelve una copia de los contadores del nodo
+func (n *Node) MessageStats() MessageStatsSnapshot {
+	n.mu.Lock()
+	deferred := len(n.DeferredReplies)
+	n.mu.Unlock()
+
+	s := n.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := MessageStatsSnapshot{
+		Algorithm:       n.Algorithm,
+		Sent:            make(map[string]uint64),
+		Received:        make(map[string]uint64),
+		SentByPeer:      make(map[string]map[string]uint64, len(s.sent)),
+		ReceivedByPeer:  make(map[string]map[string]uint64, len(s.received)),
+		CSEntries:       s.csEntries,
+		CSWait:          s.wait.snapshot(),
+		CSHeld:          s.held.snapshot(),
+		DeferredReplies: deferred,
+		MaxDeferred:     s.maxDeferred,
+	}
+	for peer, byType := range s.sent {
+		snap.SentByPeer[peer] = make(map[string]uint64, len(byType))
+		for t, c := range byType {
+			snap.SentByPeer[peer][t] = c
+			snap.Sent[t] += c
+			snap.TotalSent += c
+		}
+	}
+	for peer, byType := range s.received {
+		snap.ReceivedByPeer[peer] = make(map[string]uint64, len(byType))
+		for t, c := range byType {
+			snap.ReceivedByPeer[peer][t] = c
+			snap.Received[t] += c
+		}
+	}
+	snap.RejectedSignatures = s.rejectedSignatures
+	snap.ReplyOutbox = n.replies.Stats()
+	snap.ForcedReleases = s.forcedReleases
+	snap.SplitBrainDetected = s.splitBrain
+	snap.UnknownSenders = s.unknownSenders
+	snap.BatchesSent = s.batches
+	if s.batches > 0 {
+		snap.AvgBatchSize = float64(s.batchedMessages) / float64(s.batches)
+	}
+	snap.InjectedFaults = make([]InjectedFaults, 0, len(s.injected))
+	for key, c := range s.injected {
+		snap.InjectedFaults = append(snap.InjectedFaults, InjectedFaults{
+			Action:    key.action,
+			Direction: key.direction,
+			Type:      key.msgType,
+			Count:     c,
+		})
+	}
+	sort.Slice(snap.InjectedFaults, func(i, j int) bool {
+		a, b := snap.InjectedFaults[i], snap.InjectedFaults[j]
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		if a.Direction != b.Direction {
+			return a.Direction < b.Direction
+		}
+		return a.Type < b.Type
+	})
+	if s.csEntries > 0 {
+		snap.SentPerEntry = float64(snap.TotalSent) / float64(s.csEntries)
+	}
+	if s.latencyCount > 0 {
+		snap.AvgSendLatencyMs = float64(s.latencyTotal.Microseconds()) / float64(s.latencyCount) / 1000
+	}
+	return snap
+}
+
+// WritePrometheus escribe las métricas en el formato de texto de Prometheus
+func (snap MessageStatsSnapshot) WritePrometheus(w io.Writer, nodeID string) {
+	node := fmt.Sprintf("node=%q,algorithm=%q", nodeID, snap.Algorithm)
+
+	writeMessageCounters(w, "dme_messages_sent_total", "Messages sent to each peer, by type.", node, snap.SentByPeer)
+	writeMessageCounters(w, "dme_messages_received_total", "Messages received from each peer, by type.", node, snap.ReceivedByPeer)
+
+	fmt.Fprintf(w, "# HELP dme_cs_entries_total Entries into the critical section.\n")
+	fmt.Fprintf(w, "# TYPE dme_cs_entries_total counter\n")
+	fmt.Fprintf(w, "dme_cs_entries_total{%s} %d\n", node, snap.CSEntries)
+
+	writeDurationSummary(w, "dme_cs_wait_seconds", "Time from RequestCS to entering the critical section.", node, snap.CSWait)
+	writeDurationSummary(w, "dme_cs_held_seconds", "Time spent inside the critical section.", node, snap.CSHeld)
+
+	fmt.Fprintf(w, "# HELP dme_cs_forced_releases_total Critical sections released by the hold watchdog.\n")
+	fmt.Fprintf(w, "# TYPE dme_cs_forced_releases_total counter\n")
+	fmt.Fprintf(w, "dme_cs_forced_releases_total{%s} %d\n", node, snap.ForcedReleases)
+
+	fmt.Fprintf(w, "# HELP dme_split_brain_detected_total Times another node announced holding the critical section while this one held it.\n")
+	fmt.Fprintf(w, "# TYPE dme_split_brain_detected_total counter\n")
+	fmt.Fprintf(w, "dme_split_brain_detected_total{%s} %d\n", node, snap.SplitBrainDetected)
+	fmt.Fprintf(w, "# HELP dme_unknown_sender_messages_total Messages received from nodes that are not peers.\n")
+	fmt.Fprintf(w, "# TYPE dme_unknown_sender_messages_total counter\n")
+	fmt.Fprintf(w, "dme_unknown_sender_messages_total{%s} %d\n", node, snap.UnknownSenders)
+
+	fmt.Fprintf(w, "# HELP dme_deferred_replies Replies currently deferred by this node.\n")
+	fmt.Fprintf(w, "# TYPE dme_deferred_replies gauge\n")
+	fmt.Fprintf(w, "dme_deferred_replies{%s} %d\n", node, snap.DeferredReplies)
+	fmt.Fprintf(w, "# HELP dme_deferred_replies_max Largest number of replies deferred at once.\n")
+	fmt.Fprintf(w, "# TYPE dme_deferred_replies_max gauge\n")
+	fmt.Fprintf(w, "dme_deferred_replies_max{%s} %d\n", node, snap.MaxDeferred)
+
+	fmt.Fprintf(w, "# HELP dme_reply_outbox_depth Deferred replies waiting to be delivered.\n")
+	fmt.Fprintf(w, "# TYPE dme_reply_outbox_depth gauge\n")
+	fmt.Fprintf(w, "dme_reply_outbox_depth{%s} %d\n", node, snap.ReplyOutbox.Depth)
+	fmt.Fprintf(w, "# HELP dme_reply_outbox_oldest_seconds Age of the oldest undelivered deferred reply.\n")
+	fmt.Fprintf(w, "# TYPE dme_reply_outbox_oldest_seconds gauge\n")
+	fmt.Fprintf(w, "dme_reply_outbox_oldest_seconds{%s} %g\n", node, snap.ReplyOutbox.OldestAgeMs/1000)
+	fmt.Fprintf(w, "# HELP dme_reply_outbox_delivered_total Deferred replies delivered through the outbox.\n")
+	fmt.Fprintf(w, "# TYPE dme_reply_outbox_delivered_total counter\n")
+	fmt.Fprintf(w, "dme_reply_outbox_delivered_total{%s} %d\n", node, snap.ReplyOutbox.Delivered)
+	fmt.Fprintf(w, "# HELP dme_reply_outbox_dropped_total Deferred replies dropped because the peer was declared dead or left.\n")
+	fmt.Fprintf(w, "# TYPE dme_reply_outbox_dropped_total counter\n")
+	fmt.Fprintf(w, "dme_reply_outbox_dropped_total{%s} %d\n", node, snap.ReplyOutbox.Dropped)
+
+	fmt.Fprintf(w, "# HELP dme_send_latency_seconds_avg Average round trip of a message to a peer.\n")
+	fmt.Fprintf(w, "# TYPE dme_send_latency_seconds_avg gauge\n")
+	fmt.Fprintf(w, "dme_send_latency_seconds_avg{%s} %g\n", node, snap.AvgSendLatencyMs/1000)
+
+	fmt.Fprintf(w, "# HELP dme_message_batches_total Message batches sent to peers.\n")
+	fmt.Fprintf(w, "# TYPE dme_message_batches_total counter\n")
+	fmt.Fprintf(w, "dme_message_batches_total{%s} %d\n", node, snap.BatchesSent)
+	fmt.Fprintf(w, "# HELP dme_message_batch_size_avg Average number of messages per batch.\n")
+	fmt.Fprintf(w, "# TYPE dme_message_batch_size_avg gauge\n")
+	fmt.Fprintf(w, "dme_message_batch_size_avg{%s} %g\n", node, snap.AvgBatchSize)
+
+	fmt.Fprintf(w, "# HELP dme_rejected_signatures_total Internal messages rejected for a missing or invalid signature.\n")
+	fmt.Fprintf(w, "# TYPE dme_rejected_signatures_total counter\n")
+	fmt.Fprintf(w, "dme_rejected_signatures_total{%s} %d\n", node, snap.RejectedSignatures)
+
+	fmt.Fprintf(w, "# HELP dme_faults_injected_total Messages dropped or delayed by fault injection rules.\n")
+	fmt.Fprintf(w, "# TYPE dme_faults_injected_total counter\n")
+	for _, f := range snap.InjectedFaults {
+		fmt.Fprintf(w, "dme_faults_injected_total{%s,action=%q,direction=%q,type=%q} %d\n",
+			node, f.Action, f.Direction, f.Type, f.Count)
+	}
+}
+
+// writeMessageCounters escribe una tabla peer -> tipo como contador, en
+// orden fijo para que la salida sea estable entre scrapes
+func writeMessageCounters(w io.Writer, name, help, node string, table map[string]map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, peer := range sortedKeys(table) {
+		byType := table[peer]
+		types := make([]string, 0, len(byType))
+		for t := range byType {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			fmt.Fprintf(w, "%s{%s,peer=%q,type=%q} %d\n", name, node, peer, t, byType[t])
+		}
+	}
+}
+
+// writeDurationSummary escribe un durationStat como summary sin cuantiles,
+// más su máximo como gauge aparte
+func writeDurationSummary(w io.Writer, name, help, node string, d DurationSnapshot) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s summary\n", name)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, node, d.SumMs/1000)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, node, d.Count)
+	fmt.Fprintf(w, "# HELP %s_max Longest observed value.\n", name)
+	fmt.Fprintf(w, "# TYPE %s_max gauge\n", name)
+	fmt.Fprintf(w, "%s_max{%s} %g\n", name, node, d.MaxMs/1000)
+}
+
+func sortedKeys(m map[string]map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
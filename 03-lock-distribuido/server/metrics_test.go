@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Los contadores admiten actualizaciones desde muchos handlers a la vez
+func TestMessageStatsConcurrentUpdates(t *testing.T) {
+	s := newMessageStats()
+	const workers, each = 8, 500
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			peer := fmt.Sprintf("node%d", w%2+2)
+			for i := 0; i < each; i++ {
+				s.recordSent(peer, "REQUEST")
+				s.recordReceived(peer, "REPLY")
+				s.recordEntry(time.Millisecond)
+				s.recordHeld(2 * time.Millisecond)
+				s.recordDeferred(w)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	node := newSimNode("node1", []string{"node2", "node3"})
+	node.stats = s
+	snap := node.MessageStats()
+	total := uint64(workers * each)
+	if snap.Sent["REQUEST"] != total || snap.Received["REPLY"] != total {
+		t.Fatalf("expected %d messages each way, got sent %v received %v", total, snap.Sent, snap.Received)
+	}
+	if snap.SentByPeer["node2"]["REQUEST"] != total/2 || snap.SentByPeer["node3"]["REQUEST"] != total/2 {
+		t.Fatalf("expected the messages split between node2 and node3, got %v", snap.SentByPeer)
+	}
+	if snap.CSEntries != total || snap.CSWait.Count != total || snap.CSHeld.Count != total {
+		t.Fatalf("expected %d entries, got %d (wait %d, held %d)", total, snap.CSEntries, snap.CSWait.Count, snap.CSHeld.Count)
+	}
+	if snap.CSWait.AvgMs != 1 || snap.CSHeld.MaxMs != 2 {
+		t.Fatalf("expected 1ms average wait and 2ms max hold, got %+v %+v", snap.CSWait, snap.CSHeld)
+	}
+	if snap.MaxDeferred != workers-1 {
+		t.Fatalf("expected max deferred %d, got %d", workers-1, snap.MaxDeferred)
+	}
+}
+
+// Reservar 20 asientos desde tres nodos cuesta 2(N-1) mensajes por entrada:
+// un REQUEST y un REPLY por peer, haya o no contienda
+func TestLoadTestMessageTotals(t *testing.T) {
+	ids := []string{"node1", "node2", "node3"}
+	c := NewSimCluster(ids...)
+	for _, id := range ids {
+		c.Node(id).HeldAnnounceInterval = 0
+	}
+
+	const seats = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, seats)
+	for i := 1; i <= seats; i++ {
+		wg.Add(1)
+		go func(numero int) {
+			defer wg.Done()
+			id := ids[numero%len(ids)]
+			if err := c.Reserve(id, numero, "cliente-"+id, 5*time.Second); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	var entries, requests, replies, received, waits, helds uint64
+	for _, id := range ids {
+		snap := c.Node(id).MessageStats()
+		entries += snap.CSEntries
+		requests += snap.Sent["REQUEST"]
+		replies += snap.Sent["REPLY"]
+		received += snap.Received["REQUEST"]
+		waits += snap.CSWait.Count
+		helds += snap.CSHeld.Count
+		if snap.DeferredReplies != 0 {
+			t.Errorf("%s still defers %d replies", id, snap.DeferredReplies)
+		}
+	}
+	peers := uint64(len(ids) - 1)
+	if entries != seats || waits != seats || helds != seats {
+		t.Fatalf("expected %d entries, got %d (wait %d, held %d)", seats, entries, waits, helds)
+	}
+	if requests != seats*peers || replies != seats*peers || received != requests {
+		t.Fatalf("expected %d REQUESTs and REPLYs, sent %d/%d, received %d REQUESTs", seats*peers, requests, replies, received)
+	}
+	if perEntry := float64(requests+replies) / seats; perEntry != float64(2*peers) {
+		t.Fatalf("expected %d messages per entry, got %.2f", 2*peers, perEntry)
+	}
+}
+
+func TestMetricsAndStatsEndpoints(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	c.Node("node1").HeldAnnounceInterval = 0
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+	s := &Server{node: c.Node("node1"), serverID: "node1"}
+
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("expected Prometheus text format, got %q", ct)
+	}
+	out := rec.Body.String()
+	node := `node="node1",algorithm="ricart-agrawala"`
+	for _, line := range []string{
+		`dme_messages_sent_total{` + node + `,peer="node2",type="REQUEST"} 1`,
+		`dme_messages_received_total{` + node + `,peer="node2",type="REPLY"} 1`,
+		`dme_cs_entries_total{` + node + `} 1`,
+		`dme_cs_wait_seconds_count{` + node + `} 1`,
+		`dme_cs_held_seconds_count{` + node + `} 1`,
+		`dme_deferred_replies{` + node + `} 0`,
+		"# TYPE dme_cs_wait_seconds summary",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in /metrics, got:\n%s", line, out)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleStats(rec, httptest.NewRequest(http.MethodGet, "/internal/stats", nil))
+	var stats MessageStatsSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.CSEntries != 1 || stats.SentByPeer["node2"]["REQUEST"] != 1 || stats.SentPerEntry != 1 {
+		t.Fatalf("unexpected /internal/stats: %+v", stats)
+	}
+}
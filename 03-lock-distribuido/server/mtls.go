@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// InternalTLS guarda el certificado del nodo y la CA del clúster para el
+// tráfico /internal/* con TLS mutuo: cada nodo presenta su certificado y
+// verifica el del otro extremo contra la CA.
+//
+// Los ficheros se releen con SIGHUP sin reiniciar el nodo. Las
+// configuraciones TLS consultan el estado actual en cada conexión, así que
+// las conexiones nuevas usan ya los certificados rotados.
+type InternalTLS struct {
+	certFile, keyFile, caFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// internalTLSFromEnv lee TLS_CERT_FILE, TLS_KEY_FILE y TLS_CA_FILE. Devuelve
+// nil si no hay ninguno (tráfico interno en HTTP plano).
+func internalTLSFromEnv() (*InternalTLS, error) {
+	t := &InternalTLS{
+		certFile: os.Getenv("TLS_CERT_FILE"),
+		keyFile:  os.Getenv("TLS_KEY_FILE"),
+		caFile:   os.Getenv("TLS_CA_FILE"),
+	}
+	if t.certFile == "" && t.keyFile == "" && t.caFile == "" {
+		return nil, nil
+	}
+	if t.certFile == "" || t.keyFile == "" || t.caFile == "" {
+		return nil, errors.New("TLS_CERT_FILE, TLS_KEY_FILE and TLS_CA_FILE must be set together")
+	}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewInternalTLS carga unos ficheros concretos de certificado, clave y CA
+func NewInternalTLS(certFile, keyFile, caFile string) (*InternalTLS, error) {
+	t := &InternalTLS{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload vuelve a leer los ficheros. Si alguno es inválido se conserva la
+// configuración anterior.
+func (t *InternalTLS) Reload() error {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %v", err)
+	}
+	caPEM, err := os.ReadFile(t.caFile)
+	if err != nil {
+		return fmt.Errorf("reading CA bundle: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no certificates found in CA bundle %s", t.caFile)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cert = &cert
+	t.pool = pool
+	return nil
+}
+
+func (t *InternalTLS) current() (*tls.Certificate, *x509.CertPool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.cert, t.pool
+}
+
+// ServerConfig es la configuración del listener interno: exige un
+// certificado de cliente firmado por la CA del clúster
+func (t *InternalTLS) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := t.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig es la configuración de los envíos a los peers: presenta el
+// certificado del nodo y verifica el del peer contra la CA actual. La
+// verificación estándar se sustituye por VerifyConnection solo para poder
+// usar la CA recargada; comprueba lo mismo (cadena y nombre del host).
+func (t *InternalTLS) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := t.current()
+			return cert, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("peer presented no certificate")
+			}
+			_, pool := t.current()
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
+
+// WatchReload recarga los certificados cada vez que llega SIGHUP
+func (t *InternalTLS) WatchReload(nodeID string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := t.Reload(); err != nil {
+			log.Printf("[%s] Failed to reload TLS certificates, keeping the previous ones: %v", nodeID, err)
+			continue
+		}
+		log.Printf("[%s] Reloaded TLS certificates", nodeID)
+	}
+}
+
+// UseInternalTLS hace que los envíos a los peers (mensajes, Raft y
+// membresía) usen TLS mutuo
+func (n *Node) UseInternalTLS(t *InternalTLS) {
+	transport := n.client.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig = t.ClientConfig()
+	n.client = &http.Client{Transport: transport}
+	if n.raft != nil {
+		n.raft.client.Transport = transport
+	}
+}
+
+// SetPeerInternalURL registra la URL del listener interno de un peer
+func (n *Node) SetPeerInternalURL(peerID, url string) {
+	n.urlsMu.Lock()
+	defer n.urlsMu.Unlock()
+	if n.internalURLs == nil {
+		n.internalURLs = make(map[string]string)
+	}
+	n.internalURLs[peerID] = url
+}
+
+// internalBaseURL devuelve la URL a la que enviar el tráfico /internal/* de
+// un peer: la de su listener mTLS si la conocemos, o si no su URL base
+func (n *Node) internalBaseURL(peerID string) (string, error) {
+	if n.gossip != nil {
+		if url, internalURL, ok := n.gossip.URL(peerID); ok {
+			if internalURL != "" {
+				return internalURL, nil
+			}
+			return url, nil
+		}
+	}
+
+	n.urlsMu.RLock()
+	url, ok := n.internalURLs[peerID]
+	n.urlsMu.RUnlock()
+	if ok {
+		return url, nil
+	}
+	return n.peerBaseURL(peerID)
+}
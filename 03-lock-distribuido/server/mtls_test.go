@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA es una CA autofirmada generada para la prueba
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue firma un certificado de nodo válido para localhost y 127.0.0.1, que
+// sirve tanto de servidor como de cliente, y lo escribe en dir junto con la
+// CA. Devuelve las rutas del certificado, la clave y la CA.
+func (ca *testCA) issue(t *testing.T, dir, name string) (certFile, keyFile, caFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	caFile = filepath.Join(dir, name+"-ca.crt")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	writeFile(t, caFile, ca.pem)
+	return certFile, keyFile, caFile
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadTLS carga un certificado emitido por ca para name
+func loadTLS(t *testing.T, ca *testCA, dir, name string) *InternalTLS {
+	t.Helper()
+	tlsConf, err := NewInternalTLS(ca.issue(t, dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tlsConf
+}
+
+// mtlsPeer sirve /internal/message de node por un listener con TLS mutuo
+func mtlsPeer(t *testing.T, node *Node, tlsConf *InternalTLS) *httptest.Server {
+	t.Helper()
+	s := &Server{node: node, serverID: node.ID}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(s.handleInternalMessage))
+	ts.TLS = tlsConf.ServerConfig()
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// postTo envía un mensaje vacío al peer con el cliente del nodo
+func postTo(node *Node, url string) error {
+	resp, err := node.post(url+"/internal/message", []byte(`{"type":"RELEASE","node_id":"node1","timestamp":1}`))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Dos nodos con certificados de la misma CA completan una entrada en la CS
+// por TLS mutuo
+func TestMutualTLSBetweenNodes(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "cluster-ca")
+
+	node2 := newSimNode("node2", []string{"node1"})
+	node2.HeldAnnounceInterval = 0
+	ts := mtlsPeer(t, node2, loadTLS(t, ca, dir, "node2"))
+
+	node1 := newSimNode("node1", []string{"node2"})
+	node1.HeldAnnounceInterval = 0
+	node1.UseInternalTLS(loadTLS(t, ca, dir, "node1"))
+	node1.SetPeerInternalURL("node2", ts.URL)
+
+	node1.RequestCS()
+	node1.ReleaseCS()
+	if got := node2.MessageStats().Received["REQUEST"]; got != 1 {
+		t.Fatalf("expected node2 to receive the REQUEST over mTLS, got %d", got)
+	}
+	if got := node1.MessageStats().CSEntries; got != 1 {
+		t.Fatalf("expected node1 to enter the CS, got %d entries", got)
+	}
+}
+
+// El listener interno rechaza clientes sin certificado o de otra CA, y los
+// nodos rechazan un peer cuyo certificado no firma la CA del clúster
+func TestMutualTLSRejectsUntrustedParties(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "cluster-ca")
+	rogue := newTestCA(t, "rogue-ca")
+
+	node2 := newSimNode("node2", []string{"node1"})
+	ts := mtlsPeer(t, node2, loadTLS(t, ca, dir, "node2"))
+
+	// Confía en el servidor pero no presenta certificado
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	if resp, err := anonymous.Post(ts.URL+"/internal/message", "application/json", bytes.NewReader([]byte(`{}`))); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected a client without certificate to be rejected")
+	}
+
+	// Confía en la CA del clúster pero presenta un certificado de otra
+	certFile, keyFile, _ := rogue.issue(t, dir, "intruder")
+	intruderTLS, err := NewInternalTLS(certFile, keyFile, filepath.Join(dir, "node2-ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	intruder := newSimNode("node1", []string{"node2"})
+	intruder.UseInternalTLS(intruderTLS)
+	if err := postTo(intruder, ts.URL); err == nil {
+		t.Fatal("expected a client certificate from another CA to be rejected")
+	}
+
+	// Un peer que se hace pasar por node2 con un certificado de otra CA
+	impostor := mtlsPeer(t, newSimNode("node2", []string{"node1"}), loadTLS(t, rogue, dir, "impostor"))
+	node1 := newSimNode("node1", []string{"node2"})
+	node1.UseInternalTLS(loadTLS(t, ca, dir, "node1"))
+	if err := postTo(node1, impostor.URL); err == nil {
+		t.Fatal("expected node1 to reject a peer certificate from another CA")
+	}
+	if received := node2.MessageStats().Received; len(received) != 0 {
+		t.Fatalf("expected node2 to receive nothing, got %v", received)
+	}
+}
+
+// Al rotar los certificados y recargarlos, las conexiones nuevas usan los
+// nuevos; una recarga con ficheros inválidos conserva los anteriores
+func TestInternalTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	oldCA := newTestCA(t, "ca-2025")
+	newCA := newTestCA(t, "ca-2026")
+
+	serverTLS := loadTLS(t, oldCA, dir, "node2")
+	ts := mtlsPeer(t, newSimNode("node2", []string{"node1"}), serverTLS)
+	clientTLS := loadTLS(t, oldCA, dir, "node1")
+	node1 := newSimNode("node1", []string{"node2"})
+	node1.UseInternalTLS(clientTLS)
+	if err := postTo(node1, ts.URL); err != nil {
+		t.Fatalf("expected the original certificates to work: %v", err)
+	}
+
+	// Solo el cliente rota: el servidor aún no confía en la CA nueva
+	newCA.issue(t, dir, "node1")
+	if err := clientTLS.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	node1.client.CloseIdleConnections()
+	if err := postTo(node1, ts.URL); err == nil {
+		t.Fatal("expected the rotated client certificate to be rejected by a server on the old CA")
+	}
+
+	newCA.issue(t, dir, "node2")
+	if err := serverTLS.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := postTo(node1, ts.URL); err != nil {
+		t.Fatalf("expected both sides to work after rotating: %v", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "node1.crt"), []byte("not a certificate"))
+	if err := clientTLS.Reload(); err == nil {
+		t.Fatal("expected an invalid certificate to fail the reload")
+	}
+	node1.client.CloseIdleConnections()
+	if err := postTo(node1, ts.URL); err != nil {
+		t.Fatalf("expected the previous certificates to be kept after a failed reload: %v", err)
+	}
+}
+
+func TestInternalTLSFromEnv(t *testing.T) {
+	for _, key := range []string{"TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_CA_FILE"} {
+		t.Setenv(key, "")
+	}
+	if tlsConf, err := internalTLSFromEnv(); tlsConf != nil || err != nil {
+		t.Fatalf("expected plain HTTP without TLS variables, got %v, %v", tlsConf, err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := newTestCA(t, "cluster-ca").issue(t, dir, "node1")
+	t.Setenv("TLS_CERT_FILE", certFile)
+	if _, err := internalTLSFromEnv(); err == nil {
+		t.Fatal("expected an error when only some TLS variables are set")
+	}
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	t.Setenv("TLS_CA_FILE", caFile)
+	if tlsConf, err := internalTLSFromEnv(); err != nil || tlsConf == nil {
+		t.Fatalf("expected the certificates to load, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Pausa para demostraciones: POST /admin/pause congela el algoritmo sin
+// perder el estado, como un proceso detenido un rato, y POST /admin/resume lo
+// reanuda. En pausa:
+//   - los mensajes recibidos se encolan, hasta PAUSE_QUEUE_SIZE, y se procesan
+//     al reanudar en el orden de llegada (un REQUEST se contesta como si se
+//     hubiera pospuesto: el emisor recibe el REPLY como un mensaje aparte);
+//   - los envíos esperan a que el nodo se reanude;
+//   - los endpoints de reserva responden 503.
+// Con la cola llena el mensaje se rechaza con 503 y el emisor reintenta, igual
+// que con un nodo caído.
+
+// ErrNodePaused indica que el nodo está en pausa y su cola de entrada está
+// llena
+var ErrNodePaused = errors.New("node is paused and its inbound queue is full")
+
+// defaultPauseQueueSize es cuántos mensajes se encolan como máximo en pausa
+const defaultPauseQueueSize = 1024
+
+// PauseGate retiene los mensajes del nodo mientras está en pausa
+type PauseGate struct {
+	mu     sync.Mutex
+	paused bool
+	since  time.Time
+	// Se cierra al terminar de reanudar, para soltar los envíos retenidos
+	resumed  chan struct{}
+	inbound  []Message
+	limit    int
+	rejected uint64
+}
+
+func newPauseGate(limit int) *PauseGate {
+	return &PauseGate{limit: limit}
+}
+
+// Pause pone el nodo en pausa. Devuelve false si ya lo estaba.
+func (g *PauseGate) Pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		return false
+	}
+	g.paused = true
+	g.since = time.Now()
+	g.resumed = make(chan struct{})
+	g.rejected = 0
+	return true
+}
+
+// Paused indica si el nodo está en pausa (o reanudándose)
+func (g *PauseGate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// hold encola el mensaje si el nodo está en pausa. Devuelve true si lo ha
+// encolado y ErrNodePaused si no cabe.
+func (g *PauseGate) hold(msg Message) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return false, nil
+	}
+	if len(g.inbound) >= g.limit {
+		g.rejected++
+		return false, ErrNodePaused
+	}
+	g.inbound = append(g.inbound, msg)
+	return true, nil
+}
+
+// next saca el siguiente mensaje encolado. Con la cola vacía termina la
+// pausa y suelta los envíos retenidos; así los mensajes que llegan durante
+// la reanudación se encolan detrás de los anteriores y no se adelantan.
+func (g *PauseGate) next() (Message, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		// Otra llamada a Resume ya terminó la pausa
+		return Message{}, false
+	}
+	if len(g.inbound) == 0 {
+		g.paused = false
+		close(g.resumed)
+		return Message{}, false
+	}
+	msg := g.inbound[0]
+	g.inbound = g.inbound[1:]
+	return msg, true
+}
+
+// wait bloquea mientras el nodo está en pausa
+func (g *PauseGate) wait() {
+	g.mu.Lock()
+	paused, resumed := g.paused, g.resumed
+	g.mu.Unlock()
+	if paused {
+		<-resumed
+	}
+}
+
+// PauseStatus es el estado de la pausa que devuelven los endpoints
+type PauseStatus struct {
+	NodeID   string     `json:"node_id"`
+	Paused   bool       `json:"paused"`
+	Since    *time.Time `json:"since,omitempty"`
+	Queued   int        `json:"queued"`
+	Rejected uint64     `json:"rejected"`
+	Replayed int        `json:"replayed,omitempty"`
+	PausedMs int64      `json:"paused_ms,omitempty"`
+}
+
+// status devuelve el estado actual de la pausa
+func (g *PauseGate) status(nodeID string) PauseStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	status := PauseStatus{
+		NodeID:   nodeID,
+		Paused:   g.paused,
+		Queued:   len(g.inbound),
+		Rejected: g.rejected,
+	}
+	if g.paused {
+		since := g.since
+		status.Since = &since
+	}
+	return status
+}
+
+// Pause congela el algoritmo del nodo
+func (n *Node) Pause() bool {
+	if !n.pause.Pause() {
+		return false
+	}
+	n.logf("Node paused: holding outgoing messages and queueing incoming ones (up to %d)", n.pause.limit)
+	return true
+}
+
+// Resume procesa en orden los mensajes encolados durante la pausa y suelta
+// los envíos retenidos. Devuelve cuántos mensajes ha procesado.
+func (n *Node) Resume() int {
+	if !n.pause.Paused() {
+		return 0
+	}
+	replayed := 0
+	for {
+		msg, ok := n.pause.next()
+		if !ok {
+			break
+		}
+		replayed++
+		reply, err := n.processMessage(msg)
+		if err != nil {
+			n.logf("Failed to replay %s from %s after the pause: %v", msg.Type, msg.NodeID, err)
+			continue
+		}
+		// Al emisor ya se le dijo que su REQUEST quedaba pospuesto
+		if reply != nil {
+			n.dispatch(msg.NodeID, *reply)
+		}
+	}
+	n.logf("Node resumed: replayed %d queued messages", replayed)
+	return replayed
+}
+
+// handlePause pone el nodo en pausa
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	s.node.Pause()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.node.pause.status(s.serverID))
+}
+
+// handleResume reanuda el nodo y devuelve cuántos mensajes se procesaron
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	before := s.node.pause.status(s.serverID)
+	replayed := s.node.Resume()
+
+	status := s.node.pause.status(s.serverID)
+	status.Replayed = replayed
+	status.Rejected = before.Rejected
+	if before.Since != nil {
+		status.PausedMs = time.Since(*before.Since).Milliseconds()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// rejectWhilePaused responde 503 a las reservas mientras el nodo está en pausa
+func (s *Server) rejectWhilePaused(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.node.pause.Paused() {
+			next(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Retry-After", "2")
+		httperr.Write(w, http.StatusServiceUnavailable, CodeNodePaused, "El nodo está en pausa")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Node1 se pone en pausa mientras node2 y, después, node3 piden la CS. Sus
+// REQUEST deben quedar encolados sin mover el reloj de node1 y, al reanudar,
+// procesarse en orden de llegada con su reloj actualizado; las reservas en
+// node1 responden 503 mientras tanto.
+func TestPauseQueuesMessagesAndResumeReplaysThem(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1 := c.Node("node1")
+	node1.trace = NewTraceRecorder(100)
+	node1.Pause()
+	clockBefore := node1.Clock.GetTime()
+
+	waitQueued := func(want int) error {
+		deadline := time.Now().Add(time.Second)
+		for node1.pause.status(node1.ID).Queued < want {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("node1 queued %d messages, expected %d", node1.pause.status(node1.ID).Queued, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil
+	}
+	done := map[string]chan error{"node2": make(chan error, 1), "node3": make(chan error, 1)}
+	go func() { done["node2"] <- c.Enter("node2", 3*time.Second) }()
+	if err := waitQueued(1); err != nil {
+		t.Fatal(err)
+	}
+	go func() { done["node3"] <- c.Enter("node3", 3*time.Second) }()
+	if err := waitQueued(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if clock := node1.Clock.GetTime(); clock != clockBefore {
+		t.Fatalf("node1's clock moved while paused: %d -> %d", clockBefore, clock)
+	}
+	if received := node1.trace.Since(0, 100); len(received) != 0 {
+		t.Fatalf("node1 processed %d messages while paused", len(received))
+	}
+	server := &Server{node: node1, serverID: node1.ID}
+	rec := httptest.NewRecorder()
+	server.rejectWhilePaused(func(w http.ResponseWriter, r *http.Request) {})(rec,
+		httptest.NewRequest(http.MethodPost, "/reservar", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 from /reservar while paused, got %d", rec.Code)
+	}
+
+	if replayed := node1.Resume(); replayed != 2 {
+		t.Fatalf("expected 2 replayed messages, got %d", replayed)
+	}
+	var received []TraceEvent
+	for _, ev := range node1.trace.Since(0, 100) {
+		if ev.Direction == traceReceived {
+			received = append(received, ev)
+		}
+	}
+	if len(received) != 2 || received[0].Peer != "node2" || received[1].Peer != "node3" {
+		t.Fatalf("expected node2's then node3's REQUEST to be replayed, got %+v", received)
+	}
+	for i, ev := range received {
+		if ev.Type != "REQUEST" || ev.Clock <= ev.Timestamp || (i > 0 && ev.Clock <= received[i-1].Clock) {
+			t.Fatalf("replayed %s from %s (ts %d) left node1's clock at %d", ev.Type, ev.Peer, ev.Timestamp, ev.Clock)
+		}
+	}
+
+	// Ahora ambos pueden entrar, uno detrás de otro
+	for pending := 2; pending > 0; pending-- {
+		select {
+		case err := <-done["node2"]:
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Exit("node2")
+		case err := <-done["node3"]:
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Exit("node3")
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingPeer sirve /internal/message de node y cuenta las conexiones TCP
+// nuevas que recibe
+func countingPeer(t testing.TB, node *Node) (*httptest.Server, *int64) {
+	t.Helper()
+	conns := new(int64)
+	server := &Server{node: node, serverID: node.ID}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(server.handleInternalMessage))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(conns, 1)
+		}
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts, conns
+}
+
+// Los mensajes a un peer reutilizan la misma conexión: veinte entradas
+// seguidas, con su REQUEST y su REPLY en la respuesta, abren una sola
+func TestPeerMessagesReuseConnections(t *testing.T) {
+	node2 := newSimNode("node2", []string{"node1"})
+	node2.HeldAnnounceInterval = 0
+	ts, conns := countingPeer(t, node2)
+
+	node1 := newSimNode("node1", []string{"node2"})
+	node1.HeldAnnounceInterval = 0
+	node1.SetPeerInternalURL("node2", ts.URL)
+
+	const entries = 20
+	for i := 0; i < entries; i++ {
+		node1.RequestCS()
+		node1.ReleaseCS()
+	}
+
+	if got := node1.MessageStats().Sent["REQUEST"]; got != entries {
+		t.Fatalf("expected %d REQUESTs, sent %d", entries, got)
+	}
+	if n := atomic.LoadInt64(conns); n != 1 {
+		t.Fatalf("expected a single connection for %d messages, got %d", entries, n)
+	}
+	if latency := node1.MessageStats().AvgSendLatencyMs; latency <= 0 {
+		t.Fatalf("expected the send latency to be measured, got %v", latency)
+	}
+}
+
+// SendTimeout corta un intento contra un peer que no responde
+func TestSendTimeoutIsPerAttempt(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	node := newSimNode("node1", []string{"node2"})
+	node.SendTimeout = 50 * time.Millisecond
+	start := time.Now()
+	if _, err := node.post(ts.URL+"/internal/message", []byte(`{}`)); err == nil {
+		t.Fatal("expected the post to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the attempt to stop after SendTimeout, took %s", elapsed)
+	}
+}
+
+// BenchmarkPeerPost compara el cliente compartido con abrir una conexión por
+// mensaje, que era lo que ocurría antes de reutilizar el cliente
+func BenchmarkPeerPost(b *testing.B) {
+	node2 := newSimNode("node2", []string{"node1"})
+	node2.HeldAnnounceInterval = 0
+	ts, _ := countingPeer(b, node2)
+	body := []byte(`{"type":"RELEASE","node_id":"node1","timestamp":1}`)
+
+	for _, tc := range []struct {
+		name   string
+		client *http.Client
+	}{
+		{"pooled", newPeerClient()},
+		{"new-connection", &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			node1 := newSimNode("node1", []string{"node2"})
+			node1.client = tc.client
+			for i := 0; i < b.N; i++ {
+				resp, err := node1.post(ts.URL+"/internal/message", body)
+				if err != nil {
+					b.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+// outboxSize es cuántos envíos pueden esperar en la cola de salida de un peer
+const outboxSize = 1024
+
+// dispatch envía un mensaje en segundo plano por la cola de salida del peer.
+// Los mensajes a un mismo peer salen en el orden en que se despachan: el
+// algoritmo de Lamport supone canales FIFO, y en Ricart-Agrawala un REPLY no
+// debe adelantar al REQUEST que le precede.
+func (n *Node) dispatch(peerID string, msg Message) {
+	n.enqueue(peerID, msg.Type, func() { n.sendMessage(peerID, msg) })
+}
+
+// enqueue pone un envío en la cola de salida del peer sin bloquear, porque
+// se llama con el mutex del nodo tomado
+func (n *Node) enqueue(peerID, kind string, send func()) {
+	outbox := n.peerOutbox(peerID)
+	select {
+	case outbox <- send:
+	default:
+		// Se pierde el orden, pero solo cuando el peer lleva outboxSize
+		// envíos sin responder
+		n.logf("WARNING: outbox for %s is full, sending %s out of order", peerID, kind)
+		go func() { outbox <- send }()
+	}
+}
+
+// peerOutbox devuelve la cola de salida de un peer, creando su goroutine de
+// envío la primera vez. sendMessage espera la respuesta antes de volver, así
+// que cada mensaje se procesa en el peer antes de enviar el siguiente.
+func (n *Node) peerOutbox(peerID string) chan func() {
+	n.outboxMu.Lock()
+	defer n.outboxMu.Unlock()
+
+	outbox, ok := n.outbox[peerID]
+	if !ok {
+		outbox = make(chan func(), outboxSize)
+		n.outbox[peerID] = outbox
+		go func() {
+			for send := range outbox {
+				send()
+			}
+		}()
+	}
+	return outbox
+}
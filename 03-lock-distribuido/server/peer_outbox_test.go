@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newOrderingNode devuelve node1 con un transporte que tarda en entregar los
+// REQUEST, de modo que un REPLY enviado después llegaría antes si los envíos
+// a un peer fueran en paralelo
+func newOrderingNode() (*Node, *captureTransport) {
+	node := newSimNode("node1", []string{"node2"})
+	capture := newCaptureTransport()
+	capture.Delay = func(msg Message) time.Duration {
+		if msg.Type == "REQUEST" {
+			return 20 * time.Millisecond
+		}
+		return 0
+	}
+	node.transport = capture
+	return node, capture
+}
+
+func expectOrder(t *testing.T, capture *captureTransport, types ...string) {
+	t.Helper()
+	for i, want := range types {
+		if got := capture.next(t); got.Type != want {
+			t.Fatalf("message %d to node2 is %s, want %s (order %v)", i, got.Type, want, types)
+		}
+	}
+}
+
+func TestDispatchKeepsRequestBeforeReply(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRicartAgrawala, AlgorithmLamportQueue} {
+		node, capture := newOrderingNode()
+		node.Algorithm = algorithm
+
+		for i := 0; i < 5; i++ {
+			node.mu.Lock()
+			node.dispatch("node2", Message{Type: "REQUEST", NodeID: "node1", Timestamp: int64(2 * i)})
+			node.dispatch("node2", node.newReply("node2"))
+			node.mu.Unlock()
+			expectOrder(t, capture, "REQUEST", "REPLY")
+		}
+	}
+}
+
+// Los REPLY pospuestos van por ReplyOutbox, que no debe adelantar al
+// REQUEST que se despachó antes
+func TestDeferredReplyDoesNotOvertakeRequest(t *testing.T) {
+	node, capture := newOrderingNode()
+
+	for i := 0; i < 5; i++ {
+		node.mu.Lock()
+		node.dispatch("node2", Message{Type: "REQUEST", NodeID: "node1", Timestamp: int64(2 * i)})
+		node.replies.Add("node2", node.newReply("node2"))
+		node.mu.Unlock()
+		expectOrder(t, capture, "REQUEST", "REPLY")
+	}
+	// El último REPLY se da por entregado al volver sendMessage
+	deadline := time.Now().Add(time.Second)
+	for stats := node.replies.Stats(); stats.Delivered != 5 || stats.Depth != 0; stats = node.replies.Stats() {
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox delivered %d with depth %d, want 5 and 0", stats.Delivered, stats.Depth)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
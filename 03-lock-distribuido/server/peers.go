@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultPeerURLs son las URLs del docker-compose del módulo; solo se usan si
+// PEER_URLS no está definido.
+var defaultPeerURLs = map[string]string{
+	"server1": "http://server1:8081",
+	"server2": "http://server2:8082",
+	"server3": "http://server3:8083",
+}
+
+// ResolvePeerURLs construye el mapa de URLs de los peers a partir de
+// PEER_URLS, o de defaultPeerURLs si está vacío, y comprueba que cada peer
+// de la lista tenga una URL. Así una configuración incompleta falla al
+// arrancar en lugar de perder mensajes más tarde.
+func ResolvePeerURLs(spec string, peers []string) (map[string]string, error) {
+	urls, err := ParsePeerURLs(spec)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(spec) == "" {
+		for id, u := range defaultPeerURLs {
+			urls[id] = u
+		}
+	}
+
+	var missing []string
+	for _, peer := range peers {
+		if _, ok := urls[peer]; !ok {
+			missing = append(missing, peer)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no URL for peer(s) %s", strings.Join(missing, ", "))
+	}
+
+	return urls, nil
+}
+
+// ParsePeerURLs interpreta una lista "id=url,id=url" como la de PEER_URLS.
+// Cada URL es la base del peer (sin ruta), p. ej. "server1=http://server1:8081".
+func ParsePeerURLs(spec string) (map[string]string, error) {
+	urls := make(map[string]string)
+	if strings.TrimSpace(spec) == "" {
+		return urls, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			return nil, fmt.Errorf("empty entry in peer URL list %q", spec)
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("peer URL entry %q must have the form id=url", entry)
+		}
+
+		id := strings.TrimSpace(parts[0])
+		if id == "" {
+			return nil, fmt.Errorf("peer URL entry %q has an empty node id", entry)
+		}
+		if _, dup := urls[id]; dup {
+			return nil, fmt.Errorf("node id %q appears more than once", id)
+		}
+
+		base, err := normalizePeerURL(id, parts[1])
+		if err != nil {
+			return nil, err
+		}
+		urls[id] = base
+	}
+
+	return urls, nil
+}
+
+// normalizePeerURL comprueba que raw sea una URL base http(s) válida para el
+// peer id y la devuelve sin espacios ni barra final
+func normalizePeerURL(id, raw string) (string, error) {
+	raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("peer %q has an invalid URL %q", id, raw)
+	}
+	return raw, nil
+}
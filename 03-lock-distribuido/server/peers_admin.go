@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// PeersView es el cuerpo de GET /admin/peers y de la respuesta a POST
+type PeersView struct {
+	NodeID            string            `json:"node_id"`
+	Peers             map[string]string `json:"peers"` // ID del peer -> URL base
+	MembershipVersion int64             `json:"membership_version"`
+	Added             []string          `json:"added,omitempty"`
+	Removed           []string          `json:"removed,omitempty"`
+}
+
+// requireAdmin comprueba la cabecera X-Admin-Token. Sin ADMIN_TOKEN los
+// endpoints de administración quedan deshabilitados.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		httperr.Write(w, http.StatusForbidden, httperr.CodeAdminDisabled, "Admin endpoints disabled (ADMIN_TOKEN not set)")
+		return false
+	}
+	if r.Header.Get("X-Admin-Token") != s.adminToken {
+		httperr.Write(w, http.StatusUnauthorized, httperr.CodeUnauthorized, "Invalid admin token")
+		return false
+	}
+	return true
+}
+
+// handleGetPeers devuelve la vista actual de la membresía
+func (s *Server) handleGetPeers(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PeersView{
+		NodeID:            s.serverID,
+		Peers:             s.node.PeerURLs(),
+		MembershipVersion: s.node.MembershipVersion(),
+	})
+}
+
+// handleReplacePeers sustituye la membresía completa sin reiniciar el nodo.
+// El cuerpo es {"peers": {"server2": "http://server2:8082", ...}}; si incluye
+// al propio nodo se ignora.
+func (s *Server) handleReplacePeers(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if s.node.raftMode() {
+		// Los peers de Raft se fijan al arrancar y deciden el quórum
+		httperr.Write(w, http.StatusConflict, CodeReconfigUnsupported, "Peer reconfiguration is not supported with ALGORITHM=raft")
+		return
+	}
+
+	var req struct {
+		Peers map[string]string `json:"peers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperr.WriteDecodeError(w, err)
+		return
+	}
+	urls, err := validatePeerMap(req.Peers)
+	if err != nil {
+		httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	added, removed := s.node.ReplacePeers(urls)
+	log.Printf("[%s] Peer map replaced via /admin/peers from %s", s.serverID, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PeersView{
+		NodeID:            s.serverID,
+		Peers:             s.node.PeerURLs(),
+		MembershipVersion: s.node.MembershipVersion(),
+		Added:             added,
+		Removed:           removed,
+	})
+}
+
+// validatePeerMap comprueba los IDs y URLs de un mapa de peers y devuelve las
+// URLs normalizadas
+func validatePeerMap(peers map[string]string) (map[string]string, error) {
+	if peers == nil {
+		return nil, fmt.Errorf("peers is required")
+	}
+
+	urls := make(map[string]string, len(peers))
+	for id, raw := range peers {
+		if id == "" {
+			return nil, fmt.Errorf("peer map has an empty node id")
+		}
+		base, err := normalizePeerURL(id, raw)
+		if err != nil {
+			return nil, err
+		}
+		urls[id] = base
+	}
+	return urls, nil
+}
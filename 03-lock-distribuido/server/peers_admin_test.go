@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// simPeerURLs devuelve el mapa de peers de los IDs indicados
+func simPeerURLs(ids ...string) map[string]string {
+	urls := make(map[string]string, len(ids))
+	for _, id := range ids {
+		urls[id] = "http://" + id + ":8080"
+	}
+	return urls
+}
+
+// Quitar un peer cuyo REPLY espera la petición en curso la desbloquea en
+// lugar de dejarla colgada
+func TestRemovingAPeerUnblocksAWaitingRequest(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1 := c.Node("node1")
+	if err := c.Enter("node3", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Exit("node3")
+
+	entered := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		entered <- node1.RequestCSContext(ctx)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !needsReplyFrom(node1, "node3") || needsReplyFrom(node1, "node2") {
+		if time.Now().After(deadline) {
+			t.Fatal("node1 never ended up waiting only for node3")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	version := node1.MembershipVersion()
+	added, removed := node1.ReplacePeers(simPeerURLs("node2"))
+	if len(added) != 0 || !reflect.DeepEqual(removed, []string{"node3"}) {
+		t.Fatalf("expected only node3 removed, got added %v removed %v", added, removed)
+	}
+	select {
+	case err := <-entered:
+		if err != nil {
+			t.Fatalf("node1 did not enter after node3 was removed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("node1 kept waiting for the removed peer")
+	}
+	node1.ReleaseCS()
+
+	if got := node1.MembershipVersion(); got != version+1 {
+		t.Fatalf("expected membership version %d, got %d", version+1, got)
+	}
+	if peers := node1.PeerURLs(); !reflect.DeepEqual(peers, simPeerURLs("node2")) {
+		t.Fatalf("expected only node2 left, got %v", peers)
+	}
+}
+
+// Un peer añadido mientras hay una petición en curso no se suma a ella: la
+// petición termina con la membresía con la que empezó y la siguiente ya le
+// pregunta al nuevo
+func TestAddedPeerOnlyJoinsNewRequests(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3", "node4")
+	node1 := c.Node("node1")
+	node1.HeldAnnounceInterval = 0
+	node1.ReplacePeers(simPeerURLs("node2", "node3"))
+
+	if err := c.Enter("node3", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	entered := make(chan error, 1)
+	go func() { entered <- c.Enter("node1", 2*time.Second) }()
+	deadline := time.Now().Add(time.Second)
+	for !needsReplyFrom(node1, "node3") {
+		if time.Now().After(deadline) {
+			t.Fatal("node1 never waited for node3")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	added, _ := node1.ReplacePeers(simPeerURLs("node2", "node3", "node4"))
+	if !reflect.DeepEqual(added, []string{"node4"}) {
+		t.Fatalf("expected node4 added, got %v", added)
+	}
+	if needsReplyFrom(node1, "node4") {
+		t.Fatal("the in-flight request started waiting for the new peer")
+	}
+	c.Exit("node3")
+	if err := <-entered; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+	if got := node1.MessageStats().SentByPeer["node4"]["REQUEST"]; got != 0 {
+		t.Fatalf("expected node4 to get no REQUEST from the old round, got %d", got)
+	}
+
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+	if got := node1.MessageStats().SentByPeer["node4"]["REQUEST"]; got != 1 {
+		t.Fatalf("expected the next request to reach node4, got %d REQUESTs", got)
+	}
+}
+
+// adminRequest llama a un endpoint de /admin/peers con el token indicado
+func adminRequest(s *Server, method, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/admin/peers", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("X-Admin-Token", token)
+	}
+	rec := httptest.NewRecorder()
+	if method == http.MethodGet {
+		s.handleGetPeers(rec, req)
+	} else {
+		s.handleReplacePeers(rec, req)
+	}
+	return rec
+}
+
+func TestAdminPeersEndpoints(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1 := c.Node("node1")
+	node1.ReplacePeers(simPeerURLs("node2", "node3"))
+	s := &Server{node: node1, serverID: "node1"}
+
+	// Sin ADMIN_TOKEN los endpoints están deshabilitados
+	if rec := adminRequest(s, http.MethodGet, "secreto", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without ADMIN_TOKEN, got %d", rec.Code)
+	}
+	s.adminToken = "secreto"
+	if rec := adminRequest(s, http.MethodPost, "otro", `{"peers":{}}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong token, got %d", rec.Code)
+	}
+
+	rec := adminRequest(s, http.MethodGet, "secreto", "")
+	var view PeersView
+	json.NewDecoder(rec.Body).Decode(&view)
+	if rec.Code != http.StatusOK || view.NodeID != "node1" || !reflect.DeepEqual(view.Peers, simPeerURLs("node2", "node3")) {
+		t.Fatalf("unexpected GET /admin/peers: %d %+v", rec.Code, view)
+	}
+
+	for _, body := range []string{
+		`{}`,
+		`{"peers":{"node2":"ftp://node2"}}`,
+		`{"peers":{"":"http://node9:8080"}}`,
+		`{"peers":`,
+	} {
+		if rec := adminRequest(s, http.MethodPost, "secreto", body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+	if peers := node1.PeerURLs(); !reflect.DeepEqual(peers, simPeerURLs("node2", "node3")) {
+		t.Fatalf("a rejected map changed the peers to %v", peers)
+	}
+
+	// El propio nodo se ignora y las URLs se normalizan
+	rec = adminRequest(s, http.MethodPost, "secreto",
+		`{"peers":{"node1":"http://node1:8080","node2":"http://node2:8080/","node4":"http://node4:8080"}}`)
+	view = PeersView{}
+	json.NewDecoder(rec.Body).Decode(&view)
+	if rec.Code != http.StatusOK || !reflect.DeepEqual(view.Peers, simPeerURLs("node2", "node4")) ||
+		!reflect.DeepEqual(view.Added, []string{"node4"}) || !reflect.DeepEqual(view.Removed, []string{"node3"}) {
+		t.Fatalf("unexpected POST /admin/peers: %d %+v", rec.Code, view)
+	}
+	if view.MembershipVersion != node1.MembershipVersion() {
+		t.Fatalf("expected membership version %d, got %d", node1.MembershipVersion(), view.MembershipVersion)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParsePeerURLsRejectsMalformedEntries(t *testing.T) {
+	cases := map[string]string{
+		"missing equals":  "server1=http://server1:8081,server2",
+		"empty id":        "=http://server1:8081",
+		"empty url":       "server1=",
+		"empty entry":     "server1=http://server1:8081,,server2=http://server2:8082",
+		"trailing comma":  "server1=http://server1:8081,",
+		"duplicate id":    "server1=http://a:8081,server1=http://b:8081",
+		"missing scheme":  "server1=server1:8081",
+		"bad scheme":      "server1=ftp://server1:8081",
+		"missing host":    "server1=http://",
+		"unparseable url": "server1=http://[::1",
+	}
+	for name, spec := range cases {
+		if urls, err := ParsePeerURLs(spec); err == nil {
+			t.Errorf("%s: expected %q to be rejected, got %v", name, spec, urls)
+		}
+	}
+}
+
+func TestParsePeerURLsNormalizesEntries(t *testing.T) {
+	urls, err := ParsePeerURLs(" server1 = http://server1:8081/ , server2=https://10.0.0.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"server1": "http://server1:8081",
+		"server2": "https://10.0.0.2:9000",
+	}
+	if len(urls) != len(want) {
+		t.Fatalf("expected %d entries, got %v", len(want), urls)
+	}
+	for id, u := range want {
+		if urls[id] != u {
+			t.Errorf("expected %s -> %s, got %q", id, u, urls[id])
+		}
+	}
+}
+
+func TestResolvePeerURLsFallsBackToDefaults(t *testing.T) {
+	urls, err := ResolvePeerURLs("", []string{"server2", "server3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls["server2"] != "http://server2:8082" || urls["server3"] != "http://server3:8083" {
+		t.Fatalf("expected the docker-compose URLs, got %v", urls)
+	}
+
+	// Un peer que no está en los valores por defecto necesita PEER_URLS
+	if _, err := ResolvePeerURLs("", []string{"server2", "server4"}); err == nil || !strings.Contains(err.Error(), "server4") {
+		t.Fatalf("expected an error naming server4, got %v", err)
+	}
+}
+
+// Con PEER_URLS definido los valores por defecto no se mezclan: un peer
+// que falta en la lista es un error aunque docker-compose lo conozca
+func TestResolvePeerURLsIgnoresDefaultsWhenSet(t *testing.T) {
+	urls, err := ResolvePeerURLs("server2=http://10.0.0.2:9002", []string{"server2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls["server2"] != "http://10.0.0.2:9002" {
+		t.Fatalf("expected only the configured URL, got %v", urls)
+	}
+
+	_, err = ResolvePeerURLs("server2=http://10.0.0.2:9002", []string{"server2", "server3"})
+	if err == nil || !strings.Contains(err.Error(), "server3") {
+		t.Fatalf("expected server3 to be reported as missing, got %v", err)
+	}
+}
+
+// Un PEER_URLS mal formado se rechaza aunque cubra a todos los peers
+func TestResolvePeerURLsRejectsMalformedSpec(t *testing.T) {
+	if _, err := ResolvePeerURLs("server2=http://server2:8082,server3", []string{"server2"}); err == nil {
+		t.Fatal("expected a malformed PEER_URLS to be rejected")
+	}
+}
+
+func TestResolvePeerURLsReportsMissingPeers(t *testing.T) {
+	_, err := ResolvePeerURLs("server2=http://server2:8082", []string{"server2", "server3", "server5"})
+	if err == nil {
+		t.Fatal("expected an error for peers without a URL")
+	}
+	if !strings.Contains(err.Error(), "server3, server5") {
+		t.Fatalf("expected the error to list server3 and server5, got %v", err)
+	}
+}
+
+// fivePeerSpec es un PEER_URLS para un clúster de cinco nodos
+func fivePeerSpec() string {
+	var entries []string
+	for i := 1; i <= 5; i++ {
+		entries = append(entries, fmt.Sprintf("server%d=http://10.0.0.%d:%d", i, i, 8080+i))
+	}
+	return strings.Join(entries, ",")
+}
+
+func TestFiveNodeConfigurationResolvesEveryPeer(t *testing.T) {
+	spec := fivePeerSpec()
+	for i := 1; i <= 5; i++ {
+		self := fmt.Sprintf("server%d", i)
+		var peers []string
+		for j := 1; j <= 5; j++ {
+			if j != i {
+				peers = append(peers, fmt.Sprintf("server%d", j))
+			}
+		}
+
+		urls, err := ResolvePeerURLs(spec, peers)
+		if err != nil {
+			t.Fatalf("%s: %v", self, err)
+		}
+		node := NewNode(self, peers, urls)
+		for j := 1; j <= 5; j++ {
+			if j == i {
+				continue
+			}
+			peer := fmt.Sprintf("server%d", j)
+			got, err := node.findPeerURL(peer)
+			if err != nil {
+				t.Fatalf("%s -> %s: %v", self, peer, err)
+			}
+			want := fmt.Sprintf("http://10.0.0.%d:%d/internal/message", j, 8080+j)
+			if got != want {
+				t.Errorf("%s -> %s: expected %s, got %s", self, peer, want, got)
+			}
+		}
+	}
+}
+
+func TestFindPeerURLUnknownPeer(t *testing.T) {
+	urls, err := ResolvePeerURLs(fivePeerSpec(), []string{"server2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := NewNode("server1", []string{"server2"}, urls)
+	if _, err := node.findPeerURL("server9"); err == nil || !strings.Contains(err.Error(), "PEER_URLS") {
+		t.Fatalf("expected an error pointing at PEER_URLS, got %v", err)
+	}
+}
+
+func TestFiveNodeMutualExclusion(t *testing.T) {
+	c := NewSimCluster("server1", "server2", "server3", "server4", "server5")
+	c.Network.Delay = func(from, to string, msg Message) time.Duration {
+		return time.Millisecond
+	}
+
+	if err := runContention(c, 5); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("mutual exclusion violated %d times", v)
+	}
+	if holders := c.Holders(); len(holders) != 0 {
+		t.Fatalf("expected the CS to be free at the end, held by %v", holders)
+	}
+}
+
+// Los mensajes salen hacia la URL resuelta desde PEER_URLS
+func TestResolvedPeerURLIsUsedForMessages(t *testing.T) {
+	node2 := newSimNode("server2", []string{"server1"})
+	node2.HeldAnnounceInterval = 0
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal/message" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&hits, 1)
+		(&Server{node: node2, serverID: "server2"}).handleInternalMessage(w, r)
+	}))
+	defer ts.Close()
+
+	urls, err := ResolvePeerURLs("server2="+ts.URL+"/", []string{"server2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node1 := NewNode("server1", []string{"server2"}, urls)
+	node1.HeldAnnounceInterval = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := node1.RequestCSContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	node1.ReleaseCS()
+	if atomic.LoadInt32(&hits) == 0 {
+		t.Fatal("expected the REQUEST to reach the resolved URL")
+	}
+}
+
+// /topology y /whoami describen el nodo con las URLs resueltas de sus peers
+// sin enviarles nada
+func TestTopologyReportsTheNodeAndItsPeers(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer ts.Close()
+
+	peers := []string{"server2", "server3"}
+	urls, err := ResolvePeerURLs("server2="+ts.URL+"/,server3=http://10.0.0.3:9000", peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := NewNode("server1", peers, urls)
+	node.Clock.AdvanceTo(41)
+	s := &Server{node: node, serverID: "server1"}
+
+	for _, path := range []string{"/topology", "/whoami"} {
+		rec := httptest.NewRecorder()
+		s.handleTopology(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var topo Topology
+		if err := json.NewDecoder(rec.Body).Decode(&topo); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"server2": ts.URL, "server3": "http://10.0.0.3:9000"}
+		if topo.NodeID != "server1" || !reflect.DeepEqual(topo.Peers, want) {
+			t.Fatalf("%s: expected server1 with peers %v, got %+v", path, want, topo)
+		}
+		if topo.Algorithm != AlgorithmRicartAgrawala || topo.Transport != "http" || topo.LamportTime != 41 {
+			t.Fatalf("%s: unexpected algorithm, transport or clock: %+v", path, topo)
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Fatalf("expected /topology not to contact peers, got %d requests", n)
+	}
+}
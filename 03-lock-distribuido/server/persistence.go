@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NodeSnapshot es el estado del nodo que sobrevive a un reinicio
+type NodeSnapshot struct {
+	NodeID      string    `bson:"_id" json:"node_id"`
+	Clock       int64     `bson:"clock" json:"clock"`
+	State       string    `bson:"state" json:"state"`
+	RequestTime int64     `bson:"request_time" json:"request_time"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+	// Reloj vectorial, solo con CLOCK_MODE=vector
+	Vector map[string]int64 `bson:"vector,omitempty" json:"vector,omitempty"`
+	// REPLY que el nodo debía: los pospuestos y los del outbox sin entregar
+	Owed []OwedReply `bson:"owed_replies,omitempty" json:"owed_replies,omitempty"`
+	// Encarnación del nodo; la siguiente debe ser mayor
+	Incarnation int64 `bson:"incarnation" json:"incarnation"`
+}
+
+// OwedReply es un REPLY pendiente para la petición Round de Peer
+type OwedReply struct {
+	Peer  string `bson:"peer" json:"peer"`
+	Round int64  `bson:"round" json:"round"`
+}
+
+// NodeStateStore guarda el NodeSnapshot de cada nodo en MongoDB
+type NodeStateStore struct {
+	collection *mongo.Collection
+}
+
+// NewNodeStateStore crea un almacén de estado sobre la colección indicada
+func NewNodeStateStore(collection *mongo.Collection) *NodeStateStore {
+	return &NodeStateStore{collection: collection}
+}
+
+// Save guarda (o reemplaza) el snapshot del nodo
+func (st *NodeStateStore) Save(ctx context.Context, snap NodeSnapshot) error {
+	snap.UpdatedAt = time.Now()
+	_, err := st.collection.ReplaceOne(ctx, bson.M{"_id": snap.NodeID}, snap, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Load devuelve el último snapshot del nodo, o nil si nunca se guardó
+func (st *NodeStateStore) Load(ctx context.Context, nodeID string) (*NodeSnapshot, error) {
+	var snap NodeSnapshot
+	err := st.collection.FindOne(ctx, bson.M{"_id": nodeID}).Decode(&snap)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Snapshot captura el estado persistible del nodo
+func (n *Node) Snapshot() NodeSnapshot {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return NodeSnapshot{
+		NodeID:      n.ID,
+		Clock:       n.Clock.GetTime(),
+		State:       n.State.String(),
+		RequestTime: n.RequestTime,
+		Vector:      n.VectorSnapshot(),
+		Owed:        n.owedReplies(),
+		Incarnation: n.incarnation,
+	}
+}
+
+// owedReplies lista los REPLY que los peers esperan de este nodo: los que
+// siguen en el outbox y los pospuestos, que responden a una petición más
+// reciente y sustituyen a los del outbox.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) owedReplies() []OwedReply {
+	rounds := n.replies.owedRounds()
+	for _, peerID := range n.DeferredReplies {
+		rounds[peerID] = n.peerRounds[peerID]
+	}
+
+	owed := make([]OwedReply, 0, len(rounds))
+	for peerID, round := range rounds {
+		owed = append(owed, OwedReply{Peer: peerID, Round: round})
+	}
+	sort.Slice(owed, func(i, j int) bool { return owed[i].Peer < owed[j].Peer })
+	return owed
+}
+
+// markStateChanged pide un guardado del snapshot sin esperar al siguiente
+// intervalo. No bloquea: si ya hay uno pedido, ese recoge también este cambio.
+func (n *Node) markStateChanged() {
+	select {
+	case n.stateChanged <- struct{}{}:
+	default:
+	}
+}
+
+// RestoreState reanuda el reloj de Lamport desde el último snapshot.
+//
+// El snapshot se guarda periódicamente, así que el reloj pudo avanzar después
+// del último guardado; safetyJump debe superar los eventos que el nodo puede
+// generar en un intervalo de guardado. Así, toda petición nueva lleva un
+// timestamp mayor que cualquiera que el nodo emitiera antes de caer y no gana
+// prioridad injustamente frente a los nodos que siguieron funcionando.
+//
+// Un nodo que cayó en Held o Wanted arranca siempre en Released: su petición
+// anterior ya no existe y sus peers lo habrán dejado de esperar o lo harán
+// cuando expiren sus timeouts. Los REPLY que debía quedan apartados para
+// PayOwedReplies.
+func (n *Node) RestoreState(snap *NodeSnapshot, safetyJump int64) {
+	if snap == nil {
+		return
+	}
+
+	resumed := snap.Clock + safetyJump
+	n.Clock.AdvanceTo(resumed)
+	log.Printf("[%s] Resumed Lamport clock at %d (persisted %d + jump %d)", n.ID, resumed, snap.Clock, safetyJump)
+
+	if n.VClock != nil && snap.Vector != nil {
+		n.VClock.Restore(snap.Vector, safetyJump)
+		log.Printf("[%s] Resumed vector clock at %v", n.ID, n.VClock.Snapshot())
+	}
+
+	n.restoreIncarnation(snap.Incarnation)
+	log.Printf("[%s] Starting incarnation %d (previous %d)", n.ID, n.Incarnation(), snap.Incarnation)
+
+	if snap.State != Released.String() {
+		log.Printf("[%s] WARNING: node crashed while %s (request ts %d); restarting as Released",
+			n.ID, snap.State, snap.RequestTime)
+	}
+
+	if len(snap.Owed) > 0 {
+		n.mu.Lock()
+		n.owedOnStart = append([]OwedReply(nil), snap.Owed...)
+		n.mu.Unlock()
+		log.Printf("[%s] Node crashed owing %d replies; they will be sent before serving requests", n.ID, len(snap.Owed))
+	}
+}
+
+// PayOwedReplies envía los REPLY que el nodo debía cuando cayó. Sin ellos,
+// los peers a los que pospuso la respuesta esperarían para siempre: el nodo
+// reiniciado ya no recuerda haberlos pospuesto. Cada REPLY lleva la ronda de
+// la petición a la que responde, así que un peer que ya pasó a otra petición
+// lo descarta. Pasan por el outbox, que los reintenta hasta que el peer
+// vuelva a escuchar. Devuelve cuántos REPLY se han encolado.
+func (n *Node) PayOwedReplies() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	owed := n.owedOnStart
+	n.owedOnStart = nil
+	paid := 0
+	for _, reply := range owed {
+		if !n.isPeerLocked(reply.Peer) {
+			log.Printf("[%s] Not sending owed reply to %s: no longer a peer", n.ID, reply.Peer)
+			continue
+		}
+		if _, known := n.peerRounds[reply.Peer]; !known {
+			n.peerRounds[reply.Peer] = reply.Round
+		}
+		n.logf("Sending reply owed to %s since before the restart (round %d)", reply.Peer, reply.Round)
+		n.replies.Add(reply.Peer, n.newReply(reply.Peer))
+		paid++
+	}
+	return paid
+}
+
+// RunStatePersistence guarda el snapshot del nodo en cada intervalo, y en
+// cuanto cambian el estado o los REPLY debidos, hasta que se cierre stop; al
+// cerrarse hace un último guardado.
+func RunStatePersistence(store *NodeStateStore, node *Node, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			persistState(store, node)
+		case <-node.stateChanged:
+			persistState(store, node)
+		case <-stop:
+			persistState(store, node)
+			return
+		}
+	}
+}
+
+// persistState guarda el snapshot actual del nodo
+func persistState(store *NodeStateStore, node *Node) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := store.Save(ctx, node.Snapshot()); err != nil {
+		log.Printf("[%s] Failed to persist node state: %v", node.ID, err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Tras un reinicio, el reloj reanudado debe quedar por delante de todos los
+// timestamps que el nodo emitió antes de caer, incluidos los posteriores al
+// último snapshot guardado
+func TestRestartResumesClockAheadOfIssuedTimestamps(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	var (
+		mu     sync.Mutex
+		issued int64
+		after  []int64
+	)
+	restarted := false
+	c.Network.Delay = func(from, to string, msg Message) time.Duration {
+		if from == "node2" {
+			mu.Lock()
+			if restarted {
+				after = append(after, msg.Timestamp)
+			} else if msg.Timestamp > issued {
+				issued = msg.Timestamp
+			}
+			mu.Unlock()
+		}
+		return time.Millisecond
+	}
+
+	if err := runContention(c, 5); err != nil {
+		t.Fatal(err)
+	}
+	// Último guardado periódico antes de la caída
+	data, err := json.Marshal(c.Node("node2").Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// El nodo sigue funcionando un rato sin volver a guardar
+	if err := runContention(c, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	var snap NodeSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	before := issued
+	restarted = true
+	mu.Unlock()
+	if snap.Clock >= before {
+		t.Fatalf("expected node2 to issue timestamps after the snapshot (snapshot %d, latest %d)", snap.Clock, before)
+	}
+
+	c.Network.Detach("node2")
+	node2 := newSimNode("node2", []string{"node1", "node3"})
+	node2.RestoreState(&snap, 1000)
+	c.Network.Attach(node2)
+	c.nodes["node2"] = node2
+
+	if clock := node2.Clock.GetTime(); clock <= before {
+		t.Fatalf("resumed clock %d is not ahead of the last issued timestamp %d", clock, before)
+	}
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node2")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(after) == 0 {
+		t.Fatal("node2 sent nothing after restarting")
+	}
+	for _, ts := range after {
+		if ts <= before {
+			t.Fatalf("node2 sent timestamp %d after restarting, not ahead of %d", ts, before)
+		}
+	}
+}
+
+// Un nodo que cayó dentro de la CS o esperándola arranca en Released
+func TestRestoreStateClearsHeldAndWanted(t *testing.T) {
+	for _, state := range []NodeState{Held, Wanted} {
+		node := newSimNode("node1", []string{"node2"})
+		node.RestoreState(&NodeSnapshot{NodeID: "node1", Clock: 40, State: state.String(), RequestTime: 38, Incarnation: 3}, 100)
+
+		snap := node.Snapshot()
+		if snap.State != Released.String() {
+			t.Fatalf("node crashed while %s restarted as %s, want Released", state, snap.State)
+		}
+		if snap.Clock != 140 {
+			t.Fatalf("expected the clock to resume at 140, got %d", snap.Clock)
+		}
+		if snap.Incarnation <= 3 {
+			t.Fatalf("expected an incarnation after 3, got %d", snap.Incarnation)
+		}
+	}
+}
+
+func TestRestoreStateWithoutSnapshot(t *testing.T) {
+	node := newSimNode("node1", []string{"node2"})
+	node.RestoreState(nil, 1000)
+	if clock := node.Clock.GetTime(); clock != 0 {
+		t.Fatalf("expected a fresh node to start at 0, got %d", clock)
+	}
+}
+
+func TestNodeStateStoreSaveAndLoad(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		store := NewNodeStateStore(mt.Coll)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		if err := store.Save(context.Background(), NodeSnapshot{NodeID: "node1", Clock: 12, State: "Held"}); err != nil {
+			mt.Fatal(err)
+		}
+		cmd := mt.GetStartedEvent().Command
+		update := cmd.Lookup("updates").Array().Index(0).Value().Document()
+		if id := update.Lookup("q", "_id").StringValue(); id != "node1" {
+			mt.Fatalf("expected the snapshot to be keyed by node id, got %q", id)
+		}
+		if !update.Lookup("upsert").Boolean() {
+			mt.Fatal("expected Save to upsert")
+		}
+		if clock := update.Lookup("u", "clock").Int64(); clock != 12 {
+			mt.Fatalf("expected clock 12 to be saved, got %d", clock)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.node_state", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "node1"}, {Key: "clock", Value: int64(12)}, {Key: "state", Value: "Held"}}))
+		snap, err := store.Load(context.Background(), "node1")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if snap == nil || snap.Clock != 12 || snap.State != "Held" {
+			mt.Fatalf("expected the saved snapshot back, got %+v", snap)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.node_state", mtest.FirstBatch))
+		snap, err = store.Load(context.Background(), "node9")
+		if err != nil || snap != nil {
+			mt.Fatalf("expected no snapshot for an unknown node, got %+v, %v", snap, err)
+		}
+	})
+}
+
+// Al cerrarse stop se hace un último guardado, como en un apagado ordenado
+func TestRunStatePersistenceSavesOnStop(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		node := newSimNode("node1", []string{"node2"})
+		node.Clock.AdvanceTo(77)
+		stop := make(chan struct{})
+		close(stop)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		RunStatePersistence(NewNodeStateStore(mt.Coll), node, time.Hour, stop)
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "update" {
+			mt.Fatalf("expected a final save on stop, got %+v", started)
+		}
+		update := started.Command.Lookup("updates").Array().Index(0).Value().Document()
+		if clock := update.Lookup("u", "clock").Int64(); clock != 77 {
+			mt.Fatalf("expected clock 77 to be saved, got %d", clock)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// httpCluster conecta nodos por HTTP real, cada uno con su
+// /internal/message en un httptest.Server, y cuenta los POST que recibe
+// cada nodo por tipo de mensaje
+type httpCluster struct {
+	nodes map[string]*Node
+
+	mu    sync.Mutex
+	posts map[string]int
+}
+
+func newHTTPCluster(t testing.TB, ids ...string) *httpCluster {
+	t.Helper()
+	c := &httpCluster{nodes: make(map[string]*Node), posts: make(map[string]int)}
+	urls := make(map[string]string)
+	for _, id := range ids {
+		var peers []string
+		for _, p := range ids {
+			if p != id {
+				peers = append(peers, p)
+			}
+		}
+		node := newSimNode(id, peers)
+		node.HeldAnnounceInterval = 0
+		c.nodes[id] = node
+
+		server := &Server{node: node, serverID: id}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var msg Message
+			json.Unmarshal(body, &msg)
+			c.mu.Lock()
+			c.posts[msg.Type]++
+			c.mu.Unlock()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			server.handleInternalMessage(w, r)
+		}))
+		t.Cleanup(ts.Close)
+		urls[id] = ts.URL
+	}
+	for _, id := range ids {
+		for peer, url := range urls {
+			if peer != id {
+				c.nodes[id].SetPeerInternalURL(peer, url)
+			}
+		}
+	}
+	return c
+}
+
+// Posts devuelve los POST recibidos de un tipo, o de todos si typ está vacío
+func (c *httpCluster) Posts(typ string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if typ != "" {
+		return c.posts[typ]
+	}
+	total := 0
+	for _, n := range c.posts {
+		total += n
+	}
+	return total
+}
+
+func (c *httpCluster) enter(t testing.TB, id string) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		c.nodes[id].RequestCS()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s did not enter the CS", id)
+	}
+}
+
+func TestInternalMessageReturnsReplyOrDeferred(t *testing.T) {
+	node := newSimNode("node1", []string{"node2"})
+	node.HeldAnnounceInterval = 0
+	node.transport = newCaptureTransport()
+	server := &Server{node: node, serverID: "node1"}
+
+	post := func(msg Message) MessageResponse {
+		t.Helper()
+		body, _ := json.Marshal(msg)
+		rec := httptest.NewRecorder()
+		server.handleInternalMessage(rec, httptest.NewRequest(http.MethodPost, "/internal/message", bytes.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp MessageResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Libre: el REPLY viaja en la respuesta
+	resp := post(Message{Type: "REQUEST", NodeID: "node2", Timestamp: 3, Seq: 1, Round: 1})
+	if resp.Deferred || resp.Reply == nil {
+		t.Fatalf("expected a piggybacked REPLY, got %+v", resp)
+	}
+	if resp.Reply.Type != "REPLY" || resp.Reply.NodeID != "node1" || resp.Reply.Round != 1 {
+		t.Fatalf("expected node1's REPLY for round 1, got %+v", resp.Reply)
+	}
+
+	// Ocupado: la respuesta solo marca el REPLY como pospuesto
+	node.mu.Lock()
+	node.setState(Held)
+	node.mu.Unlock()
+	resp = post(Message{Type: "REQUEST", NodeID: "node2", Timestamp: 9, Seq: 2, Round: 2})
+	if !resp.Deferred || resp.Reply != nil {
+		t.Fatalf("expected a deferred marker, got %+v", resp)
+	}
+
+	// Los demás mensajes no llevan nada en la respuesta
+	resp = post(Message{Type: "REPLY", NodeID: "node2", Timestamp: 10, Seq: 3, Round: 7})
+	if resp.Deferred || resp.Reply != nil {
+		t.Fatalf("expected an empty response to a REPLY, got %+v", resp)
+	}
+}
+
+// Sin contienda cada peer recibe un único POST: el REQUEST, con el REPLY de
+// vuelta en la respuesta. Sin piggyback serían dos por peer.
+func TestUncontendedEntryHalvesTheMessages(t *testing.T) {
+	for _, size := range []int{3, 5} {
+		var ids []string
+		for i := 1; i <= size; i++ {
+			ids = append(ids, fmt.Sprintf("node%d", i))
+		}
+		c := newHTTPCluster(t, ids...)
+		node1 := c.nodes["node1"]
+
+		c.enter(t, "node1")
+		peers := size - 1
+		if got := c.Posts("REQUEST"); got != peers {
+			t.Fatalf("%d nodes: expected %d REQUEST posts, got %d", size, peers, got)
+		}
+		if got := c.Posts("REPLY"); got != 0 {
+			t.Fatalf("%d nodes: expected every REPLY to be piggybacked, got %d REPLY posts", size, got)
+		}
+		// Los REPLY se cuentan como mensajes aunque no usen su propio POST
+		if sent := node1.MessageStats().TotalSent; sent != uint64(peers) {
+			t.Fatalf("%d nodes: node1 sent %d messages, want %d", size, sent, peers)
+		}
+		var replies uint64
+		for _, id := range ids[1:] {
+			replies += c.nodes[id].MessageStats().TotalSent
+		}
+		if logical := uint64(peers) + replies; logical != uint64(2*c.Posts("")) {
+			t.Fatalf("%d nodes: %d protocol messages over %d posts, want twice as many messages",
+				size, logical, c.Posts(""))
+		}
+		node1.ReleaseCS()
+	}
+}
+
+// Un REPLY pospuesto sigue llegando por su propio POST al salir de la CS
+func TestDeferredReplyUsesItsOwnPost(t *testing.T) {
+	c := newHTTPCluster(t, "node1", "node2")
+	c.enter(t, "node2")
+
+	entered := make(chan struct{})
+	go func() {
+		c.nodes["node1"].RequestCS()
+		close(entered)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.nodes["node2"].DebugState().DeferredReplies) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("node2 did not defer its REPLY")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := c.Posts("REPLY"); got != 0 {
+		t.Fatalf("expected no REPLY post while node2 holds the CS, got %d", got)
+	}
+
+	c.nodes["node2"].ReleaseCS()
+	select {
+	case <-entered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("node1 did not enter after node2 released")
+	}
+	if got := c.Posts("REPLY"); got != 1 {
+		t.Fatalf("expected the deferred REPLY to arrive in 1 post, got %d", got)
+	}
+	c.nodes["node1"].ReleaseCS()
+}
+
+func BenchmarkUncontendedEntry(b *testing.B) {
+	c := newHTTPCluster(b, "node1", "node2", "node3")
+	node1 := c.nodes["node1"]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		node1.RequestCS()
+		node1.ReleaseCS()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(c.Posts(""))/float64(b.N), "posts/op")
+	b.ReportMetric(float64(node1.MessageStats().TotalSent+c.nodes["node2"].MessageStats().TotalSent+
+		c.nodes["node3"].MessageStats().TotalSent)/float64(b.N), "msgs/op")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// Prioridades de las peticiones a la CS con Ricart-Agrawala. Una petición
+// de más prioridad adelanta a las de menos, pero solo a las hechas hasta
+// PriorityAging ticks de Lamport antes que ella: pasado ese margen la más
+// antigua entra primero, así que una petición de baja prioridad sube un
+// nivel por cada PriorityAging ticks que espera y nunca se queda fuera para
+// siempre.
+//
+// El envejecimiento se mide en ticks y no en tiempo de reloj para que los dos
+// nodos que comparan un par de peticiones lleguen siempre al mismo orden: si
+// cada uno envejeciera la petición con su propio reloj, ambos podrían darse
+// prioridad mutuamente y entrar a la vez. Por la misma razón PRIORITY_AGING_TICKS
+// debe ser igual en todos los nodos, como ALGORITHM. Con CLOCK_MODE=vector el
+// orden sigue siendo el causal y la prioridad no se tiene en cuenta.
+const (
+	// PriorityMaintenance es la de las tareas de fondo, como crear los
+	// asientos, que deben ceder el paso a las reservas
+	PriorityMaintenance = -1
+	// PriorityNormal es la de las peticiones de los usuarios; es el valor
+	// por defecto, así que los mensajes sin prioridad la tienen
+	PriorityNormal = 0
+	// PriorityUrgent adelanta a las peticiones normales
+	PriorityUrgent = 1
+)
+
+// defaultPriorityAging es el valor de PRIORITY_AGING_TICKS por defecto
+const defaultPriorityAging int64 = 50
+
+type csPriorityKey struct{}
+
+// WithCSPriority marca las peticiones a la CS hechas con ctx con la
+// prioridad indicada
+func WithCSPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, csPriorityKey{}, priority)
+}
+
+// csPriority devuelve la prioridad con que ctx pide la CS
+func csPriority(ctx context.Context) int {
+	if priority, ok := ctx.Value(csPriorityKey{}).(int); ok {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// csRequest es una petición a la CS tal como se ordena
+type csRequest struct {
+	Priority  int
+	Timestamp int64
+	NodeID    string
+}
+
+// agedTimestamp es el timestamp con que compite la petición: cada nivel de
+// prioridad equivale a haberla hecho aging ticks antes
+func (r csRequest) agedTimestamp(aging int64) int64 {
+	return r.Timestamp - int64(r.Priority)*aging
+}
+
+// precedes indica si la petición r entra en la CS antes que other. Es un
+// orden total, igual en todos los nodos con el mismo aging: a igual
+// timestamp envejecido gana la de más prioridad, y después la del menor ID.
+func (r csRequest) precedes(other csRequest, aging int64) bool {
+	a, b := r.agedTimestamp(aging), other.agedTimestamp(aging)
+	if a != b {
+		return a < b
+	}
+	if r.Priority != other.Priority {
+		return r.Priority > other.Priority
+	}
+	return r.NodeID < other.NodeID
+}
+
+// peerRequest es el último REQUEST conocido del peer.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) peerRequest(peerID string) csRequest {
+	return csRequest{
+		Priority:  n.peerPriorities[peerID],
+		Timestamp: n.peerRequestTimes[peerID],
+		NodeID:    peerID,
+	}
+}
+
+// yieldTo cede el paso a un REQUEST posterior al nuestro que va antes por
+// prioridad. El peer pudo respondernos antes de pedir la CS, y ese REPLY no
+// puede valer: con él entraríamos los dos. Volvemos a necesitar su respuesta,
+// le reenviamos nuestro REQUEST para que la posponga hasta salir de la CS y
+// descartamos sus REPLY con timestamp anterior a su REQUEST.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) yieldTo(msg Message) {
+	n.yieldedTo[msg.NodeID] = msg.Timestamp
+	if n.excluded[msg.NodeID] {
+		return
+	}
+	n.logf("Yielding to higher-priority request from %s (priority %d vs my %d), asking it again for its reply",
+		msg.NodeID, msg.Priority, n.RequestPriority)
+	n.RepliesNeeded[msg.NodeID] = true
+	n.dispatch(msg.NodeID, n.currentRequest())
+}
+
+// sortDeferred ordena los REPLY pospuestos para enviar primero el del peer
+// cuya petición va antes.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) sortDeferred() {
+	sort.SliceStable(n.DeferredReplies, func(i, j int) bool {
+		return n.peerRequest(n.DeferredReplies[i]).precedes(n.peerRequest(n.DeferredReplies[j]), n.PriorityAging)
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Los REQUEST que se repiten a un peer (al cederle el paso, al recuperar un
+// permiso implícito o cuando vuelve) deben ser iguales al original: con
+// relojes vectoriales, un REQUEST sin vector se compararía contra el vector
+// cero y ganaría a cualquier petición concurrente.
+func TestResentRequestsMatchTheOriginal(t *testing.T) {
+	node := newSimNode("node1", []string{"node2"})
+	node.UseVectorClock()
+	capture := newCaptureTransport()
+	node.transport = capture
+
+	node.mu.Lock()
+	node.State = Wanted
+	node.round = 3
+	node.RequestTime = 7
+	node.RequestPriority = PriorityNormal
+	node.RequestVector = map[string]int64{"node1": 7, "node2": 2}
+	want := node.currentRequest()
+	node.mu.Unlock()
+
+	peer := Message{Type: "REQUEST", NodeID: "node2", Timestamp: 1, Priority: PriorityUrgent, Round: 1}
+	resend := map[string]func(){
+		"yieldTo":     func() { node.mu.Lock(); node.yieldTo(peer); node.mu.Unlock() },
+		"regainGrant": func() { node.mu.Lock(); node.regainGrant(peer); node.mu.Unlock() },
+		"peerRecovered": func() {
+			node.mu.Lock()
+			node.excluded["node2"] = true
+			node.mu.Unlock()
+			node.peerRecovered("node2")
+		},
+	}
+	for name, send := range resend {
+		send()
+		got := capture.next(t)
+		if got.Type != "REQUEST" || got.Timestamp != want.Timestamp || got.Round != want.Round ||
+			got.Priority != want.Priority || !reflect.DeepEqual(got.Vector, want.Vector) {
+			t.Errorf("%s re-sent %+v, want the original request %+v", name, got, want)
+		}
+	}
+}
+
+// Con node1 en la CS, node2 pide con prioridad de mantenimiento y después
+// node3 con prioridad normal. Si node3 pide dentro del margen de
+// envejecimiento entra antes aunque pidió después; si la petición de node2 ya
+// es más antigua que ese margen, entra antes node2. Por último, peticiones de
+// prioridades mezcladas compiten sin violar la exclusión mutua.
+func TestPriorityAndAging(t *testing.T) {
+	low := csRequest{Priority: PriorityMaintenance, Timestamp: 10, NodeID: "node1"}
+	normal := csRequest{Priority: PriorityNormal, Timestamp: 40, NodeID: "node2"}
+	if !normal.precedes(low, 50) || low.precedes(normal, 50) {
+		t.Fatal("a normal request 30 ticks newer should precede a maintenance one with aging 50")
+	}
+	if !low.precedes(normal, 20) || normal.precedes(low, 20) {
+		t.Fatal("a maintenance request 30 ticks older should precede a normal one with aging 20")
+	}
+
+	for _, tc := range []struct {
+		name  string
+		aging int64
+		gap   int64 // ticks que node3 adelanta su reloj antes de pedir
+		first string
+	}{
+		{name: "preference", aging: 1000, gap: 0, first: "node3"},
+		{name: "aging", aging: 5, gap: 100, first: "node2"},
+	} {
+		c := NewSimCluster("node1", "node2", "node3")
+		for _, id := range []string{"node1", "node2", "node3"} {
+			c.Node(id).PriorityAging = tc.aging
+		}
+		if err := c.Enter("node1", time.Second); err != nil {
+			t.Fatal(err)
+		}
+
+		order := make(chan string, 2)
+		errs := make(chan error, 2)
+		enter := func(id string, priority int) {
+			if err := c.EnterWithPriority(id, priority, 3*time.Second); err != nil {
+				errs <- err
+				return
+			}
+			order <- id
+			time.Sleep(10 * time.Millisecond)
+			c.Exit(id)
+			errs <- nil
+		}
+		waitDeferred := func(count int) error {
+			deadline := time.Now().Add(time.Second)
+			for {
+				queue, err := getDebugQueue(c.Node("node1"))
+				if err != nil {
+					return err
+				}
+				if len(queue.Deferred) == count {
+					return nil
+				}
+				if time.Now().After(deadline) {
+					return fmt.Errorf("%s: node1 deferred %d requests, expected %d", tc.name, len(queue.Deferred), count)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+
+		go enter("node2", PriorityMaintenance)
+		if err := waitDeferred(1); err != nil {
+			t.Fatal(err)
+		}
+		node3 := c.Node("node3")
+		node3.Clock.AdvanceTo(node3.Clock.GetTime() + tc.gap)
+		go enter("node3", PriorityNormal)
+		if err := waitDeferred(2); err != nil {
+			t.Fatal(err)
+		}
+
+		// node1 responderá primero a la petición que va antes
+		queue, err := getDebugQueue(c.Node("node1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if queue.Deferred[0].NodeID != tc.first {
+			t.Fatalf("%s: node1 would flush %s first, expected %s (%+v)",
+				tc.name, queue.Deferred[0].NodeID, tc.first, queue.Deferred)
+		}
+		c.Exit("node1")
+
+		for i := 0; i < 2; i++ {
+			if err := <-errs; err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+		}
+		if first := <-order; first != tc.first {
+			t.Fatalf("%s: %s entered first, expected %s", tc.name, first, tc.first)
+		}
+	}
+
+	// Prioridades mezcladas y un margen corto, para que haya tanto
+	// adelantamientos como envejecimiento
+	c := NewSimCluster("node1", "node2", "node3", "node4")
+	priorities := map[string]int{"node1": PriorityMaintenance, "node2": PriorityNormal, "node3": PriorityUrgent, "node4": PriorityNormal}
+	for id := range priorities {
+		c.Node(id).PriorityAging = 3
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(priorities))
+	for id, priority := range priorities {
+		wg.Add(1)
+		go func(id string, priority int) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				if err := c.EnterWithPriority(id, priority, 5*time.Second); err != nil {
+					errs <- err
+					return
+				}
+				time.Sleep(time.Millisecond)
+				c.Exit(id)
+			}
+		}(id, priority)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("%d mutual exclusion violations with mixed priorities", v)
+	}
+}
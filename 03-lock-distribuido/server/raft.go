@@ -0,0 +1,864 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AlgorithmRaft sustituye el intercambio REQUEST/REPLY por una tabla de
+// bloqueo replicada con Raft: adquirir y liberar son entradas del log.
+const AlgorithmRaft = "raft"
+
+// Operaciones del log de Raft
+const (
+	raftOpNoop    = "noop" // La añade cada líder nuevo para poder confirmar entradas antiguas
+	raftOpAcquire = "acquire"
+	raftOpRelease = "release"
+)
+
+// forcedRelease es la ronda de una liberación impuesta por el líder a un nodo
+// caído: suelta el bloqueo sin invalidar las peticiones futuras del nodo
+const forcedRelease = -1
+
+// ErrNoLeader indica que no se conoce un líder al que enviar la propuesta
+var ErrNoLeader = errors.New("no raft leader known")
+
+// RaftEntry es una entrada del log replicado
+type RaftEntry struct {
+	Term   int64  `bson:"term" json:"term"`
+	Op     string `bson:"op" json:"op"`
+	NodeID string `bson:"node_id,omitempty" json:"node_id,omitempty"`
+	Round  int64  `bson:"round,omitempty" json:"round,omitempty"`
+}
+
+// LockTable es la máquina de estados replicada: quién tiene la CS y quién
+// espera, en orden de llegada al log
+type LockTable struct {
+	Holder string   `json:"holder"`
+	Queue  []string `json:"queue"`
+	// Número de concesiones hechas; distingue dos concesiones seguidas al
+	// mismo nodo que se aplican en el mismo lote
+	Grants int64 `json:"grants"`
+	// Última ronda liberada por cada nodo; un acquire de una ronda igual o
+	// anterior es un reintento tardío y se ignora
+	Released map[string]int64 `json:"released"`
+}
+
+// apply aplica una entrada del log a la tabla
+func (t *LockTable) apply(e RaftEntry) {
+	switch e.Op {
+	case raftOpAcquire:
+		if e.Round <= t.Released[e.NodeID] || t.Holder == e.NodeID || t.queued(e.NodeID) {
+			return
+		}
+		if t.Holder == "" {
+			t.Holder = e.NodeID
+			t.Grants++
+		} else {
+			t.Queue = append(t.Queue, e.NodeID)
+		}
+	case raftOpRelease:
+		if e.Round != forcedRelease && e.Round > t.Released[e.NodeID] {
+			t.Released[e.NodeID] = e.Round
+		}
+		if t.Holder == e.NodeID {
+			t.Holder = ""
+			if len(t.Queue) > 0 {
+				t.Holder = t.Queue[0]
+				t.Queue = t.Queue[1:]
+				t.Grants++
+			}
+			return
+		}
+		for i, id := range t.Queue {
+			if id == e.NodeID {
+				t.Queue = append(t.Queue[:i], t.Queue[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// queued indica si el nodo espera en la cola
+func (t *LockTable) queued(nodeID string) bool {
+	for _, id := range t.Queue {
+		if id == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// Papel del nodo en Raft
+type raftRole int
+
+const (
+	raftFollower raftRole = iota
+	raftCandidate
+	raftLeader
+)
+
+func (r raftRole) String() string {
+	switch r {
+	case raftFollower:
+		return "Follower"
+	case raftCandidate:
+		return "Candidate"
+	case raftLeader:
+		return "Leader"
+	default:
+		return fmt.Sprintf("Unknown(%d)", r)
+	}
+}
+
+// Mensajes RPC de Raft, sobre HTTP
+type (
+	VoteRequest struct {
+		Term         int64  `json:"term"`
+		CandidateID  string `json:"candidate_id"`
+		LastLogIndex int64  `json:"last_log_index"`
+		LastLogTerm  int64  `json:"last_log_term"`
+	}
+	VoteResponse struct {
+		Term    int64 `json:"term"`
+		Granted bool  `json:"granted"`
+	}
+	AppendRequest struct {
+		Term         int64       `json:"term"`
+		LeaderID     string      `json:"leader_id"`
+		PrevLogIndex int64       `json:"prev_log_index"`
+		PrevLogTerm  int64       `json:"prev_log_term"`
+		Entries      []RaftEntry `json:"entries"`
+		LeaderCommit int64       `json:"leader_commit"`
+	}
+	AppendResponse struct {
+		Term    int64 `json:"term"`
+		Success bool  `json:"success"`
+		// Último índice que el seguidor cree coincidente, para retroceder rápido
+		MatchHint int64 `json:"match_hint"`
+	}
+	ProposeResponse struct {
+		Accepted bool   `json:"accepted"`
+		LeaderID string `json:"leader_id,omitempty"`
+	}
+)
+
+// RaftStatus es el estado de Raft que publican /health y /info
+type RaftStatus struct {
+	Role        string    `json:"role"`
+	Term        int64     `json:"term"`
+	LeaderID    string    `json:"leader_id"`
+	LogLength   int64     `json:"log_length"`
+	CommitIndex int64     `json:"commit_index"`
+	Table       LockTable `json:"lock_table"`
+}
+
+// Raft replica la tabla de bloqueo entre el nodo y sus peers. Es una versión
+// mínima con fines docentes: elección de líder, replicación del log y
+// persistencia, sin compactación ni cambios de membresía (el clúster es el
+// de PEERS al arrancar).
+type Raft struct {
+	node      *Node
+	id        string
+	peers     []string
+	store     *RaftStore
+	client    *http.Client
+	heartbeat time.Duration
+	election  time.Duration // El timeout real está entre election y 2*election
+
+	mu          sync.Mutex
+	role        raftRole
+	term        int64
+	votedFor    string
+	log         []RaftEntry // log[0] es un centinela; los índices empiezan en 1
+	commitIndex int64
+	lastApplied int64
+	leaderID    string
+	nextIndex   map[string]int64
+	matchIndex  map[string]int64
+	deadline    time.Time // Momento en que se convoca una elección sin noticias del líder
+	table       LockTable
+
+	// applied avisa (sin bloquear) de que la tabla cambió
+	applied chan struct{}
+}
+
+// NewRaft crea el componente Raft del nodo. store puede ser nil (sin
+// persistencia), en cuyo caso un reinicio pierde el log local.
+func NewRaft(node *Node, store *RaftStore, heartbeat, election time.Duration) *Raft {
+	peers := node.PeerList()
+	return &Raft{
+		node:       node,
+		id:         node.ID,
+		peers:      peers,
+		store:      store,
+		client:     &http.Client{Timeout: election},
+		heartbeat:  heartbeat,
+		election:   election,
+		log:        []RaftEntry{{}},
+		nextIndex:  make(map[string]int64),
+		matchIndex: make(map[string]int64),
+		table:      LockTable{Released: make(map[string]int64)},
+		applied:    make(chan struct{}, 1),
+	}
+}
+
+// Restore carga el término, el voto y el log persistidos. La tabla se
+// reconstruye al volver a aplicar las entradas confirmadas que anuncie el líder.
+func (r *Raft) Restore(ctx context.Context) error {
+	if r.store == nil {
+		return nil
+	}
+	state, err := r.store.Load(ctx, r.id)
+	if err != nil || state == nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.term = state.Term
+	r.votedFor = state.VotedFor
+	r.log = append([]RaftEntry{{}}, state.Log...)
+	log.Printf("[%s] Restored raft state: term %d, %d log entries", r.id, r.term, len(state.Log))
+	return nil
+}
+
+// Run mantiene los latidos del líder y las elecciones. Bloquea hasta que se
+// cierre stop.
+func (r *Raft) Run(stop <-chan struct{}) {
+	go r.notifyLoop(stop)
+
+	r.mu.Lock()
+	r.resetDeadline()
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(r.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		r.mu.Lock()
+		role := r.role
+		expired := time.Now().After(r.deadline)
+		r.mu.Unlock()
+
+		if role == raftLeader {
+			r.replicateAll()
+		} else if expired {
+			r.startElection()
+		}
+	}
+}
+
+// Status devuelve una copia del estado de Raft
+func (r *Raft) Status() RaftStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	table := LockTable{
+		Holder:   r.table.Holder,
+		Queue:    append([]string{}, r.table.Queue...),
+		Grants:   r.table.Grants,
+		Released: make(map[string]int64, len(r.table.Released)),
+	}
+	for id, round := range r.table.Released {
+		table.Released[id] = round
+	}
+	return RaftStatus{
+		Role:        r.role.String(),
+		Term:        r.term,
+		LeaderID:    r.leaderID,
+		LogLength:   r.lastIndex(),
+		CommitIndex: r.commitIndex,
+		Table:       table,
+	}
+}
+
+// Propose añade una entrada al log. En el líder se añade directamente; en un
+// seguidor se reenvía al líder. Que la propuesta se acepte no garantiza que
+// llegue a confirmarse: si el líder cae antes, el llamador debe reintentar.
+func (r *Raft) Propose(entry RaftEntry) error {
+	r.mu.Lock()
+	if r.role == raftLeader {
+		entry.Term = r.term
+		r.log = append(r.log, entry)
+		r.persist()
+		r.mu.Unlock()
+		r.replicateAll()
+		return nil
+	}
+	leader := r.leaderID
+	r.mu.Unlock()
+
+	if leader == "" {
+		return ErrNoLeader
+	}
+
+	var resp ProposeResponse
+	if err := r.post(leader, "/internal/raft/propose", entry, &resp); err != nil {
+		return err
+	}
+	if !resp.Accepted {
+		return fmt.Errorf("%s is no longer the raft leader (leader: %q)", leader, resp.LeaderID)
+	}
+	return nil
+}
+
+// --- Elección ---
+
+// startElection convoca una elección para el siguiente término
+func (r *Raft) startElection() {
+	r.mu.Lock()
+	r.role = raftCandidate
+	r.term++
+	r.votedFor = r.id
+	r.leaderID = ""
+	r.persist()
+	r.resetDeadline()
+	term := r.term
+	req := VoteRequest{
+		Term:         term,
+		CandidateID:  r.id,
+		LastLogIndex: r.lastIndex(),
+		LastLogTerm:  r.log[r.lastIndex()].Term,
+	}
+	r.mu.Unlock()
+
+	log.Printf("[%s] Starting raft election for term %d", r.id, term)
+
+	var votesMu sync.Mutex
+	votes := 1
+	if votes >= r.majority() {
+		r.becomeLeader(term)
+		return
+	}
+
+	for _, peer := range r.peers {
+		go func(peer string) {
+			var resp VoteResponse
+			if err := r.post(peer, "/internal/raft/vote", req, &resp); err != nil {
+				return
+			}
+
+			r.mu.Lock()
+			if resp.Term > r.term {
+				r.becomeFollower(resp.Term)
+			}
+			stillCandidate := r.role == raftCandidate && r.term == term
+			r.mu.Unlock()
+
+			if !stillCandidate || !resp.Granted {
+				return
+			}
+			votesMu.Lock()
+			votes++
+			won := votes == r.majority()
+			votesMu.Unlock()
+			if won {
+				r.becomeLeader(term)
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader asume el liderazgo si el término sigue siendo el de la elección
+func (r *Raft) becomeLeader(term int64) {
+	r.mu.Lock()
+	if r.role != raftCandidate || r.term != term {
+		r.mu.Unlock()
+		return
+	}
+	r.role = raftLeader
+	r.leaderID = r.id
+	for _, peer := range r.peers {
+		r.nextIndex[peer] = r.lastIndex() + 1
+		r.matchIndex[peer] = 0
+	}
+	// Una entrada del término actual permite confirmar las de términos anteriores
+	r.log = append(r.log, RaftEntry{Term: r.term, Op: raftOpNoop})
+	r.persist()
+	r.advanceCommit()
+	r.mu.Unlock()
+
+	log.Printf("[%s] Became raft leader for term %d", r.id, term)
+	r.replicateAll()
+}
+
+// becomeFollower pasa a seguidor en el término indicado.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (r *Raft) becomeFollower(term int64) {
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+		r.persist()
+	}
+	if r.role != raftFollower {
+		log.Printf("[%s] Stepping down to raft follower in term %d", r.id, r.term)
+	}
+	r.role = raftFollower
+}
+
+// resetDeadline aplaza la próxima elección un tiempo aleatorio.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (r *Raft) resetDeadline() {
+	jitter := time.Duration(rand.Int63n(int64(r.election)))
+	r.deadline = time.Now().Add(r.election + jitter)
+}
+
+// --- Replicación ---
+
+// replicateAll envía a cada peer las entradas que le faltan (o un latido)
+func (r *Raft) replicateAll() {
+	for _, peer := range r.peers {
+		go r.replicate(peer)
+	}
+}
+
+// replicate envía un AppendEntries a un peer y procesa su respuesta
+func (r *Raft) replicate(peer string) {
+	r.mu.Lock()
+	if r.role != raftLeader {
+		r.mu.Unlock()
+		return
+	}
+	term := r.term
+	prev := r.nextIndex[peer] - 1
+	if prev > r.lastIndex() {
+		prev = r.lastIndex()
+	}
+	req := AppendRequest{
+		Term:         term,
+		LeaderID:     r.id,
+		PrevLogIndex: prev,
+		PrevLogTerm:  r.log[prev].Term,
+		Entries:      append([]RaftEntry{}, r.log[prev+1:]...),
+		LeaderCommit: r.commitIndex,
+	}
+	r.mu.Unlock()
+
+	var resp AppendResponse
+	if err := r.post(peer, "/internal/raft/append", req, &resp); err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if resp.Term > r.term {
+		r.becomeFollower(resp.Term)
+		return
+	}
+	if r.role != raftLeader || r.term != term {
+		return
+	}
+
+	if resp.Success {
+		match := prev + int64(len(req.Entries))
+		if match > r.matchIndex[peer] {
+			r.matchIndex[peer] = match
+		}
+		r.nextIndex[peer] = r.matchIndex[peer] + 1
+		r.advanceCommit()
+		return
+	}
+
+	// El log del peer diverge: retroceder y reintentar en el próximo latido
+	next := prev
+	if resp.MatchHint+1 < next {
+		next = resp.MatchHint + 1
+	}
+	if next < 1 {
+		next = 1
+	}
+	r.nextIndex[peer] = next
+}
+
+// advanceCommit confirma las entradas del término actual replicadas en una
+// mayoría. ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (r *Raft) advanceCommit() {
+	for idx := r.lastIndex(); idx > r.commitIndex; idx-- {
+		if r.log[idx].Term != r.term {
+			break
+		}
+		replicas := 1
+		for _, peer := range r.peers {
+			if r.matchIndex[peer] >= idx {
+				replicas++
+			}
+		}
+		if replicas >= r.majority() {
+			r.commitIndex = idx
+			r.applyCommitted()
+			return
+		}
+	}
+}
+
+// applyCommitted aplica a la tabla las entradas confirmadas pendientes.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (r *Raft) applyCommitted() {
+	if r.lastApplied >= r.commitIndex {
+		return
+	}
+	for r.lastApplied < r.commitIndex {
+		r.lastApplied++
+		r.table.apply(r.log[r.lastApplied])
+	}
+	select {
+	case r.applied <- struct{}{}:
+	default:
+	}
+}
+
+// majority es el número de nodos (contando este) que forma mayoría
+func (r *Raft) majority() int {
+	return (len(r.peers)+1)/2 + 1
+}
+
+// lastIndex devuelve el índice de la última entrada del log.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (r *Raft) lastIndex() int64 {
+	return int64(len(r.log) - 1)
+}
+
+// persist guarda término, voto y log antes de responder a nadie.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (r *Raft) persist() {
+	if r.store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := r.store.Save(ctx, RaftState{NodeID: r.id, Term: r.term, VotedFor: r.votedFor, Log: r.log[1:]})
+	if err != nil {
+		log.Printf("[%s] Failed to persist raft state: %v", r.id, err)
+	}
+}
+
+// notifyLoop avisa al nodo cada vez que el bloqueo cambia de manos
+func (r *Raft) notifyLoop(stop <-chan struct{}) {
+	var lastGrants int64
+	for {
+		select {
+		case <-stop:
+			return
+		case <-r.applied:
+		}
+
+		r.mu.Lock()
+		holder, grants := r.table.Holder, r.table.Grants
+		r.mu.Unlock()
+
+		if grants != lastGrants {
+			lastGrants = grants
+			r.node.raftHolderChanged(holder)
+		}
+	}
+}
+
+// --- Handlers RPC ---
+
+// handleVote responde a un RequestVote
+func (r *Raft) handleVote(w http.ResponseWriter, req *http.Request) {
+	var vote VoteRequest
+	if err := json.NewDecoder(req.Body).Decode(&vote); err != nil {
+		httperr.Write(w, http.StatusBadRequest, CodeInvalidMessage, "Invalid vote request")
+		return
+	}
+
+	r.mu.Lock()
+	if vote.Term > r.term {
+		r.becomeFollower(vote.Term)
+	}
+	lastIdx := r.lastIndex()
+	lastTerm := r.log[lastIdx].Term
+	upToDate := vote.LastLogTerm > lastTerm || (vote.LastLogTerm == lastTerm && vote.LastLogIndex >= lastIdx)
+	granted := vote.Term == r.term && (r.votedFor == "" || r.votedFor == vote.CandidateID) && upToDate
+	if granted {
+		r.votedFor = vote.CandidateID
+		r.persist()
+		r.resetDeadline()
+	}
+	resp := VoteResponse{Term: r.term, Granted: granted}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAppend responde a un AppendEntries (replicación o latido)
+func (r *Raft) handleAppend(w http.ResponseWriter, req *http.Request) {
+	var app AppendRequest
+	if err := json.NewDecoder(req.Body).Decode(&app); err != nil {
+		httperr.Write(w, http.StatusBadRequest, CodeInvalidMessage, "Invalid append request")
+		return
+	}
+
+	r.mu.Lock()
+	resp := r.appendEntries(app)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// appendEntries aplica un AppendEntries al log local.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (r *Raft) appendEntries(app AppendRequest) AppendResponse {
+	if app.Term < r.term {
+		return AppendResponse{Term: r.term}
+	}
+	r.becomeFollower(app.Term)
+	r.leaderID = app.LeaderID
+	r.resetDeadline()
+
+	if app.PrevLogIndex > r.lastIndex() {
+		return AppendResponse{Term: r.term, MatchHint: r.lastIndex()}
+	}
+	if r.log[app.PrevLogIndex].Term != app.PrevLogTerm {
+		return AppendResponse{Term: r.term, MatchHint: app.PrevLogIndex - 1}
+	}
+
+	changed := false
+	for i, entry := range app.Entries {
+		idx := app.PrevLogIndex + 1 + int64(i)
+		if idx <= r.lastIndex() {
+			if r.log[idx].Term == entry.Term {
+				continue
+			}
+			// Conflicto: descartar esta entrada y todas las siguientes
+			r.log = r.log[:idx]
+		}
+		r.log = append(r.log, entry)
+		changed = true
+	}
+	if changed {
+		r.persist()
+	}
+
+	lastNew := app.PrevLogIndex + int64(len(app.Entries))
+	if app.LeaderCommit > r.commitIndex {
+		r.commitIndex = app.LeaderCommit
+		if lastNew < r.commitIndex {
+			r.commitIndex = lastNew
+		}
+		r.applyCommitted()
+	}
+
+	return AppendResponse{Term: r.term, Success: true, MatchHint: lastNew}
+}
+
+// handlePropose recibe una propuesta reenviada por un seguidor
+func (r *Raft) handlePropose(w http.ResponseWriter, req *http.Request) {
+	var entry RaftEntry
+	if err := json.NewDecoder(req.Body).Decode(&entry); err != nil {
+		httperr.Write(w, http.StatusBadRequest, CodeInvalidMessage, "Invalid proposal")
+		return
+	}
+	if entry.Op != raftOpAcquire && entry.Op != raftOpRelease {
+		httperr.Write(w, http.StatusBadRequest, CodeInvalidMessage, fmt.Sprintf("Unknown raft operation %q", entry.Op))
+		return
+	}
+
+	r.mu.Lock()
+	isLeader := r.role == raftLeader
+	leader := r.leaderID
+	r.mu.Unlock()
+
+	resp := ProposeResponse{Accepted: isLeader, LeaderID: leader}
+	if isLeader {
+		if err := r.Propose(entry); err != nil {
+			resp.Accepted = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// post envía un RPC de Raft a un peer y decodifica la respuesta
+func (r *Raft) post(peerID, path string, in, out interface{}) error {
+	base, err := r.node.internalBaseURL(peerID)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.node.postSigned(r.client, base+path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, peerID)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// --- Persistencia ---
+
+// RaftState es el estado de Raft que debe sobrevivir a un reinicio
+type RaftState struct {
+	NodeID   string      `bson:"_id"`
+	Term     int64       `bson:"term"`
+	VotedFor string      `bson:"voted_for"`
+	Log      []RaftEntry `bson:"log"`
+}
+
+// RaftStore guarda el RaftState de cada nodo en MongoDB
+type RaftStore struct {
+	collection *mongo.Collection
+}
+
+// NewRaftStore crea un almacén de estado de Raft sobre la colección indicada
+func NewRaftStore(collection *mongo.Collection) *RaftStore {
+	return &RaftStore{collection: collection}
+}
+
+// Save guarda (o reemplaza) el estado del nodo
+func (st *RaftStore) Save(ctx context.Context, state RaftState) error {
+	_, err := st.collection.ReplaceOne(ctx, bson.M{"_id": state.NodeID}, state, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Load devuelve el estado guardado del nodo, o nil si nunca se guardó
+func (st *RaftStore) Load(ctx context.Context, nodeID string) (*RaftState, error) {
+	var state RaftState
+	err := st.collection.FindOne(ctx, bson.M{"_id": nodeID}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// --- Integración con Node ---
+
+// UseRaft cambia el nodo al bloqueo replicado con Raft. Debe llamarse antes
+// de arrancar el nodo.
+func (n *Node) UseRaft(r *Raft) {
+	n.Algorithm = AlgorithmRaft
+	n.raft = r
+}
+
+// raftMode indica si el nodo usa la tabla de bloqueo replicada
+func (n *Node) raftMode() bool {
+	return n.Algorithm == AlgorithmRaft
+}
+
+// requestRaftCS pide el bloqueo al líder y espera a que la entrada se
+// confirme y nos nombre titular. Si el líder cae antes de confirmarla, la
+// propuesta se repite: los acquire duplicados de una misma ronda son inocuos.
+func (n *Node) requestRaftCS(ctx context.Context) error {
+	n.mu.Lock()
+	n.setState(Wanted)
+	n.requestedAt = time.Now()
+	n.bypassedBy = make(map[string]bool)
+	// La ronda sale de la secuencia del nodo, que arranca en el reloj físico:
+	// tras un reinicio sigue siendo mayor que las ya liberadas en el log
+	round := int64(n.nextSeq())
+	n.raftRound = round
+	n.csToken++
+	token := n.csToken
+	n.mu.Unlock()
+
+	entry := RaftEntry{Op: raftOpAcquire, NodeID: n.ID, Round: round}
+	for {
+		if err := n.raft.Propose(entry); err != nil {
+			log.Printf("[%s] Raft acquire proposal failed, retrying: %v", n.ID, err)
+		}
+
+		select {
+		case granted := <-n.csGranted:
+			if n.isCurrentGrant(granted, token) {
+				return nil
+			}
+		case <-ctx.Done():
+			// CancelCSRequest propone la liberación de la ronda
+			return n.awaitGrant(ctx, token)
+		case <-time.After(time.Second):
+		}
+
+		n.mu.Lock()
+		wanted := n.State == Wanted && n.raftRound == round
+		n.mu.Unlock()
+		if !wanted {
+			// Cancelada con CancelCSRequest, que ya propuso la liberación
+			return errCSCancelled
+		}
+	}
+}
+
+// releaseRaft propone liberar (o abandonar la cola de) la ronda indicada
+// hasta que la tabla replicada lo refleje
+func (n *Node) releaseRaft(round int64) {
+	entry := RaftEntry{Op: raftOpRelease, NodeID: n.ID, Round: round}
+	for {
+		if err := n.raft.Propose(entry); err != nil {
+			log.Printf("[%s] Raft release proposal failed, retrying: %v", n.ID, err)
+		}
+		time.Sleep(n.raft.heartbeat * 2)
+
+		status := n.raft.Status()
+		if status.Table.Released[n.ID] >= round {
+			return
+		}
+	}
+}
+
+// raftHolderChanged reacciona a un nuevo titular en la tabla replicada
+func (n *Node) raftHolderChanged(holder string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch {
+	case holder == n.ID && n.State == Wanted:
+		n._enterCS()
+	case holder == n.ID && n.State == Released:
+		// Un acquire confirmado después de cancelar: devolver el bloqueo
+		log.Printf("[%s] Raft granted a lock no longer wanted; releasing it", n.ID)
+		go n.releaseRaft(n.raftRound)
+	case holder != n.ID && n.State == Held:
+		log.Printf("[%s] CRITICAL: raft lock table moved the lock to %q while this node is in the CS", n.ID, holder)
+	}
+}
+
+// raftPeerSuspected hace que el líder retire del bloqueo a un peer caído, para
+// que no retenga la CS indefinidamente. Como con el detector de fallos en
+// Ricart-Agrawala, un falso positivo puede dar la CS a dos nodos.
+func (r *Raft) raftPeerSuspected(peerID string) {
+	r.mu.Lock()
+	isLeader := r.role == raftLeader
+	involved := r.table.Holder == peerID || r.table.queued(peerID)
+	r.mu.Unlock()
+
+	if !isLeader || !involved {
+		return
+	}
+	log.Printf("[%s] Forcing release of raft lock for suspect peer %s", r.id, peerID)
+	if err := r.Propose(RaftEntry{Op: raftOpRelease, NodeID: peerID, Round: forcedRelease}); err != nil {
+		log.Printf("[%s] Failed to propose forced release for %s: %v", r.id, peerID, err)
+	}
+}
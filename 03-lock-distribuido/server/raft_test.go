@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newLockTable() *LockTable {
+	return &LockTable{Released: make(map[string]int64)}
+}
+
+func TestLockTableIgnoresDuplicateAcquire(t *testing.T) {
+	table := newLockTable()
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node1", Round: 1})
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node2", Round: 1})
+	// Reintentos de la misma ronda tras perder el líder
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node1", Round: 1})
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node2", Round: 1})
+
+	if table.Holder != "node1" || !reflect.DeepEqual(table.Queue, []string{"node2"}) {
+		t.Fatalf("expected node1 holding and [node2] queued, got %q holding and %v queued", table.Holder, table.Queue)
+	}
+	if table.Grants != 1 {
+		t.Fatalf("expected 1 grant, got %d", table.Grants)
+	}
+}
+
+func TestLockTableIgnoresAcquireFromAnOlderRound(t *testing.T) {
+	table := newLockTable()
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node1", Round: 5})
+	table.apply(RaftEntry{Op: raftOpRelease, NodeID: "node1", Round: 5})
+
+	// Un acquire tardío de la ronda ya liberada, o de una anterior
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node1", Round: 5})
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node1", Round: 3})
+	if table.Holder != "" || len(table.Queue) != 0 {
+		t.Fatalf("stale acquire was applied: %q holding, %v queued", table.Holder, table.Queue)
+	}
+
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node1", Round: 6})
+	if table.Holder != "node1" {
+		t.Fatalf("expected a newer round to be granted, holder is %q", table.Holder)
+	}
+}
+
+func TestLockTableForcedReleasePassesTheLockOn(t *testing.T) {
+	table := newLockTable()
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node1", Round: 4})
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node2", Round: 7})
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node3", Round: 2})
+
+	table.apply(RaftEntry{Op: raftOpRelease, NodeID: "node1", Round: forcedRelease})
+	if table.Holder != "node2" || !reflect.DeepEqual(table.Queue, []string{"node3"}) {
+		t.Fatalf("expected node2 holding and [node3] queued, got %q and %v", table.Holder, table.Queue)
+	}
+	if table.Grants != 2 {
+		t.Fatalf("expected 2 grants, got %d", table.Grants)
+	}
+	// La liberación forzada no invalida las rondas futuras del nodo caído
+	if _, ok := table.Released["node1"]; ok {
+		t.Fatalf("forced release recorded a round for node1: %v", table.Released)
+	}
+
+	// Y quita de la cola a un nodo caído que esperaba
+	table.apply(RaftEntry{Op: raftOpRelease, NodeID: "node3", Round: forcedRelease})
+	if table.Holder != "node2" || len(table.Queue) != 0 {
+		t.Fatalf("expected node2 holding and nobody queued, got %q and %v", table.Holder, table.Queue)
+	}
+
+	table.apply(RaftEntry{Op: raftOpAcquire, NodeID: "node1", Round: 5})
+	if !reflect.DeepEqual(table.Queue, []string{"node1"}) {
+		t.Fatalf("expected node1 to queue again after its forced release, queue is %v", table.Queue)
+	}
+}
+
+// raftCluster monta tres nodos en modo Raft con sus RPC sobre httptest
+type raftCluster struct {
+	*SimCluster
+	servers map[string]*httptest.Server
+	down    map[string]*int32
+	stops   map[string]chan struct{}
+}
+
+func newRaftCluster(t *testing.T, ids ...string) *raftCluster {
+	t.Helper()
+	c := &raftCluster{
+		SimCluster: NewSimCluster(ids...),
+		servers:    make(map[string]*httptest.Server),
+		down:       make(map[string]*int32),
+		stops:      make(map[string]chan struct{}),
+	}
+	for _, id := range ids {
+		node := c.Node(id)
+		raft := NewRaft(node, nil, 20*time.Millisecond, 150*time.Millisecond)
+		node.UseRaft(raft)
+
+		down := new(int32)
+		alive := func(h http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(down) == 1 {
+					http.Error(w, "node is down", http.StatusServiceUnavailable)
+					return
+				}
+				h(w, r)
+			}
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/internal/raft/vote", alive(raft.handleVote))
+		mux.HandleFunc("/internal/raft/append", alive(raft.handleAppend))
+		mux.HandleFunc("/internal/raft/propose", alive(raft.handlePropose))
+		c.servers[id] = httptest.NewServer(mux)
+		c.down[id] = down
+		c.stops[id] = make(chan struct{})
+	}
+	for _, id := range ids {
+		for _, peer := range ids {
+			if peer != id {
+				c.Node(id).SetPeerInternalURL(peer, c.servers[peer].URL)
+			}
+		}
+	}
+	for _, id := range ids {
+		go c.Node(id).raft.Run(c.stops[id])
+	}
+	t.Cleanup(func() {
+		for _, id := range ids {
+			c.kill(id)
+			c.servers[id].Close()
+		}
+	})
+	return c
+}
+
+// kill detiene el Raft del nodo y deja de responder a sus RPC
+func (c *raftCluster) kill(id string) {
+	if atomic.CompareAndSwapInt32(c.down[id], 0, 1) {
+		close(c.stops[id])
+	}
+}
+
+// leader espera a que uno de los nodos vivos se vea líder
+func (c *raftCluster) leader(t *testing.T, timeout time.Duration) string {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for _, id := range c.ids {
+			if atomic.LoadInt32(c.down[id]) == 0 && c.Node(id).raft.Status().Role == raftLeader.String() {
+				return id
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no raft leader was elected")
+	return ""
+}
+
+func TestRaftLeaderFailureMidReservationDoesNotDoubleBook(t *testing.T) {
+	c := newRaftCluster(t, "node1", "node2", "node3")
+	leader := c.leader(t, 3*time.Second)
+
+	var followers []string
+	for _, id := range c.ids {
+		if id != leader {
+			followers = append(followers, id)
+		}
+	}
+	first, second := followers[0], followers[1]
+
+	// first entra en la CS y empieza a reservar el asiento 7
+	if err := c.Enter(first, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// second pide la misma reserva mientras tanto
+	var (
+		wg           sync.WaitGroup
+		secondResult error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		secondResult = c.Reserve(second, 7, "cliente-"+second, 10*time.Second)
+	}()
+
+	// El líder cae a mitad de la reserva
+	c.kill(leader)
+	newLeader := c.leader(t, 3*time.Second)
+	if newLeader == leader {
+		t.Fatalf("%s is down but still reported as leader", leader)
+	}
+
+	// La tabla reconstruida por el nuevo líder sigue dando la CS a first
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Node(newLeader).raft.Status().Table.Holder != first {
+		if time.Now().After(deadline) {
+			t.Fatalf("new leader %s does not see %s holding the lock: %+v", newLeader, first, c.Node(newLeader).raft.Status().Table)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if holders := c.Holders(); !reflect.DeepEqual(holders, []string{first}) {
+		t.Fatalf("expected only %s in the CS after the failover, got %v", first, holders)
+	}
+
+	if err := c.Seats.Reserve(7, "cliente-"+first); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit(first)
+	wg.Wait()
+
+	if secondResult != errFakeSeatTaken {
+		t.Fatalf("expected %s to find seat 7 taken, got %v", second, secondResult)
+	}
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("mutual exclusion violated %d times", v)
+	}
+	if owner := c.Seats.seats[7]; owner != "cliente-"+first {
+		t.Fatalf("seat 7 belongs to %q", owner)
+	}
+}
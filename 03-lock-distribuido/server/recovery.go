@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Reconciliación tras un reinicio. Un nodo que cae en Held arranca en
+// Released, pero pudo dejar una operación a medias: el asiento actualizado y
+// sin entrada de auditoría, con el cliente sin respuesta. Cada escritura de
+// la CS guarda en el asiento una SeatOp con el estado anterior, y la entrada
+// de auditoría con el mismo op_id es la marca de que la operación terminó.
+// Al arrancar, el nodo pide la CS, busca los asientos que escribió durante la
+// última ventana de CS_MAX_HOLD_MS antes de caer y, para cada uno, confirma la
+// operación si tiene marca o la deshace si no la tiene.
+
+// Operaciones que puede dejar una escritura de la CS
+const (
+	OpReservar = "reservar"
+	OpLiberar  = "liberar"
+)
+
+// SeatOp es la escritura de la CS que dejó el asiento como está, con el
+// estado anterior para poder deshacerla
+type SeatOp struct {
+	ID             string `bson:"id" json:"id"`
+	Operacion      string `bson:"operacion" json:"operacion"`
+	PrevDisponible bool   `bson:"prev_disponible" json:"prev_disponible"`
+	PrevCliente    string `bson:"prev_cliente,omitempty" json:"prev_cliente,omitempty"`
+}
+
+// newSeatOp describe la escritura con logicalTS que deja el asiento
+// disponible o no partiendo de previo. El reloj de Lamport nunca repite un
+// valor en el mismo nodo, ni tras reiniciar, así que el ID es único.
+func newSeatOp(serverID string, logicalTS int64, disponible bool, previo Asiento) SeatOp {
+	operacion := OpReservar
+	if disponible {
+		operacion = OpLiberar
+	}
+	return SeatOp{
+		ID:             fmt.Sprintf("%s-%d", serverID, logicalTS),
+		Operacion:      operacion,
+		PrevDisponible: previo.Disponible,
+		PrevCliente:    previo.Cliente,
+	}
+}
+
+// Decisiones de la reconciliación sobre un asiento
+const (
+	RecoveryConfirmed  = "confirmada"
+	RecoveryRolledBack = "deshecha"
+	RecoveryFailed     = "error"
+)
+
+// RecoveryDecision es lo que la reconciliación hizo con un asiento
+type RecoveryDecision struct {
+	Numero    int    `json:"numero"`
+	OpID      string `json:"op_id"`
+	Operacion string `json:"operacion"`
+	Accion    string `json:"accion"`
+	Error     string `json:"error,omitempty"`
+}
+
+// recoveryStore es lo que la reconciliación necesita de la BD
+type recoveryStore interface {
+	// PendingOps devuelve los asientos con SeatOp que serverID escribió
+	// desde since
+	PendingOps(ctx context.Context, serverID string, since time.Time) ([]Asiento, error)
+	// OpCompleted indica si existe la marca de la operación
+	OpCompleted(ctx context.Context, opID string) (bool, error)
+	// RollBack devuelve el asiento al estado anterior a su SeatOp si aún la
+	// conserva
+	RollBack(ctx context.Context, asiento Asiento, op SeatOp) error
+}
+
+// reconcileSeatOps decide sobre cada operación del nodo desde since y deja
+// constancia en el log de cada decisión. Debe llamarse dentro de la CS.
+func reconcileSeatOps(ctx context.Context, store recoveryStore, serverID string, since time.Time) ([]RecoveryDecision, error) {
+	asientos, err := store.PendingOps(ctx, serverID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make([]RecoveryDecision, 0, len(asientos))
+	for _, asiento := range asientos {
+		op := *asiento.Op
+		decision := RecoveryDecision{Numero: asiento.Numero, OpID: op.ID, Operacion: op.Operacion}
+
+		completed, err := store.OpCompleted(ctx, op.ID)
+		switch {
+		case err != nil:
+			decision.Accion, decision.Error = RecoveryFailed, err.Error()
+			log.Printf("[%s] Recovery: could not check operation %s on seat %d: %v", serverID, op.ID, asiento.Numero, err)
+		case completed:
+			decision.Accion = RecoveryConfirmed
+			log.Printf("[%s] Recovery: %s of seat %d (%s) completed before the crash, keeping it",
+				serverID, op.Operacion, asiento.Numero, op.ID)
+		default:
+			if err := store.RollBack(ctx, asiento, op); err != nil {
+				decision.Accion, decision.Error = RecoveryFailed, err.Error()
+				log.Printf("[%s] Recovery: CRITICAL: could not roll back %s of seat %d (%s): %v",
+					serverID, op.Operacion, asiento.Numero, op.ID, err)
+				break
+			}
+			decision.Accion = RecoveryRolledBack
+			log.Printf("[%s] Recovery: %s of seat %d (%s) never completed, rolled back to disponible=%t cliente=%q",
+				serverID, op.Operacion, asiento.Numero, op.ID, op.PrevDisponible, op.PrevCliente)
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions, nil
+}
+
+// mongoRecoveryStore implementa recoveryStore sobre la colección de asientos
+// y la auditoría
+type mongoRecoveryStore struct {
+	s *Server
+}
+
+func (m mongoRecoveryStore) PendingOps(ctx context.Context, serverID string, since time.Time) ([]Asiento, error) {
+	filter := bson.M{
+		"server_id":  serverID,
+		"updated_at": bson.M{"$gte": since},
+		"op":         bson.M{"$exists": true},
+	}
+	cursor, err := m.s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	asientos := []Asiento{}
+	if err := cursor.All(ctx, &asientos); err != nil {
+		return nil, err
+	}
+	return asientos, nil
+}
+
+func (m mongoRecoveryStore) OpCompleted(ctx context.Context, opID string) (bool, error) {
+	err := m.s.audit.collection.FindOne(ctx, bson.M{"op_id": opID}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m mongoRecoveryStore) RollBack(ctx context.Context, asiento Asiento, op SeatOp) error {
+	update := bson.M{
+		"$set": bson.M{
+			"disponible": op.PrevDisponible,
+			"cliente":    op.PrevCliente,
+			"server_id":  m.s.serverID,
+			"updated_at": time.Now(),
+			"logical_ts": m.s.node.Clock.Increment(),
+		},
+		"$unset": bson.M{"op": ""},
+	}
+	// Solo si nadie ha vuelto a escribir el asiento desde la operación
+	_, err := m.s.collection.UpdateOne(ctx, bson.M{"numero": asiento.Numero, "op.id": op.ID}, update)
+	return err
+}
+
+// recoverySince es desde cuándo buscar operaciones a medias: una operación
+// en curso al caer empezó como mucho maxHold antes de la caída, y la caída
+// fue después del último snapshot guardado. Sin snapshot se cuenta desde
+// ahora; sin límite de estancia en la CS se revisan todas.
+func recoverySince(snap *NodeSnapshot, maxHold time.Duration, now time.Time) time.Time {
+	if maxHold <= 0 {
+		return time.Time{}
+	}
+	last := now
+	if snap != nil && !snap.UpdatedAt.IsZero() && snap.UpdatedAt.Before(now) {
+		last = snap.UpdatedAt
+	}
+	return last.Add(-maxHold)
+}
+
+// reconcileAfterRestart revisa, dentro de la CS, las operaciones que el nodo
+// dejó a medias antes de caer. Lo reintenta hasta conseguirlo: el nodo no
+// atiende reservas mientras tanto.
+func (s *Server) reconcileAfterRestart(since time.Time) {
+	store := mongoRecoveryStore{s: s}
+	for attempt := 1; ; attempt++ {
+		err := s.reconcileInCS(store, since)
+		if err == nil {
+			return
+		}
+		log.Printf("[%s] Recovery attempt %d failed: %v", s.serverID, attempt, err)
+		time.Sleep(seatsInitRetry)
+	}
+}
+
+// reconcileInCS hace una pasada de reconciliación con la CS. Pide la CS con
+// prioridad de mantenimiento, como la inicialización de los asientos.
+func (s *Server) reconcileInCS(store recoveryStore, since time.Time) error {
+	ctx := WithCSResource(WithCSPriority(context.Background(), PriorityMaintenance), "reconciliación tras reinicio")
+	release, err := s.acquireCS(ctx, csWaitTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	decisions, err := reconcileSeatOps(ctx, store, s.serverID, since)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, decision := range decisions {
+		if decision.Accion == RecoveryFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d operations could not be reconciled", failed, len(decisions))
+	}
+	log.Printf("[%s] Recovery: %d operations since %s reviewed", s.serverID, len(decisions), since.Format(time.RFC3339))
+	return nil
+}
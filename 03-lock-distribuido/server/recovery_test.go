@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/models"
+)
+
+// FakeRecoveryStore sustituye a los asientos y a la auditoría en la
+// reconciliación tras un reinicio
+type FakeRecoveryStore struct {
+	mu      sync.Mutex
+	seats   map[int]Asiento
+	markers map[string]bool
+}
+
+// NewFakeRecoveryStore crea total asientos libres sin operaciones
+func NewFakeRecoveryStore(total int) *FakeRecoveryStore {
+	st := &FakeRecoveryStore{seats: make(map[int]Asiento, total), markers: make(map[string]bool)}
+	for i := 1; i <= total; i++ {
+		st.seats[i] = asientoLibre(i)
+	}
+	return st
+}
+
+// Write hace lo que aplicarOperacion hasta justo antes de la marca: deja el
+// asiento escrito con su SeatOp en el instante at y devuelve el ID
+func (st *FakeRecoveryStore) Write(serverID string, logicalTS int64, numero int, disponible bool, cliente string, at time.Time) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	previo := st.seats[numero]
+	op := newSeatOp(serverID, logicalTS, disponible, previo)
+	st.seats[numero] = Asiento{
+		AsientoBase: models.AsientoBase{AsientoEstado: models.AsientoEstado{Numero: numero, Disponible: disponible, Cliente: cliente}, ServerID: serverID, UpdatedAt: at},
+		LogicalTS:   logicalTS,
+		Op:          &op,
+	}
+	return op.ID
+}
+
+// Mark escribe la marca de que la operación terminó
+func (st *FakeRecoveryStore) Mark(opID string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.markers[opID] = true
+}
+
+// Seat devuelve el estado actual de un asiento
+func (st *FakeRecoveryStore) Seat(numero int) Asiento {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.seats[numero]
+}
+
+func (st *FakeRecoveryStore) PendingOps(ctx context.Context, serverID string, since time.Time) ([]Asiento, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var asientos []Asiento
+	for _, asiento := range st.seats {
+		if asiento.Op != nil && asiento.ServerID == serverID && !asiento.UpdatedAt.Before(since) {
+			asientos = append(asientos, asiento)
+		}
+	}
+	return asientos, nil
+}
+
+func (st *FakeRecoveryStore) OpCompleted(ctx context.Context, opID string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.markers[opID], nil
+}
+
+func (st *FakeRecoveryStore) RollBack(ctx context.Context, asiento Asiento, op SeatOp) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	current := st.seats[asiento.Numero]
+	if current.Op == nil || current.Op.ID != op.ID {
+		return nil
+	}
+	st.seats[asiento.Numero] = Asiento{AsientoBase: models.AsientoBase{
+		AsientoEstado: models.AsientoEstado{Numero: asiento.Numero, Disponible: op.PrevDisponible, Cliente: op.PrevCliente},
+		ServerID:      current.ServerID,
+		UpdatedAt:     time.Now(),
+	}}
+	return nil
+}
+
+// Node2 cae en la CS con los REPLY de node1 y node3 pospuestos. Un node2
+// nuevo, creado desde su snapshot serializado, envía esos REPLY al arrancar y
+// los otros dos consiguen entrar.
+func TestRestartPaysOwedReplies(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	crashed := c.Node("node2")
+	// Sin anuncios HELD: el nodo caído no debe enviar nada más
+	crashed.HeldAnnounceInterval = 0
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 2)
+	for _, id := range []string{"node1", "node3"} {
+		id := id
+		go func() {
+			err := c.Enter(id, 3*time.Second)
+			if err == nil {
+				time.Sleep(10 * time.Millisecond)
+				c.Exit(id)
+			}
+			done <- err
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		crashed.mu.Lock()
+		deferred := len(crashed.DeferredReplies)
+		crashed.mu.Unlock()
+		if deferred == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("node2 never deferred both requests")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	data, err := json.Marshal(crashed.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Network.Detach("node2")
+
+	var snap NodeSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Owed) != 2 || snap.State != Held.String() {
+		t.Fatalf("expected a Held snapshot owing 2 replies, got %s owing %v", snap.State, snap.Owed)
+	}
+
+	restarted := newSimNode("node2", []string{"node1", "node3"})
+	restarted.RestoreState(&snap, 1000)
+	c.Network.Attach(restarted)
+	c.nodes["node2"] = restarted
+	if paid := restarted.PayOwedReplies(); paid != 2 {
+		t.Fatalf("expected node2 to send 2 owed replies, sent %d", paid)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state := restarted.CSStatus().State; state != Released.String() {
+		t.Fatalf("restarted node2 should be Released, got %s", state)
+	}
+	if left := restarted.Snapshot().Owed; len(left) != 0 {
+		t.Fatalf("node2 still owes %v after delivering", left)
+	}
+}
+
+// Node1 cae dentro de la CS entre la escritura de dos asientos y sus marcas.
+// Al reiniciar, la reconciliación espera a la CS, confirma la operación con
+// marca y deshace las que no la tienen, sin tocar las de otros nodos ni las
+// anteriores a la ventana.
+func TestRestartReconcilesUnmarkedOps(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	st := NewFakeRecoveryStore(6)
+	now := time.Now()
+	crash := now.Add(-time.Minute)
+	maxHold := 5 * time.Second
+	// El último snapshot se guardó justo antes de caer
+	since := recoverySince(&NodeSnapshot{UpdatedAt: crash.Add(-time.Second)}, maxHold, now)
+
+	// Estado anterior: ana tiene el 3 y bruno el 6, ambas operaciones terminadas
+	st.Mark(st.Write("node2", 1, 3, false, "ana", crash.Add(-time.Hour)))
+	st.Mark(st.Write("node1", 2, 6, false, "bruno", crash.Add(-time.Hour)))
+
+	// Dentro de la última CS de node1: el 1 terminó; el 2 y la liberación
+	// del 3 se escribieron pero node1 cayó antes de las marcas
+	st.Mark(st.Write("node1", 10, 1, false, "carla", crash.Add(-2*time.Second)))
+	st.Write("node1", 11, 2, false, "dario", crash.Add(-time.Second))
+	st.Write("node1", 12, 3, true, "", crash)
+	// Una operación sin marca de otro nodo no es asunto de node1
+	st.Write("node2", 13, 4, false, "elena", crash)
+	// Ni una de node1 anterior a la ventana (la reconciliación previa la
+	// habría revisado)
+	st.Write("node1", 3, 5, false, "fede", crash.Add(-time.Hour))
+
+	// La reconciliación es una escritura más: espera a que node2 salga
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{node: c.Node("node1"), serverID: "node1"}
+	done := make(chan error, 1)
+	go func() { done <- s.reconcileInCS(st, since) }()
+	time.Sleep(50 * time.Millisecond)
+	if seat := st.Seat(2); seat.Disponible {
+		t.Fatal("seat 2 was rolled back while node2 held the CS")
+	}
+	c.Exit("node2")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int]struct {
+		disponible bool
+		cliente    string
+	}{
+		1: {false, "carla"}, // confirmada
+		2: {true, ""},       // reserva deshecha
+		3: {false, "ana"},   // liberación deshecha
+		4: {false, "elena"},
+		5: {false, "fede"},
+		6: {false, "bruno"},
+	}
+	for numero, w := range want {
+		seat := st.Seat(numero)
+		if seat.Disponible != w.disponible || seat.Cliente != w.cliente {
+			t.Fatalf("seat %d: disponible=%t cliente=%q, want disponible=%t cliente=%q",
+				numero, seat.Disponible, seat.Cliente, w.disponible, w.cliente)
+		}
+	}
+	for _, numero := range []int{2, 3} {
+		if st.Seat(numero).Op != nil {
+			t.Fatalf("seat %d keeps its operation after the rollback", numero)
+		}
+	}
+
+	// Una segunda pasada no encuentra nada que deshacer
+	decisions, err := reconcileSeatOps(context.Background(), st, "node1", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != 1 || decisions[0].Numero != 1 || decisions[0].Accion != RecoveryConfirmed {
+		t.Fatalf("second pass should only confirm seat 1 again, got %+v", decisions)
+	}
+	if c.Violations() != 0 {
+		t.Fatalf("%d mutual exclusion violations", c.Violations())
+	}
+}
+
+// Node2 cae mientras espera la CS, con su REQUEST pospuesto por node1 y el de
+// node3 pospuesto por él, y arranca de nuevo sin snapshot. Su RECOVER hace
+// que node1 olvide el REPLY que le debía y que node3 le vuelva a pedir el
+// suyo; los mensajes de la encarnación anterior que llegan después se
+// descartan.
+func TestRecoverStartsANewIncarnation(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1, node3 := c.Node("node1"), c.Node("node3")
+	crashed := c.Node("node2")
+	crashed.HeldAnnounceInterval = 0
+
+	waitUntil := func(what string, ok func() bool) error {
+		deadline := time.Now().Add(2 * time.Second)
+		for !ok() {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out waiting until %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil
+	}
+	deferredBy := func(n *Node, peer string) bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return n.hasDeferred(peer)
+	}
+	needs := func(n *Node, peer string) bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return n.RepliesNeeded[peer]
+	}
+
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	oldCtx, cancelOld := context.WithCancel(context.Background())
+	defer cancelOld()
+	oldDone := make(chan error, 1)
+	go func() { oldDone <- crashed.RequestCSContext(oldCtx) }()
+	if err := waitUntil("node1 defers node2 and node3 replies to it", func() bool {
+		return deferredBy(node1, "node2") && !needs(crashed, "node3")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	node3Done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		node3Done <- node3.RequestCSContext(ctx)
+	}()
+	if err := waitUntil("node1 and node2 defer node3", func() bool {
+		return deferredBy(node1, "node3") && deferredBy(crashed, "node3")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Caída y arranque sin snapshot: la encarnación nueva no sabe que debía
+	// un REPLY a node3
+	old := crashed.Incarnation()
+	c.Network.Detach("node2")
+	restarted := newSimNode("node2", []string{"node1", "node3"})
+	c.Network.Attach(restarted)
+	if restarted.Incarnation() <= old {
+		t.Fatalf("restarted incarnation %d is not newer than %d", restarted.Incarnation(), old)
+	}
+	if !deferredBy(node1, "node2") || !needs(node3, "node2") {
+		t.Fatal("before RECOVER node1 should still owe node2 and node3 wait for it")
+	}
+
+	restarted.AnnounceRecovery()
+	if err := waitUntil("peers forget the old incarnation", func() bool {
+		return !deferredBy(node1, "node2") && !needs(node3, "node2")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !deferredBy(node1, "node3") {
+		t.Fatal("node1 dropped the deferred reply to node3 too")
+	}
+
+	// Un REQUEST de la encarnación anterior que llega tarde no se pospone
+	stale := Message{
+		Type:        "REQUEST",
+		Timestamp:   node1.Clock.GetTime() + 100,
+		NodeID:      "node2",
+		Round:       42,
+		Seq:         uint64(time.Now().UnixNano()),
+		Incarnation: old,
+	}
+	if _, err := node1.handleMessage(stale); err != nil {
+		t.Fatal(err)
+	}
+	if deferredBy(node1, "node2") {
+		t.Fatal("node1 deferred a REQUEST from node2's previous incarnation")
+	}
+
+	// El nodo caído "despierta" y cancela: su REPLY a node3 llega tarde y
+	// se descarta
+	cancelOld()
+	if err := <-oldDone; err == nil {
+		t.Fatal("the crashed incarnation should not get the CS")
+	}
+
+	c.Exit("node1")
+	if err := <-node3Done; err != nil {
+		t.Fatalf("node3 never entered after node2 restarted: %v", err)
+	}
+	for _, n := range []*Node{node1, restarted} {
+		if state := n.CSStatus().State; state != Released.String() {
+			t.Fatalf("%s is %s while node3 holds the CS", n.ID, state)
+		}
+	}
+	node3.ReleaseCS()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := restarted.RequestCSContext(ctx); err != nil {
+		t.Fatalf("restarted node2 could not enter: %v", err)
+	}
+	restarted.ReleaseCS()
+}
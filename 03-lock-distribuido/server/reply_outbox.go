@@ -0,0 +1,178 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplyOutbox guarda los REPLY pospuestos hasta que llegan a su destino.
+//
+// Un REPLY pospuesto que se pierde deja al peer en Wanted para siempre: nadie
+// más se lo va a enviar. Por eso ReleaseCS no lo envía y se olvida, sino que
+// lo deja aquí; cada entrada se reintenta según Policy hasta que se entrega,
+// el detector de fallos declara caído al peer o la política se agota.
+//
+// Por peer basta con el REPLY más reciente: uno nuevo sustituye al pendiente,
+// que ya respondía a una ronda anterior.
+type ReplyOutbox struct {
+	node *Node
+
+	mu        sync.Mutex
+	pending   map[string]*pendingReply // peer -> REPLY sin entregar
+	delivered uint64
+	dropped   uint64
+
+	// Esperas entre intentos de entrega; por defecto sin límite de intentos
+	Policy RetryPolicy
+}
+
+// pendingReply es un REPLY en el outbox. done se cierra cuando la entrada se
+// sustituye o se descarta, para que su goroutine deje de reintentar.
+type pendingReply struct {
+	msg      Message
+	since    time.Time
+	attempts int
+	done     chan struct{}
+}
+
+func newReplyOutbox(n *Node) *ReplyOutbox {
+	return &ReplyOutbox{
+		node:    n,
+		pending: make(map[string]*pendingReply),
+		Policy:  defaultOutboxPolicy(),
+	}
+}
+
+// Add deja un REPLY en el outbox y empieza a entregarlo en segundo plano. El
+// primer intento sale por la cola de salida del peer, detrás de lo que ya se
+// le despachó; si falla, los reintentos siguen por su cuenta.
+// Se llama con el mutex del nodo tomado.
+func (o *ReplyOutbox) Add(peerID string, msg Message) {
+	entry := &pendingReply{msg: msg, since: time.Now(), done: make(chan struct{})}
+
+	o.mu.Lock()
+	if previous, ok := o.pending[peerID]; ok {
+		close(previous.done)
+	}
+	o.pending[peerID] = entry
+	o.mu.Unlock()
+
+	o.node.enqueue(peerID, msg.Type, func() {
+		select {
+		case <-entry.done:
+			return
+		default:
+		}
+		if o.node.sendMessage(peerID, entry.msg) {
+			o.finish(peerID, entry)
+			return
+		}
+		go o.retry(peerID, entry)
+	})
+}
+
+// Drop descarta el REPLY pendiente de un peer, p. ej. porque está caído o
+// ha salido del clúster
+func (o *ReplyOutbox) Drop(peerID, reason string) {
+	o.mu.Lock()
+	entry, ok := o.pending[peerID]
+	if ok {
+		close(entry.done)
+		delete(o.pending, peerID)
+		o.dropped++
+		o.node.markStateChanged()
+	}
+	o.mu.Unlock()
+
+	if ok {
+		o.node.logf("Dropping undelivered reply to %s after %d attempts: %s",
+			peerID, entry.attempts, reason)
+	}
+}
+
+// retry reintenta una entrada cuyo primer intento falló hasta entregarla o
+// hasta que se sustituya o descarte
+func (o *ReplyOutbox) retry(peerID string, entry *pendingReply) {
+	for {
+		o.mu.Lock()
+		entry.attempts++
+		attempts := entry.attempts
+		o.mu.Unlock()
+		delay, stop := o.Policy.Next(attempts, time.Since(entry.since))
+		if stop != nil {
+			// sendMessage ya contó cada intento fallido en el detector
+			o.Drop(peerID, stop.Error())
+			return
+		}
+		o.node.logf("Reply to %s still undelivered after %d attempts, retrying in %s",
+			peerID, attempts, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-entry.done:
+			timer.Stop()
+			return
+		}
+
+		if o.node.sendMessage(peerID, entry.msg) {
+			o.finish(peerID, entry)
+			return
+		}
+	}
+}
+
+// finish retira una entrada entregada, si sigue siendo la vigente del peer
+func (o *ReplyOutbox) finish(peerID string, entry *pendingReply) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.pending[peerID] != entry {
+		return
+	}
+	delete(o.pending, peerID)
+	o.delivered++
+	o.node.markStateChanged()
+	if entry.attempts > 0 {
+		o.node.logf("Delivered deferred reply to %s after %d failed attempts (%s)",
+			peerID, entry.attempts, time.Since(entry.since).Round(time.Millisecond))
+	}
+}
+
+// ReplyOutboxStats es la vista del outbox que publican las métricas
+type ReplyOutboxStats struct {
+	Depth       int     `json:"depth"`
+	OldestAgeMs float64 `json:"oldest_age_ms"`
+	Delivered   uint64  `json:"delivered"`
+	Dropped     uint64  `json:"dropped"`
+}
+
+// Stats devuelve la profundidad del outbox y sus contadores
+func (o *ReplyOutbox) Stats() ReplyOutboxStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats := ReplyOutboxStats{
+		Depth:     len(o.pending),
+		Delivered: o.delivered,
+		Dropped:   o.dropped,
+	}
+	for _, entry := range o.pending {
+		if age := durationMs(time.Since(entry.since)); age > stats.OldestAgeMs {
+			stats.OldestAgeMs = age
+		}
+	}
+	return stats
+}
+
+// owedRounds devuelve, por peer, la ronda del REPLY que aún no se ha entregado
+func (o *ReplyOutbox) owedRounds() map[string]int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rounds := make(map[string]int64, len(o.pending))
+	for peerID, entry := range o.pending {
+		rounds[peerID] = entry.msg.Round
+	}
+	return rounds
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockPeer hace que la red pierda todo lo que se envía a peer durante d
+func blockPeer(c *SimCluster, peer string, d time.Duration) {
+	until := time.Now().Add(d).UnixNano()
+	c.Network.Drop = func(from, to string, msg Message) bool {
+		return to == peer && time.Now().UnixNano() < until
+	}
+}
+
+// deferReplyTo deja a node1 en la CS con el REPLY a node2 pospuesto y
+// devuelve el canal con el resultado de la petición de node2
+func deferReplyTo(t *testing.T, c *SimCluster) chan error {
+	t.Helper()
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	entered := make(chan error, 1)
+	go func() { entered <- c.Enter("node2", time.Minute) }()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.Node("node1").DebugState().DeferredReplies) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("node1 never deferred its reply to node2")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return entered
+}
+
+// Con node2 inalcanzable durante 30 segundos, el REPLY pospuesto espera en
+// el outbox mucho después de agotar los reintentos de sendMessage y llega
+// en cuanto node2 vuelve, que entonces entra en la CS
+func TestDeferredReplySurvivesABlockedPeer(t *testing.T) {
+	t.Parallel()
+	blocked := 30 * time.Second
+	if testing.Short() {
+		blocked = time.Second
+	}
+	c := NewSimCluster("node1", "node2")
+	c.Node("node1").HeldAnnounceInterval = 0
+	entered := deferReplyTo(t, c)
+	node1 := c.Node("node1")
+
+	blockPeer(c, "node2", blocked)
+	start := time.Now()
+	c.Exit("node1")
+
+	time.Sleep(blocked / 2)
+	if stats := node1.replies.Stats(); stats.Depth != 1 || stats.Delivered != 0 || stats.Dropped != 0 {
+		t.Fatalf("expected the reply to wait in the outbox, got %+v", stats)
+	}
+	var buf bytes.Buffer
+	node1.MessageStats().WritePrometheus(&buf, "node1")
+	if !strings.Contains(buf.String(), `dme_reply_outbox_depth{node="node1",algorithm="ricart-agrawala"} 1`) {
+		t.Fatalf("expected the outbox depth in the metrics, got:\n%s", buf.String())
+	}
+	if state := c.Node("node2").CSStatus().State; state != Wanted.String() {
+		t.Fatalf("expected node2 to keep waiting while blocked, got %s", state)
+	}
+
+	select {
+	case err := <-entered:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(blocked + 10*time.Second):
+		t.Fatal("node2 never got the deferred reply")
+	}
+	if waited := time.Since(start); waited < blocked {
+		t.Fatalf("node2 entered after %s, before the %s block ended", waited, blocked)
+	}
+	c.Exit("node2")
+	if stats := node1.replies.Stats(); stats.Depth != 0 || stats.Delivered != 1 {
+		t.Fatalf("expected the reply delivered from the outbox, got %+v", stats)
+	}
+}
+
+// Si el detector declara caído al peer, su REPLY sale del outbox
+func TestDeadPeerReplyIsDroppedFromTheOutbox(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node1 := c.Node("node1")
+	node1.HeldAnnounceInterval = 0
+	fd := NewFailureDetector(node1, time.Second, 3)
+	node1.detector = fd
+	deferReplyTo(t, c)
+
+	var attempts int32
+	c.Network.Drop = func(from, to string, msg Message) bool {
+		if to == "node2" {
+			atomic.AddInt32(&attempts, 1)
+			return true
+		}
+		return false
+	}
+	c.Exit("node1")
+	deadline := time.Now().Add(2 * time.Second)
+	for node1.replies.Stats().Depth == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the reply never reached the outbox")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	declareDead(t, fd, "node2")
+	if stats := node1.replies.Stats(); stats.Depth != 0 || stats.Dropped != 1 {
+		t.Fatalf("expected the dead peer's reply to be dropped, got %+v", stats)
+	}
+	// La goroutine de reintentos se detiene con la entrada
+	before := atomic.LoadInt32(&attempts)
+	time.Sleep(defaultOutboxPolicy().InitialDelay * 3)
+	if after := atomic.LoadInt32(&attempts); after != before {
+		t.Fatalf("expected no retries after dropping the reply, got %d more", after-before)
+	}
+}
+
+// Un REPLY nuevo al mismo peer sustituye al pendiente
+func TestNewerReplyReplacesThePendingOne(t *testing.T) {
+	node, capture := newSequenceNode("node2")
+	capture.Delay = func(Message) time.Duration { return 50 * time.Millisecond }
+
+	node.mu.Lock()
+	node.replies.Add("node2", Message{Type: "REPLY", NodeID: "node1", Round: 1})
+	node.replies.Add("node2", Message{Type: "REPLY", NodeID: "node1", Round: 2})
+	node.mu.Unlock()
+
+	rounds := node.replies.owedRounds()
+	if len(rounds) != 1 || rounds["node2"] != 2 {
+		t.Fatalf("expected only round 2 to be owed, got %v", rounds)
+	}
+	if msg := capture.next(t); msg.Round != 2 {
+		t.Fatalf("expected the round 2 reply to be sent, got %+v", msg)
+	}
+	select {
+	case msg := <-capture.Sent:
+		t.Fatalf("expected the replaced reply not to be sent, got %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy decide cuántas veces y con qué esperas se reintenta un envío.
+// La espera tras el intento fallido k (0 = el primero) es InitialDelay
+// multiplicada k veces por Multiplier, con tope MaxDelay; Jitter es la
+// fracción de esa espera que se sortea, para que los nodos que fallan a la
+// vez no reintenten a la vez (0.5 = entre la mitad y el total).
+type RetryPolicy struct {
+	// Intentos en total, el primero incluido (0 = sin límite)
+	MaxAttempts  int
+	InitialDelay time.Duration
+	Multiplier   float64
+	// Tope de la espera entre intentos (0 = sin tope)
+	MaxDelay time.Duration
+	Jitter   float64
+	// Tiempo total desde el primer intento (0 = sin límite): no se programa
+	// un reintento que acabaría después
+	Deadline time.Duration
+}
+
+// Motivos por los que una política deja de reintentar
+var (
+	errRetryAttempts = errors.New("retry attempts exhausted")
+	errRetryDeadline = errors.New("retry deadline exceeded")
+)
+
+// defaultRetryPolicy es la de los envíos entre nodos si no se configura otra:
+// 3 intentos desde 100ms y como mucho lo que una petición espera la CS, ya
+// que más allá nadie espera ese mensaje
+func defaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: 100 * time.Millisecond,
+		Multiplier:   2,
+		MaxDelay:     time.Second,
+		Jitter:       0.5,
+		Deadline:     csWaitTimeout,
+	}
+}
+
+// defaultOutboxPolicy es la del outbox de REPLY pospuestos: reintenta sin
+// límite hasta entregarlos o hasta que el detector de fallos dé al peer por
+// caído
+func defaultOutboxPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialDelay: 500 * time.Millisecond,
+		Multiplier:   2,
+		MaxDelay:     5 * time.Second,
+	}
+}
+
+// nominalDelay es la espera tras el intento fallido attempt, sin jitter
+func (p RetryPolicy) nominalDelay(attempt int) time.Duration {
+	delay := p.InitialDelay
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay = time.Duration(float64(delay) * p.Multiplier)
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Delay es la espera tras el intento fallido attempt, con jitter
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.nominalDelay(attempt)
+	spread := time.Duration(float64(delay) * p.Jitter)
+	if spread <= 0 {
+		return delay
+	}
+	return delay - spread + time.Duration(rand.Int63n(int64(spread)+1))
+}
+
+// Next decide si se reintenta después de attempts intentos fallidos, con
+// elapsed transcurrido desde el primero, y devuelve la espera hasta el
+// siguiente o el motivo para parar
+func (p RetryPolicy) Next(attempts int, elapsed time.Duration) (time.Duration, error) {
+	if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+		return 0, errRetryAttempts
+	}
+	delay := p.Delay(attempts - 1)
+	if p.Deadline > 0 && elapsed+delay > p.Deadline {
+		return 0, errRetryDeadline
+	}
+	return delay, nil
+}
+
+// String resume la política para los logs
+func (p RetryPolicy) String() string {
+	attempts, deadline := "unlimited", "none"
+	if p.MaxAttempts > 0 {
+		attempts = strconv.Itoa(p.MaxAttempts)
+	}
+	if p.Deadline > 0 {
+		deadline = p.Deadline.String()
+	}
+	return fmt.Sprintf("attempts=%s delay=%s multiplier=%g max_delay=%s jitter=%g deadline=%s",
+		attempts, p.InitialDelay, p.Multiplier, p.MaxDelay, p.Jitter, deadline)
+}
+
+// validate comprueba que los valores tengan sentido
+func (p RetryPolicy) validate() error {
+	switch {
+	case p.MaxAttempts < 0:
+		return fmt.Errorf("attempts must not be negative")
+	case p.InitialDelay < 0 || p.MaxDelay < 0 || p.Deadline < 0:
+		return fmt.Errorf("delays must not be negative")
+	case p.Multiplier < 1:
+		return fmt.Errorf("multiplier must be at least 1")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// ParseRetryPolicy aplica sobre base una lista "clave=valor,..." como
+// "attempts=5,delay=200ms,multiplier=2,max_delay=2s,jitter=0.5,deadline=10s".
+// Las claves que no aparecen conservan el valor de base.
+func ParseRetryPolicy(spec string, base RetryPolicy) (RetryPolicy, error) {
+	policy := base
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return base, fmt.Errorf("retry policy entry %q must have the form key=value", entry)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		var err error
+		switch key {
+		case "attempts":
+			policy.MaxAttempts, err = strconv.Atoi(value)
+		case "delay":
+			policy.InitialDelay, err = time.ParseDuration(value)
+		case "multiplier":
+			policy.Multiplier, err = strconv.ParseFloat(value, 64)
+		case "max_delay":
+			policy.MaxDelay, err = time.ParseDuration(value)
+		case "jitter":
+			policy.Jitter, err = strconv.ParseFloat(value, 64)
+		case "deadline":
+			policy.Deadline, err = time.ParseDuration(value)
+		default:
+			return base, fmt.Errorf("unknown retry policy key %q", key)
+		}
+		if err != nil {
+			return base, fmt.Errorf("invalid %s %q: %v", key, value, err)
+		}
+	}
+	if err := policy.validate(); err != nil {
+		return base, fmt.Errorf("retry policy %q: %v", spec, err)
+	}
+	return policy, nil
+}
+
+// retryPolicyEnvPrefix es el prefijo de las variables que ajustan la política
+// de un tipo de mensaje, p. ej. RETRY_POLICY_REQUEST, o la del outbox
+// (RETRY_POLICY_OUTBOX)
+const retryPolicyEnvPrefix = "RETRY_POLICY_"
+
+// outboxPolicyName es el nombre de la política del outbox en el entorno
+const outboxPolicyName = "OUTBOX"
+
+// retryOverridesFromEnv lee las variables RETRY_POLICY_<TIPO>. Cada una parte
+// de la política general, salvo OUTBOX, que parte de la del outbox.
+func retryOverridesFromEnv(base, outbox RetryPolicy) (map[string]RetryPolicy, RetryPolicy, error) {
+	overrides := make(map[string]RetryPolicy)
+	var names []string
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, retryPolicyEnvPrefix) {
+			names = append(names, strings.SplitN(env, "=", 2)[0])
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		msgType := strings.ToUpper(strings.TrimPrefix(name, retryPolicyEnvPrefix))
+		from := base
+		if msgType == outboxPolicyName {
+			from = outbox
+		}
+		policy, err := ParseRetryPolicy(os.Getenv(name), from)
+		if err != nil {
+			return nil, outbox, fmt.Errorf("%s: %v", name, err)
+		}
+		if msgType == outboxPolicyName {
+			outbox = policy
+		} else {
+			overrides[msgType] = policy
+		}
+	}
+	return overrides, outbox, nil
+}
+
+// retryPolicy devuelve la política con que se envía un tipo de mensaje
+func (n *Node) retryPolicy(msgType string) RetryPolicy {
+	if policy, ok := n.RetryOverrides[msgType]; ok {
+		return policy
+	}
+	return n.Retry
+}
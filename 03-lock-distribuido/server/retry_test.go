@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// delays devuelve las esperas sin jitter tras los primeros n intentos
+func delays(p RetryPolicy, n int) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = p.nominalDelay(i)
+	}
+	return out
+}
+
+func TestRetryBackoffSequence(t *testing.T) {
+	ms := time.Millisecond
+	for name, tc := range map[string]struct {
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		"send":   {defaultRetryPolicy(), []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, time.Second, time.Second}},
+		"outbox": {defaultOutboxPolicy(), []time.Duration{500 * ms, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}},
+		"no cap": {RetryPolicy{InitialDelay: ms, Multiplier: 10}, []time.Duration{ms, 10 * ms, 100 * ms, time.Second}},
+		"flat":   {RetryPolicy{InitialDelay: 50 * ms, Multiplier: 1}, []time.Duration{50 * ms, 50 * ms, 50 * ms}},
+	} {
+		got := delays(tc.policy, len(tc.want))
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("%s: expected backoff %v, got %v", name, tc.want, got)
+			}
+		}
+	}
+
+	// Sin límite de intentos el outbox no deja de reintentar por número
+	if _, stop := defaultOutboxPolicy().Next(1000, time.Hour); stop != nil {
+		t.Fatalf("expected the outbox policy to keep retrying, got %v", stop)
+	}
+	if _, stop := defaultRetryPolicy().Next(3, 0); stop != errRetryAttempts {
+		t.Fatalf("expected the send policy to stop after 3 attempts, got %v", stop)
+	}
+}
+
+// Un reintento que acabaría después del plazo ya no se programa
+func TestRetryDeadlineCutoff(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: 10 * time.Millisecond, Multiplier: 2, Deadline: 100 * time.Millisecond}
+
+	// Fallan todos los intentos: 10+20+40 = 70ms de esperas caben en el
+	// plazo, la de 80ms siguiente ya no
+	var elapsed time.Duration
+	attempts := 1
+	for {
+		delay, stop := policy.Next(attempts, elapsed)
+		if stop != nil {
+			if stop != errRetryDeadline {
+				t.Fatalf("expected the deadline to stop the retries, got %v", stop)
+			}
+			break
+		}
+		elapsed += delay
+		attempts++
+	}
+	if attempts != 4 || elapsed != 70*time.Millisecond {
+		t.Fatalf("expected 4 attempts within 70ms, got %d within %s", attempts, elapsed)
+	}
+
+	// Con jitter ninguna espera programada se sale del plazo
+	policy.Jitter = 1
+	for i := 0; i < 100; i++ {
+		if delay, stop := policy.Next(3, 60*time.Millisecond); stop == nil && 60*time.Millisecond+delay > policy.Deadline {
+			t.Fatalf("scheduled a %s retry past the deadline", delay)
+		}
+	}
+}
+
+func TestParseRetryPolicyRejectsBadSpecs(t *testing.T) {
+	for _, spec := range []string{
+		"attempts",
+		"retries=3",
+		"attempts=tres",
+		"attempts=-1",
+		"delay=-1s",
+		"multiplier=0.5",
+		"jitter=1.5",
+	} {
+		if _, err := ParseRetryPolicy(spec, defaultRetryPolicy()); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+// RETRY_POLICY_<TIPO> ajusta la política de un tipo de mensaje partiendo de
+// la general y RETRY_POLICY_OUTBOX la del outbox partiendo de la suya
+func TestRetryOverridesFromEnv(t *testing.T) {
+	t.Setenv("RETRY_POLICY_REQUEST", "attempts=7,deadline=0")
+	t.Setenv("RETRY_POLICY_OUTBOX", "attempts=4,delay=1s")
+
+	base := defaultRetryPolicy()
+	overrides, outbox, err := retryOverridesFromEnv(base, defaultOutboxPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := overrides["REQUEST"]
+	if request.MaxAttempts != 7 || request.Deadline != 0 || request.InitialDelay != base.InitialDelay {
+		t.Fatalf("unexpected REQUEST policy %s", request)
+	}
+	if _, ok := overrides["OUTBOX"]; ok || outbox.MaxAttempts != 4 || outbox.InitialDelay != time.Second || outbox.MaxDelay != 5*time.Second {
+		t.Fatalf("unexpected outbox policy %s (overrides %v)", outbox, overrides)
+	}
+
+	node := newSimNode("node1", []string{"node2"})
+	node.RetryOverrides = overrides
+	if node.retryPolicy("REQUEST").MaxAttempts != 7 || node.retryPolicy("REPLY") != node.Retry {
+		t.Fatalf("expected only REQUEST to use its own policy")
+	}
+
+	t.Setenv("RETRY_POLICY_REPLY", "jitter=2")
+	if _, _, err := retryOverridesFromEnv(base, defaultOutboxPolicy()); err == nil {
+		t.Fatal("expected an invalid RETRY_POLICY_REPLY to be rejected")
+	}
+}
+
+// Agotar los reintentos de un envío cuenta como un fallo para el detector,
+// no uno por intento
+func TestExhaustedRetriesFeedTheFailureDetector(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node1 := c.Node("node1")
+	node1.Retry = RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, Multiplier: 1}
+	fd := NewFailureDetector(node1, time.Second, 2)
+	fd.DeadAfter = 0
+	node1.detector = fd
+	transport := &flakyTransport{Inner: node1.transport, Failures: 1000}
+	node1.transport = transport
+
+	held := Message{Type: "HELD", NodeID: "node1"}
+	if node1.sendMessage("node2", held) {
+		t.Fatal("expected the send to fail")
+	}
+	if calls := transport.Calls(); calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+	if fd.IsSuspect("node2") {
+		t.Fatal("one exhausted send counted as more than one failure")
+	}
+	node1.sendMessage("node2", held)
+	if !fd.IsSuspect("node2") {
+		t.Fatal("expected node2 suspect after two exhausted sends")
+	}
+}
+
+// flakyTransport falla las primeras Failures llamadas a Send y después las
+// entrega por Inner
+type flakyTransport struct {
+	Inner    Transport
+	Failures int
+
+	mu    sync.Mutex
+	calls int
+}
+
+var errFlaky = errors.New("injected transport failure")
+
+func (t *flakyTransport) Name() string { return "flaky" }
+
+func (t *flakyTransport) Send(peerID string, seq uint64, payload []byte) (int, MessageResponse, error) {
+	t.mu.Lock()
+	t.calls++
+	fail := t.calls <= t.Failures
+	t.mu.Unlock()
+	if fail {
+		return 0, MessageResponse{}, errFlaky
+	}
+	return t.Inner.Send(peerID, seq, payload)
+}
+
+func (t *flakyTransport) Calls() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.calls
+}
+
+// Las esperas entre reintentos siguen la RetryPolicy (espera inicial,
+// multiplicador, tope y jitter), la política se corta al agotar intentos o
+// plazo, y sendMessage reintenta lo que dice la política del tipo de mensaje
+// con un transporte que falla a propósito
+func TestSendMessageRetriesPerMessageType(t *testing.T) {
+	policy, err := ParseRetryPolicy("attempts=5,delay=4ms,multiplier=3,max_delay=50ms,jitter=0,deadline=0", defaultRetryPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for attempt, want := range []time.Duration{4, 12, 36, 50, 50} {
+		if delay := policy.Delay(attempt); delay != want*time.Millisecond {
+			t.Fatalf("backoff after attempt %d was %s, expected %s", attempt+1, delay, want*time.Millisecond)
+		}
+	}
+	policy.Jitter = 0.5
+	for attempt, nominal := range []time.Duration{4, 12, 36, 50} {
+		nominal *= time.Millisecond
+		for i := 0; i < 50; i++ {
+			if delay := policy.Delay(attempt); delay < nominal/2 || delay > nominal {
+				t.Fatalf("jittered backoff after attempt %d was %s, expected between %s and %s",
+					attempt+1, delay, nominal/2, nominal)
+			}
+		}
+	}
+	if _, stop := policy.Next(5, 0); stop != errRetryAttempts {
+		t.Fatalf("expected the policy to stop after 5 attempts, got %v", stop)
+	}
+	// Con 20ms de plazo cabe la espera de 12ms tras el segundo intento (a
+	// los 4ms), pero no la de 36ms tras el tercero (a los 16ms)
+	policy.Jitter, policy.Deadline = 0, 20*time.Millisecond
+	if delay, stop := policy.Next(2, 4*time.Millisecond); stop != nil || delay != 12*time.Millisecond {
+		t.Fatalf("expected a 12ms wait within the deadline, got %s (%v)", delay, stop)
+	}
+	if _, stop := policy.Next(3, 16*time.Millisecond); stop != errRetryDeadline {
+		t.Fatalf("expected the 20ms deadline to stop retries after 16ms, got %v", stop)
+	}
+	if _, err := ParseRetryPolicy("multiplier=0.5", policy); err == nil {
+		t.Fatal("expected a multiplier below 1 to be rejected")
+	}
+
+	c := NewSimCluster("node1", "node2")
+	node1 := c.Node("node1")
+	node1.Retry.InitialDelay = 4 * time.Millisecond
+	node1.Retry.MaxDelay = 10 * time.Millisecond
+	node1.Retry.MaxAttempts = 5
+	node1.Retry.Deadline = 0
+
+	// Un anuncio HELD a un nodo que no está en la CS no altera su estado
+	send := func(failures int) (*flakyTransport, bool) {
+		transport := &flakyTransport{Inner: node1.transport, Failures: failures}
+		node1.transport = transport
+		defer func() { node1.transport = transport.Inner }()
+		delivered := node1.sendMessage("node2", Message{Type: "HELD", NodeID: "node1"})
+		return transport, delivered
+	}
+
+	if transport, delivered := send(3); !delivered || transport.Calls() != 4 {
+		t.Fatalf("3 failures with 5 retries: expected delivery on attempt 4, got delivered=%t after %d attempts",
+			delivered, transport.Calls())
+	}
+	if transport, delivered := send(10); delivered || transport.Calls() != 5 {
+		t.Fatalf("10 failures with 5 retries: expected to give up after 5 attempts, got delivered=%t after %d",
+			delivered, transport.Calls())
+	}
+
+	// Con 15ms de plazo caben entre 2 y 4 intentos según el jitter
+	node1.Retry.MaxAttempts = 10
+	node1.Retry.Deadline = 15 * time.Millisecond
+	if transport, delivered := send(10); delivered || transport.Calls() < 2 || transport.Calls() > 4 {
+		t.Fatalf("15ms retry deadline: expected to give up after 2-4 attempts, got delivered=%t after %d",
+			delivered, transport.Calls())
+	}
+
+	// La política propia de HELD manda sobre la general
+	node1.RetryOverrides["HELD"] = RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond, Multiplier: 1}
+	if transport, delivered := send(10); delivered || transport.Calls() != 2 {
+		t.Fatalf("HELD override with 2 attempts: expected to give up after 2, got delivered=%t after %d",
+			delivered, transport.Calls())
+	}
+
+	// El outbox descarta el REPLY cuando su política se agota
+	node1.replies.Policy = RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond, Multiplier: 1}
+	transport := &flakyTransport{Inner: node1.transport, Failures: 1000}
+	node1.transport = transport
+	node1.replies.Add("node2", Message{Type: "REPLY", NodeID: "node1"})
+	deadline := time.Now().Add(time.Second)
+	for node1.replies.Stats().Dropped == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the outbox never gave up on an undeliverable REPLY")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	node1.transport = transport.Inner
+	if stats := node1.replies.Stats(); stats.Depth != 0 {
+		t.Fatalf("expected an empty outbox after dropping the REPLY, got depth %d", stats.Depth)
+	}
+}
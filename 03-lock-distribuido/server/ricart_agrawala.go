@@ -2,10 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -34,9 +39,46 @@ func (s NodeState) String() string {
 
 // Mensaje intercambiado entre nodos
 type Message struct {
-	Type      string `json:"type"`       // "REQUEST" o "REPLY"
+	Type      string `json:"type"` // "REQUEST" o "REPLY"
 	Timestamp int64  `json:"timestamp"`
 	NodeID    string `json:"node_id"`
+	// Versión de la membresía del emisor, para detectar vistas divergentes
+	MembershipVersion int64 `json:"membership_version"`
+	// Secuencia monótona por emisor, para descartar entregas duplicadas
+	Seq uint64 `json:"seq"`
+	// Ronda de la petición: la del emisor en un REQUEST, la que se responde en un REPLY
+	Round int64 `json:"round"`
+	// Prioridad de un REQUEST (PriorityNormal si no se indica)
+	Priority int `json:"priority,omitempty"`
+	// Reloj vectorial del emisor; solo se envía con CLOCK_MODE=vector
+	Vector map[string]int64 `json:"vector,omitempty"`
+	// Encarnación del emisor, para descartar los mensajes de antes de un reinicio
+	Incarnation int64 `json:"incarnation,omitempty"`
+}
+
+// ErrInvalidMessage indica un mensaje interno mal formado o de tipo desconocido
+var ErrInvalidMessage = errors.New("invalid message")
+
+// validate comprueba que el mensaje tenga los campos mínimos del protocolo
+func (m Message) validate() error {
+	if m.NodeID == "" {
+		return fmt.Errorf("%w: missing node_id", ErrInvalidMessage)
+	}
+	switch m.Type {
+	case "REQUEST", "REPLY", "RELEASE", "HELD", "RECOVER":
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidMessage, m.Type)
+	}
+}
+
+// MessageResponse es el cuerpo de la respuesta HTTP a un mensaje interno.
+// Si el nodo concede el REQUEST en el acto, el REPLY viaja en la propia
+// respuesta; si lo pospone, Deferred es true y el REPLY llegará más tarde
+// como un mensaje normal.
+type MessageResponse struct {
+	Reply    *Message `json:"reply,omitempty"`
+	Deferred bool     `json:"deferred,omitempty"`
 }
 
 // Node representa un proceso en el algoritmo de Ricart-Agrawala
@@ -53,77 +95,361 @@ type Node struct {
 	mu sync.Mutex
 
 	// Canal para notificar cuando se obtiene el acceso a la CS
-	csGranted chan bool
+	csGranted chan int64
+	// Token de la petición en curso; cada concesión lo lleva por csGranted
+	// para que una señal antigua no satisfaga una petición posterior
+	csToken int64
+	// Momento en que se entró en la CS actual
+	heldSince time.Time
+	// Momento en que se pidió la CS actual
+	requestedAt time.Time
+	// Recurso para el que se pidió la CS actual (WithCSResource)
+	csResource string
+	// Peers que entran antes que nosotros durante la espera actual y
+	// métricas de equidad
+	bypassedBy map[string]bool
+	fairness   *FairnessTracker
+	// Tiempo máximo en la CS antes de que el watchdog la libere a la
+	// fuerza (0 = sin límite), su temporizador y los tokens de las
+	// estancias ya reclamadas cuyo titular aún no ha llamado a ReleaseCSToken
+	MaxHold   time.Duration
+	holdTimer *time.Timer
+	reclaimed map[int64]bool
+	// Cada cuánto se anuncia a los peers que se está en la CS (0 = nunca) y
+	// las violaciones de la exclusión mutua detectadas con esos anuncios
+	HeldAnnounceInterval time.Duration
+	splitBrain           *SplitBrainLog
+	// Última secuencia recibida de cada peer al entrar en la CS actual
+	heldSeqs map[string]uint64
+	// Últimos mensajes enviados y recibidos (nil = sin traza)
+	trace *TraceRecorder
+	// Eventos del protocolo para /internal/events
+	events *EventHub
+	// Pausa de /admin/pause: mensajes retenidos hasta /admin/resume
+	pause *PauseGate
+	// Si un nodo que no está en Peers envía mensajes, incorporarlo a la
+	// membresía en lugar de rechazarlos, con la URL que dé autoRegisterURL
+	AutoRegisterPeers bool
+	autoRegisterURL   func(peerID string) string
+
+	// Cola FIFO de peticiones locales que esperan para ejecutar el protocolo
+	localMu    sync.Mutex
+	localBusy  bool
+	localQueue []localTurn
+
+	// Algoritmo de exclusión mutua (ALGORITHM); en lamport-queue, la cola
+	// de peticiones y las colas de salida ordenadas por peer
+	Algorithm string
+	queue     []queuedRequest
+	outbox    map[string]chan func()
+	outboxMu  sync.Mutex
+	// REPLY pospuestos pendientes de entrega (Ricart-Agrawala)
+	replies *ReplyOutbox
+
+	// Contadores de mensajes y entradas en la CS
+	stats *MessageStats
+	// Reglas de fallos inyectados desde /internal/faults
+	faults *FaultInjector
+	// Firma de los mensajes entre nodos (nil si no hay CLUSTER_SECRET)
+	signer *MessageSigner
+	// ADMIN_TOKEN, con el que se anuncia la salida si el clúster no firma
+	adminToken string
+	// Transporte por el que se envían los mensajes (HTTP por defecto)
+	transport Transport
+
+	// Bloqueo replicado (solo con ALGORITHM=raft) y ronda de la petición actual
+	raft      *Raft
+	raftRound int64
+
+	// Reloj vectorial (nil en modo lamport) y vector de la petición en curso
+	VClock        *VectorClock
+	RequestVector map[string]int64
+
+	// Detector de fallos opcional; los peers sospechosos no bloquean la CS
+	detector *FailureDetector
+	// Tabla de miembros por gossip (nil = GOSSIP_INTERVAL_MS=0); si la hay,
+	// las URLs de los peers y el detector de fallos salen de ella
+	gossip *Gossip
+	// Peers excluidos de la petición en curso por estar caídos
+	excluded map[string]bool
+	// Último mensaje intercambiado con cada peer (recibido o entregado)
+	lastContact map[string]time.Time
+
+	// Versión de la membresía; cambia con cada join/leave
+	membershipVersion int64
+	// URLs base de los peers (de PEER_URLS o de un join dinámico)
+	peerURLs map[string]string
+	urlsMu   sync.RWMutex
+	// URLs del listener interno con mTLS (PEER_INTERNAL_URLS o join); sin
+	// entrada, el tráfico interno va a la URL base
+	internalURLs map[string]string
+
+	// Ronda de la petición a la CS actual; los REPLY de otras rondas se descartan
+	round int64
+	// Última ronda pedida por cada peer, para etiquetar nuestros REPLY
+	peerRounds map[string]int64
+	// Timestamp del último REQUEST de cada peer, para reconocer los REQUEST
+	// de una petición ya cancelada que llegan tarde
+	peerRequestTimes map[string]int64
+	// Prioridad del último REQUEST de cada peer y de la petición propia en
+	// curso; PriorityAging son los ticks que vale un nivel de prioridad
+	peerPriorities  map[string]int
+	RequestPriority int
+	PriorityAging   int64
+	// Peers a los que la petición en curso cedió el paso por prioridad y
+	// timestamp de su REQUEST: sus REPLY anteriores ya no valen
+	yieldedTo map[string]int64
+	// Permisos implícitos (IMPLICIT_GRANTS): peers que nos enviaron un REPLY
+	// y a los que aún no hemos respondido; no hace falta pedirles la CS
+	ImplicitGrants bool
+	hasGrant       map[string]bool
+	// Secuencia de envío de este nodo y secuencias recibidas por peer
+	sendSeq uint64
+	lastSeq map[string]*peerSeqs
+	// Último REPLY entregado en una respuesta HTTP a cada peer, por si el
+	// peer reintenta el REQUEST porque la respuesta original se perdió
+	piggybacked map[string]piggybackedReply
+	// Avisa al guardado del snapshot de que el estado o los REPLY debidos
+	// cambiaron; owedOnStart son los REPLY que se debían al caer
+	stateChanged chan struct{}
+	owedOnStart  []OwedReply
+	// Encarnación de este nodo y la última conocida de cada peer
+	incarnation      int64
+	peerIncarnations map[string]int64
+
+	// Cliente HTTP compartido por todos los envíos, con conexiones reutilizables.
+	// SendTimeout se aplica a cada intento; los reintentos siguen Retry, o la
+	// política de RetryOverrides para ese tipo de mensaje. Cuando la política
+	// se agota el peer cuenta como fallido para el detector de fallos.
+	client         *http.Client
+	SendTimeout    time.Duration
+	Retry          RetryPolicy
+	RetryOverrides map[string]RetryPolicy
 }
 
-// NewNode crea un nuevo nodo para el algoritmo
-func NewNode(id string, peers []string) *Node {
+// newPeerClient crea el cliente HTTP para los mensajes entre nodos. Cada nodo
+// habla con pocos peers y con mucha frecuencia, así que se mantienen varias
+// conexiones abiertas por peer en lugar de abrir una nueva por mensaje.
+func newPeerClient() *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   2 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// piggybackedReply asocia un REPLY con la secuencia del REQUEST que respondió
+type piggybackedReply struct {
+	requestSeq uint64
+	reply      Message
+}
+
+// NewNode crea un nuevo nodo para el algoritmo. peerURLs asocia cada ID de
+// peer con su URL base; se copia para que el llamador no pueda modificarlo.
+func NewNode(id string, peers []string, peerURLs map[string]string) *Node {
 	// Simplificar: aceptar la lista de peers tal cual; el filtrado de self
 	// se hará en quien crea el nodo (main.go)
+	urls := make(map[string]string, len(peerURLs))
+	for peerID, url := range peerURLs {
+		urls[peerID] = url
+	}
+
 	n := &Node{
-		ID:              id,
-		Peers:           peers,
-		Clock:           NewLamportClock(),
-		State:           Released,
-		RepliesNeeded:   make(map[string]bool),
-		DeferredReplies: []string{},
-		csGranted:       make(chan bool, 1),
+		ID:               id,
+		Peers:            peers,
+		Clock:            NewLamportClock(),
+		State:            Released,
+		RepliesNeeded:    make(map[string]bool),
+		DeferredReplies:  []string{},
+		csGranted:        make(chan int64, 1),
+		excluded:         make(map[string]bool),
+		bypassedBy:       make(map[string]bool),
+		pause:            newPauseGate(defaultPauseQueueSize),
+		reclaimed:        make(map[int64]bool),
+		lastContact:      make(map[string]time.Time),
+		peerURLs:         urls,
+		peerRounds:       make(map[string]int64),
+		peerRequestTimes: make(map[string]int64),
+		peerPriorities:   make(map[string]int),
+		yieldedTo:        make(map[string]int64),
+		hasGrant:         make(map[string]bool),
+		PriorityAging:    defaultPriorityAging,
+		sendSeq:          initialSeq(),
+		lastSeq:          make(map[string]*peerSeqs),
+		piggybacked:      make(map[string]piggybackedReply),
+		stateChanged:     make(chan struct{}, 1),
+		incarnation:      initialIncarnation(),
+		peerIncarnations: make(map[string]int64),
+		Algorithm:        AlgorithmRicartAgrawala,
+		outbox:           make(map[string]chan func()),
+		stats:            newMessageStats(),
+		splitBrain:       &SplitBrainLog{},
+		fairness:         newFairnessTracker(),
+		faults:           newFaultInjector(),
+		client:           newPeerClient(),
+		SendTimeout:      2 * time.Second,
+		Retry:            defaultRetryPolicy(),
+		RetryOverrides:   make(map[string]RetryPolicy),
 	}
+	n.replies = newReplyOutbox(n)
+	n.transport = &HTTPTransport{node: n}
+	n.trace = NewTraceRecorder(defaultTraceSize)
+	n.events = newEventHub()
 	return n
 }
 
-// RequestCS intenta obtener acceso a la sección crítica
+// logf escribe una línea de log del algoritmo precedida del ID del nodo y de
+// su reloj de Lamport, "[server1] [ts=12] ...", para poder ordenar los
+// eventos de varios nodos sin relojes de pared
+func (n *Node) logf(format string, args ...interface{}) {
+	log.Printf("[%s] [ts=%d] "+format, append([]interface{}{n.ID, n.Clock.GetTime()}, args...)...)
+}
+
+// RequestCS intenta obtener acceso a la sección crítica y bloquea hasta
+// conseguirlo
 func (n *Node) RequestCS() {
+	n.RequestCSContext(context.Background())
+}
+
+// requestCS ejecuta el protocolo para la petición local admitida: anuncia el
+// REQUEST y espera la concesión o a que se cancele ctx
+func (n *Node) requestCS(ctx context.Context) error {
 	n.mu.Lock()
-	n.State = Wanted
+	n.setState(Wanted)
+	n.requestedAt = time.Now()
+	n.bypassedBy = make(map[string]bool)
 	n.RequestTime = n.Clock.Increment()
+	n.RequestPriority = csPriority(ctx)
+	if n.VClock != nil {
+		n.RequestVector = n.VClock.Increment()
+	}
+	n.round++
+	n.csToken++
+	token := n.csToken
 	// ----> INICIO DEL CAMBIO <----
 	// Limpiar el mapa de respuestas necesarias para asegurar un estado fresco
 	n.RepliesNeeded = make(map[string]bool)
-	// Necesitamos respuesta de todos los peers
+	n.excluded = make(map[string]bool)
+	n.yieldedTo = make(map[string]int64)
+	// Necesitamos respuesta de todos los peers que no estén caídos
+	var targets []string
 	for _, peer := range n.Peers {
 		// La lista n.Peers ya viene filtrada desde main.go, no contiene n.ID
+		if n.isSuspect(peer) {
+			n.logf("Skipping suspect peer %s for this request", peer)
+			n.excluded[peer] = true
+			continue
+		}
+		if n.hasGrant[peer] {
+			n.logf("Keeping implicit grant from %s, not asking it again", peer)
+			continue
+		}
 		n.RepliesNeeded[peer] = true
+		targets = append(targets, peer)
 	}
 	// ----> FIN DEL CAMBIO <----
+	if n.lamportQueue() {
+		n.enqueueRequest(queuedRequest{Timestamp: n.RequestTime, NodeID: n.ID})
+	}
+	msg := n.currentRequest()
 	n.mu.Unlock()
 
-	// Si no hay otros peers, entramos directamente
-	if len(n.Peers) == 0 {
+	if len(targets) == 0 {
+		// Si no hay otros peers vivos, entramos directamente
 		n.enterCS()
-		return
+	} else {
+		// Enviar REQUEST a todos los demás nodos
+		n.broadcast(targets, msg)
 	}
 
-	// Enviar REQUEST a todos los demás nodos
-	msg := Message{
-		Type:      "REQUEST",
-		Timestamp: n.RequestTime,
-		NodeID:    n.ID,
+	// Esperar a que se conceda el acceso
+	return n.awaitGrant(ctx, token)
+}
+
+// awaitGrant espera la concesión de la petición con el token indicado. Si
+// ctx se cancela antes, retira la petición; si la CS se concedió justo a la
+// vez, la acepta igualmente.
+func (n *Node) awaitGrant(ctx context.Context, token int64) error {
+	for {
+		select {
+		case granted := <-n.csGranted:
+			if n.isCurrentGrant(granted, token) {
+				return nil
+			}
+		case <-ctx.Done():
+			if n.CancelCSRequest() {
+				return ctx.Err()
+			}
+			// La CS se concedió a la vez que se cancelaba: su señal ya
+			// está en camino
+			for {
+				if n.isCurrentGrant(<-n.csGranted, token) {
+					return nil
+				}
+			}
+		}
 	}
-	n.broadcast(msg)
+}
 
-	// Esperar a que se conceda el acceso
-	<-n.csGranted
+// isCurrentGrant indica si una señal de csGranted corresponde a la petición
+// con el token indicado; las demás son restos de peticiones anteriores
+func (n *Node) isCurrentGrant(granted, token int64) bool {
+	if granted != token {
+		n.logf("Discarding stale CS grant (token %d, waiting for %d)", granted, token)
+		return false
+	}
+	return true
 }
 
 // ReleaseCS libera la sección crítica
 // ReleaseCS libera la sección crítica
 func (n *Node) ReleaseCS() {
 	n.mu.Lock()
-	n.State = Released
-	
-	log.Printf("[%s] Releasing critical section, sending %d deferred replies", 
-		n.ID, len(n.DeferredReplies))
-	
-	// Enviar todos los replies que habíamos pospuesto
+	n.releaseLocked()
+	n.mu.Unlock()
+
+	n.logf("Released critical section")
+	n.leaveLocal()
+}
+
+// releaseLocked sale de la CS y envía los REPLY pospuestos, sin tocar la
+// cola de admisión local. ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) releaseLocked() {
+	n.stopHoldWatchdog()
+	if n.State == Held {
+		n.stats.recordHeld(time.Since(n.heldSince))
+		n.publishEvent(ProtocolEvent{Kind: eventCSExit})
+	}
+	n.setState(Released)
+	if n.lamportQueue() {
+		n.releaseLamport()
+	}
+	if n.raftMode() {
+		go n.releaseRaft(n.raftRound)
+	}
+
+	n.logf("Releasing critical section, sending %d deferred replies",
+		len(n.DeferredReplies))
+
+	// Enviar todos los replies que habíamos pospuesto, empezando por el de
+	// la petición que va antes; el outbox los reintenta hasta entregarlos
+	if n.VClock == nil {
+		n.sortDeferred()
+	}
 	for _, nodeID := range n.DeferredReplies {
-		log.Printf("[%s] Sending deferred reply to %s", n.ID, nodeID)
-		n.sendReply(nodeID)
+		n.logf("Sending deferred reply to %s", nodeID)
+		n.replies.Add(nodeID, n.newReply(nodeID))
+	}
+	if len(n.DeferredReplies) > 0 {
+		n.publishEvent(ProtocolEvent{Kind: eventDeferredFlush, Peers: n.DeferredReplies})
 	}
 	n.DeferredReplies = []string{}
-	n.mu.Unlock()
-
-	log.Printf("[%s] Released critical section", n.ID)
 }
 
 // enterCS es llamado cuando el nodo obtiene acceso a la CS
@@ -138,53 +464,235 @@ func (n *Node) enterCS() {
 // ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
 func (n *Node) _enterCS() {
 	if n.State == Wanted {
-		log.Printf("[%s] Entering critical section", n.ID)
-		n.State = Held
-		n.csGranted <- true
+		n.logf("Entering critical section")
+		n.setState(Held)
+		n.publishEvent(ProtocolEvent{Kind: eventCSEnter})
+		n.heldSince = time.Now()
+		n.stats.recordEntry(time.Since(n.requestedAt))
+		n.fairness.record(time.Since(n.requestedAt), len(n.bypassedBy))
+		n.armHoldWatchdog()
+		n.watchSplitBrain()
+		// Descartar una señal antigua que nadie recogió. Todos los envíos
+		// se hacen con el mutex tomado, así que tras vaciarlo hay hueco; aun
+		// así el envío no bloquea nunca: bloquear aquí, con el mutex tomado,
+		// dejaría el nodo colgado
+		select {
+		case stale := <-n.csGranted:
+			n.logf("Drained stale CS grant (token %d)", stale)
+		default:
+		}
+		select {
+		case n.csGranted <- n.csToken:
+		default:
+			n.logf("CS grant channel unexpectedly full, dropping grant (token %d)", n.csToken)
+		}
+	}
+}
+
+// HeldTimestamp devuelve el timestamp de Lamport con el que se obtuvo la CS.
+// El segundo valor es false si el nodo no está en la sección crítica.
+func (n *Node) HeldTimestamp() (int64, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.RequestTime, n.State == Held
+}
+
+// handleMessage procesa los mensajes entrantes (REQUEST/REPLY). Para un
+// REQUEST concedido de inmediato devuelve el REPLY, que el llamador entrega
+// en la respuesta HTTP; en cualquier otro caso devuelve nil. Los mensajes mal
+// formados devuelven un error que envuelve ErrInvalidMessage.
+func (n *Node) handleMessage(msg Message) (*Message, error) {
+	if err := msg.validate(); err != nil {
+		return nil, err
+	}
+
+	// Fallos inyectados en la recepción, antes de tomar ningún mutex
+	if n.injectFault(faultInbound, msg.NodeID, msg.Type) {
+		return nil, ErrFaultInjected
+	}
+
+	// En pausa el mensaje se encola y se procesa al reanudar
+	if queued, err := n.pause.hold(msg); queued || err != nil {
+		return nil, err
 	}
+	return n.processMessage(msg)
 }
 
-// handleMessage procesa los mensajes entrantes (REQUEST/REPLY)
-func (n *Node) handleMessage(msg Message) {
+// processMessage procesa un mensaje ya validado
+func (n *Node) processMessage(msg Message) (*Message, error) {
+	// Un nodo que no es miembro no debe mover nuestro reloj ni nuestro estado
+	if err := n.admitSender(msg); err != nil {
+		return nil, err
+	}
+
+	// Un mensaje de antes de que el emisor se reiniciara llega tarde: la
+	// petición a la que pertenece ya no existe
+	if n.observeIncarnation(msg) {
+		n.logf("Dropping %s from %s: sent by a previous incarnation (%d)", msg.Type, msg.NodeID, msg.Incarnation)
+		n.traceMessage(traceReceived, msg.NodeID, msg, traceStale)
+		return nil, nil
+	}
+
+	// Descartar reintentos de mensajes que ya habíamos procesado
+	if n.isDuplicate(msg) {
+		n.logf("Dropping duplicate %s from %s (seq %d)", msg.Type, msg.NodeID, msg.Seq)
+		n.traceMessage(traceReceived, msg.NodeID, msg, traceDuplicate)
+		return n.repeatPiggybackedReply(msg), nil
+	}
+
+	// Los anuncios HELD solo sirven para detectar un split-brain: no
+	// participan en el algoritmo ni mueven el reloj
+	if msg.Type == "HELD" {
+		n.observeHeld(msg)
+		return nil, nil
+	}
+
 	// Actualizar el reloj de Lamport al recibir cualquier mensaje
-	n.Clock.Witness(msg.Timestamp)
+	n.Clock.WitnessFrom(msg.NodeID, msg.Timestamp)
+	if n.VClock != nil {
+		if msg.Vector == nil {
+			n.logf("WARNING: %s from %s carries no vector clock (is CLOCK_MODE the same on every node?)",
+				msg.Type, msg.NodeID)
+		}
+		n.VClock.Merge(msg.Vector)
+	}
+
+	n.checkMembershipVersion(msg)
+	n.touchPeer(msg.NodeID)
 
-	log.Printf("[%s] Received %s message from %s (timestamp: %d)", 
-		n.ID, msg.Type, msg.NodeID, msg.Timestamp)
+	// Cualquier mensaje recibido demuestra que el emisor está vivo
+	if n.detector != nil {
+		n.detector.RecordSuccess(msg.NodeID)
+	}
+
+	n.logf("Received %s message from %s (timestamp: %d)",
+		msg.Type, msg.NodeID, msg.Timestamp)
+	n.stats.recordReceived(msg.NodeID, msg.Type)
+
+	// observeIncarnation ya limpió lo que quedaba de la encarnación anterior
+	if msg.Type == "RECOVER" {
+		n.traceMessage(traceReceived, msg.NodeID, msg, traceProcessed)
+		n.logf("Peer %s is running incarnation %d", msg.NodeID, msg.Incarnation)
+		return nil, nil
+	}
+
+	if n.lamportQueue() {
+		n.traceMessage(traceReceived, msg.NodeID, msg, traceProcessed)
+		n.handleLamportMessage(msg)
+		return nil, nil
+	}
 
 	switch msg.Type {
 	case "REQUEST":
-		n.handleRequest(msg)
+		reply := n.handleRequest(msg)
+		if reply != nil {
+			n.stats.recordSent(msg.NodeID, reply.Type)
+			n.traceMessage(traceSent, msg.NodeID, *reply, tracePiggybacked)
+		}
+		return reply, nil
 	case "REPLY":
+		n.traceMessage(traceReceived, msg.NodeID, msg, traceProcessed)
 		n.handleReply(msg)
+	default:
+		n.logf("Ignoring %s from %s: not used by %s (is ALGORITHM the same on every node?)",
+			msg.Type, msg.NodeID, n.Algorithm)
 	}
+	return nil, nil
 }
 
-// handleRequest gestiona una petición de acceso a la CS
-func (n *Node) handleRequest(msg Message) {
+// handleRequest gestiona una petición de acceso a la CS. Si se concede en el
+// acto devuelve el REPLY para enviarlo en la respuesta HTTP.
+func (n *Node) handleRequest(msg Message) *Message {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
 	// Actualizar el reloj de Lamport con el timestamp del mensaje
 	n.Clock.Witness(msg.Timestamp)
 
-	// La decisión de responder se basa en el estado y el timestamp
+	// Los envíos salen en goroutines independientes, así que el REQUEST de
+	// una petición que el peer ya canceló puede llegar después del de la
+	// siguiente. Responderlo sustituiría la ronda de la petición vigente y
+	// el peer descartaría nuestro REPLY. Un peer que se reinicia tiene una
+	// ronda menor, pero su reloj restaurado da timestamps mayores.
+	if known, ok := n.peerRounds[msg.NodeID]; ok && msg.Round < known &&
+		msg.Timestamp < n.peerRequestTimes[msg.NodeID] {
+		n.logf("Dropping stale REQUEST from %s (round %d, current round %d)",
+			msg.NodeID, msg.Round, known)
+		n.traceMessage(traceReceived, msg.NodeID, msg, traceStale)
+		return nil
+	}
+
+	// La decisión de responder se basa en el estado, la prioridad y el timestamp
 	shouldReply := n.State == Released ||
-		(n.State == Wanted && (msg.Timestamp < n.RequestTime || 
-			(msg.Timestamp == n.RequestTime && msg.NodeID < n.ID)))
+		(n.State == Wanted && n.peerHasPriority(msg))
+
+	n.logf("Received REQUEST from %s (ts:%d priority:%d vs my ts:%d priority:%d, state:%s)",
+		msg.NodeID, msg.Timestamp, msg.Priority, n.RequestTime, n.RequestPriority, n.State)
 
-	log.Printf("[%s] Received REQUEST from %s (ts:%d vs my:%d, state:%s)", 
-		n.ID, msg.NodeID, msg.Timestamp, n.RequestTime, n.State)
+	// Recordar la ronda para etiquetar el REPLY (inmediato o diferido)
+	n.peerRounds[msg.NodeID] = msg.Round
+	n.peerRequestTimes[msg.NodeID] = msg.Timestamp
+	n.peerPriorities[msg.NodeID] = msg.Priority
 
 	if shouldReply {
-		log.Printf("[%s] Sending reply to %s", n.ID, msg.NodeID)
-		n.sendReply(msg.NodeID)
+		// Si esperábamos, el peer tiene prioridad y entrará antes
+		n.noteBypass(msg.NodeID)
+		if n.State == Wanted && n.VClock == nil && msg.Timestamp > n.RequestTime {
+			n.yieldTo(msg)
+		} else if n.State == Wanted && n.hasGrant[msg.NodeID] {
+			n.regainGrant(msg)
+		}
+		n.logf("Replying to %s in the HTTP response", msg.NodeID)
+		n.traceMessage(traceReceived, msg.NodeID, msg, traceReplied)
+		reply := n.newReply(msg.NodeID)
+		n.piggybacked[msg.NodeID] = piggybackedReply{requestSeq: msg.Seq, reply: reply}
+		return &reply
+	} else if n.hasDeferred(msg.NodeID) {
+		n.logf("Reply to %s already deferred", msg.NodeID)
+		n.traceMessage(traceReceived, msg.NodeID, msg, traceDeferred)
 	} else {
 		// Posponer la respuesta - usar NodeID directamente
-		log.Printf("[%s] Deferring reply to %s (reason: state=%s, ts_cmp=%t, id_cmp=%t)",
-			n.ID, msg.NodeID, n.State, msg.Timestamp < n.RequestTime, msg.NodeID < n.ID)
+		n.logf("Deferring reply to %s (reason: state=%s, ts_cmp=%t, id_cmp=%t, vector: %v vs my %v)",
+			msg.NodeID, n.State, msg.Timestamp < n.RequestTime, msg.NodeID < n.ID, msg.Vector, n.RequestVector)
 		n.DeferredReplies = append(n.DeferredReplies, msg.NodeID)
+		n.stats.recordDeferred(len(n.DeferredReplies))
+		n.markStateChanged()
+		n.traceMessage(traceReceived, msg.NodeID, msg, traceDeferred)
+		n.publishEvent(ProtocolEvent{Kind: eventDeferredAdd, Peer: msg.NodeID})
 	}
+	return nil
+}
+
+// peerHasPriority indica si el REQUEST recibido precede a nuestra petición en
+// curso. En modo lamport se compara (prioridad envejecida, timestamp, ID); en
+// modo vector, la relación causal entre los vectores de ambas peticiones.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) peerHasPriority(msg Message) bool {
+	if n.VClock != nil {
+		return vectorPrecedes(msg.Vector, msg.NodeID, n.RequestVector, n.ID)
+	}
+	peer := csRequest{Priority: msg.Priority, Timestamp: msg.Timestamp, NodeID: msg.NodeID}
+	own := csRequest{Priority: n.RequestPriority, Timestamp: n.RequestTime, NodeID: n.ID}
+	return peer.precedes(own, n.PriorityAging)
+}
+
+// repeatPiggybackedReply devuelve de nuevo el REPLY que ya se entregó para un
+// REQUEST duplicado. El emisor descarta el REPLY repetido si el primero sí le
+// llegó, gracias a que conserva su número de secuencia.
+func (n *Node) repeatPiggybackedReply(msg Message) *Message {
+	if msg.Type != "REQUEST" {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	cached, ok := n.piggybacked[msg.NodeID]
+	if !ok || cached.requestSeq != msg.Seq {
+		return nil
+	}
+	reply := cached.reply
+	return &reply
 }
 
 // handleReply gestiona una respuesta a nuestra petición
@@ -192,10 +700,22 @@ func (n *Node) handleReply(msg Message) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
+	if n.isStaleReply(msg) {
+		return
+	}
+	if requestTS, ok := n.yieldedTo[msg.NodeID]; ok && msg.Timestamp < requestTS {
+		n.logf("Ignoring reply from %s sent before its higher-priority request (ts %d < %d)",
+			msg.NodeID, msg.Timestamp, requestTS)
+		return
+	}
+
 	if n.State == Wanted {
 		// Usar el NodeID del mensaje para eliminar de RepliesNeeded
 		delete(n.RepliesNeeded, msg.NodeID)
-		log.Printf("[%s] Got reply from %s. Needed: %d", n.ID, msg.NodeID, len(n.RepliesNeeded))
+		if n.ImplicitGrants {
+			n.hasGrant[msg.NodeID] = true
+		}
+		n.logf("Got reply from %s. Needed: %d", msg.NodeID, len(n.RepliesNeeded))
 
 		// Si ya tenemos todas las respuestas, podemos entrar a la CS
 		if len(n.RepliesNeeded) == 0 {
@@ -205,92 +725,333 @@ func (n *Node) handleReply(msg Message) {
 	}
 }
 
-// broadcast envía un mensaje a todos los peers
-func (n *Node) broadcast(msg Message) {
-	for _, peerURL := range n.Peers {
+// broadcast envía un mensaje a la lista de peers indicada
+func (n *Node) broadcast(peers []string, msg Message) {
+	for _, peerURL := range peers {
 		if peerURL != n.ID { // No nos enviamos a nosotros mismos
-			go n.sendMessage(peerURL, msg)
+			n.dispatch(peerURL, msg)
 		}
 	}
 }
 
-// sendReply envía una respuesta a un nodo específico
-func (n *Node) sendReply(peerID string) {
-	reply := Message{
-		Type:      "REPLY",
-		Timestamp: n.Clock.Increment(),
+// hasDeferred indica si ya hay un REPLY pospuesto para el peer.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) hasDeferred(peerID string) bool {
+	for _, id := range n.DeferredReplies {
+		if id == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+// newReply construye un REPLY para el peer, ya sellado con secuencia y
+// versión de membresía. Con él cedemos el permiso implícito del peer.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) newReply(peerID string) Message {
+	delete(n.hasGrant, peerID)
+	return Message{
+		Type:              "REPLY",
+		Timestamp:         n.Clock.Increment(),
+		NodeID:            n.ID,
+		Round:             n.peerRounds[peerID],
+		MembershipVersion: n.membershipVersion,
+		Seq:               n.nextSeq(),
+		Vector:            n.tickVector(),
+		Incarnation:       n.incarnation,
+	}
+}
+
+// currentRequest construye el REQUEST de la petición en curso. Es el mismo
+// mensaje al anunciarla y al repetirlo a un peer (al cederle el paso, al
+// recuperar su permiso implícito o cuando vuelve o se reinicia), para que el
+// peer la compare siempre con la misma prioridad y el mismo vector.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) currentRequest() Message {
+	return Message{
+		Type:      "REQUEST",
+		Timestamp: n.RequestTime,
 		NodeID:    n.ID,
+		Round:     n.round,
+		Priority:  n.RequestPriority,
+		Vector:    n.RequestVector,
 	}
-	go n.sendMessage(peerID, reply)
-	log.Printf("[%s] Sent reply to %s", n.ID, peerID)
 }
 
-// sendMessage envía un mensaje a un peer
-func (n *Node) sendMessage(peerID string, msg Message) {
+// sendReply envía una respuesta a un nodo específico.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) sendReply(peerID string) {
+	reply := n.newReply(peerID)
+	n.dispatch(peerID, reply)
+	n.logf("Sent reply to %s", peerID)
+}
+
+// sendMessage envía un mensaje a un peer. Devuelve false si el peer no
+// respondió tras todos los reintentos; el mensaje puede reenviarse más tarde
+// tal cual, con la misma secuencia, sin riesgo de que se procese dos veces.
+func (n *Node) sendMessage(peerID string, msg Message) bool {
 	// No enviamos mensajes a nosotros mismos
 	if peerID == n.ID {
-		return
+		return true
 	}
 
+	// En pausa los envíos esperan a que el nodo se reanude
+	n.pause.wait()
+
+	n.stats.recordSent(peerID, msg.Type)
+
+	// Fallos inyectados en el envío: un mensaje descartado se pierde sin
+	// reintentos, y una partición cuenta además como fallo del peer
+	if n.injectFault(faultOutbound, peerID, msg.Type) {
+		n.traceMessage(traceSent, peerID, msg, traceDropped)
+		if n.faults.partitioned(peerID) {
+			if n.detector != nil {
+				n.detector.RecordFailure(peerID)
+			}
+			return false
+		}
+		return true
+	}
+
+	// Una sola secuencia por mensaje: los reintentos reenvían los mismos bytes
+	if msg.Seq == 0 {
+		msg.MembershipVersion = n.MembershipVersion()
+		msg.Seq = n.nextSeq()
+	}
+	if msg.Incarnation == 0 {
+		msg.Incarnation = n.Incarnation()
+	}
 	jsonData, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("[%s] Error marshalling message: %v", n.ID, err)
-		return
+		n.logf("Error marshalling message: %v", err)
+		n.traceMessage(traceSent, peerID, msg, traceFailed)
+		return true
 	}
 
-	// Obtener la URL del peer usando la función findPeerURL
-	url := n.findPeerURL(peerID)
-
-	// Lógica de reintentos con backoff exponencial
-	maxRetries := 3
-	retryDelay := 100 * time.Millisecond
+	// Reintentos con backoff exponencial según la política del tipo de mensaje
+	policy := n.retryPolicy(msg.Type)
+	firstAttempt := time.Now()
+	attempts := 0
 
-	for i := 0; i < maxRetries; i++ {
-		client := http.Client{Timeout: 2 * time.Second}
-		resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	for {
+		attempts++
+		start := time.Now()
+		status, body, err := n.transport.Send(peerID, msg.Seq, jsonData)
+		if errors.Is(err, errNoRoute) {
+			// Sin dirección del peer reintentar no sirve
+			n.logf("Cannot send %s to %s: %v", msg.Type, peerID, err)
+			n.traceMessage(traceSent, peerID, msg, traceFailed)
+			return true
+		}
 		if err == nil {
-			if resp != nil {
-				resp.Body.Close()
+			n.stats.recordLatency(time.Since(start))
+			n.touchPeer(peerID)
+
+			if status == http.StatusOK {
+				// Registrar el envío antes que el REPLY que viene en la
+				// respuesta, para que la traza respete el orden causal
+				n.traceMessage(traceSent, peerID, msg, traceDelivered)
+				if body.Deferred && msg.Type == "REQUEST" {
+					n.noteDeferredRequest(peerID, msg.Round)
+				}
+				// REPLY concedido en la misma respuesta: procesarlo ya
+				if body.Reply != nil {
+					if _, err := n.handleMessage(*body.Reply); err != nil {
+						n.logf("Invalid piggybacked reply from %s: %v", peerID, err)
+					}
+				}
+				return true
 			}
-			if err == nil && resp.StatusCode == http.StatusOK {
-				return
+
+			// Un 4xx significa que el peer rechaza el mensaje: reintentar no sirve
+			if status >= 400 && status < 500 {
+				n.logf("Peer %s rejected %s with status %d, not retrying", peerID, msg.Type, status)
+				n.traceMessage(traceSent, peerID, msg, traceRejected)
+				return true
 			}
+			err = fmt.Errorf("unexpected status %d", status)
 		}
 
-		log.Printf("[%s] Failed to send message to %s (attempt %d/%d): %v", n.ID, peerID, i+1, maxRetries, err)
-		time.Sleep(retryDelay)
-		retryDelay *= 2
+		attemptsLabel := strconv.Itoa(attempts)
+		if policy.MaxAttempts > 0 {
+			attemptsLabel += "/" + strconv.Itoa(policy.MaxAttempts)
+		}
+		n.logf("Failed to send message to %s (attempt %s): %v", peerID, attemptsLabel, err)
+		delay, stop := policy.Next(attempts, time.Since(firstAttempt))
+		if stop != nil {
+			// El detector de fallos decide si el peer está caído
+			n.logf("Giving up sending %s to %s after %d attempts (%v); reporting it to the failure detector",
+				msg.Type, peerID, attempts, stop)
+			break
+		}
+		time.Sleep(delay)
 	}
 
-	log.Printf("[%s] CRITICAL: Could not send message to %s after %d attempts.", n.ID, peerID, maxRetries)
+	n.traceMessage(traceSent, peerID, msg, traceFailed)
+	if n.detector != nil {
+		n.detector.RecordFailure(peerID)
+	}
+	return false
 }
 
-// findPeerURL encuentra la URL de un peer por su ID
-func (n *Node) findPeerURL(nodeID string) string {
-	// Mapear IDs de nodos a URLs de servicios Docker
-	switch nodeID {
-	case "server1":
-		return "http://server1:8081/internal/message"
-	case "server2":
-		return "http://server2:8082/internal/message"
-	case "server3":
-		return "http://server3:8083/internal/message"
-	default:
-		// Fallback para otros casos
-		return fmt.Sprintf("http://%s/internal/message", nodeID)
+// post envía un intento de un mensaje con el cliente compartido
+func (n *Node) post(url string, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.SendTimeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.signer.Enabled() {
+		req.Header.Set(signatureHeader, n.signer.Sign(body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// El timeout debe cubrir también la lectura del cuerpo
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose libera el contexto de la petición al cerrar el cuerpo. Antes
+// vacía lo que quede sin leer (p. ej. el salto de línea final del JSON): una
+// conexión solo vuelve al pool si su respuesta se leyó hasta el final.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	io.CopyN(io.Discard, c.ReadCloser, 4096)
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// findPeerURL encuentra la URL del endpoint de mensajes de un peer por su ID
+func (n *Node) findPeerURL(nodeID string) (string, error) {
+	base, err := n.internalBaseURL(nodeID)
+	if err != nil {
+		return "", err
+	}
+	return base + "/internal/message", nil
+}
+
+// peerBaseURL devuelve la URL base (esquema, host y puerto) de un peer: la
+// que anuncia por gossip o, si aún no la conocemos, la configurada
+func (n *Node) peerBaseURL(nodeID string) (string, error) {
+	if n.gossip != nil {
+		if url, _, ok := n.gossip.URL(nodeID); ok {
+			return url, nil
+		}
+	}
+
+	n.urlsMu.RLock()
+	defer n.urlsMu.RUnlock()
+
+	url, ok := n.peerURLs[nodeID]
+	if !ok {
+		return "", fmt.Errorf("no URL configured for peer %q (check PEER_URLS)", nodeID)
+	}
+	return url, nil
+}
+
+// isSuspect indica si el detector de fallos considera caído al peer
+func (n *Node) isSuspect(peerID string) bool {
+	return n.detector != nil && n.detector.IsSuspect(peerID)
+}
+
+// peerSuspected deja de esperar la respuesta de un peer caído. Si era la
+// última respuesta pendiente, el nodo entra en la sección crítica.
+func (n *Node) peerSuspected(peerID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.replies.Drop(peerID, "peer declared dead")
+
+	if n.lamportQueue() {
+		n.lamportPeerSuspected(peerID)
+		return
+	}
+	if n.raftMode() {
+		go n.raft.raftPeerSuspected(peerID)
+		return
+	}
+
+	if n.State != Wanted || !n.RepliesNeeded[peerID] {
+		return
 	}
+
+	delete(n.RepliesNeeded, peerID)
+	n.excluded[peerID] = true
+	n.logf("No longer waiting for suspect peer %s. Needed: %d", peerID, len(n.RepliesNeeded))
+
+	if len(n.RepliesNeeded) == 0 {
+		n._enterCS()
+	}
+}
+
+// peerRecovered vuelve a incluir a un peer que respondió de nuevo. Si la
+// petición en curso lo había excluido, se le envía el REQUEST original y se
+// espera su respuesta como a cualquier otro peer.
+func (n *Node) peerRecovered(peerID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.State != Wanted || !n.excluded[peerID] {
+		return
+	}
+
+	delete(n.excluded, peerID)
+	n.RepliesNeeded[peerID] = true
+	n.logf("Re-including recovered peer %s in current request. Needed: %d", peerID, len(n.RepliesNeeded))
+
+	n.dispatch(peerID, n.currentRequest())
 }
 
-// CancelCSRequest aborta un intento de entrar en la sección crítica (ej. por timeout)
-func (n *Node) CancelCSRequest() {
+// CancelCSRequest aborta un intento de entrar en la sección crítica (ej. por
+// timeout o porque el cliente se desconectó). Devuelve false si no había nada
+// que cancelar: en particular, si la CS ya se concedió, el llamador la tiene
+// y debe liberarla con ReleaseCS.
+func (n *Node) CancelCSRequest() bool {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
 	// Solo actuar si estábamos esperando para entrar
-	if n.State == Wanted {
-		log.Printf("[%s] Canceling CS request due to timeout.", n.ID)
-		n.State = Released
-		n.RepliesNeeded = make(map[string]bool)
-		// Nota: No se envían respuestas diferidas aquí porque nunca entramos en la CS.
+	if n.State != Wanted {
+		return false
+	}
+
+	n.logf("Canceling CS request (round %d)", n.round)
+	n.setState(Released)
+	// La petición no llegó a concederse: cualquier señal pendiente en
+	// csGranted es de una anterior y no debe quedar para la siguiente
+	select {
+	case stale := <-n.csGranted:
+		n.logf("Drained stale CS grant (token %d)", stale)
+	default:
+	}
+	n.RepliesNeeded = make(map[string]bool)
+	n.excluded = make(map[string]bool)
+	if n.lamportQueue() {
+		// Los peers tienen nuestra petición encolada: hay que retirarla
+		n.releaseLamport()
 	}
-}
\ No newline at end of file
+	if n.raftMode() {
+		// Retirarse de la cola replicada, o devolver el bloqueo si llega a
+		// concederse antes de que se aplique la retirada
+		go n.releaseRaft(n.raftRound)
+	}
+
+	// Los peers a los que pospusimos la respuesta mientras esperábamos
+	// tampoco pueden seguir esperándonos
+	for _, nodeID := range n.DeferredReplies {
+		n.replies.Add(nodeID, n.newReply(nodeID))
+	}
+	n.DeferredReplies = []string{}
+	return true
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runContention hace que cada nodo del clúster entre y salga de la CS rounds
+// veces a la vez que los demás y devuelve el primer error
+func runContention(c *SimCluster, rounds int) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(c.ids))
+	for _, id := range c.ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if err := c.Enter(id, 5*time.Second); err != nil {
+					errs <- err
+					return
+				}
+				// Quedarse un poco dentro para que los demás choquen con la CS ocupada
+				time.Sleep(time.Millisecond)
+				c.Exit(id)
+			}
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// clockModes son los modos de CLOCK_MODE; las pruebas de exclusión mutua
+// se ejecutan con todos para poder compararlos
+var clockModes = []string{ClockModeLamport, ClockModeVector}
+
+// newModeCluster crea un clúster simulado cuyos nodos ordenan las
+// peticiones con el modo de reloj indicado
+func newModeCluster(mode string, ids ...string) *SimCluster {
+	c := NewSimCluster(ids...)
+	if mode == ClockModeVector {
+		for _, id := range ids {
+			c.Node(id).UseVectorClock()
+		}
+	}
+	return c
+}
+
+func TestThreeNodeMutualExclusion(t *testing.T) {
+	for _, mode := range clockModes {
+		t.Run(mode, func(t *testing.T) {
+			c := newModeCluster(mode, "node1", "node2", "node3")
+			c.Network.Delay = func(from, to string, msg Message) time.Duration {
+				return time.Millisecond
+			}
+
+			if err := runContention(c, 10); err != nil {
+				t.Fatal(err)
+			}
+			if v := c.Violations(); v != 0 {
+				t.Fatalf("mutual exclusion violated %d times", v)
+			}
+			if holders := c.Holders(); len(holders) != 0 {
+				t.Fatalf("expected the CS to be free at the end, held by %v", holders)
+			}
+		})
+	}
+}
+
+func TestThreeNodeMutualExclusionWithDrops(t *testing.T) {
+	for _, mode := range clockModes {
+		t.Run(mode, func(t *testing.T) {
+			c := newModeCluster(mode, "node1", "node2", "node3")
+			c.Network.Delay = func(from, to string, msg Message) time.Duration {
+				return time.Millisecond
+			}
+			// Se pierde el primer intento de cada mensaje; el reintento lo entrega
+			var (
+				mu      sync.Mutex
+				seen    = make(map[string]bool)
+				dropped int
+			)
+			c.Network.Drop = func(from, to string, msg Message) bool {
+				key := fmt.Sprintf("%s>%s %s %d/%d", from, to, msg.Type, msg.Timestamp, msg.Round)
+				mu.Lock()
+				defer mu.Unlock()
+				if seen[key] {
+					return false
+				}
+				seen[key] = true
+				dropped++
+				return true
+			}
+
+			if err := runContention(c, 5); err != nil {
+				t.Fatal(err)
+			}
+			if v := c.Violations(); v != 0 {
+				t.Fatalf("mutual exclusion violated %d times", v)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if dropped == 0 {
+				t.Fatal("expected the network to drop some messages")
+			}
+		})
+	}
+}
+
+// Con retardos aleatorios los mensajes se cruzan y llegan desordenados entre
+// emisores: en modo vector abundan las peticiones concurrentes
+func TestMutualExclusionWithJitter(t *testing.T) {
+	for _, mode := range clockModes {
+		t.Run(mode, func(t *testing.T) {
+			c := newModeCluster(mode, "node1", "node2", "node3", "node4")
+			var mu sync.Mutex
+			rng := rand.New(rand.NewSource(int64(len(mode))))
+			c.Network.Delay = func(from, to string, msg Message) time.Duration {
+				mu.Lock()
+				defer mu.Unlock()
+				return time.Duration(rng.Intn(3000)) * time.Microsecond
+			}
+
+			if err := runContention(c, 8); err != nil {
+				t.Fatal(err)
+			}
+			if v := c.Violations(); v != 0 {
+				t.Fatalf("mutual exclusion violated %d times", v)
+			}
+			if holders := c.Holders(); len(holders) != 0 {
+				t.Fatalf("expected the CS to be free at the end, held by %v", holders)
+			}
+		})
+	}
+}
+
+// Un nodo solo entra con un REQUEST y un REPLY por peer, es decir, 2(N-1)
+// mensajes
+func TestUncontendedEntrySendsTwoMessagesPerPeer(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+
+	if sent := c.MessagesSent(); sent != 4 {
+		t.Fatalf("expected 4 messages for an uncontended entry, got %d", sent)
+	}
+}
+
+// Dos REQUEST con el mismo timestamp de Lamport se desempatan por ID, así que
+// node1 entra antes que node2
+func TestTimestampTieGoesToTheLowerID(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	// Con latencia, ambos REQUEST salen antes de que llegue el del otro
+	c.Network.Delay = func(from, to string, msg Message) time.Duration {
+		return 20 * time.Millisecond
+	}
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+		errs  = make(chan error, 2)
+	)
+	for _, id := range []string{"node2", "node1"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := c.Enter(id, 2*time.Second); err != nil {
+				errs <- err
+				return
+			}
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			c.Exit(id)
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if t1, t2 := c.Node("node1").RequestTime, c.Node("node2").RequestTime; t1 != t2 {
+		t.Fatalf("requests did not tie (node1 ts=%d, node2 ts=%d)", t1, t2)
+	}
+	if len(order) != 2 || order[0] != "node1" {
+		t.Fatalf("expected node1 to win the tie, entry order was %v", order)
+	}
+}
+
+// Un peer cae a mitad de la petición; cuando se le declara caído, el nodo
+// deja de esperar su REPLY y entra
+func TestSuspectedPeerStopsBlockingTheRequest(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	c.Network.Detach("node3")
+
+	done := make(chan error, 1)
+	go func() { done <- c.Enter("node1", 2*time.Second) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("node1 entered without node3's reply (err: %v)", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Lo que haría el detector de fallos
+	c.Node("node1").peerSuspected("node3")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+}
+
+// Un REQUEST que llega con la CS tomada se pospone y su REPLY sale al
+// liberarla
+func TestDeferredReplyIsSentOnRelease(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Enter("node2", 2*time.Second) }()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		node1 := c.Node("node1")
+		node1.mu.Lock()
+		deferred := len(node1.DeferredReplies)
+		node1.mu.Unlock()
+		if deferred == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("node1 never deferred node2's request")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if holders := c.Holders(); len(holders) != 1 || holders[0] != "node1" {
+		t.Fatalf("expected only node1 in the CS, got %v", holders)
+	}
+
+	c.Exit("node1")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node2")
+
+	node1 := c.Node("node1")
+	node1.mu.Lock()
+	pending := len(node1.DeferredReplies)
+	node1.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("node1 still has %d deferred replies after releasing", pending)
+	}
+	// 2(N-1) por entrada: el REPLY pospuesto sustituye al inmediato
+	if sent := c.MessagesSent(); sent != 8 {
+		t.Fatalf("expected 8 messages, got %d", sent)
+	}
+}
+
+// Todos los nodos intentan reservar el mismo asiento a la vez y solo uno lo
+// consigue
+func TestConcurrentReservationsOfOneSeat(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	c.Network.Delay = func(from, to string, msg Message) time.Duration {
+		return time.Millisecond
+	}
+
+	var (
+		wg      sync.WaitGroup
+		granted int32
+		errs    = make(chan error, 3)
+	)
+	for _, id := range c.ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			err := c.Reserve(id, 7, "cliente-"+id, 2*time.Second)
+			switch {
+			case err == nil:
+				atomic.AddInt32(&granted, 1)
+			case !errors.Is(err, errFakeSeatTaken):
+				errs <- err
+			}
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if granted != 1 {
+		t.Fatalf("expected exactly one reservation of seat 7, got %d", granted)
+	}
+	// Sin violación real, los anuncios HELD no deben dar una falsa alarma
+	for _, id := range c.ids {
+		if total, _ := c.Node(id).splitBrain.Snapshot(); total > 0 {
+			t.Fatalf("%s reported %d split-brain violation(s) without one", id, total)
+		}
+	}
+}
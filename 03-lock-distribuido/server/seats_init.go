@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// seatsInitRetry es la espera entre intentos de inicializar los asientos
+const seatsInitRetry = 2 * time.Second
+
+// seatCollection son las operaciones de MongoDB con que se crean los
+// asientos; *mongo.Collection la cumple
+type seatCollection interface {
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
+}
+
+// initSeatsInCS crea los asientos dentro de la sección crítica distribuida.
+// Todos los nodos lo intentan: el primero en entrar los inserta y los demás,
+// al entrar después, ya los encuentran creados. Pide la CS con prioridad de
+// mantenimiento para no retrasar las reservas de los nodos que ya sirven.
+func (s *Server) initSeatsInCS(seats seatCollection, seatInit SeatInit) error {
+	ctx := WithCSResource(WithCSPriority(context.Background(), PriorityMaintenance), "inicialización de asientos")
+	release, err := s.acquireCS(ctx, csWaitTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	initializeSeats(seats, seatInit)
+	return nil
+}
+
+// ensureSeats repite initialize hasta que existan asientos en la BD y
+// entonces marca el servidor como listo. Así da igual en qué orden arranquen
+// los nodos o si el que iba a inicializar cae antes de hacerlo.
+func (s *Server) ensureSeats(seats seatCollection, initialize func() error) {
+	for attempt := 1; ; attempt++ {
+		if count := s.countSeats(seats); count > 0 {
+			atomic.StoreInt32(&s.seatsReady, 1)
+			log.Printf("[%s] %d seats available, ready to serve reservations", s.serverID, count)
+			return
+		}
+
+		if err := initialize(); err != nil {
+			log.Printf("[%s] Seat initialization attempt %d failed: %v", s.serverID, attempt, err)
+		}
+		// Si otro nodo tiene el turno (o el lease), darle tiempo a crearlos
+		if s.countSeats(seats) == 0 {
+			time.Sleep(seatsInitRetry)
+		}
+	}
+}
+
+// countSeats devuelve cuántos asientos hay en la BD, o 0 si falla la consulta
+func (s *Server) countSeats(seats seatCollection) int64 {
+	count, err := seats.CountDocuments(context.Background(), bson.M{})
+	if err != nil {
+		log.Printf("[%s] Failed to count seats: %v", s.serverID, err)
+		return 0
+	}
+	return count
+}
+
+// SeatsReady indica si los asientos ya existen en la BD
+func (s *Server) SeatsReady() bool {
+	return atomic.LoadInt32(&s.seatsReady) == 1
+}
+
+// requireReady responde 503 mientras el servidor espera a sus peers o los
+// asientos no están inicializados, en lugar de dejar que las reservas fallen
+// con 404 o se queden atascadas pidiendo la CS
+func (s *Server) requireReady(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Ready() {
+			next(w, r)
+			return
+		}
+
+		message := "Los asientos aún se están inicializando"
+		if !s.PeersReady() {
+			message = "Esperando a que arranquen los demás nodos del clúster"
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Retry-After", "2")
+		httperr.Write(w, http.StatusServiceUnavailable, CodeNotReady, message)
+	}
+}
+
+// handleReady responde 200 cuando el servidor puede atender reservas y 503
+// mientras tanto
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	ready := s.Ready()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ready":       ready,
+		"peers_ready": s.PeersReady(),
+		"seats_ready": s.SeatsReady(),
+		"server_id":   s.serverID,
+	})
+}
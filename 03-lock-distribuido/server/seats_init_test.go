@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeSeats es la colección de asientos en memoria. Como MongoDB, no impide
+// que dos nodos inserten los asientos a la vez: contar y luego insertar solo
+// es correcto dentro de la CS
+type fakeSeats struct {
+	mu      sync.Mutex
+	count   int64
+	inserts int
+}
+
+func (f *fakeSeats) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	f.mu.Lock()
+	count := f.count
+	f.mu.Unlock()
+	// Ensanchar la ventana entre contar e insertar
+	time.Sleep(time.Millisecond)
+	return count, nil
+}
+
+func (f *fakeSeats) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count += int64(len(documents))
+	f.inserts++
+	return &mongo.InsertManyResult{}, nil
+}
+
+func (f *fakeSeats) snapshot() (int64, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.count, f.inserts
+}
+
+// Tres nodos que arrancan en orden aleatorio crean los asientos una sola vez
+// y todos quedan listos, sea cual sea el primero
+func TestSeatsInitializedOnceWhateverTheStartOrder(t *testing.T) {
+	ids := []string{"node1", "node2", "node3"}
+	for round := 0; round < 10; round++ {
+		c := NewSimCluster(ids...)
+		seats := &fakeSeats{}
+		servers := make(map[string]*Server, len(ids))
+		for _, id := range ids {
+			servers[id] = &Server{node: c.Node(id), serverID: id}
+		}
+
+		var wg sync.WaitGroup
+		for i, idx := range rand.Perm(len(ids)) {
+			s := servers[ids[idx]]
+			wg.Add(1)
+			go func(s *Server, delay time.Duration) {
+				defer wg.Done()
+				time.Sleep(delay)
+				s.ensureSeats(seats, func() error { return s.initSeatsInCS(seats, SeatInit{}) })
+			}(s, time.Duration(i)*time.Duration(rand.Intn(3))*time.Millisecond)
+		}
+		wg.Wait()
+
+		if count, inserts := seats.snapshot(); count != 20 || inserts != 1 {
+			t.Fatalf("round %d: expected 20 seats inserted once, got %d seats in %d inserts", round, count, inserts)
+		}
+		for _, id := range ids {
+			if !servers[id].SeatsReady() {
+				t.Fatalf("round %d: %s is not ready", round, id)
+			}
+		}
+		if c.Violations() != 0 || len(c.Holders()) != 0 {
+			t.Fatalf("round %d: expected the CS free and never shared, held by %v", round, c.Holders())
+		}
+	}
+}
+
+// Un nodo que arranca con los asientos ya creados no pide la CS
+func TestEnsureSeatsSkipsInitializationWhenSeatsExist(t *testing.T) {
+	seats := &fakeSeats{count: 20}
+	s := &Server{node: newSimNode("node1", []string{"node2"}), serverID: "node1"}
+	s.ensureSeats(seats, func() error {
+		t.Fatal("expected no initialization with seats already in the database")
+		return nil
+	})
+	if !s.SeatsReady() {
+		t.Fatal("expected the server to be ready")
+	}
+	if _, inserts := seats.snapshot(); inserts != 0 {
+		t.Fatalf("expected no inserts, got %d", inserts)
+	}
+}
+
+// Mientras no existan los asientos las reservas reciben 503, no 404
+func TestRequireReadyUntilSeatsExist(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	s := &Server{node: c.Node("node1"), serverID: "node1"}
+	atomic.StoreInt32(&s.peersReady, 1)
+	var served int32
+	handler := s.requireReady(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/reservar", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After before the seats exist, got %d", rec.Code)
+	}
+	var body httperr.Response
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body.Error.Code != CodeNotReady || atomic.LoadInt32(&served) != 0 {
+		t.Fatalf("expected code %s without reaching the handler, got %q", CodeNotReady, body.Error.Code)
+	}
+
+	seats := &fakeSeats{}
+	s.ensureSeats(seats, func() error { return s.initSeatsInCS(seats, SeatInit{}) })
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/reservar", nil))
+	if rec.Code != http.StatusOK || atomic.LoadInt32(&served) != 1 {
+		t.Fatalf("expected the request to be served once the seats exist, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// seqWindow es cuántos números de secuencia recientes se recuerdan por peer.
+// Los mensajes de una ronda se entregan desde goroutines distintas, así que
+// pueden llegar desordenados; por eso no basta con guardar el último visto.
+const seqWindow = 1024
+
+// peerSeqs registra las secuencias recibidas de un peer
+type peerSeqs struct {
+	highest uint64
+	seen    map[uint64]bool
+}
+
+// initialSeq elige el primer número de secuencia a partir del reloj físico,
+// de modo que tras un reinicio el nodo emita secuencias mayores que las que
+// sus peers ya recuerdan y no se descarten como duplicadas.
+func initialSeq() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+// nextSeq devuelve el siguiente número de secuencia de este nodo
+func (n *Node) nextSeq() uint64 {
+	return atomic.AddUint64(&n.sendSeq, 1)
+}
+
+// isDuplicate indica si el mensaje ya se procesó (un reintento de sendMessage
+// que sí había llegado) y, si no, lo marca como visto.
+func (n *Node) isDuplicate(msg Message) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	seqs, ok := n.lastSeq[msg.NodeID]
+	if !ok {
+		seqs = &peerSeqs{seen: make(map[uint64]bool)}
+		n.lastSeq[msg.NodeID] = seqs
+	}
+
+	if seqs.seen[msg.Seq] || (seqs.highest > seqWindow && msg.Seq <= seqs.highest-seqWindow) {
+		return true
+	}
+
+	seqs.seen[msg.Seq] = true
+	if msg.Seq > seqs.highest {
+		seqs.highest = msg.Seq
+		// Olvidar las secuencias que ya quedaron fuera de la ventana
+		for seq := range seqs.seen {
+			if seqs.highest > seqWindow && seq <= seqs.highest-seqWindow {
+				delete(seqs.seen, seq)
+			}
+		}
+	}
+	return false
+}
+
+// isStaleReply indica si un REPLY corresponde a una ronda de petición que ya
+// no está en curso. ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) isStaleReply(msg Message) bool {
+	if n.State != Wanted || msg.Round != n.round {
+		log.Printf("[%s] Dropping stale REPLY from %s (round %d, current round %d, state %s)",
+			n.ID, msg.NodeID, msg.Round, n.round, n.State)
+		return true
+	}
+	return false
+}
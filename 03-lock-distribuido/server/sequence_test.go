@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newSequenceNode crea un nodo que envía por un captureTransport y sin
+// anuncios HELD, para inyectarle mensajes directamente en handleMessage
+func newSequenceNode(peers ...string) (*Node, *captureTransport) {
+	node := newSimNode("node1", peers)
+	node.HeldAnnounceInterval = 0
+	capture := newCaptureTransport()
+	node.transport = capture
+	return node, capture
+}
+
+// startRequest pide la CS en segundo plano, espera a que salgan los REQUEST
+// a todos los peers y devuelve la ronda de la petición y el canal con su
+// resultado
+func startRequest(t *testing.T, node *Node, capture *captureTransport) (int64, chan error) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- node.RequestCSContext(context.Background()) }()
+	for range node.PeerList() {
+		if msg := capture.next(t); msg.Type != "REQUEST" {
+			t.Fatalf("expected a REQUEST, got %+v", msg)
+		}
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.round, done
+}
+
+func inject(t *testing.T, node *Node, msg Message) *Message {
+	t.Helper()
+	reply, err := node.handleMessage(msg)
+	if err != nil {
+		t.Fatalf("handleMessage(%+v): %v", msg, err)
+	}
+	return reply
+}
+
+// expectWaiting comprueba que el nodo sigue esperando los REPLY de peers
+func expectWaiting(t *testing.T, node *Node, peers ...string) {
+	t.Helper()
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.State != Wanted {
+		t.Fatalf("expected state Wanted, got %s", node.State)
+	}
+	want := make(map[string]bool, len(peers))
+	for _, p := range peers {
+		want[p] = true
+	}
+	if !reflect.DeepEqual(node.RepliesNeeded, want) {
+		t.Fatalf("expected to wait for %v, waiting for %v", peers, node.RepliesNeeded)
+	}
+}
+
+func expectEntered(t *testing.T, done chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the node did not enter the CS")
+	}
+}
+
+func TestDuplicateReplyCountsOnce(t *testing.T) {
+	node, capture := newSequenceNode("node2", "node3")
+	round, done := startRequest(t, node, capture)
+
+	reply := Message{Type: "REPLY", NodeID: "node2", Timestamp: 5, Seq: 100, Round: round}
+	inject(t, node, reply)
+	inject(t, node, reply)
+	expectWaiting(t, node, "node3")
+
+	inject(t, node, Message{Type: "REPLY", NodeID: "node3", Timestamp: 6, Seq: 200, Round: round})
+	expectEntered(t, done)
+	if state := node.CSStatus().State; state != Held.String() {
+		t.Fatalf("expected state Held, got %s", state)
+	}
+}
+
+// Un REPLY de la ronda anterior que llega tarde, sea un reintento con la
+// misma secuencia o un envío nuevo, no debe contar para la ronda en curso
+func TestLateRepliesDoNotCountForTheNextRound(t *testing.T) {
+	node, capture := newSequenceNode("node2", "node3")
+	first, done := startRequest(t, node, capture)
+
+	fromNode2 := Message{Type: "REPLY", NodeID: "node2", Timestamp: 5, Seq: 100, Round: first}
+	inject(t, node, fromNode2)
+	inject(t, node, Message{Type: "REPLY", NodeID: "node3", Timestamp: 6, Seq: 200, Round: first})
+	expectEntered(t, done)
+	node.ReleaseCS()
+
+	second, done := startRequest(t, node, capture)
+	if second <= first {
+		t.Fatalf("expected a new round after %d, got %d", first, second)
+	}
+
+	// Reintento del REPLY ya procesado
+	inject(t, node, fromNode2)
+	// REPLY con secuencia nueva pero de la ronda anterior
+	inject(t, node, Message{Type: "REPLY", NodeID: "node3", Timestamp: 7, Seq: 201, Round: first})
+	expectWaiting(t, node, "node2", "node3")
+
+	inject(t, node, Message{Type: "REPLY", NodeID: "node2", Timestamp: 8, Seq: 101, Round: second})
+	inject(t, node, Message{Type: "REPLY", NodeID: "node3", Timestamp: 9, Seq: 202, Round: second})
+	expectEntered(t, done)
+	node.ReleaseCS()
+}
+
+// Un REQUEST repetido recibe el mismo REPLY, y uno de una ronda anterior que
+// llega desordenado se descarta sin pisar la ronda vigente del peer
+func TestDuplicateAndOutOfOrderRequests(t *testing.T) {
+	node, _ := newSequenceNode("node2")
+
+	current := Message{Type: "REQUEST", NodeID: "node2", Timestamp: 10, Seq: 50, Round: 2}
+	reply := inject(t, node, current)
+	if reply == nil || reply.Round != 2 {
+		t.Fatalf("expected a piggybacked REPLY for round 2, got %+v", reply)
+	}
+
+	again := inject(t, node, current)
+	if again == nil || again.Seq != reply.Seq || again.Round != reply.Round {
+		t.Fatalf("expected the duplicate to get the same REPLY %+v, got %+v", reply, again)
+	}
+
+	stale := Message{Type: "REQUEST", NodeID: "node2", Timestamp: 4, Seq: 40, Round: 1}
+	if r := inject(t, node, stale); r != nil {
+		t.Fatalf("expected the stale REQUEST to be dropped, got reply %+v", r)
+	}
+	node.mu.Lock()
+	round := node.peerRounds["node2"]
+	node.mu.Unlock()
+	if round != 2 {
+		t.Fatalf("expected node2 to stay at round 2, got %d", round)
+	}
+}
+
+// Con la CS ocupada, un REQUEST repetido no pospone dos REPLY
+func TestDuplicateRequestIsDeferredOnce(t *testing.T) {
+	node, capture := newSequenceNode("node2")
+	round, done := startRequest(t, node, capture)
+	inject(t, node, Message{Type: "REPLY", NodeID: "node2", Timestamp: 5, Seq: 10, Round: round})
+	expectEntered(t, done)
+
+	request := Message{Type: "REQUEST", NodeID: "node2", Timestamp: 6, Seq: 11, Round: 4}
+	for i := 0; i < 3; i++ {
+		if r := inject(t, node, request); r != nil {
+			t.Fatalf("expected the REPLY to be deferred while held, got %+v", r)
+		}
+	}
+	node.mu.Lock()
+	deferred := append([]string(nil), node.DeferredReplies...)
+	node.mu.Unlock()
+	if !reflect.DeepEqual(deferred, []string{"node2"}) {
+		t.Fatalf("expected one deferred reply to node2, got %v", deferred)
+	}
+
+	node.ReleaseCS()
+	if msg := capture.next(t); msg.Type != "REPLY" || msg.Round != 4 {
+		t.Fatalf("expected the deferred REPLY for round 4, got %+v", msg)
+	}
+	select {
+	case msg := <-capture.Sent:
+		t.Fatalf("expected a single deferred REPLY, also sent %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestIsDuplicateToleratesReordering(t *testing.T) {
+	node, _ := newSequenceNode("node2")
+	seen := func(seq uint64) bool {
+		return node.isDuplicate(Message{Type: "REPLY", NodeID: "node2", Seq: seq})
+	}
+
+	// Dentro de la ventana el orden de llegada no importa
+	for _, seq := range []uint64{5000, 4998, 5001, 4999} {
+		if seen(seq) {
+			t.Fatalf("seq %d is new but was reported as a duplicate", seq)
+		}
+	}
+	for _, seq := range []uint64{5000, 4998} {
+		if !seen(seq) {
+			t.Fatalf("seq %d was delivered twice but not reported as a duplicate", seq)
+		}
+	}
+
+	// Lo que queda por debajo de la ventana ya no puede ser nuevo
+	if !seen(5001 - seqWindow) {
+		t.Fatal("expected a sequence below the window to be dropped")
+	}
+	if seen(5002 - seqWindow) {
+		t.Fatal("expected the oldest sequence inside the window to be accepted")
+	}
+
+	// Las secuencias de cada peer son independientes
+	if node.isDuplicate(Message{Type: "REPLY", NodeID: "node3", Seq: 5000}) {
+		t.Fatal("a sequence from another peer was reported as a duplicate")
+	}
+}
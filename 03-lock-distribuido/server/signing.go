@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// signatureHeader lleva la firma HMAC-SHA256 de un mensaje o de una petición
+// interna
+const signatureHeader = "X-Message-Signature"
+
+// signaturePrefix identifica el algoritmo dentro de la cabecera
+const signaturePrefix = "sha256="
+
+// MessageSigner firma los mensajes entre nodos con un secreto compartido
+// (CLUSTER_SECRET) para que nadie de la red pueda inyectar un REPLY falso.
+//
+// Para rotar la clave sin cortar el clúster se acepta una segunda clave
+// (CLUSTER_SECRET_SECONDARY), solo para verificar:
+//  1. en todos los nodos, CLUSTER_SECRET=vieja y CLUSTER_SECRET_SECONDARY=nueva
+//  2. en todos los nodos, CLUSTER_SECRET=nueva y CLUSTER_SECRET_SECONDARY=vieja
+//  3. quitar CLUSTER_SECRET_SECONDARY
+//
+// CLUSTER_SECRET_SECONDARY_UNTIL (RFC3339) cierra la ventana aunque la
+// segunda clave siga configurada.
+type MessageSigner struct {
+	primary        []byte
+	secondary      []byte
+	secondaryUntil time.Time // cero: sin límite
+}
+
+// NewMessageSigner crea el firmante; devuelve nil si no hay secreto, y un
+// firmante nil no firma ni verifica nada
+func NewMessageSigner(primary, secondary string, secondaryUntil time.Time) *MessageSigner {
+	if primary == "" {
+		return nil
+	}
+	s := &MessageSigner{primary: []byte(primary), secondaryUntil: secondaryUntil}
+	if secondary != "" {
+		s.secondary = []byte(secondary)
+	}
+	return s
+}
+
+// messageSignerFromEnv lee CLUSTER_SECRET, CLUSTER_SECRET_SECONDARY y
+// CLUSTER_SECRET_SECONDARY_UNTIL
+func messageSignerFromEnv() (*MessageSigner, error) {
+	primary := os.Getenv("CLUSTER_SECRET")
+	secondary := os.Getenv("CLUSTER_SECRET_SECONDARY")
+	if primary == "" && secondary != "" {
+		return nil, fmt.Errorf("CLUSTER_SECRET_SECONDARY requires CLUSTER_SECRET")
+	}
+
+	var until time.Time
+	if value := os.Getenv("CLUSTER_SECRET_SECONDARY_UNTIL"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("CLUSTER_SECRET_SECONDARY_UNTIL must be RFC3339: %v", err)
+		}
+		until = t
+	}
+	return NewMessageSigner(primary, secondary, until), nil
+}
+
+// Enabled indica si los mensajes se firman y se verifican
+func (s *MessageSigner) Enabled() bool {
+	return s != nil
+}
+
+// Sign devuelve el valor de la cabecera de firma para un cuerpo
+func (s *MessageSigner) Sign(body []byte) string {
+	if s == nil {
+		return ""
+	}
+	return signaturePrefix + hex.EncodeToString(computeMAC(s.primary, body))
+}
+
+// Verify comprueba la firma de un cuerpo con la clave principal o, dentro
+// de la ventana de rotación, con la secundaria. Sin firmante acepta todo.
+func (s *MessageSigner) Verify(body []byte, signature string) bool {
+	if s == nil {
+		return true
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	mac, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return false
+	}
+
+	if hmac.Equal(mac, computeMAC(s.primary, body)) {
+		return true
+	}
+	if s.secondary != nil && (s.secondaryUntil.IsZero() || time.Now().Before(s.secondaryUntil)) {
+		return hmac.Equal(mac, computeMAC(s.secondary, body))
+	}
+	return false
+}
+
+// requestSigningInput es lo que firman SignRequest y VerifyRequest: con el
+// método y la ruta delante, un cuerpo firmado para /internal/join no vale
+// para /internal/leave
+func requestSigningInput(method, path string, body []byte) []byte {
+	input := make([]byte, 0, len(method)+len(path)+2+len(body))
+	input = append(input, method...)
+	input = append(input, ' ')
+	input = append(input, path...)
+	input = append(input, '\n')
+	return append(input, body...)
+}
+
+// SignRequest firma una petición interna que no es un mensaje del algoritmo
+func (s *MessageSigner) SignRequest(method, path string, body []byte) string {
+	return s.Sign(requestSigningInput(method, path, body))
+}
+
+// VerifyRequest comprueba la firma de SignRequest
+func (s *MessageSigner) VerifyRequest(method, path string, body []byte, signature string) bool {
+	return s.Verify(requestSigningInput(method, path, body), signature)
+}
+
+// newSignedPost prepara un POST interno firmado con SignRequest
+func (n *Node) newSignedPost(url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.signer.Enabled() {
+		req.Header.Set(signatureHeader, n.signer.SignRequest(req.Method, req.URL.Path, body))
+	}
+	return req, nil
+}
+
+// postSigned envía un POST interno firmado con SignRequest
+func (n *Node) postSigned(client *http.Client, url string, body []byte) (*http.Response, error) {
+	req, err := n.newSignedPost(url, body)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// requireSignature protege las rutas internas que cambian la membresía o el
+// estado de Raft: rechaza con 401 las peticiones sin firma válida de
+// SignRequest. Sin CLUSTER_SECRET deja pasar todo, como /internal/message.
+func (s *Server) requireSignature(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageBytes))
+		if err != nil {
+			httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "Invalid request body")
+			return
+		}
+		if !s.node.signer.VerifyRequest(r.Method, r.URL.Path, body, r.Header.Get(signatureHeader)) {
+			s.node.stats.recordRejectedSignature()
+			log.Printf("[%s] Rejected %s %s from %s with a missing or invalid signature", s.serverID, r.Method, r.URL.Path, r.RemoteAddr)
+			httperr.Write(w, http.StatusUnauthorized, CodeInvalidSignature, "Invalid request signature")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// requireSignatureOrAdmin protege /internal/leave, que quita un peer de la
+// membresía: lo pide el nodo que se va, con la firma del clúster, o un
+// operador con X-Admin-Token. Sin CLUSTER_SECRET ni ADMIN_TOKEN nadie puede.
+func (s *Server) requireSignatureOrAdmin(next http.HandlerFunc) http.HandlerFunc {
+	signed := s.requireSignature(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case s.adminToken != "" && r.Header.Get("X-Admin-Token") == s.adminToken:
+			next(w, r)
+		case s.node.signer.Enabled():
+			signed(w, r)
+		case s.adminToken == "":
+			httperr.Write(w, http.StatusForbidden, httperr.CodeAdminDisabled, "Removing a peer requires CLUSTER_SECRET or ADMIN_TOKEN")
+		default:
+			log.Printf("[%s] Rejected %s %s from %s without a valid admin token", s.serverID, r.Method, r.URL.Path, r.RemoteAddr)
+			httperr.Write(w, http.StatusUnauthorized, httperr.CodeUnauthorized, "Invalid admin token")
+		}
+	}
+}
+
+func computeMAC(key, body []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(body)
+	return h.Sum(nil)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureVerify(t *testing.T) {
+	signer := NewMessageSigner("secreto", "", time.Time{})
+	body := []byte(`{"type":"REPLY","timestamp":7,"node_id":"node2"}`)
+	signature := signer.Sign(body)
+
+	if !signer.Verify(body, signature) {
+		t.Fatal("valid signature rejected")
+	}
+	tampered := bytes.Replace(body, []byte(`"timestamp":7`), []byte(`"timestamp":1`), 1)
+	if signer.Verify(tampered, signature) {
+		t.Error("signature accepted for a tampered timestamp")
+	}
+	if signer.Verify(body, "") {
+		t.Error("missing signature accepted")
+	}
+	if NewMessageSigner("otro", "", time.Time{}).Verify(body, signature) {
+		t.Error("signature made with another key accepted")
+	}
+}
+
+func TestSignatureRotationWindow(t *testing.T) {
+	body := []byte(`{"type":"REQUEST"}`)
+	vieja := NewMessageSigner("vieja", "", time.Time{})
+	nueva := NewMessageSigner("nueva", "", time.Time{})
+
+	// Paso 2 de la rotación: firma con la nueva y aún acepta la vieja
+	rotando := NewMessageSigner("nueva", "vieja", time.Time{})
+	if !rotando.Verify(body, vieja.Sign(body)) || !rotando.Verify(body, nueva.Sign(body)) {
+		t.Error("both keys must be accepted during the rotation window")
+	}
+	if rotando.Sign(body) != nueva.Sign(body) {
+		t.Error("rotation must sign with the primary key")
+	}
+
+	cerrada := NewMessageSigner("nueva", "vieja", time.Now().Add(-time.Minute))
+	if cerrada.Verify(body, vieja.Sign(body)) {
+		t.Error("secondary key accepted after CLUSTER_SECRET_SECONDARY_UNTIL")
+	}
+	if !cerrada.Verify(body, nueva.Sign(body)) {
+		t.Error("primary key rejected after the rotation window")
+	}
+}
+
+// newSignedServer devuelve un servidor cuyo nodo firma con secret y un
+// servidor HTTP que expone next tras requireSignature
+func newSignedServer(t *testing.T, secret string, next http.HandlerFunc) (*Server, *httptest.Server) {
+	t.Helper()
+	node := newSimNode("node1", []string{"node2"})
+	node.signer = NewMessageSigner(secret, "", time.Time{})
+	s := NewServer(node, nil, nil, "node1")
+
+	mux := http.NewServeMux()
+	for _, path := range []string{"/internal/join", "/internal/leave", "/internal/raft/vote"} {
+		mux.HandleFunc(path, s.requireSignature(next))
+	}
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func TestRequireSignatureOnInternalRoutes(t *testing.T) {
+	var got []byte
+	s, ts := newSignedServer(t, "secreto", func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+	body := []byte(`{"node_id":"node3","url":"http://node3:8080"}`)
+	signer := s.node.signer
+
+	post := func(path string, body []byte, signature string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(body))
+		if signature != "" {
+			req.Header.Set(signatureHeader, signature)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post("/internal/join", body, signer.SignRequest("POST", "/internal/join", body)); status != http.StatusOK {
+		t.Fatalf("signed join answered %d", status)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("handler read %q, want the signed body", got)
+	}
+
+	rejected := []struct {
+		name, path string
+		body       []byte
+		signature  string
+	}{
+		{"unsigned", "/internal/join", body, ""},
+		{"tampered body", "/internal/join", bytes.Replace(body, []byte("node3"), []byte("evil1"), 1), signer.SignRequest("POST", "/internal/join", body)},
+		{"replayed on another route", "/internal/leave", body, signer.SignRequest("POST", "/internal/join", body)},
+		{"body-only message signature", "/internal/raft/vote", body, signer.Sign(body)},
+		{"other key", "/internal/join", body, NewMessageSigner("otro", "", time.Time{}).SignRequest("POST", "/internal/join", body)},
+	}
+	for _, tc := range rejected {
+		if status := post(tc.path, tc.body, tc.signature); status != http.StatusUnauthorized {
+			t.Errorf("%s: answered %d, want 401", tc.name, status)
+		}
+	}
+	if n := s.node.MessageStats().RejectedSignatures; n != uint64(len(rejected)) {
+		t.Errorf("rejected_signatures = %d, want %d", n, len(rejected))
+	}
+}
+
+func TestPostSignedIsAcceptedDuringRotation(t *testing.T) {
+	// El receptor ya firma con la clave nueva y acepta la vieja; el emisor
+	// aún no ha rotado
+	s, ts := newSignedServer(t, "nueva", func(w http.ResponseWriter, r *http.Request) {})
+	s.node.signer = NewMessageSigner("nueva", "vieja", time.Time{})
+
+	sender := newSimNode("node2", []string{"node1"})
+	sender.signer = NewMessageSigner("vieja", "", time.Time{})
+	resp, err := sender.postSigned(http.DefaultClient, ts.URL+"/internal/raft/vote", []byte(`{"term":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("postSigned with the secondary key answered %d", resp.StatusCode)
+	}
+
+	s.node.signer = NewMessageSigner("nueva", "", time.Time{})
+	resp, err = sender.postSigned(http.DefaultClient, ts.URL+"/internal/raft/vote", []byte(`{"term":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("old key accepted after the rotation finished: %d", resp.StatusCode)
+	}
+}
+
+func TestHandleInternalMessageRejectsTamperedSignature(t *testing.T) {
+	node := newSimNode("node1", []string{"node2"})
+	node.signer = NewMessageSigner("secreto", "", time.Time{})
+	s := NewServer(node, nil, nil, "node1")
+
+	body := `{"type":"REPLY","timestamp":9,"node_id":"node2"}`
+	signature := node.signer.Sign([]byte(body))
+	tampered := strings.Replace(body, `"timestamp":9`, `"timestamp":1`, 1)
+
+	req := httptest.NewRequest(http.MethodPost, "/internal/message", strings.NewReader(tampered))
+	req.Header.Set(signatureHeader, signature)
+	rec := httptest.NewRecorder()
+	s.handleInternalMessage(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("tampered message answered %d, want 401", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/internal/message", strings.NewReader(body))
+	req.Header.Set(signatureHeader, signature)
+	rec = httptest.NewRecorder()
+	s.handleInternalMessage(rec, req)
+	if rec.Code == http.StatusUnauthorized {
+		t.Error("correctly signed message rejected")
+	}
+}
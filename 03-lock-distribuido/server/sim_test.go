@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMain silencia el log del algoritmo salvo con go test -v
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// SimCluster es un clúster de nodos del mismo proceso conectados por una
+// MemoryNetwork, con un almacén de asientos falso en lugar de MongoDB. Sirve
+// para ejercitar el algoritmo sin HTTP ni Docker y comprobar sus invariantes:
+// cada entrada en la CS verifica que ningún otro nodo esté en Held.
+type SimCluster struct {
+	Network *MemoryNetwork
+	Seats   *FakeSeatStore
+	ids     []string
+	nodes   map[string]*Node
+
+	violations int64
+}
+
+// NewSimCluster crea un clúster con los nodos indicados, todos en Released
+func NewSimCluster(ids ...string) *SimCluster {
+	c := &SimCluster{
+		Network: NewMemoryNetwork(),
+		Seats:   NewFakeSeatStore(20),
+		ids:     ids,
+		nodes:   make(map[string]*Node, len(ids)),
+	}
+	for _, id := range ids {
+		var peers []string
+		for _, p := range ids {
+			if p != id {
+				peers = append(peers, p)
+			}
+		}
+		node := newSimNode(id, peers)
+		c.Network.Attach(node)
+		c.nodes[id] = node
+	}
+	return c
+}
+
+// newSimNode crea un nodo con los tiempos cortos del arnés
+func newSimNode(id string, peers []string) *Node {
+	node := NewNode(id, peers, nil)
+	node.SendTimeout = 200 * time.Millisecond
+	node.Retry.MaxAttempts = 3
+	node.Retry.InitialDelay = 5 * time.Millisecond
+	node.HeldAnnounceInterval = 20 * time.Millisecond
+	node.trace = nil
+	return node
+}
+
+// captureTransport sustituye al transporte de un nodo en pruebas unitarias:
+// no entrega nada, responde que el REPLY queda pospuesto y pasa cada mensaje
+// enviado por el canal Sent, en el orden en que sale del nodo
+type captureTransport struct {
+	Sent chan Message
+	// Delay, si se define, retrasa la entrega de cada mensaje
+	Delay func(msg Message) time.Duration
+}
+
+func newCaptureTransport() *captureTransport {
+	return &captureTransport{Sent: make(chan Message, 64)}
+}
+
+func (t *captureTransport) Name() string { return "capture" }
+
+func (t *captureTransport) Send(peerID string, seq uint64, payload []byte) (int, MessageResponse, error) {
+	var msg Message
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return 0, MessageResponse{}, err
+	}
+	if t.Delay != nil {
+		time.Sleep(t.Delay(msg))
+	}
+	t.Sent <- msg
+	return http.StatusOK, MessageResponse{Deferred: true}, nil
+}
+
+// next espera el siguiente mensaje enviado
+func (t *captureTransport) next(tb testing.TB) Message {
+	tb.Helper()
+	select {
+	case msg := <-t.Sent:
+		return msg
+	case <-time.After(2 * time.Second):
+		tb.Fatal("no message was sent")
+		return Message{}
+	}
+}
+
+// Node devuelve el nodo con ese ID
+func (c *SimCluster) Node(id string) *Node {
+	return c.nodes[id]
+}
+
+// Enter pide la CS en nombre del nodo y, al conseguirla, comprueba la
+// exclusión mutua
+func (c *SimCluster) Enter(id string, timeout time.Duration) error {
+	return c.EnterWithPriority(id, PriorityNormal, timeout)
+}
+
+// EnterWithPriority es Enter con la prioridad indicada
+func (c *SimCluster) EnterWithPriority(id string, priority int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(WithCSPriority(context.Background(), priority), timeout)
+	defer cancel()
+	if err := c.nodes[id].RequestCSContext(ctx); err != nil {
+		return fmt.Errorf("%s could not enter the CS: %w", id, err)
+	}
+
+	if holders := c.Holders(); len(holders) > 1 {
+		atomic.AddInt64(&c.violations, 1)
+		return fmt.Errorf("mutual exclusion violated: %v hold the CS at once", holders)
+	}
+	return nil
+}
+
+// Exit libera la CS del nodo
+func (c *SimCluster) Exit(id string) {
+	c.nodes[id].ReleaseCS()
+}
+
+// Holders devuelve los nodos que están en Held
+func (c *SimCluster) Holders() []string {
+	var holders []string
+	for _, id := range c.ids {
+		if c.nodes[id].CSStatus().State == Held.String() {
+			holders = append(holders, id)
+		}
+	}
+	return holders
+}
+
+// Violations devuelve cuántas veces se rompió la exclusión mutua
+func (c *SimCluster) Violations() int64 {
+	return atomic.LoadInt64(&c.violations)
+}
+
+// MessagesSent suma los mensajes enviados por todos los nodos, incluidos los
+// REPLY que viajan en la respuesta a un REQUEST
+func (c *SimCluster) MessagesSent() uint64 {
+	var total uint64
+	for _, node := range c.nodes {
+		total += node.MessageStats().TotalSent
+	}
+	return total
+}
+
+// Reserve reserva un asiento del almacén falso dentro de la CS del nodo
+func (c *SimCluster) Reserve(id string, numero int, cliente string, timeout time.Duration) error {
+	if err := c.Enter(id, timeout); err != nil {
+		return err
+	}
+	defer c.Exit(id)
+	return c.Seats.Reserve(numero, cliente)
+}
+
+// FakeSeatStore sustituye a la colección de asientos. No se sincroniza:
+// solo es correcto si se usa dentro de la CS, así que una doble reserva
+// delata un fallo del algoritmo.
+type FakeSeatStore struct {
+	seats map[int]string // numero -> cliente ("" = libre)
+	// Asiento cuya reserva entra en pánico (0 = ninguno)
+	PanicOn int
+}
+
+// errFakeSeatTaken es el fallo de reservar un asiento ocupado
+var errFakeSeatTaken = errors.New("seat already taken")
+
+// NewFakeSeatStore crea total asientos libres
+func NewFakeSeatStore(total int) *FakeSeatStore {
+	s := &FakeSeatStore{seats: make(map[int]string, total)}
+	for i := 1; i <= total; i++ {
+		s.seats[i] = ""
+	}
+	return s
+}
+
+// Reserve marca el asiento como del cliente si está libre
+func (s *FakeSeatStore) Reserve(numero int, cliente string) error {
+	if numero == s.PanicOn {
+		panic(fmt.Sprintf("injected panic reserving seat %d", numero))
+	}
+	if s.seats[numero] != "" {
+		return errFakeSeatTaken
+	}
+	// Dejar pasar tiempo entre la comprobación y la escritura, como hace
+	// MongoDB, para que una violación de la CS se traduzca en doble reserva
+	time.Sleep(time.Millisecond)
+	s.seats[numero] = cliente
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// requestTiming acumula, para una petición HTTP, el tiempo que pasó
+// esperando la sección crítica
+type requestTiming struct {
+	csWait int64 // nanosegundos, con acceso atómico
+}
+
+type requestTimingKey struct{}
+
+// recordCSWait anota en la petición el tiempo de espera por la CS. No hace
+// nada si la petición no pasó por slowRequestMiddleware.
+func recordCSWait(ctx context.Context, wait time.Duration) {
+	if timing, ok := ctx.Value(requestTimingKey{}).(*requestTiming); ok {
+		atomic.AddInt64(&timing.csWait, int64(wait))
+	}
+}
+
+// statusRecorder guarda el código de estado que escribe el handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack cede la conexión al handler, p. ej. para abrir un WebSocket
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	sr.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// slowRequestMiddleware registra como WARN las peticiones que tardan más que
+// threshold, indicando cuánto de ese tiempo fue espera por la CS. Con
+// threshold <= 0 no hace nada.
+func slowRequestMiddleware(serverID string, threshold time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if threshold <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timing := &requestTiming{}
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestTimingKey{}, timing)))
+
+			elapsed := time.Since(start)
+			// Un WebSocket dura lo que dure la conexión: no es lento
+			if elapsed <= threshold || recorder.status == http.StatusSwitchingProtocols {
+				return
+			}
+			csWait := time.Duration(atomic.LoadInt64(&timing.csWait))
+			log.Printf("[%s] WARN slow request: %s %s took %s (status %d, waited %s for CS, threshold %s)",
+				serverID, r.Method, r.URL.Path, elapsed.Round(time.Millisecond), recorder.status,
+				csWait.Round(time.Millisecond), threshold)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logCapture recoge lo que se escribe en el log durante una prueba
+type logCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lc *logCapture) Write(p []byte) (int, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.buf.Write(p)
+}
+
+// slowLines devuelve las líneas de petición lenta registradas
+func (lc *logCapture) slowLines() []string {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	var lines []string
+	for _, line := range strings.Split(lc.buf.String(), "\n") {
+		if strings.Contains(line, "WARN slow request") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func captureLog(t *testing.T) *logCapture {
+	t.Helper()
+	lc := &logCapture{}
+	prev := log.Writer()
+	log.SetOutput(lc)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return lc
+}
+
+// sleepHandler tarda d en responder con el estado indicado
+func sleepHandler(d time.Duration, status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(d)
+		w.WriteHeader(status)
+	})
+}
+
+func TestSlowRequestLoggedOnlyOverThreshold(t *testing.T) {
+	lc := captureLog(t)
+	middleware := slowRequestMiddleware("node1", 50*time.Millisecond)
+
+	fast := middleware(sleepHandler(0, http.StatusOK))
+	fast.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/asientos", nil))
+	if lines := lc.slowLines(); len(lines) != 0 {
+		t.Fatalf("expected no slow-request log for a fast request, got %v", lines)
+	}
+
+	slow := middleware(sleepHandler(80*time.Millisecond, http.StatusConflict))
+	slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/reservar", nil))
+	lines := lc.slowLines()
+	if len(lines) != 1 {
+		t.Fatalf("expected one slow-request log, got %v", lines)
+	}
+	for _, want := range []string{"[node1]", "POST /reservar", "status 409", "waited 0s for CS", "threshold 50ms"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("expected %q in %q", want, lines[0])
+		}
+	}
+}
+
+// La espera por la CS que anota acquireCS aparece en la línea del log
+func TestSlowRequestReportsCSWait(t *testing.T) {
+	lc := captureLog(t)
+	c := NewSimCluster("node1", "node2")
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{node: c.Node("node1"), serverID: "node1"}
+
+	handler := slowRequestMiddleware("node1", 50*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := s.acquireCS(r.Context(), time.Second)
+		if err != nil {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		release()
+	}))
+	go func() {
+		time.Sleep(120 * time.Millisecond)
+		c.Exit("node2")
+	}()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reservar", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the request to get the CS, got %d", rec.Code)
+	}
+
+	lines := lc.slowLines()
+	if len(lines) != 1 {
+		t.Fatalf("expected one slow-request log, got %v", lines)
+	}
+	if strings.Contains(lines[0], "waited 0s for CS") || !strings.Contains(lines[0], "for CS") {
+		t.Fatalf("expected the CS wait to be reported, got %q", lines[0])
+	}
+}
+
+func TestSlowRequestDisabledAndWebSockets(t *testing.T) {
+	lc := captureLog(t)
+
+	// Con umbral 0 no se registra nada
+	disabled := slowRequestMiddleware("node1", 0)(sleepHandler(20*time.Millisecond, http.StatusOK))
+	disabled.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/asientos", nil))
+
+	// Un WebSocket abierto más que el umbral no es una petición lenta
+	ws := slowRequestMiddleware("node1", 10*time.Millisecond)(sleepHandler(20*time.Millisecond, http.StatusSwitchingProtocols))
+	ws.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ws", nil))
+
+	if lines := lc.slowLines(); len(lines) != 0 {
+		t.Fatalf("expected no slow-request logs, got %v", lines)
+	}
+}
+
+func TestRecordCSWaitWithoutMiddleware(t *testing.T) {
+	// Sin el middleware no hay dónde anotar la espera: no debe fallar
+	recordCSWait(context.Background(), time.Second)
+
+	timing := &requestTiming{}
+	ctx := context.WithValue(context.Background(), requestTimingKey{}, timing)
+	recordCSWait(ctx, 30*time.Millisecond)
+	recordCSWait(ctx, 20*time.Millisecond)
+	if timing.csWait != int64(50*time.Millisecond) {
+		t.Fatalf("expected 50ms of CS wait, got %s", time.Duration(timing.csWait))
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// snapshotPeerTimeout es lo que /asientos/snapshot espera el reloj de cada
+// peer: es una consulta barata y la lectura no debe retrasarse por un peer
+// caído
+const snapshotPeerTimeout = 500 * time.Millisecond
+
+// AsientosSnapshot es una lectura de los asientos sin pasar por la CS junto
+// con los relojes que permiten juzgar si está al día
+type AsientosSnapshot struct {
+	Asientos []Asiento `json:"asientos"`
+	ServerID string    `json:"server_id"`
+	// Reloj de Lamport del nodo antes de leer
+	SnapshotClock int64 `json:"snapshot_clock"`
+	// Mayor logical_ts entre los asientos leídos
+	MaxLogicalTS int64 `json:"max_logical_ts"`
+	// Reloj de cada peer consultado después de leer
+	PeerClocks map[string]int64 `json:"peer_clocks"`
+	// Peers cuyo reloj va por delante de SnapshotClock: pudieron confirmar
+	// una reserva que esta lectura no vio
+	AheadPeers []string `json:"ahead_peers"`
+	// Peers que no respondieron a tiempo, con el error
+	Unreachable map[string]string `json:"unreachable,omitempty"`
+	// Si la lectura pudo perderse una escritura concurrente: algún peer va
+	// por delante o no se sabe porque no respondió
+	PossiblyStale bool `json:"possibly_stale"`
+}
+
+// handleAsientosSnapshot lee los asientos de la BD sin pedir la CS, como
+// /asientos, y adjunta el reloj del nodo al empezar y el de los peers al
+// terminar. Un peer cuyo reloj va por delante del de la lectura ha tenido
+// eventos que este nodo aún no ha visto, quizá una reserva que la lectura no
+// recoge. Que ninguno vaya por delante no es una garantía, porque los relojes
+// de Lamport solo ordenan eventos relacionados causalmente: para saber si se
+// ve una reserva concreta, el cliente compara su logical_ts con
+// max_logical_ts.
+func (s *Server) handleAsientosSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	snapshotClock := s.node.Clock.GetTime()
+	asientos, err := s.loadAsientos(r.Context())
+	if err != nil {
+		if requestTimedOut(r) {
+			return
+		}
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to fetch seats")
+		return
+	}
+
+	// Los relojes se piden después de leer: así cuentan también las
+	// escrituras que se confirmaron mientras se leía
+	client := &http.Client{Timeout: snapshotPeerTimeout, Transport: s.node.client.Transport}
+	peers := s.node.PeerList()
+	reports := make([]ClockReport, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			reports[i] = s.node.fetchClock(client, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	snapshot := buildSnapshot(s.serverID, snapshotClock, asientos, reports)
+	if snapshot.PossiblyStale {
+		s.node.logf("Snapshot at clock %d may be stale: ahead %v, unreachable %d",
+			snapshotClock, snapshot.AheadPeers, len(snapshot.Unreachable))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// buildSnapshot compara el reloj de la lectura con el de los peers
+func buildSnapshot(serverID string, snapshotClock int64, asientos []Asiento, reports []ClockReport) AsientosSnapshot {
+	snapshot := AsientosSnapshot{
+		Asientos:      asientos,
+		ServerID:      serverID,
+		SnapshotClock: snapshotClock,
+		PeerClocks:    make(map[string]int64, len(reports)),
+		AheadPeers:    []string{},
+	}
+	if snapshot.Asientos == nil {
+		snapshot.Asientos = []Asiento{}
+	}
+	for _, asiento := range asientos {
+		if asiento.LogicalTS > snapshot.MaxLogicalTS {
+			snapshot.MaxLogicalTS = asiento.LogicalTS
+		}
+	}
+
+	for _, report := range reports {
+		if report.Error != "" {
+			if snapshot.Unreachable == nil {
+				snapshot.Unreachable = make(map[string]string)
+			}
+			snapshot.Unreachable[report.NodeID] = report.Error
+			continue
+		}
+		snapshot.PeerClocks[report.NodeID] = report.Time
+		if report.Time > snapshotClock {
+			snapshot.AheadPeers = append(snapshot.AheadPeers, report.NodeID)
+		}
+	}
+	sort.Strings(snapshot.AheadPeers)
+	snapshot.PossiblyStale = len(snapshot.AheadPeers) > 0 || len(snapshot.Unreachable) > 0
+	return snapshot
+}
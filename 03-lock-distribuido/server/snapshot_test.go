@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestBuildAsientosSnapshot(t *testing.T) {
+	snapshot := buildSnapshot("node1", 10,
+		[]Asiento{seatAt(1, "ana", 7), seatAt(2, "", 3), seatAt(3, "luis", 9)},
+		[]ClockReport{
+			{NodeID: "node4", Time: 12},
+			{NodeID: "node2", Time: 10},
+			{NodeID: "node3", Time: 11},
+			{NodeID: "node5", Error: "timeout"},
+		})
+
+	if snapshot.ServerID != "node1" || snapshot.SnapshotClock != 10 || snapshot.MaxLogicalTS != 9 {
+		t.Fatalf("unexpected snapshot header: %+v", snapshot)
+	}
+	// Un reloj igual al de la lectura no va por delante
+	if !reflect.DeepEqual(snapshot.AheadPeers, []string{"node3", "node4"}) {
+		t.Fatalf("expected node3 and node4 ahead, got %v", snapshot.AheadPeers)
+	}
+	if !reflect.DeepEqual(snapshot.PeerClocks, map[string]int64{"node2": 10, "node3": 11, "node4": 12}) {
+		t.Fatalf("unexpected peer clocks: %v", snapshot.PeerClocks)
+	}
+	if snapshot.Unreachable["node5"] != "timeout" || !snapshot.PossiblyStale {
+		t.Fatalf("expected node5 unreachable and the snapshot possibly stale, got %+v", snapshot)
+	}
+
+	// Sin asientos ni peers por delante la lectura está al día y se
+	// serializa con listas vacías, no null
+	snapshot = buildSnapshot("node1", 10, nil, []ClockReport{{NodeID: "node2", Time: 4}})
+	if snapshot.PossiblyStale || snapshot.MaxLogicalTS != 0 || snapshot.Unreachable != nil {
+		t.Fatalf("expected an up-to-date empty snapshot, got %+v", snapshot)
+	}
+	out, _ := json.Marshal(snapshot)
+	var raw map[string]json.RawMessage
+	json.Unmarshal(out, &raw)
+	if string(raw["asientos"]) != "[]" || string(raw["ahead_peers"]) != "[]" {
+		t.Fatalf("expected empty lists in the JSON, got %s", out)
+	}
+}
+
+// clockPeer sirve /internal/clock con el reloj indicado
+func clockPeer(t *testing.T, clock int64) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal/clock" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(ClockReport{NodeID: "ignored", Time: clock})
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// La lectura no entra en la CS; adjunta el reloj del nodo, el mayor
+// logical_ts leído y el reloj de cada peer, y un peer lento o roto solo
+// marca la lectura como posiblemente desactualizada
+func TestAsientosSnapshotReportsClocksWithoutEnteringTheCS(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(snapshotPeerTimeout + time.Second):
+			case <-r.Context().Done():
+			}
+		}))
+		defer slow.Close()
+		broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}))
+		defer broken.Close()
+
+		node := newSimNode("node1", nil)
+		node.ReplacePeers(map[string]string{
+			"node2": clockPeer(t, 41),
+			"node3": clockPeer(t, 45),
+			"node4": slow.URL,
+			"node5": broken.URL,
+		})
+		node.Clock.AdvanceTo(42)
+		s := NewServer(node, mt.Coll, NewAuditLog(mt.Coll), "node1")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.asientos", mtest.FirstBatch,
+			bson.D{{Key: "numero", Value: 1}, {Key: "disponible", Value: false}, {Key: "cliente", Value: "ana"}, {Key: "logical_ts", Value: int64(38)}},
+			bson.D{{Key: "numero", Value: 2}, {Key: "disponible", Value: true}, {Key: "logical_ts", Value: int64(12)}},
+		))
+
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		s.handleAsientosSnapshot(rec, httptest.NewRequest(http.MethodGet, "/asientos/snapshot", nil))
+		if elapsed := time.Since(start); elapsed > snapshotPeerTimeout+time.Second/2 {
+			t.Fatalf("expected the slow peer to be cut off after %s, took %s", snapshotPeerTimeout, elapsed)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 despite the unreachable peers, got %d: %s", rec.Code, rec.Body)
+		}
+		var snapshot AsientosSnapshot
+		if err := json.NewDecoder(rec.Body).Decode(&snapshot); err != nil {
+			t.Fatal(err)
+		}
+
+		if snapshot.SnapshotClock != 42 || snapshot.MaxLogicalTS != 38 || len(snapshot.Asientos) != 2 {
+			t.Fatalf("expected clock 42, max logical_ts 38 and two seats, got %+v", snapshot)
+		}
+		if !reflect.DeepEqual(snapshot.PeerClocks, map[string]int64{"node2": 41, "node3": 45}) {
+			t.Fatalf("expected the clocks of node2 and node3, got %v", snapshot.PeerClocks)
+		}
+		if !reflect.DeepEqual(snapshot.AheadPeers, []string{"node3"}) {
+			t.Fatalf("expected only node3 ahead of the snapshot, got %v", snapshot.AheadPeers)
+		}
+		if len(snapshot.Unreachable) != 2 || snapshot.Unreachable["node4"] == "" || snapshot.Unreachable["node5"] == "" {
+			t.Fatalf("expected node4 and node5 unreachable, got %v", snapshot.Unreachable)
+		}
+		if !snapshot.PossiblyStale {
+			t.Fatal("expected the snapshot to be flagged possibly stale")
+		}
+
+		if stats := node.MessageStats(); stats.CSEntries != 0 || len(stats.Sent) != 0 {
+			t.Fatalf("expected no CS entry and no algorithm messages, got %+v", stats)
+		}
+	})
+}
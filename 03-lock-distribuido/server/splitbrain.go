@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Detector de split-brain: mientras un nodo está en Held anuncia a sus peers
+// cada HeldAnnounceInterval que tiene la CS con un mensaje HELD. Si un nodo
+// que también está en Held recibe el anuncio, dos nodos tienen la CS a la
+// vez: se registra la violación con la evidencia de ambos. No evita la
+// violación, solo la hace visible.
+//
+// Los anuncios son de un solo intento y no cuentan en las estadísticas de
+// mensajes del algoritmo. El primero sale al entrar, así que una violación
+// se detecta aunque las dos estancias sean cortas, siempre que el anuncio de
+// la segunda llegue mientras la primera sigue dentro.
+//
+// Un anuncio que se retrasa por el camino puede llegar cuando su emisor ya
+// salió y nos dio paso. Para no dar una falsa alarma, cada anuncio toma su
+// secuencia con el mutex del emisor y comprobando que sigue en Held; el REPLY
+// (o RELEASE) que nos dejó entrar se numeró después de salir. Así, un
+// anuncio con una secuencia no mayor que la última que habíamos recibido de
+// ese peer al entrar es de una estancia anterior.
+
+// maxSplitBrainRecords es el número de violaciones que se conservan
+const maxSplitBrainRecords = 100
+
+// HolderEvidence es lo que se sabe de uno de los titulares simultáneos
+type HolderEvidence struct {
+	NodeID           string    `json:"node_id"`
+	RequestTimestamp int64     `json:"request_timestamp"`
+	Round            int64     `json:"round"`
+	HeldSince        time.Time `json:"held_since,omitempty"` // solo del nodo que detecta
+	AnnouncedAt      time.Time `json:"announced_at,omitempty"`
+}
+
+// SplitBrainViolation es una observación de dos nodos en Held a la vez
+type SplitBrainViolation struct {
+	DetectedAt time.Time        `json:"detected_at"`
+	DetectedBy string           `json:"detected_by"`
+	Holders    []HolderEvidence `json:"holders"`
+}
+
+// key identifica el par de estancias, para registrar cada violación una sola
+// vez aunque lleguen varios anuncios
+func (v SplitBrainViolation) key() [2]HolderEvidence {
+	a, b := v.Holders[0], v.Holders[1]
+	a.HeldSince, a.AnnouncedAt = time.Time{}, time.Time{}
+	b.HeldSince, b.AnnouncedAt = time.Time{}, time.Time{}
+	if b.NodeID < a.NodeID {
+		a, b = b, a
+	}
+	return [2]HolderEvidence{a, b}
+}
+
+// SplitBrainLog guarda las últimas violaciones detectadas por el nodo
+type SplitBrainLog struct {
+	mu         sync.Mutex
+	violations []SplitBrainViolation
+	total      uint64
+}
+
+// record añade la violación si no estaba ya registrada. Devuelve false si es
+// un anuncio repetido de la misma.
+func (l *SplitBrainLog) record(v SplitBrainViolation) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := v.key()
+	for _, seen := range l.violations {
+		if seen.key() == key {
+			return false
+		}
+	}
+	l.violations = append(l.violations, v)
+	if len(l.violations) > maxSplitBrainRecords {
+		l.violations = l.violations[len(l.violations)-maxSplitBrainRecords:]
+	}
+	l.total++
+	return true
+}
+
+// Snapshot devuelve el total de violaciones y las últimas registradas
+func (l *SplitBrainLog) Snapshot() (uint64, []SplitBrainViolation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total, append([]SplitBrainViolation{}, l.violations...)
+}
+
+// watchSplitBrain se llama al entrar en la CS: anota la última secuencia
+// recibida de cada peer y empieza a anunciar la estancia.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) watchSplitBrain() {
+	if n.HeldAnnounceInterval <= 0 {
+		return
+	}
+	n.heldSeqs = make(map[string]uint64, len(n.lastSeq))
+	for peer, seqs := range n.lastSeq {
+		n.heldSeqs[peer] = seqs.highest
+	}
+	go n.announceHeld(n.csToken)
+}
+
+// announceHeld envía el anuncio HELD a todos los peers hasta que el nodo deja
+// la estancia con el token indicado
+func (n *Node) announceHeld(token int64) {
+	for {
+		n.mu.Lock()
+		if n.State != Held || n.csToken != token {
+			n.mu.Unlock()
+			return
+		}
+		announcements := make(map[string]Message, len(n.Peers))
+		for _, peer := range n.Peers {
+			announcements[peer] = Message{
+				Type:              "HELD",
+				Timestamp:         n.RequestTime,
+				NodeID:            n.ID,
+				Round:             n.round,
+				MembershipVersion: n.membershipVersion,
+				Seq:               n.nextSeq(),
+				Incarnation:       n.incarnation,
+			}
+		}
+		n.mu.Unlock()
+
+		for peer, msg := range announcements {
+			go n.sendHeld(peer, msg)
+		}
+		time.Sleep(n.HeldAnnounceInterval)
+	}
+}
+
+// sendHeld hace un único intento de entregar un anuncio HELD; si se pierde,
+// el siguiente lo sustituye
+func (n *Node) sendHeld(peerID string, msg Message) {
+	// Los fallos inyectados también afectan a los anuncios
+	if n.injectFault(faultOutbound, peerID, msg.Type) {
+		return
+	}
+	// En pausa no se anuncia nada: el siguiente anuncio tras reanudar basta
+	if n.pause.Paused() {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		n.logf("Error marshalling HELD announcement: %v", err)
+		return
+	}
+	n.transport.Send(peerID, msg.Seq, data)
+}
+
+// observeHeld procesa un anuncio HELD de un peer: si este nodo también está
+// en Held, registra la violación de la exclusión mutua
+func (n *Node) observeHeld(msg Message) {
+	n.mu.Lock()
+	if n.State != Held {
+		n.mu.Unlock()
+		return
+	}
+	if msg.Seq <= n.heldSeqs[msg.NodeID] {
+		n.mu.Unlock()
+		n.logf("Ignoring stale HELD from %s (round %d): it was sent before we entered", msg.NodeID, msg.Round)
+		return
+	}
+	self := HolderEvidence{
+		NodeID:           n.ID,
+		RequestTimestamp: n.RequestTime,
+		Round:            n.round,
+		HeldSince:        n.heldSince,
+	}
+	n.mu.Unlock()
+
+	now := time.Now()
+	violation := SplitBrainViolation{
+		DetectedAt: now,
+		DetectedBy: n.ID,
+		Holders: []HolderEvidence{self, {
+			NodeID:           msg.NodeID,
+			RequestTimestamp: msg.Timestamp,
+			Round:            msg.Round,
+			AnnouncedAt:      now,
+		}},
+	}
+	if !n.splitBrain.record(violation) {
+		return
+	}
+
+	n.stats.recordSplitBrain()
+	n.publishEvent(ProtocolEvent{Kind: eventSplitBrain, Peer: msg.NodeID, Timestamp: msg.Timestamp})
+	n.logf("CRITICAL: SPLIT-BRAIN: %s also holds the critical section (its request ts=%d, ours ts=%d)",
+		msg.NodeID, msg.Timestamp, self.RequestTimestamp)
+}
+
+// SplitBrainReport es la respuesta de /violations
+type SplitBrainReport struct {
+	NodeID     string                `json:"node_id"`
+	Total      uint64                `json:"total"`
+	Violations []SplitBrainViolation `json:"violations"`
+}
+
+// handleViolations devuelve las violaciones de la exclusión mutua que ha
+// detectado este nodo
+func (s *Server) handleViolations(w http.ResponseWriter, r *http.Request) {
+	total, violations := s.node.splitBrain.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SplitBrainReport{
+		NodeID:     s.serverID,
+		Total:      total,
+		Violations: violations,
+	})
+}
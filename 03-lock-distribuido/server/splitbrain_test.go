@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Node2 da por caído a node1, que tiene la CS, y entra también. Los anuncios
+// HELD deben dejar constancia de la violación.
+func TestSplitBrainIsRecorded(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	node2 := c.Node("node2")
+	done := make(chan error, 1)
+	go func() { done <- node2.RequestCSContext(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	node2.peerSuspected("node1")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	var violations []SplitBrainViolation
+	for time.Now().Before(deadline) {
+		if _, violations = c.Node("node1").splitBrain.Snapshot(); len(violations) > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	node2.ReleaseCS()
+	c.Exit("node1")
+
+	if len(violations) != 1 {
+		t.Fatalf("expected node1 to record 1 split-brain violation, got %d", len(violations))
+	}
+	if got := violations[0].Holders; got[0].NodeID != "node1" || got[1].NodeID != "node2" {
+		t.Fatalf("unexpected holders in the violation: %+v", got)
+	}
+	if n := c.Node("node1").MessageStats().SplitBrainDetected; n != 1 {
+		t.Fatalf("expected split_brain_detected=1, got %d", n)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// startupPollInterval es cada cuánto se vuelve a probar /health en los peers
+// que aún no responden durante el arranque
+const startupPollInterval = 500 * time.Millisecond
+
+// waitForPeers bloquea hasta que todos los peers responden a /health o pasa
+// timeout. Evita que las primeras peticiones a la CS agoten sus reintentos
+// contra nodos que todavía no escuchan. Al volver marca los peers como
+// listos aunque alguno falte: a partir de ahí el detector de fallos se
+// encarga de los que sigan caídos.
+func (s *Server) waitForPeers(timeout time.Duration) {
+	defer atomic.StoreInt32(&s.peersReady, 1)
+
+	client := &http.Client{Timeout: time.Second}
+	deadline := time.Now().Add(timeout)
+	pending := make(map[string]bool)
+	for _, peer := range s.node.PeerList() {
+		pending[peer] = true
+	}
+	lastReport := time.Now()
+
+	for len(pending) > 0 {
+		for peer := range pending {
+			if s.node.peerReachable(client, peer) {
+				delete(pending, peer)
+				log.Printf("[%s] Peer %s is reachable", s.serverID, peer)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			log.Printf("[%s] WARNING: startup timeout after %s, still unreachable: %v. Starting anyway",
+				s.serverID, timeout, sortedSet(pending))
+			return
+		}
+		if time.Since(lastReport) >= 5*time.Second {
+			log.Printf("[%s] Waiting for peers %v before serving reservations", s.serverID, sortedSet(pending))
+			lastReport = time.Now()
+		}
+		time.Sleep(startupPollInterval)
+	}
+	log.Printf("[%s] All peers reachable", s.serverID)
+}
+
+// peerReachable indica si un peer responde 200 a /health
+func (n *Node) peerReachable(client *http.Client, peerID string) bool {
+	base, err := n.peerBaseURL(peerID)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Get(base + "/health")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// PeersReady indica si ya terminó la espera de arranque a los peers. Una vez
+// listo no se vuelve atrás aunque un peer llegue tarde o caiga.
+func (s *Server) PeersReady() bool {
+	return atomic.LoadInt32(&s.peersReady) == 1
+}
+
+// Ready indica si el servidor puede atender reservas
+func (s *Server) Ready() bool {
+	return s.PeersReady() && s.SeatsReady()
+}
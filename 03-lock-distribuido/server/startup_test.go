@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthyHandler responde 200 a /health
+var healthyHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// lateAddr reserva una dirección en la que todavía no escucha nadie
+func lateAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+// startAt arranca un servidor /health en addr
+func startAt(t *testing.T, addr string) *httptest.Server {
+	t.Helper()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(healthyHandler)
+	ts.Listener.Close()
+	ts.Listener = l
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// readyStatus consulta /ready
+func readyStatus(t *testing.T, s *Server) (int, map[string]interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	var body map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&body)
+	return rec.Code, body
+}
+
+// Un peer que arranca 10 segundos tarde retrasa el servicio de reservas
+// hasta que responde; una vez listo el nodo no vuelve atrás aunque el peer
+// caiga de nuevo
+func TestStartupWaitsForALatePeer(t *testing.T) {
+	late := 10 * time.Second
+	if testing.Short() {
+		late = time.Second
+	}
+
+	up := httptest.NewServer(healthyHandler)
+	defer up.Close()
+	addr := lateAddr(t)
+	node := NewNode("node1", []string{"node2", "node3"}, map[string]string{
+		"node2": up.URL,
+		"node3": "http://" + addr,
+	})
+	s := &Server{node: node, serverID: "node1"}
+	atomic.StoreInt32(&s.seatsReady, 1)
+	var served int32
+	reservar := s.requireReady(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+	})
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		s.waitForPeers(time.Minute)
+		close(done)
+	}()
+
+	time.Sleep(late / 2)
+	if s.PeersReady() {
+		t.Fatal("expected the node to wait for node3")
+	}
+	rec := httptest.NewRecorder()
+	reservar(rec, httptest.NewRequest(http.MethodPost, "/reservar", nil))
+	if rec.Code != http.StatusServiceUnavailable || atomic.LoadInt32(&served) != 0 {
+		t.Fatalf("expected reservations to get 503 while waiting, got %d", rec.Code)
+	}
+	if code, body := readyStatus(t, s); code != http.StatusServiceUnavailable || body["peers_ready"] != false {
+		t.Fatalf("expected /ready to report 503 without peers, got %d %v", code, body)
+	}
+
+	time.Sleep(time.Until(start.Add(late)))
+	peer := startAt(t, addr)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the node kept waiting after node3 came up")
+	}
+	if waited := time.Since(start); waited < late {
+		t.Fatalf("expected to wait for node3 at least %s, waited %s", late, waited)
+	}
+
+	// node3 vuelve a caer: el nodo ya listo sigue atendiendo
+	peer.Close()
+	if code, body := readyStatus(t, s); code != http.StatusOK || body["ready"] != true {
+		t.Fatalf("expected /ready to stay 200 after node3 left, got %d %v", code, body)
+	}
+	reservar(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/reservar", nil))
+	if atomic.LoadInt32(&served) != 1 {
+		t.Fatal("expected reservations to be served once the peers were ready")
+	}
+}
+
+// Si un peer no llega a tiempo el nodo arranca igualmente
+func TestStartupTimeoutStartsAnyway(t *testing.T) {
+	node := NewNode("node1", []string{"node2"}, map[string]string{"node2": "http://" + lateAddr(t)})
+	s := &Server{node: node, serverID: "node1"}
+
+	start := time.Now()
+	s.waitForPeers(time.Second)
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 3*time.Second {
+		t.Fatalf("expected to give up after the 1s timeout, took %s", elapsed)
+	}
+	if !s.PeersReady() {
+		t.Fatal("expected the node to start after the timeout")
+	}
+}
+
+// Sin peers, o con todos ya escuchando, no hay espera
+func TestStartupWithPeersAlreadyUp(t *testing.T) {
+	up := httptest.NewServer(healthyHandler)
+	defer up.Close()
+	node := NewNode("node1", []string{"node2", "node3"}, map[string]string{"node2": up.URL, "node3": up.URL})
+	s := &Server{node: node, serverID: "node1"}
+
+	start := time.Now()
+	s.waitForPeers(time.Minute)
+	if elapsed := time.Since(start); elapsed > startupPollInterval {
+		t.Fatalf("expected no wait with every peer up, took %s", elapsed)
+	}
+	if !s.PeersReady() {
+		t.Fatal("expected the peers to be ready")
+	}
+}
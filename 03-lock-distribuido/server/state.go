@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// PeerContact es el último contacto con un peer; LastContact es nil si
+// todavía no se ha intercambiado ningún mensaje con él
+type PeerContact struct {
+	LastContact *time.Time `json:"last_contact"`
+	AgoMs       int64      `json:"ago_ms,omitempty"`
+	Suspect     bool       `json:"suspect"`
+}
+
+// DebugState es una foto del estado interno del algoritmo, tomada de una
+// vez bajo el mutex del nodo para que todos los campos sean coherentes
+type DebugState struct {
+	NodeID          string                 `json:"node_id"`
+	CapturedAt      time.Time              `json:"captured_at"`
+	Algorithm       string                 `json:"algorithm"`
+	State           string                 `json:"state"`
+	RequestTime     int64                  `json:"request_time"`
+	Round           int64                  `json:"round"`
+	LamportTime     int64                  `json:"lamport_time"`
+	VectorClock     map[string]int64       `json:"vector_clock,omitempty"`
+	RepliesNeeded   []string               `json:"replies_needed"`
+	DeferredReplies []string               `json:"deferred_replies"`
+	Excluded        []string               `json:"excluded"`
+	ImplicitGrants  []string               `json:"implicit_grants,omitempty"` // solo con IMPLICIT_GRANTS
+	Queue           []queuedRequest        `json:"queue,omitempty"`           // solo en lamport-queue
+	LocalWaiting    int                    `json:"local_waiting"`             // peticiones locales esperando turno
+	Peers           map[string]PeerContact `json:"peers"`
+}
+
+// touchPeer anota que acabamos de intercambiar un mensaje con un peer
+func (n *Node) touchPeer(peerID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.lastContact[peerID] = time.Now()
+}
+
+// DebugState captura el estado interno del nodo
+func (n *Node) DebugState() DebugState {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	state := DebugState{
+		NodeID:          n.ID,
+		CapturedAt:      now,
+		Algorithm:       n.Algorithm,
+		State:           n.State.String(),
+		RequestTime:     n.RequestTime,
+		Round:           n.round,
+		LamportTime:     n.Clock.GetTime(),
+		VectorClock:     n.VectorSnapshot(),
+		RepliesNeeded:   sortedSet(n.RepliesNeeded),
+		DeferredReplies: append([]string{}, n.DeferredReplies...),
+		Excluded:        sortedSet(n.excluded),
+		ImplicitGrants:  sortedSet(n.hasGrant),
+		Queue:           append([]queuedRequest(nil), n.queue...),
+		LocalWaiting:    n.LocalQueueLength(),
+		Peers:           make(map[string]PeerContact, len(n.Peers)),
+	}
+	for _, peer := range n.Peers {
+		contact := PeerContact{Suspect: n.isSuspect(peer)}
+		if last, ok := n.lastContact[peer]; ok {
+			contact.LastContact = &last
+			contact.AgoMs = now.Sub(last).Milliseconds()
+		}
+		state.Peers[peer] = contact
+	}
+	return state
+}
+
+// sortedSet devuelve las claves a true de un conjunto, ordenadas
+func sortedSet(set map[string]bool) []string {
+	keys := []string{}
+	for k, ok := range set {
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// QueueEntry es una petición de la CS pendiente
+type QueueEntry struct {
+	NodeID    string `json:"node_id"`
+	Timestamp int64  `json:"timestamp"`
+	Round     int64  `json:"round"`
+	Priority  int    `json:"priority"`
+}
+
+// DebugQueue son las peticiones de la CS pendientes que conoce el nodo, para
+// dibujar cómo decide el algoritmo. Deferred son los REQUEST de peers a los
+// que el nodo aún no ha respondido, en el orden en que entrarán en la CS
+// según (timestamp, nodeID); en lamport-queue el nodo responde siempre, así
+// que son las peticiones de peers que tiene en su cola. Own es la petición
+// del propio nodo si está en Wanted.
+type DebugQueue struct {
+	NodeID     string       `json:"node_id"`
+	CapturedAt time.Time    `json:"captured_at"`
+	Algorithm  string       `json:"algorithm"`
+	State      string       `json:"state"`
+	Deferred   []QueueEntry `json:"deferred"`
+	Own        *QueueEntry  `json:"own,omitempty"`
+}
+
+// DebugQueue captura bajo el mutex las peticiones pendientes del nodo
+func (n *Node) DebugQueue() DebugQueue {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	queue := DebugQueue{
+		NodeID:     n.ID,
+		CapturedAt: time.Now(),
+		Algorithm:  n.Algorithm,
+		State:      n.State.String(),
+		Deferred:   []QueueEntry{},
+	}
+	if n.lamportQueue() {
+		for _, req := range n.queue {
+			if req.NodeID != n.ID {
+				queue.Deferred = append(queue.Deferred, QueueEntry{
+					NodeID:    req.NodeID,
+					Timestamp: req.Timestamp,
+					Round:     n.peerRounds[req.NodeID],
+				})
+			}
+		}
+	} else {
+		for _, peer := range n.DeferredReplies {
+			queue.Deferred = append(queue.Deferred, QueueEntry{
+				NodeID:    peer,
+				Timestamp: n.peerRequestTimes[peer],
+				Round:     n.peerRounds[peer],
+				Priority:  n.peerPriorities[peer],
+			})
+		}
+	}
+	// Con Ricart-Agrawala en modo lamport cuenta también la prioridad
+	withPriority := !n.lamportQueue() && n.VClock == nil
+	sort.Slice(queue.Deferred, func(i, j int) bool {
+		a, b := queue.Deferred[i], queue.Deferred[j]
+		if withPriority {
+			return csRequest{a.Priority, a.Timestamp, a.NodeID}.precedes(csRequest{b.Priority, b.Timestamp, b.NodeID}, n.PriorityAging)
+		}
+		return queuedRequest{a.Timestamp, a.NodeID}.before(queuedRequest{b.Timestamp, b.NodeID})
+	})
+	if n.State == Wanted {
+		queue.Own = &QueueEntry{NodeID: n.ID, Timestamp: n.RequestTime, Round: n.round, Priority: n.RequestPriority}
+	}
+	return queue
+}
+
+// handleDebugQueue devuelve las peticiones de la CS pendientes del nodo
+func (s *Server) handleDebugQueue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.node.DebugQueue())
+}
+
+// handleInternalState devuelve el estado interno del nodo para depuración.
+// Es de solo lectura; con ?pretty=true la salida va indentada.
+func (s *Server) handleInternalState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	if r.URL.Query().Get("pretty") == "true" {
+		encoder.SetIndent("", "  ")
+	}
+	encoder.Encode(s.node.DebugState())
+}
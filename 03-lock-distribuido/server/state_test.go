@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// getInternalState consulta /internal/state en el nodo
+func getInternalState(t *testing.T, node *Node, query string) (DebugState, string) {
+	t.Helper()
+	s := &Server{node: node, serverID: node.ID}
+	rec := httptest.NewRecorder()
+	s.handleInternalState(rec, httptest.NewRequest(http.MethodGet, "/internal/state"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	var state DebugState
+	if err := json.Unmarshal([]byte(body), &state); err != nil {
+		t.Fatal(err)
+	}
+	return state, body
+}
+
+// Con node1 esperando a node2, que tiene la CS, la foto de los dos nodos
+// muestra la misma petición desde cada lado
+func TestInternalStateWhileWanted(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	entered := make(chan error, 1)
+	go func() { entered <- c.Enter("node1", 3*time.Second) }()
+
+	node1 := c.Node("node1")
+	waitWanted(t, node1)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(node1.DebugState().RepliesNeeded) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("node1 never got node3's reply: %+v", node1.DebugState())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	state, _ := getInternalState(t, node1, "")
+	if state.NodeID != "node1" || state.State != Wanted.String() {
+		t.Fatalf("expected node1 Wanted, got %+v", state)
+	}
+	if !reflect.DeepEqual(state.RepliesNeeded, []string{"node2"}) {
+		t.Fatalf("expected node1 to wait only for node2, got %v", state.RepliesNeeded)
+	}
+	if state.RequestTime == 0 || state.LamportTime < state.RequestTime {
+		t.Fatalf("expected a request time not ahead of the clock, got request %d clock %d", state.RequestTime, state.LamportTime)
+	}
+	if len(state.DeferredReplies) != 0 {
+		t.Fatalf("expected node1 to defer nothing, got %v", state.DeferredReplies)
+	}
+	for _, peer := range []string{"node2", "node3"} {
+		if contact, ok := state.Peers[peer]; !ok || contact.LastContact == nil {
+			t.Fatalf("expected a last contact with %s, got %+v", peer, state.Peers)
+		}
+	}
+
+	holder, _ := getInternalState(t, c.Node("node2"), "")
+	if holder.State != Held.String() || !reflect.DeepEqual(holder.DeferredReplies, []string{"node1"}) {
+		t.Fatalf("expected node2 Held deferring node1, got %+v", holder)
+	}
+
+	c.Exit("node2")
+	if err := <-entered; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node1")
+}
+
+func TestInternalStatePretty(t *testing.T) {
+	node := newSimNode("node1", []string{"node2"})
+
+	compact, compactBody := getInternalState(t, node, "")
+	if strings.Contains(compactBody, "\n  ") {
+		t.Fatalf("expected compact JSON by default, got %s", compactBody)
+	}
+	pretty, prettyBody := getInternalState(t, node, "?pretty=true")
+	if !strings.Contains(prettyBody, "\n  \"node_id\": \"node1\"") {
+		t.Fatalf("expected indented JSON with ?pretty=true, got %s", prettyBody)
+	}
+	if pretty.State != compact.State || pretty.Peers["node2"].LastContact != nil {
+		t.Fatalf("expected the same Released state without contacts, got %+v", pretty)
+	}
+}
+
+// Las fotos tomadas mientras los nodos compiten por la CS nunca mezclan
+// campos de momentos distintos
+func TestInternalStateIsConsistentUnderContention(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	done := make(chan error, 1)
+	go func() { done <- runContention(c, 10) }()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for _, id := range []string{"node1", "node2", "node3"} {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(100 * time.Microsecond):
+				}
+				state := node.DebugState()
+				switch state.State {
+				case Held.String():
+					if len(state.RepliesNeeded) != 0 {
+						t.Errorf("%s Held while still waiting for %v", node.ID, state.RepliesNeeded)
+					}
+				case Wanted.String():
+					if state.RequestTime == 0 || state.LamportTime < state.RequestTime {
+						t.Errorf("%s Wanted with request %d and clock %d", node.ID, state.RequestTime, state.LamportTime)
+					}
+				case Released.String():
+					if len(state.DeferredReplies) != 0 {
+						t.Errorf("%s Released while deferring %v", node.ID, state.DeferredReplies)
+					}
+				}
+			}
+		}(c.Node(id))
+	}
+
+	err := <-done
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getDebugQueue consulta /debug/queue de un nodo a través del handler
+func getDebugQueue(n *Node) (DebugQueue, error) {
+	server := &Server{node: n, serverID: n.ID}
+	rec := httptest.NewRecorder()
+	server.handleDebugQueue(rec, httptest.NewRequest(http.MethodGet, "/debug/queue", nil))
+
+	var queue DebugQueue
+	if rec.Code != http.StatusOK {
+		return queue, fmt.Errorf("/debug/queue on %s returned %d", n.ID, rec.Code)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&queue); err != nil {
+		return queue, fmt.Errorf("decoding /debug/queue of %s: %w", n.ID, err)
+	}
+	return queue, nil
+}
+
+// Node1 está en la CS y pospone dos REQUEST con timestamps conocidos que
+// llegan en desorden; /debug/queue debe listarlos por (timestamp, nodeID) y,
+// en el nodo que espera, incluir su petición
+func TestDebugQueueOrder(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	node1 := c.Node("node1")
+	for _, msg := range []Message{
+		{Type: "REQUEST", NodeID: "node2", Timestamp: 70, Round: 1},
+		{Type: "REQUEST", NodeID: "node3", Timestamp: 50, Round: 1},
+	} {
+		if reply := node1.handleRequest(msg); reply != nil {
+			t.Fatalf("node1 replied to %s while in the CS", msg.NodeID)
+		}
+	}
+
+	queue, err := getDebugQueue(node1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Deferred) != 2 ||
+		queue.Deferred[0] != (QueueEntry{NodeID: "node3", Timestamp: 50, Round: 1}) ||
+		queue.Deferred[1] != (QueueEntry{NodeID: "node2", Timestamp: 70, Round: 1}) {
+		t.Fatalf("unexpected deferred queue on node1: %+v", queue.Deferred)
+	}
+	if queue.State != Held.String() || queue.Own != nil {
+		t.Fatalf("node1 in the CS should have no pending request, got state %s own %+v", queue.State, queue.Own)
+	}
+
+	node2 := c.Node("node2")
+	done := make(chan error, 1)
+	go func() { done <- c.Enter("node2", 2*time.Second) }()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if queue, err = getDebugQueue(node2); err != nil {
+			t.Fatal(err)
+		}
+		if queue.Own != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("node2 never showed its own request")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	node2.mu.Lock()
+	requestTime := node2.RequestTime
+	node2.mu.Unlock()
+	if queue.Own.NodeID != "node2" || queue.Own.Timestamp != requestTime {
+		t.Fatalf("unexpected own request on node2: %+v (request ts %d)", queue.Own, requestTime)
+	}
+
+	c.Exit("node1")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node2")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Plazos por ruta: una reserva espera la CS distribuida y necesita más margen
+// que una lectura de /asientos, que debe fallar pronto. El plazo se pone en el
+// contexto de la petición y los handlers lo respetan: acquireCS deja de
+// esperar la CS (y retira la petición) cuando vence, y una petición que ya
+// estaba dentro sale sin tocar la BD y la libera con su defer. Si el handler
+// termina sin responder porque venció el plazo, el middleware responde 504.
+
+// RouteTimeouts es el plazo de cada ruta, por su plantilla en el router (p. ej.
+// "/reservar"). Las rutas que no aparecen, o con plazo 0, no tienen plazo.
+type RouteTimeouts map[string]time.Duration
+
+// reservationTimeout es el plazo por defecto de las rutas que piden la CS:
+// la espera por la CS más margen para la BD
+const reservationTimeout = csWaitTimeout + 5*time.Second
+
+// DefaultRouteTimeouts son los plazos si ROUTE_TIMEOUTS no dice otra cosa
+func DefaultRouteTimeouts() RouteTimeouts {
+	return RouteTimeouts{
+		"/asientos":            2 * time.Second,
+		"/asientos/verificado": 2*verifyPeerTimeout + time.Second,
+		"/asientos/snapshot":   2*time.Second + snapshotPeerTimeout,
+		"/reservar":            reservationTimeout,
+		"/liberar":             reservationTimeout,
+		"/reservar-lote":       reservationTimeout,
+	}
+}
+
+// ParseRouteTimeouts aplica sobre base una lista "ruta=duración,..." como
+// "/reservar=20s,/asientos=500ms". Una duración 0 quita el plazo de la ruta.
+func ParseRouteTimeouts(spec string, base RouteTimeouts) (RouteTimeouts, error) {
+	timeouts := make(RouteTimeouts, len(base))
+	for route, timeout := range base {
+		timeouts[route] = timeout
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(strings.TrimSpace(parts[0]), "/") {
+			return base, fmt.Errorf("route timeout entry %q must have the form /route=duration", entry)
+		}
+		route, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return base, fmt.Errorf("invalid timeout %q for %s: %v", value, route, err)
+		}
+		if timeout < 0 {
+			return base, fmt.Errorf("timeout for %s must not be negative", route)
+		}
+		timeouts[route] = timeout
+	}
+	return timeouts, nil
+}
+
+func (t RouteTimeouts) String() string {
+	entries := make([]string, 0, len(t))
+	for route, timeout := range t {
+		if timeout > 0 {
+			entries = append(entries, fmt.Sprintf("%s=%s", route, timeout))
+		}
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// lookup devuelve la plantilla de la ruta de la petición y su plazo
+func (t RouteTimeouts) lookup(r *http.Request) (string, time.Duration) {
+	route := r.URL.Path
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			route = template
+		}
+	}
+	return route, t[route]
+}
+
+// timeoutRecorder anota si el handler llegó a responder
+type timeoutRecorder struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (tr *timeoutRecorder) WriteHeader(status int) {
+	tr.wrote = true
+	tr.ResponseWriter.WriteHeader(status)
+}
+
+func (tr *timeoutRecorder) Write(b []byte) (int, error) {
+	tr.wrote = true
+	return tr.ResponseWriter.Write(b)
+}
+
+// routeTimeoutMiddleware pone a cada petición el plazo de su ruta y responde
+// 504 si el handler termina sin responder porque el plazo venció. Los
+// WebSocket quedan fuera, como en InFlight.
+func routeTimeoutMiddleware(serverID string, timeouts RouteTimeouts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, timeout := timeouts.lookup(r)
+			if timeout <= 0 || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			recorder := &timeoutRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			// Si fue el cliente quien cerró la conexión no hay a quién responder
+			if recorder.wrote || ctx.Err() != context.DeadlineExceeded || r.Context().Err() != nil {
+				return
+			}
+			log.Printf("[%s] %s %s exceeded its %s budget, answering 504", serverID, r.Method, r.URL.Path, timeout)
+			httperr.Write(w, http.StatusGatewayTimeout, CodeRequestTimeout,
+				fmt.Sprintf("Request to %s exceeded its %s budget", route, timeout))
+		})
+	}
+}
+
+// undoTimeout limita lo que puede tardar deshacer una escritura de la CS
+const undoTimeout = 5 * time.Second
+
+// undoContext deriva de ctx el contexto con el que deshacer una escritura a
+// medias: conserva sus valores pero no su plazo ni su cancelación, porque si
+// la petición venció lo ya escrito hay que deshacerlo igual. Tiene su propio
+// plazo, undoTimeout.
+func undoContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(detachedContext{parent: ctx}, undoTimeout)
+}
+
+// detachedContext es context.WithoutCancel, que no existe hasta Go 1.21
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// requestTimedOut indica si venció el plazo de la petición; el handler debe
+// volver sin responder y routeTimeoutMiddleware responde 504
+func requestTimedOut(r *http.Request) bool {
+	return r.Context().Err() == context.DeadlineExceeded
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type undoKey struct{}
+
+func TestUndoContextOutlivesTheRequest(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.WithValue(context.Background(), undoKey{}, "valor"), time.Millisecond)
+	defer cancel()
+	<-parent.Done()
+
+	ctx, cancelUndo := undoContext(parent)
+	defer cancelUndo()
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("undo context inherited the expired deadline: %v", err)
+	}
+	if ctx.Value(undoKey{}) != "valor" {
+		t.Error("undo context lost the request values")
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > undoTimeout {
+		t.Errorf("undo context deadline %v, want at most %s from now", deadline, undoTimeout)
+	}
+}
+
+// Las lecturas de la BD dentro de la CS usan el contexto de la petición: con
+// la BD inalcanzable, /reservar falla cuando vence el plazo de la ruta en
+// lugar de esperar la selección de servidor de Mongo con la CS tomada
+func TestReservarMongoCallsHonourTheRequestDeadline(t *testing.T) {
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	node := newSimNode("node1", nil)
+	s := NewServer(node, client.Database("test").Collection("seats"), nil, "node1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/reservar", strings.NewReader(`{"numero":1,"cliente":"ana"}`)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	s.handleReservarAsiento(rec, req)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("/reservar held the CS for %s past its 300ms deadline", elapsed)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("answered %d, want 500 for the failed read", rec.Code)
+	}
+	if status := node.CSStatus(); status.State != Released.String() {
+		t.Errorf("CS left in %s", status.State)
+	}
+}
+
+// Con plazos por ruta, una lectura lenta responde 504 en cuanto vence el
+// suyo, mientras que una reserva espera la CS más que eso y la obtiene. Una
+// reserva que agota su plazo responde 504 y no deja al nodo dentro de la CS
+// ni esperándola.
+func TestRouteTimeouts(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	s := &Server{node: c.Node("node1"), serverID: "node1"}
+	timeouts, err := ParseRouteTimeouts("/asientos=100ms,/reservar=800ms", RouteTimeouts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(routeTimeoutMiddleware("node1", timeouts))
+	router.HandleFunc("/asientos", func(w http.ResponseWriter, r *http.Request) {
+		// Una consulta a la BD que tarda más que el plazo y respeta el contexto
+		select {
+		case <-time.After(2 * time.Second):
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+		}
+	}).Methods("GET")
+	router.HandleFunc("/reservar", func(w http.ResponseWriter, r *http.Request) {
+		release, err := s.acquireCS(r.Context(), csWaitTimeout)
+		if err != nil {
+			httperr.Write(w, http.StatusGatewayTimeout, CodeCSTimeout, "Timeout acquiring distributed lock")
+			return
+		}
+		defer release()
+		if r.Context().Err() != nil {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}).Methods("POST")
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	call := func(method, path string) (int, string, time.Duration, error) {
+		start := time.Now()
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, "", 0, err
+		}
+		defer resp.Body.Close()
+		var body httperr.Response
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Error.Code, time.Since(start), nil
+	}
+
+	status, code, elapsed, err := call("GET", "/asientos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusGatewayTimeout || code != CodeRequestTimeout {
+		t.Fatalf("slow read answered %d %s, want 504 %s", status, code, CodeRequestTimeout)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Fatalf("slow read took %s to time out with a 100ms budget", elapsed)
+	}
+
+	// node2 tiene la CS 300 ms: más que el plazo de una lectura, menos que
+	// el de una reserva
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(300*time.Millisecond, func() { c.Exit("node2") })
+	status, _, elapsed, err = call("POST", "/reservar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK || elapsed < 250*time.Millisecond {
+		t.Fatalf("reservation answered %d after %s, want 200 after waiting for the CS", status, elapsed)
+	}
+
+	// Ahora la tiene más que el plazo de la reserva
+	if err := c.Enter("node2", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	status, _, elapsed, err = call("POST", "/reservar")
+	c.Exit("node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusGatewayTimeout || elapsed > 1500*time.Millisecond {
+		t.Fatalf("blocked reservation answered %d after %s, want 504 after its 800ms budget", status, elapsed)
+	}
+	if state := c.Node("node1").CSStatus().State; state != Released.String() {
+		t.Fatalf("node1 is %s after its reservation timed out", state)
+	}
+	if err := c.Enter("node3", time.Second); err != nil {
+		t.Fatalf("the CS is stuck after the timed-out reservation: %v", err)
+	}
+	c.Exit("node3")
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("%d mutual exclusion violations", v)
+	}
+}
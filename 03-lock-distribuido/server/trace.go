@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Sentido de un mensaje en la traza
+const (
+	traceSent     = "sent"
+	traceReceived = "received"
+)
+
+// Resultado de un mensaje en la traza
+const (
+	traceDelivered   = "delivered"   // el peer lo aceptó
+	traceRejected    = "rejected"    // el peer respondió 4xx
+	traceFailed      = "failed"      // no se pudo entregar tras los reintentos
+	traceDropped     = "dropped"     // descartado por un fallo inyectado
+	tracePiggybacked = "piggybacked" // REPLY enviado en la respuesta HTTP
+	traceReplied     = "replied"     // REQUEST respondido en el acto
+	traceDeferred    = "deferred"    // REQUEST cuya respuesta se pospone
+	traceStale       = "stale"       // REQUEST de una ronda ya superada
+	traceDuplicate   = "duplicate"   // reentrega de un mensaje ya procesado
+	traceProcessed   = "processed"   // cualquier otro mensaje recibido
+)
+
+// TraceEvent es un mensaje del algoritmo enviado o recibido por el nodo
+type TraceEvent struct {
+	Seq       uint64    `bson:"seq" json:"seq"`
+	NodeID    string    `bson:"node_id" json:"node_id"`
+	Time      time.Time `bson:"time" json:"time"`
+	Direction string    `bson:"direction" json:"direction"`
+	Type      string    `bson:"type" json:"type"`
+	Peer      string    `bson:"peer" json:"peer"`
+	// Timestamp de Lamport que lleva el mensaje y reloj del nodo al
+	// registrarlo
+	Timestamp int64  `bson:"timestamp" json:"timestamp"`
+	Clock     int64  `bson:"clock" json:"clock"`
+	Round     int64  `bson:"round" json:"round"`
+	Outcome   string `bson:"outcome" json:"outcome"`
+}
+
+// defaultTraceSize es el número de mensajes que guarda la traza si no se
+// indica TRACE_BUFFER_SIZE
+const defaultTraceSize = 2000
+
+// traceMirrorBuffer limita los eventos pendientes de copiar a MongoDB; si
+// MongoDB no da abasto se descartan en lugar de frenar al algoritmo
+const traceMirrorBuffer = 1024
+
+// TraceRecorder guarda los últimos mensajes del algoritmo en un buffer
+// circular, para reconstruir el entrelazado de una ejecución. Registrar solo
+// toma un mutex propio durante una copia: nunca espera a la red ni a MongoDB.
+// Un TraceRecorder nil no registra nada.
+type TraceRecorder struct {
+	mu     sync.Mutex
+	events []TraceEvent
+	next   int  // posición del siguiente evento en events
+	full   bool // events ya dio la vuelta
+	seq    uint64
+
+	mirror        chan TraceEvent // nil = sin copia en MongoDB
+	mirrorDropped uint64
+}
+
+// NewTraceRecorder crea un registro de los últimos size mensajes; con
+// size <= 0 devuelve nil y la traza queda desactivada
+func NewTraceRecorder(size int) *TraceRecorder {
+	if size <= 0 {
+		return nil
+	}
+	return &TraceRecorder{events: make([]TraceEvent, size)}
+}
+
+// MirrorTo copia además cada evento en la colección indicada. La escritura
+// se hace en segundo plano.
+func (t *TraceRecorder) MirrorTo(collection *mongo.Collection) {
+	if t == nil {
+		return
+	}
+	mirror := make(chan TraceEvent, traceMirrorBuffer)
+	t.mu.Lock()
+	t.mirror = mirror
+	t.mu.Unlock()
+
+	go func() {
+		for ev := range mirror {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if _, err := collection.InsertOne(ctx, ev); err != nil {
+				log.Printf("[%s] Failed to mirror trace event %d: %v", ev.NodeID, ev.Seq, err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// Record añade un evento, numerándolo y fechándolo
+func (t *TraceRecorder) Record(ev TraceEvent) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seq++
+	ev.Seq = t.seq
+	ev.Time = time.Now()
+	t.events[t.next] = ev
+	t.next = (t.next + 1) % len(t.events)
+	if t.next == 0 {
+		t.full = true
+	}
+
+	if t.mirror != nil {
+		select {
+		case t.mirror <- ev:
+		default:
+			t.mirrorDropped++
+		}
+	}
+}
+
+// Since devuelve, del más antiguo al más reciente, hasta limit eventos
+// registrados con el reloj de Lamport del nodo mayor que since
+func (t *TraceRecorder) Since(since int64, limit int) []TraceEvent {
+	events := []TraceEvent{}
+	if t == nil {
+		return events
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	start, count := 0, t.next
+	if t.full {
+		start, count = t.next, len(t.events)
+	}
+	for i := 0; i < count && len(events) < limit; i++ {
+		ev := t.events[(start+i)%len(t.events)]
+		if ev.Clock > since {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// Clear vacía el buffer, p. ej. entre dos ejecuciones de una demo. La
+// numeración sigue, para no confundir eventos de antes y después.
+func (t *TraceRecorder) Clear() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.next = 0
+	t.full = false
+}
+
+// Capacity devuelve el tamaño del buffer (0 si la traza está desactivada)
+func (t *TraceRecorder) Capacity() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.events)
+}
+
+// MirrorDropped cuenta los eventos que no se copiaron a MongoDB por tener
+// la cola de copia llena
+func (t *TraceRecorder) MirrorDropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mirrorDropped
+}
+
+// traceMessage registra un mensaje del algoritmo en la traza del nodo y lo
+// publica en /internal/events
+func (n *Node) traceMessage(direction, peer string, msg Message, outcome string) {
+	n.publishEvent(ProtocolEvent{
+		Kind:      eventMessage,
+		Direction: direction,
+		Type:      msg.Type,
+		Peer:      peer,
+		Timestamp: msg.Timestamp,
+		Outcome:   outcome,
+	})
+	if n.trace == nil {
+		return
+	}
+	n.trace.Record(TraceEvent{
+		NodeID:    n.ID,
+		Direction: direction,
+		Type:      msg.Type,
+		Peer:      peer,
+		Timestamp: msg.Timestamp,
+		Clock:     n.Clock.GetTime(),
+		Round:     msg.Round,
+		Outcome:   outcome,
+	})
+}
+
+// handleTrace devuelve los mensajes registrados: since filtra por el reloj de
+// Lamport del nodo y limit acota cuántos se devuelven
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	var since int64
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "since must be an integer Lamport timestamp")
+			return
+		}
+		since = v
+	}
+
+	limit := 100
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			httperr.Write(w, http.StatusBadRequest, httperr.CodeInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		limit = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"server_id":      s.serverID,
+		"capacity":       s.node.trace.Capacity(),
+		"mirror_dropped": s.node.trace.MirrorDropped(),
+		"events":         s.node.trace.Since(since, limit),
+	})
+}
+
+// handleTraceClear vacía la traza entre dos ejecuciones
+func (s *Server) handleTraceClear(w http.ResponseWriter, r *http.Request) {
+	s.node.trace.Clear()
+	log.Printf("[%s] Message trace cleared", s.serverID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"server_id": s.serverID,
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// traceKey resume un evento de la traza para compararlo
+type traceKey struct {
+	Direction, Type, Peer, Outcome string
+}
+
+// traced devuelve los eventos de la traza del nodo en orden
+func traced(n *Node) []traceKey {
+	var keys []traceKey
+	for _, ev := range n.trace.Since(0, n.trace.Capacity()) {
+		keys = append(keys, traceKey{ev.Direction, ev.Type, ev.Peer, ev.Outcome})
+	}
+	return keys
+}
+
+// La traza de cada nodo reconstruye el entrelazado de una entrada con
+// contienda: quién respondió en el acto, quién pospuso y cuándo llegó el
+// REPLY pospuesto
+func TestTraceRecordsTheMessageInterleaving(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node1, node2 := c.Node("node1"), c.Node("node2")
+	for _, n := range []*Node{node1, node2} {
+		n.HeldAnnounceInterval = 0
+		n.trace = NewTraceRecorder(100)
+	}
+
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	entered := make(chan error, 1)
+	go func() { entered <- c.Enter("node2", 2*time.Second) }()
+	waitWanted(t, node2)
+	deadline := time.Now().Add(time.Second)
+	for len(node1.DebugState().DeferredReplies) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("node1 never deferred node2's request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Exit("node1")
+	if err := <-entered; err != nil {
+		t.Fatal(err)
+	}
+	c.Exit("node2")
+
+	expect := map[*Node][]traceKey{
+		node1: {
+			{traceSent, "REQUEST", "node2", traceDelivered},
+			{traceReceived, "REQUEST", "node2", traceDeferred},
+			{traceSent, "REPLY", "node2", traceDelivered},
+		},
+		node2: {
+			{traceReceived, "REQUEST", "node1", traceReplied},
+			{traceSent, "REPLY", "node1", tracePiggybacked},
+			{traceSent, "REQUEST", "node1", traceDelivered},
+			{traceReceived, "REPLY", "node1", traceProcessed},
+		},
+	}
+	for n, want := range expect {
+		got := traced(n)
+		// Cada evento esperado aparece, en ese orden
+		i := 0
+		for _, key := range got {
+			if i < len(want) && key == want[i] {
+				i++
+			}
+		}
+		if i != len(want) {
+			t.Fatalf("%s: expected %v in order, got %v", n.ID, want, got)
+		}
+
+		events := n.trace.Since(0, 100)
+		for j := 1; j < len(events); j++ {
+			if events[j].Seq != events[j-1].Seq+1 || events[j].Time.Before(events[j-1].Time) {
+				t.Fatalf("%s: events out of order: %+v then %+v", n.ID, events[j-1], events[j])
+			}
+		}
+		for _, ev := range events {
+			if ev.NodeID != n.ID || ev.Clock == 0 || ev.Timestamp == 0 {
+				t.Fatalf("%s: incomplete trace event %+v", n.ID, ev)
+			}
+		}
+	}
+}
+
+// El buffer guarda los últimos mensajes; since filtra por el reloj del nodo
+// y Clear lo vacía sin reiniciar la numeración
+func TestTraceRingBuffer(t *testing.T) {
+	tr := NewTraceRecorder(3)
+	for clock := int64(1); clock <= 5; clock++ {
+		tr.Record(TraceEvent{Type: "REQUEST", Clock: clock})
+	}
+	clocks := func(events []TraceEvent) []int64 {
+		out := []int64{}
+		for _, ev := range events {
+			out = append(out, ev.Clock)
+		}
+		return out
+	}
+
+	if got := tr.Since(0, 10); len(got) != 3 || got[0].Clock != 3 || got[2].Clock != 5 || got[0].Seq != 3 {
+		t.Fatalf("expected the 3 newest events, got %+v", got)
+	}
+	if got := clocks(tr.Since(3, 10)); len(got) != 2 || got[0] != 4 || got[1] != 5 {
+		t.Fatalf("expected the events after ts=3, got %v", got)
+	}
+	if got := clocks(tr.Since(0, 1)); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("expected only the oldest event with limit 1, got %v", got)
+	}
+
+	tr.Clear()
+	if got := tr.Since(0, 10); len(got) != 0 {
+		t.Fatalf("expected an empty trace after Clear, got %+v", got)
+	}
+	tr.Record(TraceEvent{Clock: 6})
+	if got := tr.Since(0, 10); len(got) != 1 || got[0].Seq != 6 {
+		t.Fatalf("expected numbering to continue after Clear, got %+v", got)
+	}
+
+	// Con tamaño 0 la traza queda desactivada
+	off := NewTraceRecorder(0)
+	off.Record(TraceEvent{Clock: 1})
+	if off != nil || off.Capacity() != 0 || off.Since(0, 10) == nil || len(off.Since(0, 10)) != 0 {
+		t.Fatal("expected a disabled trace to record nothing")
+	}
+}
+
+// Registrar nunca espera a la copia en MongoDB: si la cola está llena, el
+// evento se descarta y se cuenta
+func TestTraceRecordNeverBlocksOnTheMirror(t *testing.T) {
+	tr := NewTraceRecorder(10)
+	// Una cola de copia que nadie lee
+	tr.mirror = make(chan TraceEvent)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			tr.Record(TraceEvent{Clock: int64(i + 1)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Record blocked on a stalled mirror")
+	}
+	if dropped := tr.MirrorDropped(); dropped != 20 {
+		t.Fatalf("expected 20 mirror drops, got %d", dropped)
+	}
+	if got := tr.Since(0, 20); len(got) != 10 {
+		t.Fatalf("expected the buffer to keep the last 10 events, got %d", len(got))
+	}
+}
+
+func TestTraceEndpoints(t *testing.T) {
+	node := newSimNode("node1", []string{"node2"})
+	node.trace = NewTraceRecorder(10)
+	for clock := int64(1); clock <= 4; clock++ {
+		node.trace.Record(TraceEvent{NodeID: "node1", Type: "REQUEST", Clock: clock})
+	}
+	s := &Server{node: node, serverID: "node1"}
+
+	get := func(query string) (*httptest.ResponseRecorder, []TraceEvent) {
+		rec := httptest.NewRecorder()
+		s.handleTrace(rec, httptest.NewRequest(http.MethodGet, "/internal/trace"+query, nil))
+		var body struct {
+			Capacity int          `json:"capacity"`
+			Events   []TraceEvent `json:"events"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code == http.StatusOK && body.Capacity != 10 {
+			t.Fatalf("expected capacity 10, got %d", body.Capacity)
+		}
+		return rec, body.Events
+	}
+
+	if _, events := get("?since=1&limit=2"); len(events) != 2 || events[0].Clock != 2 || events[1].Clock != 3 {
+		t.Fatalf("expected events at ts 2 and 3, got %+v", events)
+	}
+	for _, query := range []string{"?since=abc", "?limit=0", "?limit=-1"} {
+		if rec, _ := get(query); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleTraceClear(rec, httptest.NewRequest(http.MethodPost, "/internal/trace/clear", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 from clear, got %d", rec.Code)
+	}
+	if _, events := get(""); len(events) != 0 {
+		t.Fatalf("expected no events after clear, got %+v", events)
+	}
+}
+
+// BenchmarkTraceRecord mide lo que añade la traza a cada mensaje
+func BenchmarkTraceRecord(b *testing.B) {
+	tr := NewTraceRecorder(defaultTraceSize)
+	ev := TraceEvent{NodeID: "node1", Direction: traceSent, Type: "REQUEST", Peer: "node2"}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tr.Record(ev)
+		}
+	})
+}
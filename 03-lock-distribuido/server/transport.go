@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Transport entrega los mensajes del algoritmo a los peers. Send hace un solo
+// intento con el mensaje ya serializado; los reintentos, la traza y el
+// detector de fallos los gestiona sendMessage igual para todos los
+// transportes. Devuelve el estado HTTP de la entrega (o su equivalente) y la
+// respuesta del peer, con el REPLY concedido de inmediato si lo hay.
+type Transport interface {
+	Send(peerID string, seq uint64, payload []byte) (int, MessageResponse, error)
+	Name() string
+}
+
+// errNoRoute indica que el transporte no sabe cómo llegar al peer (p. ej.
+// falta su URL): el envío se abandona sin reintentos
+var errNoRoute = errors.New("no route to peer")
+
+// HTTPTransport envía cada mensaje con POST /internal/message
+type HTTPTransport struct {
+	node *Node
+}
+
+func (t *HTTPTransport) Name() string { return "http" }
+
+func (t *HTTPTransport) Send(peerID string, seq uint64, payload []byte) (int, MessageResponse, error) {
+	var body MessageResponse
+	url, err := t.node.findPeerURL(peerID)
+	if err != nil {
+		return 0, body, fmt.Errorf("%w: %v", errNoRoute, err)
+	}
+	resp, err := t.node.post(url, payload)
+	if err != nil {
+		return 0, body, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		// Un cuerpo vacío (p. ej. la respuesta a un REPLY) no es un error
+		if decodeErr := json.NewDecoder(resp.Body).Decode(&body); decodeErr != nil && decodeErr != io.EOF {
+			t.node.logf("Ignoring unreadable response from %s: %v", peerID, decodeErr)
+		}
+	}
+	return resp.StatusCode, body, nil
+}
+
+// receiveMessage procesa un mensaje que llega por un transporte sin HTTP y
+// devuelve el estado y la respuesta que daría POST /internal/message
+func (n *Node) receiveMessage(msg Message) (status int, resp MessageResponse) {
+	// Un fallo interno se devuelve como 500 para que el emisor reintente
+	defer func() {
+		if rec := recover(); rec != nil {
+			n.logf("Panic processing %s from %s: %v", msg.Type, msg.NodeID, rec)
+			status, resp = http.StatusInternalServerError, MessageResponse{}
+		}
+	}()
+
+	reply, err := n.handleMessage(msg)
+	switch {
+	case errors.Is(err, ErrInvalidMessage):
+		n.logf("Rejected internal message: %v", err)
+		return http.StatusBadRequest, resp
+	case errors.Is(err, ErrUnknownSender):
+		return http.StatusForbidden, resp
+	case errors.Is(err, ErrFaultInjected), errors.Is(err, ErrNodePaused):
+		return http.StatusServiceUnavailable, resp
+	case err != nil:
+		n.logf("Failed to process internal message: %v", err)
+		return http.StatusInternalServerError, resp
+	case msg.Type == "REQUEST":
+		return http.StatusOK, MessageResponse{Reply: reply, Deferred: reply == nil}
+	}
+	return http.StatusOK, resp
+}
+
+// errMemoryDropped es el fallo de un intento que MemoryNetwork.Drop descartó
+var errMemoryDropped = errors.New("message dropped by the in-memory network")
+
+// MemoryNetwork conecta varios nodos de un mismo proceso sin red: cada envío
+// llama directamente a handleMessage del destinatario. Sirve para ejercitar
+// el algoritmo en pruebas y benchmarks sin HTTP ni Docker.
+//
+// Delay y Drop, si se definen, deciden para cada intento cuánto tarda en
+// entregarse y si se pierde; un intento perdido falla como un timeout y
+// sendMessage lo reintenta.
+type MemoryNetwork struct {
+	mu    sync.RWMutex
+	nodes map[string]*Node
+
+	Delay func(from, to string, msg Message) time.Duration
+	Drop  func(from, to string, msg Message) bool
+}
+
+// NewMemoryNetwork crea una red en memoria vacía
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{nodes: make(map[string]*Node)}
+}
+
+// Attach conecta el nodo a la red: desde ahora envía sus mensajes por ella y
+// los demás nodos conectados pueden enviárselos
+func (m *MemoryNetwork) Attach(n *Node) {
+	m.mu.Lock()
+	m.nodes[n.ID] = n
+	m.mu.Unlock()
+	n.transport = &memoryTransport{network: m, from: n.ID}
+}
+
+// Detach desconecta un nodo, como si se hubiera caído: los envíos hacia él
+// fallan hasta que se vuelva a conectar
+func (m *MemoryNetwork) Detach(id string) {
+	m.mu.Lock()
+	delete(m.nodes, id)
+	m.mu.Unlock()
+}
+
+// memoryTransport es el extremo de un nodo en una MemoryNetwork
+type memoryTransport struct {
+	network *MemoryNetwork
+	from    string
+}
+
+func (t *memoryTransport) Name() string { return "memory" }
+
+func (t *memoryTransport) Send(peerID string, seq uint64, payload []byte) (int, MessageResponse, error) {
+	t.network.mu.RLock()
+	peer, ok := t.network.nodes[peerID]
+	t.network.mu.RUnlock()
+	if !ok {
+		return 0, MessageResponse{}, fmt.Errorf("peer %q is not attached to the in-memory network", peerID)
+	}
+
+	// Igual que por la red, el receptor solo ve los bytes enviados
+	var msg Message
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return http.StatusBadRequest, MessageResponse{}, nil
+	}
+
+	if drop := t.network.Drop; drop != nil && drop(t.from, peerID, msg) {
+		return 0, MessageResponse{}, errMemoryDropped
+	}
+	if delay := t.network.Delay; delay != nil {
+		time.Sleep(delay(t.from, peerID, msg))
+	}
+
+	status, resp := peer.receiveMessage(msg)
+	return status, resp, nil
+}
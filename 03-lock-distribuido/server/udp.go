@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// udpPortOffset separa el puerto UDP de un nodo de su puerto HTTP: un nodo
+// en el 8081 recibe los datagramas en el 9081
+const udpPortOffset = 1000
+
+// maxDatagramBytes es el mayor datagrama que se acepta
+const maxDatagramBytes = 64 * 1024
+
+// Tipos de datagrama
+const (
+	udpKindMessage = "message"
+	udpKindAck     = "ack"
+)
+
+// udpDatagram es lo que viaja en cada datagrama UDP: un mensaje del algoritmo
+// o el ack que lo confirma. El ack lleva el mismo estado y la misma respuesta
+// que daría POST /internal/message, incluido el REPLY concedido de inmediato.
+type udpDatagram struct {
+	Kind string `json:"kind"`
+	From string `json:"from"`
+
+	// Mensaje: los bytes exactos del Message y su firma
+	Message   json.RawMessage `json:"message,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+
+	// Ack: la secuencia confirmada y el resultado de procesarla
+	Seq      uint64   `json:"seq,omitempty"`
+	Status   int      `json:"status,omitempty"`
+	Reply    *Message `json:"reply,omitempty"`
+	Deferred bool     `json:"deferred,omitempty"`
+}
+
+// errAckTimeout indica que el ack de un datagrama no llegó a tiempo: se
+// perdió el mensaje, el ack o el peer está caído
+var errAckTimeout = errors.New("no ack received")
+
+// UDPTransport intercambia los mensajes del algoritmo como datagramas JSON.
+//
+// UDP no garantiza la entrega ni el orden, así que cada mensaje espera su ack
+// y sendMessage reintenta igual que con HTTP. Un reintento de un mensaje que
+// sí había llegado lleva la misma secuencia y el receptor lo descarta como
+// duplicado, devolviendo de nuevo el REPLY que ya hubiera concedido.
+type UDPTransport struct {
+	node *Node
+	conn *net.UDPConn
+
+	mu      sync.Mutex
+	waiters map[uint64]chan udpDatagram // secuencia -> espera de su ack
+
+	// Fracción de datagramas salientes que se descartan a propósito, para
+	// simular un canal con pérdidas (UDP_LOSS)
+	LossRate float64
+	dropped  uint64 // acceso atómico
+}
+
+// NewUDPTransport abre el socket UDP del nodo en addr (p. ej. ":9081")
+func NewUDPTransport(node *Node, addr string) (*UDPTransport, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPTransport{
+		node:    node,
+		conn:    conn,
+		waiters: make(map[uint64]chan udpDatagram),
+	}, nil
+}
+
+// Serve lee datagramas hasta que se cierra el socket
+func (t *UDPTransport) Serve() {
+	buf := make([]byte, maxDatagramBytes)
+	for {
+		n, from, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			t.node.logf("UDP read error: %v", err)
+			continue
+		}
+
+		var dg udpDatagram
+		if err := json.Unmarshal(buf[:n], &dg); err != nil {
+			t.node.logf("Ignoring malformed datagram from %s: %v", from, err)
+			continue
+		}
+
+		switch dg.Kind {
+		case udpKindAck:
+			t.deliverAck(dg)
+		case udpKindMessage:
+			// Procesar fuera del bucle de lectura para no retrasar los acks
+			// de nuestros propios envíos
+			go t.receive(dg, from)
+		default:
+			t.node.logf("Ignoring datagram of unknown kind %q from %s", dg.Kind, from)
+		}
+	}
+}
+
+// Close cierra el socket y termina Serve
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
+
+// Dropped devuelve cuántos datagramas se han descartado por UDP_LOSS
+func (t *UDPTransport) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+func (t *UDPTransport) Name() string { return "udp" }
+
+// Send envía un intento de un mensaje a un peer y espera su ack durante
+// SendTimeout. Devuelve el estado y la respuesta equivalentes a los de POST
+// /internal/message.
+func (t *UDPTransport) Send(peerID string, seq uint64, payload []byte) (int, MessageResponse, error) {
+	addr, err := t.node.peerUDPAddr(peerID)
+	if err != nil {
+		return 0, MessageResponse{}, fmt.Errorf("%w: %v", errNoRoute, err)
+	}
+
+	ack := make(chan udpDatagram, 1)
+	t.mu.Lock()
+	t.waiters[seq] = ack
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.waiters, seq)
+		t.mu.Unlock()
+	}()
+
+	dg := udpDatagram{Kind: udpKindMessage, From: t.node.ID, Message: payload}
+	if t.node.signer.Enabled() {
+		dg.Signature = t.node.signer.Sign(payload)
+	}
+	if err := t.send(dg, addr); err != nil {
+		return 0, MessageResponse{}, err
+	}
+
+	timer := time.NewTimer(t.node.SendTimeout)
+	defer timer.Stop()
+	select {
+	case got := <-ack:
+		return got.Status, MessageResponse{Reply: got.Reply, Deferred: got.Deferred}, nil
+	case <-timer.C:
+		return 0, MessageResponse{}, errAckTimeout
+	}
+}
+
+// deliverAck entrega un ack a quien espera su secuencia. Los acks de
+// intentos que ya expiraron se ignoran.
+func (t *UDPTransport) deliverAck(dg udpDatagram) {
+	t.mu.Lock()
+	ack, ok := t.waiters[dg.Seq]
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ack <- dg:
+	default:
+	}
+}
+
+// receive procesa un mensaje recibido por UDP igual que POST
+// /internal/message y responde con su ack
+func (t *UDPTransport) receive(dg udpDatagram, from *net.UDPAddr) {
+	if !t.node.signer.Verify(dg.Message, dg.Signature) {
+		t.node.stats.recordRejectedSignature()
+		t.node.logf("Rejected datagram from %s with a missing or invalid signature", from)
+		return
+	}
+
+	var msg Message
+	if err := json.Unmarshal(dg.Message, &msg); err != nil {
+		t.node.logf("Ignoring unreadable message in datagram from %s: %v", from, err)
+		return
+	}
+
+	status, resp := t.node.receiveMessage(msg)
+	ack := udpDatagram{
+		Kind:     udpKindAck,
+		From:     t.node.ID,
+		Seq:      msg.Seq,
+		Status:   status,
+		Reply:    resp.Reply,
+		Deferred: resp.Deferred,
+	}
+
+	if err := t.send(ack, from); err != nil {
+		t.node.logf("Failed to ack %s from %s: %v", msg.Type, msg.NodeID, err)
+	}
+}
+
+// send escribe un datagrama, salvo que la pérdida simulada lo descarte
+func (t *UDPTransport) send(dg udpDatagram, addr *net.UDPAddr) error {
+	if t.LossRate > 0 && rand.Float64() < t.LossRate {
+		atomic.AddUint64(&t.dropped, 1)
+		return nil
+	}
+
+	data, err := json.Marshal(dg)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxDatagramBytes {
+		return fmt.Errorf("datagram of %d bytes exceeds the %d byte limit", len(data), maxDatagramBytes)
+	}
+	_, err = t.conn.WriteToUDP(data, addr)
+	return err
+}
+
+// peerUDPAddr deduce la dirección UDP de un peer de su URL base: el mismo
+// host y su puerto HTTP más udpPortOffset
+func (n *Node) peerUDPAddr(peerID string) (*net.UDPAddr, error) {
+	base, err := n.peerBaseURL(peerID)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		port = 80
+		if u.Scheme == "https" {
+			port = 443
+		}
+	}
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(u.Hostname(), strconv.Itoa(port+udpPortOffset)))
+}
+
+// Transport devuelve el transporte por el que el nodo envía sus mensajes
+func (n *Node) Transport() string {
+	return n.transport.Name()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listenUDP abre el socket UDP de un nodo en un puerto libre de loopback y
+// devuelve la URL base desde la que los peers deducen ese puerto
+func listenUDP(t *testing.T, node *Node, loss float64) (*UDPTransport, string) {
+	t.Helper()
+	tr, err := NewUDPTransport(node, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.LossRate = loss
+	go tr.Serve()
+	t.Cleanup(func() { tr.Close() })
+	port := tr.conn.LocalAddr().(*net.UDPAddr).Port
+	return tr, fmt.Sprintf("http://127.0.0.1:%d", port-udpPortOffset)
+}
+
+// newUDPCluster es un SimCluster cuyos nodos se envían los mensajes por UDP
+// con la pérdida indicada. Los reintentos son cortos y numerosos, como
+// corresponde a un canal que pierde datagramas.
+func newUDPCluster(t *testing.T, loss float64, ids ...string) (*SimCluster, []*UDPTransport) {
+	t.Helper()
+	c := NewSimCluster(ids...)
+	urls := make(map[string]string, len(ids))
+	var transports []*UDPTransport
+	for _, id := range ids {
+		tr, url := listenUDP(t, c.Node(id), loss)
+		urls[id] = url
+		transports = append(transports, tr)
+	}
+	for i, id := range ids {
+		node := c.Node(id)
+		node.transport = transports[i]
+		node.SendTimeout = 50 * time.Millisecond
+		node.Retry.MaxAttempts = 20
+		node.ReplacePeers(urls)
+	}
+	return c, transports
+}
+
+// La exclusión mutua se mantiene sobre un canal UDP que pierde el 10% de los
+// datagramas: los reintentos con la misma secuencia y el descarte de
+// duplicados compensan tanto los mensajes como los acks perdidos
+func TestMutualExclusionOverLossyUDP(t *testing.T) {
+	ids := []string{"node1", "node2", "node3"}
+	c, transports := newUDPCluster(t, 0.1, ids...)
+	const rounds = 10
+	if err := runContention(c, rounds); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("mutual exclusion violated %d times", v)
+	}
+
+	var dropped uint64
+	for i, id := range ids {
+		dropped += transports[i].Dropped()
+		if entries := c.Node(id).MessageStats().CSEntries; entries != rounds {
+			t.Fatalf("%s entered %d times, expected %d", id, entries, rounds)
+		}
+		if transport := c.Node(id).Transport(); transport != "udp" {
+			t.Fatalf("%s sent over %s", id, transport)
+		}
+	}
+	if dropped == 0 {
+		t.Fatal("expected the simulated loss to drop some datagrams")
+	}
+	t.Logf("%d datagrams dropped", dropped)
+}
+
+// Un nodo con TRANSPORT=udp y otro con HTTP conviven: cada uno envía por su
+// transporte y recibe por los dos
+func TestMixedUDPAndHTTPTransports(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node1, node2 := c.Node("node1"), c.Node("node2")
+
+	// node1 envía por UDP y recibe por HTTP los mensajes de node2
+	udp1, url1 := listenUDP(t, node1, 0)
+	var httpMessages int64
+	server1 := &Server{node: node1, serverID: "node1"}
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&httpMessages, 1)
+		server1.handleInternalMessage(w, r)
+	}))
+	defer ts1.Close()
+	node1.transport = udp1
+
+	// node2 envía por HTTP y escucha UDP para recibir los de node1
+	_, url2 := listenUDP(t, node2, 0)
+	node2.transport = &HTTPTransport{node: node2}
+	node2.SetPeerInternalURL("node1", ts1.URL)
+
+	node1.ReplacePeers(map[string]string{"node2": url2})
+	node2.ReplacePeers(map[string]string{"node1": url1})
+
+	if err := runContention(c, 5); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.Violations(); v != 0 {
+		t.Fatalf("mutual exclusion violated %d times", v)
+	}
+	if node1.Transport() != "udp" || node2.Transport() != "http" {
+		t.Fatalf("expected node1 on udp and node2 on http, got %s and %s", node1.Transport(), node2.Transport())
+	}
+	if atomic.LoadInt64(&httpMessages) == 0 {
+		t.Fatal("node2 never reached node1 over HTTP")
+	}
+	if got := node2.MessageStats().Received["REQUEST"]; got == 0 {
+		t.Fatal("node1's REQUESTs never reached node2 over UDP")
+	}
+}
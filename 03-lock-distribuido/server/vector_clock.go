@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Modos de reloj lógico soportados (CLOCK_MODE)
+const (
+	ClockModeLamport = "lamport"
+	ClockModeVector  = "vector"
+)
+
+// parseClockMode valida el valor de CLOCK_MODE; vacío equivale a lamport
+func parseClockMode(mode string) (string, error) {
+	switch mode {
+	case "", ClockModeLamport:
+		return ClockModeLamport, nil
+	case ClockModeVector:
+		return ClockModeVector, nil
+	default:
+		return "", fmt.Errorf("unknown clock mode %q (expected %q or %q)", mode, ClockModeLamport, ClockModeVector)
+	}
+}
+
+// VectorClock implementa un reloj vectorial indexado por ID de nodo.
+// Es seguro para su uso concurrente.
+type VectorClock struct {
+	nodeID string
+	v      map[string]int64
+	mu     sync.Mutex
+}
+
+// NewVectorClock crea un reloj vectorial para el nodo indicado
+func NewVectorClock(nodeID string) *VectorClock {
+	return &VectorClock{nodeID: nodeID, v: map[string]int64{nodeID: 0}}
+}
+
+// Increment registra un evento local y devuelve una copia del vector
+func (c *VectorClock) Increment() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.v[c.nodeID]++
+	return c.copyLocked()
+}
+
+// Merge combina el vector recibido (máximo componente a componente) y cuenta
+// la recepción como un evento local.
+func (c *VectorClock) Merge(received map[string]int64) map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, t := range received {
+		if t > c.v[id] {
+			c.v[id] = t
+		}
+	}
+	c.v[c.nodeID]++
+	return c.copyLocked()
+}
+
+// Snapshot devuelve una copia del vector actual
+func (c *VectorClock) Snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.copyLocked()
+}
+
+// Restore reanuda el reloj desde un vector persistido. La componente propia
+// avanza además safetyJump por los eventos posteriores al último guardado.
+func (c *VectorClock) Restore(saved map[string]int64, safetyJump int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, t := range saved {
+		if t > c.v[id] {
+			c.v[id] = t
+		}
+	}
+	c.v[c.nodeID] += safetyJump
+}
+
+func (c *VectorClock) copyLocked() map[string]int64 {
+	out := make(map[string]int64, len(c.v))
+	for id, t := range c.v {
+		out[id] = t
+	}
+	return out
+}
+
+// UseVectorClock activa el modo vector: las peticiones se ordenan por la
+// relación causal de sus vectores en lugar de por el timestamp de Lamport,
+// que se sigue manteniendo para la auditoría. Todos los nodos del clúster
+// deben usar el mismo modo. Debe llamarse antes de arrancar el nodo.
+func (n *Node) UseVectorClock() {
+	n.VClock = NewVectorClock(n.ID)
+}
+
+// ClockMode devuelve el modo de reloj con el que se ordenan las peticiones
+func (n *Node) ClockMode() string {
+	if n.VClock != nil {
+		return ClockModeVector
+	}
+	return ClockModeLamport
+}
+
+// VectorSnapshot devuelve una copia del reloj vectorial, o nil en modo lamport
+func (n *Node) VectorSnapshot() map[string]int64 {
+	if n.VClock == nil {
+		return nil
+	}
+	return n.VClock.Snapshot()
+}
+
+// tickVector registra un envío en el reloj vectorial y devuelve el vector que
+// viaja en el mensaje, o nil en modo lamport
+func (n *Node) tickVector() map[string]int64 {
+	if n.VClock == nil {
+		return nil
+	}
+	return n.VClock.Increment()
+}
+
+// Relación causal entre dos vectores
+type VectorOrder int
+
+const (
+	VectorEqual      VectorOrder = iota
+	VectorBefore                 // a ocurrió antes que b
+	VectorAfter                  // b ocurrió antes que a
+	VectorConcurrent             // a y b son concurrentes
+)
+
+// CompareVectors determina la relación causal entre a y b. Las entradas que
+// faltan en un vector cuentan como 0.
+func CompareVectors(a, b map[string]int64) VectorOrder {
+	less, greater := false, false
+	for id, ta := range a {
+		if tb := b[id]; ta < tb {
+			less = true
+		} else if ta > tb {
+			greater = true
+		}
+	}
+	for id, tb := range b {
+		if _, ok := a[id]; !ok && tb > 0 {
+			less = true
+		}
+	}
+
+	switch {
+	case less && greater:
+		return VectorConcurrent
+	case less:
+		return VectorBefore
+	case greater:
+		return VectorAfter
+	default:
+		return VectorEqual
+	}
+}
+
+// vectorSum suma las componentes del vector. Crece estrictamente a lo largo
+// de cualquier cadena causal, así que ordenar por ella respeta happened-before.
+func vectorSum(v map[string]int64) int64 {
+	var sum int64
+	for _, t := range v {
+		sum += t
+	}
+	return sum
+}
+
+// vectorPrecedes indica si la petición (va, idA) tiene prioridad sobre
+// (vb, idB). Si una precede causalmente a la otra, gana la anterior. Entre
+// peticiones concurrentes no basta con comparar IDs: con tres nodos el orden
+// resultante podría tener ciclos (A antes que C por causalidad, C antes que B
+// y B antes que A por ID) y dejar a todos esperando. Por eso se desempata
+// primero por la suma del vector, que es un orden total compatible con la
+// causalidad, y solo después por ID.
+func vectorPrecedes(va map[string]int64, idA string, vb map[string]int64, idB string) bool {
+	switch CompareVectors(va, vb) {
+	case VectorBefore:
+		return true
+	case VectorAfter:
+		return false
+	}
+	if sa, sb := vectorSum(va), vectorSum(vb); sa != sb {
+		return sa < sb
+	}
+	return idA < idB
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseClockMode(t *testing.T) {
+	for in, want := range map[string]string{"": ClockModeLamport, "lamport": ClockModeLamport, "vector": ClockModeVector} {
+		got, err := parseClockMode(in)
+		if err != nil || got != want {
+			t.Errorf("parseClockMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseClockMode("hybrid"); err == nil {
+		t.Error("expected an unknown clock mode to be rejected")
+	}
+}
+
+func TestVectorClockIncrementAndMerge(t *testing.T) {
+	c := NewVectorClock("node1")
+	if got := c.Increment(); !reflect.DeepEqual(got, map[string]int64{"node1": 1}) {
+		t.Fatalf("expected {node1:1} after a local event, got %v", got)
+	}
+
+	// Máximo componente a componente, y la recepción cuenta como evento
+	got := c.Merge(map[string]int64{"node1": 0, "node2": 4, "node3": 2})
+	want := map[string]int64{"node1": 2, "node2": 4, "node3": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v after merging, got %v", want, got)
+	}
+
+	// Las copias devueltas no comparten memoria con el reloj
+	got["node1"] = 99
+	if snap := c.Snapshot(); snap["node1"] != 2 {
+		t.Fatalf("modifying a returned vector changed the clock: %v", snap)
+	}
+}
+
+func TestVectorClockRestore(t *testing.T) {
+	c := NewVectorClock("node1")
+	c.Restore(map[string]int64{"node1": 7, "node2": 3}, 100)
+	want := map[string]int64{"node1": 107, "node2": 3}
+	if got := c.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v after restoring, got %v", want, got)
+	}
+}
+
+func TestCompareVectors(t *testing.T) {
+	cases := []struct {
+		a, b map[string]int64
+		want VectorOrder
+	}{
+		{map[string]int64{"n1": 1, "n2": 2}, map[string]int64{"n1": 1, "n2": 2}, VectorEqual},
+		{map[string]int64{"n1": 1}, map[string]int64{"n1": 1, "n2": 1}, VectorBefore},
+		{map[string]int64{"n1": 2, "n2": 1}, map[string]int64{"n1": 1, "n2": 1}, VectorAfter},
+		{map[string]int64{"n1": 2, "n2": 0}, map[string]int64{"n1": 1, "n2": 1}, VectorConcurrent},
+		// Las entradas que faltan cuentan como 0
+		{map[string]int64{"n1": 1, "n2": 0}, map[string]int64{"n1": 1}, VectorEqual},
+		{nil, map[string]int64{"n1": 1}, VectorBefore},
+	}
+	for _, tc := range cases {
+		if got := CompareVectors(tc.a, tc.b); got != tc.want {
+			t.Errorf("CompareVectors(%v, %v) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestVectorPrecedesOrdersConcurrentRequests(t *testing.T) {
+	// La causalidad manda sobre el ID
+	before := map[string]int64{"node2": 1}
+	after := map[string]int64{"node1": 1, "node2": 1}
+	if !vectorPrecedes(before, "node2", after, "node1") || vectorPrecedes(after, "node1", before, "node2") {
+		t.Fatal("expected the causally earlier request to win regardless of node id")
+	}
+
+	// Concurrentes con la misma suma: desempata el ID
+	a := map[string]int64{"node1": 1}
+	b := map[string]int64{"node2": 1}
+	if !vectorPrecedes(a, "node1", b, "node2") || vectorPrecedes(b, "node2", a, "node1") {
+		t.Fatal("expected concurrent requests with equal sums to be ordered by node id")
+	}
+
+	// A antes que C por causalidad, B concurrente con ambos: el orden no
+	// debe tener ciclos
+	reqs := map[string]map[string]int64{
+		"node1": {"node1": 1, "node3": 1},
+		"node2": {"node2": 1},
+		"node3": {"node3": 1, "node1": 2},
+	}
+	ids := []string{"node1", "node2", "node3"}
+	for _, x := range ids {
+		for _, y := range ids {
+			if x == y {
+				continue
+			}
+			if vectorPrecedes(reqs[x], x, reqs[y], y) == vectorPrecedes(reqs[y], y, reqs[x], x) {
+				t.Fatalf("%s and %s are not strictly ordered", x, y)
+			}
+			for _, z := range ids {
+				if z == x || z == y {
+					continue
+				}
+				if vectorPrecedes(reqs[x], x, reqs[y], y) && vectorPrecedes(reqs[y], y, reqs[z], z) &&
+					!vectorPrecedes(reqs[x], x, reqs[z], z) {
+					t.Fatalf("ordering is not transitive: %s < %s < %s but not %s < %s", x, y, z, x, z)
+				}
+			}
+		}
+	}
+}
+
+// /health informa del modo y del vector completo
+func TestHealthReportsTheVectorClock(t *testing.T) {
+	for _, mode := range clockModes {
+		c := newModeCluster(mode, "node1", "node2")
+		if err := runContention(c, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		(&Server{node: c.Node("node1"), serverID: "node1"}).handleHealthCheck(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var health struct {
+			ClockMode   string           `json:"clock_mode"`
+			VectorClock map[string]int64 `json:"vector_clock"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+			t.Fatal(err)
+		}
+		if health.ClockMode != mode {
+			t.Fatalf("expected clock_mode %q, got %q", mode, health.ClockMode)
+		}
+		switch mode {
+		case ClockModeVector:
+			if health.VectorClock["node1"] == 0 || health.VectorClock["node2"] == 0 {
+				t.Fatalf("expected the vector to include both nodes, got %v", health.VectorClock)
+			}
+		default:
+			if health.VectorClock != nil {
+				t.Fatalf("expected no vector in lamport mode, got %v", health.VectorClock)
+			}
+		}
+	}
+}
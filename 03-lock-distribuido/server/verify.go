@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Lectura verificada: GET /asientos/verificado compara la vista de los
+// asientos de este nodo con la que devuelve /asientos en cada peer. Hoy todos
+// los nodos comparten MongoDB y la comparación suele salir limpia; sirve para
+// ver la divergencia entre réplicas cuando cada nodo tenga su propia base.
+
+// verifyPeerTimeout es lo que se espera la respuesta de cada peer
+const verifyPeerTimeout = 2 * time.Second
+
+// SeatView es lo que un nodo dice de un asiento
+type SeatView struct {
+	Disponible bool   `json:"disponible"`
+	Cliente    string `json:"cliente,omitempty"`
+}
+
+// SeatDiscrepancy es un asiento en el que los nodos no coinciden. Un nodo
+// con valor null no tiene el asiento.
+type SeatDiscrepancy struct {
+	Numero int                  `json:"numero"`
+	Nodes  map[string]*SeatView `json:"nodes"`
+}
+
+// VerifiedSeats es la respuesta de /asientos/verificado
+type VerifiedSeats struct {
+	QueriedBy string `json:"queried_by"`
+	// Vista fusionada: de cada asiento, la versión con la última escritura
+	Asientos      []Asiento         `json:"asientos"`
+	Compared      []string          `json:"compared"`
+	Unreachable   map[string]string `json:"unreachable,omitempty"`
+	Discrepancies []SeatDiscrepancy `json:"discrepancies"`
+	Consistent    bool              `json:"consistent"`
+	Partial       bool              `json:"partial"`
+	Warning       string            `json:"warning,omitempty"`
+}
+
+// loadAsientos lee los asientos de MongoDB
+func (s *Server) loadAsientos(ctx context.Context) ([]Asiento, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var asientos []Asiento
+	if err := cursor.All(ctx, &asientos); err != nil {
+		return nil, err
+	}
+	return asientos, nil
+}
+
+// fetchAsientos pide /asientos a un peer
+func (n *Node) fetchAsientos(client *http.Client, peerID string) ([]Asiento, error) {
+	base, err := n.peerBaseURL(peerID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(base + "/asientos")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var body struct {
+		Asientos []Asiento `json:"asientos"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Asientos, nil
+}
+
+// compareSeatViews fusiona las vistas de cada nodo y lista los asientos en
+// los que no coinciden disponible o cliente, o que algún nodo no tiene. En
+// la vista fusionada gana la versión con mayor logical_ts (y, a igualdad, la
+// más reciente), que es la última reserva o liberación confirmada.
+func compareSeatViews(views map[string][]Asiento) ([]Asiento, []SeatDiscrepancy) {
+	nodes := make([]string, 0, len(views))
+	for node := range views {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	bySeat := make(map[int]map[string]Asiento)
+	for _, node := range nodes {
+		for _, asiento := range views[node] {
+			if bySeat[asiento.Numero] == nil {
+				bySeat[asiento.Numero] = make(map[string]Asiento)
+			}
+			bySeat[asiento.Numero][node] = asiento
+		}
+	}
+	numeros := make([]int, 0, len(bySeat))
+	for numero := range bySeat {
+		numeros = append(numeros, numero)
+	}
+	sort.Ints(numeros)
+
+	merged := make([]Asiento, 0, len(numeros))
+	discrepancies := []SeatDiscrepancy{}
+	for _, numero := range numeros {
+		versions := bySeat[numero]
+		var latest *Asiento
+		differ := len(versions) != len(nodes)
+		for _, node := range nodes {
+			asiento, ok := versions[node]
+			if !ok {
+				continue
+			}
+			if latest == nil {
+				copia := asiento
+				latest = &copia
+				continue
+			}
+			if asiento.Disponible != latest.Disponible || asiento.Cliente != latest.Cliente {
+				differ = true
+			}
+			if asiento.LogicalTS > latest.LogicalTS ||
+				(asiento.LogicalTS == latest.LogicalTS && asiento.UpdatedAt.After(latest.UpdatedAt)) {
+				copia := asiento
+				latest = &copia
+			}
+		}
+		merged = append(merged, *latest)
+
+		if differ {
+			discrepancy := SeatDiscrepancy{Numero: numero, Nodes: make(map[string]*SeatView, len(nodes))}
+			for _, node := range nodes {
+				if asiento, ok := versions[node]; ok {
+					discrepancy.Nodes[node] = &SeatView{Disponible: asiento.Disponible, Cliente: asiento.Cliente}
+				} else {
+					discrepancy.Nodes[node] = nil
+				}
+			}
+			discrepancies = append(discrepancies, discrepancy)
+		}
+	}
+	return merged, discrepancies
+}
+
+// handleAsientosVerificado compara la vista propia de los asientos con la de
+// cada peer. Un peer que no responde a tiempo se deja fuera: la comparación
+// es parcial y la respuesta lo avisa, pero no falla.
+func (s *Server) handleAsientosVerificado(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	own, err := s.loadAsientos(r.Context())
+	if err != nil {
+		if requestTimedOut(r) {
+			return
+		}
+		httperr.Write(w, http.StatusInternalServerError, CodeDatabaseError, "Failed to fetch seats")
+		return
+	}
+
+	client := &http.Client{Timeout: verifyPeerTimeout}
+	peers := s.node.PeerList()
+	seats := make([][]Asiento, len(peers))
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			seats[i], errs[i] = s.node.fetchAsientos(client, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	views := map[string][]Asiento{s.serverID: own}
+	unreachable := make(map[string]string)
+	for i, peer := range peers {
+		if errs[i] != nil {
+			unreachable[peer] = errs[i].Error()
+			continue
+		}
+		views[peer] = seats[i]
+	}
+
+	merged, discrepancies := compareSeatViews(views)
+	result := VerifiedSeats{
+		QueriedBy:     s.serverID,
+		Asientos:      merged,
+		Discrepancies: discrepancies,
+		Consistent:    len(discrepancies) == 0,
+		Partial:       len(unreachable) > 0,
+	}
+	for node := range views {
+		result.Compared = append(result.Compared, node)
+	}
+	sort.Strings(result.Compared)
+	if len(unreachable) > 0 {
+		result.Unreachable = unreachable
+		missing := make([]string, 0, len(unreachable))
+		for peer := range unreachable {
+			missing = append(missing, peer)
+		}
+		sort.Strings(missing)
+		result.Warning = fmt.Sprintf("Partial comparison: no answer from %s", strings.Join(missing, ", "))
+		log.Printf("[%s] Seat verification: %s", s.serverID, result.Warning)
+	}
+	if len(discrepancies) > 0 {
+		log.Printf("[%s] Seat verification found %d discrepancies across %v", s.serverID, len(discrepancies), result.Compared)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// seatAt es un asiento con el cliente y el logical_ts indicados ("" = libre)
+func seatAt(numero int, cliente string, logicalTS int64) Asiento {
+	a := asientoLibre(numero)
+	a.Disponible = cliente == ""
+	a.Cliente = cliente
+	a.LogicalTS = logicalTS
+	return a
+}
+
+func TestCompareSeatViews(t *testing.T) {
+	merged, discrepancies := compareSeatViews(map[string][]Asiento{
+		"node1": {seatAt(1, "ana", 5), seatAt(2, "", 3), seatAt(3, "", 1)},
+		"node2": {seatAt(1, "ana", 5), seatAt(2, "luis", 8)},
+	})
+
+	// El asiento 2 difiere y node2 no tiene el 3
+	want := []SeatDiscrepancy{
+		{Numero: 2, Nodes: map[string]*SeatView{
+			"node1": {Disponible: true},
+			"node2": {Disponible: false, Cliente: "luis"},
+		}},
+		{Numero: 3, Nodes: map[string]*SeatView{
+			"node1": {Disponible: true},
+			"node2": nil,
+		}},
+	}
+	if !reflect.DeepEqual(discrepancies, want) {
+		t.Fatalf("unexpected discrepancies: %+v", discrepancies)
+	}
+	// En la vista fusionada gana la última escritura confirmada
+	if len(merged) != 3 || merged[1].Cliente != "luis" || merged[1].LogicalTS != 8 {
+		t.Fatalf("expected the merged view to keep luis's reservation of seat 2, got %+v", merged)
+	}
+
+	if _, discrepancies := compareSeatViews(map[string][]Asiento{
+		"node1": {seatAt(1, "ana", 5)},
+		"node2": {seatAt(1, "ana", 5)},
+	}); len(discrepancies) != 0 {
+		t.Fatalf("expected identical views to agree, got %+v", discrepancies)
+	}
+}
+
+// seatsPeer sirve /asientos con los asientos indicados
+func seatsPeer(t *testing.T, asientos ...Asiento) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"asientos": asientos})
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// La lectura verificada compara con cada peer que responde; los que fallan o
+// no responden a tiempo quedan fuera con un aviso, sin que la petición falle
+func TestAsientosVerificadoDegradesToAPartialComparison(t *testing.T) {
+	withMockMongo(t, func(mt *mtest.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(verifyPeerTimeout + time.Second):
+			case <-r.Context().Done():
+			}
+		}))
+		defer slow.Close()
+		broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}))
+		defer broken.Close()
+
+		node := newSimNode("node1", nil)
+		node.ReplacePeers(map[string]string{
+			"node2": seatsPeer(t, seatAt(1, "ana", 5), seatAt(2, "", 2)),
+			"node3": seatsPeer(t, seatAt(1, "luis", 4), seatAt(2, "", 2)),
+			"node4": slow.URL,
+			"node5": broken.URL,
+		})
+		s := NewServer(node, mt.Coll, NewAuditLog(mt.Coll), "node1")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.asientos", mtest.FirstBatch,
+			bson.D{{Key: "numero", Value: 1}, {Key: "disponible", Value: false}, {Key: "cliente", Value: "ana"}, {Key: "logical_ts", Value: int64(5)}},
+			bson.D{{Key: "numero", Value: 2}, {Key: "disponible", Value: true}, {Key: "logical_ts", Value: int64(2)}},
+		))
+
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		s.handleAsientosVerificado(rec, httptest.NewRequest(http.MethodGet, "/asientos/verificado", nil))
+		if elapsed := time.Since(start); elapsed > verifyPeerTimeout+time.Second/2 {
+			t.Fatalf("expected the slow peer to be cut off after %s, took %s", verifyPeerTimeout, elapsed)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 despite the unreachable peers, got %d: %s", rec.Code, rec.Body)
+		}
+		var result VerifiedSeats
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(result.Compared, []string{"node1", "node2", "node3"}) {
+			t.Fatalf("expected node1-3 compared, got %v", result.Compared)
+		}
+		if !result.Partial || len(result.Unreachable) != 2 || result.Unreachable["node4"] == "" || result.Unreachable["node5"] == "" {
+			t.Fatalf("expected node4 and node5 reported unreachable, got partial=%t %v", result.Partial, result.Unreachable)
+		}
+		if !strings.Contains(result.Warning, "node4, node5") {
+			t.Fatalf("expected a warning naming node4 and node5, got %q", result.Warning)
+		}
+		if result.Consistent || len(result.Discrepancies) != 1 || result.Discrepancies[0].Numero != 1 ||
+			result.Discrepancies[0].Nodes["node3"].Cliente != "luis" {
+			t.Fatalf("expected only seat 1 to differ (luis on node3), got %+v", result.Discrepancies)
+		}
+		if len(result.Asientos) != 2 || result.Asientos[0].Cliente != "ana" {
+			t.Fatalf("expected ana's reservation to win seat 1, got %+v", result.Asientos)
+		}
+	})
+}
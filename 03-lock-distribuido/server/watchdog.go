@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Watchdog de la sección crítica: si un handler se queda colgado dentro de la
+// CS (una llamada a MongoDB que no vuelve, un pánico antes del defer que la
+// libera), el nodo seguiría en Held y todo el clúster quedaría bloqueado.
+// Pasado MaxHold la CS se libera a la fuerza y se envían los REPLY
+// pospuestos. Es una medida de último recurso: si el titular seguía vivo y
+// escribe después, puede coincidir con el siguiente titular.
+
+// armHoldWatchdog programa la liberación forzosa de la estancia actual en la
+// CS. ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) armHoldWatchdog() {
+	if n.MaxHold <= 0 {
+		return
+	}
+	token := n.csToken
+	n.holdTimer = time.AfterFunc(n.MaxHold, func() { n.forceRelease(token) })
+}
+
+// stopHoldWatchdog cancela la liberación forzosa pendiente.
+// ASUME QUE EL MUTEX YA ESTÁ ADQUIRIDO.
+func (n *Node) stopHoldWatchdog() {
+	if n.holdTimer != nil {
+		n.holdTimer.Stop()
+		n.holdTimer = nil
+	}
+}
+
+// forceRelease libera la CS si el nodo sigue en la estancia con el token
+// indicado
+func (n *Node) forceRelease(token int64) {
+	n.mu.Lock()
+	if n.State != Held || n.csToken != token {
+		n.mu.Unlock()
+		return
+	}
+
+	n.logf("CRITICAL: critical section held for %s (limit %s), forcing release",
+		time.Since(n.heldSince).Round(time.Millisecond), n.MaxHold)
+	n.stats.recordForcedRelease()
+	// El titular colgado llamará a ReleaseCSToken si algún día vuelve; para
+	// entonces la CS puede ser de otra petición y no debe tocarla
+	n.reclaimed[token] = true
+	n.releaseLocked()
+	n.mu.Unlock()
+
+	n.leaveLocal()
+}
+
+// HoldToken devuelve el token de la estancia actual en la CS, que la
+// identifica en ReleaseCSToken
+func (n *Node) HoldToken() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.csToken
+}
+
+// ReleaseCSToken libera la CS obtenida con el token indicado. Si el watchdog
+// ya la reclamó no hace nada: la CS puede pertenecer ya a otra petición.
+func (n *Node) ReleaseCSToken(token int64) {
+	if n.releaseToken(token, false) {
+		n.logf("Ignoring release of critical section %d: the watchdog already reclaimed it", token)
+		return
+	}
+	n.logf("Released critical section")
+	n.leaveLocal()
+}
+
+// releaseToken sale de la estancia con el token indicado; con onlyIfHeld
+// solo si el nodo sigue en ella. Devuelve true si el watchdog ya la había
+// reclamado. Libera el mutex con defer para que un pánico al liberar no deje
+// el nodo bloqueado.
+func (n *Node) releaseToken(token int64, onlyIfHeld bool) (reclaimed bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.reclaimed[token] {
+		delete(n.reclaimed, token)
+		return true
+	}
+	if !onlyIfHeld || (n.State == Held && n.csToken == token) {
+		n.releaseLocked()
+	}
+	return false
+}
+
+// abandonCS termina la estancia con el token indicado de una petición que
+// entró en pánico antes de liberarla del todo: sale de la CS si el nodo sigue
+// en ella y cede el turno a la siguiente petición local
+func (n *Node) abandonCS(token int64) {
+	if n.releaseToken(token, true) {
+		return
+	}
+	n.logf("Released critical section %d abandoned by a panicking request", token)
+	n.leaveLocal()
+}
+
+// csHoldKey guarda en el contexto de la petición la CS que obtiene su handler
+type csHoldKey struct{}
+
+// csHold es la CS que tiene una petición HTTP, para que recoverPanics pueda
+// liberarla si el handler entra en pánico. acquireCS anota el token en cuanto
+// obtiene la CS, antes de que el handler instale su defer, y la marca como
+// liberada solo cuando la liberación termina sin pánico.
+type csHold struct {
+	mu       sync.Mutex
+	token    int64
+	held     bool
+	released bool
+}
+
+func (h *csHold) acquired(token int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.token, h.held, h.released = token, true, false
+}
+
+func (h *csHold) done() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.released = true
+}
+
+// pending devuelve el token de la CS que la petición obtuvo y no terminó de
+// liberar
+func (h *csHold) pending() (int64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.token, h.held && !h.released
+}
+
+// recoverPanics es el middleware que recupera los pánicos de los handlers:
+// registra la pila con el ID de la petición, responde 500 y, si la petición
+// obtuvo la CS y no terminó de liberarla (el pánico llegó antes del defer o
+// dentro de la propia liberación), la libera para no bloquear al clúster.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hold := &csHold{}
+		r = r.WithContext(context.WithValue(r.Context(), csHoldKey{}, hold))
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			requestID := w.Header().Get(httperr.RequestIDHeader)
+			log.Printf("[%s] PANIC in %s %s (request %s): %v\n%s",
+				s.serverID, r.Method, r.URL.Path, requestID, rec, debug.Stack())
+			if token, ok := hold.pending(); ok {
+				s.abandonCS(token, requestID)
+			}
+			httperr.Write(w, http.StatusInternalServerError, httperr.CodeInternal, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// abandonCS libera la CS de una petición que entró en pánico. Si la
+// liberación vuelve a entrar en pánico solo queda el watchdog de MaxHold.
+func (s *Server) abandonCS(token int64, requestID string) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[%s] CRITICAL: PANIC releasing critical section %d of request %s: %v; waiting for the hold watchdog",
+				s.serverID, token, requestID, rec)
+		}
+	}()
+	log.Printf("[%s] Forcing release of critical section %d held by panicking request %s", s.serverID, token, requestID)
+	s.node.abandonCS(token)
+}
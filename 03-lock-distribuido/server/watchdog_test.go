@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sincronizacion-distribuida/shared/httperr"
+)
+
+// Un handler que se cuelga dentro de la CS no bloquea al clúster: pasado
+// MaxHold el watchdog la libera, las reservas que esperaban salen adelante y
+// la liberación tardía del handler colgado no toca la CS de otra petición
+func TestStuckHolderIsReclaimed(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	node1 := c.Node("node1")
+	node1.MaxHold = 200 * time.Millisecond
+	s := &Server{node: node1, serverID: node1.ID}
+
+	stuck, err := s.acquireCS(context.Background(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i, id := range []string{"node2", "node3"} {
+		wg.Add(1)
+		go func(numero int, id string) {
+			defer wg.Done()
+			errs <- c.Reserve(id, numero, "cliente-"+id, 5*time.Second)
+		}(i+1, id)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if waited := time.Since(start); waited < node1.MaxHold {
+		t.Fatalf("expected the reservations to wait for MaxHold, they took %s", waited)
+	}
+	if c.Violations() != 0 {
+		t.Fatalf("mutual exclusion violated %d times", c.Violations())
+	}
+	if forced := node1.MessageStats().ForcedReleases; forced != 1 {
+		t.Fatalf("expected 1 forced release, got %d", forced)
+	}
+	var buf bytes.Buffer
+	node1.MessageStats().WritePrometheus(&buf, "node1")
+	if !strings.Contains(buf.String(), `dme_cs_forced_releases_total{node="node1",algorithm="ricart-agrawala"} 1`) {
+		t.Fatalf("expected the forced release in the metrics, got:\n%s", buf.String())
+	}
+
+	// node1 vuelve a entrar y el handler colgado termina por fin
+	next, err := s.acquireCS(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("node1 cannot enter after the forced release: %v", err)
+	}
+	stuck()
+	if state := node1.CSStatus().State; state != Held.String() {
+		t.Fatalf("the stale release left node1 in %s", state)
+	}
+	next()
+
+	if err := c.Reserve("node1", 3, "cliente-node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if forced := node1.MessageStats().ForcedReleases; forced != 1 {
+		t.Fatalf("expected no more forced releases, got %d", forced)
+	}
+}
+
+// Liberar antes de MaxHold desarma el watchdog de esa estancia, que no debe
+// reclamar la siguiente
+func TestReleaseDisarmsTheWatchdog(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node1 := c.Node("node1")
+	node1.MaxHold = 300 * time.Millisecond
+
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	c.Exit("node1")
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// El temporizador de la primera estancia habría vencido ya
+	time.Sleep(200 * time.Millisecond)
+	if state := node1.CSStatus().State; state != Held.String() {
+		t.Fatalf("the first stay's watchdog released the second one, node1 in %s", state)
+	}
+	c.Exit("node1")
+	if forced := node1.MessageStats().ForcedReleases; forced != 0 {
+		t.Fatalf("expected no forced releases, got %d", forced)
+	}
+}
+
+// Con MaxHold a 0 no hay límite
+func TestNoMaxHoldNeverForcesARelease(t *testing.T) {
+	c := NewSimCluster("node1", "node2")
+	node1 := c.Node("node1")
+	node1.MaxHold = 0
+	if err := c.Enter("node1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if state := node1.CSStatus().State; state != Held.String() {
+		t.Fatalf("expected node1 to keep the CS, got %s", state)
+	}
+	c.Exit("node1")
+	if forced := node1.MessageStats().ForcedReleases; forced != 0 {
+		t.Fatalf("expected no forced releases, got %d", forced)
+	}
+}
+
+// La reserva de un asiento entra en pánico dentro de la CS, con el defer que
+// la libera ya instalado y sin él. En ambos casos recoverPanics debe
+// responder 500 con el ID de la petición y la CS debe quedar libre para el
+// resto del clúster y para la siguiente petición local.
+func TestPanicReleasesTheCS(t *testing.T) {
+	c := NewSimCluster("node1", "node2", "node3")
+	c.Seats.PanicOn = 7
+	node1 := c.Node("node1")
+	server := &Server{node: node1, serverID: node1.ID}
+
+	handlers := map[string]http.HandlerFunc{
+		"with-defer": func(w http.ResponseWriter, r *http.Request) {
+			release, err := server.acquireCS(r.Context(), time.Second)
+			if err != nil {
+				panic(err)
+			}
+			defer release()
+			c.Seats.Reserve(7, "cliente")
+		},
+		"before-defer": func(w http.ResponseWriter, r *http.Request) {
+			release, err := server.acquireCS(r.Context(), time.Second)
+			if err != nil {
+				panic(err)
+			}
+			c.Seats.Reserve(7, "cliente")
+			release()
+		},
+	}
+	for _, name := range []string{"with-defer", "before-defer"} {
+		req := httptest.NewRequest(http.MethodPost, "/reservar", nil)
+		req.Header.Set(httperr.RequestIDHeader, "panic-"+name)
+		rec := httptest.NewRecorder()
+		httperr.WithRequestID(server.recoverPanics(handlers[name])).ServeHTTP(rec, req)
+
+		var body httperr.Response
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: decoding the error response: %v", name, err)
+		}
+		if rec.Code != http.StatusInternalServerError || body.Error.Code != httperr.CodeInternal ||
+			body.Error.RequestID != "panic-"+name {
+			t.Fatalf("%s: expected a 500 %s for request panic-%s, got %d %+v",
+				name, httperr.CodeInternal, name, rec.Code, body.Error)
+		}
+		if state := node1.CSStatus().State; state != Released.String() {
+			t.Fatalf("%s: node1 left in %s after the panic", name, state)
+		}
+		// Ni el clúster ni la cola local de node1 deben quedar bloqueados
+		for _, id := range []string{"node2", "node1"} {
+			if err := c.Enter(id, time.Second); err != nil {
+				t.Fatalf("%s: %s cannot enter after the panic: %v", name, id, err)
+			}
+			c.Exit(id)
+		}
+	}
+}
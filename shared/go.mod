@@ -0,0 +1,3 @@
+module github.com/sincronizacion-distribuida/shared
+
+go 1.18
@@ -0,0 +1,111 @@
+// Package httperr contiene el sobre de error y el request ID que comparten
+// los servidores HTTP del proyecto.
+//
+// Todas las respuestas de error tienen la forma
+// {"error": {"code", "message", "request_id"}}. Aquí están los códigos que
+// usan todos los servidores; los de cada dominio (asientos, bloqueos, lotes)
+// se definen en cada servidor.
+package httperr
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// RequestIDHeader identifica cada petición en los errores y en los logs
+const RequestIDHeader = "X-Request-ID"
+
+// Códigos de error comunes a todos los servidores
+const (
+	CodeInvalidJSON      = "INVALID_JSON"
+	CodeEmptyBody        = "EMPTY_BODY"
+	CodeInvalidFieldType = "INVALID_FIELD_TYPE"
+	CodeInvalidRequest   = "INVALID_REQUEST"
+	CodeNotFound         = "NOT_FOUND"
+	CodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	CodeAdminDisabled    = "ADMIN_DISABLED"
+	CodeUnauthorized     = "UNAUTHORIZED"
+	CodeInternal         = "INTERNAL_ERROR"
+)
+
+// Body es el contenido del sobre de error
+type Body struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Response es el cuerpo de cualquier respuesta de error:
+// {"error": {"code", "message", "request_id"}}
+type Response struct {
+	Error Body `json:"error"`
+}
+
+// Write escribe un error con el sobre común. El request_id es el que
+// WithRequestID dejó en la cabecera de la respuesta.
+func Write(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{Error: Body{
+		Code:      code,
+		Message:   message,
+		RequestID: w.Header().Get(RequestIDHeader),
+	}})
+}
+
+// WriteDecodeError responde 400 a un cuerpo JSON que no se pudo interpretar,
+// distinguiendo un cuerpo vacío (habitual en sondas y preflights) de un JSON
+// mal formado y de un campo con un tipo que no corresponde
+func WriteDecodeError(w http.ResponseWriter, err error) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		Write(w, http.StatusBadRequest, CodeEmptyBody, "Request body is empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		Write(w, http.StatusBadRequest, CodeInvalidJSON, "Malformed JSON: unexpected end of body")
+	case errors.As(err, &syntaxErr):
+		Write(w, http.StatusBadRequest, CodeInvalidJSON,
+			fmt.Sprintf("Malformed JSON at byte %d: %v", syntaxErr.Offset, syntaxErr))
+	case errors.As(err, &typeErr):
+		Write(w, http.StatusBadRequest, CodeInvalidFieldType,
+			fmt.Sprintf("Field %q must be %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value))
+	default:
+		Write(w, http.StatusBadRequest, CodeInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+	}
+}
+
+// WithRequestID asigna un ID a cada petición (el de X-Request-ID si el
+// cliente lo envía) y lo devuelve en la misma cabecera de la respuesta
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// NotFoundHandler y MethodNotAllowedHandler sustituyen las respuestas en
+// texto plano del router
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, http.StatusNotFound, CodeNotFound, "No route for "+r.URL.Path)
+}
+
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method "+r.Method+" not allowed on "+r.URL.Path)
+}
@@ -0,0 +1,31 @@
+// Package models contiene el modelo de asiento que comparten los tres
+// servidores de reservas.
+//
+// El formato de un asiento en la API y en la BD es parte del contrato con el
+// frontend y con los documentos ya guardados, así que aquí solo está lo que
+// los servidores serializan igual. AsientoEstado es lo común a los tres;
+// 01-problema guarda los asientos en memoria y lo completa con "servidor_id"
+// y "fecha_reserva". AsientoBase añade lo que 02-lock-centralizado y
+// 03-lock-distribuido guardan en MongoDB, y cada uno lo incrusta en su Asiento
+// con sus propios campos, cuyas etiquetas no coinciden (02 siempre devuelve
+// "precio" y 03 siempre "logical_ts").
+package models
+
+import "time"
+
+// AsientoEstado son los campos de un asiento que serializan igual los tres
+// servidores: qué asiento es y quién lo ocupa
+type AsientoEstado struct {
+	Numero     int    `bson:"numero" json:"numero"`
+	Disponible bool   `bson:"disponible" json:"disponible"`
+	Cliente    string `bson:"cliente,omitempty" json:"cliente,omitempty"`
+}
+
+// AsientoBase son los campos comunes de un asiento guardado en MongoDB. Al
+// incrustarlo hay que marcarlo con `bson:",inline"` para que los campos
+// queden en el nivel superior del documento, como en JSON.
+type AsientoBase struct {
+	AsientoEstado `bson:",inline"`
+	ServerID      string    `bson:"server_id" json:"server_id"`
+	UpdatedAt     time.Time `bson:"updated_at" json:"updated_at"`
+}